@@ -1,16 +1,27 @@
 package main
 
 import (
+	"context"
 	"face-recognition/internal/api/handlers"
 	"face-recognition/internal/api/middleware"
 	"face-recognition/internal/api/websocket"
 	"face-recognition/internal/config"
+	"face-recognition/internal/models"
 	"face-recognition/internal/repository"
 	"face-recognition/internal/service/cache"
+	"face-recognition/internal/service/downloader"
+	"face-recognition/internal/service/queue"
 	"face-recognition/internal/service/storage"
+	"face-recognition/internal/service/webhook"
+	"face-recognition/internal/tracing"
+	"face-recognition/migrations"
+	"face-recognition/pkg/embedding"
 	"face-recognition/pkg/python_client"
 	"fmt"
 	"log"
+	"log/slog"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jmoiron/sqlx"
@@ -25,44 +36,89 @@ func main() {
 	cfg := config.Load()
 	log.Println("✅ Конфигурация загружена")
 
+	// Трассировка (no-op без OTEL_EXPORTER_OTLP_ENDPOINT)
+	shutdownTracing := initTracing(&cfg.Tracing)
+	defer shutdownTracing(context.Background())
+
 	// Инициализируем базу данных
-	db, err := initDatabase(cfg.Database.GetDSN())
+	db, err := initDatabase(&cfg.Database)
 	if err != nil {
 		log.Fatalf("❌ Ошибка подключения к БД: %v\n", err)
 	}
 	defer db.Close()
 	log.Println("✅ База данных подключена")
 
-	// Инициализируем Redis кэш
-	var cacheService *cache.Service
-	cacheService, err = cache.NewService(cfg.Redis.Addr, cfg.Redis.Password, cfg.Redis.DB)
-	if err != nil {
-		log.Printf("⚠️  Redis недоступен (работаем без кэша): %v\n", err)
-		cacheService = nil
-	} else {
-		defer cacheService.Close()
-		log.Println("✅ Redis кэш подключен")
+	// Миграции схемы (RUN_MIGRATIONS=true)
+	if cfg.Database.RunMigrations {
+		runMigrations(db)
+	}
+
+	// Read-only реплики (если заданы DB_READ_HOSTS)
+	replicas := initReadReplicas(&cfg.Database)
+	for _, replica := range replicas {
+		defer replica.Close()
 	}
 
+	// Инициализируем кэш: Redis, а при его недоступности - in-memory LRU
+	cacheService := cache.NewService(initCacheBackend(cfg))
+	defer cacheService.Close()
+
 	// Инициализируем репозиторий
-	repo := repository.NewRepository(db)
+	repo := repository.NewRepository(db, replicas...)
 
 	// Инициализируем storage service
-	storageService, err := storage.NewService(cfg.Storage.UploadsDir, cfg.Storage.ResultsDir)
+	backend, err := initStorageBackend(&cfg.Storage)
 	if err != nil {
 		log.Fatalf("❌ Ошибка инициализации storage: %v\n", err)
 	}
-	log.Println("✅ Storage сервис инициализирован")
+	storageService, err := storage.NewService(backend, cfg.Storage.ResultsDir)
+	if err != nil {
+		log.Fatalf("❌ Ошибка инициализации storage: %v\n", err)
+	}
+	if cfg.Storage.UploadConcurrency < 1 {
+		log.Printf("⚠️  UPLOAD_CONCURRENCY=%d меньше 1, используем %d\n", cfg.Storage.UploadConcurrency, config.DefaultUploadConcurrency)
+		cfg.Storage.UploadConcurrency = config.DefaultUploadConcurrency
+	}
+	storageService.SetUploadConcurrency(cfg.Storage.UploadConcurrency)
+	if cfg.Storage.MaxImagePixels < 0 {
+		log.Printf("⚠️  MAX_IMAGE_MEGAPIXELS не может быть отрицательным, используем %d\n", config.DefaultMaxImageMegapixels)
+		cfg.Storage.MaxImagePixels = config.DefaultMaxImageMegapixels * 1e6
+	}
+	storageService.SetMaxPixels(cfg.Storage.MaxImagePixels)
+	if !validResultsURL(cfg.Storage.ResultsURL) {
+		log.Printf("⚠️  RESULTS_URL=%q должен быть путем вида /results, используем %s\n", cfg.Storage.ResultsURL, config.DefaultResultsURL)
+		cfg.Storage.ResultsURL = config.DefaultResultsURL
+	}
+	storageService.SetResultsURL(cfg.Storage.ResultsURL)
+	if err := storageService.SetOutputFormat(cfg.Storage.OutputFormat); err != nil {
+		log.Printf("⚠️  IMAGE_OUTPUT_FORMAT=%s: %v, используем jpeg\n", cfg.Storage.OutputFormat, err)
+	}
+	if err := storageService.SetFileNaming(cfg.Storage.FileNaming); err != nil {
+		log.Printf("⚠️  STORAGE_FILE_NAMING=%s: %v, используем original\n", cfg.Storage.FileNaming, err)
+	}
+	log.Printf("✅ Storage сервис инициализирован (бэкенд: %s)\n", cfg.Storage.Backend)
+	if cfg.Storage.ConvertHEIF && !storage.HEIFSupported {
+		log.Println("⚠️  CONVERT_HEIF включен, но сервер собран без cgo - HEIC файлы будут помечены ошибкой")
+	}
+	initVideo(storageService, &cfg.Video)
 
 	// Инициализируем Python client
-	pythonClient := python_client.NewClient(cfg.Python.BaseURL)
+	// Файлы для Python читаются через storage, чтобы работали и диск, и S3
+	// Запросы распределяются между всеми серверами из PYTHON_BASE_URLS
+	validatePythonConfig(&cfg.Python)
+	pythonClient := python_client.NewClient(cfg.Python.BaseURLs...)
+	pythonClient.SetFileOpener(storageService.Open)
+	pythonClient.SetTimeouts(cfg.Python.RequestTimeout, cfg.Python.ProcessTimeout)
 
-	// Проверяем доступность Python сервера
+	// Проверяем доступность Python серверов
 	if err := pythonClient.HealthCheck(); err != nil {
 		log.Printf("⚠️  Предупреждение: Python сервер недоступен: %v\n", err)
 		log.Println("💡 Запусти: cd python && python process.py")
 	} else {
-		log.Println("✅ Python сервер доступен")
+		log.Printf("✅ Python сервер доступен (серверов: %d)\n", len(cfg.Python.BaseURLs))
+	}
+	if cfg.Python.HealthInterval > 0 {
+		go pythonClient.RunHealthChecks(context.Background(), cfg.Python.HealthInterval)
 	}
 
 	// Инициализируем WebSocket manager
@@ -71,7 +127,91 @@ func main() {
 	log.Println("✅ WebSocket manager запущен")
 
 	// Инициализируем handlers (без face detector - всё делает Python)
-	handler := handlers.NewHandler(repo, storageService, pythonClient, cacheService, wsManager)
+	notifier := webhook.NewNotifier(cfg.Webhooks)
+	validateDetectionConfig(&cfg.Detection)
+	validateBodyLimit(cfg)
+	validatePersonsConfig(&cfg.Persons)
+	validateImportConfig(&cfg.Import)
+
+	// Очередь ограничивает число одновременных тяжелых вызовов Python
+	if cfg.Queue.Timeout <= 0 {
+		log.Printf("⚠️  PROCESSING_TIMEOUT=%v должен быть больше 0, используем %v\n", cfg.Queue.Timeout, config.DefaultProcessingTimeout)
+		cfg.Queue.Timeout = config.DefaultProcessingTimeout
+	}
+	processingQueue := queue.New(cfg.Queue.Workers, cfg.Queue.Size)
+	log.Printf("✅ Очередь обработки: %d воркеров, до %d задач в ожидании\n", processingQueue.Workers(), cfg.Queue.Size)
+
+	handler := handlers.NewHandler(repo, storageService, pythonClient, initComparer(&cfg.Compare, pythonClient), cacheService, wsManager, notifier, downloader.New(cfg.URLUpload), processingQueue, cfg)
+
+	// Очередь хранится в памяти: задачи, прерванные остановкой сервера, возвращаем в нее
+	if requeued, failed, err := handler.RequeueStuckTasks(); err != nil {
+		log.Printf("⚠️  Не удалось восстановить прерванные задачи: %v\n", err)
+	} else if requeued+failed > 0 {
+		log.Printf("🔁 Прерванные задачи: %d возвращены в очередь, %d переведены в failed\n", requeued, failed)
+	}
+
+	// Файлы, оставшиеся после удаления записей (сбой между удалением из БД и с диска)
+	if cfg.Storage.OrphanCleanupInterval > 0 {
+		if cfg.Storage.OrphanGracePeriod <= 0 {
+			log.Printf("⚠️  ORPHAN_GRACE_PERIOD=%v должен быть больше 0, используем %v\n", cfg.Storage.OrphanGracePeriod, config.DefaultOrphanGracePeriod)
+			cfg.Storage.OrphanGracePeriod = config.DefaultOrphanGracePeriod
+		}
+		go handler.RunOrphanCleanup(context.Background(), cfg.Storage.OrphanCleanupInterval)
+		log.Printf("✅ Очистка файлов-сирот: каждые %v (старше %v)\n", cfg.Storage.OrphanCleanupInterval, cfg.Storage.OrphanGracePeriod)
+	}
+
+	// Перестройка кэша прогревает не больше CACHE_WARM_PERSONS людей и CACHE_WARM_TASKS задач
+	if cfg.Cache.WarmPersons < 0 {
+		log.Printf("⚠️  CACHE_WARM_PERSONS=%d не может быть отрицательным, используем %d\n",
+			cfg.Cache.WarmPersons, config.DefaultCacheWarmPersons)
+		cfg.Cache.WarmPersons = config.DefaultCacheWarmPersons
+	}
+	if cfg.Cache.WarmTasks < 0 {
+		log.Printf("⚠️  CACHE_WARM_TASKS=%d не может быть отрицательным, используем %d\n",
+			cfg.Cache.WarmTasks, config.DefaultCacheWarmTasks)
+		cfg.Cache.WarmTasks = config.DefaultCacheWarmTasks
+	}
+
+	// Статистика пересчитывается в фоне: запросы не ждут агрегации в БД
+	if cfg.Cache.StatsRefreshInterval > 0 {
+		go handler.RunStatsRefresh(context.Background(), cfg.Cache.StatsRefreshInterval)
+		log.Printf("✅ Фоновый пересчет статистики: каждые %v\n", cfg.Cache.StatsRefreshInterval)
+	}
+
+	// Счетчики stats_summary ведут триггеры; сверка исправляет расхождения
+	if cfg.Cache.StatsReconcileInterval > 0 {
+		go handler.RunStatsReconcile(context.Background(), cfg.Cache.StatsReconcileInterval)
+		log.Printf("✅ Сверка счетчиков статистики: каждые %v\n", cfg.Cache.StatsReconcileInterval)
+	}
+
+	// Статистика пересчитывается после изменений данных не чаще раза в WS_STATS_DEBOUNCE_MS
+	if cfg.WebSocket.StatsDebounce < 0 {
+		log.Printf("⚠️  WS_STATS_DEBOUNCE_MS=%d не может быть отрицательным, используем %d\n",
+			cfg.WebSocket.StatsDebounce.Milliseconds(), config.DefaultStatsDebounce.Milliseconds())
+		cfg.WebSocket.StatsDebounce = config.DefaultStatsDebounce
+	}
+	wsManager.SetStatsSource(handler.Stats, cfg.WebSocket.StatsDebounce)
+
+	// Частый прогресс задачи объединяется: не больше сообщения за WS_PROGRESS_INTERVAL_MS
+	if cfg.WebSocket.ProgressInterval < 0 {
+		log.Printf("⚠️  WS_PROGRESS_INTERVAL_MS=%d не может быть отрицательным, используем %d\n",
+			cfg.WebSocket.ProgressInterval.Milliseconds(), config.DefaultProgressInterval.Milliseconds())
+		cfg.WebSocket.ProgressInterval = config.DefaultProgressInterval
+	}
+	wsManager.SetProgressInterval(cfg.WebSocket.ProgressInterval)
+
+	// Соединения сверх WS_MAX_CONNECTIONS_PER_IP с одного IP закрываются с кодом 1008
+	if cfg.WebSocket.MaxConnectionsPerIP < 0 {
+		log.Printf("⚠️  WS_MAX_CONNECTIONS_PER_IP=%d не может быть отрицательным, используем %d\n",
+			cfg.WebSocket.MaxConnectionsPerIP, config.DefaultWSMaxConnectionsPerIP)
+		cfg.WebSocket.MaxConnectionsPerIP = config.DefaultWSMaxConnectionsPerIP
+	}
+	wsManager.SetMaxConnectionsPerIP(cfg.WebSocket.MaxConnectionsPerIP)
+
+	// Периодически сообщаем клиентам о нагрузке сервера
+	if cfg.WebSocket.StatusInterval > 0 {
+		go wsManager.RunSystemStatus(context.Background(), cfg.WebSocket.StatusInterval, handler.SystemStatus)
+	}
 
 	// Создаем роутер
 	router := setupRouter(handler, wsManager, cfg)
@@ -79,7 +219,9 @@ func main() {
 	// Запускаем сервер
 	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
 	log.Println("🎉 Сервер успешно запущен!")
-	log.Printf("🌐 Веб-интерфейс: http://localhost:%s\n", cfg.Server.Port)
+	if cfg.Static.Enabled {
+		log.Printf("🌐 Веб-интерфейс: http://localhost:%s\n", cfg.Server.Port)
+	}
 	log.Printf("📡 API: http://localhost:%s/api\n", cfg.Server.Port)
 	log.Printf("🔌 WebSocket: ws://localhost:%s/ws\n", cfg.Server.Port)
 	log.Println("━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━━")
@@ -89,8 +231,70 @@ func main() {
 	}
 }
 
+// initTracing настраивает OpenTelemetry; ошибка экспортера не мешает запуску сервера
+func initTracing(cfg *config.TracingConfig) func(context.Context) error {
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		log.Printf("⚠️  TRACING_SAMPLE_RATIO=%g вне [0, 1], используем 1\n", cfg.SampleRatio)
+		cfg.SampleRatio = 1
+	}
+
+	shutdown, err := tracing.Init(*cfg)
+	if err != nil {
+		log.Printf("⚠️  Трассировка отключена: %v\n", err)
+		return func(context.Context) error { return nil }
+	}
+
+	if cfg.Endpoint == "" {
+		log.Println("✅ Трассировка выключена (OTEL_EXPORTER_OTLP_ENDPOINT не задан)")
+	} else {
+		log.Printf("✅ Трассировка: OTLP %s (sample=%g)\n", cfg.Endpoint, cfg.SampleRatio)
+	}
+	return shutdown
+}
+
 // initDatabase инициализирует подключение к базе данных
-func initDatabase(dsn string) (*sqlx.DB, error) {
+func initDatabase(cfg *config.DatabaseConfig) (*sqlx.DB, error) {
+	for _, fix := range cfg.NormalizePool() {
+		log.Printf("⚠️  Пул БД: %s\n", fix)
+	}
+	log.Printf("✅ Пул БД: max_open=%d, max_idle=%d, max_lifetime=%v\n",
+		cfg.MaxOpenConns, cfg.MaxIdleConns, cfg.ConnMaxLifetime)
+
+	return openDatabase(cfg.GetDSN(), cfg)
+}
+
+// runMigrations применяет встроенные миграции; ошибка миграции останавливает запуск
+func runMigrations(db *sqlx.DB) {
+	applied, err := migrations.Apply(context.Background(), db.DB)
+	for _, name := range applied {
+		log.Printf("✅ Миграция применена: %s\n", name)
+	}
+	if err != nil {
+		log.Fatalf("❌ Ошибка миграции БД: %v\n", err)
+	}
+	if len(applied) == 0 {
+		log.Println("✅ Схема БД актуальна, миграций нет")
+	}
+}
+
+// initReadReplicas подключает реплики из DB_READ_HOSTS
+// Недоступная реплика пропускается - ее чтения уйдут на остальные или на primary
+func initReadReplicas(cfg *config.DatabaseConfig) []*sqlx.DB {
+	var replicas []*sqlx.DB
+	for i, dsn := range cfg.GetReadDSNs() {
+		replica, err := openDatabase(dsn, cfg)
+		if err != nil {
+			log.Printf("⚠️  Реплика %s недоступна (пропускаем): %v\n", cfg.ReadHosts[i], err)
+			continue
+		}
+		replicas = append(replicas, replica)
+		log.Printf("✅ Реплика %s подключена\n", cfg.ReadHosts[i])
+	}
+	return replicas
+}
+
+// openDatabase подключается к PostgreSQL и настраивает пул соединений
+func openDatabase(dsn string, cfg *config.DatabaseConfig) (*sqlx.DB, error) {
 	db, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
 		return nil, err
@@ -98,34 +302,308 @@ func initDatabase(dsn string) (*sqlx.DB, error) {
 
 	// Проверяем подключение
 	if err := db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	// Настраиваем connection pool
-	db.SetMaxOpenConns(25)
-	db.SetMaxIdleConns(5)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 
 	return db, nil
 }
 
+// initCacheBackend подключает Redis, а при ошибке возвращает in-memory LRU
+// Кэш работает всегда, поэтому handlers не зависят от доступности Redis
+func initCacheBackend(cfg *config.Config) cache.Backend {
+	validateRedisConfig(&cfg.Redis)
+	redisBackend, err := cache.NewRedisBackend(cfg.Redis)
+	if err != nil {
+		log.Printf("⚠️  Redis недоступен (используем in-memory кэш на %d ключей): %v\n", cfg.Cache.LRUSize, err)
+		return cache.NewLRUBackend(cfg.Cache.LRUSize)
+	}
+
+	log.Println("✅ Redis кэш подключен")
+	return redisBackend
+}
+
+// validateRedisConfig заменяет неположительные таймауты Redis значениями по умолчанию:
+// без таймаута медленный Redis блокировал бы запросы
+func validateRedisConfig(cfg *config.RedisConfig) {
+	if cfg.PoolSize < 0 {
+		log.Printf("⚠️  REDIS_POOL_SIZE=%d меньше 0, используем размер по умолчанию\n", cfg.PoolSize)
+		cfg.PoolSize = 0
+	}
+
+	timeouts := []struct {
+		name     string
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{"REDIS_DIAL_TIMEOUT", &cfg.DialTimeout, config.DefaultRedisDialTimeout},
+		{"REDIS_READ_TIMEOUT", &cfg.ReadTimeout, config.DefaultRedisReadTimeout},
+		{"REDIS_WRITE_TIMEOUT", &cfg.WriteTimeout, config.DefaultRedisWriteTimeout},
+	}
+	for _, timeout := range timeouts {
+		if *timeout.value <= 0 {
+			log.Printf("⚠️  %s=%v должен быть больше 0, используем %v\n", timeout.name, *timeout.value, timeout.fallback)
+			*timeout.value = timeout.fallback
+		}
+	}
+}
+
+// validatePythonConfig заменяет неположительные таймауты Python значениями по умолчанию
+func validatePythonConfig(cfg *config.PythonConfig) {
+	timeouts := []struct {
+		name     string
+		value    *time.Duration
+		fallback time.Duration
+	}{
+		{"PYTHON_REQUEST_TIMEOUT", &cfg.RequestTimeout, config.DefaultPythonRequestTimeout},
+		{"PYTHON_PROCESS_TIMEOUT", &cfg.ProcessTimeout, config.DefaultPythonProcessTimeout},
+	}
+	for _, timeout := range timeouts {
+		if *timeout.value <= 0 {
+			log.Printf("⚠️  %s=%v должен быть больше 0, используем %v\n", timeout.name, *timeout.value, timeout.fallback)
+			*timeout.value = timeout.fallback
+		}
+	}
+}
+
+// validateDetectionConfig сбрасывает некорректный DETECTION_MIN_CONFIDENCE на значение по умолчанию
+func validateDetectionConfig(cfg *config.DetectionConfig) {
+	if cfg.MinConfidence < 0 || cfg.MinConfidence > 1 {
+		log.Printf("⚠️  DETECTION_MIN_CONFIDENCE=%g вне [0, 1], используем %g\n", cfg.MinConfidence, models.DefaultMinConfidence)
+		cfg.MinConfidence = models.DefaultMinConfidence
+	}
+	if cfg.MaxInconsistentRatio < 0 || cfg.MaxInconsistentRatio > 1 {
+		log.Printf("⚠️  DETECTION_MAX_INCONSISTENT=%g вне [0, 1], используем %g\n", cfg.MaxInconsistentRatio, models.DefaultMaxInconsistentRatio)
+		cfg.MaxInconsistentRatio = models.DefaultMaxInconsistentRatio
+	}
+	if cfg.MaxOverlap < 0 || cfg.MaxOverlap > 1 {
+		log.Printf("⚠️  DETECTION_MAX_OVERLAP=%g вне [0, 1], используем %g\n", cfg.MaxOverlap, models.DefaultMaxFaceOverlap)
+		cfg.MaxOverlap = models.DefaultMaxFaceOverlap
+	}
+}
+
+// validateBodyLimit следит, чтобы MAX_REQUEST_BODY_MB пропускал хотя бы одно изображение
+// максимального размера (URL_UPLOAD_MAX_SIZE_MB) вместе с полями multipart формы,
+// а MULTIPART_MEMORY_MB и MAX_FILES_PER_UPLOAD не были отрицательными
+func validateBodyLimit(cfg *config.Config) {
+	minLimit := cfg.URLUpload.MaxFileSize + 1<<20
+	if cfg.Server.MaxBodySize < minLimit {
+		log.Printf("⚠️  MAX_REQUEST_BODY_MB=%d меньше размера одного изображения, используем %d\n", cfg.Server.MaxBodySize>>20, minLimit>>20)
+		cfg.Server.MaxBodySize = minLimit
+	}
+
+	if cfg.Server.MultipartMemory < 0 {
+		log.Printf("⚠️  MULTIPART_MEMORY_MB=%d меньше 0, используем %d\n", cfg.Server.MultipartMemory>>20, config.DefaultMultipartMemoryMB)
+		cfg.Server.MultipartMemory = config.DefaultMultipartMemoryMB << 20
+	}
+
+	if cfg.Server.MaxFilesPerUpload < 0 {
+		log.Printf("⚠️  MAX_FILES_PER_UPLOAD=%d меньше 0, используем %d\n", cfg.Server.MaxFilesPerUpload, config.DefaultMaxFilesPerUpload)
+		cfg.Server.MaxFilesPerUpload = config.DefaultMaxFilesPerUpload
+	}
+}
+
+// validatePersonsConfig следит, чтобы имя допустимой длины помещалось в persons.name,
+// а лимит лиц человека не был отрицательным
+func validatePersonsConfig(cfg *config.PersonsConfig) {
+	if cfg.MaxNameLength < 1 || cfg.MaxNameLength > models.MaxPersonNameColumnLength {
+		log.Printf("⚠️  PERSON_NAME_MAX_LENGTH=%d вне [1, %d], используем %d\n",
+			cfg.MaxNameLength, models.MaxPersonNameColumnLength, models.DefaultMaxPersonNameLength)
+		cfg.MaxNameLength = models.DefaultMaxPersonNameLength
+	}
+
+	if cfg.MaxFaces < 0 {
+		log.Printf("⚠️  PERSON_MAX_FACES=%d меньше 0, лимит лиц отключен\n", cfg.MaxFaces)
+		cfg.MaxFaces = 0
+	}
+}
+
+// validateImportConfig заменяет неположительные ограничения импорта значениями по умолчанию
+func validateImportConfig(cfg *config.ImportConfig) {
+	if cfg.MaxSize <= 0 {
+		log.Printf("⚠️  IMPORT_MAX_SIZE_MB должен быть больше 0, используем %d\n", config.DefaultImportMaxSizeMB)
+		cfg.MaxSize = config.DefaultImportMaxSizeMB << 20
+	}
+
+	if cfg.MaxEntries <= 0 {
+		log.Printf("⚠️  IMPORT_MAX_ENTRIES=%d должен быть больше 0, используем %d\n", cfg.MaxEntries, config.DefaultImportMaxEntries)
+		cfg.MaxEntries = config.DefaultImportMaxEntries
+	}
+
+	if cfg.MaxFileSize <= 0 {
+		log.Printf("⚠️  IMPORT_MAX_FILE_SIZE_MB должен быть больше 0, используем %d\n", config.DefaultImportMaxFileSizeMB)
+		cfg.MaxFileSize = config.DefaultImportMaxFileSizeMB << 20
+	}
+
+	if cfg.MaxUncompressedSize <= 0 {
+		log.Printf("⚠️  IMPORT_MAX_UNCOMPRESSED_MB должен быть больше 0, используем %d\n", config.DefaultImportMaxUncompressedMB)
+		cfg.MaxUncompressedSize = config.DefaultImportMaxUncompressedMB << 20
+	}
+}
+
+// initVideo включает извлечение кадров видео, если найдены ffmpeg и ffprobe
+// Без них видео в загрузках помечаются ошибкой, остальные файлы обрабатываются как обычно
+func initVideo(storageService *storage.Service, cfg *config.VideoConfig) {
+	if !cfg.Enabled {
+		return
+	}
+
+	extractor := &storage.FFmpegExtractor{FFmpeg: cfg.FFmpegPath, FFprobe: cfg.FFprobePath}
+	if err := extractor.Available(); err != nil {
+		log.Printf("⚠️  Обработка видео отключена: %v\n", err)
+		cfg.Enabled = false
+		return
+	}
+
+	if cfg.FPS <= 0 {
+		log.Printf("⚠️  VIDEO_FPS=%g должен быть больше 0, используем %g\n", cfg.FPS, config.DefaultVideoFPS)
+		cfg.FPS = config.DefaultVideoFPS
+	}
+	if cfg.MaxDuration <= 0 {
+		log.Printf("⚠️  VIDEO_MAX_DURATION=%v должен быть больше 0, используем %v\n", cfg.MaxDuration, config.DefaultVideoMaxDuration)
+		cfg.MaxDuration = config.DefaultVideoMaxDuration
+	}
+	if cfg.MaxSize <= 0 {
+		log.Printf("⚠️  VIDEO_MAX_SIZE_MB должен быть больше 0, используем %d\n", config.DefaultVideoMaxSizeMB)
+		cfg.MaxSize = config.DefaultVideoMaxSizeMB << 20
+	}
+
+	storageService.SetVideo(extractor, storage.VideoOptions{
+		FPS:         cfg.FPS,
+		MaxDuration: cfg.MaxDuration,
+		MaxSize:     cfg.MaxSize,
+	})
+	log.Printf("✅ Обработка видео: %g кадр/с, до %v и %d МБ\n", cfg.FPS, cfg.MaxDuration, cfg.MaxSize>>20)
+}
+
+// initComparer выбирает, где считать сходство embedding
+// По умолчанию - локально в Go; Python /compare оставлен для сверки результатов
+func initComparer(cfg *config.CompareConfig, pythonClient *python_client.Client) embedding.Comparer {
+	// Dedupe всегда считается локально - порог проверяем независимо от бэкенда
+	if cfg.DedupeThreshold <= 0 || cfg.DedupeThreshold > 1 {
+		log.Printf("⚠️  DEDUPE_THRESHOLD=%g вне (0, 1], используем %g\n", cfg.DedupeThreshold, models.DefaultDedupeThreshold)
+		cfg.DedupeThreshold = models.DefaultDedupeThreshold
+	}
+
+	// Размерность проверяется и у ответов /process, и у сравниваемых embedding
+	if cfg.EmbeddingDim <= 0 {
+		log.Printf("⚠️  EMBEDDING_DIM=%d должен быть больше 0, используем %d\n", cfg.EmbeddingDim, embedding.DefaultDimension)
+		cfg.EmbeddingDim = embedding.DefaultDimension
+	}
+	pythonClient.SetEmbeddingDim(cfg.EmbeddingDim)
+
+	if cfg.Backend == config.CompareBackendPython {
+		log.Printf("✅ Сравнение embedding: Python /compare (размерность %d)\n", cfg.EmbeddingDim)
+		return embedding.WithDimension(embedding.ComparerFunc(pythonClient.CompareEmbeddings), cfg.EmbeddingDim)
+	}
+
+	if cfg.Backend != config.CompareBackendGo {
+		log.Printf("⚠️  Неизвестный COMPARE_BACKEND=%s, используем %s\n", cfg.Backend, config.CompareBackendGo)
+	}
+
+	threshold := cfg.MatchThreshold
+	if threshold < -1 || threshold > 1 {
+		log.Printf("⚠️  COMPARE_MATCH_THRESHOLD=%g вне [-1, 1], используем %g\n", threshold, embedding.DefaultMatchThreshold)
+		threshold = embedding.DefaultMatchThreshold
+	}
+
+	log.Printf("✅ Сравнение embedding: Go (порог %g, размерность %d)\n", threshold, cfg.EmbeddingDim)
+	return embedding.WithDimension(embedding.NewLocal(threshold), cfg.EmbeddingDim)
+}
+
+// initStorageBackend создает бэкенд хранилища по конфигурации
+func initStorageBackend(cfg *config.StorageConfig) (storage.StorageBackend, error) {
+	switch cfg.Backend {
+	case config.StorageBackendLocal:
+		return storage.NewLocalBackend(cfg.UploadsDir, "/uploads")
+	case config.StorageBackendS3:
+		return storage.NewS3Backend(storage.S3Options{
+			Endpoint:  cfg.S3.Endpoint,
+			AccessKey: cfg.S3.AccessKey,
+			SecretKey: cfg.S3.SecretKey,
+			Bucket:    cfg.S3.Bucket,
+			Region:    cfg.S3.Region,
+			UseSSL:    cfg.S3.UseSSL,
+			PublicURL: cfg.S3.PublicURL,
+		})
+	default:
+		return nil, fmt.Errorf("неизвестный STORAGE_BACKEND: %s", cfg.Backend)
+	}
+}
+
+// validResultsURL проверяет RESULTS_URL: путь от корня, не пересекающийся с
+// маршрутами API, загрузок и веб-интерфейса
+func validResultsURL(url string) bool {
+	url = strings.TrimRight(url, "/")
+	if !strings.HasPrefix(url, "/") || url == "" {
+		return false
+	}
+	for _, reserved := range []string{"/api", "/uploads", "/static", "/ws", "/health"} {
+		if url == reserved || strings.HasPrefix(url, reserved+"/") {
+			return false
+		}
+	}
+	return true
+}
+
+// registerStatic регистрирует раздачу веб-интерфейса: /static и главную страницу /
+// STATIC_ENABLED=false - режим только API, маршруты не регистрируются
+// (/uploads сюда не относится - это файлы задач, а не интерфейс)
+func registerStatic(router *gin.Engine, cfg *config.StaticConfig) {
+	if !cfg.Enabled {
+		log.Println("✅ Веб-интерфейс выключен (STATIC_ENABLED=false), работает только API")
+		return
+	}
+
+	router.Static("/static", cfg.Dir)
+	router.StaticFile("/", cfg.Index)
+}
+
 // setupRouter настраивает роутер с middleware и endpoints
 func setupRouter(handler *handlers.Handler, wsManager *websocket.Manager, cfg *config.Config) *gin.Engine {
 	// Режим production для меньшего логирования
 	// gin.SetMode(gin.ReleaseMode)
 
-	router := gin.Default()
+	// gin.New вместо gin.Default: запросы логирует middleware.Logger, паники - middleware.Recovery
+	router := gin.New()
+
+	// Файлы загрузки сверх MULTIPART_MEMORY_MB gin пишет во временные файлы
+	router.MaxMultipartMemory = cfg.Server.MultipartMemory
 
 	// Middleware
+	router.Use(middleware.Tracing())
+	router.Use(middleware.Logger(slog.Default()))
+	router.Use(middleware.Actor())
 	router.Use(middleware.CORS())
 	router.Use(middleware.Recovery())
+	router.Use(middleware.MaxBodySize(cfg.Server.MaxBodySize))
 
 	// Статические файлы
-	router.Static("/static", "./web/static")
-	router.Static("/uploads", cfg.Storage.UploadsDir)
-	router.StaticFile("/", "./web/static/index.html")
+	registerStatic(router, &cfg.Static)
+	if cfg.Storage.Backend == config.StorageBackendLocal {
+		router.Static("/uploads", cfg.Storage.UploadsDir)
+	} else {
+		// Файлы лежат в S3 - перенаправляем на адрес объекта
+		router.GET("/uploads/*key", handler.HandleUploadedFile)
+	}
+	// Аннотированные фото Python пишет на диск в RESULTS_DIR при любом STORAGE_BACKEND
+	if cfg.Storage.ResultsURL != "" {
+		router.Static(cfg.Storage.ResultsURL, cfg.Storage.ResultsDir)
+	}
 
 	// WebSocket endpoint
 	wsHandler := websocket.NewHandler(wsManager)
+	wsHandler.SetSnapshot(handler.TaskProgressSnapshot)
+	wsHandler.SetLimits(websocket.Limits{
+		ReadBufferSize:  cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize: cfg.WebSocket.WriteBufferSize,
+		MaxMessageSize:  cfg.WebSocket.MaxMessageSize,
+	})
 	router.GET("/ws", wsHandler.HandleWebSocket)
 
 	// API группа
@@ -133,19 +611,70 @@ func setupRouter(handler *handlers.Handler, wsManager *websocket.Manager, cfg *c
 	{
 		// Загрузка и обработка
 		api.POST("/upload", handler.HandleUpload)
+		api.POST("/import", handler.HandleImport)
+		api.POST("/upload/urls", handler.HandleUploadURLs)
+		api.GET("/tasks/recent", handler.HandleRecentTasks)
 		api.GET("/task/:id", handler.HandleTaskStatus)
+		api.GET("/task/:id/files", handler.HandleTaskFiles)
+		api.GET("/task/:id/images", handler.HandleTaskImages)
+		api.GET("/task/:id/persons", handler.HandleTaskPersons)
+		api.GET("/task/:id/metrics", handler.HandleTaskMetrics)
+		api.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+		api.POST("/task/:id/label", handler.HandleLabelTask)
+		api.DELETE("/task/:id", handler.HandleDeleteTask)
 
 		// Работа с людьми
 		api.GET("/persons", handler.HandleGetPersons)
+		api.POST("/persons/bulk-delete", handler.HandleBulkDeletePersons)
+		api.GET("/persons/export", handler.HandleExportPersons)
+		api.GET("/persons/duplicates", handler.HandleFindDuplicatePersons)
 		api.GET("/persons/:id", handler.HandleGetPerson)
 		api.PUT("/persons/:id", handler.HandleUpdatePerson)
 		api.DELETE("/persons/:id", handler.HandleDeletePerson)
+		api.POST("/persons/:id/restore", handler.HandleRestorePerson)
+		api.POST("/persons/:id/tags", handler.HandleAddPersonTags)
+		api.DELETE("/persons/:id/tags/:tag", handler.HandleRemovePersonTag)
+		api.GET("/persons/:id/export", handler.HandleExportPerson)
+		api.GET("/persons/:id/audit", handler.HandleGetPersonAudit)
+		api.GET("/persons/:id/faces/count", handler.HandleCountPersonFaces)
+		api.GET("/persons/:id/sprite", handler.HandleGetPersonSprite)
+		api.POST("/persons/:id/dedupe", handler.HandleDedupePerson)
+		api.POST("/persons/:id/reembed", handler.HandleReembedPerson)
+		api.PUT("/persons/:id/cover", handler.HandleUpdatePersonCover)
 
 		// Поиск
 		api.GET("/search", handler.HandleSearch)
+		api.GET("/search/face", handler.HandleSearchFace)
+
+		// Сравнение лиц
+		api.POST("/faces/compare", handler.HandleCompareFaces)
+
+		// Лица по уверенности детекции (проверка качества)
+		api.GET("/faces", handler.HandleListFaces)
+
+		// Одно лицо и вырезка лица из исходного изображения
+		api.GET("/faces/:id", handler.HandleGetFace)
+		api.GET("/faces/:id/crop", handler.HandleFaceCrop)
+
+		// Embedding лица - биометрические данные, только с ADMIN_TOKEN
+		api.GET("/faces/:id/embedding", middleware.AdminAuth(cfg.Server.AdminToken), handler.HandleGetFaceEmbedding)
+		api.GET("/persons/:id/embeddings", middleware.AdminAuth(cfg.Server.AdminToken), handler.HandlePersonEmbeddings)
+		api.GET("/export/embeddings", middleware.AdminAuth(cfg.Server.AdminToken), handler.HandleExportEmbeddings)
 
 		// Статистика
 		api.GET("/stats", handler.HandleGetStats)
+		api.GET("/stats/stream", wsHandler.HandleStatsStream)
+
+		// Очистка кэша при отладке (только с ADMIN_TOKEN)
+		admin := api.Group("/cache", middleware.AdminAuth(cfg.Server.AdminToken))
+		admin.DELETE("/person/:id", handler.HandleClearPersonCache)
+		admin.DELETE("/stats", handler.HandleClearStatsCache)
+		admin.DELETE("/all", handler.HandleClearAllCache)
+
+		// Перестройка кэша: очистка и прогрев из БД в фоне
+		rebuild := api.Group("/admin/cache", middleware.AdminAuth(cfg.Server.AdminToken))
+		rebuild.POST("/rebuild", handler.HandleRebuildCache)
+		rebuild.GET("/rebuild/:id", handler.HandleCacheRebuildStatus)
 	}
 
 	// Health check endpoint
@@ -154,6 +683,8 @@ func setupRouter(handler *handlers.Handler, wsManager *websocket.Manager, cfg *c
 			"status":  "ok",
 			"service": "face-recognition-api",
 			"version": "2.0.0",
+			// Размерность embedding, которую ждет сервер (EMBEDDING_DIM)
+			"embedding_dim": cfg.Compare.EmbeddingDim,
 		})
 	})
 
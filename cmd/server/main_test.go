@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"face-recognition/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func staticRouter(cfg config.StaticConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerStatic(router, &cfg)
+	return router
+}
+
+func TestRegisterStatic(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.html"), []byte("<h1>app</h1>"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.js"), []byte("console.log(1)"), 0o644))
+
+	router := staticRouter(config.StaticConfig{Enabled: true, Dir: dir, Index: filepath.Join(dir, "app.html")})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<h1>app</h1>", w.Body.String())
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestSetupRouterMultipartMemory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Server:  config.ServerConfig{MaxBodySize: 1 << 20, MultipartMemory: 4 << 20},
+		Storage: config.StorageConfig{Backend: config.StorageBackendLocal, UploadsDir: t.TempDir()},
+	}
+
+	router := setupRouter(nil, nil, cfg)
+	assert.Equal(t, int64(4<<20), router.MaxMultipartMemory)
+}
+
+func TestSetupRouterServesResults(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	results := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(results, "task-1"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(results, "task-1", "f1_boxed.jpg"), []byte("boxed"), 0o644))
+
+	cfg := &config.Config{
+		Server: config.ServerConfig{MaxBodySize: 1 << 20},
+		Storage: config.StorageConfig{
+			Backend:    config.StorageBackendLocal,
+			UploadsDir: t.TempDir(),
+			ResultsDir: results,
+			ResultsURL: "/annotated",
+		},
+	}
+
+	w := httptest.NewRecorder()
+	setupRouter(nil, nil, cfg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/annotated/task-1/f1_boxed.jpg", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "boxed", w.Body.String())
+}
+
+func TestValidResultsURL(t *testing.T) {
+	for _, url := range []string{"/results", "/results/", "/files/annotated"} {
+		assert.True(t, validResultsURL(url), url)
+	}
+	for _, url := range []string{"", "/", "results", "/uploads", "/api/results", "/static"} {
+		assert.False(t, validResultsURL(url), url)
+	}
+}
+
+func TestValidateBodyLimitMultipartMemory(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{MaxBodySize: 100 << 20, MultipartMemory: -1 << 20}}
+	validateBodyLimit(cfg)
+	assert.Equal(t, int64(config.DefaultMultipartMemoryMB<<20), cfg.Server.MultipartMemory)
+
+	// 0 допустим: все файлы формы сразу пишутся на диск
+	cfg.Server.MultipartMemory = 0
+	validateBodyLimit(cfg)
+	assert.Zero(t, cfg.Server.MultipartMemory)
+}
+
+func TestValidateBodyLimitMaxFiles(t *testing.T) {
+	cfg := &config.Config{Server: config.ServerConfig{MaxBodySize: 100 << 20, MaxFilesPerUpload: -1}}
+	validateBodyLimit(cfg)
+	assert.Equal(t, config.DefaultMaxFilesPerUpload, cfg.Server.MaxFilesPerUpload)
+
+	// 0 допустим: число файлов не ограничено
+	cfg.Server.MaxFilesPerUpload = 0
+	validateBodyLimit(cfg)
+	assert.Zero(t, cfg.Server.MaxFilesPerUpload)
+}
+
+func TestRegisterStaticDisabled(t *testing.T) {
+	router := staticRouter(config.StaticConfig{Enabled: false, Dir: t.TempDir(), Index: "index.html"})
+
+	assert.Empty(t, router.Routes())
+
+	for _, path := range []string{"/", "/static/app.js"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+	}
+}
+
+func TestHealthReportsEmbeddingDim(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Server:  config.ServerConfig{MaxBodySize: 1 << 20},
+		Storage: config.StorageConfig{Backend: config.StorageBackendLocal, UploadsDir: t.TempDir()},
+		Compare: config.CompareConfig{EmbeddingDim: 128},
+	}
+
+	w := httptest.NewRecorder()
+	setupRouter(nil, nil, cfg).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"status": "ok", "service": "face-recognition-api", "version": "2.0.0", "embedding_dim": 128}`, w.Body.String())
+}
@@ -1,19 +1,26 @@
+-- Расширение для нечеткого поиска по имени (similarity, <->)
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
 -- Таблица для хранения информации о людях
 CREATE TABLE IF NOT EXISTS persons (
                                        id SERIAL PRIMARY KEY,
                                        name VARCHAR(255) NOT NULL,
     created_at TIMESTAMP DEFAULT NOW(),
-    updated_at TIMESTAMP DEFAULT NOW()
+    updated_at TIMESTAMP DEFAULT NOW(),
+    deleted_at TIMESTAMP -- soft-delete: NULL - человек не удален
     );
 
 -- Таблица для хранения лиц (embeddings)
 CREATE TABLE IF NOT EXISTS faces (
                                      id SERIAL PRIMARY KEY,
                                      person_id INTEGER REFERENCES persons(id) ON DELETE CASCADE,
+    task_id VARCHAR(36) NOT NULL DEFAULT '',
 
     -- Пути к изображениям
     original_image VARCHAR(500) NOT NULL,
+    original_filename TEXT NOT NULL DEFAULT '', -- Имя файла от клиента ('' - совпадает с именем в original_image)
     annotated_image VARCHAR(500),
+    thumbnail_image VARCHAR(500) NOT NULL DEFAULT '',
 
     -- Координаты лица на оригинальном фото
     face_x INTEGER NOT NULL DEFAULT 0,
@@ -21,13 +28,28 @@ CREATE TABLE IF NOT EXISTS faces (
     face_width INTEGER NOT NULL DEFAULT 0,
     face_height INTEGER NOT NULL DEFAULT 0,
 
+    -- Размер оригинального фото (NULL - неизвестен)
+    image_width INTEGER,
+    image_height INTEGER,
+
     -- ML данные
     embedding BYTEA,
+    embedding_model VARCHAR(100) NOT NULL DEFAULT '', -- Пустая строка - модель неизвестна
     confidence FLOAT DEFAULT 0.0,
+    quality FLOAT, -- Резкость лица в [0, 1) (NULL - не посчитана)
 
+    captured_at TIMESTAMP, -- Время съемки из EXIF (NULL - неизвестно)
+    frame_time FLOAT, -- Время кадра от начала видео в секундах (NULL - лицо не из видео)
     detected_at TIMESTAMP DEFAULT NOW()
     );
 
+-- Лицо-обложка человека (после faces - ссылки между таблицами взаимные)
+-- NULL - берется лицо с наибольшей уверенностью; при удалении лица обложка сбрасывается
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS cover_face_id INTEGER REFERENCES faces(id) ON DELETE SET NULL;
+
+-- Центроид embedding лиц человека (JSON, как faces.embedding); NULL - нет лиц с embedding
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS centroid BYTEA;
+
 -- Таблица для истории задач обработки
 CREATE TABLE IF NOT EXISTS tasks (
                                      id VARCHAR(36) PRIMARY KEY,
@@ -35,15 +57,92 @@ CREATE TABLE IF NOT EXISTS tasks (
     total_images INTEGER DEFAULT 0,
     total_faces INTEGER DEFAULT 0,
     unique_persons INTEGER DEFAULT 0,
+    min_size INTEGER DEFAULT 30,
+    det_thresh FLOAT DEFAULT 0.5,
+    min_confidence FLOAT NOT NULL DEFAULT 0,
+    rejected_faces INTEGER NOT NULL DEFAULT 0,
+    overflow_faces INTEGER NOT NULL DEFAULT 0,
+    merged_faces INTEGER NOT NULL DEFAULT 0,
+    warnings TEXT[] NOT NULL DEFAULT '{}',
+    callback_url VARCHAR(2048) NOT NULL DEFAULT '',
+    content_hash VARCHAR(64) NOT NULL DEFAULT '',
+    progress INTEGER NOT NULL DEFAULT 0,
+    stage VARCHAR(100) NOT NULL DEFAULT '',
     error_message TEXT,
     created_at TIMESTAMP DEFAULT NOW(),
+    processing_started_at TIMESTAMP,
     completed_at TIMESTAMP
     );
 
+-- Результат обработки каждого загруженного файла задачи
+-- (для загрузки по URL в file_name - адрес, который не удалось скачать)
+CREATE TABLE IF NOT EXISTS task_files (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    file_name TEXT NOT NULL,
+    faces_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    original_filename TEXT NOT NULL DEFAULT '', -- Имя файла от клиента ('' - совпадает с file_name)
+    PRIMARY KEY (task_id, file_name)
+    );
+
+-- Исходные имена загруженных файлов, сохраненных под другим именем (STORAGE_FILE_NAMING)
+CREATE TABLE IF NOT EXISTS task_file_names (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    file_name TEXT NOT NULL,
+    original_filename TEXT NOT NULL,
+    PRIMARY KEY (task_id, file_name)
+    );
+
+-- Люди, в которых задача сохранила лица: созданные ею (created) и дополненные
+-- (человек создан раньше другой задачей). Человек может относиться к нескольким задачам
+CREATE TABLE IF NOT EXISTS task_persons (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    person_id INTEGER NOT NULL REFERENCES persons(id) ON DELETE CASCADE,
+    created BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (task_id, person_id)
+    );
+
+-- Метки людей (staff, vip, ...); имена хранятся в нижнем регистре
+CREATE TABLE IF NOT EXISTS tags (
+                                    id SERIAL PRIMARY KEY,
+                                    name VARCHAR(50) NOT NULL UNIQUE
+    );
+
+-- Связь многие-ко-многим людей и меток
+CREATE TABLE IF NOT EXISTS person_tags (
+    person_id INTEGER NOT NULL REFERENCES persons(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (person_id, tag_id)
+    );
+
+-- Журнал изменений людей (append-only): добавление и удаление лиц, переименование
+-- Без внешнего ключа на persons: журнал остается и после удаления человека
+CREATE TABLE IF NOT EXISTS person_audit (
+    id BIGSERIAL PRIMARY KEY,
+    person_id INTEGER NOT NULL,
+    event VARCHAR(50) NOT NULL,    -- faces_added, faces_removed, renamed
+    actor VARCHAR(100) NOT NULL,   -- admin, anonymous, system
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+    );
+
+-- Записи журнала не изменяются и не удаляются
+CREATE OR REPLACE RULE person_audit_no_update AS ON UPDATE TO person_audit DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE person_audit_no_delete AS ON DELETE TO person_audit DO INSTEAD NOTHING;
+
 -- Индексы для быстрого поиска
 CREATE INDEX IF NOT EXISTS idx_faces_person_id ON faces(person_id);
+CREATE INDEX IF NOT EXISTS idx_faces_task_id ON faces(task_id);
+CREATE INDEX IF NOT EXISTS idx_faces_confidence ON faces(confidence);
+CREATE INDEX IF NOT EXISTS idx_faces_quality ON faces(quality);
 CREATE INDEX IF NOT EXISTS idx_persons_name ON persons(name);
+CREATE INDEX IF NOT EXISTS idx_persons_name_trgm ON persons USING GIN (name gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_persons_created_at_id ON persons(created_at, id);
 CREATE INDEX IF NOT EXISTS idx_tasks_status ON tasks(status);
+CREATE INDEX IF NOT EXISTS idx_tasks_content_hash ON tasks(content_hash);
+CREATE INDEX IF NOT EXISTS idx_person_tags_tag_id ON person_tags(tag_id);
+CREATE INDEX IF NOT EXISTS idx_task_persons_person_id ON task_persons(person_id);
+CREATE INDEX IF NOT EXISTS idx_person_audit_person_id ON person_audit(person_id, id);
 
 -- Функция для автоматического обновления updated_at
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -57,4 +156,113 @@ $$ language 'plpgsql';
 -- Триггер для persons
 DROP TRIGGER IF EXISTS update_persons_updated_at ON persons;
 CREATE TRIGGER update_persons_updated_at BEFORE UPDATE ON persons
-    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Счетчики статистики одной строкой: GetStats читает их вместо COUNT(*) по большим таблицам
+-- Обновляются триггерами при вставке и удалении людей, лиц и задач; расхождения
+-- (TRUNCATE, правки в обход триггеров) исправляет ReconcileStats
+CREATE TABLE IF NOT EXISTS stats_summary (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), -- Строка всегда одна
+    total_persons INTEGER NOT NULL DEFAULT 0, -- Люди без deleted_at
+    total_faces INTEGER NOT NULL DEFAULT 0,   -- Лица людей без deleted_at
+    total_tasks INTEGER NOT NULL DEFAULT 0,
+    reconciled_at TIMESTAMP                   -- Последняя сверка с таблицами (NULL - не было)
+);
+
+INSERT INTO stats_summary (id, total_persons, total_faces, total_tasks, reconciled_at)
+SELECT TRUE,
+       (SELECT COUNT(*) FROM persons WHERE deleted_at IS NULL),
+       (SELECT COUNT(*) FROM faces f JOIN persons p ON p.id = f.person_id WHERE p.deleted_at IS NULL),
+       (SELECT COUNT(*) FROM tasks),
+       NOW()
+ON CONFLICT (id) DO NOTHING;
+
+-- Люди: вставка, мягкое удаление/восстановление (вместе с их лицами) и удаление
+-- Удаление обрабатывается BEFORE DELETE: лица человека еще не удалены каскадом,
+-- а каскадное удаление лиц уже не найдет человека и счетчик не тронет
+CREATE OR REPLACE FUNCTION stats_summary_persons()
+RETURNS TRIGGER AS $$
+DECLARE
+    person_faces INTEGER;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.deleted_at IS NULL THEN
+            UPDATE stats_summary SET total_persons = total_persons + 1;
+        END IF;
+        RETURN NEW;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NULL THEN
+            SELECT COUNT(*) INTO person_faces FROM faces WHERE person_id = OLD.id;
+            UPDATE stats_summary SET total_persons = total_persons - 1, total_faces = total_faces - person_faces;
+        END IF;
+        RETURN OLD;
+    END IF;
+
+    IF (OLD.deleted_at IS NULL) <> (NEW.deleted_at IS NULL) THEN
+        SELECT COUNT(*) INTO person_faces FROM faces WHERE person_id = NEW.id;
+        IF NEW.deleted_at IS NULL THEN
+            UPDATE stats_summary SET total_persons = total_persons + 1, total_faces = total_faces + person_faces;
+        ELSE
+            UPDATE stats_summary SET total_persons = total_persons - 1, total_faces = total_faces - person_faces;
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Лица: считаются только лица людей без deleted_at (перенос к другому человеку - тоже)
+CREATE OR REPLACE FUNCTION stats_summary_faces()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE')
+        AND EXISTS (SELECT 1 FROM persons WHERE id = OLD.person_id AND deleted_at IS NULL) THEN
+        UPDATE stats_summary SET total_faces = total_faces - 1;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE')
+        AND EXISTS (SELECT 1 FROM persons WHERE id = NEW.person_id AND deleted_at IS NULL) THEN
+        UPDATE stats_summary SET total_faces = total_faces + 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION stats_summary_tasks()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE stats_summary SET total_tasks = total_tasks + 1;
+    ELSE
+        UPDATE stats_summary SET total_tasks = total_tasks - 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stats_summary_persons_change ON persons;
+CREATE TRIGGER stats_summary_persons_change AFTER INSERT OR UPDATE OF deleted_at ON persons
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_persons();
+
+DROP TRIGGER IF EXISTS stats_summary_persons_delete ON persons;
+CREATE TRIGGER stats_summary_persons_delete BEFORE DELETE ON persons
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_persons();
+
+DROP TRIGGER IF EXISTS stats_summary_faces_change ON faces;
+CREATE TRIGGER stats_summary_faces_change AFTER INSERT OR DELETE OR UPDATE OF person_id ON faces
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_faces();
+
+DROP TRIGGER IF EXISTS stats_summary_tasks_change ON tasks;
+CREATE TRIGGER stats_summary_tasks_change AFTER INSERT OR DELETE ON tasks
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_tasks();
+
+-- is_labeled - человеку дали имя: FALSE у новых людей с именем кластера (person_N),
+-- TRUE после переименования. По нему GET /api/persons?unlabeled=true находит людей,
+-- которых еще надо подписать
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS is_labeled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Уже существующие люди: подписаны все, чье имя не похоже на имя кластера
+UPDATE persons SET is_labeled = TRUE WHERE NOT is_labeled AND name !~ '^person_[0-9]+$';
+
+CREATE INDEX IF NOT EXISTS idx_persons_unlabeled ON persons(created_at, id)
+    WHERE NOT is_labeled AND deleted_at IS NULL;
@@ -0,0 +1,120 @@
+// Package apierror - ошибки API с машиночитаемым кодом
+// Клиент получает {"error": "...", "code": "NOT_FOUND"}: сообщение для человека
+// и стабильный код, по которому ошибки различаются программно. Внутренние причины
+// (ошибки БД, Python) в ответ не попадают - только в лог
+package apierror
+
+import (
+	"net/http"
+
+	"face-recognition/internal/models"
+)
+
+// Коды ошибок API
+const (
+	CodeValidation   = "VALIDATION_ERROR"    // 400: неверный запрос
+	CodeUnauthorized = "UNAUTHORIZED"        // 401: нет или неверный токен
+	CodeForbidden    = "FORBIDDEN"           // 403: действие запрещено
+	CodeNotFound     = "NOT_FOUND"           // 404: объекта нет
+	CodeConflict     = "CONFLICT"            // 409: конфликт с текущим состоянием
+	CodeTooLarge     = "PAYLOAD_TOO_LARGE"   // 413: слишком большой запрос
+	CodeInternal     = "INTERNAL_ERROR"      // 500: ошибка сервера (подробности в логе)
+	CodeUpstream     = "UPSTREAM_ERROR"      // 502: ошибка Python сервиса
+	CodeUnavailable  = "SERVICE_UNAVAILABLE" // 503: возможность выключена или перегружена
+)
+
+// internalMessage - сообщение клиенту о внутренней ошибке без подробностей
+const internalMessage = "Внутренняя ошибка сервера"
+
+// Error - ошибка API: HTTP статус, код и сообщение для клиента
+// Cause - внутренняя причина: пишется в лог и клиенту не отдается
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Cause   error
+}
+
+// Образцы ошибок для errors.Is: сравнение идет по коду
+var (
+	ErrValidation   = &Error{Status: http.StatusBadRequest, Code: CodeValidation}
+	ErrUnauthorized = &Error{Status: http.StatusUnauthorized, Code: CodeUnauthorized}
+	ErrForbidden    = &Error{Status: http.StatusForbidden, Code: CodeForbidden}
+	ErrNotFound     = &Error{Status: http.StatusNotFound, Code: CodeNotFound}
+	ErrConflict     = &Error{Status: http.StatusConflict, Code: CodeConflict}
+	ErrTooLarge     = &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge}
+	ErrInternal     = &Error{Status: http.StatusInternalServerError, Code: CodeInternal}
+	ErrUpstream     = &Error{Status: http.StatusBadGateway, Code: CodeUpstream}
+	ErrUnavailable  = &Error{Status: http.StatusServiceUnavailable, Code: CodeUnavailable}
+)
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+	return e.Message
+}
+
+// Unwrap возвращает внутреннюю причину
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is сравнивает ошибки по коду: errors.Is(err, apierror.ErrNotFound)
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Response возвращает тело ответа с ошибкой
+func (e *Error) Response() models.ErrorResponse {
+	return models.ErrorResponse{Error: e.Message, Code: e.Code}
+}
+
+// Validation - неверный запрос (400)
+func Validation(message string) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidation, Message: message}
+}
+
+// Unauthorized - нет или неверный токен (401)
+func Unauthorized(message string) *Error {
+	return &Error{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Message: message}
+}
+
+// Forbidden - действие запрещено (403)
+func Forbidden(message string) *Error {
+	return &Error{Status: http.StatusForbidden, Code: CodeForbidden, Message: message}
+}
+
+// NotFound - объекта нет (404)
+func NotFound(message string) *Error {
+	return &Error{Status: http.StatusNotFound, Code: CodeNotFound, Message: message}
+}
+
+// Conflict - конфликт с текущим состоянием (409)
+func Conflict(message string) *Error {
+	return &Error{Status: http.StatusConflict, Code: CodeConflict, Message: message}
+}
+
+// TooLarge - слишком большой запрос (413)
+func TooLarge(message string) *Error {
+	return &Error{Status: http.StatusRequestEntityTooLarge, Code: CodeTooLarge, Message: message}
+}
+
+// Internal - ошибка сервера (500); пустое message - общее сообщение без подробностей
+func Internal(message string, cause error) *Error {
+	if message == "" {
+		message = internalMessage
+	}
+	return &Error{Status: http.StatusInternalServerError, Code: CodeInternal, Message: message, Cause: cause}
+}
+
+// Upstream - ошибка Python сервиса (502); причина только в логе
+func Upstream(message string, cause error) *Error {
+	return &Error{Status: http.StatusBadGateway, Code: CodeUpstream, Message: message, Cause: cause}
+}
+
+// Unavailable - возможность выключена или сервис перегружен (503)
+func Unavailable(message string) *Error {
+	return &Error{Status: http.StatusServiceUnavailable, Code: CodeUnavailable, Message: message}
+}
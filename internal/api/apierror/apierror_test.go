@@ -0,0 +1,61 @@
+package apierror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"face-recognition/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorIsComparesCodes(t *testing.T) {
+	err := fmt.Errorf("загрузка: %w", NotFound("Задача не найдена"))
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+	assert.False(t, errors.Is(err, ErrValidation))
+
+	var apiErr *Error
+	assert.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusNotFound, apiErr.Status)
+}
+
+func TestErrorHidesCause(t *testing.T) {
+	cause := errors.New("pq: connection refused")
+	err := Internal("", cause)
+
+	// Причина видна в логе (Error) и через errors.Is, но не в ответе
+	assert.Equal(t, "Внутренняя ошибка сервера: pq: connection refused", err.Error())
+	assert.True(t, errors.Is(err, cause))
+	assert.Equal(t, models.ErrorResponse{Error: "Внутренняя ошибка сервера", Code: CodeInternal}, err.Response())
+
+	upstream := Upstream("Ошибка сравнения embedding", errors.New("Python вернул ошибку 500"))
+	assert.Equal(t, http.StatusBadGateway, upstream.Status)
+	assert.Equal(t, models.ErrorResponse{Error: "Ошибка сравнения embedding", Code: CodeUpstream}, upstream.Response())
+}
+
+func TestConstructors(t *testing.T) {
+	tests := []struct {
+		err    *Error
+		status int
+		code   string
+	}{
+		{Validation("x"), http.StatusBadRequest, CodeValidation},
+		{Unauthorized("x"), http.StatusUnauthorized, CodeUnauthorized},
+		{Forbidden("x"), http.StatusForbidden, CodeForbidden},
+		{NotFound("x"), http.StatusNotFound, CodeNotFound},
+		{Conflict("x"), http.StatusConflict, CodeConflict},
+		{TooLarge("x"), http.StatusRequestEntityTooLarge, CodeTooLarge},
+		{Internal("x", nil), http.StatusInternalServerError, CodeInternal},
+		{Upstream("x", nil), http.StatusBadGateway, CodeUpstream},
+		{Unavailable("x"), http.StatusServiceUnavailable, CodeUnavailable},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.status, tt.err.Status, tt.code)
+		assert.Equal(t, tt.code, tt.err.Code)
+		assert.Equal(t, "x", tt.err.Error())
+	}
+}
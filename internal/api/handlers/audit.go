@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetPersonAudit возвращает журнал изменений человека: когда ему добавлялись
+// и удалялись лица, когда он переименовывался и кем (admin, anonymous, system)
+// Журнал не зависит от того, удален ли человек, поэтому 404 не бывает:
+// для неизвестного ID возвращается пустой список
+func (h *Handler) HandleGetPersonAudit(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	entries, err := h.repo.GetPersonAudit(id)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if entries == nil {
+		entries = []models.PersonAuditEntry{}
+	}
+
+	c.JSON(http.StatusOK, models.PersonAuditResponse{
+		PersonID: id,
+		Entries:  entries,
+	})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleClearPersonCache удаляет из кэша одного человека (DELETE /api/cache/person/:id)
+func (h *Handler) HandleClearPersonCache(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	h.clearCache(c, fmt.Sprintf("person:%d", id), func() error {
+		return h.cache.InvalidatePerson(h.context(), id)
+	})
+}
+
+// HandleClearStatsCache удаляет из кэша общую статистику (DELETE /api/cache/stats)
+func (h *Handler) HandleClearStatsCache(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	h.clearCache(c, "stats", func() error {
+		// Статистика, которую отдают запросы, пересчитывается в фоне
+		h.refreshStats()
+		return h.cache.InvalidateStats(h.context())
+	})
+}
+
+// HandleClearAllCache очищает весь кэш (DELETE /api/cache/all?confirm=true)
+// Без confirm=true ничего не делает - полная очистка бьет по нагрузке на БД
+func (h *Handler) HandleClearAllCache(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	if c.Query("confirm") != "true" {
+		respondError(c, apierror.Validation("Полная очистка кэша требует confirm=true"))
+		return
+	}
+
+	h.clearCache(c, "all", func() error {
+		return h.cache.FlushAll(h.context())
+	})
+}
+
+// clearCache выполняет очистку и отвечает списком очищенного
+func (h *Handler) clearCache(c *gin.Context, cleared string, clear func() error) {
+	if h.cache == nil {
+		respondError(c, apierror.Unavailable("Кэш не настроен"))
+		return
+	}
+
+	if err := clear(); err != nil {
+		respondError(c, apierror.Internal("Ошибка очистки кэша", err))
+		return
+	}
+
+	log.Printf("🧹 Кэш очищен администратором: %s", cleared)
+	c.JSON(http.StatusOK, models.CacheClearResponse{
+		Cleared: []string{cleared},
+	})
+}
@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// cacheWarmPage - сколько людей читается из БД за один запрос при прогреве кэша
+const cacheWarmPage = 100
+
+// cacheRebuilds хранит последнюю перестройку кэша: одновременно идет не больше одной
+type cacheRebuilds struct {
+	mu   sync.Mutex
+	last *models.CacheRebuildJob
+}
+
+func newCacheRebuilds() *cacheRebuilds {
+	return &cacheRebuilds{}
+}
+
+// start заводит новую перестройку; false - предыдущая еще идет (возвращается она)
+func (r *cacheRebuilds) start(personsLimit, tasksLimit int) (models.CacheRebuildJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last != nil && r.last.Status == models.CacheRebuildRunning {
+		return *r.last, false
+	}
+	r.last = &models.CacheRebuildJob{
+		ID:           uuid.New().String(),
+		Status:       models.CacheRebuildRunning,
+		Stage:        models.CacheRebuildStageFlush,
+		PersonsLimit: personsLimit,
+		TasksLimit:   tasksLimit,
+		StartedAt:    time.Now(),
+	}
+	return *r.last, true
+}
+
+// update меняет перестройку и возвращает ее копию для рассылки
+func (r *cacheRebuilds) update(change func(job *models.CacheRebuildJob)) models.CacheRebuildJob {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	change(r.last)
+	return *r.last
+}
+
+// get возвращает перестройку по ID (false - не найдена или уже не последняя)
+func (r *cacheRebuilds) get(id string) (models.CacheRebuildJob, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.last == nil || r.last.ID != id {
+		return models.CacheRebuildJob{}, false
+	}
+	return *r.last, true
+}
+
+// HandleRebuildCache очищает кэш и заново заполняет его из БД (POST /api/admin/cache/rebuild)
+// Прогреваются статистика и CACHE_WARM_PERSONS последних людей, с tasks=true - еще
+// CACHE_WARM_TASKS последних задач. Перестройка идет в фоне: ответ 202 сразу, ход
+// перестройки - в WebSocket сообщениях cache_rebuild и GET /api/admin/cache/rebuild/:id
+func (h *Handler) HandleRebuildCache(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	if h.cache == nil || h.rebuilds == nil {
+		respondError(c, apierror.Unavailable("Кэш не настроен"))
+		return
+	}
+
+	personsLimit, tasksLimit := config.DefaultCacheWarmPersons, 0
+	if h.cfg != nil {
+		personsLimit = h.cfg.Cache.WarmPersons
+	}
+	if c.Query("tasks") == "true" {
+		tasksLimit = config.DefaultCacheWarmTasks
+		if h.cfg != nil {
+			tasksLimit = h.cfg.Cache.WarmTasks
+		}
+	}
+
+	job, ok := h.rebuilds.start(personsLimit, tasksLimit)
+	if !ok {
+		respondError(c, apierror.Conflict(fmt.Sprintf("Перестройка кэша %s уже идет", job.ID)))
+		return
+	}
+
+	log.Printf("🔁 Перестройка кэша %s: людей до %d, задач до %d", job.ID, personsLimit, tasksLimit)
+	h.broadcastCacheRebuild(job)
+	go h.rebuildCache()
+
+	c.Header("Location", "/api/admin/cache/rebuild/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// HandleCacheRebuildStatus возвращает состояние перестройки кэша (GET /api/admin/cache/rebuild/:id)
+func (h *Handler) HandleCacheRebuildStatus(c *gin.Context) {
+	if h.rebuilds == nil {
+		respondError(c, apierror.NotFound("Перестройка кэша не найдена"))
+		return
+	}
+
+	job, ok := h.rebuilds.get(c.Param("id"))
+	if !ok {
+		respondError(c, apierror.NotFound("Перестройка кэша не найдена"))
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// rebuildCache выполняет начатую перестройку кэша и отмечает ее результат
+func (h *Handler) rebuildCache() {
+	err := h.warmCache()
+
+	job := h.rebuilds.update(func(job *models.CacheRebuildJob) {
+		finished := time.Now()
+		job.FinishedAt = &finished
+		job.Status = models.CacheRebuildCompleted
+		if err != nil {
+			job.Status = models.CacheRebuildFailed
+			job.Error = err.Error()
+		}
+	})
+
+	if err != nil {
+		log.Printf("❌ Перестройка кэша %s не удалась на этапе %s: %v", job.ID, job.Stage, err)
+	} else {
+		log.Printf("✅ Кэш перестроен (%s): людей %d, задач %d", job.ID, job.Persons, job.Tasks)
+	}
+	h.broadcastCacheRebuild(job)
+}
+
+// warmCache очищает кэш и заполняет его по этапам, рассылая ход перестройки
+// Люди читаются страницами по cacheWarmPage (новые первыми), чтобы не держать всех в памяти
+func (h *Handler) warmCache() error {
+	job := h.rebuilds.update(func(job *models.CacheRebuildJob) {})
+
+	if err := h.cache.FlushAll(h.context()); err != nil {
+		return fmt.Errorf("очистка кэша: %w", err)
+	}
+
+	h.setCacheRebuildStage(models.CacheRebuildStageStats)
+	if h.stats != nil {
+		// Пересчет обновляет и кэш (loadStats); при ошибке отдается предыдущая статистика
+		if _, err := h.freshStats(); err != nil {
+			return fmt.Errorf("статистика: %w", err)
+		}
+	} else if _, err := h.loadStats(); err != nil {
+		return fmt.Errorf("статистика: %w", err)
+	}
+
+	h.setCacheRebuildStage(models.CacheRebuildStagePersons)
+	var cursor *models.PersonCursor
+	for warmed := 0; warmed < job.PersonsLimit; {
+		page, err := h.repo.GetPersonsAfter(cursor, min(cacheWarmPage, job.PersonsLimit-warmed))
+		if err != nil {
+			return fmt.Errorf("люди: %w", err)
+		}
+
+		for _, listed := range page {
+			person, err := h.repo.GetPersonByID(listed.ID)
+			if err == sql.ErrNoRows {
+				continue // Удален во время перестройки
+			}
+			if err != nil {
+				return fmt.Errorf("человек %d: %w", listed.ID, err)
+			}
+			if err := h.cache.SetPerson(h.context(), person); err != nil {
+				return fmt.Errorf("человек %d: %w", listed.ID, err)
+			}
+		}
+		warmed += len(page)
+
+		h.broadcastCacheRebuild(h.rebuilds.update(func(job *models.CacheRebuildJob) {
+			job.Persons = warmed
+		}))
+
+		if len(page) < cacheWarmPage {
+			break
+		}
+		last := page[len(page)-1]
+		cursor = &models.PersonCursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	}
+
+	if job.TasksLimit == 0 {
+		return nil
+	}
+
+	h.setCacheRebuildStage(models.CacheRebuildStageTasks)
+	tasks, err := h.repo.GetRecentTasks(job.TasksLimit)
+	if err != nil {
+		return fmt.Errorf("задачи: %w", err)
+	}
+	for i := range tasks {
+		if err := h.cache.SetTask(h.context(), &tasks[i]); err != nil {
+			return fmt.Errorf("задача %s: %w", tasks[i].ID, err)
+		}
+	}
+	h.broadcastCacheRebuild(h.rebuilds.update(func(job *models.CacheRebuildJob) {
+		job.Tasks = len(tasks)
+	}))
+
+	return nil
+}
+
+// setCacheRebuildStage переводит перестройку на следующий этап и рассылает это
+func (h *Handler) setCacheRebuildStage(stage string) {
+	h.broadcastCacheRebuild(h.rebuilds.update(func(job *models.CacheRebuildJob) {
+		job.Stage = stage
+	}))
+}
+
+// broadcastCacheRebuild рассылает состояние перестройки кэша WebSocket клиентам
+func (h *Handler) broadcastCacheRebuild(job models.CacheRebuildJob) {
+	if h.wsManager != nil {
+		h.wsManager.BroadcastCacheRebuild(job)
+	}
+}
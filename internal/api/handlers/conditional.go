@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondConditional отвечает JSON с ETag (хэш тела) и Last-Modified (если задан)
+// Если валидаторы запроса (If-None-Match, иначе If-Modified-Since) совпадают,
+// отвечает 304 без тела - клиент использует сохраненную копию
+func respondConditional(c *gin.Context, body interface{}, lastModified time.Time) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	hash := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(hash[:16]) + `"`
+
+	// no-cache: копию можно хранить, но перед использованием ее нужно перепроверить
+	c.Header("Cache-Control", "no-cache")
+	c.Header("ETag", etag)
+	if !lastModified.IsZero() {
+		c.Header("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	}
+
+	if notModified(c.Request, etag, lastModified) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+}
+
+// notModified проверяет условные заголовки запроса (RFC 9110):
+// при наличии If-None-Match If-Modified-Since не учитывается
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// Слабое сравнение: W/"x" совпадает с "x"
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// Last-Modified передается с точностью до секунды
+	return !lastModified.Truncate(time.Second).After(since)
+}
+
+// personLastModified - время последнего изменения человека или его лиц
+func personLastModified(person *models.PersonWithFaces) time.Time {
+	lastModified := person.UpdatedAt
+	for _, face := range person.Faces {
+		if face.DetectedAt.After(lastModified) {
+			lastModified = face.DetectedAt
+		}
+	}
+	return lastModified
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleUpdatePersonCover вручную выбирает лицо-обложку человека
+// Лицо должно принадлежать этому человеку
+func (h *Handler) HandleUpdatePersonCover(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	var req models.UpdateCoverRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierror.Validation("face_id обязателен"))
+		return
+	}
+
+	face, err := h.repo.GetFaceByID(req.FaceID)
+	if err != nil {
+		respondError(c, repoError(err, fmt.Sprintf("Лицо %d не найдено", req.FaceID)))
+		return
+	}
+	if face.PersonID != id {
+		respondError(c, apierror.Validation(fmt.Sprintf("Лицо %d принадлежит другому человеку", req.FaceID)))
+		return
+	}
+
+	person, err := h.repo.UpdatePersonCover(id, face.ID)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
+	}
+
+	h.broadcastPersonEvent(websocket.MessageTypePersonUpdated, id, person.Name)
+
+	cover := &models.CoverFace{FaceID: face.ID, AnnotatedImage: face.AnnotatedImage}
+	h.setCoverURL(cover)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Обложка обновлена",
+		"cover":   cover,
+	})
+}
+
+// setCoverURL заполняет адрес изображения обложки
+func (h *Handler) setCoverURL(cover *models.CoverFace) {
+	if cover == nil || h.storage == nil || cover.AnnotatedImage == "" {
+		return
+	}
+	cover.URL = h.storage.URL(cover.AnnotatedImage)
+}
+
+// coverScore оценивает, насколько лицо подходит для обложки:
+// уверенность детекции, умноженная на фронтальность и резкость (если она посчитана)
+func coverScore(metadata models.FaceMetadata, quality *float64) float64 {
+	score := metadata.Confidence * frontality(metadata.Landmarks)
+	if quality != nil {
+		score *= *quality
+	}
+	return score
+}
+
+// frontality оценивает поворот головы по 5 точкам insightface
+// (левый глаз, правый глаз, нос, углы рта): 1 - нос посередине между глазами (анфас),
+// 0 - нос на уровне одного из глаз или дальше (профиль)
+// Без точек возвращает 1 - тогда обложка выбирается только по уверенности
+func frontality(landmarks [][]float64) float64 {
+	if len(landmarks) < 3 {
+		return 1
+	}
+	for _, point := range landmarks[:3] {
+		if len(point) < 2 {
+			return 1
+		}
+	}
+
+	leftEye, rightEye, nose := landmarks[0], landmarks[1], landmarks[2]
+	halfEyes := math.Abs(rightEye[0]-leftEye[0]) / 2
+	if halfEyes == 0 {
+		return 0
+	}
+
+	offset := math.Abs(nose[0]-(leftEye[0]+rightEye[0])/2) / halfEyes
+	return math.Max(0, 1-offset)
+}
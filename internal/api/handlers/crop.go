@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"image"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxCropPadding - максимальный отступ вокруг лица (доля размера bbox с каждой стороны)
+const maxCropPadding = 1.0
+
+// HandleFaceCrop отдает область лица из исходного изображения (JPEG, WebP или AVIF -
+// см. IMAGE_OUTPUT_FORMAT)
+// Необязательный padding (0-1) расширяет bbox на эту долю его размера с каждой стороны
+func (h *Handler) HandleFaceCrop(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	padding := 0.0
+	if v := c.Query("padding"); v != "" {
+		padding, err = parseFiniteFloat(v)
+		if err != nil || padding < 0 || padding > maxCropPadding {
+			respondError(c, apierror.Validation("padding должен быть числом в диапазоне [0, 1]"))
+			return
+		}
+	}
+
+	// Вырезка не меняется, пока существует лицо - но лицо могли удалить,
+	// поэтому кэш читаем только после проверки в БД
+	face, err := h.repo.GetFaceByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Лицо не найдено"))
+		return
+	}
+
+	if h.cache != nil {
+		if data, err := h.cache.GetFaceCrop(c.Request.Context(), id, padding); err == nil && data != nil {
+			c.Data(http.StatusOK, storage.ContentType(data), data)
+			return
+		}
+	}
+
+	if face.FaceWidth <= 0 || face.FaceHeight <= 0 {
+		respondError(c, apierror.NotFound("У лица нет координат"))
+		return
+	}
+	if face.OriginalImage == "" || !h.storage.FileExists(face.OriginalImage) {
+		respondError(c, apierror.NotFound("Исходное изображение не найдено"))
+		return
+	}
+
+	data, err := h.storage.CropImage(face.OriginalImage, cropRect(face, padding))
+	if errors.Is(err, storage.ErrEmptyCrop) {
+		respondError(c, apierror.NotFound("Лицо вне исходного изображения"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if h.cache != nil {
+		if err := h.cache.SetFaceCrop(h.context(), id, padding, data); err != nil {
+			log.Printf("⚠️  Не удалось закэшировать вырезку лица %d: %v", id, err)
+		}
+	}
+
+	c.Data(http.StatusOK, storage.ContentType(data), data)
+}
+
+// cropRect возвращает bbox лица, расширенный на padding его размера с каждой стороны
+// По границам изображения область обрезает storage.CropImage
+func cropRect(face *models.Face, padding float64) image.Rectangle {
+	padX := int(math.Round(float64(face.FaceWidth) * padding))
+	padY := int(math.Round(float64(face.FaceHeight) * padding))
+
+	return image.Rect(
+		face.FaceX-padX,
+		face.FaceY-padY,
+		face.FaceX+face.FaceWidth+padX,
+		face.FaceY+face.FaceHeight+padY,
+	)
+}
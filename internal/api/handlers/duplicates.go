@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+	"face-recognition/pkg/embedding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения числа пар в ответе GET /api/persons/duplicates
+const (
+	defaultDuplicatesLimit = 100
+	maxDuplicatesLimit     = 1000
+)
+
+// HandleFindDuplicatePersons ищет пары разных людей, похожих на одного человека
+// Пары сравниваются по косинусному сходству центроидов - среднего направления
+// embedding лиц человека, хранимого в persons.centroid. Ничего не меняет: это
+// инструмент для ручной проверки перед слиянием
+// ?threshold= переопределяет COMPARE_MATCH_THRESHOLD, ?limit= - число пар (до 1000)
+// Пары отсортированы по новизне: сначала пары с самым новым человеком
+func (h *Handler) HandleFindDuplicatePersons(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	threshold, ok := h.queryThreshold(c)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(c, defaultDuplicatesLimit, maxDuplicatesLimit)
+	if !ok {
+		return
+	}
+
+	persons, err := h.repo.GetAllPersons()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	centroids, err := h.repo.GetPersonCentroids()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	pairs := findDuplicatePairs(persons, centroids, threshold)
+
+	response := models.DuplicatePersonsResponse{
+		Threshold: threshold,
+		Total:     len(pairs),
+		Pairs:     pairs[:min(len(pairs), limit)],
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// queryThreshold читает порог сходства из ?threshold= (по умолчанию COMPARE_MATCH_THRESHOLD)
+// Неверное значение - ответ 400 и false
+func (h *Handler) queryThreshold(c *gin.Context) (float64, bool) {
+	v := c.Query("threshold")
+	if v == "" {
+		return h.matchThreshold(), true
+	}
+
+	threshold, err := parseFiniteFloat(v)
+	if err != nil || threshold <= 0 || threshold > 1 {
+		respondError(c, apierror.Validation("threshold должен быть числом в диапазоне (0, 1]"))
+		return 0, false
+	}
+	return threshold, true
+}
+
+// queryLimit читает ?limit= от 1 до maxLimit (по умолчанию defaultLimit)
+// Неверное значение - ответ 400 и false
+func queryLimit(c *gin.Context, defaultLimit, maxLimit int) (int, bool) {
+	v := c.Query("limit")
+	if v == "" {
+		return defaultLimit, true
+	}
+
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxLimit {
+		respondError(c, apierror.Validation("limit должен быть числом от 1 до "+strconv.Itoa(maxLimit)))
+		return 0, false
+	}
+	return limit, true
+}
+
+// matchThreshold возвращает порог совпадения лиц из конфигурации
+func (h *Handler) matchThreshold() float64 {
+	if h.cfg == nil {
+		return embedding.DefaultMatchThreshold
+	}
+	return h.cfg.Compare.MatchThreshold
+}
+
+// findDuplicatePairs сравнивает центроиды всех пар людей и возвращает пары
+// со сходством строго выше threshold (как при сопоставлении лиц)
+// Центроиды единичные, поэтому сходство - скалярное произведение: n² / 2 сравнений
+// без выделения памяти. Пары упорядочены по дате создания более нового человека
+// (новые первыми), затем по убыванию сходства
+func findDuplicatePairs(persons []models.PersonWithFaces, centroids map[int][]float64, threshold float64) []models.DuplicatePair {
+	type candidate struct {
+		person   models.DuplicateCandidate
+		centroid []float64
+	}
+
+	candidates := make([]candidate, 0, len(persons))
+	for _, p := range persons {
+		centroid, ok := centroids[p.ID]
+		if !ok {
+			continue
+		}
+		candidates = append(candidates, candidate{
+			person: models.DuplicateCandidate{
+				ID:         p.ID,
+				Name:       p.Name,
+				FacesCount: p.Count,
+				CreatedAt:  p.CreatedAt,
+				Cover:      p.Cover,
+			},
+			centroid: centroid,
+		})
+	}
+
+	// Сначала новые: тогда в каждой паре PersonA (индекс i) новее PersonB
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i].person, candidates[j].person
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+
+	pairs := []models.DuplicatePair{}
+	for i := range candidates {
+		start := len(pairs)
+		for j := i + 1; j < len(candidates); j++ {
+			if len(candidates[i].centroid) != len(candidates[j].centroid) {
+				continue // Лица посчитаны разными моделями - сравнивать нельзя
+			}
+			similarity := embedding.Dot(candidates[i].centroid, candidates[j].centroid)
+			if similarity <= threshold {
+				continue
+			}
+			pairs = append(pairs, models.DuplicatePair{
+				PersonA:    candidates[i].person,
+				PersonB:    candidates[j].person,
+				Similarity: min(similarity, 1),
+			})
+		}
+
+		// Внутри одного PersonA - самые похожие первыми
+		group := pairs[start:]
+		sort.SliceStable(group, func(a, b int) bool {
+			return group[a].Similarity > group[b].Similarity
+		})
+	}
+
+	return pairs
+}
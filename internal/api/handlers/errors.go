@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"face-recognition/internal/api/apierror"
+
+	"github.com/gin-gonic/gin"
+)
+
+// respondError отвечает ошибкой API {"error": "...", "code": "..."}
+// Статус и код берутся из *apierror.Error; любая другая ошибка - внутренняя:
+// клиент получает INTERNAL_ERROR без подробностей. Причины внутренних ошибок
+// и ошибок Python пишутся в лог
+func respondError(c *gin.Context, err error) {
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) {
+		apiErr = apierror.Internal("", err)
+	}
+
+	if apiErr.Cause != nil {
+		log.Printf("❌ %s %s: %v", c.Request.Method, c.Request.URL.Path, apiErr)
+	}
+	c.JSON(apiErr.Status, apiErr.Response())
+}
+
+// repoError переводит ошибку репозитория в ошибку API: sql.ErrNoRows - NOT_FOUND
+// с сообщением notFound, остальные - внутренняя ошибка
+func repoError(err error, notFound string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return apierror.NotFound(notFound)
+	}
+	return apierror.Internal("", err)
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportPageSize - сколько людей читается из БД за один запрос при экспорте
+const exportPageSize = 500
+
+// HandleExportPersons выгружает весь каталог людей в CSV или JSON
+// ?format=csv|json (по умолчанию csv)
+// Данные читаются постранично и сразу пишутся в ответ
+func (h *Handler) HandleExportPersons(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "json" {
+		respondError(c, apierror.Validation("format должен быть csv или json"))
+		return
+	}
+
+	// Первую страницу читаем до отправки заголовков, чтобы вернуть
+	// нормальную ошибку если БД недоступна
+	page, err := h.repo.GetPersonsPage(0, exportPageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "json" {
+		contentType = "application/json; charset=utf-8"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="persons.%s"`, format))
+	c.Status(http.StatusOK)
+
+	var writeRow func(p models.PersonWithFaces) error
+	var finish func() error
+
+	if format == "csv" {
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write([]string{"id", "name", "faces_count", "created_at", "updated_at"}); err != nil {
+			log.Printf("⚠️  Экспорт людей: %v", err)
+			return
+		}
+		writeRow = func(p models.PersonWithFaces) error {
+			return w.Write([]string{
+				strconv.Itoa(p.ID),
+				p.Name,
+				strconv.Itoa(p.Count),
+				p.CreatedAt.Format(time.RFC3339),
+				p.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		finish = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		first := true
+		if _, err := io.WriteString(c.Writer, "["); err != nil {
+			log.Printf("⚠️  Экспорт людей: %v", err)
+			return
+		}
+		writeRow = func(p models.PersonWithFaces) error {
+			if !first {
+				if _, err := io.WriteString(c.Writer, ","); err != nil {
+					return err
+				}
+			}
+			first = false
+
+			data, err := json.Marshal(models.PersonSummary{Person: p.Person, Count: p.Count})
+			if err != nil {
+				return err
+			}
+			_, err = c.Writer.Write(data)
+			return err
+		}
+		finish = func() error {
+			_, err := io.WriteString(c.Writer, "]")
+			return err
+		}
+	}
+
+	for len(page) > 0 {
+		for _, p := range page {
+			if err := writeRow(p); err != nil {
+				log.Printf("⚠️  Экспорт людей: %v", err)
+				return
+			}
+		}
+
+		if len(page) < exportPageSize {
+			break
+		}
+
+		page, err = h.repo.GetPersonsPage(page[len(page)-1].ID, exportPageSize)
+		if err != nil {
+			// Заголовки уже отправлены - можем только оборвать выгрузку
+			log.Printf("⚠️  Экспорт людей: ошибка чтения страницы: %v", err)
+			return
+		}
+	}
+
+	if err := finish(); err != nil {
+		log.Printf("⚠️  Экспорт людей: %v", err)
+	}
+}
+
+// HandleExportPerson отдает ZIP архив со всеми фото человека и metadata.json
+// Архив пишется прямо в ответ, без сборки в памяти
+// ?annotated=true добавляет фото с рамками
+func (h *Handler) HandleExportPerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	withAnnotated := c.Query("annotated") == "true"
+
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	if len(person.Faces) == 0 {
+		respondError(c, apierror.NotFound("У человека нет фото"))
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="person_%d.zip"`, person.ID))
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	defer archive.Close()
+
+	metadata := models.ExportMetadata{
+		PersonID: person.ID,
+		Name:     person.Name,
+		Faces:    make([]models.ExportFaceRecord, 0, len(person.Faces)),
+	}
+
+	// Одно оригинальное фото может содержать несколько лиц - пишем его один раз
+	written := make(map[string]bool)
+
+	for _, face := range person.Faces {
+		record := models.ExportFaceRecord{
+			FaceID:     face.ID,
+			Original:   path.Join("originals", path.Base(face.OriginalImage)),
+			Bbox:       [4]int{face.FaceX, face.FaceY, face.FaceWidth, face.FaceHeight},
+			Confidence: face.Confidence,
+		}
+		h.addFileToZip(archive, face.OriginalImage, record.Original, written)
+
+		if withAnnotated && face.AnnotatedImage != "" {
+			record.Annotated = path.Join("annotated", path.Base(face.AnnotatedImage))
+			h.addFileToZip(archive, face.AnnotatedImage, record.Annotated, written)
+		}
+
+		metadata.Faces = append(metadata.Faces, record)
+	}
+
+	entry, err := archive.Create("metadata.json")
+	if err != nil {
+		log.Printf("⚠️  Ошибка записи metadata.json: %v", err)
+		return
+	}
+
+	encoder := json.NewEncoder(entry)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(metadata); err != nil {
+		log.Printf("⚠️  Ошибка записи metadata.json: %v", err)
+	}
+}
+
+// addFileToZip копирует файл из хранилища в архив под именем name
+// Ответ уже начат, поэтому отсутствующие файлы только логируются
+func (h *Handler) addFileToZip(archive *zip.Writer, key, name string, written map[string]bool) {
+	if written[name] {
+		return
+	}
+	written[name] = true
+
+	src, err := h.storage.Open(key)
+	if err != nil {
+		log.Printf("⚠️  Экспорт: файл %s недоступен: %v", key, err)
+		return
+	}
+	defer src.Close()
+
+	entry, err := archive.Create(name)
+	if err != nil {
+		log.Printf("⚠️  Экспорт: ошибка создания записи %s: %v", name, err)
+		return
+	}
+
+	if _, err := io.Copy(entry, src); err != nil {
+		log.Printf("⚠️  Экспорт: ошибка копирования %s: %v", key, err)
+	}
+}
+
+// HandlePersonEmbeddings возвращает embedding всех лиц человека (GET /api/persons/:id/embeddings)
+// Для обучения моделей на размеченных данных; embedding - биометрические данные,
+// поэтому роут закрыт AdminAuth. Лица без корректного embedding пропускаются
+func (h *Handler) HandlePersonEmbeddings(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	response := models.PersonEmbeddingsResponse{
+		PersonID: person.ID,
+		Name:     person.Name,
+		Faces:    make([]models.FaceEmbeddingResponse, 0, len(person.Faces)),
+	}
+	for _, face := range person.Faces {
+		vector, ok := decodeEmbedding(face.Embedding)
+		if !ok {
+			continue
+		}
+		response.Faces = append(response.Faces, models.FaceEmbeddingResponse{
+			FaceID:    face.ID,
+			PersonID:  face.PersonID,
+			Model:     face.EmbeddingModel,
+			Dimension: len(vector),
+			Embedding: vector,
+		})
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleExportEmbeddings выгружает embedding всех лиц с метками (GET /api/export/embeddings)
+// ?format=ndjson (единственный формат): одна JSON строка на лицо, по возрастанию ID лица
+// Лица читаются постранично и сразу пишутся в ответ, поэтому выгрузка не держит
+// всю БД в памяти. Закрыт AdminAuth, как и embedding отдельных лиц
+func (h *Handler) HandleExportEmbeddings(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	if format := c.DefaultQuery("format", "ndjson"); format != "ndjson" {
+		respondError(c, apierror.Validation("format должен быть ndjson"))
+		return
+	}
+
+	// Первую страницу читаем до отправки заголовков, чтобы вернуть
+	// нормальную ошибку если БД недоступна
+	page, err := h.repo.GetEmbeddingsPage(0, exportPageSize)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Content-Disposition", `attachment; filename="embeddings.ndjson"`)
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	exported := 0
+	for len(page) > 0 {
+		for _, face := range page {
+			vector, ok := decodeEmbedding(face.Embedding)
+			if !ok {
+				continue
+			}
+			if err := encoder.Encode(models.EmbeddingExportRecord{
+				FaceID:     face.FaceID,
+				PersonID:   face.PersonID,
+				PersonName: face.PersonName,
+				Model:      face.Model,
+				Dimension:  len(vector),
+				Embedding:  vector,
+			}); err != nil {
+				log.Printf("⚠️  Экспорт embedding: %v", err)
+				return
+			}
+			exported++
+		}
+		c.Writer.Flush()
+
+		if len(page) < exportPageSize {
+			break
+		}
+
+		page, err = h.repo.GetEmbeddingsPage(page[len(page)-1].FaceID, exportPageSize)
+		if err != nil {
+			// Заголовки уже отправлены - можем только оборвать выгрузку
+			log.Printf("⚠️  Экспорт embedding: ошибка чтения страницы: %v", err)
+			return
+		}
+	}
+
+	log.Printf("📦 Выгружено %d embedding", exported)
+}
+
+// decodeEmbedding разбирает embedding лица из JSON (false - embedding нет или он битый)
+func decodeEmbedding(data []byte) ([]float64, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	var vector []float64
+	if err := json.Unmarshal(data, &vector); err != nil || len(vector) == 0 {
+		return nil, false
+	}
+	return vector, true
+}
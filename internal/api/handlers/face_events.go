@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"time"
+
+	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+)
+
+// faceEvents копит сохраненные лица задачи и рассылает их сообщениями face_saved:
+// пачка уходит, когда набралось FaceEventsBatch лиц или с прошлой отправки прошло
+// FaceEventsInterval. Остаток отправляется flush в конце этапа сохранения
+type faceEvents struct {
+	h         *Handler
+	taskID    string
+	batch     int
+	interval  time.Duration
+	pending   []websocket.FaceSaved
+	lastFlush time.Time
+}
+
+// newFaceEvents создает накопитель событий face_saved для задачи
+func (h *Handler) newFaceEvents(taskID string) *faceEvents {
+	return &faceEvents{
+		h:         h,
+		taskID:    taskID,
+		batch:     h.faceEventsBatch(),
+		interval:  h.faceEventsInterval(),
+		lastFlush: time.Now(),
+	}
+}
+
+// add добавляет сохраненное лицо и отправляет пачку, если пора
+func (e *faceEvents) add(face *models.Face) {
+	saved := websocket.FaceSaved{
+		PersonID:       face.PersonID,
+		FaceID:         face.ID,
+		AnnotatedImage: face.AnnotatedImage,
+	}
+	if e.h.storage != nil && face.AnnotatedImage != "" {
+		saved.URL = e.h.storage.URL(face.AnnotatedImage)
+	}
+	e.pending = append(e.pending, saved)
+
+	if len(e.pending) >= e.batch || time.Since(e.lastFlush) >= e.interval {
+		e.flush()
+	}
+}
+
+// flush отправляет накопленные лица
+func (e *faceEvents) flush() {
+	e.lastFlush = time.Now()
+	if len(e.pending) == 0 || e.h.wsManager == nil {
+		e.pending = nil
+		return
+	}
+	e.h.wsManager.BroadcastFacesSaved(e.taskID, e.pending)
+	e.pending = nil
+}
+
+// faceEventsBatch возвращает максимум лиц в одном сообщении face_saved
+func (h *Handler) faceEventsBatch() int {
+	if h.cfg == nil || h.cfg.WebSocket.FaceEventsBatch <= 0 {
+		return config.DefaultFaceEventsBatch
+	}
+	return h.cfg.WebSocket.FaceEventsBatch
+}
+
+// faceEventsInterval возвращает минимальный интервал между неполными пачками face_saved
+func (h *Handler) faceEventsInterval() time.Duration {
+	if h.cfg == nil || h.cfg.WebSocket.FaceEventsInterval <= 0 {
+		return config.DefaultFaceEventsInterval
+	}
+	return h.cfg.WebSocket.FaceEventsInterval
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+	"face-recognition/pkg/embedding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения числа людей в ответе GET /api/search/face
+const (
+	defaultFaceSearchLimit = 10
+	maxFaceSearchLimit     = 100
+)
+
+// HandleSearchFace ищет людей, похожих на лицо с изображения по URL (GET /api/search/face?image_url=)
+// Изображение скачивается с теми же ограничениями, что и при загрузке по URL (SSRF, размер),
+// Python находит на нем лица, и embedding самого крупного лица сравнивается с центроидами
+// людей. Ничего не сохраняет: изображение удаляется после поиска
+// ?threshold= переопределяет COMPARE_MATCH_THRESHOLD, ?limit= - число людей (до 100)
+// Поиск вызывает Python синхронно, в обход очереди: одновременных поисков не больше,
+// чем воркеров очереди (остальные получают 503), а отключение клиента прерывает вызов
+func (h *Handler) HandleSearchFace(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	if h.downloader == nil {
+		respondError(c, apierror.Unavailable("Загрузка по URL отключена"))
+		return
+	}
+
+	imageURL := strings.TrimSpace(c.Query("image_url"))
+	if imageURL == "" {
+		respondError(c, apierror.Validation("Требуется параметр image_url"))
+		return
+	}
+
+	threshold, ok := h.queryThreshold(c)
+	if !ok {
+		return
+	}
+	limit, ok := queryLimit(c, defaultFaceSearchLimit, maxFaceSearchLimit)
+	if !ok {
+		return
+	}
+
+	release, ok := h.acquireSearch()
+	if !ok {
+		respondError(c, apierror.Unavailable("Слишком много одновременных поисков, повторите позже"))
+		return
+	}
+	defer release()
+
+	file, err := h.downloader.Download(imageURL)
+	if err != nil {
+		respondError(c, apierror.Validation("Не удалось скачать изображение: "+err.Error()))
+		return
+	}
+
+	// Python сохраняет присланные фото и рамки в папку задачи - используем отдельную
+	scratchID := "search-" + storage.NewTaskID()
+	defer func() {
+		if err := h.storage.DeleteTaskDirectory(scratchID); err != nil {
+			log.Printf("⚠️  Не удалось удалить временную папку %s: %v", scratchID, err)
+		}
+	}()
+
+	key, err := h.storage.SaveFile(scratchID, file.Name, bytes.NewReader(file.Data), int64(len(file.Data)))
+	if err != nil {
+		respondError(c, apierror.Internal("Ошибка сохранения изображения", err))
+		return
+	}
+
+	// Те же проверки, что и при обработке задачи: заголовок и размеры до декодирования, HEIC → JPEG
+	fileErrors := h.checkImages(scratchID, []string{key})
+	keys, fileErrors := h.convertImages(scratchID, []string{key}, fileErrors)
+	if reason, failed := fileErrors[filepath.Base(key)]; failed {
+		respondError(c, apierror.Validation(reason))
+		return
+	}
+
+	result, err := h.pythonClient.ProcessImages(c.Request.Context(), keys, scratchID, models.DefaultMinSize, models.DefaultDetThresh)
+	if err != nil {
+		respondError(c, apierror.Upstream("Ошибка поиска лиц на изображении", err))
+		return
+	}
+
+	response := models.FaceSearchResponse{
+		ImageURL:   imageURL,
+		FacesFound: len(result.FacesMetadata),
+		Threshold:  threshold,
+		Matches:    []models.FaceSearchMatch{},
+	}
+
+	faceID := largestFace(result)
+	if faceID == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	response.Bbox = result.FacesMetadata[faceID].Bbox
+
+	probe, err := embedding.Centroid([][]float64{result.Embeddings[faceID]})
+	if err != nil {
+		respondError(c, apierror.Upstream("Python вернул некорректный embedding", err))
+		return
+	}
+
+	centroids, err := h.repo.GetPersonCentroids()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	matches := findSimilarPersons(probe, centroids, threshold)
+	matches = matches[:min(len(matches), limit)]
+	if len(matches) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// Имена и обложки нужны только найденным людям
+	ids := make([]int, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	persons, err := h.repo.GetPersonsByIDs(ids)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	response.Matches = attachPersons(matches, persons)
+	for i := range response.Matches {
+		h.setCoverURL(response.Matches[i].Cover)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// acquireSearch занимает место для поиска по лицу; release освобождает его
+// ok = false - все места заняты. Без очереди (например, в тестах) не ограничивает
+func (h *Handler) acquireSearch() (release func(), ok bool) {
+	if h.searches == nil {
+		return func() {}, true
+	}
+	select {
+	case h.searches <- struct{}{}:
+		return func() { <-h.searches }, true
+	default:
+		return nil, false
+	}
+}
+
+// largestFace возвращает ID самого крупного лица с embedding ("" - лиц нет)
+// На фото для поиска обычно один человек, остальные лица - фон
+func largestFace(result *models.PythonResponse) string {
+	bestID, bestArea := "", -1
+	for faceID, metadata := range result.FacesMetadata {
+		if len(metadata.Bbox) != 4 || len(result.Embeddings[faceID]) == 0 {
+			continue
+		}
+		area := (metadata.Bbox[2] - metadata.Bbox[0]) * (metadata.Bbox[3] - metadata.Bbox[1])
+		if area > bestArea || (area == bestArea && faceID < bestID) {
+			bestID, bestArea = faceID, area
+		}
+	}
+	return bestID
+}
+
+// findSimilarPersons сравнивает единичный embedding probe с центроидами людей
+// и возвращает ID людей со сходством строго выше threshold (как при сопоставлении лиц),
+// самых похожих первыми. Имя и обложку добавляет attachPersons
+func findSimilarPersons(probe []float64, centroids map[int][]float64, threshold float64) []models.FaceSearchMatch {
+	matches := []models.FaceSearchMatch{}
+	for id, centroid := range centroids {
+		if len(centroid) != len(probe) {
+			continue // Посчитан другой моделью
+		}
+		similarity := embedding.Dot(probe, centroid)
+		if similarity <= threshold {
+			continue
+		}
+		matches = append(matches, models.FaceSearchMatch{ID: id, Similarity: min(similarity, 1)})
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+// attachPersons дополняет найденных людей именем, числом лиц и обложкой
+// Люди, удаленные между чтением центроидов и persons, из ответа выпадают
+func attachPersons(matches []models.FaceSearchMatch, persons []models.PersonWithFaces) []models.FaceSearchMatch {
+	byID := make(map[int]models.PersonWithFaces, len(persons))
+	for _, p := range persons {
+		byID[p.ID] = p
+	}
+
+	attached := make([]models.FaceSearchMatch, 0, len(matches))
+	for _, match := range matches {
+		p, ok := byID[match.ID]
+		if !ok {
+			continue
+		}
+		match.Name, match.FacesCount, match.Cover = p.Name, p.Count, p.Cover
+		attached = append(attached, match)
+	}
+	return attached
+}
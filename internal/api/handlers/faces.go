@@ -0,0 +1,417 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/models"
+	"face-recognition/pkg/embedding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleGetFace возвращает одно лицо со всеми метаданными и адресами изображений
+// Embedding по умолчанию не отдается (он большой); ?include_embedding=true добавляет его
+func (h *Handler) HandleGetFace(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	face, err := h.repo.GetFaceByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Лицо не найдено"))
+		return
+	}
+
+	details := models.FaceDetails{Face: *face}
+	if h.storage != nil {
+		details.OriginalURL = h.storage.URL(face.OriginalImage)
+		if face.AnnotatedImage != "" {
+			details.AnnotatedURL = h.storage.URL(face.AnnotatedImage)
+		}
+		if face.ThumbnailImage != "" {
+			details.ThumbnailURL = h.storage.URL(face.ThumbnailImage)
+		}
+	}
+
+	if c.Query("include_embedding") == "true" {
+		if err := json.Unmarshal(face.Embedding, &details.Vector); err != nil {
+			respondError(c, apierror.Internal(fmt.Sprintf("У лица %d нет корректного embedding", id), err))
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, details)
+}
+
+// HandleGetFaceEmbedding возвращает embedding лица вместе с моделью, которой он посчитан
+// Embedding - биометрические данные, поэтому роут закрыт AdminAuth
+func (h *Handler) HandleGetFaceEmbedding(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	face, err := h.repo.GetFaceByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Лицо не найдено"))
+		return
+	}
+
+	if len(face.Embedding) == 0 {
+		respondError(c, apierror.NotFound(fmt.Sprintf("У лица %d нет embedding", id)))
+		return
+	}
+
+	var vector []float64
+	if err := json.Unmarshal(face.Embedding, &vector); err != nil {
+		respondError(c, apierror.Internal(fmt.Sprintf("У лица %d нет корректного embedding", id), err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.FaceEmbeddingResponse{
+		FaceID:    face.ID,
+		PersonID:  face.PersonID,
+		Model:     face.EmbeddingModel,
+		Dimension: len(vector),
+		Embedding: vector,
+	})
+}
+
+// HandleCountPersonFaces возвращает только число лиц человека (GET /api/persons/:id/faces/count)
+// Для счетчиков в интерфейсе: в отличие от GET /api/persons/:id лица не загружаются
+func (h *Handler) HandleCountPersonFaces(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	count, err := h.repo.GetPersonFaceCount(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PersonFacesCountResponse{Count: count})
+}
+
+// HandleListFaces возвращает страницу лиц с уверенностью детекции в заданном диапазоне
+// Для проверки качества: ?max_confidence=0.6 находит вероятные ложные срабатывания
+// ?min_confidence= и ?max_confidence= - границы диапазона включительно (по умолчанию 0 и 1)
+// ?min_quality= - минимальная резкость лица (0 - без фильтра)
+// Лица отсортированы по возрастанию уверенности; limit/offset - как в /api/search
+func (h *Handler) HandleListFaces(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	response := models.FaceListResponse{
+		MinConfidence: 0,
+		MaxConfidence: 1,
+		Limit:         models.DefaultSearchLimit,
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value *float64
+	}{
+		{"min_confidence", &response.MinConfidence},
+		{"max_confidence", &response.MaxConfidence},
+		{"min_quality", &response.MinQuality},
+	} {
+		v := c.Query(bound.name)
+		if v == "" {
+			continue
+		}
+		confidence, err := parseFiniteFloat(v)
+		if err != nil || confidence < 0 || confidence > 1 {
+			respondError(c, apierror.Validation(bound.name+" должен быть числом в диапазоне [0, 1]"))
+			return
+		}
+		*bound.value = confidence
+	}
+
+	if response.MinConfidence > response.MaxConfidence {
+		respondError(c, apierror.Validation("min_confidence не может быть больше max_confidence"))
+		return
+	}
+
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > models.MaxSearchLimit {
+			respondError(c, apierror.Validation(fmt.Sprintf("limit должен быть числом от 1 до %d", models.MaxSearchLimit)))
+			return
+		}
+		response.Limit = limit
+	}
+
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			respondError(c, apierror.Validation("offset должен быть неотрицательным числом"))
+			return
+		}
+		response.Offset = offset
+	}
+
+	faces, total, err := h.repo.ListFaces(response.MinConfidence, response.MaxConfidence, response.MinQuality, response.Limit, response.Offset)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if faces == nil {
+		faces = []models.FaceWithPerson{}
+	}
+	response.Faces = faces
+	response.Total = total
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleCompareFaces сравнивает два лица и сообщает, один ли это человек
+// Сходство считается через h.comparer (локально в Go или в Python, см. COMPARE_BACKEND)
+// Каждое лицо задается либо face_id_a/face_id_b (embedding берется из БД),
+// либо embedding_a/embedding_b напрямую
+// ?threshold= - порог совпадения для этого сравнения в [-1, 1] (по умолчанию порог бэкенда:
+// COMPARE_MATCH_THRESHOLD для go, собственный порог Python для python)
+func (h *Handler) HandleCompareFaces(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	var threshold *float64
+	if v := c.Query("threshold"); v != "" {
+		parsed, err := parseFiniteFloat(v)
+		if err != nil || parsed < -1 || parsed > 1 {
+			respondError(c, apierror.Validation("threshold должен быть числом в диапазоне [-1, 1]"))
+			return
+		}
+		threshold = &parsed
+	}
+
+	var req models.CompareFacesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, apierror.Validation("Неверный формат запроса"))
+		return
+	}
+
+	embeddingA, err := h.resolveEmbedding("a", req.FaceIDA, req.EmbeddingA)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	embeddingB, err := h.resolveEmbedding("b", req.FaceIDB, req.EmbeddingB)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if len(embeddingA) != len(embeddingB) {
+		respondError(c, apierror.Validation(fmt.Sprintf("Размерности embedding не совпадают: %d и %d", len(embeddingA), len(embeddingB))))
+		return
+	}
+	// Одинаковые, но чужой размерности - embedding другой модели: сходство было бы бессмысленным
+	if dim := h.embeddingDim(); dim > 0 && len(embeddingA) != dim {
+		respondError(c, apierror.Validation(fmt.Sprintf("Размерность embedding %d, ожидается %d (EMBEDDING_DIM)", len(embeddingA), dim)))
+		return
+	}
+
+	// Размерности уже проверены - ошибка возможна только у Python бэкенда
+	similarity, match, err := h.comparer.Compare(embeddingA, embeddingB, threshold)
+	if err != nil {
+		respondError(c, apierror.Upstream("Ошибка сравнения embedding", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.CompareFacesResponse{
+		Similarity: similarity,
+		Match:      match,
+	})
+}
+
+// resolveEmbedding возвращает embedding одной стороны сравнения
+// Ошибки - ошибки API (VALIDATION_ERROR - неверный запрос, NOT_FOUND - лица нет)
+func (h *Handler) resolveEmbedding(side string, faceID *int, embedding []float64) ([]float64, error) {
+	if faceID != nil && embedding != nil {
+		return nil, apierror.Validation(fmt.Sprintf("Укажите либо face_id_%s, либо embedding_%s", side, side))
+	}
+
+	if faceID == nil {
+		if len(embedding) == 0 {
+			return nil, apierror.Validation(fmt.Sprintf("Требуется face_id_%s или embedding_%s", side, side))
+		}
+		return embedding, nil
+	}
+
+	face, err := h.repo.GetFaceByID(*faceID)
+	if err != nil {
+		return nil, repoError(err, fmt.Sprintf("Лицо %d не найдено", *faceID))
+	}
+
+	var stored []float64
+	if err := json.Unmarshal(face.Embedding, &stored); err != nil || len(stored) == 0 {
+		return nil, apierror.Internal(fmt.Sprintf("У лица %d нет корректного embedding", *faceID), err)
+	}
+
+	return stored, nil
+}
+
+// HandleDedupePerson находит почти одинаковые лица человека (серия снимков одного
+// момента) и оставляет по одному представителю в каждой группе
+// По умолчанию dry-run: возвращает группы, ничего не удаляя; ?apply=true удаляет
+// лишние лица и их файлы. ?threshold= переопределяет DEDUPE_THRESHOLD
+func (h *Handler) HandleDedupePerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	threshold := h.dedupeThreshold()
+	if v := c.Query("threshold"); v != "" {
+		threshold, err = parseFiniteFloat(v)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			respondError(c, apierror.Validation("threshold должен быть числом в диапазоне (0, 1]"))
+			return
+		}
+	}
+	apply := c.Query("apply") == "true"
+
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	groups := dedupeFaces(person.Faces, threshold)
+
+	var duplicates []int
+	for _, group := range groups {
+		duplicates = append(duplicates, group.Duplicates...)
+	}
+
+	response := models.DedupeResponse{
+		PersonID:  id,
+		Threshold: threshold,
+		Applied:   apply,
+		Removed:   len(duplicates),
+		Groups:    groups,
+	}
+
+	if !apply || len(duplicates) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	deleted, files, err := h.repo.DeleteFaces(id, duplicates)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+	response.Removed = len(deleted)
+
+	if err := h.storage.DeleteFiles(files); err != nil {
+		// Записи в БД уже удалены - сообщаем об ошибке в лог, клиенту отвечаем успехом
+		log.Printf("⚠️  Ошибка удаления файлов дубликатов человека %d: %v", id, err)
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
+		h.cache.InvalidateStats(h.context())
+	}
+	h.statsChanged()
+
+	h.broadcastPersonEvent(websocket.MessageTypePersonUpdated, id, person.Name)
+
+	log.Printf("🧹 Человек %d: удалено %d почти одинаковых лиц (порог %g)", id, len(deleted), threshold)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// dedupeThreshold возвращает порог сходства для dedupe из конфигурации
+func (h *Handler) dedupeThreshold() float64 {
+	if h.cfg == nil {
+		return models.DefaultDedupeThreshold
+	}
+	return h.cfg.Compare.DedupeThreshold
+}
+
+// embeddingDim возвращает ожидаемую размерность embedding (EMBEDDING_DIM, 0 - не проверяется)
+func (h *Handler) embeddingDim() int {
+	if h.cfg == nil {
+		return 0
+	}
+	return h.cfg.Compare.EmbeddingDim
+}
+
+// dedupeFaces группирует почти одинаковые лица (косинусное сходство выше threshold)
+// Представителем группы становится лицо с наибольшей уверенностью детекции
+// (при равенстве - с меньшим ID); остальные лица сравниваются только с
+// представителями, поэтому цепочка похожих лиц не склеивается в одну группу
+// Лица без корректного embedding не участвуют и никогда не удаляются
+func dedupeFaces(faces []models.Face, threshold float64) []models.DedupeGroup {
+	type candidate struct {
+		face      models.Face
+		embedding []float64
+	}
+
+	candidates := make([]candidate, 0, len(faces))
+	for _, face := range faces {
+		var vector []float64
+		if err := json.Unmarshal(face.Embedding, &vector); err != nil || len(vector) == 0 {
+			continue
+		}
+		candidates = append(candidates, candidate{face: face, embedding: vector})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].face.Confidence != candidates[j].face.Confidence {
+			return candidates[i].face.Confidence > candidates[j].face.Confidence
+		}
+		return candidates[i].face.ID < candidates[j].face.ID
+	})
+
+	groups := []models.DedupeGroup{}
+	grouped := make([]bool, len(candidates))
+	for i, representative := range candidates {
+		if grouped[i] {
+			continue
+		}
+
+		group := models.DedupeGroup{Keep: representative.face.ID}
+		for j := i + 1; j < len(candidates); j++ {
+			if grouped[j] {
+				continue
+			}
+			similarity, err := embedding.CosineSimilarity(representative.embedding, candidates[j].embedding)
+			if err != nil || similarity <= threshold {
+				continue
+			}
+			grouped[j] = true
+			group.Duplicates = append(group.Duplicates, candidates[j].face.ID)
+		}
+
+		if len(group.Duplicates) > 0 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+
+	"face-recognition/internal/models"
+)
+
+// originalNames - исходные имена файлов задачи: имя в хранилище → имя от клиента
+// Заполнено только для файлов, сохраненных под другим именем (STORAGE_FILE_NAMING=uuid/hash)
+type originalNames map[string]string
+
+// add запоминает исходное имя файла key, если в хранилище оно другое
+func (n originalNames) add(key, filename string) {
+	filename = filepath.Base(filename)
+	if name := path.Base(key); name != filename {
+		n[name] = filename
+	}
+}
+
+// renamed переносит исходные имена на новые ключи файлов (HEIC после конвертации в JPEG)
+// from и to - ключи до и после по порядку
+func (n originalNames) renamed(from, to []string) {
+	for i, key := range to {
+		if original, ok := n[path.Base(from[i])]; ok && key != from[i] {
+			n[path.Base(key)] = original
+		}
+	}
+}
+
+// lookup возвращает исходное имя файла key ("" - сохранен под своим именем)
+func (n originalNames) lookup(key string) string {
+	return n[path.Base(key)]
+}
+
+// fill проставляет исходные имена в результатах по файлам
+func (n originalNames) fill(files []models.TaskFile) []models.TaskFile {
+	for i := range files {
+		files[i].OriginalFilename = n[files[i].FileName]
+	}
+	return files
+}
+
+// saveOriginalNames сохраняет исходные имена файлов новой задачи
+// Ошибка не мешает обработке - в результатах останутся имена из хранилища
+func (h *Handler) saveOriginalNames(taskID string, names originalNames) {
+	if len(names) == 0 {
+		return
+	}
+	if err := h.repo.SaveOriginalFilenames(taskID, names); err != nil {
+		log.Printf("⚠️  Задача %s: не удалось сохранить исходные имена файлов: %v", taskID, err)
+	}
+}
+
+// loadOriginalNames читает исходные имена файлов задачи (при ошибке - пустые)
+func (h *Handler) loadOriginalNames(taskID string) originalNames {
+	names, err := h.repo.GetOriginalFilenames(taskID)
+	if err != nil {
+		log.Printf("⚠️  Задача %s: не удалось прочитать исходные имена файлов: %v", taskID, err)
+	}
+	if names == nil {
+		return originalNames{}
+	}
+	return names
+}
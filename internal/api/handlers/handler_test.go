@@ -1,16 +1,54 @@
 package handlers
 
 import (
+	"archive/zip"
 	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/middleware"
+	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/config"
 	"face-recognition/internal/models"
+	"face-recognition/internal/repository"
+	"face-recognition/internal/service/cache"
+	"face-recognition/internal/service/downloader"
+	"face-recognition/internal/service/queue"
+	"face-recognition/internal/service/storage"
+	"face-recognition/internal/service/webhook"
+	"face-recognition/pkg/embedding"
+	"face-recognition/pkg/python_client"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 // MockRepository - мок репозитория для тестов
@@ -26,11 +64,59 @@ func (m *MockRepository) GetStats() (*models.Stats, error) {
 	return args.Get(0).(*models.Stats), args.Error(1)
 }
 
+func (m *MockRepository) ReconcileStats() (models.StatsSummary, models.StatsSummary, error) {
+	args := m.Called()
+	return args.Get(0).(models.StatsSummary), args.Get(1).(models.StatsSummary), args.Error(2)
+}
+
 func (m *MockRepository) GetAllPersons() ([]models.PersonWithFaces, error) {
 	args := m.Called()
 	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
 }
 
+func (m *MockRepository) GetPersonsByIDs(ids []int) ([]models.PersonWithFaces, error) {
+	args := m.Called(ids)
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
+func (m *MockRepository) GetPersonsByTag(tag string) ([]models.PersonWithFaces, error) {
+	args := m.Called(tag)
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
+func (m *MockRepository) GetUnlabeledPersons() ([]models.PersonWithFaces, error) {
+	args := m.Called()
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
+func (m *MockRepository) AddPersonTags(personID int, tags []string) ([]string, error) {
+	args := m.Called(personID, tags)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRepository) RemovePersonTag(personID int, tag string) error {
+	args := m.Called(personID, tag)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetPersonsPage(afterID, limit int) ([]models.PersonWithFaces, error) {
+	args := m.Called(afterID, limit)
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
+func (m *MockRepository) GetPersonsAfter(cursor *models.PersonCursor, limit int) ([]models.PersonWithFaces, error) {
+	args := m.Called(cursor, limit)
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
+func (m *MockRepository) GetPersonsOffset(offset, limit int) ([]models.PersonWithFaces, error) {
+	args := m.Called(offset, limit)
+	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+}
+
 func (m *MockRepository) GetPersonByID(id int) (*models.PersonWithFaces, error) {
 	args := m.Called(id)
 	if args.Get(0) == nil {
@@ -44,17 +130,52 @@ func (m *MockRepository) UpdatePersonName(id int, name string) error {
 	return args.Error(0)
 }
 
-func (m *MockRepository) SearchPersons(query string) ([]models.PersonWithFaces, error) {
-	args := m.Called(query)
-	return args.Get(0).([]models.PersonWithFaces), args.Error(1)
+func (m *MockRepository) LinkTaskPerson(taskID string, personID int, created bool) error {
+	return m.Called(taskID, personID, created).Error(0)
+}
+
+func (m *MockRepository) GetPersonsByTask(taskID string) ([]models.TaskPerson, error) {
+	args := m.Called(taskID)
+	return args.Get(0).([]models.TaskPerson), args.Error(1)
+}
+
+func (m *MockRepository) LabelTaskPersons(taskID string, labels map[string]string) ([]models.LabeledPerson, []string, error) {
+	args := m.Called(taskID, labels)
+	return args.Get(0).([]models.LabeledPerson), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockRepository) UpdatePersonCover(personID, faceID int) (*models.Person, error) {
+	args := m.Called(personID, faceID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *MockRepository) SearchPersons(query string, opts models.SearchOptions) ([]models.PersonWithFaces, int, error) {
+	args := m.Called(query, opts)
+	return args.Get(0).([]models.PersonWithFaces), args.Int(1), args.Error(2)
 }
 
 // Остальные методы для полноты интерфейса
-func (m *MockRepository) CreateTask(taskID string, totalImages int) error {
-	args := m.Called(taskID, totalImages)
+// WithContext возвращает тот же мок - ожидания не зависят от контекста
+func (m *MockRepository) WithContext(ctx context.Context) repository.RepositoryInterface {
+	return m
+}
+
+func (m *MockRepository) CreateTask(task *models.Task) error {
+	args := m.Called(task)
 	return args.Error(0)
 }
 
+func (m *MockRepository) GetCompletedTaskByHash(contentHash string) (*models.Task, error) {
+	args := m.Called(contentHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Task), args.Error(1)
+}
+
 func (m *MockRepository) GetTask(taskID string) (*models.Task, error) {
 	args := m.Called(taskID)
 	if args.Get(0) == nil {
@@ -68,32 +189,270 @@ func (m *MockRepository) UpdateTaskStatus(taskID, status string, errorMsg *strin
 	return args.Error(0)
 }
 
-func (m *MockRepository) UpdateTaskStats(taskID string, totalFaces, uniquePersons int) error {
-	args := m.Called(taskID, totalFaces, uniquePersons)
+func (m *MockRepository) MarkTaskStarted(taskID string) error {
+	args := m.Called(taskID)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdateTaskStats(taskID string, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces int) error {
+	args := m.Called(taskID, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdateTaskWarnings(taskID string, warnings []string) error {
+	args := m.Called(taskID, warnings)
+	return args.Error(0)
+}
+
+func (m *MockRepository) UpdateTaskProgress(taskID string, percent int, stage string) error {
+	args := m.Called(taskID, percent, stage)
+	return args.Error(0)
+}
+
+func (m *MockRepository) SaveTaskFiles(taskID string, files []models.TaskFile) error {
+	args := m.Called(taskID, files)
 	return args.Error(0)
 }
 
-func (m *MockRepository) GetOrCreatePerson(name string) (int, error) {
+func (m *MockRepository) SaveOriginalFilenames(taskID string, names map[string]string) error {
+	args := m.Called(taskID, names)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetOriginalFilenames(taskID string) (map[string]string, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[string]string), args.Error(1)
+}
+
+func (m *MockRepository) GetTaskFiles(taskID string) ([]models.TaskFile, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.TaskFile), args.Error(1)
+}
+
+func (m *MockRepository) ResetTaskForReprocess(taskID string, params models.DetectionParams) ([]models.Person, []models.Face, error) {
+	args := m.Called(taskID, params)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).([]models.Person), args.Get(1).([]models.Face), args.Error(2)
+}
+
+func (m *MockRepository) DeleteTaskFaces(taskID string) ([]models.Person, []models.Face, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).([]models.Person), args.Get(1).([]models.Face), args.Error(2)
+}
+
+func (m *MockRepository) DeleteTask(taskID string) (*models.Task, []models.Person, []models.Face, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, nil, nil, args.Error(3)
+	}
+	return args.Get(0).(*models.Task), args.Get(1).([]models.Person), args.Get(2).([]models.Face), args.Error(3)
+}
+
+func (m *MockRepository) GetInterruptedTasks() ([]models.Task, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Task), args.Error(1)
+}
+
+func (m *MockRepository) RequeueInterruptedTask(taskID string) ([]models.Person, []models.Face, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).([]models.Person), args.Get(1).([]models.Face), args.Error(2)
+}
+
+func (m *MockRepository) GetTaskFaces(taskID string) ([]models.Face, error) {
+	args := m.Called(taskID)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Face), args.Error(1)
+}
+
+func (m *MockRepository) GetTaskIDs() ([]string, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
+func (m *MockRepository) GetRecentTasks(limit int) ([]models.Task, error) {
+	args := m.Called(limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.Task), args.Error(1)
+}
+
+func (m *MockRepository) GetOrCreatePerson(name string) (int, bool, error) {
 	args := m.Called(name)
-	return args.Int(0), args.Error(1)
+	return args.Int(0), args.Bool(1), args.Error(2)
 }
 
-func (m *MockRepository) DeletePerson(id int) ([]models.Face, error) {
+func (m *MockRepository) MarkPersonLabeled(id int) error {
 	args := m.Called(id)
-	return args.Get(0).([]models.Face), args.Error(1)
+	return args.Error(0)
+}
+
+func (m *MockRepository) DeletePerson(id int) (*models.Person, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *MockRepository) RestorePerson(id int) (*models.Person, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Person), args.Error(1)
+}
+
+func (m *MockRepository) HardDeletePerson(id int) (*models.Person, []string, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, nil, args.Error(2)
+	}
+	return args.Get(0).(*models.Person), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockRepository) DeletePersons(ids []int) ([]models.Person, error) {
+	args := m.Called(ids)
+	return args.Get(0).([]models.Person), args.Error(1)
+}
+
+func (m *MockRepository) HardDeletePersons(ids []int) ([]models.Person, []string, error) {
+	args := m.Called(ids)
+	return args.Get(0).([]models.Person), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockRepository) CreateFace(face *models.Face) error {
+	args := m.Called(face)
+	return args.Error(0)
+}
+
+func (m *MockRepository) GetFaceByID(id int) (*models.Face, error) {
+	args := m.Called(id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*models.Face), args.Error(1)
+}
+
+func (m *MockRepository) CountPersonFaces(personID int) (int, error) {
+	args := m.Called(personID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) GetPersonFaceCount(personID int) (int, error) {
+	args := m.Called(personID)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockRepository) ListFaces(minConf, maxConf, minQuality float64, limit, offset int) ([]models.FaceWithPerson, int, error) {
+	args := m.Called(minConf, maxConf, minQuality, limit, offset)
+	return args.Get(0).([]models.FaceWithPerson), args.Int(1), args.Error(2)
+}
+
+func (m *MockRepository) GetPersonAudit(personID int) ([]models.PersonAuditEntry, error) {
+	args := m.Called(personID)
+	return args.Get(0).([]models.PersonAuditEntry), args.Error(1)
+}
+
+func (m *MockRepository) GetPersonCentroids() (map[int][]float64, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[int][]float64), args.Error(1)
+}
+
+func (m *MockRepository) UpdatePersonCentroid(personID int) error {
+	args := m.Called(personID)
+	return args.Error(0)
 }
 
-func (m *MockRepository) CreateFace(personID int, imagePath string, embedding []float64, confidence float64) error {
-	args := m.Called(personID, imagePath, embedding, confidence)
+func (m *MockRepository) UpdateFaceEmbedding(faceID int, embedding []byte, model string) error {
+	args := m.Called(faceID, embedding, model)
 	return args.Error(0)
 }
 
+func (m *MockRepository) DeleteFaces(personID int, faceIDs []int) ([]models.Face, []string, error) {
+	args := m.Called(personID, faceIDs)
+	return args.Get(0).([]models.Face), args.Get(1).([]string), args.Error(2)
+}
+
+func (m *MockRepository) GetEmbeddingsPage(afterFaceID, limit int) ([]models.LabeledEmbedding, error) {
+	args := m.Called(afterFaceID, limit)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]models.LabeledEmbedding), args.Error(1)
+}
+
+func (m *MockRepository) GetFaceFileKeys() ([]string, error) {
+	args := m.Called()
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]string), args.Error(1)
+}
+
 func (m *MockRepository) SaveFacesTransaction(clusters map[string][]string, embeddings map[string][]float64) (int, int, error) {
 	args := m.Called(clusters, embeddings)
 	return args.Int(0), args.Int(1), args.Error(2)
 }
 
+// newTestStorage создает storage service на временной папке
+func newTestStorage(t *testing.T) *storage.Service {
+	backend, err := storage.NewLocalBackend(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := storage.NewService(backend, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return service
+}
+
+// testJPEG - маленький корректный JPEG для файлов задач: пустые и битые файлы
+// отклоняются до отправки в Python
+var testJPEG = func() []byte {
+	var data bytes.Buffer
+	if err := jpeg.Encode(&data, image.NewGray(image.Rect(0, 0, 4, 4)), nil); err != nil {
+		panic(err)
+	}
+	return data.Bytes()
+}()
+
 // setupTestRouter создает тестовый роутер
+// assertErrorCode проверяет машиночитаемый код ответа с ошибкой
+func assertErrorCode(t *testing.T, w *httptest.ResponseRecorder, code string) {
+	t.Helper()
+	var resp models.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, code, resp.Code)
+	assert.NotEmpty(t, resp.Error)
+}
+
 func setupTestRouter() *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	return gin.New()
@@ -135,76 +494,544 @@ func TestHandleGetStats(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
-func TestHandleGetPersons(t *testing.T) {
+func TestHandleGetStatsConditional(t *testing.T) {
 	mockRepo := new(MockRepository)
 	handler := &Handler{repo: mockRepo}
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 10}, nil)
 
-	expectedPersons := []models.PersonWithFaces{
-		{
-			Person: models.Person{ID: 1, Name: "John Doe"},
-			Count:  3,
-		},
-		{
-			Person: models.Person{ID: 2, Name: "Jane Smith"},
-			Count:  5,
-		},
+	router := setupTestRouter()
+	router.GET("/stats", handler.HandleGetStats)
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/stats", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
 	}
 
-	mockRepo.On("GetAllPersons").Return(expectedPersons, nil)
+	first := get("", "")
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+	assert.Empty(t, first.Header().Get("Last-Modified"), "у статистики нет времени изменения")
 
-	router := setupTestRouter()
-	router.GET("/persons", handler.HandleGetPersons)
+	w := get("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, etag, w.Header().Get("ETag"))
 
-	req, _ := http.NewRequest("GET", "/persons", nil)
-	w := httptest.NewRecorder()
+	assert.Equal(t, http.StatusNotModified, get("If-None-Match", `"other", W/`+etag).Code, "слабый валидатор в списке")
+	assert.Equal(t, http.StatusOK, get("If-None-Match", `"other"`).Code)
+	assert.Equal(t, http.StatusOK, get("If-Modified-Since", time.Now().UTC().Format(http.TimeFormat)).Code)
+}
 
-	router.ServeHTTP(w, req)
+func TestHandleGetPersonConditional(t *testing.T) {
+	updated := time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC)
+	detected := updated.Add(time.Hour)
 
-	assert.Equal(t, http.StatusOK, w.Code)
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+	mockRepo.On("GetPersonByID", 1).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Анна", UpdatedAt: updated},
+		Faces:  []models.Face{{ID: 5, PersonID: 1, DetectedAt: detected}},
+		Count:  1,
+	}, nil)
 
-	var persons []models.PersonWithFaces
-	err := json.Unmarshal(w.Body.Bytes(), &persons)
-	assert.NoError(t, err)
-	assert.Len(t, persons, 2)
-	assert.Equal(t, "John Doe", persons[0].Name)
-	assert.Equal(t, 3, persons[0].Count)
+	router := setupTestRouter()
+	router.GET("/persons/:id", handler.HandleGetPerson)
 
-	mockRepo.AssertExpectations(t)
+	get := func(headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/persons/1", nil)
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get(nil)
+	require.Equal(t, http.StatusOK, first.Code)
+	etag := first.Header().Get("ETag")
+	// Последнее лицо добавлено позже изменения самого человека
+	assert.Equal(t, detected.Format(http.TimeFormat), first.Header().Get("Last-Modified"))
+
+	cases := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"ETag совпадает", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+		{"ETag не совпадает", map[string]string{"If-None-Match": `"stale"`}, http.StatusOK},
+		{"не изменялся с Last-Modified", map[string]string{"If-Modified-Since": detected.Format(http.TimeFormat)}, http.StatusNotModified},
+		{"изменился после If-Modified-Since", map[string]string{"If-Modified-Since": updated.Format(http.TimeFormat)}, http.StatusOK},
+		{"неверная дата", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+		// If-None-Match важнее If-Modified-Since
+		{"ETag не совпадает при свежей дате", map[string]string{
+			"If-None-Match":     `"stale"`,
+			"If-Modified-Since": detected.Format(http.TimeFormat),
+		}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := get(tc.headers)
+			assert.Equal(t, tc.want, w.Code)
+			if tc.want == http.StatusNotModified {
+				assert.Empty(t, w.Body.String())
+			} else {
+				assert.Equal(t, first.Body.String(), w.Body.String())
+			}
+		})
+	}
 }
 
-func TestHandleUpdatePerson(t *testing.T) {
+func TestHandleGetPersonMinQuality(t *testing.T) {
+	sharp, blurry := 0.8, 0.2
 	mockRepo := new(MockRepository)
-	handler := &Handler{repo: mockRepo}
-
-	newName := "Updated Name"
-	mockRepo.On("UpdatePersonName", 1, newName).Return(nil)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+	mockRepo.On("GetPersonByID", 1).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Анна"},
+		Faces: []models.Face{
+			{ID: 5, PersonID: 1, Quality: &sharp},
+			{ID: 6, PersonID: 1, Quality: &blurry},
+			{ID: 7, PersonID: 1}, // Сохранено до оценки резкости
+		},
+		Count: 3,
+	}, nil)
 
 	router := setupTestRouter()
-	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+	router.GET("/persons/:id", handler.HandleGetPerson)
 
-	reqBody := models.UpdatePersonRequest{Name: newName}
-	jsonBody, _ := json.Marshal(reqBody)
+	get := func(url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
 
-	req, _ := http.NewRequest("PUT", "/persons/1", bytes.NewBuffer(jsonBody))
-	req.Header.Set("Content-Type", "application/json")
-	w := httptest.NewRecorder()
+	w := get("/persons/1?min_quality=0.5")
+	require.Equal(t, http.StatusOK, w.Code)
+	var person models.PersonWithFaces
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	require.Len(t, person.Faces, 1)
+	assert.Equal(t, 5, person.Faces[0].ID)
+	assert.Equal(t, 3, person.Count)
 
-	router.ServeHTTP(w, req)
+	// Без порога - все лица
+	w = get("/persons/1")
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	assert.Len(t, person.Faces, 3)
 
-	assert.Equal(t, http.StatusOK, w.Code)
+	for _, url := range []string{"/persons/1?min_quality=abc", "/persons/1?min_quality=1.5", "/persons/1?min_quality=NaN"} {
+		assert.Equal(t, http.StatusBadRequest, get(url).Code, url)
+	}
+}
 
-	var response map[string]interface{}
-	err := json.Unmarshal(w.Body.Bytes(), &response)
-	assert.NoError(t, err)
-	assert.Equal(t, "Имя обновлено", response["message"])
-	assert.Equal(t, newName, response["name"])
+// timedOutCacheBackend - бэкенд кэша, каждая операция которого упирается в таймаут
+type timedOutCacheBackend struct{}
 
-	mockRepo.AssertExpectations(t)
+func (timedOutCacheBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	return nil, false, context.DeadlineExceeded
 }
 
-func TestHandleSearch(t *testing.T) {
-	mockRepo := new(MockRepository)
-	handler := &Handler{repo: mockRepo}
+func (timedOutCacheBackend) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	return context.DeadlineExceeded
+}
+
+func (timedOutCacheBackend) Delete(ctx context.Context, keys ...string) error {
+	return context.DeadlineExceeded
+}
+
+func (timedOutCacheBackend) Flush(ctx context.Context) error { return context.DeadlineExceeded }
+
+func (timedOutCacheBackend) Close() error { return nil }
+
+func TestHandleGetPersonCacheTimeoutFallsBackToDB(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cache: cache.NewService(timedOutCacheBackend{})}
+	mockRepo.On("GetPersonByID", 1).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Анна"},
+	}, nil)
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 1}, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons/:id", handler.HandleGetPerson)
+	router.GET("/stats", handler.HandleGetStats)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/persons/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Анна")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/stats", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandlePersonCacheCancelledRequest(t *testing.T) {
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	require.NoError(t, cacheService.SetPerson(context.Background(), &models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Из кэша"},
+	}))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cache: cacheService}
+	mockRepo.On("GetPersonByID", 1).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Из БД"},
+	}, nil)
+	mockRepo.On("UpdatePersonName", 1, "Анна").Return(nil)
+
+	router := setupTestRouter()
+	router.GET("/persons/:id", handler.HandleGetPerson)
+	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Чтение кэша прерывается вместе с запросом
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/persons/1", nil).WithContext(ctx))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "Из БД")
+
+	// Инвалидация после изменения в БД выполняется, даже если клиент уже отключился
+	req := httptest.NewRequest("PUT", "/persons/1", strings.NewReader(`{"name": "Анна"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	cached, err := cacheService.GetPerson(context.Background(), 1)
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleGetPersons(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	expectedPersons := []models.PersonWithFaces{
+		{
+			Person: models.Person{ID: 1, Name: "John Doe"},
+			Count:  3,
+		},
+		{
+			Person: models.Person{ID: 2, Name: "Jane Smith"},
+			Count:  5,
+		},
+	}
+
+	mockRepo.On("GetAllPersons").Return(expectedPersons, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	req, _ := http.NewRequest("GET", "/persons", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var persons []models.PersonWithFaces
+	err := json.Unmarshal(w.Body.Bytes(), &persons)
+	assert.NoError(t, err)
+	assert.Len(t, persons, 2)
+	assert.Equal(t, "John Doe", persons[0].Name)
+	assert.Equal(t, 3, persons[0].Count)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleGetPersonsCompact(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetAllPersons").Return([]models.PersonWithFaces{
+		{
+			Person: models.Person{ID: 1, Name: "John Doe", CreatedAt: time.Now(), UpdatedAt: time.Now()},
+			Count:  3,
+			Cover:  &models.CoverFace{FaceID: 7, AnnotatedImage: "task-1/face_0_boxed.jpg"},
+		},
+		{Person: models.Person{ID: 2, Name: "Jane Smith"}, Count: 5},
+	}, nil)
+	mockRepo.On("GetPersonsByTag", "family").Return([]models.PersonWithFaces(nil), nil)
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/persons?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Только id, name и faces_count - без дат, обложки и faces
+	w := get("fields=compact")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[
+		{"id": 1, "name": "John Doe", "faces_count": 3},
+		{"id": 2, "name": "Jane Smith", "faces_count": 5}
+	]`, w.Body.String())
+
+	// Пустой список - массив, а не null
+	w = get("fields=compact&tag=family")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `[]`, w.Body.String())
+
+	// Явный full - прежний формат
+	w = get("fields=full")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"faces"`)
+	assert.Contains(t, w.Body.String(), `"created_at"`)
+
+	w = get("fields=ids")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandleGetPersonsCursorStableAcrossInserts(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	// "Таблица" людей: GetPersonsAfter отбирает из нее так же, как запрос в БД
+	base := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var db []models.PersonWithFaces
+	insert := func(id int, createdAt time.Time) {
+		db = append([]models.PersonWithFaces{{Person: models.Person{ID: id, Name: fmt.Sprintf("person_%d", id), CreatedAt: createdAt}}}, db...)
+	}
+	for id := 1; id <= 5; id++ {
+		// У 3, 4 и 5 одинаковый created_at - порядок между ними задает id
+		insert(id, base.Add(time.Duration(min(id, 3))*time.Minute))
+	}
+	// Отбор как в БД: (created_at, id) < курсора, новые первыми
+	pageAfter := func(cursor *models.PersonCursor, limit int) []models.PersonWithFaces {
+		page := []models.PersonWithFaces{}
+		for _, p := range db {
+			if cursor != nil && !p.CreatedAt.Before(cursor.CreatedAt) &&
+				!(p.CreatedAt.Equal(cursor.CreatedAt) && p.ID < cursor.ID) {
+				continue
+			}
+			if len(page) < limit {
+				page = append(page, p)
+			}
+		}
+		return page
+	}
+	// Следующая страница запрашивается после последнего показанного человека
+	expectPage := func(cursor *models.PersonCursor) {
+		mockRepo.On("GetPersonsAfter", cursor, 2).Return(pageAfter(cursor, 2), nil).Once()
+	}
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	get := func(query string) models.PersonPage {
+		req, _ := http.NewRequest("GET", "/persons?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var page struct {
+			Persons    []models.PersonWithFaces `json:"persons"`
+			NextCursor string                   `json:"next_cursor"`
+			Limit      int                      `json:"limit"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		return models.PersonPage{Persons: page.Persons, NextCursor: page.NextCursor, Limit: page.Limit}
+	}
+	ids := func(page models.PersonPage) []int {
+		var ids []int
+		for _, p := range page.Persons.([]models.PersonWithFaces) {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	expectPage(nil)
+	page := get("limit=2")
+	assert.Equal(t, []int{5, 4}, ids(page))
+	assert.Equal(t, 2, page.Limit)
+	require.NotEmpty(t, page.NextCursor)
+
+	// Новые люди между страницами не сдвигают следующую (offset дал бы 4 повторно)
+	insert(6, base.Add(time.Hour))
+	insert(7, base.Add(time.Hour))
+
+	expectPage(&models.PersonCursor{CreatedAt: base.Add(3 * time.Minute), ID: 4})
+	page = get("limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{3, 2}, ids(page))
+	require.NotEmpty(t, page.NextCursor)
+
+	expectPage(&models.PersonCursor{CreatedAt: base.Add(2 * time.Minute), ID: 2})
+	page = get("limit=2&cursor=" + page.NextCursor)
+	assert.Equal(t, []int{1}, ids(page))
+	assert.Empty(t, page.NextCursor)
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleGetPersonsPageParams(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetPersonsOffset", 10, models.DefaultSearchLimit).Return([]models.PersonWithFaces{
+		{Person: models.Person{ID: 3, Name: "Carol"}, Count: 2},
+	}, nil)
+	mockRepo.On("GetPersonsAfter", (*models.PersonCursor)(nil), 1).Return([]models.PersonWithFaces{
+		{Person: models.Person{ID: 3, Name: "Carol"}, Count: 2},
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/persons?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Устаревший offset: страница без курсора, если она неполная
+	w := get("offset=10")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"persons": [{"id": 3, "name": "Carol", "faces_count": 2, "created_at": "0001-01-01T00:00:00Z", "updated_at": "0001-01-01T00:00:00Z", "faces": null}], "limit": 50}`, w.Body.String())
+
+	// Компактный формат внутри страницы
+	w = get("limit=1&fields=compact")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page struct {
+		Persons    []map[string]interface{} `json:"persons"`
+		NextCursor string                   `json:"next_cursor"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, []map[string]interface{}{{"id": 3.0, "name": "Carol", "faces_count": 2.0}}, page.Persons)
+	cursor, err := models.ParsePersonCursor(page.NextCursor)
+	require.NoError(t, err)
+	assert.Equal(t, 3, cursor.ID)
+
+	for _, query := range []string{
+		"cursor=abc&offset=5",
+		"cursor=not-a-cursor",
+		"cursor=" + base64.RawURLEncoding.EncodeToString([]byte(`{"id":0}`)),
+		"limit=0",
+		"limit=201",
+		"offset=-1",
+		"tag=staff&limit=10",
+	} {
+		assert.Equal(t, http.StatusBadRequest, get(query).Code, query)
+	}
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUpdatePerson(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	newName := "Updated Name"
+	mockRepo.On("UpdatePersonName", 1, newName).Return(nil)
+
+	router := setupTestRouter()
+	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+	reqBody := models.UpdatePersonRequest{Name: newName}
+	jsonBody, _ := json.Marshal(reqBody)
+
+	req, _ := http.NewRequest("PUT", "/persons/1", bytes.NewBuffer(jsonBody))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, "Имя обновлено", response["message"])
+	assert.Equal(t, newName, response["name"])
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUpdatePersonTrimsName(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("UpdatePersonName", 1, "Анна Иванова").Return(nil)
+
+	router := setupTestRouter()
+	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+	req, _ := http.NewRequest("PUT", "/persons/1", strings.NewReader(`{"name": "  Анна Иванова\t"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"Анна Иванова"`)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUpdatePersonInvalidName(t *testing.T) {
+	cfg := &config.Config{Persons: config.PersonsConfig{MaxNameLength: 10}}
+
+	tests := []struct {
+		name    string
+		value   string
+		message string
+	}{
+		{"whitespace only", "   \t ", "пустым"},
+		{"over length", "Очень длинное имя", "длиннее 10 символов"},
+		{"control character", "Anna\u0000", "недопустимый символ"},
+		{"newline inside", "An\nna", "недопустимый символ"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			handler := &Handler{repo: mockRepo, cfg: cfg}
+
+			router := setupTestRouter()
+			router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+			body, _ := json.Marshal(models.UpdatePersonRequest{Name: tt.value})
+			req, _ := http.NewRequest("PUT", "/persons/1", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Contains(t, w.Body.String(), tt.message)
+			mockRepo.AssertNotCalled(t, "UpdatePersonName", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestNormalizePersonNameLength(t *testing.T) {
+	handler := &Handler{cfg: &config.Config{Persons: config.PersonsConfig{MaxNameLength: 5}}}
+
+	// Длина считается в символах, а не в байтах
+	name, err := handler.normalizePersonName("Ёжик")
+	require.NoError(t, err)
+	assert.Equal(t, "Ёжик", name)
+
+	_, err = handler.normalizePersonName("Ёжики!")
+	assert.Error(t, err)
+
+	// Без конфигурации - ограничение по умолчанию
+	_, err = (&Handler{}).normalizePersonName(strings.Repeat("a", models.DefaultMaxPersonNameLength+1))
+	assert.Error(t, err)
+}
+
+func TestHandleSearch(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
 
 	query := "John"
 	expectedResults := []models.PersonWithFaces{
@@ -214,7 +1041,10 @@ func TestHandleSearch(t *testing.T) {
 		},
 	}
 
-	mockRepo.On("SearchPersons", query).Return(expectedResults, nil)
+	mockRepo.On("SearchPersons", query, models.SearchOptions{
+		MinSimilarity: models.DefaultMinSimilarity,
+		Limit:         models.DefaultSearchLimit,
+	}).Return(expectedResults, 1, nil)
 
 	router := setupTestRouter()
 	router.GET("/search", handler.HandleSearch)
@@ -226,11 +1056,13 @@ func TestHandleSearch(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
-	var results []models.PersonWithFaces
+	var results models.SearchResponse
 	err := json.Unmarshal(w.Body.Bytes(), &results)
 	assert.NoError(t, err)
-	assert.Len(t, results, 1)
-	assert.Equal(t, "John Doe", results[0].Name)
+	assert.Len(t, results.Persons, 1)
+	assert.Equal(t, "John Doe", results.Persons[0].Name)
+	assert.Equal(t, 1, results.Total)
+	assert.Equal(t, models.DefaultSearchLimit, results.Limit)
 
 	mockRepo.AssertExpectations(t)
 }
@@ -254,3 +1086,6727 @@ func TestHandleSearchMissingQuery(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "Параметр q обязателен", response.Error)
 }
+
+// newUploadRequest создает multipart запрос на загрузку с одним файлом и полями формы
+func newUploadRequest(t *testing.T, fields map[string]string) *http.Request {
+	return newUploadRequestWithFiles(t, []string{"fake image"}, fields)
+}
+
+// newUploadRequestWithFiles создает multipart запрос с файлами заданного содержимого
+func newUploadRequestWithFiles(t *testing.T, contents []string, fields map[string]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for i, content := range contents {
+		part, err := writer.CreateFormFile("images", fmt.Sprintf("photo%d.jpg", i))
+		assert.NoError(t, err)
+		part.Write([]byte(content))
+	}
+
+	for key, value := range fields {
+		writer.WriteField(key, value)
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/upload", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestHandleUploadInvalidDetectionParams(t *testing.T) {
+	cases := []struct {
+		name   string
+		fields map[string]string
+	}{
+		{"det_thresh above 1", map[string]string{"det_thresh": "1.5"}},
+		{"det_thresh zero", map[string]string{"det_thresh": "0"}},
+		{"det_thresh negative", map[string]string{"det_thresh": "-0.2"}},
+		{"det_thresh not a number", map[string]string{"det_thresh": "abc"}},
+		{"det_thresh NaN", map[string]string{"det_thresh": "NaN"}},
+		{"det_thresh infinity", map[string]string{"det_thresh": "Inf"}},
+		{"min_size zero", map[string]string{"min_size": "0"}},
+		{"min_size too large", map[string]string{"min_size": "5000"}},
+		{"min_size not an integer", map[string]string{"min_size": "12.5"}},
+		{"min_confidence above 1", map[string]string{"min_confidence": "1.1"}},
+		{"min_confidence negative", map[string]string{"min_confidence": "-0.1"}},
+		{"min_confidence not a number", map[string]string{"min_confidence": "high"}},
+		{"min_confidence NaN", map[string]string{"min_confidence": "NaN"}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			handler := &Handler{repo: mockRepo}
+
+			router := setupTestRouter()
+			router.POST("/upload", handler.HandleUpload)
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, newUploadRequest(t, tc.fields))
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+
+			var response models.ErrorResponse
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, response.Error)
+
+			// Задача не должна создаваться
+			mockRepo.AssertNotCalled(t, "CreateTask")
+		})
+	}
+}
+
+func TestParseDetectionParams(t *testing.T) {
+	// Без полей - значения по умолчанию
+	params, err := parseDetectionParams(map[string][]string{})
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultDetectionParams(), params)
+
+	// Переданные значения применяются
+	params, err = parseDetectionParams(map[string][]string{
+		"min_size":   {"64"},
+		"det_thresh": {"0.75"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 64, params.MinSize)
+	assert.Equal(t, 0.75, params.DetThresh)
+
+	// Верхняя граница det_thresh включительно
+	params, err = parseDetectionParams(map[string][]string{
+		"det_thresh": {"1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, params.DetThresh)
+
+	// Пустые значения игнорируются
+	params, err = parseDetectionParams(map[string][]string{
+		"min_size": {""},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.DefaultMinSize, params.MinSize)
+
+	// Границы min_confidence включительно
+	params, err = parseDetectionParams(map[string][]string{
+		"min_confidence": {"0"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, params.MinConfidence)
+
+	params, err = parseDetectionParams(map[string][]string{
+		"min_confidence": {"1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, params.MinConfidence)
+}
+
+func TestDefaultDetectionParamsFromConfig(t *testing.T) {
+	handler := &Handler{}
+	assert.Equal(t, models.DefaultDetectionParams(), handler.defaultDetectionParams())
+
+	handler.cfg = &config.Config{Detection: config.DetectionConfig{MinConfidence: 0.7}}
+	assert.Equal(t, 0.7, handler.defaultDetectionParams().MinConfidence)
+
+	// Значение из формы важнее конфигурации
+	params, err := parseDetectionParamsWithBase(map[string][]string{
+		"min_confidence": {"0.3"},
+	}, handler.defaultDetectionParams())
+	assert.NoError(t, err)
+	assert.Equal(t, 0.3, params.MinConfidence)
+}
+
+func TestFilterByConfidence(t *testing.T) {
+	metadata := map[string]models.FaceMetadata{
+		"face_1": {Confidence: 0.9},
+		"face_2": {Confidence: 0.4},
+		"face_3": {Confidence: 0.5},
+	}
+
+	// Без порога ничего не отбрасывается
+	kept, rejected := filterByConfidence([]string{"face_1", "face_2"}, metadata, 0)
+	assert.Equal(t, []string{"face_1", "face_2"}, kept)
+	assert.Equal(t, 0, rejected)
+
+	// Лицо ровно на пороге остается, лицо без метаданных тоже
+	kept, rejected = filterByConfidence([]string{"face_1", "face_2", "face_3", "face_x"}, metadata, 0.5)
+	assert.Equal(t, []string{"face_1", "face_3", "face_x"}, kept)
+	assert.Equal(t, 1, rejected)
+}
+
+func TestHandleBulkDeletePersons(t *testing.T) {
+	mockRepo := new(MockRepository)
+	store := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	// Групповое фото нужно лицам других людей - репозиторий его не возвращает, оно остается
+	for _, key := range []string{"task-1/group.jpg", "task-1/a_boxed.jpg"} {
+		require.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	}
+	// Дубликат id=1 должен быть убран до вызова репозитория
+	deleted := []models.Person{{ID: 1, Name: "Alice"}, {ID: 3, Name: "Bob"}}
+	mockRepo.On("HardDeletePersons", []int{1, 2, 3}).Return(deleted, []string{"task-1/a_boxed.jpg"}, nil)
+
+	router := setupTestRouter()
+	router.POST("/persons/bulk-delete", handler.HandleBulkDeletePersons)
+
+	body, _ := json.Marshal(models.BulkDeleteRequest{IDs: []int{1, 2, 1, 3}})
+	req, _ := http.NewRequest("POST", "/persons/bulk-delete?hard=true", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.BulkDeleteResponse
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, response.Deleted)
+	assert.Equal(t, []int{2}, response.NotFound)
+
+	exists, _ := store.Backend().Exists("task-1/group.jpg")
+	assert.True(t, exists, "общий исходник не удаляется")
+	exists, _ = store.Backend().Exists("task-1/a_boxed.jpg")
+	assert.False(t, exists)
+
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "DeletePersons", mock.Anything)
+}
+
+func TestHandleBulkDeletePersonsSoftByDefault(t *testing.T) {
+	mockRepo := new(MockRepository)
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	// Человек 2 не найден или уже удален - его нет в ответе репозитория
+	mockRepo.On("DeletePersons", []int{1, 2}).Return([]models.Person{{ID: 1, Name: "noise"}}, nil)
+
+	router := setupTestRouter()
+	router.POST("/persons/bulk-delete", handler.HandleBulkDeletePersons)
+
+	body, _ := json.Marshal(models.BulkDeleteRequest{IDs: []int{1, 2}})
+	req, _ := http.NewRequest("POST", "/persons/bulk-delete", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.BulkDeleteResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.BulkDeleteResponse{Deleted: 1, NotFound: []int{2}}, response)
+
+	// Soft-delete не трогает файлы: человека можно восстановить
+	assert.True(t, store.FileExists("task-1/a.jpg"))
+	mockRepo.AssertNotCalled(t, "HardDeletePersons", mock.Anything)
+}
+
+func TestHandleTaskPersons(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetPersonsByTask", "task-1").Return([]models.TaskPerson{
+		{Person: models.Person{ID: 1, Name: "cluster_0"}, Created: true, TaskFaces: 3},
+		// Создан раньше другой задачей, эта только добавила лицо
+		{Person: models.Person{ID: 7, Name: "Alice"}, Created: false, TaskFaces: 1},
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/task/:id/persons", handler.HandleTaskPersons)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/task/task-1/persons", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.TaskPersonsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "task-1", response.TaskID)
+	assert.Equal(t, models.TaskStatusCompleted, response.Status)
+	require.Len(t, response.Persons, 2)
+	assert.True(t, response.Persons[0].Created)
+	assert.Equal(t, 3, response.Persons[0].TaskFaces)
+	assert.Equal(t, "Alice", response.Persons[1].Name)
+	assert.False(t, response.Persons[1].Created)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/task/missing/persons", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleLabelTask(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, cache: mockCache, wsManager: manager}
+
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	// Имена приводятся к каноническому виду до репозитория
+	mockRepo.On("LabelTaskPersons", "task-1", map[string]string{"cluster_5": "Alice", "cluster_9": "Bob"}).
+		Return([]models.LabeledPerson{{ID: 40, OldName: "cluster_5", Name: "Alice"}}, []string{"cluster_9"}, nil)
+	mockCache.On("InvalidatePerson", 40).Return(nil)
+	mockCache.On("InvalidateStats").Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/task/:id/label", handler.HandleLabelTask)
+
+	req, _ := http.NewRequest("POST", "/task/task-1/label",
+		bytes.NewBufferString(`{"labels": {"cluster_5": " Alice ", "cluster_9": "Bob"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.TaskLabelResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "task-1", response.TaskID)
+	assert.Equal(t, []models.LabeledPerson{{ID: 40, OldName: "cluster_5", Name: "Alice"}}, response.Renamed)
+	assert.Equal(t, []string{"cluster_9"}, response.Unknown)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonUpdated)
+	assert.Equal(t, map[string]interface{}{"id": 40, "name": "Alice"}, message.Payload)
+	mockRepo.AssertExpectations(t)
+	mockCache.AssertExpectations(t)
+}
+
+func TestHandleLabelTaskErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, tasks: newTaskGuard()}
+
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetTask", "running").Return(&models.Task{ID: "running", Status: models.TaskStatusProcessing}, nil)
+	mockRepo.On("GetTask", "guarded").Return(&models.Task{ID: "guarded", Status: models.TaskStatusFailed}, nil)
+
+	// Просроченная задача, обработка которой еще идет
+	lock, ok := handler.tasks.acquire("guarded")
+	require.True(t, ok)
+	defer lock.Release()
+
+	router := setupTestRouter()
+	router.POST("/task/:id/label", handler.HandleLabelTask)
+
+	cases := []struct {
+		name, taskID, body string
+		want               int
+	}{
+		{"без labels", "task-1", `{}`, http.StatusBadRequest},
+		{"не JSON", "task-1", `labels`, http.StatusBadRequest},
+		{"пустое имя", "task-1", `{"labels": {"cluster_5": "  "}}`, http.StatusBadRequest},
+		{"нет задачи", "missing", `{"labels": {"cluster_5": "Alice"}}`, http.StatusNotFound},
+		{"задача обрабатывается", "running", `{"labels": {"cluster_5": "Alice"}}`, http.StatusConflict},
+		{"операция над задачей", "guarded", `{"labels": {"cluster_5": "Alice"}}`, http.StatusConflict},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/task/"+tc.taskID+"/label", bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.want, w.Code)
+		})
+	}
+	mockRepo.AssertNotCalled(t, "LabelTaskPersons", mock.Anything, mock.Anything)
+}
+
+func TestHandleBulkDeletePersonsEmpty(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.POST("/persons/bulk-delete", handler.HandleBulkDeletePersons)
+
+	for _, body := range []string{`{"ids": []}`, `{}`, `not json`} {
+		req, _ := http.NewRequest("POST", "/persons/bulk-delete", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, body)
+	}
+
+	mockRepo.AssertNotCalled(t, "DeletePersons", mock.Anything)
+	mockRepo.AssertNotCalled(t, "HardDeletePersons", mock.Anything)
+}
+
+func TestHandleExportPerson(t *testing.T) {
+	mockRepo := new(MockRepository)
+	store := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	for key, data := range map[string]string{
+		"task1/a.jpg":           "original-a",
+		"task1/annotated_a.jpg": "annotated-a",
+	} {
+		err := store.Backend().Save(key, bytes.NewBufferString(data), int64(len(data)))
+		assert.NoError(t, err)
+	}
+
+	// Два лица на одном фото - оригинал должен попасть в архив один раз
+	person := &models.PersonWithFaces{
+		Person: models.Person{ID: 7, Name: "Alice"},
+		Faces: []models.Face{
+			{ID: 1, OriginalImage: "task1/a.jpg", AnnotatedImage: "task1/annotated_a.jpg", FaceX: 1, FaceY: 2, FaceWidth: 3, FaceHeight: 4, Confidence: 0.9},
+			{ID: 2, OriginalImage: "task1/a.jpg", AnnotatedImage: "task1/annotated_a.jpg", Confidence: 0.8},
+		},
+	}
+	mockRepo.On("GetPersonByID", 7).Return(person, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons/:id/export", handler.HandleExportPerson)
+
+	req, _ := http.NewRequest("GET", "/persons/7/export?annotated=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/zip", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="person_7.zip"`)
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+	for _, f := range archive.File {
+		rc, err := f.Open()
+		assert.NoError(t, err)
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	assert.Len(t, files, 3)
+	assert.Equal(t, "original-a", files["originals/a.jpg"])
+	assert.Equal(t, "annotated-a", files["annotated/annotated_a.jpg"])
+
+	var metadata models.ExportMetadata
+	assert.NoError(t, json.Unmarshal([]byte(files["metadata.json"]), &metadata))
+	assert.Equal(t, "Alice", metadata.Name)
+	assert.Len(t, metadata.Faces, 2)
+	assert.Equal(t, [4]int{1, 2, 3, 4}, metadata.Faces[0].Bbox)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleExportPersonWithoutFaces(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+
+	mockRepo.On("GetPersonByID", 3).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 3, Name: "Empty"},
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons/:id/export", handler.HandleExportPerson)
+
+	req, _ := http.NewRequest("GET", "/persons/3/export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+}
+
+func TestHandleExportPersons(t *testing.T) {
+	created := time.Date(2024, 11, 20, 10, 0, 0, 0, time.UTC)
+	fullPage := make([]models.PersonWithFaces, exportPageSize)
+	for i := range fullPage {
+		fullPage[i] = models.PersonWithFaces{
+			Person: models.Person{ID: i + 1, Name: fmt.Sprintf("Person %d", i+1), CreatedAt: created, UpdatedAt: created},
+			Count:  1,
+		}
+	}
+	lastPage := []models.PersonWithFaces{
+		{Person: models.Person{ID: exportPageSize + 1, Name: "Doe, John", CreatedAt: created, UpdatedAt: created}, Count: 3},
+	}
+
+	newRouter := func() (*gin.Engine, *MockRepository) {
+		mockRepo := new(MockRepository)
+		mockRepo.On("GetPersonsPage", 0, exportPageSize).Return(fullPage, nil)
+		mockRepo.On("GetPersonsPage", exportPageSize, exportPageSize).Return(lastPage, nil)
+
+		handler := &Handler{repo: mockRepo}
+		router := setupTestRouter()
+		router.GET("/persons/export", handler.HandleExportPersons)
+		router.GET("/persons/:id", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+		return router, mockRepo
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		router, mockRepo := newRouter()
+		req, _ := http.NewRequest("GET", "/persons/export", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/csv")
+		assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="persons.csv"`)
+
+		records, err := csv.NewReader(w.Body).ReadAll()
+		assert.NoError(t, err)
+		assert.Len(t, records, exportPageSize+2) // заголовок + все люди
+		assert.Equal(t, []string{"id", "name", "faces_count", "created_at", "updated_at"}, records[0])
+		assert.Equal(t, []string{"501", "Doe, John", "3", "2024-11-20T10:00:00Z", "2024-11-20T10:00:00Z"}, records[len(records)-1])
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		router, mockRepo := newRouter()
+		req, _ := http.NewRequest("GET", "/persons/export?format=json", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+
+		var persons []models.PersonSummary
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &persons))
+		assert.Len(t, persons, exportPageSize+1)
+		assert.Equal(t, "Doe, John", persons[exportPageSize].Name)
+		assert.Equal(t, 3, persons[exportPageSize].Count)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		router, _ := newRouter()
+		req, _ := http.NewRequest("GET", "/persons/export?format=xml", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestHandleUploadBodyTooLarge(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.Use(middleware.MaxBodySize(1024))
+	router.POST("/upload", handler.HandleUpload)
+
+	// Тело без Content-Length: лимит срабатывает при разборе multipart формы
+	req := newUploadRequestWithFiles(t, []string{strings.Repeat("x", 4096)}, nil)
+	req.Body = io.NopCloser(req.Body)
+	req.ContentLength = -1
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	var resp models.ErrorResponse
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	assert.Equal(t, middleware.ErrBodyTooLarge, resp.Error)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleUploadTooManyFiles(t *testing.T) {
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:    mockRepo,
+		storage: store,
+		cfg:     &config.Config{Server: config.ServerConfig{MaxFilesPerUpload: 2}},
+	}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	for _, query := range []string{"", "?validate_only=true"} {
+		req := newUploadRequestWithFiles(t, []string{"one", "two", "three"}, nil)
+		req.URL.RawQuery = strings.TrimPrefix(query, "?")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		var resp models.ErrorResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		assert.Equal(t, "Слишком много файлов: 3 (максимум 2)", resp.Error)
+		assert.Equal(t, apierror.CodeValidation, resp.Code)
+	}
+
+	// Ни один файл не сохранен
+	files, err := store.ListAllFiles()
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleUploadInvalidCallbackURL(t *testing.T) {
+	notifier := webhook.NewNotifier(config.WebhooksConfig{AllowedHosts: []string{"hooks.example.com"}})
+
+	tests := []struct {
+		name     string
+		notifier *webhook.Notifier
+		url      string
+	}{
+		{"callbacks disabled", nil, "https://hooks.example.com/done"},
+		{"bad scheme", notifier, "ftp://hooks.example.com/done"},
+		{"host not allowed", notifier, "http://localhost:6379/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			handler := &Handler{repo: mockRepo, storage: newTestStorage(t), notifier: tt.notifier}
+
+			router := setupTestRouter()
+			router.POST("/upload", handler.HandleUpload)
+
+			req := newUploadRequest(t, map[string]string{"callback_url": tt.url})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			mockRepo.AssertNotCalled(t, "CreateTask")
+		})
+	}
+}
+
+// uploadedFiles разбирает multipart запрос и возвращает загруженные файлы
+func uploadedFiles(t *testing.T, req *http.Request) []*multipart.FileHeader {
+	if err := req.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return req.MultipartForm.File["images"]
+}
+
+func TestUploadHash(t *testing.T) {
+	params := models.DefaultDetectionParams()
+	hashOf := func(contents ...string) string {
+		hash, err := uploadHash(uploadedFiles(t, newUploadRequestWithFiles(t, contents, nil)), params)
+		assert.NoError(t, err)
+		return hash
+	}
+
+	base := hashOf("image one", "image two")
+
+	assert.Len(t, base, 64)
+	assert.Equal(t, base, hashOf("image one", "image two"), "одинаковые файлы")
+	assert.Equal(t, base, hashOf("image two", "image one"), "порядок файлов не важен")
+	assert.NotEqual(t, base, hashOf("image one", "image twO"), "отличие в одном байте")
+	assert.NotEqual(t, base, hashOf("image one"), "другой набор файлов")
+
+	files := uploadedFiles(t, newUploadRequestWithFiles(t, []string{"image one", "image two"}, nil))
+	otherParams, err := uploadHash(files, models.DetectionParams{MinSize: 50, DetThresh: 0.5})
+	assert.NoError(t, err)
+	assert.NotEqual(t, base, otherParams, "другие параметры детекции")
+}
+
+func TestHandleUploadIdempotentReplay(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+
+	existing := &models.Task{ID: "existing-task", Status: models.TaskStatusCompleted}
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(existing, nil)
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"same image"}, nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Idempotent-Replay"))
+
+	var response models.UploadResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "existing-task", response.TaskID)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleUploadIdempotentReplayFromCache(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cache: cacheService}
+
+	req := newUploadRequestWithFiles(t, []string{"cached image"}, nil)
+	hash, err := uploadHash(uploadedFiles(t, newUploadRequestWithFiles(t, []string{"cached image"}, nil)), models.DefaultDetectionParams())
+	assert.NoError(t, err)
+
+	cacheService.SetTaskIDByHash(context.Background(), hash, "cached-task")
+	cacheService.SetTask(context.Background(), &models.Task{ID: "cached-task", Status: models.TaskStatusCompleted})
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Idempotent-Replay"))
+	assert.Contains(t, w.Body.String(), "cached-task")
+	// Кэш отвечает без обращения к БД
+	mockRepo.AssertNotCalled(t, "GetCompletedTaskByHash", mock.Anything)
+	mockRepo.AssertNotCalled(t, "GetTask", mock.Anything)
+}
+
+func TestHandleUploadStaleCachedHashNotReplayed(t *testing.T) {
+	mockRepo := new(MockRepository)
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cache: cacheService}
+
+	hash, err := uploadHash(uploadedFiles(t, newUploadRequestWithFiles(t, []string{"stale image"}, nil)), models.DefaultDetectionParams())
+	require.NoError(t, err)
+
+	// Запись хэша устарела: задачу уже перезапустили, и в БД она снова обрабатывается
+	cacheService.SetTaskIDByHash(context.Background(), hash, "reset-task")
+	mockRepo.On("GetTask", "reset-task").Return(&models.Task{ID: "reset-task", Status: models.TaskStatusProcessing}, nil)
+	mockRepo.On("GetCompletedTaskByHash", hash).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateTask", mock.Anything).Return(errors.New("db down"))
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"stale image"}, nil))
+
+	assert.Empty(t, w.Header().Get("X-Idempotent-Replay"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUploadNearIdenticalIsProcessed(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+
+	original, err := uploadHash(uploadedFiles(t, newUploadRequestWithFiles(t, []string{"image A"}, nil)), models.DefaultDetectionParams())
+	assert.NoError(t, err)
+
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	// Ошибка создания задачи останавливает загрузку до запуска обработки
+	mockRepo.On("CreateTask", mock.MatchedBy(func(task *models.Task) bool {
+		return task.ContentHash != "" && task.ContentHash != original
+	})).Return(errors.New("db down"))
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"image B"}, nil))
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("X-Idempotent-Replay"))
+	mockRepo.AssertExpectations(t)
+}
+
+// newNamedUploadRequest создает multipart запрос с файлами "имя -> содержимое" в заданном порядке
+func newNamedUploadRequest(t *testing.T, target string, files [][2]string) *http.Request {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for _, file := range files {
+		part, err := writer.CreateFormFile("images", file[0])
+		require.NoError(t, err)
+		part.Write([]byte(file[1]))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", target, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestHandleUploadValidateOnly(t *testing.T) {
+	var pngData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	heic := "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	uploads := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: uploads, cfg: &config.Config{}}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newNamedUploadRequest(t, "/upload?validate_only=true", [][2]string{
+		{"../../etc/photo.png", pngData.String()},
+		{"notes.txt", "just some text"},
+		{"empty.jpg", ""},
+		{"IMG_1.HEIC", heic},
+		{"dup.jpg", pngData.String()},
+		{"dup.jpg", string(testJPEG)},
+	}))
+
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var report models.UploadValidationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.Valid)
+	assert.Equal(t, 2, report.Accepted)
+	assert.Equal(t, 4, report.Rejected)
+	assert.Empty(t, report.ExistingTaskID)
+	require.Len(t, report.Files, 6)
+
+	assert.Equal(t, "photo.png", report.Files[0].StoredName, "директории из имени отрезаются")
+	assert.Equal(t, "image/png", report.Files[0].ContentType)
+	assert.True(t, report.Files[0].Accepted)
+
+	assert.False(t, report.Files[1].Accepted)
+	assert.Contains(t, report.Files[1].Reason, "Неподдерживаемый формат")
+
+	assert.False(t, report.Files[2].Accepted)
+	assert.Equal(t, "Пустой файл", report.Files[2].Reason)
+
+	// CONVERT_HEIF выключен - HEIC не обработается
+	assert.Equal(t, "image/heic", report.Files[3].ContentType)
+	assert.False(t, report.Files[3].Accepted)
+
+	assert.False(t, report.Files[4].Accepted, "первый из одноименных файлов перезаписывается")
+	assert.Contains(t, report.Files[4].Reason, "№6")
+	assert.True(t, report.Files[5].Accepted)
+
+	// Ничего не сохранено и задача не создана
+	files, err := os.ReadDir(uploads.Backend().(*storage.LocalBackend).Root())
+	require.NoError(t, err)
+	assert.Empty(t, files)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleUploadValidateOnlyVideo(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	cfg := &config.Config{Video: config.VideoConfig{Enabled: true, MaxSize: 1 << 20}}
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cfg: cfg}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	validate := func() []models.UploadFileValidation {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newNamedUploadRequest(t, "/upload?validate_only=true", [][2]string{
+			{"clip.mp4", testVideo},
+			{"long.mp4", testVideo + strings.Repeat("\x00", 1<<20)},
+		}))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var report models.UploadValidationResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		require.Len(t, report.Files, 2)
+		return report.Files
+	}
+
+	files := validate()
+	assert.Equal(t, "video/mp4", files[0].ContentType)
+	assert.True(t, files[0].Accepted)
+	assert.False(t, files[1].Accepted)
+	assert.Equal(t, "Видео больше 1 МБ", files[1].Reason)
+
+	// Без ffmpeg (или VIDEO_ENABLED=false) видео не обработается
+	cfg.Video.Enabled = false
+	files = validate()
+	assert.False(t, files[0].Accepted)
+	assert.Equal(t, storage.ErrVideoUnsupported.Error(), files[0].Reason)
+}
+
+func TestHandleUploadValidateOnlyOversizedImage(t *testing.T) {
+	var small, large bytes.Buffer
+	require.NoError(t, png.Encode(&small, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+	require.NoError(t, png.Encode(&large, image.NewRGBA(image.Rect(0, 0, 20, 10))))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cfg: &config.Config{
+		Storage: config.StorageConfig{MaxImagePixels: 100},
+	}}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newNamedUploadRequest(t, "/upload?validate_only=true", [][2]string{
+		{"small.png", small.String()},
+		{"large.png", large.String()},
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var report models.UploadValidationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Len(t, report.Files, 2)
+	assert.True(t, report.Files[0].Accepted)
+	assert.False(t, report.Files[1].Accepted)
+	assert.Contains(t, report.Files[1].Reason, "large.png (20x10")
+}
+
+func TestHandleUploadValidateOnlyReportsReplay(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(&models.Task{ID: "existing-task", Status: models.TaskStatusCompleted}, nil)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newNamedUploadRequest(t, "/upload?validate_only=true", [][2]string{
+		{"notes.txt", "just some text"},
+	}))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("X-Idempotent-Replay"))
+
+	var report models.UploadValidationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.False(t, report.Valid)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Equal(t, "existing-task", report.ExistingTaskID)
+}
+
+// newTestWSClient запускает WebSocket менеджер с одним клиентом,
+// подписанным на постороннюю задачу (глобальные события должны доходить и до него)
+func newTestWSClient(t *testing.T) (*websocket.Manager, *websocket.Client) {
+	manager := websocket.NewManager()
+	go manager.Run()
+
+	client := &websocket.Client{
+		ID:     "test-client",
+		Send:   make(chan websocket.Message, 64),
+		TaskID: "other-task",
+	}
+	manager.RegisterClient(client)
+	return manager, client
+}
+
+// waitForMessage ждет сообщение указанного типа, пропуская остальные
+func waitForMessage(t *testing.T, client *websocket.Client, messageType websocket.MessageType) websocket.Message {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case message := <-client.Send:
+			if message.Type == messageType {
+				return message
+			}
+		case <-timeout:
+			t.Fatalf("сообщение %s не получено", messageType)
+		}
+	}
+}
+
+func TestHandleUpdatePersonBroadcastsEvent(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, wsManager: manager}
+
+	mockRepo.On("UpdatePersonName", 4, "Alice").Return(nil)
+
+	router := setupTestRouter()
+	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+	req, _ := http.NewRequest("PUT", "/persons/4", bytes.NewBufferString(`{"name": "Alice"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonUpdated)
+	assert.Empty(t, message.TaskID)
+	assert.Equal(t, map[string]interface{}{"id": 4, "name": "Alice"}, message.Payload)
+}
+
+func TestHandleUpdatePersonBroadcastsStats(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager := websocket.NewManager()
+	go manager.Run()
+	handler := &Handler{repo: mockRepo, wsManager: manager}
+	manager.SetStatsSource(handler.Stats, 10*time.Millisecond)
+
+	// Клиент /api/stats/stream получает только статистику
+	client := &websocket.Client{ID: "stats-client", Send: make(chan websocket.Message, 64), StatsOnly: true}
+	manager.RegisterClient(client)
+	require.Eventually(t, func() bool { return manager.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	mockRepo.On("UpdatePersonName", 4, "Alice").Return(nil)
+	mockRepo.On("UpdatePersonName", 5, "Bob").Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 2}, nil).Once()
+
+	router := setupTestRouter()
+	router.PUT("/persons/:id", handler.HandleUpdatePerson)
+
+	// Две правки подряд - один пересчет статистики
+	for path, body := range map[string]string{"/persons/4": `{"name": "Alice"}`, "/persons/5": `{"name": "Bob"}`} {
+		req, _ := http.NewRequest("PUT", path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+
+	select {
+	case message := <-client.Send:
+		assert.Equal(t, websocket.MessageTypeStatsUpdate, message.Type)
+		assert.Equal(t, 2, message.Payload.(*models.Stats).TotalPersons)
+	case <-time.After(time.Second):
+		t.Fatal("stats_update не отправлен")
+	}
+
+	// События person_updated клиенту статистики не приходят, повторного пересчета нет
+	select {
+	case message := <-client.Send:
+		t.Fatalf("лишнее сообщение %s", message.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleDeletePersonBroadcastsEvent(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), wsManager: manager}
+
+	mockRepo.On("DeletePerson", 9).Return(&models.Person{ID: 9, Name: "Bob"}, nil)
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id", handler.HandleDeletePerson)
+
+	req, _ := http.NewRequest("DELETE", "/persons/9", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonDeleted)
+	assert.Equal(t, map[string]interface{}{"id": 9, "name": "Bob"}, message.Payload)
+}
+
+func TestHandleDeletePersonNotFoundDoesNotBroadcast(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), wsManager: manager}
+
+	mockRepo.On("DeletePerson", 9).Return(nil, sql.ErrNoRows)
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id", handler.HandleDeletePerson)
+
+	req, _ := http.NewRequest("DELETE", "/persons/9", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	select {
+	case message := <-client.Send:
+		t.Fatalf("неожиданное сообщение %s", message.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestProcessImagesBroadcastsPersonCreated(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Model:    "insightface/buffalo_l",
+			Clusters: map[string][]string{"person_1": {"face_1"}, "person_2": {"face_2"}},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{0, 0, 10, 10}},
+				"face_2": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{10, 10, 20, 20}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// person_1 новый, person_2 уже существовал - задача связывается с обоими
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, true, nil)
+	mockRepo.On("GetOrCreatePerson", "person_2").Return(2, false, nil)
+	mockRepo.On("LinkTaskPerson", "task-1", 1, true).Return(nil).Once()
+	mockRepo.On("LinkTaskPerson", "task-1", 2, false).Return(nil).Once()
+	// Модель из ответа Python сохраняется у каждого лица
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.EmbeddingModel == "insightface/buffalo_l"
+	})).Return(nil)
+	// Центроид пересчитывается один раз на кластер, а не после каждого лица
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil).Once()
+	mockRepo.On("UpdatePersonCentroid", 2).Return(nil).Once()
+	mockRepo.On("UpdateTaskStats", "task-1", 2, 2, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", []models.TaskFile{{FileName: "a.jpg", FacesCount: 2}}).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonCreated)
+	assert.Equal(t, map[string]interface{}{"id": 1, "name": "person_1"}, message.Payload)
+
+	// Для существующего person_2 события нет
+drain:
+	for {
+		select {
+		case message := <-client.Send:
+			assert.NotEqual(t, websocket.MessageTypePersonCreated, message.Type)
+		case <-time.After(50 * time.Millisecond):
+			break drain
+		}
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestOriginalNames(t *testing.T) {
+	names := originalNames{}
+	names.add("task-1/3f2a.heic", "dir/Фото с отпуска.heic")
+	names.add("task-1/scan.png", "scan.png") // Сохранен под своим именем
+
+	assert.Equal(t, originalNames{"3f2a.heic": "Фото с отпуска.heic"}, names)
+	assert.Equal(t, "Фото с отпуска.heic", names.lookup("task-1/3f2a.heic"))
+	assert.Equal(t, "", names.lookup("task-1/scan.png"))
+
+	// После конвертации HEIC имя переходит на JPEG
+	names.renamed([]string{"task-1/3f2a.heic", "task-1/scan.png"}, []string{"task-1/3f2a.jpg", "task-1/scan.png"})
+	assert.Equal(t, "Фото с отпуска.heic", names.lookup("task-1/3f2a.jpg"))
+
+	files := names.fill([]models.TaskFile{{FileName: "3f2a.jpg"}, {FileName: "scan.png"}})
+	assert.Equal(t, "Фото с отпуска.heic", files[0].OriginalFilename)
+	assert.Equal(t, "", files[1].OriginalFilename)
+}
+
+func TestProcessImagesStoresOriginalFilenames(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Clusters: map[string][]string{"person_1": {"face_1", "face_2"}},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/3f2a.jpg", Bbox: []int{0, 0, 10, 10}},
+				"face_2": {OriginalImage: "/uploads/task-1/b.jpg", Bbox: []int{0, 0, 10, 10}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/3f2a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/b.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// Исходные имена читаются из БД: задача могла быть перезапущена или обработана заново
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(map[string]string{"3f2a.jpg": "Фото с отпуска.jpg"}, nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, true, nil)
+	mockRepo.On("LinkTaskPerson", "task-1", 1, true).Return(nil)
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.OriginalImage == "task-1/3f2a.jpg" && face.OriginalFilename == "Фото с отпуска.jpg"
+	})).Return(nil).Once()
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.OriginalImage == "task-1/b.jpg" && face.OriginalFilename == ""
+	})).Return(nil).Once()
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 2, 1, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", []models.TaskFile{
+		{FileName: "3f2a.jpg", FacesCount: 1, OriginalFilename: "Фото с отпуска.jpg"},
+		{FileName: "b.jpg", FacesCount: 1},
+	}).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/3f2a.jpg", "task-1/b.jpg"}, models.DefaultDetectionParams(), "")
+
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesRejectsLowConfidenceFaces(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success: true,
+			Clusters: map[string][]string{
+				"person_1": {"face_1", "face_2"},
+				"person_2": {"face_3", "face_4"},
+			},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+				"face_3": {0.5, 0.6},
+				"face_4": {0.7, 0.8},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{0, 0, 10, 10}, Confidence: 0.95},
+				"face_2": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{10, 10, 20, 20}, Confidence: 0.3},
+				"face_3": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{20, 20, 30, 30}, Confidence: 0.2},
+				"face_4": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{30, 30, 40, 40}, Confidence: 0.1},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	client := &websocket.Client{ID: "task-client", Send: make(chan websocket.Message, 64), TaskID: "task-1"}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// Для person_2 не осталось лиц - персона не создается
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, true, nil)
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.Confidence == 0.95
+	})).Return(nil).Once()
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 1, 1, 3, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	params := models.DefaultDetectionParams()
+	params.MinConfidence = 0.5
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, params, "")
+
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "GetOrCreatePerson", "person_2")
+
+	// Итоговое сообщение содержит число отброшенных лиц
+	for {
+		message := waitForMessage(t, client, websocket.MessageTypeTaskUpdate)
+		payload := message.Payload.(map[string]interface{})
+		if payload["status"] != models.TaskStatusCompleted {
+			continue
+		}
+		data := payload["data"].(map[string]interface{})
+		assert.Equal(t, 3, data["rejected_faces"])
+		break
+	}
+}
+
+func TestBboxIoU(t *testing.T) {
+	assert.Equal(t, 1.0, bboxIoU([]int{0, 0, 10, 10}, []int{0, 0, 10, 10}))
+	assert.Equal(t, 0.0, bboxIoU([]int{0, 0, 10, 10}, []int{10, 0, 20, 10}), "касаются сторонами")
+	assert.Equal(t, 0.0, bboxIoU([]int{0, 0, 10, 10}, []int{50, 50, 60, 60}))
+	// Пересечение 5x10 = 50, объединение 100 + 100 - 50 = 150
+	assert.InDelta(t, 1.0/3, bboxIoU([]int{0, 0, 10, 10}, []int{5, 0, 15, 10}), 1e-9)
+	// Вложенная рамка: 25 / 100
+	assert.InDelta(t, 0.25, bboxIoU([]int{0, 0, 10, 10}, []int{0, 0, 5, 5}), 1e-9)
+}
+
+func TestMergeOverlappingFaces(t *testing.T) {
+	metadata := map[string]models.FaceMetadata{
+		// Одно лицо найдено дважды: IoU = 9000 / 10000 = 0.9
+		"face_1": {OriginalImage: "a.jpg", Bbox: []int{0, 0, 100, 100}, Confidence: 0.8},
+		"face_2": {OriginalImage: "a.jpg", Bbox: []int{0, 0, 100, 90}, Confidence: 0.95},
+		// Рядом, но почти не перекрывается (IoU ≈ 0.05)
+		"face_3": {OriginalImage: "a.jpg", Bbox: []int{90, 0, 190, 100}, Confidence: 0.9},
+		// Та же рамка, но на другом фото
+		"face_4": {OriginalImage: "b.jpg", Bbox: []int{0, 0, 100, 100}, Confidence: 0.5},
+		// Без рамки не сравнивается
+		"face_5": {OriginalImage: "a.jpg", Confidence: 0.1},
+		// Outlier не трогается
+		"face_6": {OriginalImage: "a.jpg", Bbox: []int{0, 0, 100, 100}, Confidence: 0.99},
+	}
+	clusters := map[string][]string{
+		"person_1":   {"face_1", "face_4", "face_5"},
+		"person_2":   {"face_3", "face_2"},
+		noiseCluster: {"face_6"},
+	}
+
+	merged, count := mergeOverlappingFaces(clusters, metadata, 0.7)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, map[string][]string{
+		"person_1":   {"face_4", "face_5"},
+		"person_2":   {"face_3", "face_2"},
+		noiseCluster: {"face_6"},
+	}, merged, "остается более уверенное лицо, даже из другого кластера")
+
+	merged, count = mergeOverlappingFaces(clusters, metadata, 0.9)
+	assert.Zero(t, count, "перекрытие ниже порога")
+	assert.Equal(t, clusters, merged)
+
+	merged, count = mergeOverlappingFaces(clusters, metadata, 0)
+	assert.Zero(t, count, "0 - не объединять")
+	assert.Equal(t, clusters, merged)
+}
+
+func TestProcessImagesMergesOverlappingFaces(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success: true,
+			Clusters: map[string][]string{
+				"person_1": {"face_1", "face_2", "face_3"},
+			},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.1, 0.2},
+				"face_3": {0.3, 0.4},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{10, 10, 110, 110}, Confidence: 0.7},
+				"face_2": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{12, 12, 110, 110}, Confidence: 0.9},
+				"face_3": {OriginalImage: "/uploads/task-1/b.jpg", Bbox: []int{10, 10, 110, 110}, Confidence: 0.8},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/b.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	client := &websocket.Client{ID: "task-client", Send: make(chan websocket.Message, 64), TaskID: "task-1"}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// Из двух рамок одного лица сохраняется более уверенная
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.Confidence == 0.9 || face.Confidence == 0.8
+	})).Return(nil).Twice()
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 2, 1, 0, 0, 1).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg", "task-1/b.jpg"}, models.DefaultDetectionParams(), "")
+
+	mockRepo.AssertExpectations(t)
+
+	for {
+		message := waitForMessage(t, client, websocket.MessageTypeTaskUpdate)
+		payload := message.Payload.(map[string]interface{})
+		if payload["status"] != models.TaskStatusCompleted {
+			continue
+		}
+		data := payload["data"].(map[string]interface{})
+		assert.Equal(t, 1, data["merged_faces"])
+		break
+	}
+}
+
+func TestCapFaces(t *testing.T) {
+	metadata := map[string]models.FaceMetadata{
+		"face_1": {Confidence: 0.5},
+		"face_2": {Confidence: 0.9},
+		"face_3": {Confidence: 0.7},
+	}
+	faceIDs := []string{"face_1", "face_2", "face_3"}
+
+	assert.Equal(t, faceIDs, capFaces(faceIDs, metadata, 4), "места больше, чем лиц")
+	assert.Equal(t, faceIDs, capFaces(faceIDs, metadata, 3), "ровно на границе")
+	assert.Equal(t, []string{"face_2", "face_3"}, capFaces(faceIDs, metadata, 2), "самые уверенные в исходном порядке")
+	assert.Equal(t, []string{"face_2"}, capFaces(faceIDs, metadata, 1))
+	assert.Empty(t, capFaces(faceIDs, metadata, 0), "лимит уже достигнут")
+	assert.Empty(t, capFaces(faceIDs, metadata, -2), "лимит превышен (например, после его уменьшения)")
+}
+
+func TestCapPersonFaces(t *testing.T) {
+	metadata := map[string]models.FaceMetadata{"face_1": {}, "face_2": {}, "face_3": {}}
+	faceIDs := []string{"face_1", "face_2", "face_3"}
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("CountPersonFaces", 1).Return(9, nil)
+	mockRepo.On("CountPersonFaces", 2).Return(10, nil)
+	mockRepo.On("CountPersonFaces", 3).Return(0, errors.New("db down"))
+
+	cfg := &config.Config{Persons: config.PersonsConfig{MaxFaces: 10}}
+	handler := &Handler{repo: mockRepo, cfg: cfg}
+
+	kept, overflow := handler.capPersonFaces(1, false, faceIDs, metadata)
+	assert.Len(t, kept, 1)
+	assert.Equal(t, 2, overflow)
+
+	kept, overflow = handler.capPersonFaces(2, false, faceIDs, metadata)
+	assert.Empty(t, kept)
+	assert.Equal(t, 3, overflow)
+
+	// Ошибка подсчета не мешает сохранению
+	kept, overflow = handler.capPersonFaces(3, false, faceIDs, metadata)
+	assert.Equal(t, faceIDs, kept)
+	assert.Zero(t, overflow)
+
+	// У нового человека лиц еще нет - БД не спрашиваем
+	kept, overflow = handler.capPersonFaces(4, true, faceIDs, metadata)
+	assert.Equal(t, faceIDs, kept)
+	assert.Zero(t, overflow)
+	mockRepo.AssertNotCalled(t, "CountPersonFaces", 4)
+
+	// 0 - без ограничения
+	cfg.Persons.MaxFaces = 0
+	kept, overflow = handler.capPersonFaces(2, false, faceIDs, metadata)
+	assert.Equal(t, faceIDs, kept)
+	assert.Zero(t, overflow)
+}
+
+func TestProcessImagesEnforcesPersonFaceLimit(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success: true,
+			Clusters: map[string][]string{
+				"person_1": {"face_1", "face_2", "face_3"},
+				"person_2": {"face_4"},
+			},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+				"face_3": {0.5, 0.6},
+				"face_4": {0.7, 0.8},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{0, 0, 10, 10}, Confidence: 0.6},
+				"face_2": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{10, 10, 20, 20}, Confidence: 0.9},
+				"face_3": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{20, 20, 30, 30}, Confidence: 0.8},
+				"face_4": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{30, 30, 40, 40}, Confidence: 0.9},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+		cfg:          &config.Config{Persons: config.PersonsConfig{MaxFaces: 5}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// У person_1 уже 3 лица из 5 - сохраняются два самых уверенных,
+	// person_2 уже на лимите - его лица не сохраняются вовсе
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("GetOrCreatePerson", "person_2").Return(2, false, nil)
+	mockRepo.On("CountPersonFaces", 1).Return(3, nil)
+	mockRepo.On("CountPersonFaces", 2).Return(5, nil)
+	mockRepo.On("CreateFace", mock.MatchedBy(func(face *models.Face) bool {
+		return face.PersonID == 1 && face.Confidence > 0.7
+	})).Return(nil).Twice()
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 2, 1, 0, 2, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "UpdatePersonCentroid", 2)
+}
+
+func TestProcessImagesStoresImageSize(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:    true,
+			Clusters:   map[string][]string{"person_1": {"face_1", "face_2"}},
+			Embeddings: map[string][]float64{"face_1": {0.1, 0.2}, "face_2": {0.3, 0.4}},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "task-1/a.jpg", Bbox: []int{100, 50, 300, 250}, ImageWidth: 1920, ImageHeight: 1080},
+				// Старый Python сервер размер не присылает
+				"face_2": {OriginalImage: "task-1/a.jpg", Bbox: []int{10, 10, 50, 50}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	var saved []*models.Face
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		saved = append(saved, args.Get(0).(*models.Face))
+	}).Return(nil)
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 2, 1, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	require.Len(t, saved, 2)
+	require.NotNil(t, saved[0].ImageWidth)
+	require.NotNil(t, saved[0].ImageHeight)
+	assert.Equal(t, 1920, *saved[0].ImageWidth)
+	assert.Equal(t, 1080, *saved[0].ImageHeight)
+	assert.Equal(t, 200, saved[0].FaceWidth)
+
+	// Неизвестный размер сохраняется как NULL, а не 0
+	assert.Nil(t, saved[1].ImageWidth)
+	assert.Nil(t, saved[1].ImageHeight)
+	mockRepo.AssertExpectations(t)
+}
+
+// runFaceEventsTask обрабатывает задачу из одного кластера на count лиц
+// и возвращает сообщения face_saved, полученные подписчиком задачи
+func runFaceEventsTask(t *testing.T, count int, cfg *config.Config) []websocket.Message {
+	t.Helper()
+
+	faceIDs := make([]string, 0, count)
+	embeddings := make(map[string][]float64, count)
+	metadata := make(map[string]models.FaceMetadata, count)
+	for i := 0; i < count; i++ {
+		faceID := fmt.Sprintf("face_%d", i)
+		faceIDs = append(faceIDs, faceID)
+		embeddings[faceID] = []float64{0.1, 0.2}
+		metadata[faceID] = models.FaceMetadata{OriginalImage: "task-1/a.jpg", BoxedImage: fmt.Sprintf("boxed_%d.jpg", i)}
+	}
+
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:       true,
+			Clusters:      map[string][]string{"person_1": faceIDs},
+			Embeddings:    embeddings,
+			FacesMetadata: metadata,
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	manager := websocket.NewManager()
+	go manager.Run()
+	client := &websocket.Client{ID: "task-client", Send: make(chan websocket.Message, 256), TaskID: "task-1"}
+	manager.RegisterClient(client)
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+		cfg:          cfg,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	nextID := 0
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		nextID++
+		args.Get(0).(*models.Face).ID = nextID
+	}).Return(nil)
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", count, 1, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	// face_saved рассылаются до финального task_update
+	var events []websocket.Message
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case message := <-client.Send:
+			if message.Type == websocket.MessageTypeFaceSaved {
+				events = append(events, message)
+			}
+			if message.Type == websocket.MessageTypeTaskUpdate &&
+				message.Payload.(map[string]interface{})["status"] == models.TaskStatusCompleted {
+				return events
+			}
+		case <-timeout:
+			t.Fatal("задача не завершилась")
+		}
+	}
+}
+
+func TestProcessImagesBatchesFaceSavedEvents(t *testing.T) {
+	cfg := &config.Config{WebSocket: config.WebSocketConfig{FaceEventsBatch: 50, FaceEventsInterval: time.Hour}}
+
+	events := runFaceEventsTask(t, 120, cfg)
+
+	require.Len(t, events, 3)
+	var ids []int
+	for i, wantCount := range []int{50, 50, 20} {
+		payload := events[i].Payload.(map[string]interface{})
+		faces := payload["faces"].([]websocket.FaceSaved)
+		assert.Equal(t, wantCount, payload["count"])
+		require.Len(t, faces, wantCount)
+		for _, face := range faces {
+			ids = append(ids, face.FaceID)
+			assert.Equal(t, 1, face.PersonID)
+		}
+	}
+	assert.Len(t, ids, 120)
+	assert.Equal(t, 1, ids[0])
+	assert.Equal(t, 120, ids[119])
+
+	first := events[0].Payload.(map[string]interface{})["faces"].([]websocket.FaceSaved)[0]
+	assert.Equal(t, "results/task-1/boxed_0.jpg", first.AnnotatedImage)
+	assert.Equal(t, "/results/task-1/boxed_0.jpg", first.URL)
+}
+
+func TestResultKey(t *testing.T) {
+	store := newTestStorage(t)
+	handler := &Handler{storage: store}
+
+	// Python пишет аннотированные фото в RESULTS_DIR
+	assert.Equal(t, "results/task-1/face_0_boxed.jpg", handler.resultKey("task-1", "task-1/face_0_boxed.jpg"))
+	assert.Equal(t, "", handler.resultKey("task-1", ""))
+
+	// Python старой версии - в папку загрузки
+	require.NoError(t, store.Backend().Save("task-1/face_1_boxed.jpg", bytes.NewBufferString("boxed"), 5))
+	assert.Equal(t, "task-1/face_1_boxed.jpg", handler.resultKey("task-1", "task-1/face_1_boxed.jpg"))
+}
+
+func TestProcessImagesFaceSavedIntervalFlushesEarly(t *testing.T) {
+	// Интервал истекает к каждому следующему лицу - пачки по одному
+	cfg := &config.Config{WebSocket: config.WebSocketConfig{FaceEventsBatch: 50, FaceEventsInterval: time.Nanosecond}}
+
+	events := runFaceEventsTask(t, 3, cfg)
+
+	require.Len(t, events, 3)
+	for _, event := range events {
+		assert.Equal(t, 1, event.Payload.(map[string]interface{})["count"])
+	}
+}
+
+func TestProcessFilesPropagatesTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	var traceparent string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent = r.Header.Get("traceparent")
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: false, Error: "stop"})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).Return(nil)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "POST /api/upload")
+	handler.withContext(ctx).processFiles("task-1", []string{"task-1/a.jpg"}, nil, models.DetectionParams{MinSize: 30, DetThresh: 0.5}, "", nil)
+	parent.End()
+
+	// Python получает trace запроса в заголовке traceparent
+	assert.Contains(t, traceparent, parent.SpanContext().TraceID().String())
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "processImages")
+	require.Contains(t, spans, "python /process")
+	assert.Equal(t, parent.SpanContext().SpanID(), spans["processImages"].Parent().SpanID())
+	assert.Equal(t, spans["processImages"].SpanContext().SpanID(), spans["python /process"].Parent().SpanID())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleReprocessTask(t *testing.T) {
+	// Python возвращает ошибку - достаточно, чтобы дождаться окончания processImages
+	var received url.Values
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseMultipartForm(1 << 20)
+		received = r.MultipartForm.Value
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: false, Error: "stop"})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	for _, key := range []string{"task-1/a.jpg", "task-1/face_0_boxed.jpg", "thumbnails/task-1/face_0_boxed.jpg"} {
+		assert.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	}
+
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{
+		ID: "task-1", Status: models.TaskStatusCompleted, MinSize: 30, DetThresh: 0.5,
+	}, nil)
+	// min_size из формы, det_thresh остается от задачи
+	newParams := models.DetectionParams{MinSize: 80, DetThresh: 0.5}
+	mockRepo.On("ResetTaskForReprocess", "task-1", newParams).Return(
+		[]models.Person{{ID: 1, Name: "person_1"}},
+		[]models.Face{{ID: 10, PersonID: 1, TaskID: "task-1", OriginalImage: "task-1/a.jpg",
+			AnnotatedImage: "task-1/face_0_boxed.jpg", ThumbnailImage: "thumbnails/task-1/face_0_boxed.jpg"}},
+		nil,
+	)
+
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", 10, "Отправка в Python").Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.MatchedBy(func(files []models.TaskFile) bool {
+		return len(files) == 1 && files[0].FileName == "a.jpg" && files[0].Error != ""
+	})).Return(nil)
+
+	done := make(chan struct{})
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).
+		Run(func(mock.Arguments) { close(done) }).
+		Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+
+	req, _ := http.NewRequest("POST", "/task/task-1/reprocess", strings.NewReader("min_size=80"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("processImages не завершился")
+	}
+
+	// В Python ушел только оригинал с новыми параметрами
+	assert.Equal(t, []string{"80"}, received["min_size"])
+	assert.True(t, store.FileExists("task-1/a.jpg"))
+	assert.False(t, store.FileExists("task-1/face_0_boxed.jpg"))
+	assert.False(t, store.FileExists("thumbnails/task-1/face_0_boxed.jpg"))
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonDeleted)
+	assert.Equal(t, map[string]interface{}{"id": 1, "name": "person_1"}, message.Payload)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleReprocessTaskRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		task   *models.Task
+		err    error
+		files  bool
+		status int
+	}{
+		{"not found", nil, sql.ErrNoRows, true, http.StatusNotFound},
+		{"processing", &models.Task{ID: "task-1", Status: models.TaskStatusProcessing}, nil, true, http.StatusConflict},
+		{"queued", &models.Task{ID: "task-1", Status: models.TaskStatusQueued}, nil, true, http.StatusConflict},
+		{"no files", &models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil, false, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStorage(t)
+			if tt.files {
+				store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG)))
+			}
+
+			mockRepo := new(MockRepository)
+			mockRepo.On("GetTask", "task-1").Return(tt.task, tt.err)
+			handler := &Handler{repo: mockRepo, storage: store}
+
+			router := setupTestRouter()
+			router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+
+			req, _ := http.NewRequest("POST", "/task/task-1/reprocess", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			mockRepo.AssertNotCalled(t, "ResetTaskForReprocess", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandleReprocessTaskConcurrentReset(t *testing.T) {
+	store := newTestStorage(t)
+	store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG)))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	// Другой запрос успел перезапустить задачу между GetTask и сбросом
+	mockRepo.On("ResetTaskForReprocess", "task-1", mock.Anything).Return(nil, nil, repository.ErrTaskProcessing)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	router := setupTestRouter()
+	router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+
+	req, _ := http.NewRequest("POST", "/task/task-1/reprocess", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestHandleReprocessTaskSameTaskTwice(t *testing.T) {
+	// Python отвечает, только когда тест разрешит - до этого обработка занимает задачу
+	release := make(chan struct{})
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: false, Error: "stop"})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		tasks:        newTaskGuard(),
+	}
+	go handler.wsManager.Run()
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// Статус в БД не меняется (как у задачи, просроченной по таймауту) - защищает только guard
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	mockRepo.On("ResetTaskForReprocess", "task-1", mock.Anything).Return([]models.Person{}, []models.Face{}, nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	done := make(chan struct{})
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).
+		Run(func(mock.Arguments) { close(done) }).
+		Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+	router.DELETE("/task/:id", handler.HandleDeleteTask)
+
+	send := func(method, path string) int {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Два одновременных reprocess: обработку запускает только один
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- send("POST", "/task/task-1/reprocess")
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	var statuses []int
+	for code := range codes {
+		statuses = append(statuses, code)
+	}
+	assert.ElementsMatch(t, []int{http.StatusOK, http.StatusConflict}, statuses)
+	mockRepo.AssertNumberOfCalls(t, "ResetTaskForReprocess", 1)
+
+	// Пока обработка идет, удалить задачу нельзя
+	assert.Equal(t, http.StatusConflict, send("DELETE", "/task/task-1"))
+	mockRepo.AssertNotCalled(t, "DeleteTask", mock.Anything)
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("processImages не завершился")
+	}
+
+	// После обработки задача освобождается, запись guard удаляется
+	assert.Eventually(t, func() bool {
+		handler.tasks.mu.Lock()
+		defer handler.tasks.mu.Unlock()
+		return len(handler.tasks.active) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandleDeleteTask(t *testing.T) {
+	store := newTestStorage(t)
+	keys := []string{"task-1/a.jpg", "task-1/b.jpg", "task-1/face_0_boxed.jpg", "thumbnails/task-1/face_0_boxed.jpg", "task-2/c.jpg"}
+	for _, key := range keys {
+		require.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	}
+
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	cacheService.SetTask(context.Background(), &models.Task{ID: "task-1", Status: models.TaskStatusCompleted})
+	cacheService.SetTaskIDByHash(context.Background(), "hash-1", "task-1")
+	cacheService.SetPerson(context.Background(), &models.PersonWithFaces{Person: models.Person{ID: 2, Name: "Bob"}})
+
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, storage: store, cache: cacheService, wsManager: manager}
+
+	// Alice встречалась только в task-1, у Bob остались лица из других задач
+	faces := []models.Face{
+		{ID: 10, PersonID: 1, TaskID: "task-1", OriginalImage: "task-1/a.jpg",
+			AnnotatedImage: "task-1/face_0_boxed.jpg", ThumbnailImage: "thumbnails/task-1/face_0_boxed.jpg"},
+		{ID: 11, PersonID: 2, TaskID: "task-1", OriginalImage: "task-1/a.jpg"},
+	}
+	mockRepo.On("DeleteTask", "task-1").Return(
+		&models.Task{ID: "task-1", Status: models.TaskStatusCompleted, ContentHash: "hash-1"},
+		[]models.Person{{ID: 1, Name: "Alice"}},
+		faces,
+		nil,
+	)
+
+	router := setupTestRouter()
+	router.DELETE("/task/:id", handler.HandleDeleteTask)
+
+	req, _ := http.NewRequest("DELETE", "/task/task-1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response models.DeleteTaskResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.DeleteTaskResponse{
+		TaskID: "task-1", DeletedFaces: 2, DeletedPersons: 1, FilesDeleted: true,
+	}, response)
+
+	// Удалены все файлы задачи, включая миниатюры вне ее папки; чужие файлы на месте
+	for _, key := range keys[:4] {
+		assert.False(t, store.FileExists(key), key)
+	}
+	assert.True(t, store.FileExists("task-2/c.jpg"))
+
+	cachedTask, _ := cacheService.GetTask(context.Background(), "task-1")
+	assert.Nil(t, cachedTask)
+	cachedID, _ := cacheService.GetTaskIDByHash(context.Background(), "hash-1")
+	assert.Empty(t, cachedID)
+	cachedPerson, _ := cacheService.GetPerson(context.Background(), 2)
+	assert.Nil(t, cachedPerson)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonDeleted)
+	assert.Equal(t, map[string]interface{}{"id": 1, "name": "Alice"}, message.Payload)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleDeleteTaskRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"not found", sql.ErrNoRows, http.StatusNotFound},
+		{"processing", repository.ErrTaskProcessing, http.StatusConflict},
+		{"db error", errors.New("db down"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStorage(t)
+			require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+			mockRepo := new(MockRepository)
+			mockRepo.On("DeleteTask", "task-1").Return(nil, nil, nil, tt.err)
+			handler := &Handler{repo: mockRepo, storage: store}
+
+			router := setupTestRouter()
+			router.DELETE("/task/:id", handler.HandleDeleteTask)
+
+			req, _ := http.NewRequest("DELETE", "/task/task-1", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			// Файлы не трогаем, если задача не удалена из БД
+			assert.True(t, store.FileExists("task-1/a.jpg"))
+		})
+	}
+}
+
+func TestHandleSearchFuzzy(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("SearchPersons", "Jhon", models.SearchOptions{Fuzzy: true, MinSimilarity: 0.4, Limit: models.DefaultSearchLimit}).
+		Return([]models.PersonWithFaces{{Person: models.Person{ID: 1, Name: "John"}, Score: 0.45}}, 1, nil)
+
+	router := setupTestRouter()
+	router.GET("/search", handler.HandleSearch)
+
+	req, _ := http.NewRequest("GET", "/search?q=Jhon&fuzzy=true&min_similarity=0.4", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var results models.SearchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	assert.Len(t, results.Persons, 1)
+	assert.Equal(t, "John", results.Persons[0].Name)
+	assert.Equal(t, 0.45, results.Persons[0].Score)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleSearchPageAndMinFaces(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("SearchPersons", "john", models.SearchOptions{
+		MinSimilarity: models.DefaultMinSimilarity,
+		MinFaces:      3,
+		Limit:         10,
+		Offset:        20,
+	}).Return([]models.PersonWithFaces{{Person: models.Person{ID: 5, Name: "John"}, Count: 4}}, 21, nil)
+
+	router := setupTestRouter()
+	router.GET("/search", handler.HandleSearch)
+
+	req, _ := http.NewRequest("GET", "/search?q=john&limit=10&offset=20&min_faces=3", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var results models.SearchResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &results))
+	require.Len(t, results.Persons, 1)
+	assert.Equal(t, 4, results.Persons[0].Count)
+	assert.Equal(t, 21, results.Total)
+	assert.Equal(t, 10, results.Limit)
+	assert.Equal(t, 20, results.Offset)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleSearchInvalidPage(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/search", handler.HandleSearch)
+
+	for _, params := range []string{
+		"limit=0", "limit=201", "limit=abc", "offset=-1", "offset=x", "min_faces=0", "min_faces=-2",
+	} {
+		req, _ := http.NewRequest("GET", "/search?q=John&"+params, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, params)
+	}
+	mockRepo.AssertNotCalled(t, "SearchPersons", mock.Anything, mock.Anything)
+}
+
+func TestHandleSearchInvalidMinSimilarity(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/search", handler.HandleSearch)
+
+	for _, v := range []string{"0", "1.5", "-1", "abc", "NaN"} {
+		req, _ := http.NewRequest("GET", "/search?q=John&fuzzy=true&min_similarity="+v, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, v)
+	}
+	mockRepo.AssertNotCalled(t, "SearchPersons", mock.Anything, mock.Anything)
+}
+
+// newCompareServer имитирует Python /compare и запоминает полученные embeddings
+func newCompareServer(t *testing.T, received *map[string][]float64) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/compare", r.URL.Path)
+		json.NewDecoder(r.Body).Decode(received)
+		json.NewEncoder(w).Encode(map[string]interface{}{"similarity": 0.87, "match": true})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func postCompare(handler *Handler, body string) *httptest.ResponseRecorder {
+	router := setupTestRouter()
+	router.POST("/api/faces/compare", handler.HandleCompareFaces)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/faces/compare", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleCompareFacesByID(t *testing.T) {
+	var received map[string][]float64
+	python := newCompareServer(t, &received)
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceByID", 1).Return(&models.Face{ID: 1, Embedding: []byte("[0.1,0.2]")}, nil)
+	mockRepo.On("GetFaceByID", 2).Return(&models.Face{ID: 2, Embedding: []byte("[0.3,0.4]")}, nil)
+
+	handler := &Handler{repo: mockRepo, comparer: embedding.ComparerFunc(python_client.NewClient(python.URL).CompareEmbeddings)}
+	w := postCompare(handler, `{"face_id_a": 1, "face_id_b": 2}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.CompareFacesResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.CompareFacesResponse{Similarity: 0.87, Match: true}, response)
+
+	assert.Equal(t, []float64{0.1, 0.2}, received["embedding1"])
+	assert.Equal(t, []float64{0.3, 0.4}, received["embedding2"])
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleCompareFacesRawEmbeddings(t *testing.T) {
+	// Одна сторона по ID, другая - готовым embedding; считаем локально
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceByID", 1).Return(&models.Face{ID: 1, Embedding: []byte("[1,0]")}, nil)
+
+	handler := &Handler{repo: mockRepo, comparer: embedding.NewLocal(embedding.DefaultMatchThreshold)}
+	w := postCompare(handler, `{"face_id_a": 1, "embedding_b": [1, 1]}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.CompareFacesResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.InDelta(t, 0.7071, response.Similarity, 1e-4)
+	assert.True(t, response.Match)
+}
+
+func TestHandleCompareFacesThreshold(t *testing.T) {
+	// Сходство 0.8: порог из запроса заменяет COMPARE_MATCH_THRESHOLD с обеих сторон
+	handler := &Handler{comparer: embedding.NewLocal(0.9)}
+	body := `{"embedding_a": [1, 0], "embedding_b": [0.8, 0.6]}`
+
+	for query, want := range map[string]bool{
+		"":                 false,
+		"?threshold=0.79":  true,
+		"?threshold=0.801": false,
+		"?threshold=-1":    true,
+	} {
+		router := setupTestRouter()
+		router.POST("/api/faces/compare", handler.HandleCompareFaces)
+		req := httptest.NewRequest(http.MethodPost, "/api/faces/compare"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code, query)
+		var response models.CompareFacesResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.InDelta(t, 0.8, response.Similarity, 1e-9, query)
+		assert.Equal(t, want, response.Match, query)
+	}
+
+	for _, query := range []string{"?threshold=1.5", "?threshold=-2", "?threshold=abc", "?threshold=NaN"} {
+		router := setupTestRouter()
+		router.POST("/api/faces/compare", handler.HandleCompareFaces)
+		req := httptest.NewRequest(http.MethodPost, "/api/faces/compare"+query, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestHandleCompareFacesPythonError(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "boom"})
+	}))
+	defer python.Close()
+
+	handler := &Handler{comparer: embedding.ComparerFunc(python_client.NewClient(python.URL).CompareEmbeddings)}
+	w := postCompare(handler, `{"embedding_a": [0.1], "embedding_b": [0.2]}`)
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	assertErrorCode(t, w, apierror.CodeUpstream)
+	assert.NotContains(t, w.Body.String(), "boom")
+}
+
+func TestHandleCompareFacesNotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceByID", 1).Return(&models.Face{ID: 1, Embedding: []byte("[0.1,0.2]")}, nil)
+	mockRepo.On("GetFaceByID", 99).Return(nil, sql.ErrNoRows)
+
+	handler := &Handler{repo: mockRepo}
+	w := postCompare(handler, `{"face_id_a": 1, "face_id_b": 99}`)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assertErrorCode(t, w, apierror.CodeNotFound)
+	assert.Contains(t, w.Body.String(), "99")
+}
+
+func TestHandleCompareFacesInvalidRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"не JSON", `not json`},
+		{"нет второго лица", `{"face_id_a": 1}`},
+		{"и ID, и embedding", `{"face_id_a": 1, "embedding_a": [0.1], "embedding_b": [0.2]}`},
+		{"разные размерности", `{"embedding_a": [0.1, 0.2], "embedding_b": [0.3]}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			mockRepo.On("GetFaceByID", 1).Return(&models.Face{ID: 1, Embedding: []byte("[0.1]")}, nil)
+
+			w := postCompare(&Handler{repo: mockRepo}, tt.body)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assertErrorCode(t, w, apierror.CodeValidation)
+		})
+	}
+}
+
+func TestHandleCompareFacesEmbeddingDim(t *testing.T) {
+	// Лицо посчитано моделью с другой размерностью: не сравниваем, а отвечаем 400
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceByID", 1).Return(&models.Face{ID: 1, Embedding: []byte("[1,0]")}, nil)
+
+	calls := 0
+	handler := &Handler{
+		repo: mockRepo,
+		comparer: embedding.ComparerFunc(func(a, b []float64, threshold *float64) (float64, bool, error) {
+			calls++
+			return 1, true, nil
+		}),
+		cfg: &config.Config{Compare: config.CompareConfig{EmbeddingDim: 3}},
+	}
+
+	w := postCompare(handler, `{"face_id_a": 1, "embedding_b": [1, 1]}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assertErrorCode(t, w, apierror.CodeValidation)
+	assert.Contains(t, w.Body.String(), "Размерность embedding 2, ожидается 3 (EMBEDDING_DIM)")
+	assert.Zero(t, calls)
+
+	w = postCompare(handler, `{"embedding_a": [1, 0, 0], "embedding_b": [0, 1, 0]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestProcessImagesPersistsProgress(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var stages []int
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { stages = append(stages, args.Int(1)) }).
+		Return(nil)
+	// 100% записывается до перевода задачи в completed
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).
+		Run(func(mock.Arguments) { assert.Equal(t, []int{10, 70, 100}, stages) }).
+		Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	assert.Equal(t, []int{10, 70, 100}, stages)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestTaskProgressSnapshot(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{
+		ID: "task-1", Status: models.TaskStatusProcessing, Progress: 70, Stage: "Сохранение в базу данных",
+	}, nil)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	message, ok := handler.TaskProgressSnapshot("task-1")
+	assert.True(t, ok)
+	assert.Equal(t, websocket.MessageTypeTaskProgress, message.Type)
+	assert.Equal(t, "task-1", message.TaskID)
+	payload := message.Payload.(map[string]interface{})
+	assert.Equal(t, 70.0, payload["percent"])
+	assert.Equal(t, "Сохранение в базу данных", payload["stage"])
+
+	_, ok = handler.TaskProgressSnapshot("missing")
+	assert.False(t, ok)
+}
+
+func TestHandleTaskStatusIncludesProgress(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{
+		ID: "task-1", Status: models.TaskStatusProcessing, Progress: 10, Stage: "Отправка в Python",
+	}, nil)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/task/:id", handler.HandleTaskStatus)
+
+	req, _ := http.NewRequest("GET", "/task/task-1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 10.0, response["progress"])
+	assert.Equal(t, "Отправка в Python", response["stage"])
+}
+
+func TestHandleTaskStatusDuration(t *testing.T) {
+	created := time.Date(2024, 11, 21, 6, 9, 0, 0, time.UTC)
+	cases := []struct {
+		name     string
+		task     *models.Task
+		duration interface{}
+	}{
+		{
+			name: "processing",
+			task: &models.Task{ID: "task-1", Status: models.TaskStatusProcessing, CreatedAt: created,
+				StartedAt: sql.NullTime{Time: created.Add(2 * time.Second), Valid: true}},
+			duration: nil,
+		},
+		{
+			// Ожидание в очереди (created_at -> processing_started_at) не входит в длительность
+			name: "completed",
+			task: &models.Task{ID: "task-1", Status: models.TaskStatusCompleted, CreatedAt: created,
+				StartedAt:   sql.NullTime{Time: created.Add(2 * time.Second), Valid: true},
+				CompletedAt: sql.NullTime{Time: created.Add(14500 * time.Millisecond), Valid: true}},
+			duration: 12.5,
+		},
+		{
+			name: "failed",
+			task: &models.Task{ID: "task-1", Status: models.TaskStatusFailed, CreatedAt: created,
+				StartedAt:   sql.NullTime{Time: created, Valid: true},
+				CompletedAt: sql.NullTime{Time: created.Add(3 * time.Second), Valid: true}},
+			duration: 3.0,
+		},
+		{
+			// Задача обработана до появления processing_started_at
+			name: "completed without start time",
+			task: &models.Task{ID: "task-1", Status: models.TaskStatusCompleted, CreatedAt: created,
+				CompletedAt: sql.NullTime{Time: created.Add(5 * time.Second), Valid: true}},
+			duration: 5.0,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(MockRepository)
+			mockRepo.On("GetTask", "task-1").Return(tc.task, nil)
+			handler := &Handler{repo: mockRepo}
+
+			router := setupTestRouter()
+			router.GET("/task/:id", handler.HandleTaskStatus)
+
+			req, _ := http.NewRequest("GET", "/task/task-1", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			require.Contains(t, response, "duration_seconds")
+			assert.Equal(t, tc.duration, response["duration_seconds"])
+		})
+	}
+}
+
+func TestHandleTaskStatusDurationFromCache(t *testing.T) {
+	created := time.Date(2024, 11, 21, 6, 9, 0, 0, time.UTC)
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	require.NoError(t, cacheService.SetTask(context.Background(), &models.Task{
+		ID: "task-1", Status: models.TaskStatusCompleted, CreatedAt: created,
+		StartedAt:   sql.NullTime{Time: created, Valid: true},
+		CompletedAt: sql.NullTime{Time: created.Add(4 * time.Second), Valid: true},
+	}))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, cache: cacheService}
+
+	router := setupTestRouter()
+	router.GET("/task/:id", handler.HandleTaskStatus)
+
+	req, _ := http.NewRequest("GET", "/task/task-1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 4.0, response["duration_seconds"])
+	mockRepo.AssertNotCalled(t, "GetTask", mock.Anything)
+}
+
+func TestProcessImagesMarksTaskStarted(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// Время начала сохраняется до первого этапа обработки
+	var calls []string
+	record := func(name string) func(mock.Arguments) {
+		return func(mock.Arguments) { calls = append(calls, name) }
+	}
+	mockRepo.On("MarkTaskStarted", "task-1").Run(record("MarkTaskStarted")).Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Run(record("UpdateTaskProgress")).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	require.NotEmpty(t, calls)
+	assert.Equal(t, "MarkTaskStarted", calls[0])
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleDeletePersonSoftKeepsFiles(t *testing.T) {
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("DeletePerson", 9).Return(&models.Person{ID: 9, Name: "Bob"}, nil)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id", handler.HandleDeletePerson)
+
+	req, _ := http.NewRequest("DELETE", "/persons/9", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, store.FileExists("task-1/a.jpg"))
+	mockRepo.AssertNotCalled(t, "HardDeletePerson", mock.Anything)
+}
+
+func TestHandleDeletePersonHard(t *testing.T) {
+	store := newTestStorage(t)
+	for _, key := range []string{"task-1/a.jpg", "task-1/face_0_boxed.jpg"} {
+		assert.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	}
+
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	mockRepo.On("HardDeletePerson", 9).Return(
+		&models.Person{ID: 9, Name: "Bob"},
+		[]string{"task-1/a.jpg", "task-1/face_0_boxed.jpg"},
+		nil,
+	)
+	handler := &Handler{repo: mockRepo, storage: store, wsManager: manager}
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id", handler.HandleDeletePerson)
+
+	req, _ := http.NewRequest("DELETE", "/persons/9?hard=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, store.FileExists("task-1/a.jpg"))
+	assert.False(t, store.FileExists("task-1/face_0_boxed.jpg"))
+	mockRepo.AssertNotCalled(t, "DeletePerson", mock.Anything)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonDeleted)
+	assert.Equal(t, map[string]interface{}{"id": 9, "name": "Bob"}, message.Payload)
+}
+
+func TestHandleDeletePersonHardAlreadySoftDeleted(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	deleted := &models.Person{ID: 9, Name: "Bob", DeletedAt: sql.NullTime{Time: time.Now(), Valid: true}}
+	mockRepo.On("HardDeletePerson", 9).Return(deleted, []string(nil), nil)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), wsManager: manager}
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id", handler.HandleDeletePerson)
+
+	req, _ := http.NewRequest("DELETE", "/persons/9?hard=true", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Клиенты уже получили person_deleted при soft-delete
+	select {
+	case message := <-client.Send:
+		t.Fatalf("неожиданное сообщение %s", message.Type)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestHandleRestorePerson(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	mockRepo.On("RestorePerson", 9).Return(&models.Person{ID: 9, Name: "Bob"}, nil)
+	mockRepo.On("RestorePerson", 10).Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo, wsManager: manager}
+
+	router := setupTestRouter()
+	router.POST("/persons/:id/restore", handler.HandleRestorePerson)
+
+	req, _ := http.NewRequest("POST", "/persons/9/restore", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	message := waitForMessage(t, client, websocket.MessageTypePersonRestored)
+	assert.Equal(t, map[string]interface{}{"id": 9, "name": "Bob"}, message.Payload)
+
+	// Не удален или не существует
+	req, _ = http.NewRequest("POST", "/persons/10/restore", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestTaskFileResults(t *testing.T) {
+	imagePaths := []string{"task-1/a.jpg", "task-1/b.jpg", "task-1/empty.jpg"}
+	metadata := map[string]models.FaceMetadata{
+		"face_1": {OriginalImage: "task-1/a.jpg"},
+		"face_2": {OriginalImage: "task-1/a.jpg"},
+		"face_3": {OriginalImage: "/uploads/task-1/b.jpg"},
+	}
+
+	files := taskFileResults(imagePaths, metadata, "", nil)
+	assert.Equal(t, []models.TaskFile{
+		{FileName: "a.jpg", FacesCount: 2},
+		{FileName: "b.jpg", FacesCount: 1},
+		{FileName: "empty.jpg", FacesCount: 0},
+	}, files)
+
+	// Ошибка Python относится ко всем файлам
+	files = taskFileResults(imagePaths[:1], nil, "boom", nil)
+	assert.Equal(t, []models.TaskFile{{FileName: "a.jpg", Error: "boom"}}, files)
+
+	// Своя ошибка файла важнее общей
+	files = taskFileResults(imagePaths[:2], nil, "boom", map[string]string{"b.jpg": "decode"})
+	assert.Equal(t, []models.TaskFile{
+		{FileName: "a.jpg", Error: "boom"},
+		{FileName: "b.jpg", Error: "decode"},
+	}, files)
+}
+
+func TestProcessImagesConvertsHEIF(t *testing.T) {
+	if !storage.HEIFSupported {
+		t.Skip("HEIC декодер требует cgo")
+	}
+
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	sample, err := os.ReadFile("../../service/storage/testdata/sample.heic")
+	require.NoError(t, err)
+	broken := []byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic garbage")
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/IMG_1.HEIC", bytes.NewReader(sample), int64(len(sample))))
+	require.NoError(t, store.Backend().Save("task-1/broken.heic", bytes.NewReader(broken), int64(len(broken))))
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		cfg:          &config.Config{Storage: config.StorageConfig{ConvertHEIF: true}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var files []models.TaskFile
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/IMG_1.HEIC", "task-1/broken.heic", "task-1/a.jpg"},
+		models.DefaultDetectionParams(), "")
+
+	// В Python уходит JPEG вместо HEIC; битый файл не отправляется, задача не падает
+	assert.Equal(t, []string{"IMG_1.jpg", "a.jpg"}, received)
+	assert.True(t, store.FileExists("task-1/IMG_1.jpg"))
+	assert.False(t, store.FileExists("task-1/IMG_1.HEIC"))
+
+	require.Len(t, files, 3)
+	assert.Equal(t, models.TaskFile{FileName: "IMG_1.jpg"}, files[0])
+	assert.Equal(t, "broken.heic", files[1].FileName)
+	assert.Contains(t, files[1].Error, storage.ErrDecode.Error())
+	assert.Equal(t, models.TaskFile{FileName: "a.jpg"}, files[2])
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesHEIFConversionDisabled(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	sample, err := os.ReadFile("../../service/storage/testdata/sample.heic")
+	require.NoError(t, err)
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/IMG_1.HEIC", bytes.NewReader(sample), int64(len(sample))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		cfg:          &config.Config{Storage: config.StorageConfig{ConvertHEIF: false}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	mockRepo.On("SaveTaskFiles", "task-1", []models.TaskFile{{FileName: "IMG_1.HEIC"}}).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/IMG_1.HEIC"}, models.DefaultDetectionParams(), "")
+
+	assert.Equal(t, []string{"IMG_1.HEIC"}, received)
+	assert.True(t, store.FileExists("task-1/IMG_1.HEIC"))
+	mockRepo.AssertExpectations(t)
+}
+
+// testFrameExtractor вместо ffmpeg пишет frames пустых кадров
+type testFrameExtractor struct {
+	frames int
+}
+
+func (e testFrameExtractor) Duration(ctx context.Context, videoPath string) (time.Duration, error) {
+	return time.Duration(e.frames) * time.Second, nil
+}
+
+func (e testFrameExtractor) Extract(ctx context.Context, videoPath, dir string, fps float64) error {
+	for i := 1; i <= e.frames; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("frame_%06d.jpg", i)), []byte("frame"), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// testVideo - начало MP4 файла (ftyp isom)
+const testVideo = "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41 video"
+
+func TestProcessImagesExtractsVideoFrames(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		// Один человек на фото и на двух кадрах видео - один кластер
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Clusters: map[string][]string{"person_1": {"face_1", "face_2", "face_3"}},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2}, "face_2": {0.1, 0.2}, "face_3": {0.1, 0.2},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/data/task-1/a.jpg", Bbox: []int{0, 0, 10, 10}, Confidence: 0.9},
+				"face_2": {OriginalImage: "/data/task-1/clip_frame_000002.jpg", Bbox: []int{0, 0, 10, 10}, Confidence: 0.9},
+				"face_3": {OriginalImage: "/data/task-1/clip_frame_000003.jpg", Bbox: []int{0, 0, 10, 10}, Confidence: 0.9},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	store.SetVideo(testFrameExtractor{frames: 3}, storage.VideoOptions{FPS: 2, MaxDuration: time.Minute, MaxSize: 1 << 20})
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/clip.mp4", bytes.NewBufferString(testVideo), int64(len(testVideo))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var saved []*models.Face
+	var files []models.TaskFile
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("LinkTaskPerson", "task-1", 1, false).Return(nil)
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		saved = append(saved, args.Get(0).(*models.Face))
+	}).Return(nil)
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 3, 1, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg", "task-1/clip.mp4"}, models.DefaultDetectionParams(), "")
+
+	// В Python уходят кадры вместо видео
+	assert.Equal(t, []string{"a.jpg", "clip_frame_000001.jpg", "clip_frame_000002.jpg", "clip_frame_000003.jpg"}, received)
+
+	// Лица с кадров помнят время кадра, лицо с фото - нет
+	frameTimes := make(map[string]*float64)
+	for _, face := range saved {
+		frameTimes[face.OriginalImage] = face.FrameTime
+	}
+	assert.Nil(t, frameTimes["task-1/a.jpg"])
+	require.NotNil(t, frameTimes["task-1/clip_frame_000002.jpg"])
+	assert.Equal(t, 0.5, *frameTimes["task-1/clip_frame_000002.jpg"])
+	assert.Equal(t, 1.0, *frameTimes["task-1/clip_frame_000003.jpg"])
+
+	// Результат по файлам - по загруженным файлам: лица кадров засчитываются видео
+	assert.Equal(t, []models.TaskFile{
+		{FileName: "a.jpg", FacesCount: 1},
+		{FileName: "clip.mp4", FacesCount: 2},
+	}, files)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesVideoErrors(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	store.SetVideo(testFrameExtractor{frames: 120}, storage.VideoOptions{FPS: 1, MaxDuration: time.Minute, MaxSize: 1 << 20})
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/long.mp4", bytes.NewBufferString(testVideo), int64(len(testVideo))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var files []models.TaskFile
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg", "task-1/long.mp4"}, models.DefaultDetectionParams(), "")
+
+	// Слишком длинное видео помечается ошибкой и не уходит в Python; задача не падает
+	assert.Equal(t, []string{"a.jpg"}, received)
+	require.Len(t, files, 2)
+	assert.Equal(t, models.TaskFile{FileName: "a.jpg"}, files[0])
+	assert.Equal(t, "long.mp4", files[1].FileName)
+	assert.Contains(t, files[1].Error, storage.ErrVideoTooLong.Error())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesRejectsOversizedImages(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	store.SetMaxPixels(100)
+	for name, size := range map[string]int{"task-1/small.png": 10, "task-1/large.png": 11} {
+		var data bytes.Buffer
+		require.NoError(t, png.Encode(&data, image.NewRGBA(image.Rect(0, 0, size, size))))
+		require.NoError(t, store.Backend().Save(name, &data, int64(data.Len())))
+	}
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		cfg:          &config.Config{Storage: config.StorageConfig{ConvertHEIF: true}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var files []models.TaskFile
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/small.png", "task-1/large.png"}, models.DefaultDetectionParams(), "")
+
+	// Слишком большое изображение отклоняется по заголовку и не уходит в Python
+	assert.Equal(t, []string{"small.png"}, received)
+	require.Len(t, files, 2)
+	assert.Equal(t, models.TaskFile{FileName: "small.png"}, files[0])
+	assert.Equal(t, "large.png", files[1].FileName)
+	assert.Contains(t, files[1].Error, storage.ErrImageTooLarge.Error())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesRejectsEmptyAndCorruptFiles(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	var photo bytes.Buffer
+	require.NoError(t, png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+	truncated := photo.Bytes()[:20] // Обрезан посреди заголовка IHDR
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/empty.jpg", bytes.NewReader(nil), 0))
+	require.NoError(t, store.Backend().Save("task-1/cut.png", bytes.NewReader(truncated), int64(len(truncated))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var files []models.TaskFile
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg", "task-1/empty.jpg", "task-1/cut.png"},
+		models.DefaultDetectionParams(), "")
+
+	// Пустой и обрезанный файлы отклоняются с причиной и не уходят в Python
+	assert.Equal(t, []string{"a.jpg"}, received)
+	require.Len(t, files, 3)
+	assert.Equal(t, models.TaskFile{FileName: "a.jpg"}, files[0])
+	assert.Contains(t, files[1].Error, storage.ErrEmptyFile.Error())
+	assert.Contains(t, files[2].Error, storage.ErrCorruptImage.Error())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesFailsWhenAllFilesInvalid(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("битые файлы не должны уходить в Python")
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/empty.jpg", bytes.NewReader(nil), 0))
+	require.NoError(t, store.Backend().Save("task-1/notes.jpg", bytes.NewBufferString("not an image"), 12))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var errorMsg string
+	var files []models.TaskFile
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).
+		Run(func(args mock.Arguments) { errorMsg = *args.Get(2).(*string) }).
+		Return(nil)
+
+	handler.processImages("task-1", []string{"task-1/empty.jpg", "task-1/notes.jpg"}, models.DefaultDetectionParams(), "")
+
+	// Задача падает сразу, у каждого файла - своя причина
+	assert.Contains(t, errorMsg, "Нет изображений для обработки")
+	require.Len(t, files, 2)
+	assert.Contains(t, files[0].Error, storage.ErrEmptyFile.Error())
+	assert.Contains(t, files[1].Error, storage.ErrCorruptImage.Error())
+	mockRepo.AssertNotCalled(t, "UpdateTaskProgress", mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUploadValidateOnlyCorruptImage(t *testing.T) {
+	var photo bytes.Buffer
+	require.NoError(t, png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cfg: &config.Config{}}
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newNamedUploadRequest(t, "/upload?validate_only=true", [][2]string{
+		{"photo.png", photo.String()},
+		{"cut.png", photo.String()[:20]},
+	}))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var report models.UploadValidationResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	require.Len(t, report.Files, 2)
+	assert.True(t, report.Files[0].Accepted)
+	assert.False(t, report.Files[1].Accepted)
+	assert.Contains(t, report.Files[1].Reason, storage.ErrCorruptImage.Error())
+}
+
+func TestHandleTaskFiles(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	mockRepo.On("GetTaskFiles", "task-1").Return([]models.TaskFile{
+		{TaskID: "task-1", FileName: "a.jpg", FacesCount: 2},
+		{TaskID: "task-1", FileName: "empty.jpg", FacesCount: 0},
+	}, nil)
+	mockRepo.On("GetTask", "task-2").Return(&models.Task{ID: "task-2", Status: models.TaskStatusProcessing}, nil)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/task/:id/files", handler.HandleTaskFiles)
+
+	get := func(taskID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/task/"+taskID+"/files", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("task-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"task_id": "task-1",
+		"status": "completed",
+		"files": [
+			{"file_name": "a.jpg", "faces_count": 2},
+			{"file_name": "empty.jpg", "faces_count": 0}
+		]
+	}`, w.Body.String())
+
+	// Пока задача обрабатывается - результатов нет
+	w = get("task-2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"task_id": "task-2", "status": "processing", "files": []}`, w.Body.String())
+	mockRepo.AssertNotCalled(t, "GetTaskFiles", "task-2")
+
+	assert.Equal(t, http.StatusNotFound, get("missing").Code)
+}
+
+func TestHandleTaskImages(t *testing.T) {
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewBufferString("img"), 3))
+	require.NoError(t, store.Backend().Save("task-1/b.png", bytes.NewBufferString("image"), 5))
+	require.NoError(t, store.Backend().Save("task-1/face_0_boxed.jpg", bytes.NewBufferString("boxed"), 5))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusProcessing}, nil)
+	mockRepo.On("GetTask", "cleaned").Return(&models.Task{ID: "cleaned", Status: models.TaskStatusCompleted}, nil)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	router := setupTestRouter()
+	router.GET("/task/:id/images", handler.HandleTaskImages)
+
+	get := func(taskID string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/task/"+taskID+"/images", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Изображения доступны, пока задача обрабатывается; аннотированных фото в списке нет
+	w := get("task-1")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{
+		"task_id": "task-1",
+		"status": "processing",
+		"images": [
+			{"file_name": "a.jpg", "size": 3, "url": "/uploads/task-1/a.jpg"},
+			{"file_name": "b.png", "size": 5, "url": "/uploads/task-1/b.png"}
+		]
+	}`, w.Body.String())
+
+	// Папки задачи нет (удалена очисткой)
+	assert.Equal(t, http.StatusNotFound, get("cleaned").Code)
+	assert.Equal(t, http.StatusNotFound, get("missing").Code)
+}
+
+// syntheticFaces создает лица задачи: для каждого человека - size векторов вокруг
+// своей оси с разбросом spread (чем больше spread, тем хуже разделены кластеры)
+func syntheticFaces(t *testing.T, rng *rand.Rand, persons, size int, spread float64) []models.Face {
+	var faces []models.Face
+	for person := 1; person <= persons; person++ {
+		for i := 0; i < size; i++ {
+			vector := make([]float64, 8)
+			vector[person-1] = 1
+			for j := range vector {
+				vector[j] += spread * rng.NormFloat64()
+			}
+			data, err := json.Marshal(vector)
+			require.NoError(t, err)
+			faces = append(faces, models.Face{ID: len(faces) + 1, PersonID: person, TaskID: "task-1", Embedding: data})
+		}
+	}
+	return faces
+}
+
+func TestHandleTaskMetrics(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	get := func(faces []models.Face) models.TaskMetricsResponse {
+		mockRepo := new(MockRepository)
+		mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+		mockRepo.On("GetTaskFaces", "task-1").Return(faces, nil)
+		handler := &Handler{repo: mockRepo}
+
+		router := setupTestRouter()
+		router.GET("/task/:id/metrics", handler.HandleTaskMetrics)
+
+		req, _ := http.NewRequest("GET", "/task/task-1/metrics", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var report models.TaskMetricsResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		return report
+	}
+
+	// Хорошо разделенные кластеры: лица близко к своему центроиду, центроиды далеко
+	good := get(syntheticFaces(t, rng, 3, 5, 0.05))
+	assert.Equal(t, "task-1", good.TaskID)
+	assert.Equal(t, 15, good.Faces)
+	assert.Equal(t, 3, good.ClusterCount)
+	assert.Equal(t, 0, good.SingletonCount)
+	assert.Equal(t, 5.0, good.AvgClusterSize)
+	assert.Equal(t, 5, good.MaxClusterSize)
+	assert.Less(t, good.IntraDistance, 0.05)
+	require.NotNil(t, good.InterDistance)
+	assert.InDelta(t, 1.0, *good.InterDistance, 0.1)
+	require.NotNil(t, good.Silhouette)
+	assert.Greater(t, *good.Silhouette, 0.9)
+	require.Len(t, good.Clusters, 3)
+	assert.Equal(t, 1, good.Clusters[0].PersonID)
+	require.NotNil(t, good.Clusters[0].NearestPersonID)
+
+	// Плохо разделенные: разброс больше расстояния между осями
+	poor := get(syntheticFaces(t, rng, 3, 5, 1.0))
+	assert.Equal(t, 3, poor.ClusterCount)
+	assert.Greater(t, poor.IntraDistance, good.IntraDistance)
+	require.NotNil(t, poor.Silhouette)
+	assert.Less(t, *poor.Silhouette, 0.5)
+	assert.Less(t, *poor.Silhouette, *good.Silhouette)
+
+	// Один кластер: межкластерных метрик нет; лица без embedding не учитываются
+	faces := syntheticFaces(t, rng, 1, 3, 0.05)
+	faces = append(faces, models.Face{ID: 10, PersonID: 1, TaskID: "task-1"})
+	single := get(faces)
+	assert.Equal(t, 3, single.Faces)
+	assert.Equal(t, 1, single.SkippedFaces)
+	assert.Equal(t, 1, single.ClusterCount)
+	assert.Nil(t, single.InterDistance)
+	assert.Nil(t, single.MinInterDistance)
+	assert.Nil(t, single.Silhouette)
+	require.Len(t, single.Clusters, 1)
+	assert.Nil(t, single.Clusters[0].NearestPersonID)
+
+	// Задача без лиц
+	empty := get([]models.Face{})
+	assert.Equal(t, 0, empty.ClusterCount)
+	assert.Empty(t, empty.Clusters)
+}
+
+func TestHandleTaskMetricsNotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/task/:id/metrics", handler.HandleTaskMetrics)
+
+	req, _ := http.NewRequest("GET", "/task/missing/metrics", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	mockRepo.AssertNotCalled(t, "GetTaskFaces", mock.Anything)
+}
+
+func TestHandleGetPersonsByTag(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetPersonsByTag", "staff").Return([]models.PersonWithFaces{
+		{Person: models.Person{ID: 1, Name: "Alice"}, Count: 3},
+	}, nil)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	req, _ := http.NewRequest("GET", "/persons?tag=staff", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var persons []models.PersonWithFaces
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &persons))
+	require.Len(t, persons, 1)
+	assert.Equal(t, "Alice", persons[0].Name)
+	mockRepo.AssertNotCalled(t, "GetAllPersons")
+}
+
+func TestHandleGetPersonsUnlabeled(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetUnlabeledPersons").Return([]models.PersonWithFaces{
+		{Person: models.Person{ID: 2, Name: "person_2"}, Count: 4},
+	}, nil).Once()
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := get("/persons?unlabeled=true")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var persons []models.PersonWithFaces
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &persons))
+	require.Len(t, persons, 1)
+	assert.Equal(t, "person_2", persons[0].Name)
+
+	// Фильтр не сочетается с метками и страницами
+	assert.Equal(t, http.StatusBadRequest, get("/persons?unlabeled=true&tag=staff").Code)
+	assert.Equal(t, http.StatusBadRequest, get("/persons?unlabeled=true&limit=10").Code)
+
+	mockRepo.AssertNotCalled(t, "GetAllPersons")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleAddPersonTags(t *testing.T) {
+	mockRepo := new(MockRepository)
+	// Регистр и повторы убираются до обращения к БД
+	mockRepo.On("AddPersonTags", 1, []string{"staff", "vip"}).Return([]string{"staff", "suspect", "vip"}, nil)
+	mockRepo.On("AddPersonTags", 2, []string{"staff"}).Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.POST("/persons/:id/tags", handler.HandleAddPersonTags)
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post("/persons/1/tags", `{"tags": ["Staff", " VIP ", "staff"]}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"person_id": 1, "tags": ["staff", "suspect", "vip"]}`, w.Body.String())
+
+	assert.Equal(t, http.StatusNotFound, post("/persons/2/tags", `{"tags": ["staff"]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/persons/1/tags", `{"tags": []}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/persons/1/tags", `{"tags": ["  "]}`).Code)
+	assert.Equal(t, http.StatusBadRequest, post("/persons/1/tags", `{"tags": ["`+strings.Repeat("я", models.MaxTagLength+1)+`"]}`).Code)
+}
+
+func TestHandleRemovePersonTag(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("RemovePersonTag", 1, "VIP").Return(nil)
+	mockRepo.On("RemovePersonTag", 1, "missing").Return(sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.DELETE("/persons/:id/tags/:tag", handler.HandleRemovePersonTag)
+
+	req, _ := http.NewRequest("DELETE", "/persons/1/tags/VIP", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	req, _ = http.NewRequest("DELETE", "/persons/1/tags/missing", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// ============ ERRORS ============
+
+func TestRespondError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+		body   string
+	}{
+		{"validation", apierror.Validation("Неверный ID"), http.StatusBadRequest, apierror.CodeValidation, "Неверный ID"},
+		{"wrapped", fmt.Errorf("person: %w", apierror.NotFound("Человек не найден")), http.StatusNotFound, apierror.CodeNotFound, "Человек не найден"},
+		{"upstream hides cause", apierror.Upstream("Ошибка сравнения embedding", errors.New("python: boom")), http.StatusBadGateway, apierror.CodeUpstream, "Ошибка сравнения embedding"},
+		{"raw error is internal", errors.New("pq: password authentication failed"), http.StatusInternalServerError, apierror.CodeInternal, "Внутренняя ошибка сервера"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupTestRouter()
+			router.GET("/err", func(c *gin.Context) { respondError(c, tt.err) })
+
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/err", nil))
+
+			assert.Equal(t, tt.status, w.Code)
+			var resp models.ErrorResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+			assert.Equal(t, models.ErrorResponse{Error: tt.body, Code: tt.code}, resp)
+		})
+	}
+}
+
+func TestRepoError(t *testing.T) {
+	err := repoError(sql.ErrNoRows, "Задача не найдена")
+	assert.True(t, errors.Is(err, apierror.ErrNotFound))
+	assert.Equal(t, "Задача не найдена", err.Error())
+
+	err = repoError(fmt.Errorf("get: %w", sql.ErrNoRows), "Лицо не найдено")
+	assert.True(t, errors.Is(err, apierror.ErrNotFound))
+
+	cause := errors.New("db down")
+	err = repoError(cause, "Лицо не найдено")
+	assert.True(t, errors.Is(err, apierror.ErrInternal))
+	assert.True(t, errors.Is(err, cause))
+}
+
+func TestHandleTaskStatusErrorCodes(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "missing").Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetTask", "broken").Return(nil, errors.New("pq: connection refused"))
+	handler := &Handler{repo: mockRepo}
+
+	router := setupTestRouter()
+	router.GET("/api/task/:id", handler.HandleTaskStatus)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/task/missing", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assertErrorCode(t, w, apierror.CodeNotFound)
+
+	// Текст ошибки БД в ответ не попадает
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/task/broken", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assertErrorCode(t, w, apierror.CodeInternal)
+	assert.NotContains(t, w.Body.String(), "pq:")
+}
+
+// ============ UPLOAD BY URL TESTS ============
+
+func newTestDownloader() *downloader.Downloader {
+	return downloader.New(config.URLUploadConfig{
+		MaxURLs:      2,
+		MaxFileSize:  1 << 20,
+		Timeout:      5 * time.Second,
+		AllowPrivate: true, // httptest слушает на 127.0.0.1
+	})
+}
+
+func TestHandleUploadURLsValidation(t *testing.T) {
+	// Загрузчик по умолчанию: адреса внутренней сети запрещены
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, downloader: downloader.New(config.URLUploadConfig{
+		MaxURLs: 2, MaxFileSize: 1 << 20, Timeout: time.Second,
+	})}
+
+	router := setupTestRouter()
+	router.POST("/api/upload/urls", handler.HandleUploadURLs)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"неверный JSON", `{"urls":`},
+		{"нет URL", `{"urls": []}`},
+		{"только пустые URL", `{"urls": ["", "  "]}`},
+		{"слишком много URL", `{"urls": ["http://a.example.com/1.jpg", "http://a.example.com/2.jpg", "http://a.example.com/3.jpg"]}`},
+		{"неверная схема", `{"urls": ["ftp://example.com/a.jpg"]}`},
+		{"адрес во внутренней сети", `{"urls": ["http://169.254.169.254/latest/meta-data"]}`},
+		{"callback без notifier", `{"urls": ["http://example.com/a.jpg"], "callback_url": "http://example.com/hook"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/api/upload/urls", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleUploadURLsDisabled(t *testing.T) {
+	handler := &Handler{repo: new(MockRepository)}
+
+	router := setupTestRouter()
+	router.POST("/api/upload/urls", handler.HandleUploadURLs)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/upload/urls", strings.NewReader(`{"urls": ["http://example.com/a.jpg"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func TestHandleUploadURLsCreatesTask(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	}))
+	defer images.Close()
+
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		downloader:   newTestDownloader(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var created *models.Task
+	done := make(chan struct{})
+	mockRepo.On("CreateTask", mock.Anything).
+		Run(func(args mock.Arguments) { created = args.Get(0).(*models.Task) }).
+		Return(nil)
+	mockRepo.On("SaveTaskFiles", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("MarkTaskStarted", mock.Anything).Return(nil)
+	mockRepo.On("GetOriginalFilenames", mock.Anything).Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", mock.Anything, 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", mock.Anything, models.TaskStatusCompleted, (*string)(nil)).
+		Run(func(args mock.Arguments) { close(done) }).
+		Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	router := setupTestRouter()
+	router.POST("/api/upload/urls", handler.HandleUploadURLs)
+
+	body := fmt.Sprintf(`{"urls": [" %s/a.png ", "%s/b.png"]}`, images.URL, images.URL)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/upload/urls", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+
+	var response models.UploadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.NotNil(t, created)
+	assert.Equal(t, created.ID, response.TaskID)
+	assert.Equal(t, "/api/task/"+created.ID, w.Header().Get("Location"))
+	assert.Equal(t, 2, created.TotalImages)
+	assert.Empty(t, created.ContentHash)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("задача не завершилась")
+	}
+
+	assert.True(t, store.FileExists(response.TaskID+"/a.png"))
+	assert.True(t, store.FileExists(response.TaskID+"/b.png"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDownloadAndProcessReportsFailedURLs(t *testing.T) {
+	var received []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseMultipartForm(32<<20))
+		for _, file := range r.MultipartForm.File["images"] {
+			received = append(received, file.Filename)
+		}
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.jpg":
+			http.NotFound(w, r)
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			w.Header().Set("Content-Type", "image/png")
+			png.Encode(w, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+		}
+	}))
+	defer images.Close()
+
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		downloader:   newTestDownloader(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	var files []models.TaskFile
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	urls := []string{
+		images.URL + "/x/photo.png",
+		images.URL + "/missing.jpg",
+		images.URL + "/y/photo.png",
+		images.URL + "/page.html",
+	}
+	handler.downloadAndProcess("task-1", urls, models.DefaultDetectionParams(), "")
+
+	// Одинаковые имена из разных URL не перезаписывают друг друга
+	assert.Equal(t, []string{"photo.png", "photo_2.png"}, received)
+
+	require.Len(t, files, 4)
+	assert.Equal(t, models.TaskFile{FileName: "photo.png"}, files[0])
+	assert.Equal(t, models.TaskFile{FileName: "photo_2.png"}, files[1])
+	assert.Equal(t, urls[1], files[2].FileName)
+	assert.Contains(t, files[2].Error, "404")
+	assert.Equal(t, urls[3], files[3].FileName)
+	assert.Contains(t, files[3].Error, downloader.ErrNotImage.Error())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUniqueFileName(t *testing.T) {
+	used := map[string]bool{}
+
+	assert.Equal(t, "image.jpg", uniqueFileName("image.jpg", used))
+	assert.Equal(t, "image_2.jpg", uniqueFileName("image.jpg", used))
+	assert.Equal(t, "IMAGE_3.JPG", uniqueFileName("IMAGE.JPG", used))
+	assert.Equal(t, "photo", uniqueFileName("photo", used))
+	assert.Equal(t, "photo_2", uniqueFileName("photo", used))
+}
+
+// ============ PYTHON RESULT VALIDATION TESTS ============
+
+func TestCheckPythonResult(t *testing.T) {
+	result := &models.PythonResponse{
+		Clusters: map[string][]string{
+			"person_2": {"face_4", "face_1"},
+			"person_1": {"face_1", "face_2", "face_3"},
+			"noise":    {"face_9"},
+		},
+		Embeddings: map[string][]float64{
+			"face_1": {0.1},
+			"face_2": {},
+			"face_4": {0.4},
+		},
+		FacesMetadata: map[string]models.FaceMetadata{
+			"face_1": {},
+			"face_2": {},
+			"face_3": {},
+		},
+	}
+
+	check := checkPythonResult(result)
+
+	assert.Equal(t, 5, check.total)
+	assert.Equal(t, 4, check.inconsistent)
+	assert.Equal(t, map[string][]string{
+		"person_1": {"face_1"},
+		"person_2": {},
+		"noise":    {"face_9"},
+	}, check.clusters)
+
+	// Кластеры проверяются по порядку ID - предупреждения стабильны между запусками
+	assert.Equal(t, []string{
+		"лицо face_2 (кластер person_1): нет embedding",
+		"лицо face_3 (кластер person_1): нет embedding",
+		"лицо face_4 (кластер person_2): нет метаданных",
+		"лицо face_1 уже есть в кластере person_1",
+	}, check.warnings)
+}
+
+func TestCheckPythonResultCapsWarnings(t *testing.T) {
+	faceIDs := make([]string, maxTaskWarnings+10)
+	for i := range faceIDs {
+		faceIDs[i] = fmt.Sprintf("face_%d", i)
+	}
+
+	check := checkPythonResult(&models.PythonResponse{
+		Clusters: map[string][]string{"person_1": faceIDs},
+	})
+
+	assert.Equal(t, len(faceIDs), check.inconsistent)
+	require.Len(t, check.warnings, maxTaskWarnings+1)
+	assert.Equal(t, "... и еще 10", check.warnings[maxTaskWarnings])
+}
+
+// malformedPythonServer отдает кластер из пяти лиц, у части которых нет данных
+func malformedPythonServer(t *testing.T, broken int) *httptest.Server {
+	response := models.PythonResponse{
+		Success:       true,
+		Clusters:      map[string][]string{"person_1": {}},
+		Embeddings:    map[string][]float64{},
+		FacesMetadata: map[string]models.FaceMetadata{},
+	}
+	for i := 1; i <= 5; i++ {
+		faceID := fmt.Sprintf("face_%d", i)
+		response.Clusters["person_1"] = append(response.Clusters["person_1"], faceID)
+		response.FacesMetadata[faceID] = models.FaceMetadata{OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{i * 10, 0, i*10 + 10, 10}}
+		if i > broken {
+			response.Embeddings[faceID] = []float64{0.1, 0.2}
+		}
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestProcessImagesReportsInconsistentFaces(t *testing.T) {
+	// 1 из 5 лиц без embedding - 20%, в пределах допустимого
+	python := malformedPythonServer(t, 1)
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	client := &websocket.Client{ID: "task-client", Send: make(chan websocket.Message, 64), TaskID: "task-1"}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	warnings := []string{"лицо face_1 (кластер person_1): нет embedding"}
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, false, nil)
+	mockRepo.On("CreateFace", mock.Anything).Return(nil).Times(4)
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 4, 1, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskWarnings", "task-1", warnings).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	mockRepo.AssertExpectations(t)
+
+	// Предупреждения приходят в итоговом сообщении
+	for {
+		message := waitForMessage(t, client, websocket.MessageTypeTaskUpdate)
+		payload := message.Payload.(map[string]interface{})
+		if payload["status"] != models.TaskStatusCompleted {
+			continue
+		}
+		data := payload["data"].(map[string]interface{})
+		assert.Equal(t, warnings, data["warnings"])
+		break
+	}
+}
+
+func TestProcessImagesFailsOnInconsistentResult(t *testing.T) {
+	// 2 из 5 лиц без embedding - 40% при допустимых 20%
+	python := malformedPythonServer(t, 2)
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager, _ := newTestWSClient(t)
+	client := &websocket.Client{ID: "task-client", Send: make(chan websocket.Message, 64), TaskID: "task-1"}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+		cfg:          &config.Config{Detection: config.DetectionConfig{MaxInconsistentRatio: 0.2}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	var errorMsg string
+	var files []models.TaskFile
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).
+		Run(func(args mock.Arguments) { files = args.Get(1).([]models.TaskFile) }).
+		Return(nil)
+	mockRepo.On("UpdateTaskWarnings", "task-1", []string{
+		"лицо face_1 (кластер person_1): нет embedding",
+		"лицо face_2 (кластер person_1): нет embedding",
+	}).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).
+		Run(func(args mock.Arguments) { errorMsg = *args.Get(2).(*string) }).
+		Return(nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	// Ничего из несогласованного ответа не сохраняется
+	mockRepo.AssertNotCalled(t, "GetOrCreatePerson", mock.Anything)
+	mockRepo.AssertNotCalled(t, "CreateFace", mock.Anything)
+	assert.Contains(t, errorMsg, "2 из 5 лиц")
+	require.Len(t, files, 1)
+	assert.Equal(t, errorMsg, files[0].Error)
+
+	mockRepo.AssertExpectations(t)
+
+	for {
+		message := waitForMessage(t, client, websocket.MessageTypeTaskUpdate)
+		payload := message.Payload.(map[string]interface{})
+		if payload["status"] != models.TaskStatusFailed {
+			continue
+		}
+		data := payload["data"].(map[string]interface{})
+		assert.Equal(t, errorMsg, data["error"])
+		assert.Len(t, data["warnings"], 2)
+		break
+	}
+}
+
+// ============ DEDUPE ============
+
+// burstFaces - серия снимков: 1-3 почти одинаковые, 4-5 почти одинаковые,
+// 6 отличается, у 7 нет embedding
+func burstFaces() []models.Face {
+	return []models.Face{
+		{ID: 1, PersonID: 5, Confidence: 0.90, Embedding: []byte("[1, 0, 0, 0]"),
+			OriginalImage: "task-1/a.jpg", AnnotatedImage: "task-1/face_1_boxed.jpg"},
+		{ID: 2, PersonID: 5, Confidence: 0.97, Embedding: []byte("[0.99, 0.05, 0, 0]"),
+			OriginalImage: "task-1/b.jpg", AnnotatedImage: "task-1/face_2_boxed.jpg"},
+		{ID: 3, PersonID: 5, Confidence: 0.80, Embedding: []byte("[0.98, 0, 0.06, 0]"),
+			OriginalImage: "task-1/c.jpg", AnnotatedImage: "task-1/face_3_boxed.jpg"},
+		{ID: 4, PersonID: 5, Confidence: 0.85, Embedding: []byte("[0, 1, 0, 0]"),
+			OriginalImage: "task-1/d.jpg"},
+		{ID: 5, PersonID: 5, Confidence: 0.85, Embedding: []byte("[0.02, 1, 0.01, 0]"),
+			OriginalImage: "task-1/e.jpg"},
+		{ID: 6, PersonID: 5, Confidence: 0.99, Embedding: []byte("[0, 0, 0, 1]"),
+			OriginalImage: "task-1/f.jpg"},
+		{ID: 7, PersonID: 5, Confidence: 0.95, OriginalImage: "task-1/g.jpg"},
+	}
+}
+
+func TestDedupeFaces(t *testing.T) {
+	groups := dedupeFaces(burstFaces(), 0.95)
+
+	// Представитель - лицо с наибольшей уверенностью, при равенстве - с меньшим ID
+	assert.Equal(t, []models.DedupeGroup{
+		{Keep: 2, Duplicates: []int{1, 3}},
+		{Keep: 4, Duplicates: []int{5}},
+	}, groups)
+
+	// Порог выше сходства серии - дубликатов нет
+	assert.Empty(t, dedupeFaces(burstFaces(), 0.9999))
+	assert.Empty(t, dedupeFaces(nil, 0.95))
+}
+
+func TestDedupeFacesComparesWithRepresentative(t *testing.T) {
+	// b похоже и на a, и на c, но a и c между собой - нет: c остается отдельно
+	faces := []models.Face{
+		{ID: 1, Confidence: 0.9, Embedding: []byte("[1, 0]")},
+		{ID: 2, Confidence: 0.8, Embedding: []byte("[0.97, 0.26]")},
+		{ID: 3, Confidence: 0.7, Embedding: []byte("[0.87, 0.5]")},
+	}
+
+	assert.Equal(t, []models.DedupeGroup{{Keep: 1, Duplicates: []int{2}}}, dedupeFaces(faces, 0.95))
+}
+
+func postDedupe(handler *Handler, query string) *httptest.ResponseRecorder {
+	router := setupTestRouter()
+	router.POST("/api/persons/:id/dedupe", handler.HandleDedupePerson)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/persons/5/dedupe"+query, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleDedupePersonDryRun(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetPersonByID", 5).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 5, Name: "Alice"},
+		Faces:  burstFaces(),
+	}, nil)
+
+	w := postDedupe(&Handler{repo: mockRepo}, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.DedupeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.False(t, response.Applied)
+	assert.Equal(t, 3, response.Removed)
+	assert.Equal(t, models.DefaultDedupeThreshold, response.Threshold)
+	assert.Len(t, response.Groups, 2)
+
+	// threshold из запроса переопределяет DEDUPE_THRESHOLD
+	w = postDedupe(&Handler{repo: mockRepo}, "?threshold=0.9999")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	response = models.DedupeResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0.9999, response.Threshold)
+	assert.Equal(t, 0, response.Removed)
+	assert.Empty(t, response.Groups)
+
+	// Dry-run ничего не удаляет
+	mockRepo.AssertNotCalled(t, "DeleteFaces", mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleDedupePersonApply(t *testing.T) {
+	store := newTestStorage(t)
+	for _, key := range []string{"task-1/a.jpg", "task-1/face_1_boxed.jpg", "task-1/c.jpg", "task-1/face_3_boxed.jpg"} {
+		assert.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	}
+
+	faces := burstFaces()
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetPersonByID", 5).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 5, Name: "Alice"},
+		Faces:  faces,
+	}, nil)
+	// Оригинал c.jpg еще используется лицом другого человека - его файл не удаляется
+	mockRepo.On("DeleteFaces", 5, []int{1, 3, 5}).Return(
+		[]models.Face{faces[0], faces[2], faces[4]},
+		[]string{"task-1/a.jpg", "task-1/face_1_boxed.jpg", "task-1/face_3_boxed.jpg", "task-1/e.jpg"},
+		nil,
+	)
+
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	assert.NoError(t, cacheService.SetPerson(context.Background(), &models.PersonWithFaces{Person: models.Person{ID: 5}}))
+
+	handler := &Handler{repo: mockRepo, storage: store, cache: cacheService}
+	w := postDedupe(handler, "?apply=true")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.DedupeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(t, response.Applied)
+	assert.Equal(t, 3, response.Removed)
+
+	for _, key := range []string{"task-1/a.jpg", "task-1/face_1_boxed.jpg", "task-1/face_3_boxed.jpg"} {
+		_, err := store.Backend().Size(key)
+		assert.ErrorIs(t, err, storage.ErrNotFound, key)
+	}
+	_, err := store.Backend().Size("task-1/c.jpg")
+	assert.NoError(t, err)
+
+	cached, err := cacheService.GetPerson(context.Background(), 5)
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleDedupePersonErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetPersonByID", 5).Return(nil, sql.ErrNoRows)
+	handler := &Handler{repo: mockRepo}
+
+	w := postDedupe(handler, "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	for _, threshold := range []string{"0", "1.5", "-0.2", "abc", "NaN"} {
+		w := postDedupe(handler, "?threshold="+threshold)
+		assert.Equal(t, http.StatusBadRequest, w.Code, threshold)
+	}
+}
+
+// ============ DUPLICATE PERSONS ============
+
+// clusterCentroid возвращает центроид n embedding вокруг center с шумом noise
+func clusterCentroid(rng *rand.Rand, center []float64, n int, noise float64) []float64 {
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		vectors[i] = make([]float64, len(center))
+		for j := range center {
+			vectors[i][j] = center[j] + rng.NormFloat64()*noise
+		}
+	}
+	centroid, _ := embedding.Centroid(vectors)
+	return centroid
+}
+
+// duplicateClusters - синтетическая база: люди 1, 2 и 4 - один и тот же человек
+// (лица из одного кластера), 3 - частично пересекающийся с ними кластер,
+// у 5 нет центроида (нет лиц с embedding)
+func duplicateClusters() ([]models.PersonWithFaces, map[int][]float64) {
+	rng := rand.New(rand.NewSource(1))
+	const dim = 64
+
+	shared := make([]float64, dim)
+	other := make([]float64, dim)
+	for i := 0; i < dim; i++ {
+		shared[i] = rng.NormFloat64()
+		other[i] = rng.NormFloat64()
+	}
+	// Кластер человека 3 частично совпадает с общим: сходство центроидов около 0.45
+	overlapping := make([]float64, dim)
+	for i := range overlapping {
+		overlapping[i] = shared[i]/2 + other[i]
+	}
+
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	persons := make([]models.PersonWithFaces, 5)
+	for i := range persons {
+		persons[i] = models.PersonWithFaces{
+			Person: models.Person{ID: i + 1, Name: fmt.Sprintf("Person %d", i+1), CreatedAt: base.AddDate(0, 0, i)},
+			Count:  3,
+		}
+	}
+
+	centroids := map[int][]float64{
+		1: clusterCentroid(rng, shared, 3, 0.3),
+		2: clusterCentroid(rng, shared, 3, 0.3),
+		3: clusterCentroid(rng, overlapping, 3, 0.3),
+		4: clusterCentroid(rng, shared, 3, 0.3),
+	}
+	return persons, centroids
+}
+
+func TestFindDuplicatePairs(t *testing.T) {
+	persons, centroids := duplicateClusters()
+
+	pairs := findDuplicatePairs(persons, centroids, 0.6)
+
+	var ids [][2]int
+	for _, pair := range pairs {
+		ids = append(ids, [2]int{pair.PersonA.ID, pair.PersonB.ID})
+		assert.Greater(t, pair.Similarity, 0.9)
+		assert.True(t, pair.PersonA.CreatedAt.After(pair.PersonB.CreatedAt))
+	}
+	// Сначала пары с самым новым человеком (4), затем с 2; 3 пересекается слабо
+	require.Len(t, ids, 3)
+	assert.Equal(t, 4, ids[0][0])
+	assert.Equal(t, 4, ids[1][0])
+	assert.ElementsMatch(t, []int{1, 2}, []int{ids[0][1], ids[1][1]})
+	assert.Equal(t, [2]int{2, 1}, ids[2])
+	assert.GreaterOrEqual(t, pairs[0].Similarity, pairs[1].Similarity)
+
+	// Пересекающийся кластер находится при низком пороге; человек 5 без центроида не участвует
+	pairs = findDuplicatePairs(persons, centroids, 0.3)
+	assert.Len(t, pairs, 6)
+
+	assert.Empty(t, findDuplicatePairs(persons, centroids, 1))
+	assert.Empty(t, findDuplicatePairs(nil, centroids, 0.6))
+}
+
+func getDuplicates(handler *Handler, query string) *httptest.ResponseRecorder {
+	router := setupTestRouter()
+	router.GET("/api/persons/duplicates", handler.HandleFindDuplicatePersons)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/persons/duplicates"+query, nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleFindDuplicatePersons(t *testing.T) {
+	persons, centroids := duplicateClusters()
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetAllPersons").Return(persons, nil)
+	mockRepo.On("GetPersonCentroids").Return(centroids, nil)
+	handler := &Handler{repo: mockRepo}
+
+	w := getDuplicates(handler, "")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.DuplicatePersonsResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, embedding.DefaultMatchThreshold, response.Threshold)
+	assert.Equal(t, 3, response.Total)
+	require.Len(t, response.Pairs, 3)
+	assert.Equal(t, 4, response.Pairs[0].PersonA.ID)
+	assert.Equal(t, "Person 4", response.Pairs[0].PersonA.Name)
+	assert.Equal(t, 3, response.Pairs[0].PersonA.FacesCount)
+
+	// limit обрезает список, total - сколько пар всего
+	w = getDuplicates(handler, "?threshold=0.3&limit=2")
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	response = models.DuplicatePersonsResponse{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0.3, response.Threshold)
+	assert.Equal(t, 6, response.Total)
+	assert.Len(t, response.Pairs, 2)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleFindDuplicatePersonsErrors(t *testing.T) {
+	handler := &Handler{repo: new(MockRepository)}
+	for _, query := range []string{"?threshold=0", "?threshold=1.5", "?threshold=abc", "?threshold=NaN", "?limit=0", "?limit=1001", "?limit=x"} {
+		w := getDuplicates(handler, query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		assertErrorCode(t, w, apierror.CodeValidation)
+	}
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetAllPersons").Return([]models.PersonWithFaces{}, nil)
+	mockRepo.On("GetPersonCentroids").Return(nil, errors.New("db down"))
+
+	w := getDuplicates(&Handler{repo: mockRepo}, "")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assertErrorCode(t, w, apierror.CodeInternal)
+	assert.NotContains(t, w.Body.String(), "db down")
+}
+
+// ============ SEARCH BY IMAGE URL ============
+
+// newFaceSearchHandler - обработчик поиска с сервером изображений и Python, который
+// находит на любом фото маленькое лицо f0 и крупное f1 с заданным embedding
+func newFaceSearchHandler(t *testing.T, probe []float64) (*Handler, *MockRepository, *httptest.Server, *[]string) {
+	var taskIDs []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		taskIDs = append(taskIDs, r.FormValue("task_id"))
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success: true,
+			Embeddings: map[string][]float64{
+				"f0": {0, 1},
+				"f1": probe,
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"f0": {Bbox: []int{0, 0, 10, 10}},
+				"f1": {Bbox: []int{20, 20, 80, 90}},
+			},
+		})
+	}))
+	t.Cleanup(python.Close)
+
+	images := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	}))
+	t.Cleanup(images.Close)
+
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		downloader:   newTestDownloader(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	return handler, mockRepo, images, &taskIDs
+}
+
+func searchFace(handler *Handler, query string) *httptest.ResponseRecorder {
+	router := setupTestRouter()
+	router.GET("/api/search/face", handler.HandleSearchFace)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/search/face"+query, nil))
+	return w
+}
+
+func TestFindSimilarPersons(t *testing.T) {
+	centroids := map[int][]float64{
+		1: {0.8, 0.6},
+		2: {1, 0},
+		3: {0, 1},
+		4: {1, 0, 0}, // Другая модель
+	}
+
+	matches := findSimilarPersons([]float64{1, 0}, centroids, 0.6)
+
+	require.Len(t, matches, 2)
+	assert.Equal(t, 2, matches[0].ID)
+	assert.InDelta(t, 1, matches[0].Similarity, 1e-9)
+	assert.Equal(t, 1, matches[1].ID)
+	assert.InDelta(t, 0.8, matches[1].Similarity, 1e-9)
+
+	assert.Len(t, findSimilarPersons([]float64{1, 0}, centroids, 0.9), 1)
+	assert.Empty(t, findSimilarPersons([]float64{1, 0}, centroids, 1))
+
+	// Человек, удаленный после чтения центроидов, выпадает; порядок сохраняется
+	attached := attachPersons(matches, []models.PersonWithFaces{
+		{Person: models.Person{ID: 2, Name: "Bob"}, Count: 5},
+	})
+	require.Len(t, attached, 1)
+	assert.Equal(t, "Bob", attached[0].Name)
+	assert.Equal(t, 5, attached[0].FacesCount)
+	assert.InDelta(t, 1, attached[0].Similarity, 1e-9)
+}
+
+func TestHandleSearchFace(t *testing.T) {
+	// Ищется по крупному лицу f1; его embedding не нормирован
+	handler, mockRepo, images, taskIDs := newFaceSearchHandler(t, []float64{2, 0})
+	// Читаются только найденные люди, самые похожие первыми
+	alice := models.PersonWithFaces{Person: models.Person{ID: 1, Name: "Alice"}, Count: 2}
+	bob := models.PersonWithFaces{Person: models.Person{ID: 2, Name: "Bob"}, Count: 5}
+	mockRepo.On("GetPersonsByIDs", []int{2, 1}).Return([]models.PersonWithFaces{alice, bob}, nil)
+	mockRepo.On("GetPersonsByIDs", []int{2}).Return([]models.PersonWithFaces{bob}, nil)
+	mockRepo.On("GetPersonCentroids").Return(map[int][]float64{
+		1: {0.8, 0.6},
+		2: {1, 0},
+		3: {0, 1},
+	}, nil)
+
+	imageURL := images.URL + "/face.png"
+	w := searchFace(handler, "?image_url="+url.QueryEscape(imageURL))
+
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var response models.FaceSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, imageURL, response.ImageURL)
+	assert.Equal(t, 2, response.FacesFound)
+	assert.Equal(t, []int{20, 20, 80, 90}, response.Bbox)
+	assert.Equal(t, embedding.DefaultMatchThreshold, response.Threshold)
+	require.Len(t, response.Matches, 2)
+	assert.Equal(t, "Bob", response.Matches[0].Name)
+	assert.Equal(t, 5, response.Matches[0].FacesCount)
+	assert.InDelta(t, 1, response.Matches[0].Similarity, 1e-9)
+	assert.Equal(t, "Alice", response.Matches[1].Name)
+	assert.InDelta(t, 0.8, response.Matches[1].Similarity, 1e-9)
+
+	// Изображение отправлено во временную задачу, которая затем удалена
+	require.Len(t, *taskIDs, 1)
+	assert.True(t, strings.HasPrefix((*taskIDs)[0], "search-"))
+	assert.False(t, handler.storage.FileExists((*taskIDs)[0]+"/face.png"))
+
+	// Порог и limit - как у поиска дубликатов
+	w = searchFace(handler, "?threshold=0.9&image_url="+url.QueryEscape(imageURL))
+	require.Equal(t, http.StatusOK, w.Code)
+	response = models.FaceSearchResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0.9, response.Threshold)
+	require.Len(t, response.Matches, 1)
+	assert.Equal(t, 2, response.Matches[0].ID)
+
+	w = searchFace(handler, "?limit=1&image_url="+url.QueryEscape(imageURL))
+	require.Equal(t, http.StatusOK, w.Code)
+	response = models.FaceSearchResponse{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Matches, 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleSearchFaceErrors(t *testing.T) {
+	handler, mockRepo, images, taskIDs := newFaceSearchHandler(t, []float64{1, 0})
+
+	for _, query := range []string{
+		"",
+		"?image_url=",
+		"?image_url=ftp://example.com/a.png",
+		"?image_url=" + url.QueryEscape(images.URL+"/missing.png"),
+		"?threshold=2&image_url=" + url.QueryEscape(images.URL+"/a.png"),
+		"?limit=101&image_url=" + url.QueryEscape(images.URL+"/a.png"),
+	} {
+		w := searchFace(handler, query)
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+		assert.Contains(t, w.Body.String(), "error", query)
+	}
+
+	// Ошибка скачивания объясняет причину
+	w := searchFace(handler, "?image_url="+url.QueryEscape(images.URL+"/missing.png"))
+	assert.Contains(t, w.Body.String(), "Не удалось скачать изображение")
+
+	// Без URL загрузки поиск недоступен
+	w = searchFace(&Handler{repo: mockRepo}, "?image_url="+url.QueryEscape(images.URL+"/a.png"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	assert.Empty(t, *taskIDs)
+	mockRepo.AssertNotCalled(t, "GetPersonCentroids")
+}
+
+func TestHandleSearchFaceNoFaces(t *testing.T) {
+	handler, mockRepo, images, _ := newFaceSearchHandler(t, nil)
+	// Python не нашел лиц
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+	handler.pythonClient = python_client.NewClient(python.URL)
+	handler.pythonClient.SetFileOpener(handler.storage.Open)
+
+	w := searchFace(handler, "?image_url="+url.QueryEscape(images.URL+"/a.png"))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.FaceSearchResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0, response.FacesFound)
+	assert.Nil(t, response.Bbox)
+	assert.Empty(t, response.Matches)
+	assert.Contains(t, w.Body.String(), `"matches":[]`)
+	mockRepo.AssertNotCalled(t, "GetPersonCentroids")
+}
+
+func TestHandleSearchFacePythonError(t *testing.T) {
+	handler, mockRepo, images, _ := newFaceSearchHandler(t, nil)
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusInternalServerError)
+	}))
+	defer python.Close()
+	handler.pythonClient = python_client.NewClient(python.URL)
+	handler.pythonClient.SetFileOpener(handler.storage.Open)
+
+	w := searchFace(handler, "?image_url="+url.QueryEscape(images.URL+"/a.png"))
+
+	assert.Equal(t, http.StatusBadGateway, w.Code)
+	mockRepo.AssertNotCalled(t, "GetPersonCentroids")
+}
+
+func TestHandleSearchFaceLimitsConcurrency(t *testing.T) {
+	handler, mockRepo, images, taskIDs := newFaceSearchHandler(t, []float64{1, 0})
+	handler.searches = make(chan struct{}, 1)
+	handler.searches <- struct{}{} // Единственное место занято другим поиском
+
+	w := searchFace(handler, "?image_url="+url.QueryEscape(images.URL+"/a.png"))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Empty(t, *taskIDs, "изображение не скачивается и в Python не отправляется")
+
+	// Освободившееся место снова доступно, и поиск его возвращает
+	<-handler.searches
+	mockRepo.On("GetPersonCentroids").Return(map[int][]float64{}, nil)
+	w = searchFace(handler, "?image_url="+url.QueryEscape(images.URL+"/a.png"))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, handler.searches)
+
+	// Места - по числу воркеров очереди
+	processing := queue.New(3, 10)
+	assert.Equal(t, 3, cap(NewHandler(nil, nil, nil, nil, nil, nil, nil, nil, processing, nil).searches))
+}
+
+func TestHandleSearchFaceStopsWhenClientLeaves(t *testing.T) {
+	handler, mockRepo, images, _ := newFaceSearchHandler(t, nil)
+	// Python отвечает дольше, чем клиент ждет
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer python.Close()
+	handler.pythonClient = python_client.NewClient(python.URL)
+	handler.pythonClient.SetFileOpener(handler.storage.Open)
+
+	router := setupTestRouter()
+	router.GET("/api/search/face", handler.HandleSearchFace)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/search/face?image_url="+url.QueryEscape(images.URL+"/a.png"), nil)
+
+	started := time.Now()
+	router.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+	assert.Less(t, time.Since(started), 2*time.Second, "запрос к Python не прерван вместе с запросом клиента")
+	mockRepo.AssertNotCalled(t, "GetPersonCentroids")
+}
+
+// ============ COVER ============
+
+// Точки insightface: левый глаз, правый глаз, нос, углы рта
+var (
+	frontalLandmarks = [][]float64{{30, 40}, {70, 40}, {50, 60}, {35, 80}, {65, 80}}
+	profileLandmarks = [][]float64{{30, 40}, {50, 40}, {48, 60}, {33, 80}, {47, 80}}
+)
+
+func TestFrontality(t *testing.T) {
+	assert.Equal(t, 1.0, frontality(frontalLandmarks))
+	assert.InDelta(t, 0.2, frontality(profileLandmarks), 1e-9)
+
+	// Нос за пределами глаз - полный профиль
+	assert.Equal(t, 0.0, frontality([][]float64{{30, 40}, {50, 40}, {60, 60}}))
+	assert.Equal(t, 0.0, frontality([][]float64{{40, 40}, {40, 40}, {45, 60}}))
+
+	// Без точек фронтальность не влияет на выбор
+	assert.Equal(t, 1.0, frontality(nil))
+	assert.Equal(t, 1.0, frontality([][]float64{{30, 40}, {70}, {50, 60}}))
+}
+
+func TestCoverScore(t *testing.T) {
+	// Уверенная, но повернутая детекция проигрывает чуть менее уверенной анфас
+	profile := coverScore(models.FaceMetadata{Confidence: 0.99, Landmarks: profileLandmarks}, nil)
+	frontal := coverScore(models.FaceMetadata{Confidence: 0.9, Landmarks: frontalLandmarks}, nil)
+	assert.Greater(t, frontal, profile)
+
+	// Без точек - только уверенность
+	assert.Equal(t, 0.7, coverScore(models.FaceMetadata{Confidence: 0.7}, nil))
+}
+
+func TestProcessImagesPicksCoverForNewPerson(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success: true,
+			Clusters: map[string][]string{
+				"person_1": {"face_1", "face_2", "face_3"},
+				"person_2": {"face_4"},
+			},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+				"face_3": {0.5, 0.6},
+				"face_4": {0.7, 0.8},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "task-1/a.jpg", Confidence: 0.99, Landmarks: profileLandmarks},
+				"face_2": {OriginalImage: "task-1/a.jpg", Confidence: 0.9, Landmarks: frontalLandmarks},
+				"face_3": {OriginalImage: "task-1/a.jpg", Confidence: 0.5, Landmarks: frontalLandmarks},
+				"face_4": {OriginalImage: "task-1/a.jpg", Confidence: 0.95},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	assert.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	// person_1 новый, person_2 уже существовал (его обложку не трогаем)
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(1, true, nil)
+	mockRepo.On("GetOrCreatePerson", "person_2").Return(2, false, nil)
+	faceIDs := map[string]int{}
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		face := args.Get(0).(*models.Face)
+		face.ID = 10 + len(faceIDs)
+		faceIDs[fmt.Sprintf("%d/%.2f", face.PersonID, face.Confidence)] = face.ID
+	}).Return(nil)
+	mockRepo.On("UpdatePersonCover", 1, mock.Anything).Return(&models.Person{ID: 1, Name: "person_1"}, nil)
+	mockRepo.On("UpdatePersonCentroid", 1).Return(nil)
+	mockRepo.On("UpdatePersonCentroid", 2).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 4, 2, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).Return(nil)
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	// Обложка - самое уверенное из фронтальных лиц, а не самое уверенное вообще
+	mockRepo.AssertCalled(t, "UpdatePersonCover", 1, faceIDs["1/0.90"])
+	mockRepo.AssertNumberOfCalls(t, "UpdatePersonCover", 1)
+	mockRepo.AssertExpectations(t)
+}
+
+func putCover(handler *Handler, body string) *httptest.ResponseRecorder {
+	router := setupTestRouter()
+	router.PUT("/api/persons/:id/cover", handler.HandleUpdatePersonCover)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/persons/1/cover", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandleUpdatePersonCover(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	cacheService := cache.NewService(cache.NewLRUBackend(10))
+	assert.NoError(t, cacheService.SetPerson(context.Background(), &models.PersonWithFaces{Person: models.Person{ID: 1}}))
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cache: cacheService, wsManager: manager}
+
+	mockRepo.On("GetFaceByID", 7).Return(&models.Face{ID: 7, PersonID: 1, AnnotatedImage: "task-1/face_7_boxed.jpg"}, nil)
+	mockRepo.On("UpdatePersonCover", 1, 7).Return(&models.Person{ID: 1, Name: "Alice"}, nil)
+
+	w := putCover(handler, `{"face_id": 7}`)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Cover models.CoverFace `json:"cover"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.CoverFace{
+		FaceID:         7,
+		AnnotatedImage: "task-1/face_7_boxed.jpg",
+		URL:            "/uploads/task-1/face_7_boxed.jpg",
+	}, response.Cover)
+
+	// Карточка в кэше устарела
+	cached, err := cacheService.GetPerson(context.Background(), 1)
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+
+	message := waitForMessage(t, client, websocket.MessageTypePersonUpdated)
+	assert.Equal(t, map[string]interface{}{"id": 1, "name": "Alice"}, message.Payload)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUpdatePersonCoverErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetFaceByID", 7).Return(&models.Face{ID: 7, PersonID: 2}, nil)
+	mockRepo.On("GetFaceByID", 8).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetFaceByID", 9).Return(&models.Face{ID: 9, PersonID: 1}, nil)
+	// Лицо принадлежит человеку, но сам человек удален
+	mockRepo.On("UpdatePersonCover", 1, 9).Return(nil, sql.ErrNoRows)
+
+	assert.Equal(t, http.StatusBadRequest, putCover(handler, `{}`).Code)
+	assert.Equal(t, http.StatusBadRequest, putCover(handler, `{"face_id": 7}`).Code)
+	assert.Equal(t, http.StatusNotFound, putCover(handler, `{"face_id": 8}`).Code)
+	assert.Equal(t, http.StatusNotFound, putCover(handler, `{"face_id": 9}`).Code)
+
+	mockRepo.AssertNotCalled(t, "UpdatePersonCover", 1, 7)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleGetPersonsIncludesCoverURL(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t)}
+
+	mockRepo.On("GetAllPersons").Return([]models.PersonWithFaces{
+		{Person: models.Person{ID: 1, Name: "Alice"}, Count: 2,
+			Cover: &models.CoverFace{FaceID: 5, AnnotatedImage: "task-1/face_5_boxed.jpg"}},
+		{Person: models.Person{ID: 2, Name: "Bob"}},
+	}, nil)
+	mockRepo.On("GetPersonByID", 1).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 1, Name: "Alice"},
+		Faces:  []models.Face{{ID: 5, PersonID: 1}},
+		Cover:  &models.CoverFace{FaceID: 5, AnnotatedImage: "task-1/face_5_boxed.jpg"},
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/persons", handler.HandleGetPersons)
+	router.GET("/persons/:id", handler.HandleGetPerson)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/persons", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var persons []models.PersonWithFaces
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &persons))
+	require.Len(t, persons, 2)
+	require.NotNil(t, persons[0].Cover)
+	assert.Equal(t, "/uploads/task-1/face_5_boxed.jpg", persons[0].Cover.URL)
+	assert.Nil(t, persons[1].Cover) // Без лиц - без обложки
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/persons/1", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var person models.PersonWithFaces
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	require.NotNil(t, person.Cover)
+	assert.Equal(t, 5, person.Cover.FaceID)
+	assert.Equal(t, "/uploads/task-1/face_5_boxed.jpg", person.Cover.URL)
+	mockRepo.AssertExpectations(t)
+}
+
+// ============ FACE CROP ============
+
+// newCropTestHandler сохраняет PNG 200x100 (светлый фон, темный квадрат лица
+// 80..120 x 30..70) и возвращает handler с роутами вырезки и спрайта
+func newCropTestHandler(t *testing.T) (*MockRepository, *storage.Service, *gin.Engine) {
+	src := image.NewGray(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			src.Pix[y*src.Stride+x] = 240
+			if x >= 80 && x < 120 && y >= 30 && y < 70 {
+				src.Pix[y*src.Stride+x] = 10
+			}
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/photo.png", &buf, int64(buf.Len())))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: store, cache: cache.NewService(cache.NewLRUBackend(10))}
+
+	router := setupTestRouter()
+	router.GET("/faces/:id/crop", handler.HandleFaceCrop)
+	router.GET("/persons/:id/sprite", handler.HandleGetPersonSprite)
+	return mockRepo, store, router
+}
+
+// cropFace - лицо из newCropTestHandler
+func cropFace() *models.Face {
+	return &models.Face{ID: 3, PersonID: 1, OriginalImage: "task-1/photo.png", FaceX: 80, FaceY: 30, FaceWidth: 40, FaceHeight: 40}
+}
+
+func TestHandleFaceCrop(t *testing.T) {
+	mockRepo, _, router := newCropTestHandler(t)
+	mockRepo.On("GetFaceByID", 3).Return(cropFace(), nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/3/crop", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+
+	img, format, err := image.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 40, img.Bounds().Dx())
+	assert.Equal(t, 40, img.Bounds().Dy())
+
+	// Вырезка целиком из темного квадрата
+	r, _, _, _ := img.At(img.Bounds().Min.X+20, img.Bounds().Min.Y+20).RGBA()
+	assert.Less(t, r>>8, uint32(40))
+}
+
+func TestHandleFaceCropPadding(t *testing.T) {
+	mockRepo, _, router := newCropTestHandler(t)
+	mockRepo.On("GetFaceByID", 3).Return(cropFace(), nil)
+
+	// padding=1 - по 40px с каждой стороны; по вертикали упирается в края 0 и 100
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/3/crop?padding=1", nil)
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	img, _, err := image.Decode(w.Body)
+	require.NoError(t, err)
+	assert.Equal(t, 120, img.Bounds().Dx())
+	assert.Equal(t, 100, img.Bounds().Dy())
+
+	// Угол вырезки - светлый фон вокруг лица
+	r, _, _, _ := img.At(img.Bounds().Min.X+5, img.Bounds().Min.Y+5).RGBA()
+	assert.Greater(t, r>>8, uint32(200))
+}
+
+func TestHandleFaceCropCached(t *testing.T) {
+	mockRepo, store, router := newCropTestHandler(t)
+	mockRepo.On("GetFaceByID", 3).Return(cropFace(), nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/3/crop?padding=0.25", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	first := w.Body.Bytes()
+
+	// Исходник удален - ответ с тем же отступом берется из кэша
+	require.NoError(t, store.DeleteFiles([]string{"task-1/photo.png"}))
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/faces/3/crop?padding=0.25", nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, first, w.Body.Bytes())
+
+	// Другой отступ в кэше не найден, а исходника уже нет
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/faces/3/crop?padding=0.5", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleFaceCropMissingOriginal(t *testing.T) {
+	mockRepo, _, router := newCropTestHandler(t)
+	face := cropFace()
+	face.OriginalImage = "task-1/missing.jpg"
+	mockRepo.On("GetFaceByID", 3).Return(face, nil)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/3/crop", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "Исходное изображение не найдено")
+}
+
+func TestHandleFaceCropNotFound(t *testing.T) {
+	mockRepo, _, router := newCropTestHandler(t)
+	mockRepo.On("GetFaceByID", 99).Return(nil, sql.ErrNoRows)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/99/crop", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleFaceCropInvalidParams(t *testing.T) {
+	cases := []string{
+		"/faces/abc/crop",
+		"/faces/3/crop?padding=-0.1",
+		"/faces/3/crop?padding=1.5",
+		"/faces/3/crop?padding=wide",
+		"/faces/3/crop?padding=NaN",
+	}
+
+	for _, url := range cases {
+		t.Run(url, func(t *testing.T) {
+			mockRepo, _, router := newCropTestHandler(t)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", url, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			mockRepo.AssertNotCalled(t, "GetFaceByID", mock.Anything)
+		})
+	}
+}
+
+// ============ PROCESSING QUEUE ============
+
+func TestQueueBoundsConcurrentPythonCalls(t *testing.T) {
+	var inFlight, maxInFlight atomic.Int32
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		queue:        queue.New(2, 10),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	const tasks = 5
+	var done sync.WaitGroup
+	done.Add(tasks)
+	mockRepo.On("MarkTaskStarted", mock.Anything).Return(nil)
+	mockRepo.On("GetOriginalFilenames", mock.Anything).Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", mock.Anything, 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", mock.Anything, models.TaskStatusCompleted, (*string)(nil)).
+		Run(func(mock.Arguments) { done.Done() }).
+		Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	for i := 0; i < tasks; i++ {
+		taskID := fmt.Sprintf("task-%d", i)
+		key := taskID + "/a.jpg"
+		require.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+		require.NoError(t, handler.enqueue(taskID, func() {
+			handler.processImages(taskID, []string{key}, models.DefaultDetectionParams(), "")
+		}))
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		done.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("задачи не обработаны")
+	}
+
+	assert.Equal(t, int32(2), maxInFlight.Load())
+}
+
+// blockQueue занимает единственного воркера и весь буфер очереди
+// Возвращаемая функция освобождает очередь
+func blockQueue(t *testing.T, q *queue.Queue) func() {
+	t.Helper()
+	release := make(chan struct{})
+	require.NoError(t, q.Submit(func() { <-release }))
+	require.Eventually(t, func() bool { return q.Active() == 1 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, q.Submit(func() {}))
+	return func() { close(release) }
+}
+
+func TestHandleUploadQueueFull(t *testing.T) {
+	processing := queue.New(1, 1)
+	release := blockQueue(t, processing)
+	defer release()
+
+	// Отклоненная задача - такой же провал, как ошибка обработки: webhook обязателен
+	payloads := make(chan models.TaskWebhookPayload, 1)
+	callback := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload models.TaskWebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer callback.Close()
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:      mockRepo,
+		storage:   newTestStorage(t),
+		wsManager: websocket.NewManager(),
+		notifier:  webhook.NewNotifier(config.WebhooksConfig{AllowedHosts: []string{"127.0.0.1"}}),
+		queue:     processing,
+	}
+	go handler.wsManager.Run()
+
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateTask", mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", mock.Anything, mock.MatchedBy(func(files []models.TaskFile) bool {
+		return len(files) == 1 && files[0].Error == queue.ErrQueueFull.Error()
+	})).Return(nil)
+	mockRepo.On("UpdateTaskStatus", mock.Anything, models.TaskStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == queue.ErrQueueFull.Error()
+	})).Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"image A"}, map[string]string{
+		"callback_url": callback.URL + "/done",
+	}))
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), queue.ErrQueueFull.Error())
+	mockRepo.AssertExpectations(t)
+
+	select {
+	case payload := <-payloads:
+		assert.Equal(t, models.TaskStatusFailed, payload.Status)
+		assert.Equal(t, queue.ErrQueueFull.Error(), payload.Error)
+		assert.Equal(t, 1, payload.TotalImages)
+	case <-time.After(time.Second):
+		t.Fatal("webhook не отправлен")
+	}
+}
+
+func TestProcessImagesTimeout(t *testing.T) {
+	// Python отвечает дольше PROCESSING_TIMEOUT
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager := websocket.NewManager()
+	go manager.Run()
+	client := &websocket.Client{ID: "watcher", Send: make(chan websocket.Message, 64), TaskID: "task-1"}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+		cfg:          &config.Config{Queue: config.QueueConfig{Timeout: 50 * time.Millisecond}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	errorMsg := "Обработка не завершилась за 50ms"
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("SaveTaskFiles", "task-1", []models.TaskFile{{FileName: "a.jpg", Error: errorMsg}}).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, &errorMsg).Return(nil)
+
+	started := time.Now()
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+	assert.Less(t, time.Since(started), 2*time.Second, "запрос к Python не прерван по таймауту")
+
+	// Подписчики задачи узнают о таймауте
+	for {
+		payload := waitForMessage(t, client, websocket.MessageTypeTaskUpdate).Payload.(map[string]interface{})
+		if payload["status"] == models.TaskStatusFailed {
+			assert.Equal(t, errorMsg, payload["data"].(map[string]interface{})["error"])
+			break
+		}
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProcessImagesTimeoutWhileSaving(t *testing.T) {
+	// Python отвечает сразу, а время выходит на сохранении первого лица
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Clusters: map[string][]string{"person_1": {"face_1", "face_2", "face_3"}},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+				"face_3": {0.5, 0.6},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{0, 0, 2, 2}},
+				"face_2": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{4, 4, 6, 6}},
+				"face_3": {OriginalImage: "/uploads/task-1/a.jpg", Bbox: []int{8, 8, 10, 10}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	manager := websocket.NewManager()
+	go manager.Run()
+	client := &websocket.Client{ID: "watcher", Send: make(chan websocket.Message, 64)}
+	manager.RegisterClient(client)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    manager,
+		cfg:          &config.Config{Queue: config.QueueConfig{Timeout: 200 * time.Millisecond}},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	errorMsg := "Обработка не завершилась за 200ms"
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "person_1").Return(7, true, nil)
+	mockRepo.On("LinkTaskPerson", "task-1", 7, true).Return(nil)
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*models.Face).ID = 70
+		time.Sleep(300 * time.Millisecond) // БД отвечает дольше PROCESSING_TIMEOUT
+	}).Return(nil).Once()
+	mockRepo.On("DeleteTaskFaces", "task-1").Return(
+		[]models.Person{{ID: 7, Name: "person_1"}},
+		[]models.Face{{ID: 70, PersonID: 7, TaskID: "task-1", OriginalImage: "task-1/a.jpg"}}, nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil).Maybe()
+	mockRepo.On("SaveTaskFiles", "task-1", []models.TaskFile{{FileName: "a.jpg", Error: errorMsg}}).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, &errorMsg).Return(nil)
+
+	handler.processImages("task-1", []string{"task-1/a.jpg"}, models.DefaultDetectionParams(), "")
+
+	// Остальные лица кластера не сохраняются, центроид и обложка не обновляются
+	mockRepo.AssertNumberOfCalls(t, "CreateFace", 1)
+	mockRepo.AssertNotCalled(t, "UpdatePersonCentroid", mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdatePersonCover", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateTaskStats", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockRepo.AssertExpectations(t)
+
+	// Человек, созданный задачей, удален вместе с ее лицами
+	message := waitForMessage(t, client, websocket.MessageTypePersonDeleted)
+	assert.Equal(t, map[string]interface{}{"id": 7, "name": "person_1"}, message.Payload)
+}
+
+func TestHandleUploadAccepted(t *testing.T) {
+	// Воркер занят, поэтому задача остается в очереди и обработка в тесте не запускается
+	processing := queue.New(1, 2)
+	blockQueue(t, processing)
+	depth := processing.Depth()
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), queue: processing}
+
+	var created *models.Task
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateTask", mock.Anything).
+		Run(func(args mock.Arguments) { created = args.Get(0).(*models.Task) }).
+		Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"image A"}, nil))
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.NotNil(t, created)
+	assert.Equal(t, "/api/task/"+created.ID, w.Header().Get("Location"))
+
+	var response models.UploadResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, created.ID, response.TaskID)
+	assert.Equal(t, depth+1, processing.Depth())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleUploadSavesOriginalFilenames(t *testing.T) {
+	processing := queue.New(1, 2)
+	blockQueue(t, processing)
+
+	store := newTestStorage(t)
+	require.NoError(t, store.SetFileNaming(storage.NamingUUID))
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: store, queue: processing}
+
+	var names map[string]string
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateTask", mock.Anything).Return(nil)
+	mockRepo.On("SaveOriginalFilenames", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { names = args.Get(1).(map[string]string) }).
+		Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"image A", "image B"}, nil))
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	require.Len(t, names, 2)
+	originals := make([]string, 0, len(names))
+	for stored, original := range names {
+		assert.Regexp(t, `^[0-9a-f-]{36}\.jpg$`, stored)
+		originals = append(originals, original)
+	}
+	assert.ElementsMatch(t, []string{"photo0.jpg", "photo1.jpg"}, originals)
+}
+
+func TestHandleUploadOriginalNamingSavesNoNames(t *testing.T) {
+	processing := queue.New(1, 2)
+	blockQueue(t, processing)
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), queue: processing}
+	mockRepo.On("GetCompletedTaskByHash", mock.Anything).Return(nil, sql.ErrNoRows)
+	mockRepo.On("CreateTask", mock.Anything).Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/upload", handler.HandleUpload)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newUploadRequestWithFiles(t, []string{"image A"}, nil))
+
+	require.Equal(t, http.StatusAccepted, w.Code)
+	mockRepo.AssertNotCalled(t, "SaveOriginalFilenames", mock.Anything, mock.Anything)
+}
+
+func TestHandleReprocessTaskQueueFull(t *testing.T) {
+	processing := queue.New(1, 1)
+	release := blockQueue(t, processing)
+	defer release()
+
+	store := newTestStorage(t)
+	store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG)))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	handler := &Handler{repo: mockRepo, storage: store, queue: processing}
+
+	router := setupTestRouter()
+	router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+
+	req, _ := http.NewRequest("POST", "/task/task-1/reprocess", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Места нет - результаты задачи не тронуты
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	mockRepo.AssertNotCalled(t, "ResetTaskForReprocess", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "UpdateTaskStatus", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleReprocessTaskReleasesReservedSlot(t *testing.T) {
+	processing := queue.New(1, 1)
+	store := newTestStorage(t)
+	store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG)))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{ID: "task-1", Status: models.TaskStatusCompleted}, nil)
+	mockRepo.On("ResetTaskForReprocess", "task-1", mock.Anything).Return(nil, nil, repository.ErrTaskProcessing)
+	handler := &Handler{repo: mockRepo, storage: store, queue: processing}
+
+	router := setupTestRouter()
+	router.POST("/task/:id/reprocess", handler.HandleReprocessTask)
+
+	req, _ := http.NewRequest("POST", "/task/task-1/reprocess", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	// Сброс не удался - зарезервированное место возвращается в очередь
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.NoError(t, processing.Submit(func() {}))
+}
+
+func TestHandleTaskStatusQueued(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetTask", "task-1").Return(&models.Task{
+		ID:        "task-1",
+		Status:    models.TaskStatusQueued,
+		CreatedAt: time.Now().Add(-time.Minute),
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/task/:id", handler.HandleTaskStatus)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/task/task-1", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var task models.Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, models.TaskStatusQueued, task.Status)
+	// Длительность появляется только после завершения
+	assert.Nil(t, task.Duration)
+}
+
+func TestHandleGetStatsIncludesQueue(t *testing.T) {
+	processing := queue.New(1, 1)
+	release := blockQueue(t, processing)
+	defer release()
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, queue: processing}
+	mockRepo.On("GetStats").Return(&models.Stats{TotalTasks: 3}, nil)
+
+	router := setupTestRouter()
+	router.GET("/stats", handler.HandleGetStats)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/stats", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var stats models.Stats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, &models.QueueStats{Depth: 1, Active: 1, Workers: 1}, stats.Queue)
+}
+
+func TestHandleGetStatsBackgroundRefresh(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	handler.stats = newStatsRefresher(handler.loadStats)
+
+	// Первый подсчет идет долго - все пришедшие за это время ждут его, а не считают сами
+	release := make(chan time.Time)
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 10}, nil).WaitUntil(release).Once()
+
+	router := setupTestRouter()
+	router.GET("/stats", handler.HandleGetStats)
+
+	get := func() models.Stats {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/stats", nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var stats models.Stats
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+		return stats
+	}
+
+	var wg sync.WaitGroup
+	results := make(chan int, 20)
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- get().TotalPersons
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	for persons := range results {
+		assert.Equal(t, 10, persons)
+	}
+	mockRepo.AssertNumberOfCalls(t, "GetStats", 1)
+
+	// Неудачный пересчет оставляет предыдущую статистику
+	mockRepo.On("GetStats").Return(nil, errors.New("db down")).Once()
+	call := handler.stats.refresh()
+	require.NoError(t, call.err)
+	assert.Equal(t, 10, get().TotalPersons)
+
+	// Изменение данных пересчитывает статистику в фоне; запросы до этого получают прежнюю
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 11}, nil).Once()
+	handler.statsChanged()
+	assert.Eventually(t, func() bool { return get().TotalPersons == 11 }, time.Second, 5*time.Millisecond)
+	mockRepo.AssertNumberOfCalls(t, "GetStats", 3)
+}
+
+func TestStatsRefresherCurrentWaitsForChange(t *testing.T) {
+	var loads atomic.Int32
+	refresher := newStatsRefresher(func() (*models.Stats, error) {
+		n := int(loads.Add(1))
+		return &models.Stats{TotalPersons: n}, nil
+	})
+
+	// Без изменений данных current отдает посчитанную статистику
+	stats, err := refresher.current()
+	require.NoError(t, err)
+	assert.Equal(t, 1, stats.TotalPersons)
+	stats, _ = refresher.current()
+	assert.Equal(t, 1, stats.TotalPersons)
+
+	// После изменения - только статистику, посчитанную позже него
+	refresher.invalidate()
+	stats, _ = refresher.current()
+	assert.Equal(t, 2, stats.TotalPersons)
+
+	// Копия: состояние очереди в ответе не попадает в общую статистику
+	stats.Queue = &models.QueueStats{Depth: 5}
+	latest, _ := refresher.latest()
+	assert.Nil(t, latest.Queue)
+
+	// Без удачного подсчета ошибка возвращается
+	failing := newStatsRefresher(func() (*models.Stats, error) { return nil, errors.New("db down") })
+	_, err = failing.latest()
+	assert.Error(t, err)
+	_, err = failing.refreshSince(failing.invalidate())
+	assert.Error(t, err)
+}
+
+// ============ RECOVERY AFTER RESTART ============
+
+func TestRequeueStuckTasks(t *testing.T) {
+	var processed atomic.Int32
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		processed.Add(1)
+		json.NewEncoder(w).Encode(models.PythonResponse{Success: true})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+	require.NoError(t, store.Backend().Save("task-1/a_face0_boxed.jpg", bytes.NewBufferString("box"), 3))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		queue:        queue.New(1, 10),
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	// Сервер остановился посреди обработки task-1 (одно лицо уже сохранено),
+	// а task-2 ждала в очереди, но ее файлы уже удалены
+	mockRepo.On("GetInterruptedTasks").Return([]models.Task{
+		{ID: "task-1", Status: models.TaskStatusProcessing, TotalImages: 1, MinSize: 40, DetThresh: 0.6},
+		{ID: "task-2", Status: models.TaskStatusQueued, TotalImages: 2},
+	}, nil)
+	mockRepo.On("RequeueInterruptedTask", "task-1").Return(
+		[]models.Person{{ID: 7, Name: "person_7"}},
+		[]models.Face{{ID: 70, PersonID: 7, TaskID: "task-1", AnnotatedImage: "task-1/a_face0_boxed.jpg"}},
+		nil,
+	)
+	mockRepo.On("UpdateTaskStatus", "task-2", models.TaskStatusFailed, mock.MatchedBy(func(msg *string) bool {
+		return msg != nil && *msg == errTaskInterrupted
+	})).Return(nil)
+	mockRepo.On("SaveTaskFiles", mock.Anything, mock.Anything).Return(nil)
+
+	done := make(chan struct{})
+	mockRepo.On("MarkTaskStarted", "task-1").Return(nil)
+	mockRepo.On("GetOriginalFilenames", "task-1").Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", "task-1", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", "task-1", 0, 0, 0, 0, 0).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusCompleted, (*string)(nil)).
+		Run(func(mock.Arguments) { close(done) }).
+		Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+
+	requeued, failed, err := handler.RequeueStuckTasks()
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assert.Equal(t, 1, failed)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("восстановленная задача не обработана")
+	}
+
+	assert.Equal(t, int32(1), processed.Load())
+	// Производные файлы удаленных лиц убраны, оригинал остался для обработки
+	exists, _ := store.Backend().Exists("task-1/a_face0_boxed.jpg")
+	assert.False(t, exists)
+	exists, _ = store.Backend().Exists("task-1/a.jpg")
+	assert.True(t, exists)
+	mockRepo.AssertNotCalled(t, "RequeueInterruptedTask", "task-2")
+}
+
+func TestRequeueStuckTasksQueueFull(t *testing.T) {
+	processing := queue.New(1, 1)
+	release := blockQueue(t, processing)
+	defer release()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: store, wsManager: websocket.NewManager(), queue: processing}
+	go handler.wsManager.Run()
+
+	mockRepo.On("GetInterruptedTasks").Return([]models.Task{{ID: "task-1", Status: models.TaskStatusQueued}}, nil)
+	mockRepo.On("SaveTaskFiles", "task-1", mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStatus", "task-1", models.TaskStatusFailed, mock.Anything).Return(nil)
+
+	requeued, failed, err := handler.RequeueStuckTasks()
+	require.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assert.Equal(t, 1, failed)
+	// Без места в очереди сохраненные лица не трогаем
+	mockRepo.AssertNotCalled(t, "RequeueInterruptedTask", mock.Anything)
+}
+
+// ============ ORPHANED FILES ============
+
+func TestCleanupOrphanedFiles(t *testing.T) {
+	store := newTestStorage(t)
+	root := store.Backend().(*storage.LocalBackend).Root()
+	old := time.Now().Add(-48 * time.Hour)
+
+	// save записывает файл; stale - файл старше ORPHAN_GRACE_PERIOD
+	save := func(key string, stale bool) {
+		require.NoError(t, store.Backend().Save(key, bytes.NewReader(testJPEG), int64(len(testJPEG))))
+		if stale {
+			path := filepath.Join(root, filepath.FromSlash(key))
+			require.NoError(t, os.Chtimes(path, old, old))
+		}
+	}
+
+	save("task-1/a.jpg", true)                   // Оригинал существующей задачи без лиц
+	save("task-1/1_boxed.jpg", true)             // Аннотированное фото лица
+	save("thumbnails/task-1/1_boxed.jpg", true)  // Миниатюра лица
+	save("task-1/2_boxed.jpg", true)             // Лицо удалено, файл остался
+	save("thumbnails/task-1/2_boxed.jpg", true)  // Миниатюра удаленного лица
+	save("deleted-task/b.jpg", true)             // Задача удалена, файл остался
+	save("deleted-task/c.jpg", false)            // Свежий файл - запись может еще создаваться
+	save("thumbnails/task-1/3_boxed.jpg", false) // Свежая миниатюра без лица
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceFileKeys").Return([]string{
+		"task-1/1_boxed.jpg", "thumbnails/task-1/1_boxed.jpg",
+	}, nil)
+	mockRepo.On("GetTaskIDs").Return([]string{"task-1"}, nil)
+
+	handler := &Handler{repo: mockRepo, storage: store, cfg: &config.Config{
+		Storage: config.StorageConfig{OrphanGracePeriod: time.Hour},
+	}}
+
+	removed, err := handler.CleanupOrphanedFiles()
+	require.NoError(t, err)
+	assert.Equal(t, 3, removed)
+
+	for _, key := range []string{"task-1/a.jpg", "task-1/1_boxed.jpg", "thumbnails/task-1/1_boxed.jpg", "deleted-task/c.jpg", "thumbnails/task-1/3_boxed.jpg"} {
+		assert.True(t, store.FileExists(key), key)
+	}
+	for _, key := range []string{"task-1/2_boxed.jpg", "thumbnails/task-1/2_boxed.jpg", "deleted-task/b.jpg"} {
+		assert.False(t, store.FileExists(key), key)
+	}
+
+	// Повторный запуск удалять уже нечего
+	removed, err = handler.CleanupOrphanedFiles()
+	require.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestCleanupOrphanedFilesKeepsFilesOnDBError(t *testing.T) {
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetFaceFileKeys").Return(nil, errors.New("db down"))
+
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	_, err := handler.CleanupOrphanedFiles()
+	require.Error(t, err)
+	assert.True(t, store.FileExists("task-1/a.jpg"))
+}
+
+// ============ CACHE ADMIN ============
+
+// MockCache - мок кэша; проверяет, какие инвалидации вызваны
+type MockCache struct {
+	mock.Mock
+}
+
+func (m *MockCache) GetPerson(ctx context.Context, id int) (*models.PersonWithFaces, error) {
+	return nil, nil
+}
+
+func (m *MockCache) SetPerson(ctx context.Context, person *models.PersonWithFaces) error { return nil }
+
+func (m *MockCache) GetStalePerson(ctx context.Context, id int) (*models.PersonWithFaces, error) {
+	return nil, nil
+}
+
+func (m *MockCache) InvalidatePerson(ctx context.Context, id int) error {
+	return m.Called(id).Error(0)
+}
+
+func (m *MockCache) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
+	return nil, nil
+}
+
+func (m *MockCache) GetStaleTask(ctx context.Context, taskID string) (*models.Task, error) {
+	return nil, nil
+}
+
+func (m *MockCache) SetTask(ctx context.Context, task *models.Task) error { return nil }
+
+func (m *MockCache) InvalidateTask(ctx context.Context, taskID string) error { return nil }
+
+func (m *MockCache) GetTaskIDByHash(ctx context.Context, contentHash string) (string, error) {
+	return "", nil
+}
+
+func (m *MockCache) SetTaskIDByHash(ctx context.Context, contentHash, taskID string) error {
+	return nil
+}
+
+func (m *MockCache) InvalidateTaskIDByHash(ctx context.Context, contentHash string) error { return nil }
+
+func (m *MockCache) GetRecentTasks(ctx context.Context) ([]models.Task, error) { return nil, nil }
+
+func (m *MockCache) SetRecentTasks(ctx context.Context, tasks []models.Task) error { return nil }
+
+func (m *MockCache) InvalidateRecentTasks(ctx context.Context) error { return nil }
+
+func (m *MockCache) GetFaceCrop(ctx context.Context, faceID int, padding float64) ([]byte, error) {
+	return nil, nil
+}
+
+func (m *MockCache) SetFaceCrop(ctx context.Context, faceID int, padding float64, data []byte) error {
+	return nil
+}
+
+func (m *MockCache) GetPersonSprite(ctx context.Context, personID, cols, size int, faces string) (*models.PersonSpriteResponse, error) {
+	return nil, nil
+}
+
+func (m *MockCache) SetPersonSprite(ctx context.Context, cols, size int, faces string, sprite *models.PersonSpriteResponse) error {
+	return nil
+}
+
+func (m *MockCache) GetStats(ctx context.Context) (*models.Stats, error) { return nil, nil }
+
+func (m *MockCache) GetStaleStats(ctx context.Context) (*models.Stats, error) { return nil, nil }
+
+func (m *MockCache) SetStats(ctx context.Context, stats *models.Stats) error { return nil }
+
+func (m *MockCache) InvalidateStats(ctx context.Context) error {
+	return m.Called().Error(0)
+}
+
+func (m *MockCache) FlushAll(ctx context.Context) error {
+	return m.Called().Error(0)
+}
+
+func newCacheAdminRouter(handler *Handler) *gin.Engine {
+	router := setupTestRouter()
+	router.DELETE("/cache/person/:id", handler.HandleClearPersonCache)
+	router.DELETE("/cache/stats", handler.HandleClearStatsCache)
+	router.DELETE("/cache/all", handler.HandleClearAllCache)
+	return router
+}
+
+func TestHandleClearCache(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		setup   func(m *MockCache)
+		cleared string
+	}{
+		{"person", "/cache/person/5", func(m *MockCache) { m.On("InvalidatePerson", 5).Return(nil) }, "person:5"},
+		{"stats", "/cache/stats", func(m *MockCache) { m.On("InvalidateStats").Return(nil) }, "stats"},
+		{"all", "/cache/all?confirm=true", func(m *MockCache) { m.On("FlushAll").Return(nil) }, "all"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCache := new(MockCache)
+			tt.setup(mockCache)
+			router := newCacheAdminRouter(&Handler{cache: mockCache})
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("DELETE", tt.url, nil)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			var response models.CacheClearResponse
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+			assert.Equal(t, []string{tt.cleared}, response.Cleared)
+			mockCache.AssertExpectations(t)
+		})
+	}
+}
+
+func TestHandleClearAllCacheRequiresConfirm(t *testing.T) {
+	mockCache := new(MockCache)
+	router := newCacheAdminRouter(&Handler{cache: mockCache})
+
+	for _, url := range []string{"/cache/all", "/cache/all?confirm=1"} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", url, nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, url)
+	}
+	mockCache.AssertNotCalled(t, "FlushAll")
+}
+
+func TestHandleClearCacheErrors(t *testing.T) {
+	mockCache := new(MockCache)
+	mockCache.On("InvalidateStats").Return(errors.New("redis down"))
+	router := newCacheAdminRouter(&Handler{cache: mockCache})
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("DELETE", "/cache/stats", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/cache/person/abc", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Без кэша очищать нечего
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("DELETE", "/cache/stats", nil)
+	newCacheAdminRouter(&Handler{}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+func newCacheRebuildRouter(handler *Handler) *gin.Engine {
+	router := setupTestRouter()
+	router.POST("/admin/cache/rebuild", handler.HandleRebuildCache)
+	router.GET("/admin/cache/rebuild/:id", handler.HandleCacheRebuildStatus)
+	return router
+}
+
+// startCacheRebuild запускает перестройку кэша и возвращает ее
+func startCacheRebuild(t *testing.T, router *gin.Engine, url string) models.CacheRebuildJob {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", url, nil)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var job models.CacheRebuildJob
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	assert.Equal(t, models.CacheRebuildRunning, job.Status)
+	assert.Equal(t, "/api/admin/cache/rebuild/"+job.ID, w.Header().Get("Location"))
+	return job
+}
+
+// waitCacheRebuild дожидается завершения перестройки кэша и возвращает ее итог
+func waitCacheRebuild(t *testing.T, router *gin.Engine, id string) models.CacheRebuildJob {
+	var job models.CacheRebuildJob
+	require.Eventually(t, func() bool {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/admin/cache/rebuild/"+id, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+		return job.Status != models.CacheRebuildRunning
+	}, time.Second, 5*time.Millisecond)
+	return job
+}
+
+func TestHandleRebuildCacheWarmsCache(t *testing.T) {
+	ctx := context.Background()
+	cacheService := cache.NewService(cache.NewLRUBackend(1000))
+	// Устаревшие записи: перестройка их удаляет
+	require.NoError(t, cacheService.SetPerson(ctx, &models.PersonWithFaces{Person: models.Person{ID: 999, Name: "stale"}}))
+	require.NoError(t, cacheService.SetStats(ctx, &models.Stats{TotalPersons: 1}))
+
+	// 150 людей из 200: первая страница целиком, вторая - остаток до лимита
+	persons := make([]models.PersonWithFaces, 200)
+	now := time.Now()
+	for i := range persons {
+		persons[i] = models.PersonWithFaces{Person: models.Person{ID: i + 1, CreatedAt: now.Add(-time.Duration(i) * time.Second)}}
+	}
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetStats").Return(&models.Stats{TotalPersons: 200}, nil)
+	mockRepo.On("GetPersonsAfter", (*models.PersonCursor)(nil), 100).Return(persons[:100], nil).Once()
+	mockRepo.On("GetPersonsAfter", &models.PersonCursor{CreatedAt: persons[99].CreatedAt, ID: 100}, 50).Return(persons[100:150], nil).Once()
+	for id := 1; id <= 150; id++ {
+		mockRepo.On("GetPersonByID", id).Return(&models.PersonWithFaces{Person: models.Person{ID: id, Name: fmt.Sprintf("person_%d", id)}, Count: 1}, nil).Once()
+	}
+	mockRepo.On("GetRecentTasks", 5).Return([]models.Task{{ID: "task-1", Status: models.TaskStatusCompleted}}, nil)
+
+	manager := websocket.NewManager()
+	go manager.Run()
+	client := &websocket.Client{ID: "c1", Send: make(chan websocket.Message, 256)}
+	manager.RegisterClient(client)
+
+	handler := &Handler{repo: mockRepo, cache: cacheService, wsManager: manager, rebuilds: newCacheRebuilds(), cfg: &config.Config{
+		Cache: config.CacheConfig{WarmPersons: 150, WarmTasks: 5},
+	}}
+	router := newCacheRebuildRouter(handler)
+
+	started := startCacheRebuild(t, router, "/admin/cache/rebuild?tasks=true")
+	job := waitCacheRebuild(t, router, started.ID)
+
+	assert.Equal(t, models.CacheRebuildCompleted, job.Status)
+	assert.Equal(t, 150, job.Persons)
+	assert.Equal(t, 150, job.PersonsLimit)
+	assert.Equal(t, 1, job.Tasks)
+	assert.NotNil(t, job.FinishedAt)
+
+	stale, err := cacheService.GetPerson(ctx, 999)
+	require.NoError(t, err)
+	assert.Nil(t, stale)
+
+	warmed, err := cacheService.GetPerson(ctx, 150)
+	require.NoError(t, err)
+	require.NotNil(t, warmed)
+	assert.Equal(t, "person_150", warmed.Name)
+
+	notWarmed, err := cacheService.GetPerson(ctx, 151)
+	require.NoError(t, err)
+	assert.Nil(t, notWarmed)
+
+	stats, err := cacheService.GetStats(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, 200, stats.TotalPersons)
+
+	task, err := cacheService.GetTask(ctx, "task-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+
+	mockRepo.AssertExpectations(t)
+	mockRepo.AssertNumberOfCalls(t, "GetPersonByID", 150)
+
+	// Ход перестройки уходит в WebSocket: последнее сообщение - итог
+	var last models.CacheRebuildJob
+	require.Eventually(t, func() bool {
+		for {
+			select {
+			case msg := <-client.Send:
+				if msg.Type == websocket.MessageTypeCacheRebuild {
+					last = msg.Payload.(models.CacheRebuildJob)
+				}
+			default:
+				return last.Status == models.CacheRebuildCompleted
+			}
+		}
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, models.CacheRebuildStageTasks, last.Stage)
+	assert.Equal(t, 150, last.Persons)
+}
+
+func TestHandleRebuildCacheSkipsTasksByDefault(t *testing.T) {
+	cacheService := cache.NewService(cache.NewLRUBackend(100))
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+	mockRepo.On("GetPersonsAfter", (*models.PersonCursor)(nil), 100).Return([]models.PersonWithFaces{}, nil).Once()
+
+	handler := &Handler{repo: mockRepo, cache: cacheService, rebuilds: newCacheRebuilds(), cfg: &config.Config{
+		Cache: config.CacheConfig{WarmPersons: 1000, WarmTasks: 5},
+	}}
+	router := newCacheRebuildRouter(handler)
+
+	job := waitCacheRebuild(t, router, startCacheRebuild(t, router, "/admin/cache/rebuild").ID)
+	assert.Equal(t, models.CacheRebuildCompleted, job.Status)
+	assert.Equal(t, 0, job.TasksLimit)
+	mockRepo.AssertNotCalled(t, "GetRecentTasks", mock.Anything)
+}
+
+func TestHandleRebuildCacheConflict(t *testing.T) {
+	cacheService := cache.NewService(cache.NewLRUBackend(100))
+	release := make(chan time.Time)
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil).WaitUntil(release).Once()
+	mockRepo.On("GetPersonsAfter", (*models.PersonCursor)(nil), 100).Return([]models.PersonWithFaces{}, nil).Once()
+
+	handler := &Handler{repo: mockRepo, cache: cacheService, rebuilds: newCacheRebuilds(), cfg: &config.Config{
+		Cache: config.CacheConfig{WarmPersons: 1000},
+	}}
+	router := newCacheRebuildRouter(handler)
+
+	job := startCacheRebuild(t, router, "/admin/cache/rebuild")
+
+	// Пока первая перестройка идет, вторая не запускается
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/cache/rebuild", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusConflict, w.Code)
+
+	close(release)
+	assert.Equal(t, models.CacheRebuildCompleted, waitCacheRebuild(t, router, job.ID).Status)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/admin/cache/rebuild/unknown", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestHandleRebuildCacheFailed(t *testing.T) {
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetStats").Return(nil, errors.New("db down"))
+
+	handler := &Handler{repo: mockRepo, cache: cache.NewService(cache.NewLRUBackend(100)), rebuilds: newCacheRebuilds()}
+	router := newCacheRebuildRouter(handler)
+
+	job := waitCacheRebuild(t, router, startCacheRebuild(t, router, "/admin/cache/rebuild").ID)
+	assert.Equal(t, models.CacheRebuildFailed, job.Status)
+	assert.Equal(t, models.CacheRebuildStageStats, job.Stage)
+	assert.Contains(t, job.Error, "db down")
+}
+
+func TestHandleRebuildCacheWithoutCache(t *testing.T) {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/admin/cache/rebuild", nil)
+	newCacheRebuildRouter(&Handler{rebuilds: newCacheRebuilds()}).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
+
+// ============ SINGLE FACE ============
+
+func TestHandleGetFace(t *testing.T) {
+	mockRepo := new(MockRepository)
+	store := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: store}
+
+	face := &models.Face{
+		ID: 42, PersonID: 3, TaskID: "task-1",
+		OriginalImage:  "task-1/a.jpg",
+		AnnotatedImage: "task-1/a_face0_boxed.jpg",
+		FaceX:          10, FaceY: 20, FaceWidth: 30, FaceHeight: 40,
+		Confidence: 0.93,
+		Embedding:  []byte(`[0.1, 0.2]`),
+	}
+	mockRepo.On("GetFaceByID", 42).Return(face, nil)
+
+	router := setupTestRouter()
+	router.GET("/faces/:id", handler.HandleGetFace)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces/42", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var details map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &details))
+	assert.Equal(t, float64(3), details["person_id"])
+	assert.Equal(t, float64(30), details["face_width"])
+	assert.Equal(t, 0.93, details["confidence"])
+	assert.Equal(t, store.URL("task-1/a.jpg"), details["original_url"])
+	assert.Equal(t, store.URL("task-1/a_face0_boxed.jpg"), details["annotated_url"])
+	assert.NotContains(t, details, "thumbnail_url")
+	// Embedding не отдается без явного запроса
+	assert.NotContains(t, details, "embedding")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/faces/42?include_embedding=true", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var withEmbedding models.FaceDetails
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &withEmbedding))
+	assert.Equal(t, []float64{0.1, 0.2}, withEmbedding.Vector)
+}
+
+func TestHandleGetFaceErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("GetFaceByID", 404).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetFaceByID", 500).Return(nil, errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/faces/:id", handler.HandleGetFace)
+
+	for url, status := range map[string]int{
+		"/faces/abc": http.StatusBadRequest,
+		"/faces/404": http.StatusNotFound,
+		"/faces/500": http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, url)
+	}
+}
+
+func TestHandleCountPersonFaces(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	mockRepo.On("GetPersonFaceCount", 3).Return(7, nil)
+	mockRepo.On("GetPersonFaceCount", 4).Return(0, sql.ErrNoRows)
+	mockRepo.On("GetPersonFaceCount", 5).Return(0, errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/persons/:id/faces/count", handler.HandleCountPersonFaces)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/persons/3/faces/count", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"count": 7}`, w.Body.String())
+	// Лица не загружаются
+	mockRepo.AssertNotCalled(t, "GetPersonByID", mock.Anything)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/persons/4/faces/count", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assertErrorCode(t, w, apierror.CodeNotFound)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/persons/5/faces/count", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assertErrorCode(t, w, apierror.CodeInternal)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/persons/abc/faces/count", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assertErrorCode(t, w, apierror.CodeValidation)
+}
+
+func TestHandleGetFaceEmbedding(t *testing.T) {
+	vector := []float64{0.125, -0.5, 0.3333333333333333, 1e-7}
+	stored, err := json.Marshal(vector)
+	require.NoError(t, err)
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("GetFaceByID", 42).Return(&models.Face{ID: 42, PersonID: 3, Embedding: stored, EmbeddingModel: "insightface/buffalo_l"}, nil)
+	mockRepo.On("GetFaceByID", 7).Return(&models.Face{ID: 7, PersonID: 3}, nil)
+	mockRepo.On("GetFaceByID", 8).Return(&models.Face{ID: 8, PersonID: 3, Embedding: []byte("not json")}, nil)
+	mockRepo.On("GetFaceByID", 404).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetFaceByID", 500).Return(nil, errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/faces/:id/embedding", middleware.AdminAuth("secret"), handler.HandleGetFaceEmbedding)
+
+	get := func(url, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/faces/42/embedding", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.FaceEmbeddingResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.FaceEmbeddingResponse{
+		FaceID:    42,
+		PersonID:  3,
+		Model:     "insightface/buffalo_l",
+		Dimension: 4,
+		Embedding: vector,
+	}, response)
+
+	for url, status := range map[string]int{
+		"/faces/abc/embedding": http.StatusBadRequest,
+		"/faces/404/embedding": http.StatusNotFound,
+		"/faces/7/embedding":   http.StatusNotFound,
+		"/faces/8/embedding":   http.StatusInternalServerError,
+		"/faces/500/embedding": http.StatusInternalServerError,
+	} {
+		assert.Equal(t, status, get(url, "secret").Code, url)
+	}
+
+	// Без токена embedding не отдается
+	assert.Equal(t, http.StatusUnauthorized, get("/faces/42/embedding", "").Code)
+	assert.Equal(t, http.StatusUnauthorized, get("/faces/42/embedding", "wrong").Code)
+}
+
+func TestHandlePersonEmbeddings(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("GetPersonByID", 3).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 3, Name: "Alice"},
+		Faces: []models.Face{
+			{ID: 42, PersonID: 3, Embedding: []byte("[0.6,0.8]"), EmbeddingModel: "insightface/buffalo_l"},
+			{ID: 43, PersonID: 3}, // Без embedding
+			{ID: 44, PersonID: 3, Embedding: []byte("not json")},
+			{ID: 45, PersonID: 3, Embedding: []byte("[1,0]")},
+		},
+	}, nil)
+	mockRepo.On("GetPersonByID", 9).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 9, Name: "Bob"},
+		Faces:  []models.Face{},
+	}, nil)
+	mockRepo.On("GetPersonByID", 404).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetPersonByID", 500).Return(nil, errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/persons/:id/embeddings", middleware.AdminAuth("secret"), handler.HandlePersonEmbeddings)
+
+	get := func(url, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", url, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := get("/persons/3/embeddings", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.PersonEmbeddingsResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, models.PersonEmbeddingsResponse{
+		PersonID: 3,
+		Name:     "Alice",
+		Faces: []models.FaceEmbeddingResponse{
+			{FaceID: 42, PersonID: 3, Model: "insightface/buffalo_l", Dimension: 2, Embedding: []float64{0.6, 0.8}},
+			{FaceID: 45, PersonID: 3, Dimension: 2, Embedding: []float64{1, 0}},
+		},
+	}, response)
+
+	// У человека без лиц - пустой список, а не null
+	w = get("/persons/9/embeddings", "secret")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"faces":[]`)
+
+	for url, status := range map[string]int{
+		"/persons/abc/embeddings": http.StatusBadRequest,
+		"/persons/404/embeddings": http.StatusNotFound,
+		"/persons/500/embeddings": http.StatusInternalServerError,
+	} {
+		assert.Equal(t, status, get(url, "secret").Code, url)
+	}
+
+	// Без токена embedding не отдаются
+	assert.Equal(t, http.StatusUnauthorized, get("/persons/3/embeddings", "").Code)
+}
+
+func TestHandleExportEmbeddings(t *testing.T) {
+	// Полная первая страница: выгрузка продолжается со следующей после последнего лица
+	first := make([]models.LabeledEmbedding, exportPageSize)
+	for i := range first {
+		first[i] = models.LabeledEmbedding{FaceID: i + 1, PersonID: 1, PersonName: "Alice", Model: "buffalo_l", Embedding: []byte("[1,0]")}
+	}
+	first[1].Embedding = []byte("broken")
+
+	mockRepo := new(MockRepository)
+	mockRepo.On("GetEmbeddingsPage", 0, exportPageSize).Return(first, nil)
+	mockRepo.On("GetEmbeddingsPage", exportPageSize, exportPageSize).Return([]models.LabeledEmbedding{
+		{FaceID: 900, PersonID: 2, PersonName: "Bob", Embedding: []byte("[0.5,0.5]")},
+	}, nil)
+
+	router := setupTestRouter()
+	router.GET("/export/embeddings", (&Handler{repo: mockRepo}).HandleExportEmbeddings)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export/embeddings?format=ndjson", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson; charset=utf-8", w.Header().Get("Content-Type"))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	require.Len(t, lines, exportPageSize) // Битое лицо пропущено
+	var record models.EmbeddingExportRecord
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, models.EmbeddingExportRecord{FaceID: 1, PersonID: 1, PersonName: "Alice", Model: "buffalo_l", Dimension: 2, Embedding: []float64{1, 0}}, record)
+	require.NoError(t, json.Unmarshal([]byte(lines[len(lines)-1]), &record))
+	assert.Equal(t, 900, record.FaceID)
+	assert.Equal(t, "Bob", record.PersonName)
+	mockRepo.AssertExpectations(t)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export/embeddings?format=csv", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	failing := new(MockRepository)
+	failing.On("GetEmbeddingsPage", 0, exportPageSize).Return(nil, errors.New("db down"))
+	router = setupTestRouter()
+	router.GET("/export/embeddings", (&Handler{repo: failing}).HandleExportEmbeddings)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/export/embeddings", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// ============ PERSON AUDIT ============
+
+func TestHandleGetPersonAudit(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	entries := []models.PersonAuditEntry{
+		{ID: 1, PersonID: 3, Event: models.AuditFacesAdded, Actor: "anonymous", Details: json.RawMessage(`{"face_ids":[42],"task_id":"task-1"}`)},
+		{ID: 2, PersonID: 3, Event: models.AuditRenamed, Actor: "admin", Details: json.RawMessage(`{"old_name":"person_3","new_name":"Alice"}`)},
+	}
+	mockRepo.On("GetPersonAudit", 3).Return(entries, nil)
+	mockRepo.On("GetPersonAudit", 9).Return([]models.PersonAuditEntry(nil), nil)
+	mockRepo.On("GetPersonAudit", 500).Return([]models.PersonAuditEntry(nil), errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/persons/:id/audit", handler.HandleGetPersonAudit)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/persons/3/audit", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.PersonAuditResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 3, response.PersonID)
+	require.Len(t, response.Entries, 2)
+	assert.Equal(t, models.AuditRenamed, response.Entries[1].Event)
+	assert.JSONEq(t, `{"old_name":"person_3","new_name":"Alice"}`, string(response.Entries[1].Details))
+
+	// Журнала нет - пустой список, а не 404 (журнал переживает удаление человека)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/persons/9/audit", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"entries":[]`)
+
+	for url, status := range map[string]int{
+		"/persons/abc/audit": http.StatusBadRequest,
+		"/persons/500/audit": http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, url)
+	}
+}
+
+// ============ FACES BY CONFIDENCE ============
+
+func TestHandleListFaces(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+
+	faces := []models.FaceWithPerson{
+		{Face: models.Face{ID: 7, PersonID: 2, Confidence: 0.41, Embedding: []byte(`[0.1]`)}, PersonName: "Anna"},
+		{Face: models.Face{ID: 3, PersonID: 5, Confidence: 0.55}, PersonName: "Boris"},
+	}
+	mockRepo.On("ListFaces", 0.4, 0.6, 0.0, 2, 4).Return(faces, 9, nil)
+	mockRepo.On("ListFaces", 0.0, 1.0, 0.0, models.DefaultSearchLimit, 0).Return([]models.FaceWithPerson(nil), 0, nil)
+	mockRepo.On("ListFaces", 0.0, 1.0, 0.5, models.DefaultSearchLimit, 0).Return([]models.FaceWithPerson(nil), 0, nil)
+
+	router := setupTestRouter()
+	router.GET("/faces", handler.HandleListFaces)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/faces?min_confidence=0.4&max_confidence=0.6&limit=2&offset=4", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	var page models.FaceListResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+	assert.Equal(t, 9, page.Total)
+	assert.Equal(t, 0.4, page.MinConfidence)
+	assert.Equal(t, 0.6, page.MaxConfidence)
+	assert.Equal(t, 2, page.Limit)
+	assert.Equal(t, 4, page.Offset)
+	require.Len(t, page.Faces, 2)
+	assert.Equal(t, "Anna", page.Faces[0].PersonName)
+	assert.Equal(t, 0.41, page.Faces[0].Confidence)
+	assert.NotContains(t, w.Body.String(), "embedding\"")
+
+	// Без параметров - весь диапазон и пустой массив вместо null
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/faces", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"faces":[]`)
+
+	// Порог резкости передается в репозиторий
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/faces?min_quality=0.5", nil)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"min_quality":0.5`)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleListFacesErrors(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("ListFaces", 0.0, 0.5, 0.0, models.DefaultSearchLimit, 0).
+		Return([]models.FaceWithPerson(nil), 0, errors.New("db down"))
+
+	router := setupTestRouter()
+	router.GET("/faces", handler.HandleListFaces)
+
+	for url, status := range map[string]int{
+		"/faces?min_confidence=abc":                    http.StatusBadRequest,
+		"/faces?min_confidence=-0.1":                   http.StatusBadRequest,
+		"/faces?min_confidence=NaN":                    http.StatusBadRequest,
+		"/faces?max_confidence=NaN":                    http.StatusBadRequest,
+		"/faces?max_confidence=1.5":                    http.StatusBadRequest,
+		"/faces?min_confidence=0.8&max_confidence=0.2": http.StatusBadRequest,
+		"/faces?limit=0":                               http.StatusBadRequest,
+		"/faces?limit=1000":                            http.StatusBadRequest,
+		"/faces?offset=-1":                             http.StatusBadRequest,
+		"/faces?min_quality=2":                         http.StatusBadRequest,
+		"/faces?min_quality=NaN":                       http.StatusBadRequest,
+		"/faces?max_confidence=0.5":                    http.StatusInternalServerError,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, status, w.Code, url)
+	}
+}
+
+func TestHandleReembedPerson(t *testing.T) {
+	var taskIDs []string
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		taskIDs = append(taskIDs, r.FormValue("task_id"))
+		// Новая модель находит лицо со сдвигом на пару пикселей и еще одно лицо
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Model:    "insightface/antelopev2",
+			Clusters: map[string][]string{"0": {"f0", "f1"}},
+			Embeddings: map[string][]float64{
+				"f0": {0.9, 0.1},
+				"f1": {0.5, 0.5},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"f0": {Bbox: []int{12, 11, 52, 51}},
+				"f1": {Bbox: []int{200, 200, 240, 240}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	mockCache := new(MockCache)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		cache:        mockCache,
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	mockRepo.On("GetPersonByID", 3).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 3, Name: "Alice"},
+		Faces: []models.Face{
+			{ID: 1, OriginalImage: "task-1/a.jpg", FaceX: 10, FaceY: 10, FaceWidth: 40, FaceHeight: 40},
+			{ID: 2, OriginalImage: "task-1/a.jpg", FaceX: 400, FaceY: 400, FaceWidth: 40, FaceHeight: 40},
+			{ID: 3, OriginalImage: "task-2/missing.jpg", FaceX: 0, FaceY: 0, FaceWidth: 40, FaceHeight: 40},
+			// Уже посчитано новой моделью - пропускается
+			{ID: 4, OriginalImage: "task-3/b.jpg", EmbeddingModel: "insightface/antelopev2"},
+		},
+	}, nil)
+	mockRepo.On("UpdateFaceEmbedding", 1, []byte("[0.9,0.1]"), "insightface/antelopev2").Return(nil)
+	mockRepo.On("UpdatePersonCentroid", 3).Return(nil).Once()
+	mockCache.On("InvalidatePerson", 3).Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/api/persons/:id/reembed", handler.HandleReembedPerson)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/persons/3/reembed?model=insightface/antelopev2", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.ReembedResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 3, response.PersonID)
+	assert.Equal(t, 1, response.Updated)
+	assert.Equal(t, 1, response.Skipped)
+	assert.Equal(t, []models.ReembedFailure{
+		{FaceID: 2, Error: errReembedFaceNotFound.Error()},
+		{FaceID: 3, Error: errReembedFileMissing.Error()},
+	}, response.Failed)
+
+	// Фото отправлено один раз, во временную задачу, которая затем удалена
+	require.Len(t, taskIDs, 1)
+	assert.True(t, strings.HasPrefix(taskIDs[0], "reembed-"))
+	assert.False(t, store.FileExists(taskIDs[0]+"/a.jpg"))
+
+	mockRepo.AssertExpectations(t)
+	mockCache.AssertExpectations(t)
+}
+
+func TestHandleReembedPersonPythonError(t *testing.T) {
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model not loaded", http.StatusInternalServerError)
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	require.NoError(t, store.Backend().Save("task-1/a.jpg", bytes.NewReader(testJPEG), int64(len(testJPEG))))
+
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: store, pythonClient: python_client.NewClient(python.URL)}
+	handler.pythonClient.SetFileOpener(store.Open)
+
+	mockRepo.On("GetPersonByID", 3).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 3},
+		Faces:  []models.Face{{ID: 1, OriginalImage: "task-1/a.jpg", FaceWidth: 40, FaceHeight: 40}},
+	}, nil)
+
+	router := setupTestRouter()
+	router.POST("/api/persons/:id/reembed", handler.HandleReembedPerson)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/persons/3/reembed", nil))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response models.ReembedResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, 0, response.Updated)
+	require.Len(t, response.Failed, 1)
+	assert.Contains(t, response.Failed[0].Error, "model not loaded")
+	mockRepo.AssertNotCalled(t, "UpdateFaceEmbedding", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandleReembedPersonNotFound(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo}
+	mockRepo.On("GetPersonByID", 9).Return(nil, sql.ErrNoRows)
+
+	router := setupTestRouter()
+	router.POST("/api/persons/:id/reembed", handler.HandleReembedPerson)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/persons/9/reembed", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/persons/abc/reembed", nil))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// ============ IMPORT TESTS ============
+
+// newImportRequest создает multipart запрос с ZIP архивом "путь -> содержимое" в поле archive
+func newImportRequest(t *testing.T, entries [][2]string) *http.Request {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for _, entry := range entries {
+		w, err := zw.Create(entry[0])
+		require.NoError(t, err)
+		w.Write([]byte(entry[1]))
+	}
+	require.NoError(t, zw.Close())
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("archive", "people.zip")
+	require.NoError(t, err)
+	part.Write(archive.Bytes())
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", "/api/import", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestPlanImport(t *testing.T) {
+	entries := [][2]string{
+		{"people/Alice/1.jpg", "a"},
+		{"people/Alice/2.jpg", "a"},
+		{"people/ Bob /1.jpg", "b"},
+		{"people/noise/1.jpg", "n"},
+		{"people/Bob/trip/1.jpg", "b"},
+		{"__MACOSX/people/Alice/._1.jpg", "x"},
+		{"people/.DS_Store", "x"},
+	}
+	req := newImportRequest(t, entries)
+	require.NoError(t, req.ParseMultipartForm(1<<20))
+	file, err := req.MultipartForm.File["archive"][0].Open()
+	require.NoError(t, err)
+	defer file.Close()
+	archive, err := zip.NewReader(file, req.MultipartForm.File["archive"][0].Size)
+	require.NoError(t, err)
+
+	// Общая папка people снимается, но у Bob вложенная папка - она пропускается
+	plan, err := (&Handler{}).planImport(archive.File, 1<<20)
+	require.NoError(t, err)
+
+	require.Len(t, plan.folders, 3)
+	assert.Equal(t, "Bob", plan.folders[0].result.Name) // Имя после normalizePersonName
+	assert.Len(t, plan.folders[0].entries, 1)
+	assert.Equal(t, "Alice", plan.folders[1].result.Name)
+	assert.Len(t, plan.folders[1].entries, 2)
+	assert.Equal(t, "noise", plan.folders[2].result.Name)
+	assert.NotEmpty(t, plan.folders[2].result.Error)
+	assert.Empty(t, plan.folders[2].entries)
+
+	require.Len(t, plan.skipped, 1)
+	assert.Equal(t, "people/Bob/trip/1.jpg", plan.skipped[0].Path)
+}
+
+func TestHandleImportRejectsZipSlip(t *testing.T) {
+	mockRepo := new(MockRepository)
+	uploads := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: uploads, cfg: &config.Config{
+		Import: config.ImportConfig{MaxSize: 1 << 20, MaxEntries: 10, MaxFileSize: 1 << 20, MaxUncompressedSize: 4 << 20},
+	}}
+
+	router := setupTestRouter()
+	router.POST("/api/import", handler.HandleImport)
+
+	for _, name := range []string{"../evil.jpg", "Alice/../../evil.jpg", "/etc/evil.jpg", "Alice\\..\\evil.jpg"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, newImportRequest(t, [][2]string{{"Alice/ok.jpg", "\xFF\xD8\xFF\xE0"}, {name, "x"}}))
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, name)
+		assert.Contains(t, w.Body.String(), "Недопустимый путь", name)
+	}
+
+	// Ничего не распаковано и задача не создана
+	keys, err := uploads.ListAllFiles()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleImportLimits(t *testing.T) {
+	mockRepo := new(MockRepository)
+	handler := &Handler{repo: mockRepo, storage: newTestStorage(t), cfg: &config.Config{
+		Import: config.ImportConfig{MaxSize: 1 << 10, MaxEntries: 2, MaxFileSize: 1 << 10, MaxUncompressedSize: 4 << 10},
+	}}
+
+	router := setupTestRouter()
+	router.POST("/api/import", handler.HandleImport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newImportRequest(t, [][2]string{{"A/1.jpg", "1"}, {"A/2.jpg", "2"}, {"A/3.jpg", "3"}}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "не больше 2")
+
+	// Несжимаемые данные - архив больше лимита
+	noise := make([]byte, 4<<10)
+	rand.New(rand.NewSource(1)).Read(noise)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newImportRequest(t, [][2]string{{"A/1.jpg", string(noise)}}))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Архив без папок людей
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, newImportRequest(t, [][2]string{{"1.jpg", "\xFF\xD8\xFF\xE0"}}))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestHandleImportRejectsZipBomb(t *testing.T) {
+	mockRepo := new(MockRepository)
+	uploads := newTestStorage(t)
+	handler := &Handler{repo: mockRepo, storage: uploads, cfg: &config.Config{
+		Import:  config.ImportConfig{MaxSize: 1 << 20, MaxEntries: 10, MaxFileSize: 3 << 20, MaxUncompressedSize: 4 << 20},
+		Persons: config.PersonsConfig{MaxNameLength: models.DefaultMaxPersonNameLength},
+	}}
+
+	router := setupTestRouter()
+	router.POST("/api/import", handler.HandleImport)
+
+	// Нули сжимаются в сотни раз: архив в несколько КБ распаковывается в 6 МБ
+	bomb := "\xFF\xD8\xFF\xE0" + strings.Repeat("\x00", 3<<20-4)
+	req := newImportRequest(t, [][2]string{{"Alice/1.jpg", bomb}, {"Bob/2.jpg", bomb}})
+	require.Less(t, req.ContentLength, int64(64<<10))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Contains(t, w.Body.String(), "после распаковки больше 4 МБ")
+
+	// Ничего не распаковано и задача не создана
+	keys, err := uploads.ListAllFiles()
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+	mockRepo.AssertNotCalled(t, "CreateTask", mock.Anything)
+}
+
+func TestImportFileUncompressedBudget(t *testing.T) {
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("Alice/1.jpg")
+	require.NoError(t, err)
+	w.Write([]byte("\xFF\xD8\xFF\xE0" + strings.Repeat("\x00", 2<<10)))
+	require.NoError(t, zw.Close())
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	require.NoError(t, err)
+	file := reader.File[0]
+
+	uploads := newTestStorage(t)
+	handler := &Handler{storage: uploads}
+
+	// Файл больше лимита на файл пропускается, бюджет не тратится
+	remaining := int64(1 << 20)
+	_, reason, err := handler.importFile("task", file, "1.jpg", 1<<10, &remaining)
+	require.NoError(t, err)
+	assert.Contains(t, reason, "Файл больше")
+
+	// Распаковка сверх остатка бюджета прерывает импорт
+	remaining = 1 << 10
+	_, _, err = handler.importFile("task", file, "1.jpg", 1<<20, &remaining)
+	assert.True(t, errors.Is(err, errImportTooLarge))
+
+	remaining = 4 << 10
+	key, reason, err := handler.importFile("task", file, "1.jpg", 1<<20, &remaining)
+	require.NoError(t, err)
+	assert.Empty(t, reason)
+	assert.NotEmpty(t, key)
+	assert.Equal(t, int64(4<<10-(2<<10+4)), remaining)
+}
+
+func TestHandleImportProcessesLabeledFolders(t *testing.T) {
+	var pngData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+
+	// Python объединил лица Alice и Bob в один кластер, а второе лицо Bob счел шумом -
+	// при импорте лица все равно группируются по папкам
+	python := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(models.PythonResponse{
+			Success:  true,
+			Clusters: map[string][]string{"person_1": {"face_1", "face_2"}, "noise": {"face_3"}},
+			Embeddings: map[string][]float64{
+				"face_1": {0.1, 0.2},
+				"face_2": {0.3, 0.4},
+				"face_3": {0.5, 0.6},
+			},
+			FacesMetadata: map[string]models.FaceMetadata{
+				"face_1": {OriginalImage: "/uploads/x/alice.png", Bbox: []int{0, 0, 2, 2}},
+				"face_2": {OriginalImage: "/uploads/x/bob.png", Bbox: []int{0, 0, 2, 2}},
+				"face_3": {OriginalImage: "/uploads/x/bob.png", Bbox: []int{2, 2, 4, 4}},
+			},
+		})
+	}))
+	defer python.Close()
+
+	store := newTestStorage(t)
+	mockRepo := new(MockRepository)
+	handler := &Handler{
+		repo:         mockRepo,
+		storage:      store,
+		pythonClient: python_client.NewClient(python.URL),
+		wsManager:    websocket.NewManager(),
+		cfg: &config.Config{
+			Import:  config.ImportConfig{MaxSize: 1 << 20, MaxEntries: 10, MaxFileSize: 1 << 20, MaxUncompressedSize: 4 << 20},
+			Persons: config.PersonsConfig{MaxNameLength: models.DefaultMaxPersonNameLength},
+		},
+	}
+	handler.pythonClient.SetFileOpener(store.Open)
+	go handler.wsManager.Run()
+
+	done := make(chan struct{})
+	var mu sync.Mutex
+	facesByPerson := map[int]int{}
+	mockRepo.On("CreateTask", mock.MatchedBy(func(task *models.Task) bool { return task.TotalImages == 2 })).Return(nil)
+	mockRepo.On("MarkTaskStarted", mock.Anything).Return(nil)
+	mockRepo.On("GetOriginalFilenames", mock.Anything).Return(nil, nil)
+	mockRepo.On("UpdateTaskProgress", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("LinkTaskPerson", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetOrCreatePerson", "Alice").Return(1, true, nil)
+	mockRepo.On("GetOrCreatePerson", "Bob").Return(2, false, nil)
+	mockRepo.On("MarkPersonLabeled", 1).Return(nil).Once() // Только созданный импортом
+	mockRepo.On("CreateFace", mock.Anything).Run(func(args mock.Arguments) {
+		mu.Lock()
+		facesByPerson[args.Get(0).(*models.Face).PersonID]++
+		mu.Unlock()
+	}).Return(nil)
+	mockRepo.On("UpdatePersonCentroid", mock.Anything).Return(nil)
+	mockRepo.On("UpdateTaskStats", mock.Anything, 3, 2, 0, 0, 0).Return(nil)
+	mockRepo.On("SaveTaskFiles", mock.Anything, mock.Anything).Return(nil)
+	mockRepo.On("GetStats").Return(&models.Stats{}, nil)
+	mockRepo.On("UpdateTaskStatus", mock.Anything, models.TaskStatusCompleted, (*string)(nil)).
+		Run(func(mock.Arguments) { close(done) }).Return(nil)
+
+	router := setupTestRouter()
+	router.POST("/api/import", handler.HandleImport)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, newImportRequest(t, [][2]string{
+		{"Alice/alice.png", pngData.String()},
+		{"Bob/bob.png", pngData.String()},
+		{"Bob/notes.txt", "not an image"},
+		{"readme.txt", "hello"},
+	}))
+
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+	var response models.ImportResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "/api/task/"+response.TaskID, w.Header().Get("Location"))
+	require.Len(t, response.Folders, 2)
+	assert.Equal(t, models.ImportFolder{Name: "Alice", Images: 1, Skipped: []models.ImportSkippedFile{}}, response.Folders[0])
+	assert.Equal(t, "Bob", response.Folders[1].Name)
+	assert.Equal(t, 1, response.Folders[1].Images)
+	require.Len(t, response.Folders[1].Skipped, 1)
+	assert.Equal(t, "Bob/notes.txt", response.Folders[1].Skipped[0].Path)
+	require.Len(t, response.Skipped, 1)
+	assert.Equal(t, "readme.txt", response.Skipped[0].Path)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("обработка не завершилась")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[int]int{1: 1, 2: 2}, facesByPerson)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleRecentTasks(t *testing.T) {
+	mockRepo := new(MockRepository)
+	manager, client := newTestWSClient(t)
+	handler := &Handler{repo: mockRepo, cache: cache.NewService(cache.NewLRUBackend(10)), wsManager: manager}
+
+	created := time.Date(2024, 11, 21, 6, 9, 0, 0, time.UTC)
+	tasks := []models.Task{
+		{ID: "task-3", Status: models.TaskStatusProcessing, CreatedAt: created.Add(2 * time.Minute)},
+		{ID: "task-2", Status: models.TaskStatusCompleted, TotalFaces: 4, UniquPersons: 2, CreatedAt: created.Add(time.Minute),
+			StartedAt:   sql.NullTime{Time: created.Add(time.Minute), Valid: true},
+			CompletedAt: sql.NullTime{Time: created.Add(time.Minute + 3*time.Second), Valid: true}},
+		{ID: "task-1", Status: models.TaskStatusFailed, CreatedAt: created},
+	}
+	// Из БД всегда берется максимум, limit только обрезает список из кэша
+	mockRepo.On("GetRecentTasks", maxRecentTasks).Return(tasks, nil).Once()
+
+	router := setupTestRouter()
+	router.GET("/tasks/recent", handler.HandleRecentTasks)
+
+	get := func(url string) (int, models.RecentTasksResponse) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", url, nil)
+		router.ServeHTTP(w, req)
+		var response models.RecentTasksResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w.Code, response
+	}
+
+	code, response := get("/tasks/recent?limit=2")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, response.Tasks, 2)
+	assert.Equal(t, "task-3", response.Tasks[0].ID)
+	assert.Nil(t, response.Tasks[0].Duration)
+	assert.Equal(t, "task-2", response.Tasks[1].ID)
+	assert.Equal(t, 4, response.Tasks[1].TotalFaces)
+	require.NotNil(t, response.Tasks[1].Duration)
+	assert.Equal(t, 3.0, *response.Tasks[1].Duration)
+
+	// Повторный запрос - из кэша, без обращения к БД
+	code, response = get("/tasks/recent")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, response.Tasks, 3)
+	assert.Equal(t, []string{"task-3", "task-2", "task-1"},
+		[]string{response.Tasks[0].ID, response.Tasks[1].ID, response.Tasks[2].ID})
+
+	// Новая задача сбрасывает кэш и приходит всем клиентам
+	newTask := models.Task{ID: "task-4", Status: models.TaskStatusQueued, CreatedAt: created.Add(3 * time.Minute)}
+	mockRepo.On("GetRecentTasks", maxRecentTasks).Return(append([]models.Task{newTask}, tasks...), nil).Once()
+	handler.taskCreated(&newTask)
+
+	message := waitForMessage(t, client, websocket.MessageTypeTaskCreated)
+	assert.Empty(t, message.TaskID)
+	assert.Equal(t, "task-4", message.Payload.(*models.Task).ID)
+
+	code, response = get("/tasks/recent?limit=1")
+	assert.Equal(t, http.StatusOK, code)
+	require.Len(t, response.Tasks, 1)
+	assert.Equal(t, "task-4", response.Tasks[0].ID)
+
+	for _, url := range []string{"/tasks/recent?limit=0", "/tasks/recent?limit=51", "/tasks/recent?limit=abc"} {
+		code, _ = get(url)
+		assert.Equal(t, http.StatusBadRequest, code, url)
+	}
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReconcileStats(t *testing.T) {
+	summary := models.StatsSummary{TotalPersons: 4, TotalFaces: 10, TotalTasks: 2}
+
+	t.Run("in sync", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		mockCache := new(MockCache)
+		handler := &Handler{repo: mockRepo, cache: mockCache}
+		mockRepo.On("ReconcileStats").Return(summary, summary, nil).Once()
+
+		drifted, err := handler.ReconcileStats()
+		require.NoError(t, err)
+		assert.False(t, drifted)
+		mockCache.AssertNotCalled(t, "InvalidateStats")
+	})
+
+	t.Run("drifted", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		mockCache := new(MockCache)
+		handler := &Handler{repo: mockRepo, cache: mockCache}
+		stale := summary
+		stale.TotalFaces = 13
+		mockRepo.On("ReconcileStats").Return(stale, summary, nil).Once()
+		mockCache.On("InvalidateStats").Return(nil).Once()
+
+		drifted, err := handler.ReconcileStats()
+		require.NoError(t, err)
+		assert.True(t, drifted)
+		mockCache.AssertExpectations(t)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		mockRepo := new(MockRepository)
+		handler := &Handler{repo: mockRepo}
+		mockRepo.On("ReconcileStats").Return(models.StatsSummary{}, models.StatsSummary{}, sql.ErrConnDone).Once()
+
+		_, err := handler.ReconcileStats()
+		assert.ErrorIs(t, err, sql.ErrConnDone)
+	})
+}
+
+func TestHandleGetPersonSprite(t *testing.T) {
+	mockRepo, store, router := newCropTestHandler(t)
+
+	faces := []models.Face{
+		{ID: 7, OriginalImage: "task-1/photo.png", FaceX: 80, FaceY: 30, FaceWidth: 40, FaceHeight: 40},  // темное лицо
+		{ID: 8, OriginalImage: "task-1/photo.png"},                                                       // без bbox - не в спрайте
+		{ID: 9, OriginalImage: "task-1/missing.png", FaceX: 0, FaceY: 0, FaceWidth: 10, FaceHeight: 10},  // исходника нет
+		{ID: 10, OriginalImage: "task-1/photo.png", FaceX: 10, FaceY: 10, FaceWidth: 40, FaceHeight: 40}, // светлый фон
+	}
+	person := &models.PersonWithFaces{Person: models.Person{ID: 1, Name: "Alice"}, Faces: faces}
+	mockRepo.On("GetPersonByID", 1).Return(person, nil).Twice()
+
+	get := func(url string) (*httptest.ResponseRecorder, models.PersonSpriteResponse) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var response models.PersonSpriteResponse
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w, response
+	}
+
+	w, sprite := get("/persons/1/sprite?cols=2&size=20")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, 2, sprite.Cols)
+	assert.Equal(t, 20, sprite.Size)
+	assert.Equal(t, 4, sprite.Total)
+	assert.Equal(t, 40, sprite.Width)
+	assert.Equal(t, 20, sprite.Height)
+	assert.Equal(t, map[int]models.SpritePosition{7: {X: 0, Y: 0}, 10: {X: 20, Y: 0}}, sprite.Positions)
+
+	encoded, ok := strings.CutPrefix(sprite.Image, "data:image/jpeg;base64,")
+	require.True(t, ok, sprite.Image[:min(len(sprite.Image), 40)])
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	require.NoError(t, err)
+	img, _, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+	r, _, _, _ := img.At(10, 10).RGBA()
+	assert.Less(t, r>>8, uint32(60))
+	r, _, _, _ = img.At(30, 10).RGBA()
+	assert.Greater(t, r>>8, uint32(200))
+
+	// Тот же набор лиц - спрайт из кэша, исходник уже не нужен
+	require.NoError(t, store.DeleteFiles([]string{"task-1/photo.png"}))
+	w, cached := get("/persons/1/sprite?cols=2&size=20")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, sprite, cached)
+
+	// Лицо удалено - набор другой, спрайт собирается заново (а исходника уже нет)
+	changed := &models.PersonWithFaces{Person: person.Person, Faces: faces[:3]}
+	mockRepo.On("GetPersonByID", 1).Return(changed, nil).Once()
+	w, _ = get("/persons/1/sprite?cols=2&size=20")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assertErrorCode(t, w, apierror.CodeNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestHandleGetPersonSpriteValidation(t *testing.T) {
+	mockRepo, _, router := newCropTestHandler(t)
+	mockRepo.On("GetPersonByID", 2).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetPersonByID", 3).Return(&models.PersonWithFaces{
+		Person: models.Person{ID: 3},
+		Faces:  []models.Face{{ID: 1, OriginalImage: "task-1/photo.png"}},
+	}, nil)
+
+	for url, code := range map[string]int{
+		"/persons/abc/sprite":        http.StatusBadRequest,
+		"/persons/1/sprite?cols=0":   http.StatusBadRequest,
+		"/persons/1/sprite?cols=21":  http.StatusBadRequest,
+		"/persons/1/sprite?size=8":   http.StatusBadRequest,
+		"/persons/1/sprite?size=300": http.StatusBadRequest,
+		"/persons/2/sprite":          http.StatusNotFound,
+		"/persons/3/sprite":          http.StatusNotFound, // лиц с bbox нет
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, code, w.Code, url)
+	}
+	mockRepo.AssertNotCalled(t, "GetPersonByID", 1)
+}
+
+func TestReadEndpointsServeStaleCacheWhenDatabaseDown(t *testing.T) {
+	ctx := context.Background()
+	mockRepo := new(MockRepository)
+	cacheService := cache.NewService(cache.NewLRUBackend(100))
+	handler := &Handler{repo: mockRepo, cache: cacheService}
+
+	router := setupTestRouter()
+	router.GET("/persons/:id", handler.HandleGetPerson)
+	router.GET("/task/:id", handler.HandleTaskStatus)
+	router.GET("/stats", handler.HandleGetStats)
+
+	// Записи были в кэше, но основные ключи истекли - осталась резервная копия
+	require.NoError(t, cacheService.SetPerson(ctx, &models.PersonWithFaces{Person: models.Person{ID: 5, Name: "Alice"}}))
+	require.NoError(t, cacheService.SetTask(ctx, &models.Task{ID: "task-1", Status: models.TaskStatusCompleted}))
+	require.NoError(t, cacheService.SetStats(ctx, &models.Stats{TotalPersons: 3}))
+	require.NoError(t, cacheService.InvalidatePerson(ctx, 5))
+	require.NoError(t, cacheService.InvalidateTask(ctx, "task-1"))
+	require.NoError(t, cacheService.InvalidateStats(ctx))
+
+	mockRepo.On("GetPersonByID", 5).Return(nil, sql.ErrConnDone)
+	mockRepo.On("GetPersonByID", 6).Return(nil, sql.ErrConnDone)
+	mockRepo.On("GetPersonByID", 7).Return(nil, sql.ErrNoRows)
+	mockRepo.On("GetTask", "task-1").Return(nil, sql.ErrConnDone)
+	mockRepo.On("GetStats").Return(nil, sql.ErrConnDone)
+
+	get := func(url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		return w
+	}
+
+	w := get("/persons/5")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Served-Stale"))
+	var person models.PersonWithFaces
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &person))
+	assert.Equal(t, "Alice", person.Name)
+
+	w = get("/task/task-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Served-Stale"))
+	var task models.Task
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &task))
+	assert.Equal(t, models.TaskStatusCompleted, task.Status)
+
+	w = get("/stats")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "true", w.Header().Get("X-Served-Stale"))
+	var stats models.Stats
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &stats))
+	assert.Equal(t, 3, stats.TotalPersons)
+
+	// Ни БД, ни копии - 500; "не найдено" от БД копией не подменяется
+	w = get("/persons/6")
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, w.Header().Get("X-Served-Stale"))
+
+	require.NoError(t, cacheService.SetPerson(ctx, &models.PersonWithFaces{Person: models.Person{ID: 7, Name: "Deleted"}}))
+	require.NoError(t, cacheService.InvalidatePerson(ctx, 7))
+	w = get("/persons/7")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Empty(t, w.Header().Get("X-Served-Stale"))
+}
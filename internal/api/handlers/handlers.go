@@ -1,65 +1,173 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"image"
+	"io"
 	"log"
+	"math"
+	"mime/multipart"
 	"net/http"
+	"path/filepath"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/middleware"
 	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/config"
 	"face-recognition/internal/models"
 	"face-recognition/internal/repository"
 	"face-recognition/internal/service/cache"
+	"face-recognition/internal/service/downloader"
+	"face-recognition/internal/service/queue"
 	"face-recognition/internal/service/storage"
+	"face-recognition/internal/service/webhook"
+	"face-recognition/internal/tracing"
+	"face-recognition/pkg/embedding"
 	"face-recognition/pkg/python_client"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Допустимый диапазон min_size (в пикселях)
+const (
+	minSizeLowerBound = 1
+	minSizeUpperBound = 1000
 )
 
 // Handler содержит все зависимости для обработки HTTP запросов
 type Handler struct {
 	repo         repository.RepositoryInterface
-	storage      *storage.Service
+	storage      storage.ServiceInterface
 	pythonClient *python_client.Client
-	cache        *cache.Service
+	comparer     embedding.Comparer
+	cache        cache.ServiceInterface
 	wsManager    *websocket.Manager
+	notifier     *webhook.Notifier
+	downloader   *downloader.Downloader
+	queue        *queue.Queue    // Ограничивает число одновременно обрабатываемых задач
+	searches     chan struct{}   // Одновременные поиски по лицу (nil - без ограничения)
+	tasks        *taskGuard      // Задачи, над которыми идет операция (одна за раз на задачу)
+	stats        *statsRefresher // Фоновый пересчет статистики (nil - по запросу, через кэш)
+	rebuilds     *cacheRebuilds  // Перестройка кэша администратором
+	cfg          *config.Config
+	ctx          context.Context // Span запроса или фоновой обработки (см. withContext)
 }
 
 // NewHandler создает новый handler с зависимостями
 func NewHandler(
 	repo repository.RepositoryInterface,
-	storage *storage.Service,
+	storage storage.ServiceInterface,
 	pythonClient *python_client.Client,
-	cache *cache.Service,
+	comparer embedding.Comparer,
+	cache cache.ServiceInterface,
 	wsManager *websocket.Manager,
+	notifier *webhook.Notifier,
+	downloader *downloader.Downloader,
+	processingQueue *queue.Queue,
+	cfg *config.Config,
 ) *Handler {
-	return &Handler{
+	h := &Handler{
 		repo:         repo,
 		storage:      storage,
 		pythonClient: pythonClient,
+		comparer:     comparer,
 		cache:        cache,
 		wsManager:    wsManager,
+		notifier:     notifier,
+		downloader:   downloader,
+		queue:        processingQueue,
+		tasks:        newTaskGuard(),
+		rebuilds:     newCacheRebuilds(),
+		cfg:          cfg,
+	}
+	if processingQueue != nil {
+		// Поиск по лицу вызывает Python в обход очереди - не больше поисков, чем воркеров
+		h.searches = make(chan struct{}, processingQueue.Workers())
+	}
+	if cfg != nil && cfg.Cache.StatsRefreshInterval > 0 {
+		h.stats = newStatsRefresher(h.loadStats)
 	}
+	return h
 }
 
 // ============ UPLOAD ============
 
 // HandleUpload обрабатывает загрузку файлов
 func (h *Handler) HandleUpload(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
 	form, err := c.MultipartForm()
+	if middleware.IsBodyTooLarge(err) {
+		respondError(c, apierror.TooLarge(middleware.ErrBodyTooLarge))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Ошибка получения файлов",
-		})
+		respondError(c, apierror.Validation("Ошибка получения файлов"))
 		return
 	}
 
 	files := form.File["images"]
 	if len(files) == 0 {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Файлы не загружены",
+		respondError(c, apierror.Validation("Файлы не загружены"))
+		return
+	}
+
+	// Число файлов проверяется до сохранения: иначе одна загрузка создала бы огромную задачу
+	if maxFiles := h.maxFilesPerUpload(); maxFiles > 0 && len(files) > maxFiles {
+		respondError(c, apierror.Validation(fmt.Sprintf("Слишком много файлов: %d (максимум %d)", len(files), maxFiles)))
+		return
+	}
+
+	// Параметры детекции (опциональные поля формы)
+	params, err := parseDetectionParamsWithBase(form.Value, h.defaultDetectionParams())
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	// URL для уведомления о завершении (опционально)
+	callbackURL := firstValue(form.Value, "callback_url")
+	if callbackURL != "" {
+		if err := h.validateCallbackURL(callbackURL); err != nil {
+			respondError(c, apierror.Validation(err.Error()))
+			return
+		}
+	}
+
+	// Пробный запуск: только отчет о проверке файлов
+	if c.Query("validate_only") == "true" {
+		h.validateUpload(c, files, params)
+		return
+	}
+
+	// Те же файлы с теми же параметрами уже обработаны - отдаем готовую задачу
+	contentHash, err := uploadHash(files, params)
+	if err != nil {
+		respondError(c, apierror.Validation(fmt.Sprintf("Ошибка чтения файлов: %v", err)))
+		return
+	}
+
+	if existing := h.findCompletedUpload(contentHash); existing != nil {
+		h.notifyReplay(callbackURL, existing)
+
+		c.Header("X-Idempotent-Replay", "true")
+		c.JSON(http.StatusOK, models.UploadResponse{
+			TaskID:  existing.ID,
+			Message: "Эти файлы уже обработаны, возвращена существующая задача",
 		})
 		return
 	}
@@ -67,102 +175,474 @@ func (h *Handler) HandleUpload(c *gin.Context) {
 	// Сохраняем файлы через storage service
 	taskID, savedFiles, err := h.storage.SaveUploadedFiles(files)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: fmt.Sprintf("Ошибка сохранения файлов: %v", err),
-		})
+		respondError(c, apierror.Internal("Ошибка сохранения файлов", err))
 		return
 	}
 
 	// Создаем задачу в БД
-	if err := h.repo.CreateTask(taskID, len(files)); err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: "Ошибка создания задачи",
-		})
+	task := &models.Task{
+		ID:            taskID,
+		TotalImages:   len(files),
+		MinSize:       params.MinSize,
+		DetThresh:     params.DetThresh,
+		MinConfidence: params.MinConfidence,
+		CallbackURL:   callbackURL,
+		ContentHash:   contentHash,
+	}
+	if err := h.repo.CreateTask(task); err != nil {
+		respondError(c, apierror.Internal("Ошибка создания задачи", err))
 		return
 	}
+	h.taskCreated(task)
 
-	// Запускаем обработку асинхронно
-	go h.processImages(taskID, savedFiles)
+	names := originalNames{}
+	for i, key := range savedFiles {
+		names.add(key, files[i].Filename)
+	}
+	h.saveOriginalNames(taskID, names)
 
-	c.JSON(http.StatusOK, models.UploadResponse{
+	// Обработку выполнит воркер очереди; до этого задача в статусе queued
+	if err := h.enqueue(taskID, func() { h.processImages(taskID, savedFiles, params, callbackURL) }); err != nil {
+		log.Printf("❌ Задача %s не поставлена в очередь: %v", taskID, err)
+		h.failTask(taskID, names.fill(taskFileResults(savedFiles, nil, err.Error(), nil)), err.Error(), nil, len(files), callbackURL)
+		respondError(c, apierror.Unavailable(err.Error()))
+		return
+	}
+
+	acceptTask(c, taskID, fmt.Sprintf("Загружено %d файлов, начата обработка", len(files)))
+}
+
+// maxFilesPerUpload возвращает максимум файлов в одной загрузке (0 - без ограничения)
+func (h *Handler) maxFilesPerUpload() int {
+	if h.cfg == nil {
+		return config.DefaultMaxFilesPerUpload
+	}
+	return h.cfg.Server.MaxFilesPerUpload
+}
+
+// acceptTask отвечает 202 Accepted: задача создана и обрабатывается асинхронно,
+// ее статус - по адресу из заголовка Location. task_id остается и в теле ответа
+func acceptTask(c *gin.Context, taskID, message string) {
+	c.Header("Location", "/api/task/"+taskID)
+	c.JSON(http.StatusAccepted, models.UploadResponse{
 		TaskID:  taskID,
-		Message: fmt.Sprintf("Загружено %d файлов, начата обработка", len(files)),
+		Message: message,
+	})
+}
+
+// uploadHash вычисляет SHA-256 загрузки: хэши файлов сортируются, поэтому
+// порядок и имена файлов не важны. Параметры детекции тоже входят в хэш -
+// с другими параметрами результат обработки будет другим
+func uploadHash(files []*multipart.FileHeader, params models.DetectionParams) (string, error) {
+	fileHashes := make([]string, 0, len(files))
+	for _, fileHeader := range files {
+		file, err := fileHeader.Open()
+		if err != nil {
+			return "", err
+		}
+
+		hash := sha256.New()
+		_, err = io.Copy(hash, file)
+		file.Close()
+		if err != nil {
+			return "", err
+		}
+
+		fileHashes = append(fileHashes, hex.EncodeToString(hash.Sum(nil)))
+	}
+	sort.Strings(fileHashes)
+
+	hash := sha256.New()
+	for _, fileHash := range fileHashes {
+		io.WriteString(hash, fileHash)
+	}
+	fmt.Fprintf(hash, "min_size=%d;det_thresh=%g", params.MinSize, params.DetThresh)
+	// Без фильтрации хэш совпадает с хэшами задач, созданных до появления min_confidence
+	if params.MinConfidence > 0 {
+		fmt.Fprintf(hash, ";min_confidence=%g", params.MinConfidence)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// findCompletedUpload ищет завершенную задачу с тем же хэшем (сначала в кэше)
+// Ошибки поиска не мешают загрузке - файлы просто обрабатываются заново
+func (h *Handler) findCompletedUpload(contentHash string) *models.Task {
+	if h.cache != nil {
+		if taskID, err := h.cache.GetTaskIDByHash(h.context(), contentHash); err == nil && taskID != "" {
+			if task, err := h.cache.GetTask(h.context(), taskID); err == nil && task != nil && task.Status == models.TaskStatusCompleted {
+				return task
+			}
+			// Устаревшая запись (задачу успели перезапустить) не должна вернуть незавершенную задачу
+			if task, err := h.repo.GetTask(taskID); err == nil && task.Status == models.TaskStatusCompleted {
+				return task
+			}
+		}
+	}
+
+	task, err := h.repo.GetCompletedTaskByHash(contentHash)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("⚠️  Ошибка поиска задачи по хэшу: %v", err)
+		}
+		return nil
+	}
+
+	if h.cache != nil {
+		h.cache.SetTaskIDByHash(h.context(), contentHash, task.ID)
+	}
+
+	return task
+}
+
+// notifyReplay отправляет callback с результатами уже завершенной задачи
+func (h *Handler) notifyReplay(callbackURL string, task *models.Task) {
+	if callbackURL == "" {
+		return
+	}
+
+	go h.notifyCallback(callbackURL, models.TaskWebhookPayload{
+		TaskID:        task.ID,
+		Status:        task.Status,
+		TotalImages:   task.TotalImages,
+		TotalFaces:    task.TotalFaces,
+		UniquePersons: task.UniquPersons,
+		RejectedFaces: task.RejectedFaces,
 	})
 }
 
+// defaultDetectionParams возвращает параметры по умолчанию с учетом конфигурации
+func (h *Handler) defaultDetectionParams() models.DetectionParams {
+	params := models.DefaultDetectionParams()
+	if h.cfg != nil {
+		params.MinConfidence = h.cfg.Detection.MinConfidence
+	}
+	return params
+}
+
+// parseDetectionParams читает min_size, det_thresh и min_confidence из формы
+// Отсутствующие поля заменяются значениями по умолчанию
+func parseDetectionParams(values map[string][]string) (models.DetectionParams, error) {
+	return parseDetectionParamsWithBase(values, models.DefaultDetectionParams())
+}
+
+// parseDetectionParamsWithBase читает min_size, det_thresh и min_confidence из формы
+// Отсутствующие поля берутся из base
+func parseDetectionParamsWithBase(values map[string][]string, base models.DetectionParams) (models.DetectionParams, error) {
+	params := base
+
+	if v := firstValue(values, "min_size"); v != "" {
+		minSize, err := strconv.Atoi(v)
+		if err != nil {
+			return params, fmt.Errorf("min_size должен быть целым числом")
+		}
+		if minSize < minSizeLowerBound || minSize > minSizeUpperBound {
+			return params, fmt.Errorf("min_size должен быть в диапазоне %d-%d", minSizeLowerBound, minSizeUpperBound)
+		}
+		params.MinSize = minSize
+	}
+
+	if v := firstValue(values, "det_thresh"); v != "" {
+		detThresh, err := parseFiniteFloat(v)
+		if err != nil {
+			return params, fmt.Errorf("det_thresh должен быть числом")
+		}
+		if detThresh <= 0 || detThresh > 1 {
+			return params, fmt.Errorf("det_thresh должен быть в диапазоне (0, 1]")
+		}
+		params.DetThresh = detThresh
+	}
+
+	if v := firstValue(values, "min_confidence"); v != "" {
+		minConfidence, err := parseFiniteFloat(v)
+		if err != nil {
+			return params, fmt.Errorf("min_confidence должен быть числом")
+		}
+		if minConfidence < 0 || minConfidence > 1 {
+			return params, fmt.Errorf("min_confidence должен быть в диапазоне [0, 1]")
+		}
+		params.MinConfidence = minConfidence
+	}
+
+	return params, nil
+}
+
+// parseFiniteFloat разбирает число из запроса, отклоняя NaN и ±Inf: strconv.ParseFloat
+// их принимает, а с NaN любое сравнение ложно, поэтому проверка диапазона его бы пропустила
+func parseFiniteFloat(v string) (float64, error) {
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("%s не является конечным числом", v)
+	}
+	return f, nil
+}
+
+// firstValue возвращает первое значение поля формы или пустую строку
+func firstValue(values map[string][]string, key string) string {
+	if v := values[key]; len(v) > 0 {
+		return strings.TrimSpace(v[0])
+	}
+	return ""
+}
+
+// withContext возвращает копию обработчика, у которой запросы к БД, кэшу и Python
+// попадают в trace из ctx. Отмена ctx не наследуется: обработка, запущенная
+// из запроса, продолжается после ответа клиенту
+func (h *Handler) withContext(ctx context.Context) *Handler {
+	return h.bindContext(context.WithoutCancel(ctx))
+}
+
+// bindContext - как withContext, но без отвязки от отмены: запросы к БД и Python
+// прерываются вместе с ctx (используется для ограничения времени обработки)
+func (h *Handler) bindContext(ctx context.Context) *Handler {
+	clone := *h
+	clone.ctx = ctx
+	if h.repo != nil {
+		clone.repo = h.repo.WithContext(ctx)
+	}
+	return &clone
+}
+
+// context возвращает контекст обработчика (Background, если withContext не вызывался)
+// С ним идут запись и инвалидация кэша: они не должны прерываться, если клиент
+// отключился, иначе в кэше остались бы устаревшие данные. Чтение кэша в обработчике
+// запроса получает c.Request.Context() и прерывается вместе с запросом
+func (h *Handler) context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+	return h.ctx
+}
+
 // processImages обрабатывает изображения через Python (InsightFace)
-func (h *Handler) processImages(taskID string, imagePaths []string) {
+func (h *Handler) processImages(taskID string, imagePaths []string, params models.DetectionParams, callbackURL string) {
+	h.processFiles(taskID, imagePaths, nil, params, callbackURL, nil)
+}
+
+// processFiles обрабатывает сохраненные файлы задачи; failed - файлы, которые
+// не попали в хранилище (например, не скачались по URL). Они входят в total_images
+// и в результат по файлам со своей ошибкой. labels (импорт) задает имя человека
+// для каждого файла: лица группируются по именам, а не по кластерам Python
+func (h *Handler) processFiles(taskID string, imagePaths []string, failed []models.TaskFile, params models.DetectionParams, callbackURL string, labels map[string]string) {
+	totalImages := len(imagePaths) + len(failed)
+
+	// Фоновая обработка - отдельный span внутри trace запроса, который ее запустил
+	ctx, span := tracing.Start(h.context(), "processImages", trace.WithAttributes(
+		attribute.String("task.id", taskID),
+		attribute.Int("images.count", totalImages),
+	))
+	defer span.End()
+	h = h.withContext(ctx)
+
+	// Имена от клиента, если файлы сохранены под другими (STORAGE_FILE_NAMING)
+	names := h.loadOriginalNames(taskID)
+
+	// Обработка ограничена PROCESSING_TIMEOUT, чтобы зависший Python или БД
+	// не оставляли задачу в processing навсегда. Просроченную задачу переводит
+	// в failed base - обработчик без дедлайна
+	base := h
+	timeout := h.processingTimeout()
+	ctx, cancel := context.WithTimeout(h.context(), timeout)
+	defer cancel()
+	h = h.bindContext(ctx)
+	failTimedOut := func(imagePaths []string, fileErrors map[string]string) {
+		errorMsg := fmt.Sprintf("Обработка не завершилась за %v", timeout)
+		log.Printf("❌ Задача %s: %s", taskID, errorMsg)
+		base.failTask(taskID, append(names.fill(taskFileResults(imagePaths, nil, errorMsg, fileErrors)), failed...),
+			errorMsg, nil, totalImages, callbackURL)
+	}
+
+	// Длительность задачи считается отсюда - без ожидания в очереди и скачивания по URL
+	if err := h.repo.MarkTaskStarted(taskID); err != nil {
+		log.Printf("⚠️  Задача %s: не удалось сохранить время начала обработки: %v", taskID, err)
+	}
+
 	// Отправляем начальное уведомление
 	h.wsManager.BroadcastTaskUpdate(taskID, models.TaskStatusProcessing, map[string]interface{}{
 		"message": "Начало обработки",
-		"total":   len(imagePaths),
+		"total":   totalImages,
 	})
 
-	log.Printf("🚀 Задача %s: Обработка %d изображений", taskID, len(imagePaths))
+	log.Printf("🚀 Задача %s: Обработка %d изображений (min_size=%d, det_thresh=%.2f, min_confidence=%.2f)",
+		taskID, len(imagePaths), params.MinSize, params.DetThresh, params.MinConfidence)
+
+	// Пустые и битые файлы, а также изображения, которые раскодируются в слишком
+	// много пикселей, отклоняются по заголовку - до конвертации HEIC и отправки в Python
+	fileErrors := h.checkImages(taskID, imagePaths)
+	if len(imagePaths) > 0 && allFailed(imagePaths, fileErrors) {
+		errorMsg := fmt.Sprintf("Нет изображений для обработки: все файлы (%d) пустые, повреждены или слишком большие", len(imagePaths))
+		log.Printf("❌ Задача %s: %s", taskID, errorMsg)
+		h.failTask(taskID, append(names.fill(taskFileResults(imagePaths, nil, errorMsg, fileErrors)), failed...),
+			errorMsg, nil, totalImages, callbackURL)
+		return
+	}
+
+	// HEIC/HEIF с iPhone InsightFace не читает - конвертируем в JPEG.
+	// Файлы, которые не удалось декодировать, помечаются ошибкой и не отправляются в Python
+	originalPaths := imagePaths
+	imagePaths, fileErrors = h.convertImages(taskID, imagePaths, fileErrors)
+	names.renamed(originalPaths, imagePaths)
+	if labels != nil {
+		// Ключ HEIC после конвертации другой - имя переносим на новый ключ
+		for i, key := range imagePaths {
+			labels[key] = labels[originalPaths[i]]
+		}
+	}
 
-	// Этап 1: Отправка в Python (детекция + embeddings + кластеризация)
-	h.wsManager.BroadcastTaskProgress(taskID, 10, 100, "Отправка в Python")
+	// Из видео извлекаются кадры - в Python уходят они, а не само видео
+	frames, fileErrors := h.extractVideoFrames(taskID, imagePaths, fileErrors)
+	pythonPaths := make([]string, 0, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		if _, failed := fileErrors[filepath.Base(imagePath)]; !failed {
+			pythonPaths = append(pythonPaths, frames.pythonPaths(imagePath)...)
+		}
+	}
+	if labels != nil {
+		// Кадры видео получают имя самого видео
+		for video, extracted := range frames.byVideo {
+			for _, frame := range extracted {
+				labels[frame.Key] = labels[video]
+			}
+		}
+	}
 
-	// Параметры детекции
-	minSize := 30    // Минимальный размер лица в пикселях
-	detThresh := 0.5 // Порог уверенности детекции
+	// Этап 1: Отправка в Python (детекция + embeddings + кластеризация)
+	h.reportProgress(taskID, 10, "Отправка в Python")
 
 	// Вызываем Python для полной обработки
-	result, err := h.pythonClient.ProcessImages(imagePaths, taskID, minSize, detThresh)
+	result := &models.PythonResponse{Success: true}
+	var err error
+	if len(pythonPaths) > 0 {
+		result, err = h.pythonClient.ProcessImages(h.context(), pythonPaths, taskID, params.MinSize, params.DetThresh)
+	}
 
+	if ctx.Err() != nil {
+		failTimedOut(imagePaths, fileErrors)
+		return
+	}
 	if err != nil {
 		errorMsg := fmt.Sprintf("Ошибка Python обработки: %v", err)
 		log.Printf("❌ %s", errorMsg)
-		h.repo.UpdateTaskStatus(taskID, models.TaskStatusFailed, &errorMsg)
-
-		h.wsManager.BroadcastTaskUpdate(taskID, models.TaskStatusFailed, map[string]interface{}{
-			"error": errorMsg,
-		})
+		h.failTask(taskID, append(names.fill(taskFileResults(imagePaths, nil, errorMsg, fileErrors)), failed...),
+			errorMsg, nil, totalImages, callbackURL)
 		return
 	}
 
 	log.Printf("✅ Python обработка завершена: %d лиц, %d людей", result.TotalFaces, result.UniquePersons)
 
+	// Лица из кластеров без метаданных или embedding сохранить нельзя. Немного таких
+	// лиц - предупреждения в задаче, слишком много - ответу Python доверять нельзя
+	check := checkPythonResult(result)
+	if check.inconsistent > 0 {
+		log.Printf("⚠️  Задача %s: %d из %d лиц в ответе Python без метаданных или embedding",
+			taskID, check.inconsistent, check.total)
+	}
+
+	if maxRatio := h.maxInconsistentRatio(); float64(check.inconsistent) > maxRatio*float64(check.total) {
+		errorMsg := fmt.Sprintf("Python вернул несогласованный результат: %d из %d лиц без метаданных или embedding (допустимо %.0f%%)",
+			check.inconsistent, check.total, maxRatio*100)
+		log.Printf("❌ %s", errorMsg)
+		h.failTask(taskID, append(names.fill(taskFileResults(imagePaths, nil, errorMsg, fileErrors)), failed...),
+			errorMsg, check.warnings, totalImages, callbackURL)
+		return
+	}
+
+	if labels != nil {
+		check.clusters = h.labelClusters(taskID, check.clusters, result, labels)
+	}
+
+	// Детектор иногда находит одно лицо дважды - лишние рамки до сохранения убираются
+	var mergedFaces int
+	check.clusters, mergedFaces = mergeOverlappingFaces(check.clusters, result.FacesMetadata, h.maxFaceOverlap())
+	if mergedFaces > 0 {
+		log.Printf("⚠️  Задача %s: %d повторных детекций одного лица не сохранено", taskID, mergedFaces)
+	}
+
 	// Этап 2: Сохранение результатов в БД
-	h.wsManager.BroadcastTaskProgress(taskID, 70, 100, "Сохранение в базу данных")
+	h.reportProgress(taskID, 70, "Сохранение в базу данных")
 
 	totalFaces := 0
 	uniquePersons := 0
+	rejectedFaces := 0
+	overflowFaces := 0
+	events := h.newFaceEvents(taskID)
+	capturedAt := h.captureTimes(pythonPaths)
+	qualities := h.faceQualities(taskID, check.clusters, result.FacesMetadata)
+	var touched []int // Люди, которым задача могла добавить лица
 
 	// Обрабатываем каждый кластер
-	for clusterID, faceIDs := range result.Clusters {
+	for clusterID, faceIDs := range check.clusters {
+		if ctx.Err() != nil {
+			break // Время вышло - задача переводится в failed ниже
+		}
+
 		// Пропускаем noise кластер
-		if clusterID == "noise" {
+		if clusterID == noiseCluster {
 			log.Printf("⚠️  Пропускаем %d outlier лиц", len(faceIDs))
 			continue
 		}
 
+		// Отбрасываем неуверенные детекции до создания персоны,
+		// чтобы кластер из одних таких лиц не превратился в пустого человека
+		faceIDs, rejected := filterByConfidence(faceIDs, result.FacesMetadata, params.MinConfidence)
+		rejectedFaces += rejected
+		if len(faceIDs) == 0 {
+			log.Printf("⚠️  Кластер %s: все %d лиц ниже min_confidence", clusterID, rejected)
+			continue
+		}
+
 		// Создаем или находим персону
-		personID, err := h.repo.GetOrCreatePerson(clusterID)
+		personID, created, err := h.repo.GetOrCreatePerson(clusterID)
 		if err != nil {
 			log.Printf("⚠️  Ошибка создания персоны %s: %v", clusterID, err)
 			continue
 		}
+		touched = append(touched, personID)
+		if created {
+			h.broadcastPersonEvent(websocket.MessageTypePersonCreated, personID, clusterID)
+
+			// Импорт создает людей сразу с именем папки - подписывать их не нужно
+			if labels != nil {
+				if err := h.repo.MarkPersonLabeled(personID); err != nil {
+					log.Printf("⚠️  Человек %d не отмечен подписанным: %v", personID, err)
+				}
+			}
+		}
+
+		// Человек не накапливает больше PERSON_MAX_FACES лиц - лишние только считаются
+		faceIDs, overflow := h.capPersonFaces(personID, created, faceIDs, result.FacesMetadata)
+		overflowFaces += overflow
+		if overflow > 0 {
+			log.Printf("⚠️  Человек %d: лимит лиц, не сохранено %d лиц кластера %s", personID, overflow, clusterID)
+		}
+		if len(faceIDs) == 0 {
+			continue
+		}
 		uniquePersons++
 
+		// Задача помнит своих людей - и созданных, и дополненных (созданных другой задачей)
+		if err := h.repo.LinkTaskPerson(taskID, personID, created); err != nil {
+			log.Printf("⚠️  Ошибка связи задачи %s с человеком %d: %v", taskID, personID, err)
+		}
+
+		// Лучшее лицо кластера - обложка нового человека
+		coverID, bestScore := 0, -1.0
+		saved := 0
+
 		// Сохраняем каждое лицо в кластере
 		for _, faceID := range faceIDs {
-			// Получаем метаданные лица
-			metadata, exists := result.FacesMetadata[faceID]
-			if !exists {
-				log.Printf("⚠️  Метаданные для %s не найдены", faceID)
-				continue
+			if ctx.Err() != nil {
+				break
 			}
 
-			// Получаем embedding
-			embedding, exists := result.Embeddings[faceID]
-			if !exists {
-				log.Printf("⚠️  Embedding для %s не найден", faceID)
-				continue
-			}
+			// Метаданные и embedding есть - это проверено в checkPythonResult
+			metadata := result.FacesMetadata[faceID]
 
 			// Конвертируем embedding в JSON для хранения
-			embeddingBytes, err := json.Marshal(embedding)
+			embeddingBytes, err := json.Marshal(result.Embeddings[faceID])
 			if err != nil {
 				log.Printf("⚠️  Ошибка сериализации embedding: %v", err)
 				continue
@@ -179,17 +659,28 @@ func (h *Handler) processImages(taskID string, imagePaths []string) {
 			}
 
 			// Создаем запись лица в БД
+			// Пути от Python приводим к ключам хранилища ("task_id/filename",
+			// аннотированные фото - "results/task_id/filename")
 			face := &models.Face{
 				PersonID:       personID,
-				OriginalImage:  metadata.OriginalImage,
-				AnnotatedImage: metadata.BoxedImage,
+				TaskID:         taskID,
+				OriginalImage:  h.fileKey(taskID, metadata.OriginalImage),
+				AnnotatedImage: h.resultKey(taskID, metadata.BoxedImage),
 				FaceX:          faceX,
 				FaceY:          faceY,
 				FaceWidth:      faceWidth,
 				FaceHeight:     faceHeight,
+				ImageWidth:     imageDimension(metadata.ImageWidth),
+				ImageHeight:    imageDimension(metadata.ImageHeight),
 				Embedding:      embeddingBytes,
+				EmbeddingModel: result.Model,
 				Confidence:     metadata.Confidence,
+				Quality:        qualities[faceID],
 			}
+			face.CapturedAt = capturedAt[face.OriginalImage]
+			face.FrameTime = frames.frameTime(face.OriginalImage)
+			face.OriginalFilename = names.lookup(frames.source(face.OriginalImage))
+			face.ThumbnailImage = h.generateThumbnail(face)
 
 			if err := h.repo.CreateFace(face); err != nil {
 				log.Printf("⚠️  Ошибка сохранения лица в БД: %v", err)
@@ -198,178 +689,1196 @@ func (h *Handler) processImages(taskID string, imagePaths []string) {
 				continue
 			}
 			totalFaces++
+			saved++
+			events.add(face)
+
+			if score := coverScore(metadata, face.Quality); score > bestScore {
+				coverID, bestScore = face.ID, score
+			}
 
 			log.Printf("   ✓ Сохранено лицо %s: PersonID=%d, bbox=(%d,%d,%dx%d)",
 				faceID, personID, faceX, faceY, faceWidth, faceHeight)
 		}
+		if ctx.Err() != nil {
+			break // Сохраненные лица откатываются ниже - центроид и обложка не нужны
+		}
+
+		// Центроид пересчитываем один раз на кластер, а не после каждого лица
+		if saved > 0 {
+			if err := h.repo.UpdatePersonCentroid(personID); err != nil {
+				log.Printf("⚠️  Ошибка пересчета центроида человека %d: %v", personID, err)
+			}
+		}
+
+		// Обложку существующего человека не трогаем - она могла быть выбрана вручную
+		if created && coverID != 0 {
+			if _, err := h.repo.UpdatePersonCover(personID, coverID); err != nil {
+				log.Printf("⚠️  Ошибка выбора обложки человека %d: %v", personID, err)
+			}
+		}
+	}
+
+	// Сохраненные до таймаута лица удаляются, как при повторной обработке: иначе
+	// у людей осталась бы часть лиц задачи в failed, а кэш и статистика устарели бы
+	if ctx.Err() != nil {
+		base.discardTimedOutFaces(taskID, touched)
+		failTimedOut(imagePaths, fileErrors)
+		return
 	}
 
-	log.Printf("💾 Сохранено в БД: %d лиц, %d людей", totalFaces, uniquePersons)
+	events.flush()
+	log.Printf("💾 Сохранено в БД: %d лиц, %d людей (отброшено: %d, сверх лимита: %d, повторов: %d)",
+		totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces)
+
+	h.saveTaskFiles(taskID, append(names.fill(taskFileResults(imagePaths, frames.sourceMetadata(result.FacesMetadata), "", fileErrors)), failed...))
+
+	// Прогресс 100% сохраняем до смены статуса, чтобы завершенная задача
+	// никогда не читалась из БД с промежуточным прогрессом
+	h.reportProgress(taskID, 100, "Готово!")
 
 	// Обновляем статистику задачи
-	h.repo.UpdateTaskStats(taskID, totalFaces, uniquePersons)
+	h.repo.UpdateTaskStats(taskID, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces)
+	h.saveTaskWarnings(taskID, check.warnings)
 	h.repo.UpdateTaskStatus(taskID, models.TaskStatusCompleted, nil)
 
 	// Инвалидируем кэш
 	if h.cache != nil {
-		h.cache.InvalidateStats()
+		h.cache.InvalidateTask(h.context(), taskID)
+		h.cache.InvalidateStats(h.context())
 	}
+	h.recentTasksChanged()
 
 	// Отправляем финальное уведомление
-	h.wsManager.BroadcastTaskUpdate(taskID, models.TaskStatusCompleted, map[string]interface{}{
+	completed := map[string]interface{}{
 		"total_faces":    totalFaces,
 		"unique_persons": uniquePersons,
-	})
-
-	h.wsManager.BroadcastTaskProgress(taskID, 100, 100, "Готово!")
+		"rejected_faces": rejectedFaces,
+		"overflow_faces": overflowFaces,
+		"merged_faces":   mergedFaces,
+	}
+	if len(check.warnings) > 0 {
+		completed["warnings"] = check.warnings
+	}
+	h.wsManager.BroadcastTaskUpdate(taskID, models.TaskStatusCompleted, completed)
 
 	// Обновляем статистику для всех клиентов
-	if stats, err := h.repo.GetStats(); err == nil {
+	if stats, err := h.freshStats(); err == nil {
 		h.wsManager.BroadcastStatsUpdate(stats)
 	}
 
+	h.notifyCallback(callbackURL, models.TaskWebhookPayload{
+		TaskID:        taskID,
+		Status:        models.TaskStatusCompleted,
+		TotalImages:   totalImages,
+		TotalFaces:    totalFaces,
+		UniquePersons: uniquePersons,
+		RejectedFaces: rejectedFaces,
+		OverflowFaces: overflowFaces,
+		MergedFaces:   mergedFaces,
+		Warnings:      check.warnings,
+	})
+
 	log.Printf("✅ Задача %s завершена успешно", taskID)
 }
 
-// ============ TASKS ============
-
-// HandleTaskStatus возвращает статус задачи (с кэшем)
-func (h *Handler) HandleTaskStatus(c *gin.Context) {
-	taskID := c.Param("id")
-
-	// Пробуем из кэша
-	if h.cache != nil {
-		if task, err := h.cache.GetTask(taskID); err == nil && task != nil {
-			c.JSON(http.StatusOK, task)
-			return
+// discardTimedOutFaces удаляет лица, которые задача успела сохранить до PROCESSING_TIMEOUT,
+// и людей, у которых не осталось лиц. Если удалить не удалось, лица остаются до повторной
+// обработки, но кэш затронутых людей (personIDs) и статистика все равно сбрасываются
+func (h *Handler) discardTimedOutFaces(taskID string, personIDs []int) {
+	persons, faces, err := h.repo.DeleteTaskFaces(taskID)
+	if err != nil {
+		log.Printf("⚠️  Задача %s: не удалось удалить лица, сохраненные до таймаута: %v", taskID, err)
+		if h.cache != nil {
+			for _, personID := range personIDs {
+				h.cache.InvalidatePerson(h.context(), personID)
+			}
+			h.cache.InvalidateStats(h.context())
 		}
-	}
-
-	// Из БД
-	task, err := h.repo.GetTask(taskID)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: "Задача не найдена",
-		})
+		h.statsChanged()
 		return
 	}
 
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
-		return
+	log.Printf("🧹 Задача %s: удалено %d лиц и %d людей, сохраненных до таймаута", taskID, len(faces), len(persons))
+	h.discardTaskResults(&models.Task{ID: taskID}, persons, faces)
+}
+
+// processingTimeout возвращает максимальное время обработки одной задачи
+func (h *Handler) processingTimeout() time.Duration {
+	if h.cfg == nil || h.cfg.Queue.Timeout <= 0 {
+		return config.DefaultProcessingTimeout
 	}
+	return h.cfg.Queue.Timeout
+}
+
+// failTask переводит задачу в failed: сохраняет результаты по файлам и предупреждения,
+// рассылает ошибку по WebSocket и отправляет webhook
+func (h *Handler) failTask(taskID string, files []models.TaskFile, errorMsg string, warnings []string, totalImages int, callbackURL string) {
+	trace.SpanFromContext(h.context()).SetStatus(codes.Error, errorMsg)
+
+	h.saveTaskFiles(taskID, files)
+	h.saveTaskWarnings(taskID, warnings)
+	h.repo.UpdateTaskStatus(taskID, models.TaskStatusFailed, &errorMsg)
 
-	// Сохраняем в кэш
 	if h.cache != nil {
-		h.cache.SetTask(task)
+		h.cache.InvalidateTask(h.context(), taskID)
 	}
+	h.recentTasksChanged()
 
-	c.JSON(http.StatusOK, task)
+	failed := map[string]interface{}{
+		"error": errorMsg,
+	}
+	if len(warnings) > 0 {
+		failed["warnings"] = warnings
+	}
+	h.wsManager.BroadcastTaskUpdate(taskID, models.TaskStatusFailed, failed)
+
+	h.notifyCallback(callbackURL, models.TaskWebhookPayload{
+		TaskID:      taskID,
+		Status:      models.TaskStatusFailed,
+		TotalImages: totalImages,
+		Warnings:    warnings,
+		Error:       errorMsg,
+	})
 }
 
-// ============ PERSONS ============
+// maxTaskWarnings - сколько предупреждений сохраняется в задаче
+// Если Python сломался целиком, тысячи одинаковых строк в задаче не нужны
+const maxTaskWarnings = 50
 
-// HandleGetPersons возвращает всех людей
-func (h *Handler) HandleGetPersons(c *gin.Context) {
-	persons, err := h.repo.GetAllPersons()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
-		return
+// pythonResultCheck - итог проверки ответа Python перед сохранением
+type pythonResultCheck struct {
+	clusters     map[string][]string // Кластеры без несогласованных лиц (noise - как есть)
+	warnings     []string            // Описание каждой несогласованности (не больше maxTaskWarnings)
+	inconsistent int                 // Лиц без метаданных или embedding, повторов в кластерах
+	total        int                 // Всего лиц в кластерах, кроме noise
+}
+
+// noiseCluster - кластер Python с лицами, не попавшими ни в одного человека
+const noiseCluster = "noise"
+
+// checkPythonResult проверяет, что у каждого лица из кластеров есть метаданные
+// и непустой embedding, и что лицо не попало в несколько кластеров
+// Noise кластер не проверяется - его лица все равно не сохраняются
+func checkPythonResult(result *models.PythonResponse) pythonResultCheck {
+	check := pythonResultCheck{clusters: make(map[string][]string, len(result.Clusters))}
+
+	clusterIDs := make([]string, 0, len(result.Clusters))
+	for clusterID := range result.Clusters {
+		clusterIDs = append(clusterIDs, clusterID)
 	}
+	sort.Strings(clusterIDs) // Стабильный порядок предупреждений
+
+	var warnings []string
+	seen := make(map[string]string)
+	for _, clusterID := range clusterIDs {
+		faceIDs := result.Clusters[clusterID]
+		if clusterID == noiseCluster {
+			check.clusters[clusterID] = faceIDs
+			continue
+		}
 
-	if persons == nil {
-		persons = []models.PersonWithFaces{}
+		valid := make([]string, 0, len(faceIDs))
+		for _, faceID := range faceIDs {
+			check.total++
+
+			var problem string
+			if other, dup := seen[faceID]; dup {
+				problem = fmt.Sprintf("лицо %s уже есть в кластере %s", faceID, other)
+			} else if _, ok := result.FacesMetadata[faceID]; !ok {
+				problem = fmt.Sprintf("лицо %s (кластер %s): нет метаданных", faceID, clusterID)
+			} else if len(result.Embeddings[faceID]) == 0 {
+				problem = fmt.Sprintf("лицо %s (кластер %s): нет embedding", faceID, clusterID)
+			}
+
+			if problem != "" {
+				check.inconsistent++
+				warnings = append(warnings, problem)
+				continue
+			}
+
+			seen[faceID] = clusterID
+			valid = append(valid, faceID)
+		}
+		check.clusters[clusterID] = valid
+	}
+
+	if len(warnings) > maxTaskWarnings {
+		rest := len(warnings) - maxTaskWarnings
+		warnings = append(warnings[:maxTaskWarnings], fmt.Sprintf("... и еще %d", rest))
 	}
+	check.warnings = warnings
 
-	c.JSON(http.StatusOK, persons)
+	return check
 }
 
-// HandleGetPerson возвращает конкретного человека со всеми фото (с кэшем)
-func (h *Handler) HandleGetPerson(c *gin.Context) {
-	idStr := c.Param("id")
-	id, err := strconv.Atoi(idStr)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Неверный ID",
-		})
+// maxInconsistentRatio - допустимая доля несогласованных лиц (DETECTION_MAX_INCONSISTENT)
+func (h *Handler) maxInconsistentRatio() float64 {
+	if h.cfg == nil {
+		return models.DefaultMaxInconsistentRatio
+	}
+	return h.cfg.Detection.MaxInconsistentRatio
+}
+
+// saveTaskWarnings сохраняет предупреждения задачи; ошибка не влияет на статус задачи
+func (h *Handler) saveTaskWarnings(taskID string, warnings []string) {
+	if len(warnings) == 0 {
 		return
 	}
+	if err := h.repo.UpdateTaskWarnings(taskID, warnings); err != nil {
+		log.Printf("⚠️  Задача %s: не удалось сохранить предупреждения: %v", taskID, err)
+	}
+}
 
-	// Пробуем из кэша
-	if h.cache != nil {
-		if person, err := h.cache.GetPerson(id); err == nil && person != nil {
-			c.JSON(http.StatusOK, person)
-			return
-		}
+// imageDimension возвращает размер оригинала для записи в БД
+// Старый Python сервер размер не присылает (0) - тогда сохраняем NULL
+func imageDimension(size int) *int {
+	if size <= 0 {
+		return nil
 	}
+	return &size
+}
 
-	// Из БД
-	person, err := h.repo.GetPersonByID(id)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: "Человек не найден",
+// maxPersonFaces возвращает лимит лиц одного человека (0 - без ограничения)
+func (h *Handler) maxPersonFaces() int {
+	if h.cfg == nil {
+		return 0
+	}
+	return h.cfg.Persons.MaxFaces
+}
+
+// capPersonFaces ограничивает лица кластера лимитом PERSON_MAX_FACES с учетом уже
+// сохраненных лиц человека. Возвращает лица, которые поместились, и число лишних
+// Если лица человека посчитать не удалось, сохраняются все лица кластера
+func (h *Handler) capPersonFaces(personID int, created bool, faceIDs []string, metadata map[string]models.FaceMetadata) ([]string, int) {
+	limit := h.maxPersonFaces()
+	if limit <= 0 {
+		return faceIDs, 0
+	}
+
+	existing := 0
+	if !created {
+		count, err := h.repo.CountPersonFaces(personID)
+		if err != nil {
+			log.Printf("⚠️  Ошибка подсчета лиц человека %d, лимит не проверяется: %v", personID, err)
+			return faceIDs, 0
+		}
+		existing = count
+	}
+
+	kept := capFaces(faceIDs, metadata, limit-existing)
+	return kept, len(faceIDs) - len(kept)
+}
+
+// capFaces оставляет не больше room самых уверенных лиц в исходном порядке
+func capFaces(faceIDs []string, metadata map[string]models.FaceMetadata, room int) []string {
+	if room >= len(faceIDs) {
+		return faceIDs
+	}
+	if room <= 0 {
+		return nil
+	}
+
+	ranked := append([]string(nil), faceIDs...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return metadata[ranked[i]].Confidence > metadata[ranked[j]].Confidence
+	})
+	keep := make(map[string]bool, room)
+	for _, faceID := range ranked[:room] {
+		keep[faceID] = true
+	}
+
+	kept := make([]string, 0, room)
+	for _, faceID := range faceIDs {
+		if keep[faceID] {
+			kept = append(kept, faceID)
+		}
+	}
+	return kept
+}
+
+// filterByConfidence отделяет лица с уверенностью детекции ниже minConfidence
+// Лица без метаданных остаются - они пропускаются позже с предупреждением
+func filterByConfidence(faceIDs []string, metadata map[string]models.FaceMetadata, minConfidence float64) ([]string, int) {
+	if minConfidence <= 0 {
+		return faceIDs, 0
+	}
+
+	kept := make([]string, 0, len(faceIDs))
+	rejected := 0
+	for _, faceID := range faceIDs {
+		if face, ok := metadata[faceID]; ok && face.Confidence < minConfidence {
+			rejected++
+			continue
+		}
+		kept = append(kept, faceID)
+	}
+	return kept, rejected
+}
+
+// checkImages проверяет файлы задачи по заголовку: файл не пустой, читается как
+// изображение и не больше MAX_IMAGE_MEGAPIXELS. Возвращает ошибки по именам файлов,
+// которые нельзя отправлять в Python
+func (h *Handler) checkImages(taskID string, imagePaths []string) map[string]string {
+	fileErrors := make(map[string]string)
+	for _, imagePath := range imagePaths {
+		err := h.storage.ValidateImage(imagePath)
+		if err == nil {
+			err = h.storage.CheckDimensions(imagePath)
+		}
+		if err != nil {
+			log.Printf("⚠️  Задача %s: %v", taskID, err)
+			fileErrors[filepath.Base(imagePath)] = err.Error()
+		}
+	}
+	return fileErrors
+}
+
+// allFailed проверяет, что у каждого файла есть ошибка в fileErrors
+func allFailed(imagePaths []string, fileErrors map[string]string) bool {
+	for _, imagePath := range imagePaths {
+		if _, failed := fileErrors[filepath.Base(imagePath)]; !failed {
+			return false
+		}
+	}
+	return true
+}
+
+// convertImages конвертирует HEIC/HEIF файлы задачи в JPEG (если включено CONVERT_HEIF)
+// Файлы с ошибкой в fileErrors не конвертируются. Возвращает ключи файлов после
+// конвертации и fileErrors с ошибками файлов, которые конвертировать не удалось
+// (ключи таких файлов не меняются)
+func (h *Handler) convertImages(taskID string, imagePaths []string, fileErrors map[string]string) ([]string, map[string]string) {
+	if h.cfg == nil || !h.cfg.Storage.ConvertHEIF {
+		return imagePaths, fileErrors
+	}
+
+	if fileErrors == nil {
+		fileErrors = make(map[string]string)
+	}
+	converted := make([]string, 0, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		if _, failed := fileErrors[filepath.Base(imagePath)]; failed {
+			converted = append(converted, imagePath)
+			continue
+		}
+
+		key, ok, err := h.storage.ConvertHEIF(imagePath)
+		if err != nil {
+			log.Printf("⚠️  Задача %s: %v", taskID, err)
+			fileErrors[filepath.Base(imagePath)] = err.Error()
+			converted = append(converted, imagePath)
+			continue
+		}
+
+		if ok {
+			log.Printf("🔄 Задача %s: %s сконвертирован в %s", taskID, imagePath, key)
+		}
+		converted = append(converted, key)
+	}
+
+	return converted, fileErrors
+}
+
+// captureTimes читает время съемки (EXIF DateTimeOriginal) каждого фото задачи
+// Фото без EXIF в результат не попадают
+func (h *Handler) captureTimes(imagePaths []string) map[string]*time.Time {
+	times := make(map[string]*time.Time, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		if capturedAt := h.storage.CaptureTime(imagePath); capturedAt != nil {
+			times[imagePath] = capturedAt
+		}
+	}
+	return times
+}
+
+// faceQualities оценивает резкость лиц кластеров (кроме шума) по их bbox на оригинале
+// Каждое фото декодируется один раз; лица фото, которое не удалось прочитать,
+// остаются без оценки
+func (h *Handler) faceQualities(taskID string, clusters map[string][]string, metadata map[string]models.FaceMetadata) map[string]*float64 {
+	byImage := make(map[string][]string)
+	for clusterID, faceIDs := range clusters {
+		if clusterID == noiseCluster {
+			continue
+		}
+		for _, faceID := range faceIDs {
+			if face, ok := metadata[faceID]; ok && len(face.Bbox) == 4 && face.OriginalImage != "" {
+				key := h.fileKey(taskID, face.OriginalImage)
+				byImage[key] = append(byImage[key], faceID)
+			}
+		}
+	}
+
+	qualities := make(map[string]*float64)
+	for key, faceIDs := range byImage {
+		rects := make([]image.Rectangle, len(faceIDs))
+		for i, faceID := range faceIDs {
+			bbox := metadata[faceID].Bbox
+			rects[i] = image.Rect(bbox[0], bbox[1], bbox[2], bbox[3])
+		}
+
+		values, err := h.storage.FaceQualities(key, rects)
+		if err != nil {
+			log.Printf("⚠️  Резкость лиц %s не оценена: %v", key, err)
+			continue
+		}
+		for i, faceID := range faceIDs {
+			quality := values[i]
+			qualities[faceID] = &quality
+		}
+	}
+	return qualities
+}
+
+// taskFileResults считает лица по каждому загруженному файлу
+// Лица относятся к файлам по OriginalImage из метаданных Python; файлы без лиц
+// попадают в результат с faces_count = 0. errorMsg (если задан) ставится всем файлам,
+// кроме тех, у которых есть своя ошибка в fileErrors (ключ - имя файла)
+func taskFileResults(imagePaths []string, metadata map[string]models.FaceMetadata, errorMsg string, fileErrors map[string]string) []models.TaskFile {
+	counts := make(map[string]int, len(imagePaths))
+	for _, face := range metadata {
+		counts[filepath.Base(face.OriginalImage)]++
+	}
+
+	files := make([]models.TaskFile, 0, len(imagePaths))
+	seen := make(map[string]bool, len(imagePaths))
+	for _, imagePath := range imagePaths {
+		name := filepath.Base(imagePath)
+		if seen[name] {
+			continue // Файлы с одинаковым именем в хранилище - это один файл
+		}
+		seen[name] = true
+
+		fileError := errorMsg
+		if err, ok := fileErrors[name]; ok {
+			fileError = err
+		}
+
+		files = append(files, models.TaskFile{
+			FileName:   name,
+			FacesCount: counts[name],
+			Error:      fileError,
 		})
+	}
+	return files
+}
+
+// saveTaskFiles сохраняет результаты по файлам; ошибка не влияет на статус задачи
+func (h *Handler) saveTaskFiles(taskID string, files []models.TaskFile) {
+	if err := h.repo.SaveTaskFiles(taskID, files); err != nil {
+		log.Printf("⚠️  Задача %s: не удалось сохранить результаты по файлам: %v", taskID, err)
+	}
+}
+
+// enqueue ставит обработку задачи в очередь воркеров
+// Задача занята (taskGuard), пока обработка не завершится
+func (h *Handler) enqueue(taskID string, job queue.Job) error {
+	lock, ok := h.tasks.acquire(taskID)
+	if !ok {
+		return repository.ErrTaskProcessing
+	}
+	defer lock.Release() // Ничего не делает, если обработка поставлена в очередь
+
+	slot, err := h.reserveSlot()
+	if err != nil {
+		return err
+	}
+	h.submit(slot, lock.wrap(job))
+	return nil
+}
+
+// reserveSlot занимает место в очереди обработки заранее
+// Без очереди (например, в тестах) возвращает nil - submit запустит обработку сразу
+func (h *Handler) reserveSlot() (*queue.Slot, error) {
+	if h.queue == nil {
+		return nil, nil
+	}
+	return h.queue.Reserve()
+}
+
+// submit ставит обработку на зарезервированное место
+// Без очереди обработка запускается в отдельной горутине
+func (h *Handler) submit(slot *queue.Slot, job queue.Job) {
+	if slot == nil {
+		go job()
+		return
+	}
+	slot.Submit(job)
+}
+
+// reportProgress сохраняет прогресс задачи в БД и рассылает его по WebSocket
+// Ошибка записи не прерывает обработку - прогресс носит справочный характер
+func (h *Handler) reportProgress(taskID string, percent int, stage string) {
+	if err := h.repo.UpdateTaskProgress(taskID, percent, stage); err != nil {
+		log.Printf("⚠️  Задача %s: не удалось сохранить прогресс: %v", taskID, err)
+	}
+
+	if h.cache != nil {
+		h.cache.InvalidateTask(h.context(), taskID)
+	}
+
+	h.wsManager.BroadcastTaskProgress(taskID, percent, 100, stage)
+}
+
+// validateCallbackURL проверяет callback_url из формы загрузки
+func (h *Handler) validateCallbackURL(callbackURL string) error {
+	if h.notifier == nil {
+		return webhook.ErrCallbacksDisabled
+	}
+	return h.notifier.ValidateURL(callbackURL)
+}
+
+// notifyCallback отправляет уведомление о завершении задачи, если задан callback_url
+// Ошибки доставки логируются внутри Notifier и не влияют на статус задачи
+func (h *Handler) notifyCallback(callbackURL string, payload models.TaskWebhookPayload) {
+	if callbackURL == "" || h.notifier == nil {
+		return
+	}
+	h.notifier.Notify(callbackURL, payload)
+}
+
+// fileKey переводит путь файла от Python в ключ хранилища
+// Python читает оригиналы из папки задачи, поэтому ключ однозначно
+// определяется taskID и именем файла
+func (h *Handler) fileKey(taskID, pythonPath string) string {
+	if pythonPath == "" {
+		return ""
+	}
+	return h.storage.GetUploadPath(taskID, filepath.Base(pythonPath))
+}
+
+// resultKey переводит путь аннотированного фото от Python в ключ хранилища
+// Python пишет их в RESULTS_DIR/task_id; фото, которого там нет (Python старой
+// версии пишет в папку загрузки), ищется среди файлов задачи
+func (h *Handler) resultKey(taskID, pythonPath string) string {
+	if pythonPath == "" {
+		return ""
+	}
+	key := h.storage.GetResultPath(taskID, filepath.Base(pythonPath))
+	if uploadKey := h.fileKey(taskID, pythonPath); !h.storage.FileExists(key) && h.storage.FileExists(uploadKey) {
+		return uploadKey
+	}
+	return key
+}
+
+// generateThumbnail создает миниатюру аннотированного фото (или оригинала)
+// Ошибка генерации не мешает сохранить лицо - возвращается пустой ключ
+func (h *Handler) generateThumbnail(face *models.Face) string {
+	if h.cfg == nil || !h.cfg.Thumbnails.Enabled {
+		return ""
+	}
+
+	srcKey := face.AnnotatedImage
+	if srcKey == "" {
+		srcKey = face.OriginalImage
+	}
+
+	thumbKey, err := h.storage.GenerateThumbnail(srcKey, h.cfg.Thumbnails.Size)
+	if err != nil {
+		log.Printf("⚠️  Ошибка создания миниатюры для %s: %v", srcKey, err)
+		return ""
+	}
+
+	return thumbKey
+}
+
+// ============ FILES ============
+
+// HandleUploadedFile перенаправляет на адрес файла в хранилище
+// Используется вместо статического /uploads, когда файлы лежат не на диске (S3)
+func (h *Handler) HandleUploadedFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if key == "" {
+		respondError(c, apierror.NotFound("Файл не найден"))
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.storage.URL(key))
+}
+
+// ============ TASKS ============
+
+// HandleTaskStatus возвращает статус задачи (с кэшем)
+func (h *Handler) HandleTaskStatus(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	// Пробуем из кэша
+	if h.cache != nil {
+		if task, err := h.cache.GetTask(c.Request.Context(), taskID); err == nil && task != nil {
+			task.SetDuration()
+			c.JSON(http.StatusOK, task)
+			return
+		}
+	}
+
+	// Из БД; если она недоступна - резервная копия из кэша
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		if stale := h.staleTask(taskID, err); stale != nil {
+			markStale(c)
+			stale.SetDuration()
+			c.JSON(http.StatusOK, stale)
+			return
+		}
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	// Сохраняем в кэш
+	if h.cache != nil {
+		h.cache.SetTask(h.context(), task)
+	}
+
+	task.SetDuration()
+	c.JSON(http.StatusOK, task)
+}
+
+// HandleTaskFiles возвращает результат обработки каждого загруженного файла задачи:
+// сколько лиц найдено и ошибку, если она была
+func (h *Handler) HandleTaskFiles(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	response := models.TaskFilesResponse{
+		TaskID: task.ID,
+		Status: task.Status,
+		Files:  []models.TaskFile{},
+	}
+
+	// В очереди и во время обработки (в том числе повторной) результатов еще нет
+	if !task.InProgress() {
+		files, err := h.repo.GetTaskFiles(taskID)
+		if err != nil {
+			respondError(c, err)
+			return
+		}
+		response.Files = files
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleTaskPersons возвращает людей задачи: созданных ею (created = true) и
+// дополненных - созданных раньше другой задачей. Во время обработки список неполный
+func (h *Handler) HandleTaskPersons(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	persons, err := h.repo.GetPersonsByTask(taskID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TaskPersonsResponse{
+		TaskID:  task.ID,
+		Status:  task.Status,
+		Persons: persons,
+	})
+}
+
+// HandleTaskImages возвращает загруженные изображения задачи (имя, размер, адрес)
+// Доступен и во время обработки - UI показывает превью, пока лица еще ищутся
+func (h *Handler) HandleTaskImages(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	files, err := h.storage.ListTaskFiles(taskID)
+	if err != nil {
+		respondError(c, apierror.Internal("Ошибка чтения файлов задачи", err))
+		return
+	}
+
+	// Пустой список - папки задачи нет (удалена очисткой или файлы еще скачиваются по URL)
+	if len(files) == 0 {
+		respondError(c, apierror.NotFound("Файлы задачи не найдены"))
+		return
+	}
+
+	images := make([]models.TaskImage, len(files))
+	for i, file := range files {
+		images[i] = models.TaskImage{
+			FileName: file.Name,
+			Size:     file.Size,
+			URL:      file.URL,
+		}
+	}
+
+	c.JSON(http.StatusOK, models.TaskImagesResponse{
+		TaskID: task.ID,
+		Status: task.Status,
+		Images: images,
+	})
+}
+
+// TaskProgressSnapshot возвращает сохраненный прогресс задачи для WebSocket клиента,
+// подключившегося посреди обработки (см. websocket.Handler.SetSnapshot)
+func (h *Handler) TaskProgressSnapshot(taskID string) (websocket.Message, bool) {
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		return websocket.Message{}, false
+	}
+
+	return websocket.NewTaskProgressMessage(task.ID, task.Progress, 100, task.Stage), true
+}
+
+// HandleReprocessTask повторно обрабатывает уже загруженные файлы задачи
+// Необязательные min_size/det_thresh (форма или query) заменяют параметры задачи
+// Лица задачи и оставшиеся без лиц люди удаляются перед новой обработкой
+func (h *Handler) HandleReprocessTask(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	if task.InProgress() {
+		respondError(c, apierror.Conflict(repository.ErrTaskProcessing.Error()))
+		return
+	}
+
+	// Статус уже не processing, но прежняя обработка (например, просроченная) еще может
+	// работать; параллельный reprocess той же задачи тоже получает 409
+	lock, ok := h.tasks.acquire(taskID)
+	if !ok {
+		respondError(c, apierror.Conflict(repository.ErrTaskProcessing.Error()))
+		return
+	}
+	defer lock.Release() // Ничего не делает, если обработка поставлена на место
+
+	// Порог памяти формы - router.MaxMultipartMemory (MULTIPART_MEMORY_MB)
+	_, err = c.MultipartForm()
+	if middleware.IsBodyTooLarge(err) {
+		respondError(c, apierror.TooLarge(middleware.ErrBodyTooLarge))
+		return
+	}
+	if err != nil && err != http.ErrNotMultipart {
+		respondError(c, apierror.Validation("Ошибка чтения формы"))
+		return
+	}
+
+	params, err := parseDetectionParamsWithBase(c.Request.Form, models.DetectionParams{
+		MinSize:       task.MinSize,
+		DetThresh:     task.DetThresh,
+		MinConfidence: task.MinConfidence,
+	})
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	// Файлы проверяем до сброса задачи - без них обработка невозможна
+	files, err := h.storage.ListTaskFiles(taskID)
+	if err != nil {
+		respondError(c, apierror.Internal("Ошибка чтения файлов задачи", err))
+		return
+	}
+
+	if len(files) == 0 {
+		respondError(c, apierror.Conflict("Файлы задачи не найдены (возможно, удалены очисткой)"))
+		return
+	}
+
+	// Место в очереди занимаем до сброса: при переполненной очереди
+	// результаты задачи должны остаться нетронутыми
+	slot, err := h.reserveSlot()
+	if err != nil {
+		respondError(c, apierror.Unavailable(err.Error()))
+		return
+	}
+	defer slot.Release() // Ничего не делает, если обработка поставлена на место
+
+	persons, faces, err := h.repo.ResetTaskForReprocess(taskID, params)
+	if err == repository.ErrTaskProcessing {
+		respondError(c, apierror.Conflict(err.Error()))
+		return
+	}
+
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	h.discardTaskResults(task, persons, faces)
+
+	log.Printf("🔁 Задача %s: повторная обработка (удалено %d лиц, %d людей)", taskID, len(faces), len(persons))
+
+	keys := make([]string, len(files))
+	for i, file := range files {
+		keys[i] = file.Key
+	}
+
+	h.submit(slot, lock.wrap(func() { h.processImages(taskID, keys, params, task.CallbackURL) }))
+
+	c.JSON(http.StatusOK, models.UploadResponse{
+		TaskID:  taskID,
+		Message: fmt.Sprintf("Повторная обработка %d файлов", len(files)),
+	})
+}
+
+// discardTaskResults убирает следы результатов задачи, сброшенной для повторной обработки:
+// производные файлы удаленных лиц, кэш и удаленных людей у клиентов WebSocket
+// Оригиналы нужны для повторной обработки - удаляются только производные файлы
+func (h *Handler) discardTaskResults(task *models.Task, persons []models.Person, faces []models.Face) {
+	var derived []string
+	for _, face := range faces {
+		for _, key := range []string{face.AnnotatedImage, face.ThumbnailImage} {
+			if key != "" {
+				derived = append(derived, key)
+			}
+		}
+	}
+	if err := h.storage.DeleteFiles(derived); err != nil {
+		log.Printf("⚠️  Ошибка удаления файлов задачи %s: %v", task.ID, err)
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidateTask(h.context(), task.ID)
+		if task.ContentHash != "" {
+			h.cache.InvalidateTaskIDByHash(h.context(), task.ContentHash)
+		}
+		for _, face := range faces {
+			h.cache.InvalidatePerson(h.context(), face.PersonID)
+		}
+		h.cache.InvalidateStats(h.context())
+	}
+	h.recentTasksChanged()
+	h.statsChanged()
+
+	for _, person := range persons {
+		h.broadcastPersonEvent(websocket.MessageTypePersonDeleted, person.ID, person.Name)
+	}
+}
+
+// HandleDeleteTask удаляет задачу вместе с ее лицами, файлами и людьми,
+// которые встречались только в этой задаче
+func (h *Handler) HandleDeleteTask(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	// Пока над задачей идет операция (в том числе обработка, уже просроченная
+	// по таймауту), удалять ее нельзя
+	lock, ok := h.tasks.acquire(taskID)
+	if !ok {
+		respondError(c, apierror.Conflict(repository.ErrTaskProcessing.Error()))
+		return
+	}
+	defer lock.Release()
+
+	task, persons, faces, err := h.repo.DeleteTask(taskID)
+	if err == sql.ErrNoRows {
+		respondError(c, apierror.NotFound("Задача не найдена"))
+		return
+	}
+
+	if err == repository.ErrTaskProcessing {
+		respondError(c, apierror.Conflict(err.Error()))
+		return
+	}
+
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Файлы удаляем после коммита: строки в БД уже удалены,
+	// поэтому ошибка очистки только логируется
+	filesDeleted := true
+	// Миниатюры лежат вне папки задачи - удаляем их по ключам
+	if err := h.storage.DeleteFiles(faceFiles(faces)); err != nil {
+		log.Printf("⚠️  Ошибка удаления файлов лиц задачи %s: %v", taskID, err)
+		filesDeleted = false
+	}
+	if err := h.storage.DeleteTaskDirectory(taskID); err != nil {
+		log.Printf("⚠️  Ошибка удаления папки задачи %s: %v", taskID, err)
+		filesDeleted = false
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidateTask(h.context(), taskID)
+		if task.ContentHash != "" {
+			h.cache.InvalidateTaskIDByHash(h.context(), task.ContentHash)
+		}
+		for _, face := range faces {
+			h.cache.InvalidatePerson(h.context(), face.PersonID)
+		}
+		h.cache.InvalidateStats(h.context())
+	}
+	h.recentTasksChanged()
+	h.statsChanged()
+
+	for _, person := range persons {
+		h.broadcastPersonEvent(websocket.MessageTypePersonDeleted, person.ID, person.Name)
+	}
+
+	log.Printf("🗑️  Задача %s удалена (%d лиц, %d людей)", taskID, len(faces), len(persons))
+
+	c.JSON(http.StatusOK, models.DeleteTaskResponse{
+		TaskID:         taskID,
+		DeletedFaces:   len(faces),
+		DeletedPersons: len(persons),
+		FilesDeleted:   filesDeleted,
+	})
+}
+
+// ============ PERSONS ============
+
+// HandleGetPersons возвращает всех людей
+// ?tag=staff - только людей с указанной меткой
+// ?unlabeled=true - только людей, которых еще не переименовывали (имя кластера)
+// С ?limit=, ?cursor= или ?offset= возвращает страницу (см. handleGetPersonsPage)
+func (h *Handler) HandleGetPersons(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	fields := c.DefaultQuery("fields", models.PersonFieldsFull)
+	if fields != models.PersonFieldsFull && fields != models.PersonFieldsCompact {
+		respondError(c, apierror.Validation("fields должен быть full или compact"))
+		return
+	}
+
+	tag := c.Query("tag")
+	unlabeled := c.Query("unlabeled") == "true"
+	if c.Query("limit") != "" || c.Query("cursor") != "" || c.Query("offset") != "" {
+		if tag != "" || unlabeled {
+			respondError(c, apierror.Validation("tag и unlabeled нельзя сочетать с limit, cursor и offset"))
+			return
+		}
+		h.handleGetPersonsPage(c, fields)
+		return
+	}
+	if tag != "" && unlabeled {
+		respondError(c, apierror.Validation("tag нельзя сочетать с unlabeled"))
+		return
+	}
+
+	var persons []models.PersonWithFaces
+	var err error
+	switch {
+	case tag != "":
+		persons, err = h.repo.GetPersonsByTag(tag)
+	case unlabeled:
+		// Люди с автоматическим именем кластера - их еще надо подписать
+		persons, err = h.repo.GetUnlabeledPersons()
+	default:
+		persons, err = h.repo.GetAllPersons()
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, h.personList(persons, fields))
+}
+
+// handleGetPersonsPage возвращает страницу людей (новые первыми)
+// ?limit=50&cursor=<next_cursor> - страница после курсора: люди, созданные во время
+// обхода, не сдвигают страницы и не дают повторов. next_cursor пуст на последней странице
+// ?offset= - устаревший вариант, при вставках страницы сдвигаются
+func (h *Handler) handleGetPersonsPage(c *gin.Context, fields string) {
+	limit := models.DefaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > models.MaxSearchLimit {
+			respondError(c, apierror.Validation(fmt.Sprintf("limit должен быть числом от 1 до %d", models.MaxSearchLimit)))
+			return
+		}
+	}
+
+	var persons []models.PersonWithFaces
+	var err error
+	switch token, v := c.Query("cursor"), c.Query("offset"); {
+	case token != "" && v != "":
+		respondError(c, apierror.Validation("cursor нельзя сочетать с offset"))
+		return
+	case v != "":
+		offset, convErr := strconv.Atoi(v)
+		if convErr != nil || offset < 0 {
+			respondError(c, apierror.Validation("offset должен быть неотрицательным числом"))
+			return
+		}
+		persons, err = h.repo.GetPersonsOffset(offset, limit)
+	default:
+		var cursor *models.PersonCursor
+		if token != "" {
+			if cursor, err = models.ParsePersonCursor(token); err != nil {
+				respondError(c, apierror.Validation(err.Error()))
+				return
+			}
+		}
+		persons, err = h.repo.GetPersonsAfter(cursor, limit)
+	}
+	if err != nil {
+		respondError(c, err)
 		return
 	}
 
+	page := models.PersonPage{Limit: limit}
+	// Полная страница - возможно, есть следующая; курсор - последний показанный человек
+	if len(persons) == limit {
+		last := persons[len(persons)-1]
+		page.NextCursor = models.PersonCursor{CreatedAt: last.CreatedAt, ID: last.ID}.Encode()
+	}
+	page.Persons = h.personList(persons, fields)
+
+	c.JSON(http.StatusOK, page)
+}
+
+// personList готовит список людей к ответу в формате fields
+func (h *Handler) personList(persons []models.PersonWithFaces, fields string) interface{} {
+	// Компактный формат для больших списков: без дат, обложки и пустого faces
+	if fields == models.PersonFieldsCompact {
+		items := make([]models.PersonListItem, len(persons))
+		for i, person := range persons {
+			items[i] = models.PersonListItem{ID: person.ID, Name: person.Name, Count: person.Count}
+		}
+		return items
+	}
+
+	if persons == nil {
+		persons = []models.PersonWithFaces{}
+	}
+	for i := range persons {
+		h.setCoverURL(persons[i].Cover)
+	}
+	return persons
+}
+
+// HandleGetPerson возвращает конкретного человека со всеми фото (с кэшем)
+// ?min_quality= оставляет только лица с резкостью не ниже порога (count - по-прежнему все лица)
+// Ответ содержит ETag и Last-Modified; при совпадении валидаторов - 304
+func (h *Handler) HandleGetPerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	minQuality := 0.0
+	if v := c.Query("min_quality"); v != "" {
+		minQuality, err = parseFiniteFloat(v)
+		if err != nil || minQuality < 0 || minQuality > 1 {
+			respondError(c, apierror.Validation("min_quality должен быть числом в диапазоне [0, 1]"))
+			return
+		}
+	}
+
+	// Пробуем из кэша
+	if h.cache != nil {
+		if person, err := h.cache.GetPerson(c.Request.Context(), id); err == nil && person != nil {
+			respondConditional(c, filterFacesByQuality(person, minQuality), personLastModified(person))
+			return
+		}
+	}
+
+	// Из БД; если она недоступна - резервная копия из кэша
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		if stale := h.stalePerson(id, err); stale != nil {
+			markStale(c)
+			respondConditional(c, filterFacesByQuality(stale, minQuality), personLastModified(stale))
+			return
+		}
+		respondError(c, repoError(err, "Человек не найден"))
 		return
 	}
 
+	h.setCoverURL(person.Cover)
+
 	// Сохраняем в кэш
 	if h.cache != nil {
-		h.cache.SetPerson(person)
+		h.cache.SetPerson(h.context(), person)
 	}
 
-	c.JSON(http.StatusOK, person)
+	respondConditional(c, filterFacesByQuality(person, minQuality), personLastModified(person))
+}
+
+// filterFacesByQuality возвращает копию человека только с лицами резкостью не ниже
+// minQuality; лица без оценки резкости отбрасываются. 0 - человек как есть
+func filterFacesByQuality(person *models.PersonWithFaces, minQuality float64) *models.PersonWithFaces {
+	if minQuality == 0 {
+		return person
+	}
+
+	filtered := *person
+	filtered.Faces = []models.Face{}
+	for _, face := range person.Faces {
+		if face.Quality != nil && *face.Quality >= minQuality {
+			filtered.Faces = append(filtered.Faces, face)
+		}
+	}
+	return &filtered
 }
 
 // HandleUpdatePerson обновляет имя человека
 func (h *Handler) HandleUpdatePerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Неверный ID",
-		})
+		respondError(c, apierror.Validation("Неверный ID"))
 		return
 	}
 
 	var req models.UpdatePersonRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Имя обязательно",
-		})
+		respondError(c, apierror.Validation("Имя обязательно"))
 		return
 	}
 
-	err = h.repo.UpdatePersonName(id, req.Name)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: "Человек не найден",
-		})
+	req.Name, err = h.normalizePersonName(req.Name)
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
 		return
 	}
 
+	err = h.repo.UpdatePersonName(id, req.Name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
+		respondError(c, repoError(err, "Человек не найден"))
 		return
 	}
 
 	// Инвалидируем кэш
 	if h.cache != nil {
-		h.cache.InvalidatePerson(id)
-		h.cache.InvalidateStats()
+		h.cache.InvalidatePerson(h.context(), id)
+		h.cache.InvalidateStats(h.context())
 	}
+	h.statsChanged()
+
+	h.broadcastPersonEvent(websocket.MessageTypePersonUpdated, id, req.Name)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Имя обновлено",
@@ -378,71 +1887,374 @@ func (h *Handler) HandleUpdatePerson(c *gin.Context) {
 }
 
 // HandleDeletePerson удаляет человека
+// По умолчанию - soft-delete (можно восстановить через /restore, файлы сохраняются)
+// ?hard=true удаляет навсегда вместе с лицами и файлами
 func (h *Handler) HandleDeletePerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Неверный ID",
-		})
+		respondError(c, apierror.Validation("Неверный ID"))
 		return
 	}
 
-	// Удаляем из БД и получаем список файлов для удаления
-	faces, err := h.repo.DeletePerson(id)
-	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, models.ErrorResponse{
-			Error: "Человек не найден",
-		})
+	hard := c.Query("hard") == "true"
+
+	var person *models.Person
+	var files []string
+	if hard {
+		// Удаляем из БД и получаем файлы, на которые не ссылаются лица других людей
+		person, files, err = h.repo.HardDeletePerson(id)
+	} else {
+		person, err = h.repo.DeletePerson(id)
+	}
+
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
 		return
 	}
 
+	// Удаляем файлы (original, annotated и миниатюры)
+	if err := h.storage.DeleteFiles(files); err != nil {
+		// Записи в БД уже удалены - сообщаем об ошибке в лог, клиенту отвечаем успехом
+		log.Printf("⚠️  Ошибка удаления файлов человека %d: %v", id, err)
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
+		h.cache.InvalidateStats(h.context())
+	}
+	h.statsChanged()
+
+	// Повторное hard-удаление уже скрытого человека клиентам не видно
+	if !hard || !person.DeletedAt.Valid {
+		h.broadcastPersonEvent(websocket.MessageTypePersonDeleted, person.ID, person.Name)
+	}
+
+	message := "Человек удален"
+	if hard {
+		message = "Человек удален навсегда"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+	})
+}
+
+// HandleRestorePerson восстанавливает человека после soft-delete
+func (h *Handler) HandleRestorePerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
+		respondError(c, apierror.Validation("Неверный ID"))
 		return
 	}
 
-	// Удаляем файлы (original и annotated)
-	var paths []string
-	for _, face := range faces {
-		paths = append(paths, face.OriginalImage)
-		if face.AnnotatedImage != "" {
-			paths = append(paths, face.AnnotatedImage)
+	person, err := h.repo.RestorePerson(id)
+	if err != nil {
+		respondError(c, repoError(err, "Удаленный человек не найден"))
+		return
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
+		h.cache.InvalidateStats(h.context())
+	}
+	h.statsChanged()
+
+	h.broadcastPersonEvent(websocket.MessageTypePersonRestored, person.ID, person.Name)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Человек восстановлен",
+		"name":    person.Name,
+	})
+}
+
+// HandleBulkDeletePersons удаляет нескольких людей одним запросом
+// Как и HandleDeletePerson, по умолчанию - soft-delete (восстановление через /restore);
+// ?hard=true удаляет навсегда вместе с лицами и файлами
+func (h *Handler) HandleBulkDeletePersons(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	var req models.BulkDeleteRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.IDs) == 0 {
+		respondError(c, apierror.Validation("Список ids обязателен"))
+		return
+	}
+
+	// Убираем дубликаты, сохраняя порядок
+	ids := make([]int, 0, len(req.IDs))
+	seen := make(map[int]bool, len(req.IDs))
+	for _, id := range req.IDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	hard := c.Query("hard") == "true"
+
+	var deleted []models.Person
+	var files []string
+	var err error
+	if hard {
+		// Удаляются только файлы, на которые не ссылаются лица других людей
+		deleted, files, err = h.repo.HardDeletePersons(ids)
+	} else {
+		deleted, err = h.repo.DeletePersons(ids)
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	if err := h.storage.DeleteFiles(files); err != nil {
+		log.Printf("⚠️  Ошибка удаления файлов при массовом удалении: %v", err)
+	}
+
+	deletedSet := make(map[int]bool, len(deleted))
+	for _, person := range deleted {
+		deletedSet[person.ID] = true
+	}
+
+	notFound := []int{}
+	for _, id := range ids {
+		if !deletedSet[id] {
+			notFound = append(notFound, id)
 		}
 	}
-	h.storage.DeleteFiles(paths)
 
 	// Инвалидируем кэш
 	if h.cache != nil {
-		h.cache.InvalidatePerson(id)
-		h.cache.InvalidateStats()
+		for _, person := range deleted {
+			h.cache.InvalidatePerson(h.context(), person.ID)
+		}
+		h.cache.InvalidateStats(h.context())
+	}
+	h.statsChanged()
+
+	for _, person := range deleted {
+		// Повторное hard-удаление уже скрытого человека клиентам не видно
+		if !hard || !person.DeletedAt.Valid {
+			h.broadcastPersonEvent(websocket.MessageTypePersonDeleted, person.ID, person.Name)
+		}
+	}
+
+	c.JSON(http.StatusOK, models.BulkDeleteResponse{
+		Deleted:  len(deleted),
+		NotFound: notFound,
+	})
+}
+
+// HandleAddPersonTags добавляет человеку метки ({"tags": ["staff", "VIP"]})
+// Метки не зависят от регистра, повторы игнорируются
+func (h *Handler) HandleAddPersonTags(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	var req models.AddTagsRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Tags) == 0 {
+		respondError(c, apierror.Validation("Список tags обязателен"))
+		return
+	}
+
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	allTags, err := h.repo.AddPersonTags(id, tags)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
+	}
+
+	c.JSON(http.StatusOK, models.PersonTagsResponse{
+		PersonID: id,
+		Tags:     allTags,
+	})
+}
+
+// HandleRemovePersonTag снимает метку с человека
+func (h *Handler) HandleRemovePersonTag(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	idStr := c.Param("id")
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	err = h.repo.RemovePersonTag(id, c.Param("tag"))
+	if err != nil {
+		respondError(c, repoError(err, "Метка не найдена"))
+		return
+	}
+
+	if h.cache != nil {
+		h.cache.InvalidatePerson(h.context(), id)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Человек удален",
+		"message": "Метка удалена",
 	})
 }
 
+// normalizeTags приводит метки к нижнему регистру и убирает повторы, сохраняя порядок
+func normalizeTags(tags []string) ([]string, error) {
+	result := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = models.NormalizeTag(tag)
+		if tag == "" {
+			return nil, fmt.Errorf("Метка не может быть пустой")
+		}
+		if utf8.RuneCountInString(tag) > models.MaxTagLength {
+			return nil, fmt.Errorf("Метка длиннее %d символов: %s", models.MaxTagLength, tag)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	return result, nil
+}
+
+// normalizePersonName обрезает пробелы по краям имени и проверяет его:
+// имя не пустое, не длиннее PERSON_NAME_MAX_LENGTH и без управляющих символов
+// Используется везде, где имя человека задает пользователь
+func (h *Handler) normalizePersonName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", fmt.Errorf("Имя не может быть пустым")
+	}
+
+	maxLength := models.DefaultMaxPersonNameLength
+	if h.cfg != nil {
+		maxLength = h.cfg.Persons.MaxNameLength
+	}
+	if length := utf8.RuneCountInString(name); length > maxLength {
+		return "", fmt.Errorf("Имя длиннее %d символов (%d)", maxLength, length)
+	}
+
+	for _, r := range name {
+		if !unicode.IsGraphic(r) {
+			return "", fmt.Errorf("Имя содержит недопустимый символ %U", r)
+		}
+	}
+
+	return name, nil
+}
+
+// broadcastPersonEvent рассылает событие по человеку всем WebSocket клиентам
+func (h *Handler) broadcastPersonEvent(messageType websocket.MessageType, id int, name string) {
+	if h.wsManager == nil {
+		return
+	}
+	h.wsManager.BroadcastPersonEvent(messageType, id, name)
+}
+
+// statsChanged сообщает клиентам, что статистика изменилась
+// Пересчет и stats_update выполняет менеджер с задержкой, объединяя серию правок
+func (h *Handler) statsChanged() {
+	h.refreshStats()
+	if h.wsManager == nil {
+		return
+	}
+	h.wsManager.NotifyStatsChanged()
+}
+
+// faceFiles собирает ключи всех файлов лиц (original, annotated и миниатюры)
+func faceFiles(faces []models.Face) []string {
+	var paths []string
+	for _, face := range faces {
+		paths = append(paths, face.OriginalImage)
+		if face.AnnotatedImage != "" {
+			paths = append(paths, face.AnnotatedImage)
+		}
+		if face.ThumbnailImage != "" {
+			paths = append(paths, face.ThumbnailImage)
+		}
+	}
+	return paths
+}
+
 // ============ SEARCH ============
 
 // HandleSearch ищет людей по имени или ID
 func (h *Handler) HandleSearch(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
 	query := c.Query("q")
 
 	if query == "" {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{
-			Error: "Параметр q обязателен",
-		})
+		respondError(c, apierror.Validation("Параметр q обязателен"))
 		return
 	}
 
-	persons, err := h.repo.SearchPersons(query)
+	opts := models.SearchOptions{
+		Fuzzy:         c.Query("fuzzy") == "true",
+		MinSimilarity: models.DefaultMinSimilarity,
+	}
+
+	if v := c.Query("min_similarity"); v != "" {
+		minSimilarity, err := parseFiniteFloat(v)
+		if err != nil || minSimilarity <= 0 || minSimilarity > 1 {
+			respondError(c, apierror.Validation("min_similarity должен быть числом в диапазоне (0, 1]"))
+			return
+		}
+		opts.MinSimilarity = minSimilarity
+	}
+
+	// Страница и фильтр по числу фото: ?limit=20&offset=40&min_faces=3
+	opts.Limit = models.DefaultSearchLimit
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > models.MaxSearchLimit {
+			respondError(c, apierror.Validation(fmt.Sprintf("limit должен быть числом от 1 до %d", models.MaxSearchLimit)))
+			return
+		}
+		opts.Limit = limit
+	}
+
+	if v := c.Query("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			respondError(c, apierror.Validation("offset должен быть неотрицательным числом"))
+			return
+		}
+		opts.Offset = offset
+	}
+
+	if v := c.Query("min_faces"); v != "" {
+		minFaces, err := strconv.Atoi(v)
+		if err != nil || minFaces < 1 {
+			respondError(c, apierror.Validation("min_faces должен быть положительным числом"))
+			return
+		}
+		opts.MinFaces = minFaces
+	}
+
+	persons, total, err := h.repo.SearchPersons(query, opts)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -450,34 +2262,104 @@ func (h *Handler) HandleSearch(c *gin.Context) {
 		persons = []models.PersonWithFaces{}
 	}
 
-	c.JSON(http.StatusOK, persons)
+	c.JSON(http.StatusOK, models.SearchResponse{
+		Persons: persons,
+		Total:   total,
+		Limit:   opts.Limit,
+		Offset:  opts.Offset,
+	})
 }
 
 // ============ STATS ============
 
 // HandleGetStats возвращает общую статистику (с кэшем)
+// Ответ содержит ETag; при совпадении If-None-Match - 304
 func (h *Handler) HandleGetStats(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	stats, err := h.currentStats()
+	if err != nil {
+		// БД недоступна - отдаем последнюю сохраненную статистику, если она есть
+		if stats = h.staleStats(err); stats == nil {
+			respondError(c, err)
+			return
+		}
+		markStale(c)
+	}
+
+	respondConditional(c, stats, time.Time{})
+}
+
+// Stats возвращает текущую статистику для stats_update (источник для websocket.Manager)
+// При фоновом пересчете дожидается идущего пересчета: сообщение должно отражать изменения
+func (h *Handler) Stats() (interface{}, error) {
+	if h.stats == nil {
+		return h.currentStats()
+	}
+
+	stats, err := h.stats.current()
+	if err != nil {
+		return nil, err
+	}
+	h.setQueueStats(stats)
+	return stats, nil
+}
+
+// currentStats возвращает статистику вместе с состоянием очереди: при фоновом
+// пересчете - последнюю посчитанную (запрос не ждет БД), иначе из кэша или БД
+func (h *Handler) currentStats() (*models.Stats, error) {
+	if h.stats != nil {
+		stats, err := h.stats.latest()
+		if err != nil {
+			return nil, err
+		}
+		h.setQueueStats(stats)
+		return stats, nil
+	}
+
 	// Пробуем из кэша
 	if h.cache != nil {
-		if stats, err := h.cache.GetStats(); err == nil && stats != nil {
-			c.JSON(http.StatusOK, stats)
-			return
+		if stats, err := h.cache.GetStats(h.context()); err == nil && stats != nil {
+			h.setQueueStats(stats)
+			return stats, nil
 		}
 	}
 
 	// Из БД
 	stats, err := h.repo.GetStats()
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
-			Error: err.Error(),
-		})
-		return
+		return nil, err
 	}
 
 	// Сохраняем в кэш
 	if h.cache != nil {
-		h.cache.SetStats(stats)
+		h.cache.SetStats(h.context(), stats)
+	}
+
+	h.setQueueStats(stats)
+	return stats, nil
+}
+
+// setQueueStats добавляет в статистику текущее состояние очереди обработки
+// Очередь меняется постоянно, поэтому в кэш она не попадает
+func (h *Handler) setQueueStats(stats *models.Stats) {
+	if h.queue == nil {
+		return
 	}
+	stats.Queue = &models.QueueStats{
+		Depth:   h.queue.Depth(),
+		Active:  h.queue.Active(),
+		Workers: h.queue.Workers(),
+	}
+}
 
-	c.JSON(http.StatusOK, stats)
+// SystemStatus возвращает нагрузку очереди обработки для сообщения system_status
+func (h *Handler) SystemStatus() websocket.SystemStatus {
+	if h.queue == nil {
+		return websocket.SystemStatus{}
+	}
+	return websocket.SystemStatus{
+		ActiveTasks: h.queue.Active(),
+		QueueDepth:  h.queue.Depth(),
+	}
 }
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/middleware"
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleImport импортирует людей из ZIP: каждая папка архива - человек, имя папки -
+// его имя. Изображения проходят обычную обработку, но лица группируются по папкам,
+// а не кластеризацией. Пути архива проверяются до распаковки (zip-slip), размер
+// архива и число записей ограничены IMPORT_MAX_SIZE_MB и IMPORT_MAX_ENTRIES, размер
+// файла и всех файлов после распаковки - IMPORT_MAX_FILE_SIZE_MB и IMPORT_MAX_UNCOMPRESSED_MB
+func (h *Handler) HandleImport(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	limits := h.importLimits()
+
+	form, err := c.MultipartForm()
+	if middleware.IsBodyTooLarge(err) {
+		respondError(c, apierror.TooLarge(middleware.ErrBodyTooLarge))
+		return
+	}
+	if err != nil || len(form.File["archive"]) == 0 {
+		respondError(c, apierror.Validation("ZIP архив не загружен (поле archive)"))
+		return
+	}
+
+	archiveHeader := form.File["archive"][0]
+	if archiveHeader.Size > limits.MaxSize {
+		respondError(c, apierror.TooLarge(fmt.Sprintf("ZIP архив больше %d МБ", limits.MaxSize>>20)))
+		return
+	}
+
+	params, err := parseDetectionParamsWithBase(form.Value, h.defaultDetectionParams())
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	callbackURL := firstValue(form.Value, "callback_url")
+	if callbackURL != "" {
+		if err := h.validateCallbackURL(callbackURL); err != nil {
+			respondError(c, apierror.Validation(err.Error()))
+			return
+		}
+	}
+
+	file, err := archiveHeader.Open()
+	if err != nil {
+		respondError(c, apierror.Validation(fmt.Sprintf("Ошибка чтения архива: %v", err)))
+		return
+	}
+	defer file.Close()
+
+	archive, err := zip.NewReader(file, archiveHeader.Size)
+	if err != nil {
+		respondError(c, apierror.Validation("Некорректный ZIP архив"))
+		return
+	}
+
+	if len(archive.File) > limits.MaxEntries {
+		respondError(c, apierror.Validation(fmt.Sprintf("В архиве %d записей, допустимо не больше %d", len(archive.File), limits.MaxEntries)))
+		return
+	}
+
+	plan, err := h.planImport(archive.File, limits.MaxFileSize)
+	if err != nil {
+		respondError(c, apierror.Validation(err.Error()))
+		return
+	}
+
+	// Сжатие zip-бомбы - до тысяч раз: суммарный размер после распаковки проверяется
+	// по заголовкам до распаковки, а zip не дает записи распаковаться больше заявленного
+	if plan.uncompressed > uint64(limits.MaxUncompressedSize) {
+		respondError(c, apierror.TooLarge(fmt.Sprintf("Файлы архива после распаковки больше %d МБ", limits.MaxUncompressedSize>>20)))
+		return
+	}
+
+	// Файлы распаковываются по одному прямо в хранилище - в памяти не больше одного
+	// файла (IMPORT_MAX_FILE_SIZE_MB)
+	taskID := storage.NewTaskID()
+	var keys []string
+	labels := make(map[string]string)
+	names := originalNames{}
+	used := make(map[string]bool)
+	remaining := limits.MaxUncompressedSize
+	response := models.ImportResponse{TaskID: taskID, Skipped: plan.skipped}
+
+	for _, folder := range plan.folders {
+		result := folder.result
+		for _, entry := range folder.entries {
+			name := uniqueFileName(path.Base(entry.Name), used)
+			key, reason, err := h.importFile(taskID, entry, name, limits.MaxFileSize, &remaining)
+			if errors.Is(err, errImportTooLarge) {
+				h.storage.DeleteTaskDirectory(taskID)
+				respondError(c, apierror.TooLarge(fmt.Sprintf("Файлы архива после распаковки больше %d МБ", limits.MaxUncompressedSize>>20)))
+				return
+			}
+			if err != nil {
+				h.storage.DeleteTaskDirectory(taskID)
+				respondError(c, apierror.Internal("Ошибка сохранения файлов", err))
+				return
+			}
+			if reason != "" {
+				result.Skipped = append(result.Skipped, models.ImportSkippedFile{Path: entry.Name, Reason: reason})
+				continue
+			}
+
+			keys = append(keys, key)
+			labels[key] = result.Name
+			names.add(key, name)
+			result.Images++
+		}
+		response.Folders = append(response.Folders, result)
+	}
+
+	if len(keys) == 0 {
+		h.storage.DeleteTaskDirectory(taskID)
+		respondError(c, apierror.Validation("В архиве нет изображений в папках людей"))
+		return
+	}
+
+	task := &models.Task{
+		ID:            taskID,
+		TotalImages:   len(keys),
+		MinSize:       params.MinSize,
+		DetThresh:     params.DetThresh,
+		MinConfidence: params.MinConfidence,
+		CallbackURL:   callbackURL,
+	}
+	if err := h.repo.CreateTask(task); err != nil {
+		h.storage.DeleteTaskDirectory(taskID)
+		respondError(c, apierror.Internal("Ошибка создания задачи", err))
+		return
+	}
+	h.taskCreated(task)
+	h.saveOriginalNames(taskID, names)
+
+	if err := h.enqueue(taskID, func() { h.processFiles(taskID, keys, nil, params, callbackURL, labels) }); err != nil {
+		log.Printf("❌ Задача %s не поставлена в очередь: %v", taskID, err)
+		h.failTask(taskID, names.fill(taskFileResults(keys, nil, err.Error(), nil)), err.Error(), nil, len(keys), callbackURL)
+		respondError(c, apierror.Unavailable(err.Error()))
+		return
+	}
+
+	persons := 0
+	for _, folder := range response.Folders {
+		if folder.Images > 0 {
+			persons++
+		}
+	}
+	log.Printf("📦 Задача %s: импорт %d изображений, людей: %d", taskID, len(keys), persons)
+
+	response.Message = fmt.Sprintf("Принято %d изображений из %d папок, начата обработка", len(keys), persons)
+	c.Header("Location", "/api/task/"+taskID)
+	c.JSON(http.StatusAccepted, response)
+}
+
+// importLimits возвращает ограничения импорта (по умолчанию, если конфигурации нет)
+func (h *Handler) importLimits() config.ImportConfig {
+	if h.cfg == nil {
+		return config.ImportConfig{
+			MaxSize:             config.DefaultImportMaxSizeMB << 20,
+			MaxEntries:          config.DefaultImportMaxEntries,
+			MaxFileSize:         config.DefaultImportMaxFileSizeMB << 20,
+			MaxUncompressedSize: config.DefaultImportMaxUncompressedMB << 20,
+		}
+	}
+	return h.cfg.Import
+}
+
+// errImportTooLarge - распакованные файлы превысили IMPORT_MAX_UNCOMPRESSED_MB
+var errImportTooLarge = errors.New("превышен суммарный размер файлов после распаковки")
+
+// importFolder - папка архива: человек и файлы для распаковки
+type importFolder struct {
+	result  models.ImportFolder
+	entries []*zip.File
+}
+
+// importPlan - разбор архива до распаковки
+type importPlan struct {
+	folders      []importFolder             // По имени папки
+	skipped      []models.ImportSkippedFile // Файлы вне папок людей
+	uncompressed uint64                     // Заявленный размер файлов папок после распаковки
+}
+
+// planImport раскладывает записи архива по папкам людей, ничего не распаковывая
+// Путь с ".." или абсолютный путь (zip-slip) отклоняет весь архив. Служебные файлы
+// (__MACOSX, скрытые) пропускаются молча; если все папки лежат в одной общей папке
+// (архив папки целиком), она не считается человеком
+func (h *Handler) planImport(files []*zip.File, maxFileSize int64) (*importPlan, error) {
+	type entry struct {
+		file  *zip.File
+		parts []string
+	}
+
+	var entries []entry
+	for _, file := range files {
+		if unsafeArchivePath(file.Name) {
+			return nil, fmt.Errorf("Недопустимый путь в архиве: %s", file.Name)
+		}
+		if file.FileInfo().IsDir() || ignoredArchivePath(file.Name) {
+			continue
+		}
+		entries = append(entries, entry{file: file, parts: strings.Split(file.Name, "/")})
+	}
+
+	paths := make([][]string, len(entries))
+	for i, e := range entries {
+		paths[i] = e.parts
+	}
+	if commonArchiveRoot(paths) {
+		for i := range entries {
+			entries[i].parts = entries[i].parts[1:]
+		}
+	}
+
+	plan := &importPlan{skipped: []models.ImportSkippedFile{}}
+	byName := make(map[string]*importFolder)
+	var names []string
+	for _, e := range entries {
+		switch {
+		case len(e.parts) == 1:
+			plan.skipped = append(plan.skipped, models.ImportSkippedFile{Path: e.file.Name, Reason: "Файл вне папки человека"})
+			continue
+		case len(e.parts) > 2:
+			plan.skipped = append(plan.skipped, models.ImportSkippedFile{Path: e.file.Name, Reason: "Вложенные папки не поддерживаются"})
+			continue
+		}
+
+		folder, ok := byName[e.parts[0]]
+		if !ok {
+			folder = &importFolder{result: models.ImportFolder{Name: e.parts[0], Skipped: []models.ImportSkippedFile{}}}
+			byName[e.parts[0]] = folder
+			names = append(names, e.parts[0])
+		}
+		folder.entries = append(folder.entries, e.file)
+	}
+
+	sort.Strings(names)
+	for _, dir := range names {
+		folder := byName[dir]
+
+		name, err := h.normalizePersonName(dir)
+		if err == nil && name == noiseCluster {
+			err = fmt.Errorf("Имя %s зарезервировано", noiseCluster)
+		}
+		if err != nil {
+			folder.result.Error = err.Error()
+			folder.entries = nil
+		} else {
+			folder.result.Name = name
+			for _, file := range folder.entries {
+				// Файлы больше лимита пропускаются без распаковки
+				if file.UncompressedSize64 <= uint64(maxFileSize) {
+					plan.uncompressed += file.UncompressedSize64
+				}
+			}
+		}
+		plan.folders = append(plan.folders, *folder)
+	}
+
+	return plan, nil
+}
+
+// importFile распаковывает одно изображение архива в папку задачи, уменьшая remaining -
+// сколько еще байт можно распаковать. reason - почему файл пропущен; err - ошибка
+// хранилища или errImportTooLarge, импорт прерывается
+func (h *Handler) importFile(taskID string, file *zip.File, name string, maxFileSize int64, remaining *int64) (key, reason string, err error) {
+	if file.UncompressedSize64 > uint64(maxFileSize) {
+		return "", fmt.Sprintf("Файл больше %d МБ", maxFileSize>>20), nil
+	}
+
+	rc, err := file.Open()
+	if err != nil {
+		return "", fmt.Sprintf("Ошибка чтения: %v", err), nil
+	}
+	defer rc.Close()
+
+	// Размер в заголовке может быть неправдой - читаем не больше лимитов
+	data, err := io.ReadAll(io.LimitReader(rc, min(maxFileSize, *remaining)+1))
+	*remaining -= int64(len(data))
+	if err != nil {
+		return "", fmt.Sprintf("Ошибка чтения: %v", err), nil
+	}
+	if int64(len(data)) > maxFileSize {
+		return "", fmt.Sprintf("Файл больше %d МБ", maxFileSize>>20), nil
+	}
+	if *remaining < 0 {
+		return "", "", errImportTooLarge
+	}
+	if len(data) == 0 {
+		return "", "Пустой файл", nil
+	}
+
+	if storage.IsHEIF(data) {
+		if h.cfg == nil || !h.cfg.Storage.ConvertHEIF || !storage.HEIFSupported {
+			return "", "HEIC/HEIF не поддерживается", nil
+		}
+	} else if contentType := http.DetectContentType(data); !processableTypes[contentType] {
+		return "", fmt.Sprintf("Неподдерживаемый формат: %s", contentType), nil
+	}
+
+	key, err = h.storage.SaveFile(taskID, name, bytes.NewReader(data), int64(len(data)))
+	return key, "", err
+}
+
+// labelClusters заменяет кластеры Python группами по именам из labels (ключ файла - имя)
+// При импорте человек известен заранее, поэтому сохраняются и лица шума - если у них
+// есть метаданные и embedding (checkPythonResult шум не проверяет)
+func (h *Handler) labelClusters(taskID string, clusters map[string][]string, result *models.PythonResponse, labels map[string]string) map[string][]string {
+	labeled := make(map[string][]string)
+	for _, faceIDs := range clusters {
+		for _, faceID := range faceIDs {
+			metadata, ok := result.FacesMetadata[faceID]
+			if !ok || len(result.Embeddings[faceID]) == 0 {
+				continue
+			}
+			name := labels[h.fileKey(taskID, metadata.OriginalImage)]
+			if name == "" {
+				continue
+			}
+			labeled[name] = append(labeled[name], faceID)
+		}
+	}
+
+	for _, faceIDs := range labeled {
+		sort.Strings(faceIDs)
+	}
+	return labeled
+}
+
+// unsafeArchivePath сообщает, может ли путь записи выйти за пределы папки распаковки
+func unsafeArchivePath(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") {
+		return true
+	}
+	// Диск Windows ("C:...")
+	if len(name) >= 2 && name[1] == ':' {
+		return true
+	}
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// ignoredArchivePath - служебные файлы архиваторов и ОС (__MACOSX, .DS_Store)
+func ignoredArchivePath(name string) bool {
+	for _, part := range strings.Split(strings.TrimSuffix(name, "/"), "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// commonArchiveRoot сообщает, лежат ли все файлы (пути по частям) во вложенных папках
+// одной общей папки
+func commonArchiveRoot(paths [][]string) bool {
+	if len(paths) == 0 {
+		return false
+	}
+	root := paths[0][0]
+	for _, p := range paths {
+		if len(p) < 3 || p[0] != root {
+			return false
+		}
+	}
+	return true
+}
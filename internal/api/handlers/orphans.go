@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"face-recognition/internal/config"
+	"face-recognition/internal/service/storage"
+)
+
+// CleanupOrphanedFiles удаляет файлы хранилища, на которые не ссылается ни одна запись в БД
+// Записи удаляются раньше файлов, поэтому падение сервера между этими шагами (или ошибка
+// удаления файла) оставляет сирот. Файл нужен, если на него ссылается лицо; оригинал
+// загрузки нужен и без лиц, пока существует его задача. Файлы новее ORPHAN_GRACE_PERIOD
+// не трогаем: загрузка или обработка, которая их пишет, могла еще не создать запись
+// Возвращает число удаленных файлов; ошибка удаления отдельного файла только логируется
+func (h *Handler) CleanupOrphanedFiles() (int, error) {
+	grace := config.DefaultOrphanGracePeriod
+	if h.cfg != nil && h.cfg.Storage.OrphanGracePeriod > 0 {
+		grace = h.cfg.Storage.OrphanGracePeriod
+	}
+
+	// Сначала файлы, потом ссылки: лицо, сохраненное между запросами, попадет в ссылки
+	keys, err := h.storage.ListAllFiles()
+	if err != nil {
+		return 0, err
+	}
+	if len(keys) == 0 {
+		return 0, nil
+	}
+
+	faceKeys, err := h.repo.GetFaceFileKeys()
+	if err != nil {
+		return 0, err
+	}
+	taskIDs, err := h.repo.GetTaskIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	referenced := make(map[string]bool, len(faceKeys))
+	for _, key := range faceKeys {
+		referenced[key] = true
+	}
+	tasks := make(map[string]bool, len(taskIDs))
+	for _, id := range taskIDs {
+		tasks[id] = true
+	}
+
+	removed := 0
+	for _, key := range keys {
+		if referenced[key] {
+			continue
+		}
+		if !storage.IsFaceFile(key) {
+			taskID, _, _ := strings.Cut(key, "/")
+			if tasks[taskID] {
+				continue
+			}
+		}
+
+		modTime, err := h.storage.FileModTime(key)
+		if errors.Is(err, storage.ErrNotFound) {
+			continue // Уже удален
+		}
+		if err != nil {
+			log.Printf("⚠️  Очистка файлов: не удалось прочитать %s: %v", key, err)
+			continue
+		}
+		if time.Since(modTime) < grace {
+			continue
+		}
+
+		if err := h.storage.DeleteFiles([]string{key}); err != nil {
+			log.Printf("⚠️  Очистка файлов: %v", err)
+			continue
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+// RunOrphanCleanup периодически удаляет файлы-сироты до отмены ctx
+// Запускается в отдельной горутине
+func (h *Handler) RunOrphanCleanup(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			removed, err := h.CleanupOrphanedFiles()
+			if err != nil {
+				log.Printf("⚠️  Очистка файлов не выполнена: %v", err)
+			} else if removed > 0 {
+				log.Printf("🧹 Удалено файлов без записей в БД: %d", removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"sort"
+
+	"face-recognition/internal/models"
+)
+
+// maxFaceOverlap возвращает IoU рамок, выше которого два лица одного фото
+// считаются одной детекцией (0 - не объединять)
+func (h *Handler) maxFaceOverlap() float64 {
+	if h.cfg == nil {
+		return models.DefaultMaxFaceOverlap
+	}
+	return h.cfg.Detection.MaxOverlap
+}
+
+// mergeOverlappingFaces убирает повторные детекции: если рамки двух лиц одного
+// исходного фото перекрываются сильнее maxOverlap (IoU), остается более уверенное лицо
+// Лица без рамки и outlier кластер не трогаются. Возвращает кластеры без убранных лиц
+// (порядок лиц сохраняется) и число убранных
+func mergeOverlappingFaces(clusters map[string][]string, metadata map[string]models.FaceMetadata, maxOverlap float64) (map[string][]string, int) {
+	if maxOverlap <= 0 {
+		return clusters, 0
+	}
+
+	byImage := make(map[string][]string)
+	for clusterID, faceIDs := range clusters {
+		if clusterID == noiseCluster {
+			continue
+		}
+		for _, faceID := range faceIDs {
+			if face, ok := metadata[faceID]; ok && len(face.Bbox) == 4 {
+				byImage[face.OriginalImage] = append(byImage[face.OriginalImage], faceID)
+			}
+		}
+	}
+
+	dropped := make(map[string]bool)
+	for _, faceIDs := range byImage {
+		if len(faceIDs) < 2 {
+			continue
+		}
+
+		// Самые уверенные первыми: лицо остается, если не перекрывает ни одно оставленное
+		sort.Slice(faceIDs, func(i, j int) bool {
+			a, b := metadata[faceIDs[i]], metadata[faceIDs[j]]
+			if a.Confidence != b.Confidence {
+				return a.Confidence > b.Confidence
+			}
+			return faceIDs[i] < faceIDs[j]
+		})
+
+		kept := make([]string, 0, len(faceIDs))
+		for _, faceID := range faceIDs {
+			duplicate := false
+			for _, keptID := range kept {
+				if bboxIoU(metadata[faceID].Bbox, metadata[keptID].Bbox) > maxOverlap {
+					duplicate = true
+					break
+				}
+			}
+			if duplicate {
+				dropped[faceID] = true
+			} else {
+				kept = append(kept, faceID)
+			}
+		}
+	}
+
+	if len(dropped) == 0 {
+		return clusters, 0
+	}
+
+	merged := make(map[string][]string, len(clusters))
+	for clusterID, faceIDs := range clusters {
+		kept := make([]string, 0, len(faceIDs))
+		for _, faceID := range faceIDs {
+			if !dropped[faceID] {
+				kept = append(kept, faceID)
+			}
+		}
+		merged[clusterID] = kept
+	}
+	return merged, len(dropped)
+}
+
+// bboxIoU возвращает отношение площади пересечения рамок [x1, y1, x2, y2]
+// к площади их объединения (0 - не пересекаются)
+func bboxIoU(a, b []int) float64 {
+	width := min(a[2], b[2]) - max(a[0], b[0])
+	height := min(a[3], b[3]) - max(a[1], b[1])
+	if width <= 0 || height <= 0 {
+		return 0
+	}
+
+	intersection := float64(width * height)
+	union := float64((a[2]-a[0])*(a[3]-a[1])+(b[2]-b[0])*(b[3]-b[1])) - intersection
+	if union <= 0 {
+		return 0
+	}
+	return intersection / union
+}
@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения числа задач в ответе GET /api/tasks/recent
+// В кэше лежит maxRecentTasks задач, limit только обрезает список
+const (
+	defaultRecentTasks = 10
+	maxRecentTasks     = 50
+)
+
+// HandleRecentTasks возвращает последние задачи (новые первыми) со статусом,
+// итогами и длительностью обработки - для блока последней активности
+// ?limit= - число задач (по умолчанию 10, до 50). Список кэшируется на 10 секунд,
+// новые задачи приходят клиентам сообщением task_created
+func (h *Handler) HandleRecentTasks(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	limit, ok := queryLimit(c, defaultRecentTasks, maxRecentTasks)
+	if !ok {
+		return
+	}
+
+	tasks, err := h.recentTasks()
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	tasks = tasks[:min(len(tasks), limit)]
+	for i := range tasks {
+		tasks[i].SetDuration()
+	}
+
+	c.JSON(http.StatusOK, models.RecentTasksResponse{Tasks: tasks})
+}
+
+// recentTasks возвращает maxRecentTasks последних задач из кэша или БД
+func (h *Handler) recentTasks() ([]models.Task, error) {
+	if h.cache != nil {
+		if tasks, err := h.cache.GetRecentTasks(h.context()); err == nil && tasks != nil {
+			return tasks, nil
+		}
+	}
+
+	tasks, err := h.repo.GetRecentTasks(maxRecentTasks)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.cache != nil {
+		if err := h.cache.SetRecentTasks(h.context(), tasks); err != nil {
+			log.Printf("⚠️  Не удалось закэшировать последние задачи: %v", err)
+		}
+	}
+	return tasks, nil
+}
+
+// taskCreated сообщает о новой задаче: сбрасывает кэш последних задач
+// и отправляет task_created всем клиентам
+func (h *Handler) taskCreated(task *models.Task) {
+	h.recentTasksChanged()
+	if h.wsManager != nil {
+		h.wsManager.BroadcastTaskCreated(task)
+	}
+}
+
+// recentTasksChanged сбрасывает кэш последних задач (задача создана, завершена или удалена)
+func (h *Handler) recentTasksChanged() {
+	if h.cache != nil {
+		h.cache.InvalidateRecentTasks(h.context())
+	}
+}
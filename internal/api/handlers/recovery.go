@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"database/sql"
+	"log"
+
+	"face-recognition/internal/models"
+)
+
+// errTaskInterrupted - ошибка задачи, которую прервал перезапуск, а файлов для повтора нет
+const errTaskInterrupted = "Обработка прервана перезапуском сервера, файлы задачи не найдены"
+
+// RequeueStuckTasks возвращает в очередь задачи, обработку которых прервала остановка сервера
+// Очередь хранится в памяти, поэтому после перезапуска задачи в queued и processing
+// никто не обработает. Лица, которые задача успела сохранить, удаляются, и задача
+// обрабатывается заново с прежними параметрами. Задачи без файлов (например, удаленных
+// очисткой) и не поместившиеся в очередь переводятся в failed
+// Вызывается один раз при запуске, до приема запросов
+func (h *Handler) RequeueStuckTasks() (requeued, failed int, err error) {
+	tasks, err := h.repo.GetInterruptedTasks()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i := range tasks {
+		if h.requeueStuckTask(&tasks[i]) {
+			requeued++
+		} else {
+			failed++
+		}
+	}
+
+	return requeued, failed, nil
+}
+
+// requeueStuckTask возвращает в очередь одну прерванную задачу
+// false - задачу восстановить не удалось, она переведена в failed
+func (h *Handler) requeueStuckTask(task *models.Task) bool {
+	files, err := h.storage.ListTaskFiles(task.ID)
+	if err != nil {
+		log.Printf("⚠️  Задача %s: не удалось прочитать файлы: %v", task.ID, err)
+	}
+	if len(files) == 0 {
+		h.failTask(task.ID, nil, errTaskInterrupted, nil, task.TotalImages, task.CallbackURL)
+		return false
+	}
+
+	lock, ok := h.tasks.acquire(task.ID)
+	if !ok {
+		return false // Задачу уже обрабатывают
+	}
+	defer lock.Release()
+
+	slot, err := h.reserveSlot()
+	if err != nil {
+		h.failTask(task.ID, nil, err.Error(), nil, task.TotalImages, task.CallbackURL)
+		return false
+	}
+	defer slot.Release()
+
+	persons, faces, err := h.repo.RequeueInterruptedTask(task.ID)
+	if err == sql.ErrNoRows {
+		return false // Задачу успели удалить или завершить
+	}
+	if err != nil {
+		log.Printf("⚠️  Задача %s: не удалось вернуть в очередь: %v", task.ID, err)
+		h.failTask(task.ID, nil, err.Error(), nil, task.TotalImages, task.CallbackURL)
+		return false
+	}
+
+	h.discardTaskResults(task, persons, faces)
+
+	keys := make([]string, len(files))
+	for i, file := range files {
+		keys[i] = file.Key
+	}
+	params := models.DetectionParams{
+		MinSize:       task.MinSize,
+		DetThresh:     task.DetThresh,
+		MinConfidence: task.MinConfidence,
+	}
+
+	log.Printf("🔁 Задача %s: возвращена в очередь после перезапуска (удалено %d лиц)", task.ID, len(faces))
+	h.submit(slot, lock.wrap(func() { h.processImages(task.ID, keys, params, task.CallbackURL) }))
+	return true
+}
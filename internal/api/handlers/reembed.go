@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// minReembedIoU - минимальное перекрытие рамок, при котором найденное заново лицо
+// считается тем же, что сохранено в БД
+const minReembedIoU = 0.5
+
+// Причины, по которым embedding лица не пересчитан
+var (
+	errReembedFileMissing  = errors.New("оригинальное фото не найдено")
+	errReembedFaceNotFound = errors.New("лицо не найдено на фото заново")
+	errReembedFaceDeleted  = errors.New("лицо удалено во время пересчета")
+)
+
+// HandleReembedPerson пересчитывает embedding всех лиц человека (POST /api/persons/:id/reembed)
+// Нужен после обновления модели InsightFace: старые embedding несравнимы с новыми
+// Каждое оригинальное фото заново отправляется в Python, а лицо сопоставляется
+// с найденным по перекрытию рамок. Лица без файла или не найденные заново
+// остаются со старым embedding и перечисляются в failed
+// ?model= пропускает лица, embedding которых уже посчитан этой моделью
+func (h *Handler) HandleReembedPerson(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	response := models.ReembedResponse{
+		PersonID: id,
+		Failed:   []models.ReembedFailure{},
+	}
+
+	// Пересчитываем только устаревшие embedding
+	faces := person.Faces
+	if current := c.Query("model"); current != "" {
+		faces = faces[:0:0]
+		for _, face := range person.Faces {
+			if face.EmbeddingModel == current {
+				response.Skipped++
+				continue
+			}
+			faces = append(faces, face)
+		}
+	}
+
+	// Python сохраняет присланные фото и рамки в папку задачи - используем отдельную
+	scratchID := "reembed-" + storage.NewTaskID()
+	defer func() {
+		if err := h.storage.DeleteTaskDirectory(scratchID); err != nil {
+			log.Printf("⚠️  Не удалось удалить временную папку %s: %v", scratchID, err)
+		}
+	}()
+
+	for _, image := range groupFacesByImage(faces) {
+		embeddings, model, detectErr := h.detectEmbeddings(image.key, image.faces, scratchID)
+		for _, face := range image.faces {
+			err := detectErr
+			if err == nil {
+				err = h.updateFaceEmbedding(face.ID, embeddings[face.ID], model)
+			}
+			if err != nil {
+				response.Failed = append(response.Failed, models.ReembedFailure{FaceID: face.ID, Error: err.Error()})
+				continue
+			}
+			response.Updated++
+		}
+	}
+
+	if response.Updated > 0 {
+		if err := h.repo.UpdatePersonCentroid(id); err != nil {
+			log.Printf("⚠️  Ошибка пересчета центроида человека %d: %v", id, err)
+		}
+		if h.cache != nil {
+			h.cache.InvalidatePerson(h.context(), id)
+		}
+	}
+
+	log.Printf("🧬 Человек %d: пересчитано %d embedding, ошибок %d", id, response.Updated, len(response.Failed))
+
+	c.JSON(http.StatusOK, response)
+}
+
+// imageFaces - лица одного оригинального фото
+type imageFaces struct {
+	key   string
+	faces []models.Face
+}
+
+// groupFacesByImage группирует лица по оригинальному фото (в порядке первого появления),
+// чтобы каждое фото отправлялось в Python один раз
+func groupFacesByImage(faces []models.Face) []imageFaces {
+	var images []imageFaces
+	index := make(map[string]int)
+	for _, face := range faces {
+		i, ok := index[face.OriginalImage]
+		if !ok {
+			i = len(images)
+			index[face.OriginalImage] = i
+			images = append(images, imageFaces{key: face.OriginalImage})
+		}
+		images[i].faces = append(images[i].faces, face)
+	}
+	return images
+}
+
+// detectEmbeddings заново находит лица на фото и возвращает новые embedding
+// сохраненных лиц по их ID и модель, которой они посчитаны
+// Лица, не найденные заново, в результат не попадают
+func (h *Handler) detectEmbeddings(key string, faces []models.Face, scratchID string) (map[int][]float64, string, error) {
+	if !h.storage.FileExists(key) {
+		return nil, "", errReembedFileMissing
+	}
+
+	// min_size не больше самого маленького лица, иначе Python его не найдет
+	minSize := models.DefaultMinSize
+	for _, face := range faces {
+		minSize = min(minSize, face.FaceWidth, face.FaceHeight)
+	}
+	minSize = max(minSize, minSizeLowerBound)
+
+	result, err := h.pythonClient.ProcessImages(h.ctx, []string{key}, scratchID, minSize, models.DefaultDetThresh)
+	if err != nil {
+		return nil, "", err
+	}
+
+	embeddings := make(map[int][]float64, len(faces))
+	for _, face := range faces {
+		bestIoU, bestID := 0.0, ""
+		for faceID, metadata := range result.FacesMetadata {
+			if len(metadata.Bbox) != 4 || len(result.Embeddings[faceID]) == 0 {
+				continue
+			}
+			if iou := boxIoU(face, metadata.Bbox); iou > bestIoU {
+				bestIoU, bestID = iou, faceID
+			}
+		}
+		if bestIoU >= minReembedIoU {
+			embeddings[face.ID] = result.Embeddings[bestID]
+		}
+	}
+	return embeddings, result.Model, nil
+}
+
+// updateFaceEmbedding сохраняет новый embedding лица
+func (h *Handler) updateFaceEmbedding(faceID int, vector []float64, model string) error {
+	if len(vector) == 0 {
+		return errReembedFaceNotFound
+	}
+
+	embeddingBytes, err := json.Marshal(vector)
+	if err != nil {
+		return err
+	}
+
+	err = h.repo.UpdateFaceEmbedding(faceID, embeddingBytes, model)
+	if err == sql.ErrNoRows {
+		return errReembedFaceDeleted
+	}
+	return err
+}
+
+// boxIoU - отношение площади пересечения рамок к площади объединения
+// bbox от Python: [x1, y1, x2, y2]
+func boxIoU(face models.Face, bbox []int) float64 {
+	x1, y1 := max(face.FaceX, bbox[0]), max(face.FaceY, bbox[1])
+	x2, y2 := min(face.FaceX+face.FaceWidth, bbox[2]), min(face.FaceY+face.FaceHeight, bbox[3])
+	if x2 <= x1 || y2 <= y1 {
+		return 0
+	}
+
+	intersection := float64((x2 - x1) * (y2 - y1))
+	union := float64(face.FaceWidth*face.FaceHeight+(bbox[2]-bbox[0])*(bbox[3]-bbox[1])) - intersection
+	if union <= 0 {
+		return 0
+	}
+	return intersection / union
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"log"
+	"net/http"
+	"strconv"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Ограничения сетки GET /api/persons/:id/sprite
+const (
+	defaultSpriteCols = 5
+	maxSpriteCols     = 20
+	defaultSpriteSize = 100
+	minSpriteSize     = 16
+	maxSpriteSize     = 256
+)
+
+// HandleGetPersonSprite собирает вырезки лиц человека в одно изображение-сетку,
+// чтобы UI рисовал галерею одним запросом вместо запроса на каждое лицо
+// ?cols= - колонок (1-20, по умолчанию 5), ?size= - сторона ячейки в px (16-256, по умолчанию 100)
+// Ответ - изображение в data URL и позиции ячеек по ID лица. Спрайт кэшируется по человеку,
+// параметрам и набору лиц: после добавления или удаления лиц собирается заново
+func (h *Handler) HandleGetPersonSprite(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		respondError(c, apierror.Validation("Неверный ID"))
+		return
+	}
+	cols, ok := queryIntRange(c, "cols", defaultSpriteCols, 1, maxSpriteCols)
+	if !ok {
+		return
+	}
+	size, ok := queryIntRange(c, "size", defaultSpriteSize, minSpriteSize, maxSpriteSize)
+	if !ok {
+		return
+	}
+
+	// Набор лиц берем из БД, а не из кэша человека - по нему проверяется свежесть спрайта
+	person, err := h.repo.GetPersonByID(id)
+	if err != nil {
+		respondError(c, repoError(err, "Человек не найден"))
+		return
+	}
+
+	faces := spriteFaces(person.Faces)
+	if len(faces) == 0 {
+		respondError(c, apierror.NotFound("У человека нет лиц с координатами"))
+		return
+	}
+	fingerprint := facesFingerprint(faces)
+
+	if h.cache != nil {
+		if sprite, err := h.cache.GetPersonSprite(c.Request.Context(), id, cols, size, fingerprint); err == nil && sprite != nil {
+			c.JSON(http.StatusOK, sprite)
+			return
+		}
+	}
+
+	crops := make([]storage.SpriteFace, len(faces))
+	for i := range faces {
+		crops[i] = storage.SpriteFace{Key: faces[i].OriginalImage, Rect: cropRect(&faces[i], 0)}
+	}
+
+	composed, err := h.storage.ComposeSprite(crops, cols, size)
+	if errors.Is(err, storage.ErrEmptySprite) {
+		respondError(c, apierror.NotFound("Исходные изображения лиц не найдены"))
+		return
+	}
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	sprite := &models.PersonSpriteResponse{
+		PersonID:  id,
+		Cols:      cols,
+		Size:      size,
+		Width:     composed.Width,
+		Height:    composed.Height,
+		Total:     len(person.Faces),
+		Image:     fmt.Sprintf("data:%s;base64,%s", composed.Format.ContentType, base64.StdEncoding.EncodeToString(composed.Data)),
+		Positions: make(map[int]models.SpritePosition, len(composed.Placed)),
+	}
+	for cell, i := range composed.Placed {
+		at := storage.SpriteCell(cell, cols, size)
+		sprite.Positions[faces[i].ID] = models.SpritePosition{X: at.X, Y: at.Y}
+	}
+
+	if h.cache != nil {
+		if err := h.cache.SetPersonSprite(h.context(), cols, size, fingerprint, sprite); err != nil {
+			log.Printf("⚠️  Не удалось закэшировать спрайт человека %d: %v", id, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, sprite)
+}
+
+// spriteFaces возвращает лица, которые можно вырезать (есть исходник и bbox),
+// не больше MaxSpriteFaces в порядке человека (новые первыми)
+func spriteFaces(faces []models.Face) []models.Face {
+	usable := make([]models.Face, 0, min(len(faces), models.MaxSpriteFaces))
+	for _, face := range faces {
+		if len(usable) == models.MaxSpriteFaces {
+			break
+		}
+		if face.OriginalImage != "" && !image.Rect(0, 0, face.FaceWidth, face.FaceHeight).Empty() {
+			usable = append(usable, face)
+		}
+	}
+	return usable
+}
+
+// facesFingerprint - отпечаток набора и порядка лиц для ключа кэша спрайта
+func facesFingerprint(faces []models.Face) string {
+	hash := fnv.New64a()
+	for _, face := range faces {
+		hash.Write([]byte(strconv.Itoa(face.ID) + ","))
+	}
+	return strconv.FormatUint(hash.Sum64(), 16)
+}
+
+// queryIntRange читает целый query параметр name от minValue до maxValue
+// (по умолчанию defaultValue). Неверное значение - ответ 400 и false
+func queryIntRange(c *gin.Context, name string, defaultValue, minValue, maxValue int) (int, bool) {
+	v := c.Query(name)
+	if v == "" {
+		return defaultValue, true
+	}
+
+	value, err := strconv.Atoi(v)
+	if err != nil || value < minValue || value > maxValue {
+		respondError(c, apierror.Validation(fmt.Sprintf("%s должен быть числом от %d до %d", name, minValue, maxValue)))
+		return 0, false
+	}
+	return value, true
+}
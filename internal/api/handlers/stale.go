@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// servedStaleHeader - ответ собран из резервной копии кэша: БД недоступна
+const servedStaleHeader = "X-Served-Stale"
+
+// markStale отмечает ответ как устаревшую копию
+func markStale(c *gin.Context) {
+	c.Header(servedStaleHeader, "true")
+}
+
+// canServeStale - ошибку БД можно заменить резервной копией из кэша
+// "Не найдено" - настоящий ответ БД, его копией не подменяем
+func (h *Handler) canServeStale(err error) bool {
+	return h.cache != nil && err != nil && !errors.Is(err, sql.ErrNoRows)
+}
+
+// stalePerson возвращает резервную копию человека, если БД ответила ошибкой err
+// (nil - копии нет или ошибка не про недоступность БД)
+func (h *Handler) stalePerson(id int, err error) *models.PersonWithFaces {
+	if !h.canServeStale(err) {
+		return nil
+	}
+	person, cacheErr := h.cache.GetStalePerson(h.context(), id)
+	if cacheErr != nil || person == nil {
+		return nil
+	}
+	log.Printf("⚠️  БД недоступна, отдаем устаревшую копию человека %d: %v", id, err)
+	return person
+}
+
+// staleTask возвращает резервную копию задачи, если БД ответила ошибкой err
+func (h *Handler) staleTask(taskID string, err error) *models.Task {
+	if !h.canServeStale(err) {
+		return nil
+	}
+	task, cacheErr := h.cache.GetStaleTask(h.context(), taskID)
+	if cacheErr != nil || task == nil {
+		return nil
+	}
+	log.Printf("⚠️  БД недоступна, отдаем устаревшую копию задачи %s: %v", taskID, err)
+	return task
+}
+
+// staleStats возвращает резервную копию статистики (с текущей очередью), если
+// посчитать статистику не удалось
+func (h *Handler) staleStats(err error) *models.Stats {
+	if !h.canServeStale(err) {
+		return nil
+	}
+	stats, cacheErr := h.cache.GetStaleStats(h.context())
+	if cacheErr != nil || stats == nil {
+		return nil
+	}
+	log.Printf("⚠️  БД недоступна, отдаем устаревшую статистику: %v", err)
+	h.setQueueStats(stats)
+	return stats
+}
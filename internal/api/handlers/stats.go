@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"face-recognition/internal/models"
+)
+
+// statsRefresher держит последнюю удачно посчитанную статистику и пересчитывает ее
+// в фоне (по таймеру и после изменений данных), чтобы запросы не ждали тяжелой агрегации
+// Одновременно идет не больше одного пересчета: остальные вызовы ждут его результата
+type statsRefresher struct {
+	load func() (*models.Stats, error)
+
+	mu       sync.Mutex
+	last     *models.Stats    // Последняя удачная статистика (nil - еще не считалась)
+	lastGen  uint64           // Поколение данных, по которому посчитана last
+	gen      uint64           // Поколение данных: растет при каждом изменении (invalidate)
+	inflight *statsRefreshing // Идущий пересчет
+}
+
+// statsRefreshing - пересчет, результат которого ждут все, кто пришел во время него
+type statsRefreshing struct {
+	gen    uint64 // Поколение данных на момент начала пересчета
+	done   chan struct{}
+	stats  *models.Stats
+	err    error
+	failed bool // Пересчет не удался (stats - предыдущая статистика или nil)
+}
+
+func newStatsRefresher(load func() (*models.Stats, error)) *statsRefresher {
+	return &statsRefresher{load: load}
+}
+
+// latest возвращает последнюю статистику не дожидаясь пересчета
+// Пока статистика ни разу не считалась, ждет первого пересчета
+func (r *statsRefresher) latest() (*models.Stats, error) {
+	r.mu.Lock()
+	last := r.last
+	r.mu.Unlock()
+
+	if last == nil {
+		call := r.refresh()
+		return copyStats(call.stats), call.err
+	}
+	return copyStats(last), nil
+}
+
+// current возвращает статистику, учитывающую все изменения данных на момент вызова
+// (при необходимости дожидается пересчета). Для stats_update: сообщение уходит
+// после изменения данных и должно его отражать
+func (r *statsRefresher) current() (*models.Stats, error) {
+	r.mu.Lock()
+	gen, last, lastGen := r.gen, r.last, r.lastGen
+	r.mu.Unlock()
+
+	if last != nil && lastGen >= gen {
+		return copyStats(last), nil
+	}
+	return r.refreshSince(gen)
+}
+
+// invalidate отмечает изменение данных и возвращает новое поколение
+func (r *statsRefresher) invalidate() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gen++
+	return r.gen
+}
+
+// refreshSince пересчитывает статистику так, чтобы она учитывала поколение gen:
+// пересчет, начатый до изменения, не подходит - после него запускается следующий
+// Неудачный пересчет не повторяется: отдается предыдущая статистика
+func (r *statsRefresher) refreshSince(gen uint64) (*models.Stats, error) {
+	for {
+		call := r.refresh()
+		if call.failed || call.gen >= gen {
+			return copyStats(call.stats), call.err
+		}
+	}
+}
+
+// refresh пересчитывает статистику или присоединяется к уже идущему пересчету
+// и возвращает завершенный пересчет. При ошибке в нем последняя удачная статистика
+// (stale-if-error); ошибка остается, только если удачной статистики еще не было
+func (r *statsRefresher) refresh() *statsRefreshing {
+	r.mu.Lock()
+	if call := r.inflight; call != nil {
+		r.mu.Unlock()
+		<-call.done
+		return call
+	}
+	call := &statsRefreshing{gen: r.gen, done: make(chan struct{})}
+	r.inflight = call
+	r.mu.Unlock()
+
+	stats, err := r.load()
+
+	r.mu.Lock()
+	if err == nil {
+		r.last, r.lastGen = stats, call.gen
+	} else {
+		call.failed = true
+		if r.last != nil {
+			log.Printf("⚠️  Статистика не пересчитана, отдаем предыдущую: %v", err)
+			stats, err = r.last, nil
+		}
+	}
+	call.stats, call.err = stats, err
+	r.inflight = nil
+	r.mu.Unlock()
+	close(call.done)
+
+	return call
+}
+
+// copyStats копирует статистику: в копию добавляется состояние очереди (setQueueStats),
+// общий экземпляр при этом не меняется. Срезы только читаются - их не копируем
+func copyStats(stats *models.Stats) *models.Stats {
+	if stats == nil {
+		return nil
+	}
+	copied := *stats
+	return &copied
+}
+
+// loadStats считает статистику в БД и обновляет ее в кэше
+func (h *Handler) loadStats() (*models.Stats, error) {
+	stats, err := h.repo.GetStats()
+	if err != nil {
+		return nil, err
+	}
+	if h.cache != nil {
+		h.cache.SetStats(h.context(), stats)
+	}
+	return stats, nil
+}
+
+// freshStats пересчитывает статистику после изменения данных и дожидается результата
+// (завершение задачи)
+func (h *Handler) freshStats() (*models.Stats, error) {
+	if h.stats == nil {
+		return h.repo.GetStats()
+	}
+	return h.stats.refreshSince(h.stats.invalidate())
+}
+
+// refreshStats запускает фоновый пересчет статистики после изменения данных
+func (h *Handler) refreshStats() {
+	if h.stats == nil {
+		return
+	}
+	gen := h.stats.invalidate()
+	go h.stats.refreshSince(gen)
+}
+
+// RunStatsRefresh пересчитывает статистику раз в interval до отмены ctx
+// Первый пересчет - сразу, чтобы первый запрос не ждал БД
+// Запускается в отдельной горутине
+func (h *Handler) RunStatsRefresh(ctx context.Context, interval time.Duration) {
+	if h.stats == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if call := h.stats.refresh(); call.err != nil {
+			log.Printf("⚠️  Статистика не посчитана: %v", call.err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconcileStats сверяет счетчики статистики (stats_summary) с таблицами
+// Если счетчики разошлись, статистика пересчитывается. Возвращает true при расхождении
+func (h *Handler) ReconcileStats() (bool, error) {
+	before, after, err := h.repo.ReconcileStats()
+	if err != nil {
+		return false, err
+	}
+	if before == after {
+		return false, nil
+	}
+
+	log.Printf("⚠️  Счетчики статистики разошлись с таблицами и исправлены: людей %d → %d, лиц %d → %d, задач %d → %d",
+		before.TotalPersons, after.TotalPersons, before.TotalFaces, after.TotalFaces, before.TotalTasks, after.TotalTasks)
+	if h.cache != nil {
+		h.cache.InvalidateStats(h.context())
+	}
+	h.statsChanged()
+	return true, nil
+}
+
+// RunStatsReconcile сверяет счетчики статистики раз в interval до отмены ctx
+// Запускается в отдельной горутине
+func (h *Handler) RunStatsReconcile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := h.ReconcileStats(); err != nil {
+				log.Printf("⚠️  Сверка счетчиков статистики не выполнена: %v", err)
+			}
+		}
+	}
+}
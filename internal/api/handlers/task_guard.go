@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"sync"
+
+	"face-recognition/internal/service/queue"
+)
+
+// taskGuard отмечает задачи, над которыми в этом процессе идет операция
+// (обработка, повторная обработка, удаление). Статус в БД защищает не полностью:
+// задача, просроченная по PROCESSING_TIMEOUT, уже в failed, а ее горутина еще
+// сохраняет лица - повторная обработка или удаление в это время испортили бы результат
+type taskGuard struct {
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+func newTaskGuard() *taskGuard {
+	return &taskGuard{active: make(map[string]bool)}
+}
+
+// acquire занимает задачу; false - над ней уже идет операция
+// Без guard (nil, например в тестах) задача занимается всегда, а блокировка nil
+func (g *taskGuard) acquire(taskID string) (*taskLock, bool) {
+	if g == nil {
+		return nil, true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.active[taskID] {
+		return nil, false
+	}
+	g.active[taskID] = true
+	return &taskLock{guard: g, taskID: taskID}, true
+}
+
+// release освобождает задачу; запись удаляется, чтобы map не рос
+func (g *taskGuard) release(taskID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.active, taskID)
+}
+
+// taskLock - занятая задача; освобождается один раз
+type taskLock struct {
+	guard     *taskGuard
+	taskID    string
+	handedOff bool // Блокировку освободит обработка (см. wrap)
+	once      sync.Once
+}
+
+// wrap передает блокировку обработке: задача освободится, когда job завершится
+// После wrap job нужно обязательно запустить
+func (l *taskLock) wrap(job queue.Job) queue.Job {
+	if l == nil {
+		return job
+	}
+
+	l.handedOff = true
+	return func() {
+		defer l.unlock()
+		job()
+	}
+}
+
+// Release освобождает задачу, если блокировка не передана обработке
+// Безопасен для nil и после wrap, поэтому подходит для defer
+func (l *taskLock) Release() {
+	if l == nil || l.handedOff {
+		return
+	}
+	l.unlock()
+}
+
+func (l *taskLock) unlock() {
+	l.once.Do(func() { l.guard.release(l.taskID) })
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/websocket"
+	"face-recognition/internal/models"
+	"face-recognition/internal/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleLabelTask дает имена людям, созданным задачей, за один запрос
+// ({"labels": {"cluster_5": "Alice", "12": "Bob"}}): ключ - автоматическое имя
+// кластера или ID человека. Все имена меняются в одной транзакции; ключи, которым
+// не нашлось человека задачи, пропускаются и возвращаются в unknown
+func (h *Handler) HandleLabelTask(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	var req models.TaskLabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.Labels) == 0 {
+		respondError(c, apierror.Validation("Список labels обязателен"))
+		return
+	}
+
+	labels := make(map[string]string, len(req.Labels))
+	for key, name := range req.Labels {
+		name, err := h.normalizePersonName(name)
+		if err != nil {
+			respondError(c, apierror.Validation(fmt.Sprintf("%s: %v", key, err)))
+			return
+		}
+		labels[key] = name
+	}
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	// Пока задача обрабатывается (в том числе уже просроченная), ее люди еще не все созданы
+	if task.InProgress() {
+		respondError(c, apierror.Conflict(repository.ErrTaskProcessing.Error()))
+		return
+	}
+	lock, ok := h.tasks.acquire(taskID)
+	if !ok {
+		respondError(c, apierror.Conflict(repository.ErrTaskProcessing.Error()))
+		return
+	}
+	defer lock.Release()
+
+	renamed, unknown, err := h.repo.LabelTaskPersons(taskID, labels)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Инвалидируем кэш
+	if h.cache != nil && len(renamed) > 0 {
+		for _, person := range renamed {
+			h.cache.InvalidatePerson(h.context(), person.ID)
+		}
+		h.cache.InvalidateStats(h.context())
+	}
+	if len(renamed) > 0 {
+		h.statsChanged()
+	}
+
+	for _, person := range renamed {
+		h.broadcastPersonEvent(websocket.MessageTypePersonUpdated, person.ID, person.Name)
+	}
+
+	c.JSON(http.StatusOK, models.TaskLabelResponse{
+		TaskID:  taskID,
+		Renamed: renamed,
+		Unknown: unknown,
+	})
+}
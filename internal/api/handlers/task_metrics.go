@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+
+	"face-recognition/internal/models"
+	"face-recognition/pkg/embedding"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HandleTaskMetrics оценивает качество кластеризации задачи по embedding ее лиц
+// Кластер - человек, к которому отнесены лица задачи; центроиды и косинусные
+// расстояния считаются в Go
+func (h *Handler) HandleTaskMetrics(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	taskID := c.Param("id")
+
+	task, err := h.repo.GetTask(taskID)
+	if err != nil {
+		respondError(c, repoError(err, "Задача не найдена"))
+		return
+	}
+
+	faces, err := h.repo.GetTaskFaces(taskID)
+	if err != nil {
+		respondError(c, err)
+		return
+	}
+
+	report := clusterMetrics(faces)
+	report.TaskID = task.ID
+	report.Status = task.Status
+
+	c.JSON(http.StatusOK, report)
+}
+
+// metricsCluster - лица одного человека и их центроид
+type metricsCluster struct {
+	personID  int
+	vectors   [][]float64
+	centroid  []float64
+	distances []float64 // Расстояние каждого лица до centroid
+}
+
+// clusterMetrics считает метрики кластеризации лиц
+// Лица без embedding, с нулевым вектором или с размерностью, отличной от первого
+// лица (embedding разных моделей), пропускаются
+func clusterMetrics(faces []models.Face) models.TaskMetricsResponse {
+	report := models.TaskMetricsResponse{Clusters: []models.ClusterMetrics{}}
+
+	byPerson := make(map[int]*metricsCluster)
+	dimension := 0
+	for _, face := range faces {
+		var vector []float64
+		if err := json.Unmarshal(face.Embedding, &vector); err != nil || len(vector) == 0 ||
+			embedding.Dot(vector, vector) == 0 || (dimension != 0 && len(vector) != dimension) {
+			report.SkippedFaces++
+			continue
+		}
+		dimension = len(vector)
+
+		cluster, ok := byPerson[face.PersonID]
+		if !ok {
+			cluster = &metricsCluster{personID: face.PersonID}
+			byPerson[face.PersonID] = cluster
+		}
+		cluster.vectors = append(cluster.vectors, vector)
+		report.Faces++
+	}
+
+	clusters := make([]*metricsCluster, 0, len(byPerson))
+	for _, cluster := range byPerson {
+		// Векторы ненулевые и одной размерности - ошибки быть не может
+		cluster.centroid, _ = embedding.Centroid(cluster.vectors)
+		for _, vector := range cluster.vectors {
+			cluster.distances = append(cluster.distances, cosineDistance(vector, cluster.centroid))
+		}
+		clusters = append(clusters, cluster)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		return clusters[i].personID < clusters[j].personID
+	})
+
+	report.ClusterCount = len(clusters)
+	if report.ClusterCount == 0 {
+		return report
+	}
+	report.AvgClusterSize = float64(report.Faces) / float64(report.ClusterCount)
+
+	// Расстояния между центроидами (единичные векторы - сходство равно скалярному произведению)
+	between := make([][]float64, len(clusters))
+	for i := range clusters {
+		between[i] = make([]float64, len(clusters))
+		for j := range clusters {
+			if i != j {
+				between[i][j] = 1 - embedding.Dot(clusters[i].centroid, clusters[j].centroid)
+			}
+		}
+	}
+
+	var intraSum, interSum, silhouetteSum float64
+	pairs := 0
+	minInter := math.Inf(1)
+	for i, cluster := range clusters {
+		metrics := models.ClusterMetrics{
+			PersonID:      cluster.personID,
+			Size:          len(cluster.vectors),
+			IntraDistance: mean(cluster.distances),
+		}
+		if metrics.Size == 1 {
+			report.SingletonCount++
+		}
+		if metrics.Size > report.MaxClusterSize {
+			report.MaxClusterSize = metrics.Size
+		}
+
+		nearest := -1
+		for j := range clusters {
+			if j == i {
+				continue
+			}
+			if nearest < 0 || between[i][j] < between[i][nearest] {
+				nearest = j
+			}
+			if j > i {
+				interSum += between[i][j]
+				pairs++
+				minInter = math.Min(minInter, between[i][j])
+			}
+		}
+		if nearest >= 0 {
+			nearestID, distance := clusters[nearest].personID, between[i][nearest]
+			metrics.NearestPersonID = &nearestID
+			metrics.NearestDistance = &distance
+		}
+
+		for k, a := range cluster.distances {
+			intraSum += a
+			if nearest >= 0 {
+				silhouetteSum += faceSilhouette(cluster, clusters, k, a)
+			}
+		}
+
+		report.Clusters = append(report.Clusters, metrics)
+	}
+
+	report.IntraDistance = intraSum / float64(report.Faces)
+	if pairs > 0 {
+		inter := interSum / float64(pairs)
+		silhouette := silhouetteSum / float64(report.Faces)
+		report.InterDistance = &inter
+		report.MinInterDistance = &minInter
+		report.Silhouette = &silhouette
+	}
+
+	return report
+}
+
+// faceSilhouette - упрощенный silhouette лица k кластера own: a - расстояние до своего
+// центроида, b - до ближайшего чужого. Для кластера из одного лица 0, как в sklearn
+func faceSilhouette(own *metricsCluster, clusters []*metricsCluster, k int, a float64) float64 {
+	if len(own.vectors) == 1 {
+		return 0
+	}
+
+	b := math.Inf(1)
+	for _, other := range clusters {
+		if other != own {
+			b = math.Min(b, cosineDistance(own.vectors[k], other.centroid))
+		}
+	}
+
+	if larger := math.Max(a, b); larger > 0 {
+		return (b - a) / larger
+	}
+	return 0
+}
+
+// cosineDistance - косинусное расстояние (1 - сходство) векторов одной размерности
+func cosineDistance(a, b []float64) float64 {
+	similarity, _ := embedding.CosineSimilarity(a, b)
+	return 1 - similarity
+}
+
+// mean - среднее значение (0 для пустого среза)
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
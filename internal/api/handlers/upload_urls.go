@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/api/middleware"
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+	"face-recognition/internal/tracing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// HandleUploadURLs создает задачу из изображений, доступных по URL
+// Изображения скачиваются в фоне; ошибки отдельных URL попадают в /api/task/:id/files
+func (h *Handler) HandleUploadURLs(c *gin.Context) {
+	h = h.withContext(c.Request.Context())
+
+	if h.downloader == nil {
+		respondError(c, apierror.Unavailable("Загрузка по URL отключена"))
+		return
+	}
+
+	var req models.UploadURLsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		if middleware.IsBodyTooLarge(err) {
+			respondError(c, apierror.TooLarge(middleware.ErrBodyTooLarge))
+			return
+		}
+		respondError(c, apierror.Validation("Неверный формат запроса"))
+		return
+	}
+
+	urls := make([]string, 0, len(req.URLs))
+	for _, rawURL := range req.URLs {
+		if rawURL = strings.TrimSpace(rawURL); rawURL != "" {
+			urls = append(urls, rawURL)
+		}
+	}
+
+	if len(urls) == 0 {
+		respondError(c, apierror.Validation("Требуется хотя бы один URL"))
+		return
+	}
+
+	if len(urls) > h.downloader.MaxURLs() {
+		respondError(c, apierror.Validation(fmt.Sprintf("Слишком много URL: %d (максимум %d)", len(urls), h.downloader.MaxURLs())))
+		return
+	}
+
+	// Заведомо неверные URL отклоняем сразу; сетевые ошибки - по каждому URL в результате задачи
+	for _, rawURL := range urls {
+		if err := h.downloader.ValidateURL(rawURL); err != nil {
+			respondError(c, apierror.Validation(err.Error()))
+			return
+		}
+	}
+
+	callbackURL := strings.TrimSpace(req.CallbackURL)
+	if callbackURL != "" {
+		if err := h.validateCallbackURL(callbackURL); err != nil {
+			respondError(c, apierror.Validation(err.Error()))
+			return
+		}
+	}
+
+	params := h.defaultDetectionParams()
+	taskID := storage.NewTaskID()
+
+	// Хэш содержимого до скачивания неизвестен - повтор загрузки по URL не дедуплицируется
+	task := &models.Task{
+		ID:            taskID,
+		TotalImages:   len(urls),
+		MinSize:       params.MinSize,
+		DetThresh:     params.DetThresh,
+		MinConfidence: params.MinConfidence,
+		CallbackURL:   callbackURL,
+	}
+	if err := h.repo.CreateTask(task); err != nil {
+		respondError(c, apierror.Internal("Ошибка создания задачи", err))
+		return
+	}
+	h.taskCreated(task)
+
+	go h.downloadAndProcess(taskID, urls, params, callbackURL)
+
+	acceptTask(c, taskID, fmt.Sprintf("Принято %d URL, начата загрузка", len(urls)))
+}
+
+// downloadAndProcess скачивает изображения в папку задачи и запускает обычную обработку
+// URL, которые не удалось скачать или сохранить, попадают в результат по файлам с ошибкой
+func (h *Handler) downloadAndProcess(taskID string, urls []string, params models.DetectionParams, callbackURL string) {
+	// Задача новая - занять ее не может никто другой
+	lock, _ := h.tasks.acquire(taskID)
+	defer lock.Release()
+
+	h.reportProgress(taskID, 5, "Скачивание изображений")
+
+	var imagePaths []string
+	var failed []models.TaskFile
+	used := make(map[string]bool, len(urls))
+	names := originalNames{}
+
+	_, span := tracing.Start(h.context(), "downloadImages", trace.WithAttributes(
+		attribute.String("task.id", taskID),
+		attribute.Int("urls.count", len(urls)),
+	))
+	results := h.downloader.DownloadAll(urls)
+	span.End()
+
+	for _, result := range results {
+		if result.Err == nil {
+			name := uniqueFileName(result.File.Name, used)
+			data := result.File.Data
+			key, err := h.storage.SaveFile(taskID, name, bytes.NewReader(data), int64(len(data)))
+			if err == nil {
+				imagePaths = append(imagePaths, key)
+				names.add(key, name)
+				continue
+			}
+			result.Err = fmt.Errorf("ошибка сохранения: %w", err)
+		}
+
+		log.Printf("⚠️  Задача %s: %s: %v", taskID, result.URL, result.Err)
+		failed = append(failed, models.TaskFile{
+			FileName: result.URL,
+			Error:    result.Err.Error(),
+		})
+	}
+
+	log.Printf("📥 Задача %s: скачано %d из %d изображений", taskID, len(imagePaths), len(urls))
+	h.saveOriginalNames(taskID, names)
+
+	// Скачивание не нагружает Python и идет сразу, а обработка - через очередь воркеров.
+	// Без очереди продолжаем в текущей горутине - она уже фоновая
+	process := func() { h.processFiles(taskID, imagePaths, failed, params, callbackURL, nil) }
+	if h.queue == nil {
+		process()
+		return
+	}
+	slot, err := h.queue.Reserve()
+	if err != nil {
+		h.failTask(taskID, append(names.fill(taskFileResults(imagePaths, nil, err.Error(), nil)), failed...),
+			err.Error(), nil, len(urls), callbackURL)
+		return
+	}
+	slot.Submit(lock.wrap(process))
+}
+
+// uniqueFileName возвращает имя, которого еще нет в used ("a.jpg", "a_2.jpg", ...)
+// Разные URL часто заканчиваются одинаково (image.jpg, photo.png)
+func uniqueFileName(name string, used map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+
+	candidate := name
+	for i := 2; used[strings.ToLower(candidate)]; i++ {
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
+	}
+
+	used[strings.ToLower(candidate)] = true
+	return candidate
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// processableTypes - форматы, которые читает Python (OpenCV); HEIC/HEIF
+// обрабатывается отдельно - его конвертирует Go (CONVERT_HEIF)
+var processableTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+	"image/bmp":  true,
+}
+
+// validateUpload отвечает отчетом о проверке файлов загрузки (?validate_only=true)
+// Файлы не сохраняются, задача не создается, Python не вызывается
+func (h *Handler) validateUpload(c *gin.Context, files []*multipart.FileHeader, params models.DetectionParams) {
+	report := models.UploadValidationResponse{
+		Files: make([]models.UploadFileValidation, len(files)),
+	}
+
+	// Файлы с одинаковым именем попадают в один ключ - сохранится только последний
+	last := make(map[string]int, len(files))
+	for i, fileHeader := range files {
+		report.Files[i] = h.validateUploadFile(fileHeader)
+		last[report.Files[i].StoredName] = i
+	}
+
+	for i := range report.Files {
+		file := &report.Files[i]
+		if file.Accepted && last[file.StoredName] != i {
+			file.Accepted = false
+			file.Reason = fmt.Sprintf("Будет заменен файлом №%d с тем же именем", last[file.StoredName]+1)
+		}
+
+		if file.Accepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+	}
+	report.Valid = report.Accepted > 0
+
+	// Те же файлы уже обработаны - настоящая загрузка вернет существующую задачу
+	if contentHash, err := uploadHash(files, params); err == nil {
+		if existing := h.findCompletedUpload(contentHash); existing != nil {
+			report.ExistingTaskID = existing.ID
+		}
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// validateUploadFile проверяет имя, размер и формат (по содержимому) одного файла
+func (h *Handler) validateUploadFile(fileHeader *multipart.FileHeader) models.UploadFileValidation {
+	result := models.UploadFileValidation{
+		FileName:   fileHeader.Filename,
+		StoredName: h.storage.GetUploadPath("", fileHeader.Filename),
+		Size:       fileHeader.Size,
+	}
+
+	switch result.StoredName {
+	case "", ".", "/":
+		result.Reason = "Некорректное имя файла"
+		return result
+	}
+
+	if fileHeader.Size == 0 {
+		result.Reason = "Пустой файл"
+		return result
+	}
+
+	header, err := readHeader(fileHeader)
+	if err != nil {
+		result.Reason = fmt.Sprintf("Ошибка чтения файла: %v", err)
+		return result
+	}
+
+	if storage.IsHEIF(header) {
+		result.ContentType = "image/heic"
+		if h.cfg == nil || !h.cfg.Storage.ConvertHEIF || !storage.HEIFSupported {
+			result.Reason = "HEIC/HEIF не поддерживается: конвертация выключена (CONVERT_HEIF) или сервер собран без cgo"
+			return result
+		}
+		result.Reason = imageReason(fileHeader, h.maxImagePixels())
+		result.Accepted = result.Reason == ""
+		return result
+	}
+
+	// Видео обрабатывается по кадрам (VIDEO_ENABLED, нужен ffmpeg)
+	if contentType := storage.VideoType(header); contentType != "" {
+		result.ContentType = contentType
+		if h.cfg == nil || !h.cfg.Video.Enabled {
+			result.Reason = storage.ErrVideoUnsupported.Error()
+			return result
+		}
+		if h.cfg.Video.MaxSize > 0 && fileHeader.Size > h.cfg.Video.MaxSize {
+			result.Reason = fmt.Sprintf("Видео больше %d МБ", h.cfg.Video.MaxSize>>20)
+			return result
+		}
+		result.Accepted = true
+		return result
+	}
+
+	result.ContentType = http.DetectContentType(header)
+	if !processableTypes[result.ContentType] {
+		result.Reason = fmt.Sprintf("Неподдерживаемый формат: %s", result.ContentType)
+		return result
+	}
+
+	result.Reason = imageReason(fileHeader, h.maxImagePixels())
+	result.Accepted = result.Reason == ""
+	return result
+}
+
+// maxImagePixels возвращает предел размера изображения (MAX_IMAGE_MEGAPIXELS)
+func (h *Handler) maxImagePixels() int64 {
+	if h.cfg == nil {
+		return 0
+	}
+	return h.cfg.Storage.MaxImagePixels
+}
+
+// imageReason проверяет изображение по заголовку (как при обработке): файл читается
+// как изображение и его размеры допустимы. Возвращает причину отказа ("" - файл подходит)
+func imageReason(fileHeader *multipart.FileHeader, maxPixels int64) string {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Sprintf("Ошибка чтения файла: %v", err)
+	}
+	defer file.Close()
+
+	if err := storage.CheckImage(file, fileHeader.Filename); err != nil {
+		return err.Error()
+	}
+	if maxPixels <= 0 {
+		return ""
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Sprintf("Ошибка чтения файла: %v", err)
+	}
+	if err := storage.CheckImageSize(file, fileHeader.Filename, maxPixels); err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+// readHeader читает первые 512 байт файла - столько нужно http.DetectContentType
+func readHeader(fileHeader *multipart.FileHeader) ([]byte, error) {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, 512)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"log"
+	"path/filepath"
+
+	"face-recognition/internal/models"
+	"face-recognition/internal/service/storage"
+)
+
+// videoFrames - кадры видео задачи: в Python они уходят вместо самих видео
+type videoFrames struct {
+	byVideo map[string][]storage.VideoFrame // Ключ видео → его кадры по порядку
+	byFrame map[string]frameSource          // Имя файла кадра → видео и время кадра
+}
+
+// frameSource - видео, из которого извлечен кадр
+type frameSource struct {
+	video     string
+	frameTime float64 // Секунды от начала видео
+}
+
+// extractVideoFrames извлекает кадры видео задачи (VIDEO_FPS кадров в секунду)
+// Видео, которые не удалось обработать (слишком длинные, битые, без ffmpeg),
+// помечаются ошибкой в fileErrors (ключ - имя файла) и в Python не отправляются
+// Возвращает кадры и fileErrors с добавленными ошибками
+func (h *Handler) extractVideoFrames(taskID string, imagePaths []string, fileErrors map[string]string) (*videoFrames, map[string]string) {
+	frames := &videoFrames{
+		byVideo: make(map[string][]storage.VideoFrame),
+		byFrame: make(map[string]frameSource),
+	}
+
+	for _, imagePath := range imagePaths {
+		name := filepath.Base(imagePath)
+		if _, failed := fileErrors[name]; failed {
+			continue
+		}
+
+		extracted, ok, err := h.storage.ExtractFrames(h.context(), imagePath)
+		if err != nil {
+			log.Printf("⚠️  Задача %s: %v", taskID, err)
+			if fileErrors == nil {
+				fileErrors = make(map[string]string)
+			}
+			fileErrors[name] = err.Error()
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		log.Printf("🎞️  Задача %s: из %s извлечено %d кадров", taskID, imagePath, len(extracted))
+		frames.byVideo[imagePath] = extracted
+		for _, frame := range extracted {
+			frames.byFrame[filepath.Base(frame.Key)] = frameSource{video: imagePath, frameTime: frame.Offset.Seconds()}
+		}
+	}
+
+	return frames, fileErrors
+}
+
+// pythonPaths возвращает файлы, которые уходят в Python вместо imagePath:
+// кадры для видео, сам файл для фото
+func (v *videoFrames) pythonPaths(imagePath string) []string {
+	extracted, ok := v.byVideo[imagePath]
+	if !ok {
+		return []string{imagePath}
+	}
+
+	keys := make([]string, len(extracted))
+	for i, frame := range extracted {
+		keys[i] = frame.Key
+	}
+	return keys
+}
+
+// source возвращает видео, из которого извлечен кадр key (для остальных файлов - сам key)
+func (v *videoFrames) source(key string) string {
+	if source, ok := v.byFrame[filepath.Base(key)]; ok {
+		return source.video
+	}
+	return key
+}
+
+// frameTime возвращает время кадра key от начала видео (nil - файл не кадр видео)
+func (v *videoFrames) frameTime(key string) *float64 {
+	source, ok := v.byFrame[filepath.Base(key)]
+	if !ok {
+		return nil
+	}
+	frameTime := source.frameTime
+	return &frameTime
+}
+
+// sourceMetadata относит лица с кадров к самим видео, чтобы результат по файлам
+// считал лица по загруженным файлам, а не по кадрам
+func (v *videoFrames) sourceMetadata(metadata map[string]models.FaceMetadata) map[string]models.FaceMetadata {
+	if len(v.byFrame) == 0 {
+		return metadata
+	}
+
+	bySource := make(map[string]models.FaceMetadata, len(metadata))
+	for faceID, face := range metadata {
+		if source, ok := v.byFrame[filepath.Base(face.OriginalImage)]; ok {
+			face.OriginalImage = source.video
+		}
+		bySource[faceID] = face
+	}
+	return bySource
+}
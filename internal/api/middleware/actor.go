@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"face-recognition/internal/audit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actor записывает в контекст запроса исполнителя для журнала изменений (person_audit)
+// Запросы без токена - audit.ActorAnonymous; AdminAuth после проверки токена
+// заменяет его на audit.ActorAdmin
+func Actor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), audit.ActorAnonymous))
+		c.Next()
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/audit"
+)
+
+// AdminAuth пропускает только запросы с заголовком "Authorization: Bearer <token>"
+// Пустой token отключает защищенные эндпоинты целиком (403), чтобы они не оказались
+// открытыми из-за незаданной переменной окружения. Изменения, сделанные по такому
+// запросу, записываются в журнал от имени audit.ActorAdmin
+func AdminAuth(token string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token == "" {
+			abortWithError(c, apierror.Forbidden("Админские эндпоинты отключены (ADMIN_TOKEN не задан)"))
+			return
+		}
+
+		provided, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			abortWithError(c, apierror.Unauthorized("Требуется токен администратора"))
+			return
+		}
+
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), audit.ActorAdmin))
+		c.Next()
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/audit"
+	"face-recognition/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newAdminRouter(token string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.DELETE("/admin", AdminAuth(token), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		header string
+		status int
+		code   string
+	}{
+		{"valid token", "secret", "Bearer secret", http.StatusOK, ""},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized, apierror.CodeUnauthorized},
+		{"no header", "secret", "", http.StatusUnauthorized, apierror.CodeUnauthorized},
+		{"no bearer prefix", "secret", "secret", http.StatusUnauthorized, apierror.CodeUnauthorized},
+		{"disabled", "", "Bearer ", http.StatusForbidden, apierror.CodeForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodDelete, "/admin", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			newAdminRouter(tt.token).ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code)
+			if tt.code != "" {
+				var resp models.ErrorResponse
+				require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+				assert.Equal(t, tt.code, resp.Code)
+			}
+		})
+	}
+}
+
+func TestActorForAuditLog(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Actor())
+
+	var actors []string
+	record := func(c *gin.Context) {
+		actors = append(actors, audit.Actor(c.Request.Context()))
+	}
+	router.GET("/public", record)
+	router.GET("/admin", AdminAuth("secret"), record)
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/public", nil))
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, []string{audit.ActorAnonymous, audit.ActorAdmin}, actors)
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"face-recognition/internal/api/apierror"
+)
+
+// ErrBodyTooLarge - текст ответа 413 при превышении лимита тела запроса
+const ErrBodyTooLarge = "Размер запроса превышает допустимый"
+
+// MaxBodySize ограничивает размер тела запроса
+// Запрос с большим Content-Length сразу отклоняется с 413; тело без Content-Length
+// (chunked) обрезается http.MaxBytesReader - обработчик получает ошибку при чтении
+// и проверяет ее через IsBodyTooLarge
+func MaxBodySize(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limit <= 0 || c.Request.Body == nil {
+			c.Next()
+			return
+		}
+
+		if c.Request.ContentLength > limit {
+			abortWithError(c, apierror.TooLarge(ErrBodyTooLarge))
+			return
+		}
+
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+		c.Next()
+	}
+}
+
+// abortWithError прерывает обработку запроса ответом с ошибкой API
+func abortWithError(c *gin.Context, err *apierror.Error) {
+	c.AbortWithStatusJSON(err.Status, err.Response())
+}
+
+// IsBodyTooLarge сообщает, что чтение тела прервано лимитом MaxBodySize
+func IsBodyTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
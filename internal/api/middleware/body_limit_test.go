@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"face-recognition/internal/api/apierror"
+	"face-recognition/internal/models"
+)
+
+func newBodyLimitRouter(limit int64) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MaxBodySize(limit))
+	router.POST("/upload", func(c *gin.Context) {
+		body, err := io.ReadAll(c.Request.Body)
+		if IsBodyTooLarge(err) {
+			c.JSON(http.StatusRequestEntityTooLarge, models.ErrorResponse{Error: ErrBodyTooLarge})
+			return
+		}
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, "%d", len(body))
+	})
+	return router
+}
+
+func TestMaxBodySizeRejectsLargeContentLength(t *testing.T) {
+	router := newBodyLimitRouter(1024)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/upload", bytes.NewReader(make([]byte, 2048)))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	var resp models.ErrorResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, ErrBodyTooLarge, resp.Error)
+	assert.Equal(t, apierror.CodeTooLarge, resp.Code)
+}
+
+func TestMaxBodySizeLimitsChunkedBody(t *testing.T) {
+	router := newBodyLimitRouter(1024)
+
+	w := httptest.NewRecorder()
+	// Без Content-Length лимит срабатывает только при чтении тела
+	req, _ := http.NewRequest("POST", "/upload", io.NopCloser(strings.NewReader(strings.Repeat("x", 2048))))
+	req.ContentLength = -1
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestMaxBodySizeAllowsBodyWithinLimit(t *testing.T) {
+	router := newBodyLimitRouter(1024)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/upload", bytes.NewReader(make([]byte, 1024)))
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "1024", w.Body.String())
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// correlationHeaders - заголовки, в которых клиент или прокси передает ID запроса
+var correlationHeaders = []string{"X-Request-ID", "X-Correlation-ID"}
+
+// Logger логирует каждый запрос: метод, путь, IP клиента, статус, размер ответа и время
+// Ответы 5xx пишутся с уровнем error, остальные - info. Проверки /health и статика
+// веб-интерфейса не логируются, чтобы не засорять лог. Если есть correlation id
+// (X-Request-ID или X-Correlation-ID) или span от Tracing - они добавляются к записи
+// logger == nil - slog.Default()
+func Logger(logger *slog.Logger) gin.HandlerFunc {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(c *gin.Context) {
+		if skipRequestLog(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+		latency := time.Since(start)
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("status", status),
+			slog.Int("size", max(c.Writer.Size(), 0)),
+			slog.Duration("latency", latency),
+		}
+		for _, header := range correlationHeaders {
+			if id := c.GetHeader(header); id != "" {
+				attrs = append(attrs, slog.String("correlation_id", id))
+				break
+			}
+		}
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			attrs = append(attrs, slog.String("trace_id", span.TraceID().String()))
+		}
+
+		level := slog.LevelInfo
+		if status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.LogAttrs(c.Request.Context(), level, "HTTP запрос", attrs...)
+	}
+}
+
+// skipRequestLog - запросы, которые не логируются: проверки здоровья и статика
+func skipRequestLog(path string) bool {
+	return path == "/health" || path == "/favicon.ico" || strings.HasPrefix(path, "/static/")
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newLoggedRouter(buf *bytes.Buffer) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Logger(slog.New(slog.NewJSONHandler(buf, nil))))
+	router.GET("/api/task/:id", func(c *gin.Context) {
+		c.String(http.StatusOK, "hello")
+	})
+	router.GET("/api/broken", func(c *gin.Context) {
+		c.Status(http.StatusBadGateway)
+	})
+	router.GET("/health", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	router.GET("/static/*file", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	return router
+}
+
+func TestLoggerWritesRequestLine(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf)
+
+	req, _ := http.NewRequest("GET", "/api/task/task-1", nil)
+	req.Header.Set("X-Request-ID", "req-42")
+	req.RemoteAddr = "10.0.0.7:5000"
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "INFO", entry["level"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/api/task/task-1", entry["path"])
+	assert.Equal(t, "10.0.0.7", entry["client_ip"])
+	assert.Equal(t, float64(http.StatusOK), entry["status"])
+	assert.Equal(t, float64(len("hello")), entry["size"])
+	assert.Equal(t, "req-42", entry["correlation_id"])
+	assert.Contains(t, entry, "latency")
+}
+
+func TestLoggerErrorLevelFor5xx(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf)
+
+	req, _ := http.NewRequest("GET", "/api/broken", nil)
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.Equal(t, float64(http.StatusBadGateway), entry["status"])
+	assert.Equal(t, float64(0), entry["size"])
+	assert.NotContains(t, entry, "correlation_id")
+}
+
+func TestLoggerSkipsHealthAndStatic(t *testing.T) {
+	var buf bytes.Buffer
+	router := newLoggedRouter(&buf)
+
+	for _, path := range []string{"/health", "/static/app.js"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+	}
+	assert.Empty(t, buf.String())
+}
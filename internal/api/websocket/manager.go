@@ -1,9 +1,11 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
@@ -17,6 +19,22 @@ const (
 	MessageTypeTaskComplete MessageType = "task_complete"
 	MessageTypeTaskFailed   MessageType = "task_failed"
 	MessageTypeStatsUpdate  MessageType = "stats_update"
+	MessageTypeFaceSaved    MessageType = "face_saved"
+
+	// Новая задача - глобальное событие для списка последних задач
+	MessageTypeTaskCreated MessageType = "task_created"
+
+	// События по людям - глобальные, отправляются всем клиентам
+	MessageTypePersonCreated  MessageType = "person_created"
+	MessageTypePersonUpdated  MessageType = "person_updated"
+	MessageTypePersonDeleted  MessageType = "person_deleted"
+	MessageTypePersonRestored MessageType = "person_restored"
+
+	// Состояние сервера - периодически отправляется всем клиентам
+	MessageTypeSystemStatus MessageType = "system_status"
+
+	// Ход перестройки кэша администратором
+	MessageTypeCacheRebuild MessageType = "cache_rebuild"
 )
 
 // Message структура WebSocket сообщения
@@ -26,12 +44,72 @@ type Message struct {
 	Payload interface{} `json:"payload"`
 }
 
+// FaceSaved - лицо, сохраненное в БД на этапе сохранения задачи
+type FaceSaved struct {
+	PersonID       int    `json:"person_id"`
+	FaceID         int    `json:"face_id"`
+	AnnotatedImage string `json:"annotated_image"`
+	URL            string `json:"url,omitempty"`
+}
+
+// SystemStatus - текущая нагрузка сервера для сообщения system_status
+type SystemStatus struct {
+	Clients     int `json:"clients"`      // Подключенных WebSocket клиентов
+	ActiveTasks int `json:"active_tasks"` // Задач в обработке прямо сейчас
+	QueueDepth  int `json:"queue_depth"`  // Задач, ожидающих в очереди
+}
+
+// StatusFunc возвращает нагрузку сервера (число клиентов менеджер заполняет сам)
+type StatusFunc func() SystemStatus
+
+// StatsFunc возвращает текущую статистику для сообщения stats_update
+type StatsFunc func() (interface{}, error)
+
 // Client представляет WebSocket клиента
+// Send никогда не закрывается: отключение сигнализирует done (см. stop), а соединение
+// закрывает WritePump. Поэтому отправка в Send не может паниковать на закрытом канале
 type Client struct {
 	ID     string
 	Conn   *websocket.Conn
 	Send   chan Message
 	TaskID string // ID задачи, которую отслеживает клиент
+	IP     string // Адрес клиента для лимита соединений (см. SetMaxConnectionsPerIP)
+
+	// StatsOnly - клиент подписан только на stats_update (/api/stats/stream)
+	StatsOnly bool
+
+	initOnce sync.Once
+	stopOnce sync.Once
+	done     chan struct{} // Закрывается, когда клиент отключен (создается лениво, см. Done)
+}
+
+// Done возвращает канал, который закрывается при отключении клиента
+func (c *Client) Done() <-chan struct{} {
+	c.initOnce.Do(func() { c.done = make(chan struct{}) })
+	return c.done
+}
+
+// stop отмечает клиента отключенным; повторный вызов ничего не делает
+func (c *Client) stop() {
+	c.Done()
+	c.stopOnce.Do(func() { close(c.done) })
+}
+
+// trySend кладет сообщение в Send без блокировки
+// false - буфер переполнен или клиент уже отключен
+func (c *Client) trySend(message Message) bool {
+	select {
+	case <-c.Done():
+		return false
+	default:
+	}
+
+	select {
+	case c.Send <- message:
+		return true
+	default:
+		return false
+	}
 }
 
 // Manager управляет WebSocket соединениями
@@ -41,6 +119,27 @@ type Manager struct {
 	unregister chan *Client
 	broadcast  chan Message
 	mu         sync.RWMutex
+
+	// Пересчет статистики по NotifyStatsChanged
+	statsMu       sync.Mutex
+	stats         StatsFunc
+	statsDebounce time.Duration
+	statsPending  bool // Пересчет уже запланирован
+
+	// Объединение task_progress (см. BroadcastTaskProgress)
+	progressMu       sync.Mutex
+	progressInterval time.Duration
+	progress         map[string]*taskProgress
+
+	// Открытые соединения по IP (см. SetMaxConnectionsPerIP)
+	connMu   sync.Mutex
+	maxPerIP int
+	ipConns  map[string]int
+}
+
+// taskProgress - прогресс задачи, отправленный в текущем интервале
+type taskProgress struct {
+	pending *Message // Последний прогресс, пришедший после отправленного
 }
 
 // NewManager создает новый WebSocket manager
@@ -50,6 +149,8 @@ func NewManager() *Manager {
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan Message, 256),
+		progress:   make(map[string]*taskProgress),
+		ipConns:    make(map[string]int),
 	}
 }
 
@@ -61,38 +162,152 @@ func (m *Manager) Run() {
 			m.mu.Lock()
 			m.clients[client.ID] = client
 			m.mu.Unlock()
-			log.Printf("WebSocket: клиент %s подключен (задача: %s)", client.ID, client.TaskID)
+			if client.StatsOnly {
+				log.Printf("WebSocket: клиент %s подключен (только статистика)", client.ID)
+			} else {
+				log.Printf("WebSocket: клиент %s подключен (задача: %s)", client.ID, client.TaskID)
+			}
 
 		case client := <-m.unregister:
-			m.mu.Lock()
-			if _, ok := m.clients[client.ID]; ok {
-				delete(m.clients, client.ID)
-				close(client.Send)
+			if m.removeClient(client) {
 				log.Printf("WebSocket: клиент %s отключен", client.ID)
 			}
-			m.mu.Unlock()
 
 		case message := <-m.broadcast:
+			var slow []*Client
 			m.mu.RLock()
 			for _, client := range m.clients {
 				// Если сообщение для конкретной задачи - отправляем только подписанным клиентам
 				if message.TaskID != "" && client.TaskID != message.TaskID {
 					continue
 				}
+				if client.StatsOnly && message.Type != MessageTypeStatsUpdate {
+					continue
+				}
 
-				select {
-				case client.Send <- message:
-				default:
-					// Если канал переполнен - отключаем клиента
-					close(client.Send)
-					delete(m.clients, client.ID)
+				if !client.trySend(message) {
+					slow = append(slow, client)
 				}
 			}
 			m.mu.RUnlock()
+
+			// Если канал переполнен - отключаем клиента (удаление из map - под записывающей блокировкой)
+			for _, client := range slow {
+				if m.removeClient(client) {
+					log.Printf("⚠️  WebSocket: клиент %s отключен - не успевает читать сообщения", client.ID)
+				}
+			}
+		}
+	}
+}
+
+// removeClient удаляет клиента и сигнализирует WritePump закрыть соединение
+// false - клиент уже удален (повторное отключение ничего не делает)
+func (m *Manager) removeClient(client *Client) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.clients[client.ID] != client {
+		return false
+	}
+	delete(m.clients, client.ID)
+	m.releaseIP(client.IP)
+	client.stop()
+	return true
+}
+
+// SetMaxConnectionsPerIP задает максимум одновременных соединений с одного IP (0 - без ограничения)
+// Защищает от исчерпания горутин клиентом, открывающим соединения в цикле
+func (m *Manager) SetMaxConnectionsPerIP(limit int) {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	m.maxPerIP = limit
+}
+
+// acquireIP занимает место соединения с ip до апгрейда
+// false - с этого IP уже открыто максимум соединений
+func (m *Manager) acquireIP(ip string) bool {
+	if ip == "" {
+		return true
+	}
+
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.maxPerIP > 0 && m.ipConns[ip] >= m.maxPerIP {
+		return false
+	}
+	m.ipConns[ip]++
+	return true
+}
+
+// releaseIP освобождает место соединения с ip (клиент отключен или апгрейд не удался)
+func (m *Manager) releaseIP(ip string) {
+	if ip == "" {
+		return
+	}
+
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	if m.ipConns[ip] <= 1 {
+		delete(m.ipConns, ip)
+		return
+	}
+	m.ipConns[ip]--
+}
+
+// connectionsFromIP возвращает число открытых соединений с ip
+func (m *Manager) connectionsFromIP(ip string) int {
+	m.connMu.Lock()
+	defer m.connMu.Unlock()
+	return m.ipConns[ip]
+}
+
+// ClientCount возвращает число подключенных клиентов
+func (m *Manager) ClientCount() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.clients)
+}
+
+// RunSystemStatus раз в interval отправляет всем клиентам system_status
+// Пока клиентов нет, сообщение не отправляется. Завершается вместе с ctx
+func (m *Manager) RunSystemStatus(ctx context.Context, interval time.Duration, status StatusFunc) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			clients := m.ClientCount()
+			if clients == 0 {
+				continue
+			}
+			current := status()
+			current.Clients = clients
+			m.BroadcastSystemStatus(current)
 		}
 	}
 }
 
+// BroadcastSystemStatus отправляет состояние сервера всем клиентам
+// (без TaskID, поэтому независимо от подписки на задачу)
+func (m *Manager) BroadcastSystemStatus(status SystemStatus) {
+	m.Broadcast(Message{
+		Type:    MessageTypeSystemStatus,
+		Payload: status,
+	})
+}
+
+// BroadcastCacheRebuild отправляет состояние перестройки кэша
+func (m *Manager) BroadcastCacheRebuild(job interface{}) {
+	m.Broadcast(Message{
+		Type:    MessageTypeCacheRebuild,
+		Payload: job,
+	})
+}
+
 // RegisterClient регистрирует нового клиента
 func (m *Manager) RegisterClient(client *Client) {
 	m.register <- client
@@ -109,7 +324,17 @@ func (m *Manager) Broadcast(message Message) {
 }
 
 // BroadcastTaskUpdate отправляет обновление по задаче
+// Отложенный прогресс задачи отправляется раньше, чтобы не прийти после смены статуса
 func (m *Manager) BroadcastTaskUpdate(taskID, status string, payload interface{}) {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	if state, ok := m.progress[taskID]; ok {
+		delete(m.progress, taskID)
+		if state.pending != nil {
+			m.Broadcast(*state.pending)
+		}
+	}
+
 	m.Broadcast(Message{
 		Type:   MessageTypeTaskUpdate,
 		TaskID: taskID,
@@ -120,9 +345,69 @@ func (m *Manager) BroadcastTaskUpdate(taskID, status string, payload interface{}
 	})
 }
 
+// SetProgressInterval задает интервал объединения task_progress (0 - без объединения)
+func (m *Manager) SetProgressInterval(interval time.Duration) {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+	m.progressInterval = interval
+}
+
 // BroadcastTaskProgress отправляет прогресс обработки
+// По задаче уходит не больше одного сообщения за интервал (см. SetProgressInterval):
+// первое сразу, из пришедших за интервал - только последнее по его окончании.
+// Завершающий прогресс (current >= total) отправляется сразу и отменяет отложенный
 func (m *Manager) BroadcastTaskProgress(taskID string, current, total int, stage string) {
-	m.Broadcast(Message{
+	message := NewTaskProgressMessage(taskID, current, total, stage)
+
+	// Отправка под блокировкой: иначе отложенный прогресс мог бы обогнать завершающий
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+
+	if m.progressInterval <= 0 {
+		m.Broadcast(message)
+		return
+	}
+
+	state, ok := m.progress[taskID]
+	if current >= total {
+		delete(m.progress, taskID)
+		m.Broadcast(message)
+		return
+	}
+	if ok {
+		state.pending = &message
+		return
+	}
+
+	state = &taskProgress{}
+	m.progress[taskID] = state
+	m.Broadcast(message)
+	time.AfterFunc(m.progressInterval, func() { m.flushProgress(taskID, state) })
+}
+
+// flushProgress завершает интервал задачи: отправляет отложенный прогресс и начинает
+// следующий интервал, а если за интервал ничего не пришло - забывает задачу
+func (m *Manager) flushProgress(taskID string, state *taskProgress) {
+	m.progressMu.Lock()
+	defer m.progressMu.Unlock()
+
+	// Задача уже завершена (или начала новый интервал после завершения)
+	if m.progress[taskID] != state {
+		return
+	}
+	if state.pending == nil {
+		delete(m.progress, taskID)
+		return
+	}
+
+	m.Broadcast(*state.pending)
+	state.pending = nil
+	time.AfterFunc(m.progressInterval, func() { m.flushProgress(taskID, state) })
+}
+
+// NewTaskProgressMessage создает сообщение о прогрессе обработки задачи
+func NewTaskProgressMessage(taskID string, current, total int, stage string) Message {
+	return Message{
 		Type:   MessageTypeTaskProgress,
 		TaskID: taskID,
 		Payload: map[string]interface{}{
@@ -131,10 +416,23 @@ func (m *Manager) BroadcastTaskProgress(taskID string, current, total int, stage
 			"stage":   stage,
 			"percent": float64(current) / float64(total) * 100,
 		},
+	}
+}
+
+// BroadcastFacesSaved отправляет пачку сохраненных лиц задачи
+// Лица группируются, чтобы задача на тысячи лиц не забивала канал клиента
+func (m *Manager) BroadcastFacesSaved(taskID string, faces []FaceSaved) {
+	m.Broadcast(Message{
+		Type:   MessageTypeFaceSaved,
+		TaskID: taskID,
+		Payload: map[string]interface{}{
+			"faces": faces,
+			"count": len(faces),
+		},
 	})
 }
 
-// BroadcastStatsUpdate отправляет обновление статистики
+// BroadcastStatsUpdate отправляет обновление статистики (сразу, без debounce)
 func (m *Manager) BroadcastStatsUpdate(stats interface{}) {
 	m.Broadcast(Message{
 		Type:    MessageTypeStatsUpdate,
@@ -142,6 +440,82 @@ func (m *Manager) BroadcastStatsUpdate(stats interface{}) {
 	})
 }
 
+// SetStatsSource задает источник статистики для NotifyStatsChanged и /api/stats/stream
+// Уведомления за debounce объединяются в один пересчет
+func (m *Manager) SetStatsSource(stats StatsFunc, debounce time.Duration) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	m.stats = stats
+	m.statsDebounce = debounce
+}
+
+// NotifyStatsChanged сообщает, что данные изменились и статистику надо пересчитать
+// Пересчет выполняется через debounce после первого уведомления: серия быстрых правок
+// дает один запрос к БД и одно сообщение stats_update. Без клиентов ничего не считается
+func (m *Manager) NotifyStatsChanged() {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	if m.stats == nil || m.statsPending {
+		return
+	}
+	m.statsPending = true
+	time.AfterFunc(m.statsDebounce, m.flushStats)
+}
+
+// flushStats пересчитывает статистику и отправляет ее клиентам
+func (m *Manager) flushStats() {
+	// Изменения во время пересчета запланируют следующий
+	m.statsMu.Lock()
+	m.statsPending = false
+	m.statsMu.Unlock()
+
+	if m.ClientCount() == 0 {
+		return
+	}
+	if message, ok := m.statsMessage(); ok {
+		m.Broadcast(message)
+	}
+}
+
+// statsMessage считает текущую статистику (ok = false - источника нет или ошибка)
+func (m *Manager) statsMessage() (Message, bool) {
+	m.statsMu.Lock()
+	stats := m.stats
+	m.statsMu.Unlock()
+
+	if stats == nil {
+		return Message{}, false
+	}
+	current, err := stats()
+	if err != nil {
+		log.Printf("⚠️  WebSocket: не удалось пересчитать статистику: %v", err)
+		return Message{}, false
+	}
+	return Message{Type: MessageTypeStatsUpdate, Payload: current}, true
+}
+
+// BroadcastPersonEvent отправляет событие по человеку всем клиентам
+// (без TaskID, поэтому независимо от подписки на задачу)
+func (m *Manager) BroadcastPersonEvent(messageType MessageType, id int, name string) {
+	m.Broadcast(Message{
+		Type: messageType,
+		Payload: map[string]interface{}{
+			"id":   id,
+			"name": name,
+		},
+	})
+}
+
+// BroadcastTaskCreated отправляет новую задачу всем клиентам
+// (без TaskID: подписчиков у новой задачи еще нет, событие нужно списку задач)
+func (m *Manager) BroadcastTaskCreated(task interface{}) {
+	m.Broadcast(Message{
+		Type:    MessageTypeTaskCreated,
+		Payload: task,
+	})
+}
+
 // ReadPump читает сообщения от клиента
 func (c *Client) ReadPump(manager *Manager) {
 	defer func() {
@@ -152,7 +526,9 @@ func (c *Client) ReadPump(manager *Manager) {
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+			if err == websocket.ErrReadLimit {
+				log.Printf("WebSocket: клиент %s отключен - сообщение больше лимита", c.ID)
+			} else if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("WebSocket error: %v", err)
 			}
 			break
@@ -164,12 +540,21 @@ func (c *Client) ReadPump(manager *Manager) {
 }
 
 // WritePump отправляет сообщения клиенту
+// Завершается при отключении клиента менеджером (Done) или ошибке записи и закрывает
+// соединение - тогда ReadPump тоже завершается и отменяет регистрацию
 func (c *Client) WritePump() {
 	defer func() {
 		c.Conn.Close()
 	}()
 
-	for message := range c.Send {
+	for {
+		var message Message
+		select {
+		case <-c.Done():
+			return
+		case message = <-c.Send:
+		}
+
 		w, err := c.Conn.NextWriter(websocket.TextMessage)
 		if err != nil {
 			return
@@ -0,0 +1,354 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestClient(id, taskID string) *Client {
+	return &Client{ID: id, Send: make(chan Message, 8), TaskID: taskID}
+}
+
+func TestManagerClientCount(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	assert.Equal(t, 0, m.ClientCount())
+
+	first := newTestClient("c1", "")
+	second := newTestClient("c2", "task-1")
+	m.RegisterClient(first)
+	m.RegisterClient(second)
+	assert.Eventually(t, func() bool { return m.ClientCount() == 2 }, time.Second, 5*time.Millisecond)
+
+	m.UnregisterClient(first)
+	assert.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Повторное отключение не меняет счетчик
+	m.UnregisterClient(first)
+	m.UnregisterClient(second)
+	assert.Eventually(t, func() bool { return m.ClientCount() == 0 }, time.Second, 5*time.Millisecond)
+}
+
+func TestManagerRunSystemStatus(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	// Клиент, подписанный на задачу, тоже получает system_status
+	client := newTestClient("c1", "task-1")
+	m.RegisterClient(client)
+	require.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.RunSystemStatus(ctx, 10*time.Millisecond, func() SystemStatus {
+		return SystemStatus{ActiveTasks: 2, QueueDepth: 5}
+	})
+
+	select {
+	case message := <-client.Send:
+		assert.Equal(t, MessageTypeSystemStatus, message.Type)
+		assert.Empty(t, message.TaskID)
+		assert.Equal(t, SystemStatus{Clients: 1, ActiveTasks: 2, QueueDepth: 5}, message.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("system_status не отправлен")
+	}
+}
+
+// countingStats возвращает источник статистики, считающий свои вызовы
+func countingStats(calls *int32) StatsFunc {
+	return func() (interface{}, error) {
+		n := atomic.AddInt32(calls, 1)
+		return map[string]int{"total_persons": int(n)}, nil
+	}
+}
+
+func TestManagerNotifyStatsChangedDebounces(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	var calls int32
+	m.SetStatsSource(countingStats(&calls), 20*time.Millisecond)
+
+	client := newTestClient("c1", "")
+	m.RegisterClient(client)
+	require.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Серия правок - один пересчет и одно сообщение
+	for i := 0; i < 10; i++ {
+		m.NotifyStatsChanged()
+	}
+
+	select {
+	case message := <-client.Send:
+		assert.Equal(t, MessageTypeStatsUpdate, message.Type)
+		assert.Equal(t, map[string]int{"total_persons": 1}, message.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("stats_update не отправлен")
+	}
+	select {
+	case message := <-client.Send:
+		t.Fatalf("лишнее сообщение %s", message.Type)
+	case <-time.After(60 * time.Millisecond):
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// Следующая правка после пересчета снова отправляет статистику
+	m.NotifyStatsChanged()
+	select {
+	case message := <-client.Send:
+		assert.Equal(t, map[string]int{"total_persons": 2}, message.Payload)
+	case <-time.After(time.Second):
+		t.Fatal("stats_update не отправлен")
+	}
+}
+
+func TestManagerNotifyStatsChangedWithoutClients(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	var calls int32
+	m.SetStatsSource(countingStats(&calls), time.Millisecond)
+
+	m.NotifyStatsChanged()
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	// Без источника уведомление ничего не делает
+	NewManager().NotifyStatsChanged()
+}
+
+func TestManagerStatsOnlyClient(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	stats := &Client{ID: "stats", Send: make(chan Message, 8), StatsOnly: true}
+	regular := newTestClient("regular", "")
+	m.RegisterClient(stats)
+	m.RegisterClient(regular)
+	require.Eventually(t, func() bool { return m.ClientCount() == 2 }, time.Second, 5*time.Millisecond)
+
+	m.BroadcastPersonEvent(MessageTypePersonUpdated, 1, "Alice")
+	m.BroadcastSystemStatus(SystemStatus{Clients: 2})
+	m.BroadcastStatsUpdate(map[string]int{"total_persons": 3})
+
+	// Обычный клиент получает все три сообщения, клиент статистики - только stats_update
+	for _, want := range []MessageType{MessageTypePersonUpdated, MessageTypeSystemStatus, MessageTypeStatsUpdate} {
+		select {
+		case message := <-regular.Send:
+			assert.Equal(t, want, message.Type)
+		case <-time.After(time.Second):
+			t.Fatalf("сообщение %s не получено", want)
+		}
+	}
+	select {
+	case message := <-stats.Send:
+		assert.Equal(t, MessageTypeStatsUpdate, message.Type)
+	case <-time.After(time.Second):
+		t.Fatal("stats_update не получен")
+	}
+	assert.Empty(t, stats.Send)
+}
+
+func TestManagerBroadcastTaskCreated(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	// task_created идет всем: и клиенту другой задачи, и клиенту без подписки
+	other := newTestClient("other", "task-1")
+	global := newTestClient("global", "")
+	m.RegisterClient(other)
+	m.RegisterClient(global)
+	require.Eventually(t, func() bool { return m.ClientCount() == 2 }, time.Second, 5*time.Millisecond)
+
+	m.BroadcastTaskCreated(map[string]string{"id": "task-2"})
+
+	for _, client := range []*Client{other, global} {
+		select {
+		case message := <-client.Send:
+			assert.Equal(t, MessageTypeTaskCreated, message.Type)
+			assert.Empty(t, message.TaskID)
+			assert.Equal(t, map[string]string{"id": "task-2"}, message.Payload)
+		case <-time.After(time.Second):
+			t.Fatalf("task_created не получен клиентом %s", client.ID)
+		}
+	}
+}
+
+// receiveProgress ждет task_progress и возвращает его current
+func receiveProgress(t *testing.T, client *Client) int {
+	t.Helper()
+	select {
+	case message := <-client.Send:
+		require.Equal(t, MessageTypeTaskProgress, message.Type)
+		return message.Payload.(map[string]interface{})["current"].(int)
+	case <-time.After(time.Second):
+		t.Fatal("task_progress не отправлен")
+		return 0
+	}
+}
+
+// assertNoMessage проверяет, что за wait клиенту ничего не пришло
+func assertNoMessage(t *testing.T, client *Client, wait time.Duration) {
+	t.Helper()
+	select {
+	case message := <-client.Send:
+		t.Fatalf("лишнее сообщение %s: %v", message.Type, message.Payload)
+	case <-time.After(wait):
+	}
+}
+
+func TestManagerTaskProgressCoalesces(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+	m.SetProgressInterval(30 * time.Millisecond)
+
+	client := newTestClient("c1", "task-1")
+	m.RegisterClient(client)
+	require.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Первый прогресс - сразу, серия за интервал - одним последним сообщением
+	for i := 1; i <= 50; i++ {
+		m.BroadcastTaskProgress("task-1", i, 100, "Сохранение")
+	}
+	assert.Equal(t, 1, receiveProgress(t, client))
+	assert.Equal(t, 50, receiveProgress(t, client))
+	assertNoMessage(t, client, 80*time.Millisecond)
+
+	// Завершающий прогресс не ждет интервала и отменяет отложенный
+	m.BroadcastTaskProgress("task-1", 60, 100, "Сохранение")
+	m.BroadcastTaskProgress("task-1", 70, 100, "Сохранение")
+	m.BroadcastTaskProgress("task-1", 100, 100, "Готово!")
+	assert.Equal(t, 60, receiveProgress(t, client))
+	assert.Equal(t, 100, receiveProgress(t, client))
+	assertNoMessage(t, client, 80*time.Millisecond)
+
+	m.progressMu.Lock()
+	assert.Empty(t, m.progress)
+	m.progressMu.Unlock()
+}
+
+func TestManagerTaskUpdateFlushesProgress(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+	m.SetProgressInterval(time.Hour)
+
+	client := newTestClient("c1", "task-1")
+	m.RegisterClient(client)
+	require.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+
+	// Отложенный прогресс уходит раньше смены статуса, а не после нее
+	m.BroadcastTaskProgress("task-1", 10, 100, "Отправка в Python")
+	m.BroadcastTaskProgress("task-1", 70, 100, "Сохранение")
+	m.BroadcastTaskUpdate("task-1", "failed", nil)
+
+	assert.Equal(t, 10, receiveProgress(t, client))
+	assert.Equal(t, 70, receiveProgress(t, client))
+	select {
+	case message := <-client.Send:
+		assert.Equal(t, MessageTypeTaskUpdate, message.Type)
+	case <-time.After(time.Second):
+		t.Fatal("task_update не отправлен")
+	}
+}
+
+func TestManagerStatsMessageError(t *testing.T) {
+	m := NewManager()
+	m.SetStatsSource(func() (interface{}, error) { return nil, errors.New("db down") }, time.Second)
+
+	_, ok := m.statsMessage()
+	assert.False(t, ok)
+}
+
+func TestClientTrySendAfterStop(t *testing.T) {
+	client := &Client{ID: "c1", Send: make(chan Message, 1)}
+	assert.True(t, client.trySend(Message{Type: MessageTypeStatsUpdate}))
+	assert.False(t, client.trySend(Message{Type: MessageTypeStatsUpdate}), "буфер переполнен")
+
+	<-client.Send
+	client.stop()
+	client.stop() // Повторное отключение не паникует
+	assert.False(t, client.trySend(Message{Type: MessageTypeStatsUpdate}), "клиент отключен")
+
+	select {
+	case <-client.Done():
+	default:
+		t.Fatal("Done не закрыт после stop")
+	}
+}
+
+func TestManagerSlowClientsUnderLoad(t *testing.T) {
+	m := NewManager()
+	go m.Run()
+
+	// Медленные клиенты не читают Send и отключаются при переполнении буфера,
+	// а одновременно с этим их ReadPump отменяет регистрацию
+	var slow []*Client
+	for i := 0; i < 50; i++ {
+		client := &Client{ID: fmt.Sprintf("slow-%d", i), Send: make(chan Message, 1), IP: "10.0.0.1"}
+		require.True(t, m.acquireIP(client.IP))
+		m.RegisterClient(client)
+		slow = append(slow, client)
+	}
+	fast := &Client{ID: "fast", Send: make(chan Message, 4096)}
+	m.RegisterClient(fast)
+	require.Eventually(t, func() bool { return m.ClientCount() == 51 }, time.Second, 5*time.Millisecond)
+
+	var received atomic.Int32
+	go func() {
+		for {
+			select {
+			case <-fast.Send:
+				received.Add(1)
+			case <-fast.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				m.Broadcast(Message{Type: MessageTypeSystemStatus})
+			}
+		}()
+	}
+	for _, client := range slow {
+		wg.Add(1)
+		go func(client *Client) {
+			defer wg.Done()
+			m.UnregisterClient(client)
+			m.UnregisterClient(client)
+		}(client)
+	}
+	wg.Wait()
+
+	for _, client := range slow {
+		select {
+		case <-client.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("клиент %s не отключен", client.ID)
+		}
+	}
+	assert.Eventually(t, func() bool { return m.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+	assert.Zero(t, m.connectionsFromIP("10.0.0.1"), "места соединений освобождены ровно один раз")
+
+	// Быстрый клиент остается подключенным и получает все сообщения
+	assert.Eventually(t, func() bool { return received.Load() == 8*200 }, time.Second, 5*time.Millisecond)
+	select {
+	case <-fast.Done():
+		t.Fatal("быстрый клиент отключен")
+	default:
+	}
+}
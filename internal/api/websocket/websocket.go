@@ -3,57 +3,169 @@ package websocket
 import (
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
+// Limits - размеры буферов соединения и максимальный размер входящего сообщения
+type Limits struct {
+	ReadBufferSize  int   // Буфер чтения в байтах
+	WriteBufferSize int   // Буфер записи в байтах
+	MaxMessageSize  int64 // Сообщение больше этого размера закрывает соединение
+}
+
+// defaultLimits - клиенты шлют серверу только короткие служебные сообщения
+var defaultLimits = Limits{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	// Разрешаем все origins (в продакшене нужно ограничить)
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+	MaxMessageSize:  4096,
+}
+
+// newUpgrader создает upgrader с заданными размерами буферов
+func newUpgrader(limits Limits) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  limits.ReadBufferSize,
+		WriteBufferSize: limits.WriteBufferSize,
+		// Разрешаем все origins (в продакшене нужно ограничить)
+		CheckOrigin: func(r *http.Request) bool {
+			return true
+		},
+	}
 }
 
+// SnapshotFunc возвращает текущее состояние задачи для только что подключившегося клиента
+// ok = false, если задачи нет или состояние неизвестно
+type SnapshotFunc func(taskID string) (message Message, ok bool)
+
 // Handler обрабатывает WebSocket подключения
 type Handler struct {
-	manager *Manager
+	manager  *Manager
+	snapshot SnapshotFunc
+	upgrader websocket.Upgrader
+	limits   Limits
 }
 
 // NewHandler создает новый WebSocket handler
 func NewHandler(manager *Manager) *Handler {
 	return &Handler{
-		manager: manager,
+		manager:  manager,
+		upgrader: newUpgrader(defaultLimits),
+		limits:   defaultLimits,
 	}
 }
 
+// SetLimits задает размеры буферов и максимальный размер входящего сообщения
+// Нулевые значения заменяются значениями по умолчанию
+func (h *Handler) SetLimits(limits Limits) {
+	if limits.ReadBufferSize <= 0 {
+		limits.ReadBufferSize = defaultLimits.ReadBufferSize
+	}
+	if limits.WriteBufferSize <= 0 {
+		limits.WriteBufferSize = defaultLimits.WriteBufferSize
+	}
+	if limits.MaxMessageSize <= 0 {
+		limits.MaxMessageSize = defaultLimits.MaxMessageSize
+	}
+	h.upgrader = newUpgrader(limits)
+	h.limits = limits
+}
+
+// SetSnapshot задает источник текущего прогресса задачи
+// Клиент, подключившийся посреди обработки, сразу получает последнее состояние,
+// а не ждет следующего события
+func (h *Handler) SetSnapshot(snapshot SnapshotFunc) {
+	h.snapshot = snapshot
+}
+
 // HandleWebSocket обрабатывает WebSocket подключение
 func (h *Handler) HandleWebSocket(c *gin.Context) {
 	// Получаем taskID из query параметра
 	taskID := c.Query("task_id")
 
-	// Апгрейдим HTTP соединение до WebSocket
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	client := h.upgrade(c)
+	if client == nil {
+		return
+	}
+	client.TaskID = taskID
+
+	// Текущее состояние кладем в очередь до регистрации: все события,
+	// отправленные после регистрации, придут уже после него
+	if taskID != "" && h.snapshot != nil {
+		if message, ok := h.snapshot(taskID); ok {
+			client.trySend(message)
+		}
+	}
+
+	h.start(client)
+}
+
+// HandleStatsStream подключает клиента, которому нужна только статистика
+// (GET /api/stats/stream): сразу после подключения приходит текущая статистика,
+// затем stats_update после изменений. События задач, людей и system_status
+// такому клиенту не отправляются
+func (h *Handler) HandleStatsStream(c *gin.Context) {
+	client := h.upgrade(c)
+	if client == nil {
+		return
+	}
+	client.StatsOnly = true
+
+	if message, ok := h.manager.statsMessage(); ok {
+		client.trySend(message)
+	}
+
+	h.start(client)
+}
+
+// upgrade апгрейдит HTTP соединение до WebSocket и создает клиента
+// nil - апгрейд не удался или с IP клиента открыто слишком много соединений
+// (ответ клиенту уже отправлен)
+func (h *Handler) upgrade(c *gin.Context) *Client {
+	ip := c.ClientIP()
+	if !h.manager.acquireIP(ip) {
+		h.reject(c, ip)
+		return nil
+	}
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
+		h.manager.releaseIP(ip)
 		log.Printf("Failed to upgrade to WebSocket: %v", err)
-		return
+		return nil
 	}
+	// Сообщение больше лимита завершает ReadMessage ошибкой, и ReadPump закрывает соединение
+	conn.SetReadLimit(h.limits.MaxMessageSize)
 
-	// Создаем клиента
-	client := &Client{
-		ID:     uuid.New().String(),
-		Conn:   conn,
-		Send:   make(chan Message, 256),
-		TaskID: taskID,
+	return &Client{
+		ID:   uuid.New().String(),
+		Conn: conn,
+		Send: make(chan Message, 256),
+		IP:   ip,
 	}
+}
+
+// reject закрывает соединение сверх лимита на IP с кодом 1008 (policy violation)
+// Апгрейд выполняется, чтобы браузер получил код закрытия, а не безымянную ошибку рукопожатия
+func (h *Handler) reject(c *gin.Context, ip string) {
+	log.Printf("⚠️  WebSocket: отклонено соединение с %s - превышен лимит соединений с одного IP", ip)
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "слишком много соединений с одного IP")
+	conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
 
-	// Регистрируем клиента
+// start регистрирует клиента и запускает горутины чтения и записи
+func (h *Handler) start(client *Client) {
 	h.manager.RegisterClient(client)
 
-	// Запускаем горутины для чтения и записи
 	go client.WritePump()
 	go client.ReadPump(h.manager)
 }
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dialTestServer поднимает /ws с заданными лимитами и подключается к нему
+func dialTestServer(t *testing.T, limits Limits) *websocket.Conn {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	manager := NewManager()
+	go manager.Run()
+
+	handler := NewHandler(manager)
+	handler.SetLimits(limits)
+
+	router := gin.New()
+	router.GET("/ws", handler.HandleWebSocket)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestHandleWebSocketClosesOnOversizedMessage(t *testing.T) {
+	conn := dialTestServer(t, Limits{MaxMessageSize: 16})
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte(strings.Repeat("x", 64))))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := conn.ReadMessage()
+	require.Error(t, err)
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig), "ожидали закрытие 1009, получили %v", err)
+}
+
+func TestHandleWebSocketAcceptsMessageWithinLimit(t *testing.T) {
+	conn := dialTestServer(t, Limits{MaxMessageSize: 16})
+
+	require.NoError(t, conn.WriteMessage(websocket.TextMessage, []byte("ping")))
+
+	// Сервер на сообщения не отвечает: соединение остается открытым до дедлайна
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, _, err := conn.ReadMessage()
+	require.Error(t, err)
+	assert.False(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+	var netErr interface{ Timeout() bool }
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+}
+
+func TestHandleStatsStreamSendsCurrentStats(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager := NewManager()
+	go manager.Run()
+	manager.SetStatsSource(func() (interface{}, error) {
+		return map[string]int{"total_persons": 7}, nil
+	}, time.Millisecond)
+
+	router := gin.New()
+	router.GET("/api/stats/stream", NewHandler(manager).HandleStatsStream)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/stats/stream", nil)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Сразу после подключения - текущая статистика
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var message struct {
+		Type    MessageType    `json:"type"`
+		Payload map[string]int `json:"payload"`
+	}
+	require.NoError(t, conn.ReadJSON(&message))
+	assert.Equal(t, MessageTypeStatsUpdate, message.Type)
+	assert.Equal(t, 7, message.Payload["total_persons"])
+
+	// События задач и людей клиенту статистики не приходят
+	require.Eventually(t, func() bool { return manager.ClientCount() == 1 }, time.Second, 5*time.Millisecond)
+	manager.BroadcastPersonEvent(MessageTypePersonCreated, 1, "person_1")
+	manager.NotifyStatsChanged()
+
+	require.NoError(t, conn.ReadJSON(&message))
+	assert.Equal(t, MessageTypeStatsUpdate, message.Type)
+}
+
+func TestHandlerSetLimitsDefaults(t *testing.T) {
+	handler := NewHandler(NewManager())
+	handler.SetLimits(Limits{WriteBufferSize: 2048})
+
+	assert.Equal(t, defaultLimits.ReadBufferSize, handler.upgrader.ReadBufferSize)
+	assert.Equal(t, 2048, handler.upgrader.WriteBufferSize)
+	assert.Equal(t, defaultLimits.MaxMessageSize, handler.limits.MaxMessageSize)
+}
+
+func TestHandleWebSocketLimitsConnectionsPerIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	manager := NewManager()
+	go manager.Run()
+	manager.SetMaxConnectionsPerIP(2)
+
+	router := gin.New()
+	router.GET("/ws", NewHandler(manager).HandleWebSocket)
+	server := httptest.NewServer(router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	dial := func() *websocket.Conn {
+		conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+
+	first := dial()
+	dial()
+	require.Eventually(t, func() bool { return manager.ClientCount() == 2 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 2, manager.connectionsFromIP("127.0.0.1"))
+
+	// Третье соединение с того же IP закрывается с кодом 1008 и не регистрируется
+	rejected := dial()
+	rejected.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err := rejected.ReadMessage()
+	require.Error(t, err)
+	assert.True(t, websocket.IsCloseError(err, websocket.ClosePolicyViolation), "ожидали закрытие 1008, получили %v", err)
+	assert.Equal(t, 2, manager.ClientCount())
+	assert.Equal(t, 2, manager.connectionsFromIP("127.0.0.1"))
+
+	// После отключения клиента место освобождается
+	first.Close()
+	require.Eventually(t, func() bool { return manager.connectionsFromIP("127.0.0.1") == 1 }, time.Second, 5*time.Millisecond)
+	dial()
+	require.Eventually(t, func() bool { return manager.ClientCount() == 2 }, time.Second, 5*time.Millisecond)
+}
+
+func TestManagerAcquireReleaseIP(t *testing.T) {
+	manager := NewManager()
+
+	// Без лимита соединения считаются, но не отклоняются
+	for i := 0; i < 3; i++ {
+		assert.True(t, manager.acquireIP("10.0.0.1"))
+	}
+	assert.Equal(t, 3, manager.connectionsFromIP("10.0.0.1"))
+
+	manager.SetMaxConnectionsPerIP(3)
+	assert.False(t, manager.acquireIP("10.0.0.1"))
+	assert.True(t, manager.acquireIP("10.0.0.2"))
+
+	manager.releaseIP("10.0.0.1")
+	assert.True(t, manager.acquireIP("10.0.0.1"))
+
+	for i := 0; i < 3; i++ {
+		manager.releaseIP("10.0.0.1")
+	}
+	assert.Equal(t, 0, manager.connectionsFromIP("10.0.0.1"))
+	assert.NotContains(t, manager.ipConns, "10.0.0.1")
+}
@@ -0,0 +1,26 @@
+package audit
+
+import "context"
+
+// Исполнители изменений в журнале person_audit
+const (
+	ActorSystem    = "system"    // Фоновая работа сервера без запроса (восстановление задач и т.п.)
+	ActorAnonymous = "anonymous" // Запрос к API без токена
+	ActorAdmin     = "admin"     // Запрос с токеном администратора (ADMIN_TOKEN)
+)
+
+// actorKey - ключ исполнителя в context.Context
+type actorKey struct{}
+
+// WithActor возвращает контекст, изменения в котором записываются в журнал от имени actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// Actor возвращает исполнителя из контекста (ActorSystem, если он не задан)
+func Actor(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return ActorSystem
+}
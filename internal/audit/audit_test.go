@@ -0,0 +1,18 @@
+package audit
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestActor(t *testing.T) {
+	assert.Equal(t, ActorSystem, Actor(context.Background()))
+	assert.Equal(t, ActorSystem, Actor(WithActor(context.Background(), "")))
+
+	ctx := WithActor(context.Background(), ActorAnonymous)
+	assert.Equal(t, ActorAnonymous, Actor(ctx))
+	// Вложенный контекст переопределяет исполнителя
+	assert.Equal(t, ActorAdmin, Actor(WithActor(ctx, ActorAdmin)))
+}
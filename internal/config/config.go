@@ -2,24 +2,72 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"face-recognition/internal/models"
+	"face-recognition/pkg/embedding"
 )
 
 // Config содержит всю конфигурацию приложения
 type Config struct {
-	Server   ServerConfig
-	Database DatabaseConfig
-	Storage  StorageConfig
-	Python   PythonConfig
-	Redis    RedisConfig
+	Server     ServerConfig
+	Static     StaticConfig
+	Database   DatabaseConfig
+	Storage    StorageConfig
+	Python     PythonConfig
+	Redis      RedisConfig
+	Cache      CacheConfig
+	Thumbnails ThumbnailsConfig
+	Webhooks   WebhooksConfig
+	Compare    CompareConfig
+	Detection  DetectionConfig
+	URLUpload  URLUploadConfig
+	Tracing    TracingConfig
+	WebSocket  WebSocketConfig
+	Queue      QueueConfig
+	Persons    PersonsConfig
+	Import     ImportConfig
+	Video      VideoConfig
 }
 
 // ServerConfig - настройки HTTP сервера
 type ServerConfig struct {
-	Port string
-	Host string
+	Port        string
+	Host        string
+	MaxBodySize int64  // Максимальный размер тела запроса в байтах (413 при превышении)
+	AdminToken  string // Bearer токен админских эндпоинтов; пусто - эндпоинты отключены
+
+	// Максимум файлов в одной загрузке (400 при превышении); 0 - без ограничения
+	MaxFilesPerUpload int
+
+	// Сколько байт multipart формы держится в памяти; остальное пишется во временные файлы
+	MultipartMemory int64
 }
 
+// DefaultMaxBodySizeMB - лимит тела запроса по умолчанию
+const DefaultMaxBodySizeMB = 100
+
+// DefaultMaxFilesPerUpload - максимум файлов в одной загрузке по умолчанию
+const DefaultMaxFilesPerUpload = 1000
+
+// DefaultMultipartMemoryMB - порог памяти multipart формы по умолчанию (как в gin)
+const DefaultMultipartMemoryMB = 32
+
+// StaticConfig - раздача веб-интерфейса
+type StaticConfig struct {
+	Enabled bool   // false - только API: / и /static не регистрируются (статику отдает, например, nginx)
+	Dir     string // Папка, раздаваемая по /static
+	Index   string // Файл, отдаваемый по / (по умолчанию index.html в Dir)
+}
+
+// DefaultStaticDir - папка веб-интерфейса по умолчанию
+const DefaultStaticDir = "./web/static"
+
 // DatabaseConfig - настройки базы данных
 type DatabaseConfig struct {
 	Host     string
@@ -28,33 +76,298 @@ type DatabaseConfig struct {
 	Password string
 	DBName   string
 	SSLMode  string
+
+	// Read-only реплики ("host" или "host:port"); учетные данные и база - как у primary
+	ReadHosts []string
+
+	// Пул соединений
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration // 0 - соединения не пересоздаются
+
+	// Применять встроенные миграции (migrations/) при старте
+	RunMigrations bool
 }
 
+// Значения пула соединений по умолчанию
+const (
+	DefaultMaxOpenConns    = 25
+	DefaultMaxIdleConns    = 5
+	DefaultConnMaxLifetime = 30 * time.Minute
+)
+
+// DefaultResultsURL - префикс раздачи RESULTS_DIR по умолчанию
+const DefaultResultsURL = "/results"
+
 // StorageConfig - настройки хранилища файлов
 type StorageConfig struct {
-	UploadsDir string
-	ResultsDir string
+	Backend     string // local или s3
+	UploadsDir  string
+	ResultsDir  string // Результаты обработки (аннотированные фото от Python) - всегда на диске
+	ResultsURL  string // Префикс, по которому Go раздает ResultsDir
+	ConvertHEIF bool   // HEIC/HEIF с iPhone конвертируются в JPEG перед отправкой в Python
+	S3          S3Config
+
+	UploadConcurrency int // Сколько файлов одной загрузки сохраняется параллельно
+
+	// Изображения больше (по размерам из заголовка) отклоняются до декодирования
+	// в Go и Python - защита от "декомпрессионных бомб" (MAX_IMAGE_MEGAPIXELS, 0 - без ограничения)
+	MaxImagePixels int64
+
+	OutputFormat string // Формат миниатюр и вырезок лиц: jpeg, webp или avif
+
+	// Имена сохраняемых загрузок: original, uuid или hash (исходное имя хранится в БД)
+	FileNaming string
+
+	// Очистка файлов, на которые не ссылается ни одна запись в БД
+	OrphanCleanupInterval time.Duration // 0 - очистка выключена
+	OrphanGracePeriod     time.Duration // Более новые файлы не трогаем - их запись может еще создаваться
 }
 
-// PythonConfig - настройки Python сервера
+// DefaultUploadConcurrency - параллельность сохранения файлов загрузки по умолчанию
+const DefaultUploadConcurrency = 4
+
+// DefaultMaxImageMegapixels - предел размера изображения по умолчанию (в мегапикселях)
+const DefaultMaxImageMegapixels = 100
+
+// DefaultOrphanGracePeriod - минимальный возраст файла-сироты, который можно удалить
+const DefaultOrphanGracePeriod = 24 * time.Hour
+
+// S3Config - настройки S3-совместимого хранилища (AWS S3, MinIO)
+type S3Config struct {
+	Endpoint  string
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	PublicURL string
+}
+
+// Бэкенды хранилища файлов
+const (
+	StorageBackendLocal = "local"
+	StorageBackendS3    = "s3"
+)
+
+// PythonConfig - настройки Python серверов
 type PythonConfig struct {
-	BaseURL string
+	BaseURLs       []string      // Запросы распределяются между серверами
+	HealthInterval time.Duration // Период проверки /health каждого сервера
+
+	// Таймауты запросов: /health и /compare отвечают быстро, /process - долго (InsightFace)
+	RequestTimeout time.Duration
+	ProcessTimeout time.Duration
+}
+
+// Таймауты запросов к Python по умолчанию
+const (
+	DefaultPythonRequestTimeout = 10 * time.Second
+	DefaultPythonProcessTimeout = 10 * time.Minute
+)
+
+// ThumbnailsConfig - настройки миниатюр лиц
+type ThumbnailsConfig struct {
+	Enabled bool
+	Size    int // Большая сторона миниатюры в пикселях
+}
+
+// WebhooksConfig - настройки уведомлений о завершении задач (callback_url)
+type WebhooksConfig struct {
+	AllowedHosts []string // Пустой список запрещает callback_url
+	Timeout      time.Duration
+	MaxRetries   int
+}
+
+// URLUploadConfig - настройки загрузки изображений по URL (POST /api/upload/urls)
+type URLUploadConfig struct {
+	MaxURLs      int           // Максимум URL в одном запросе
+	MaxFileSize  int64         // Максимальный размер одного изображения в байтах
+	Timeout      time.Duration // Таймаут скачивания одного изображения
+	AllowedHosts []string      // Пустой список - любой публичный хост
+	AllowPrivate bool          // Разрешить приватные и loopback адреса (только для разработки)
+}
+
+// TracingConfig - настройки трассировки OpenTelemetry
+// Без адреса коллектора трассировка выключена: спаны создаются no-op провайдером
+type TracingConfig struct {
+	Endpoint    string  // OTLP/HTTP адрес коллектора (http://otel-collector:4318)
+	ServiceName string  // Имя сервиса в трейсах
+	SampleRatio float64 // Доля трассируемых запросов (0-1); входящий traceparent учитывается
+}
+
+// QueueConfig - настройки очереди обработки задач
+type QueueConfig struct {
+	Workers int // Сколько задач обрабатывается одновременно (одновременных вызовов Python)
+	Size    int // Сколько задач может ждать в очереди; сверх этого загрузка отклоняется
+
+	Timeout time.Duration // Максимальное время обработки одной задачи; потом она переводится в failed
+}
+
+// Значения очереди обработки по умолчанию
+const (
+	DefaultQueueWorkers      = 2
+	DefaultQueueSize         = 1000
+	DefaultProcessingTimeout = 30 * time.Minute
+)
+
+// WebSocketConfig - настройки событий WebSocket
+type WebSocketConfig struct {
+	FaceEventsBatch    int           // Максимум лиц в одном сообщении face_saved
+	FaceEventsInterval time.Duration // Не чаще одного неполного face_saved за интервал
+	StatusInterval     time.Duration // Период сообщения system_status (0 - не отправлять)
+	StatsDebounce      time.Duration // Задержка пересчета stats_update после изменения данных
+	ProgressInterval   time.Duration // Не чаще одного task_progress задачи за интервал (0 - без объединения)
+
+	ReadBufferSize  int   // Буфер чтения соединения в байтах
+	WriteBufferSize int   // Буфер записи соединения в байтах
+	MaxMessageSize  int64 // Входящее сообщение больше этого размера закрывает соединение
+
+	MaxConnectionsPerIP int // Одновременных соединений с одного IP (0 - без ограничения)
+}
+
+// Значения событий face_saved по умолчанию
+const (
+	DefaultFaceEventsBatch    = 50
+	DefaultFaceEventsInterval = 500 * time.Millisecond
+	DefaultStatusInterval     = 5 * time.Second
+	DefaultStatsDebounce      = time.Second
+	DefaultProgressInterval   = 250 * time.Millisecond
+
+	DefaultWSReadBufferSize  = 1024
+	DefaultWSWriteBufferSize = 1024
+	DefaultWSMaxMessageSize  = 4096
+
+	DefaultWSMaxConnectionsPerIP = 20
+)
+
+// DetectionConfig - настройки детекции по умолчанию (переопределяются при загрузке)
+type DetectionConfig struct {
+	MinConfidence        float64 // Лица с меньшей уверенностью не сохраняются (0 - без фильтрации)
+	MaxInconsistentRatio float64 // Доля несогласованных лиц в ответе Python, выше которой задача падает
+	MaxOverlap           float64 // IoU рамок лиц одного фото, выше которого лица - одна детекция (0 - не объединять)
 }
 
+// PersonsConfig - ограничения на данные людей
+type PersonsConfig struct {
+	MaxNameLength int // Максимальная длина имени в символах (не больше размера колонки)
+	MaxFaces      int // Сколько лиц может накопить один человек (0 - без ограничения)
+}
+
+// ImportConfig - ограничения импорта людей из ZIP (POST /api/import)
+type ImportConfig struct {
+	MaxSize             int64 // Максимальный размер ZIP в байтах
+	MaxEntries          int   // Максимум записей в архиве (файлы и папки)
+	MaxFileSize         int64 // Максимальный размер одного файла после распаковки в байтах
+	MaxUncompressedSize int64 // Максимальный размер всех файлов после распаковки (защита от zip-бомб)
+}
+
+// Ограничения импорта по умолчанию
+const (
+	DefaultImportMaxSizeMB         = 100
+	DefaultImportMaxEntries        = 1000
+	DefaultImportMaxFileSizeMB     = 20
+	DefaultImportMaxUncompressedMB = 1024
+)
+
+// VideoConfig - обработка видео: кадры извлекает ffmpeg, в Python они уходят как фото
+type VideoConfig struct {
+	Enabled     bool          // false - видео в загрузке помечаются ошибкой
+	FFmpegPath  string        // Путь к ffmpeg (или имя в PATH)
+	FFprobePath string        // Путь к ffprobe (или имя в PATH)
+	FPS         float64       // Сколько кадров извлекается на секунду видео
+	MaxDuration time.Duration // Видео длиннее помечаются ошибкой
+	MaxSize     int64         // Видео больше (в байтах) помечаются ошибкой
+}
+
+// Ограничения обработки видео по умолчанию
+const (
+	DefaultVideoFPS         = 1.0
+	DefaultVideoMaxDuration = 5 * time.Minute
+	DefaultVideoMaxSizeMB   = 100
+)
+
+// CompareConfig - настройки сравнения embedding
+type CompareConfig struct {
+	Backend         string  // go (локально) или python (через /compare, для сверки)
+	MatchThreshold  float64 // Порог косинусного сходства для совпадения (только для go)
+	DedupeThreshold float64 // Порог сходства почти одинаковых лиц одного человека (dedupe)
+	EmbeddingDim    int     // Размерность embedding модели Python (512 для buffalo_*, 128 для части моделей)
+}
+
+// Бэкенды сравнения embedding
+const (
+	CompareBackendGo     = "go"
+	CompareBackendPython = "python"
+)
+
 // RedisConfig - настройки Redis
 type RedisConfig struct {
 	Addr     string
 	Password string
 	DB       int
+
+	// Пул соединений и таймауты: медленный Redis не должен блокировать запросы
+	PoolSize     int // 0 - по умолчанию go-redis (10 на CPU)
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
 }
 
+// Таймауты Redis по умолчанию
+const (
+	DefaultRedisDialTimeout  = 2 * time.Second
+	DefaultRedisReadTimeout  = 500 * time.Millisecond
+	DefaultRedisWriteTimeout = 500 * time.Millisecond
+)
+
+// CacheConfig - настройки in-memory кэша (используется, если Redis недоступен)
+type CacheConfig struct {
+	LRUSize int // Максимальное количество ключей
+
+	// Период фонового пересчета статистики: запросы получают последнюю посчитанную,
+	// изменения данных запускают пересчет сразу (0 - считать по запросу, через кэш)
+	StatsRefreshInterval time.Duration
+
+	// Период сверки счетчиков stats_summary с таблицами (0 - не сверять)
+	StatsReconcileInterval time.Duration
+
+	// Сколько людей и задач (новые первыми) загружает в кэш его перестройка
+	WarmPersons int
+	WarmTasks   int
+}
+
+// DefaultStatsRefreshInterval - период фонового пересчета статистики по умолчанию
+const DefaultStatsRefreshInterval = time.Minute
+
+// DefaultStatsReconcileInterval - период сверки счетчиков статистики по умолчанию
+const DefaultStatsReconcileInterval = time.Hour
+
+// Ограничения перестройки кэша по умолчанию
+const (
+	DefaultCacheWarmPersons = 1000
+	DefaultCacheWarmTasks   = 100
+)
+
 // Load загружает конфигурацию из переменных окружения
 // с fallback на значения по умолчанию
 func Load() *Config {
+	staticDir := getEnv("STATIC_DIR", DefaultStaticDir)
+
 	return &Config{
 		Server: ServerConfig{
-			Port: getEnv("SERVER_PORT", "8080"),
-			Host: getEnv("SERVER_HOST", "0.0.0.0"),
+			Port:        getEnv("SERVER_PORT", "8080"),
+			Host:        getEnv("SERVER_HOST", "0.0.0.0"),
+			MaxBodySize: int64(getEnvInt("MAX_REQUEST_BODY_MB", DefaultMaxBodySizeMB)) << 20,
+			AdminToken:  getEnv("ADMIN_TOKEN", ""),
+
+			MaxFilesPerUpload: getEnvInt("MAX_FILES_PER_UPLOAD", DefaultMaxFilesPerUpload),
+			MultipartMemory:   int64(getEnvInt("MULTIPART_MEMORY_MB", DefaultMultipartMemoryMB)) << 20,
+		},
+		Static: StaticConfig{
+			Enabled: getEnvBool("STATIC_ENABLED", true),
+			Dir:     staticDir,
+			Index:   getEnv("STATIC_INDEX", filepath.Join(staticDir, "index.html")),
 		},
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
@@ -63,18 +376,132 @@ func Load() *Config {
 			Password: getEnv("DB_PASSWORD", "facepass"),
 			DBName:   getEnv("DB_NAME", "facedb"),
 			SSLMode:  getEnv("DB_SSLMODE", "disable"),
+
+			ReadHosts: getEnvList("DB_READ_HOSTS"),
+
+			MaxOpenConns:    getEnvInt("DB_MAX_OPEN_CONNS", DefaultMaxOpenConns),
+			MaxIdleConns:    getEnvInt("DB_MAX_IDLE_CONNS", DefaultMaxIdleConns),
+			ConnMaxLifetime: getEnvDuration("DB_CONN_MAX_LIFETIME", DefaultConnMaxLifetime),
+
+			RunMigrations: getEnvBool("RUN_MIGRATIONS", false),
 		},
 		Storage: StorageConfig{
-			UploadsDir: getEnv("UPLOADS_DIR", "uploads"),
-			ResultsDir: getEnv("RESULTS_DIR", "results"),
+			Backend:     getEnv("STORAGE_BACKEND", StorageBackendLocal),
+			UploadsDir:  getEnv("UPLOADS_DIR", "uploads"),
+			ResultsDir:  getEnv("RESULTS_DIR", "results"),
+			ResultsURL:  getEnv("RESULTS_URL", DefaultResultsURL),
+			ConvertHEIF: getEnvBool("CONVERT_HEIF", true),
+			S3: S3Config{
+				Endpoint:  getEnv("S3_ENDPOINT", "localhost:9000"),
+				AccessKey: getEnv("S3_ACCESS_KEY", ""),
+				SecretKey: getEnv("S3_SECRET_KEY", ""),
+				Bucket:    getEnv("S3_BUCKET", "face-recognition"),
+				Region:    getEnv("S3_REGION", "us-east-1"),
+				UseSSL:    getEnvBool("S3_USE_SSL", false),
+				PublicURL: getEnv("S3_PUBLIC_URL", ""),
+			},
+
+			UploadConcurrency: getEnvInt("UPLOAD_CONCURRENCY", DefaultUploadConcurrency),
+			MaxImagePixels:    int64(getEnvFloat("MAX_IMAGE_MEGAPIXELS", DefaultMaxImageMegapixels) * 1e6),
+
+			OutputFormat: strings.ToLower(getEnv("IMAGE_OUTPUT_FORMAT", "jpeg")),
+			FileNaming:   strings.ToLower(getEnv("STORAGE_FILE_NAMING", "original")),
+
+			OrphanCleanupInterval: getEnvDuration("ORPHAN_CLEANUP_INTERVAL", 0),
+			OrphanGracePeriod:     getEnvDuration("ORPHAN_GRACE_PERIOD", DefaultOrphanGracePeriod),
 		},
 		Python: PythonConfig{
-			BaseURL: getEnv("PYTHON_BASE_URL", "http://localhost:5000"),
+			BaseURLs:       pythonBaseURLs(),
+			HealthInterval: time.Duration(getEnvInt("PYTHON_HEALTH_INTERVAL", 15)) * time.Second,
+			RequestTimeout: getEnvDuration("PYTHON_REQUEST_TIMEOUT", DefaultPythonRequestTimeout),
+			ProcessTimeout: getEnvDuration("PYTHON_PROCESS_TIMEOUT", DefaultPythonProcessTimeout),
 		},
 		Redis: RedisConfig{
 			Addr:     getEnv("REDIS_ADDR", "localhost:6379"),
 			Password: getEnv("REDIS_PASSWORD", ""),
 			DB:       getEnvInt("REDIS_DB", 0),
+
+			PoolSize:     getEnvInt("REDIS_POOL_SIZE", 0),
+			DialTimeout:  getEnvDuration("REDIS_DIAL_TIMEOUT", DefaultRedisDialTimeout),
+			ReadTimeout:  getEnvDuration("REDIS_READ_TIMEOUT", DefaultRedisReadTimeout),
+			WriteTimeout: getEnvDuration("REDIS_WRITE_TIMEOUT", DefaultRedisWriteTimeout),
+		},
+		Cache: CacheConfig{
+			LRUSize: getEnvInt("CACHE_LRU_SIZE", 1000),
+
+			StatsRefreshInterval:   getEnvDuration("STATS_REFRESH_INTERVAL", DefaultStatsRefreshInterval),
+			StatsReconcileInterval: getEnvDuration("STATS_RECONCILE_INTERVAL", DefaultStatsReconcileInterval),
+
+			WarmPersons: getEnvInt("CACHE_WARM_PERSONS", DefaultCacheWarmPersons),
+			WarmTasks:   getEnvInt("CACHE_WARM_TASKS", DefaultCacheWarmTasks),
+		},
+		Thumbnails: ThumbnailsConfig{
+			Enabled: getEnvBool("THUMBNAILS_ENABLED", true),
+			Size:    getEnvInt("THUMBNAIL_SIZE", 128),
+		},
+		Webhooks: WebhooksConfig{
+			AllowedHosts: getEnvList("WEBHOOK_ALLOWED_HOSTS"),
+			Timeout:      time.Duration(getEnvInt("WEBHOOK_TIMEOUT", 10)) * time.Second,
+			MaxRetries:   getEnvInt("WEBHOOK_MAX_RETRIES", 3),
+		},
+		URLUpload: URLUploadConfig{
+			MaxURLs:      getEnvInt("URL_UPLOAD_MAX_URLS", 20),
+			MaxFileSize:  int64(getEnvInt("URL_UPLOAD_MAX_SIZE_MB", 20)) << 20,
+			Timeout:      time.Duration(getEnvInt("URL_UPLOAD_TIMEOUT", 30)) * time.Second,
+			AllowedHosts: getEnvList("URL_UPLOAD_ALLOWED_HOSTS"),
+			AllowPrivate: getEnvBool("URL_UPLOAD_ALLOW_PRIVATE", false),
+		},
+		Tracing: TracingConfig{
+			Endpoint:    getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+			ServiceName: getEnv("OTEL_SERVICE_NAME", "face-recognition"),
+			SampleRatio: getEnvFloat("TRACING_SAMPLE_RATIO", 1),
+		},
+		Queue: QueueConfig{
+			Workers: getEnvInt("PROCESSING_WORKERS", DefaultQueueWorkers),
+			Size:    getEnvInt("PROCESSING_QUEUE_SIZE", DefaultQueueSize),
+			Timeout: getEnvDuration("PROCESSING_TIMEOUT", DefaultProcessingTimeout),
+		},
+		WebSocket: WebSocketConfig{
+			FaceEventsBatch:    getEnvInt("WS_FACE_EVENTS_BATCH", DefaultFaceEventsBatch),
+			FaceEventsInterval: time.Duration(getEnvInt("WS_FACE_EVENTS_INTERVAL_MS", int(DefaultFaceEventsInterval/time.Millisecond))) * time.Millisecond,
+			StatusInterval:     time.Duration(getEnvInt("WS_STATUS_INTERVAL", int(DefaultStatusInterval/time.Second))) * time.Second,
+			StatsDebounce:      time.Duration(getEnvInt("WS_STATS_DEBOUNCE_MS", int(DefaultStatsDebounce/time.Millisecond))) * time.Millisecond,
+			ProgressInterval:   time.Duration(getEnvInt("WS_PROGRESS_INTERVAL_MS", int(DefaultProgressInterval/time.Millisecond))) * time.Millisecond,
+
+			ReadBufferSize:  getEnvInt("WS_READ_BUFFER_SIZE", DefaultWSReadBufferSize),
+			WriteBufferSize: getEnvInt("WS_WRITE_BUFFER_SIZE", DefaultWSWriteBufferSize),
+			MaxMessageSize:  int64(getEnvInt("WS_MAX_MESSAGE_SIZE", DefaultWSMaxMessageSize)),
+
+			MaxConnectionsPerIP: getEnvInt("WS_MAX_CONNECTIONS_PER_IP", DefaultWSMaxConnectionsPerIP),
+		},
+		Detection: DetectionConfig{
+			MinConfidence:        getEnvFloat("DETECTION_MIN_CONFIDENCE", models.DefaultMinConfidence),
+			MaxInconsistentRatio: getEnvFloat("DETECTION_MAX_INCONSISTENT", models.DefaultMaxInconsistentRatio),
+			MaxOverlap:           getEnvFloat("DETECTION_MAX_OVERLAP", models.DefaultMaxFaceOverlap),
+		},
+		Persons: PersonsConfig{
+			MaxNameLength: getEnvInt("PERSON_NAME_MAX_LENGTH", models.DefaultMaxPersonNameLength),
+			MaxFaces:      getEnvInt("PERSON_MAX_FACES", 0),
+		},
+		Import: ImportConfig{
+			MaxSize:             int64(getEnvInt("IMPORT_MAX_SIZE_MB", DefaultImportMaxSizeMB)) << 20,
+			MaxEntries:          getEnvInt("IMPORT_MAX_ENTRIES", DefaultImportMaxEntries),
+			MaxFileSize:         int64(getEnvInt("IMPORT_MAX_FILE_SIZE_MB", DefaultImportMaxFileSizeMB)) << 20,
+			MaxUncompressedSize: int64(getEnvInt("IMPORT_MAX_UNCOMPRESSED_MB", DefaultImportMaxUncompressedMB)) << 20,
+		},
+		Video: VideoConfig{
+			Enabled:     getEnvBool("VIDEO_ENABLED", true),
+			FFmpegPath:  getEnv("FFMPEG_PATH", "ffmpeg"),
+			FFprobePath: getEnv("FFPROBE_PATH", "ffprobe"),
+			FPS:         getEnvFloat("VIDEO_FPS", DefaultVideoFPS),
+			MaxDuration: getEnvDuration("VIDEO_MAX_DURATION", DefaultVideoMaxDuration),
+			MaxSize:     int64(getEnvInt("VIDEO_MAX_SIZE_MB", DefaultVideoMaxSizeMB)) << 20,
+		},
+		Compare: CompareConfig{
+			Backend:         getEnv("COMPARE_BACKEND", CompareBackendGo),
+			MatchThreshold:  getEnvFloat("COMPARE_MATCH_THRESHOLD", embedding.DefaultMatchThreshold),
+			DedupeThreshold: getEnvFloat("DEDUPE_THRESHOLD", models.DefaultDedupeThreshold),
+			EmbeddingDim:    getEnvInt("EMBEDDING_DIM", embedding.DefaultDimension),
 		},
 	}
 }
@@ -87,6 +514,51 @@ func (c *DatabaseConfig) GetDSN() string {
 	)
 }
 
+// GetReadDSNs возвращает строки подключения к репликам из ReadHosts
+// Если порт не указан, используется порт primary
+func (c *DatabaseConfig) GetReadDSNs() []string {
+	dsns := make([]string, 0, len(c.ReadHosts))
+	for _, readHost := range c.ReadHosts {
+		replica := *c
+		replica.Host = readHost
+		if host, port, err := net.SplitHostPort(readHost); err == nil {
+			replica.Host, replica.Port = host, port
+		}
+		dsns = append(dsns, replica.GetDSN())
+	}
+	return dsns
+}
+
+// NormalizePool исправляет некорректные настройки пула:
+// неположительные значения заменяются значениями по умолчанию,
+// MaxIdleConns не может превышать MaxOpenConns
+// Возвращает описание исправлений (для лога)
+func (c *DatabaseConfig) NormalizePool() []string {
+	var fixes []string
+
+	if c.MaxOpenConns <= 0 {
+		fixes = append(fixes, fmt.Sprintf("DB_MAX_OPEN_CONNS=%d заменен на %d", c.MaxOpenConns, DefaultMaxOpenConns))
+		c.MaxOpenConns = DefaultMaxOpenConns
+	}
+
+	if c.MaxIdleConns < 0 {
+		fixes = append(fixes, fmt.Sprintf("DB_MAX_IDLE_CONNS=%d заменен на %d", c.MaxIdleConns, DefaultMaxIdleConns))
+		c.MaxIdleConns = DefaultMaxIdleConns
+	}
+
+	if c.MaxIdleConns > c.MaxOpenConns {
+		fixes = append(fixes, fmt.Sprintf("DB_MAX_IDLE_CONNS=%d больше DB_MAX_OPEN_CONNS, уменьшен до %d", c.MaxIdleConns, c.MaxOpenConns))
+		c.MaxIdleConns = c.MaxOpenConns
+	}
+
+	if c.ConnMaxLifetime < 0 {
+		fixes = append(fixes, fmt.Sprintf("DB_CONN_MAX_LIFETIME=%v заменен на %v", c.ConnMaxLifetime, DefaultConnMaxLifetime))
+		c.ConnMaxLifetime = DefaultConnMaxLifetime
+	}
+
+	return fixes
+}
+
 // getEnv получает переменную окружения или возвращает значение по умолчанию
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -104,3 +576,63 @@ func getEnvInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvFloat получает дробную переменную окружения
+// Некорректное значение заменяется значением по умолчанию
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return floatValue
+}
+
+// getEnvDuration получает длительность ("30m", "1h30m")
+// Некорректное значение заменяется значением по умолчанию
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return defaultValue
+	}
+	return duration
+}
+
+// pythonBaseURLs читает список PYTHON_BASE_URLS, а без него - один PYTHON_BASE_URL
+func pythonBaseURLs() []string {
+	if urls := getEnvList("PYTHON_BASE_URLS"); len(urls) > 0 {
+		return urls
+	}
+	return []string{getEnv("PYTHON_BASE_URL", "http://localhost:5000")}
+}
+
+// getEnvList получает список значений, разделенных запятыми
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvBool получает булеву переменную окружения (true/1/yes)
+func getEnvBool(key string, defaultValue bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "true", "1", "yes":
+		return true
+	case "false", "0", "no":
+		return false
+	}
+	return defaultValue
+}
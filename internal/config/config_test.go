@@ -0,0 +1,306 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"face-recognition/pkg/embedding"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizePool(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        DatabaseConfig
+		want      DatabaseConfig
+		wantFixes int
+	}{
+		{
+			name:      "valid settings are kept",
+			in:        DatabaseConfig{MaxOpenConns: 50, MaxIdleConns: 10, ConnMaxLifetime: time.Hour},
+			want:      DatabaseConfig{MaxOpenConns: 50, MaxIdleConns: 10, ConnMaxLifetime: time.Hour},
+			wantFixes: 0,
+		},
+		{
+			name:      "idle above open is capped",
+			in:        DatabaseConfig{MaxOpenConns: 4, MaxIdleConns: 10},
+			want:      DatabaseConfig{MaxOpenConns: 4, MaxIdleConns: 4},
+			wantFixes: 1,
+		},
+		{
+			name:      "non-positive values fall back to defaults",
+			in:        DatabaseConfig{MaxOpenConns: 0, MaxIdleConns: -1, ConnMaxLifetime: -time.Second},
+			want:      DatabaseConfig{MaxOpenConns: DefaultMaxOpenConns, MaxIdleConns: DefaultMaxIdleConns, ConnMaxLifetime: DefaultConnMaxLifetime},
+			wantFixes: 3,
+		},
+		{
+			name:      "zero idle and lifetime are allowed",
+			in:        DatabaseConfig{MaxOpenConns: 10},
+			want:      DatabaseConfig{MaxOpenConns: 10},
+			wantFixes: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := tt.in
+			fixes := cfg.NormalizePool()
+			assert.Equal(t, tt.want, cfg)
+			assert.Len(t, fixes, tt.wantFixes)
+		})
+	}
+}
+
+func TestLoadPoolSettingsFromEnv(t *testing.T) {
+	t.Setenv("DB_MAX_OPEN_CONNS", "100")
+	t.Setenv("DB_MAX_IDLE_CONNS", "20")
+	t.Setenv("DB_CONN_MAX_LIFETIME", "5m")
+
+	cfg := Load()
+	assert.Equal(t, 100, cfg.Database.MaxOpenConns)
+	assert.Equal(t, 20, cfg.Database.MaxIdleConns)
+	assert.Equal(t, 5*time.Minute, cfg.Database.ConnMaxLifetime)
+
+	t.Setenv("DB_CONN_MAX_LIFETIME", "forever")
+	assert.Equal(t, DefaultConnMaxLifetime, Load().Database.ConnMaxLifetime)
+}
+
+func TestLoadPythonSettings(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, []string{"http://localhost:5000"}, cfg.Python.BaseURLs)
+	assert.Equal(t, 15*time.Second, cfg.Python.HealthInterval)
+	assert.Equal(t, DefaultPythonRequestTimeout, cfg.Python.RequestTimeout)
+	assert.Equal(t, DefaultPythonProcessTimeout, cfg.Python.ProcessTimeout)
+
+	// Один сервер по старой переменной
+	t.Setenv("PYTHON_BASE_URL", "http://python:5000")
+	assert.Equal(t, []string{"http://python:5000"}, Load().Python.BaseURLs)
+
+	// Список важнее одиночного адреса
+	t.Setenv("PYTHON_BASE_URLS", "http://python-1:5000, http://python-2:5000")
+	t.Setenv("PYTHON_HEALTH_INTERVAL", "5")
+	t.Setenv("PYTHON_REQUEST_TIMEOUT", "3s")
+	t.Setenv("PYTHON_PROCESS_TIMEOUT", "30m")
+
+	cfg = Load()
+	assert.Equal(t, []string{"http://python-1:5000", "http://python-2:5000"}, cfg.Python.BaseURLs)
+	assert.Equal(t, 5*time.Second, cfg.Python.HealthInterval)
+	assert.Equal(t, 3*time.Second, cfg.Python.RequestTimeout)
+	assert.Equal(t, 30*time.Minute, cfg.Python.ProcessTimeout)
+}
+
+func TestLoadURLUploadSettings(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, 20, cfg.URLUpload.MaxURLs)
+	assert.Equal(t, int64(20<<20), cfg.URLUpload.MaxFileSize)
+	assert.Equal(t, 30*time.Second, cfg.URLUpload.Timeout)
+	assert.Empty(t, cfg.URLUpload.AllowedHosts)
+	assert.False(t, cfg.URLUpload.AllowPrivate)
+
+	t.Setenv("URL_UPLOAD_MAX_URLS", "5")
+	t.Setenv("URL_UPLOAD_MAX_SIZE_MB", "2")
+	t.Setenv("URL_UPLOAD_TIMEOUT", "10")
+	t.Setenv("URL_UPLOAD_ALLOWED_HOSTS", "cdn.example.com, images.example.com")
+	t.Setenv("URL_UPLOAD_ALLOW_PRIVATE", "true")
+
+	cfg = Load()
+	assert.Equal(t, 5, cfg.URLUpload.MaxURLs)
+	assert.Equal(t, int64(2<<20), cfg.URLUpload.MaxFileSize)
+	assert.Equal(t, 10*time.Second, cfg.URLUpload.Timeout)
+	assert.Equal(t, []string{"cdn.example.com", "images.example.com"}, cfg.URLUpload.AllowedHosts)
+	assert.True(t, cfg.URLUpload.AllowPrivate)
+}
+
+func TestLoadMaxBodySize(t *testing.T) {
+	assert.Equal(t, int64(DefaultMaxBodySizeMB<<20), Load().Server.MaxBodySize)
+
+	t.Setenv("MAX_REQUEST_BODY_MB", "250")
+	assert.Equal(t, int64(250<<20), Load().Server.MaxBodySize)
+}
+
+func TestLoadMaxFilesPerUpload(t *testing.T) {
+	assert.Equal(t, DefaultMaxFilesPerUpload, Load().Server.MaxFilesPerUpload)
+
+	t.Setenv("MAX_FILES_PER_UPLOAD", "50")
+	assert.Equal(t, 50, Load().Server.MaxFilesPerUpload)
+}
+
+func TestLoadStaticSettings(t *testing.T) {
+	cfg := Load()
+	assert.True(t, cfg.Static.Enabled)
+	assert.Equal(t, DefaultStaticDir, cfg.Static.Dir)
+	assert.Equal(t, filepath.Join(DefaultStaticDir, "index.html"), cfg.Static.Index)
+
+	// Главная страница по умолчанию следует за STATIC_DIR
+	t.Setenv("STATIC_DIR", "/srv/www")
+	assert.Equal(t, "/srv/www/index.html", Load().Static.Index)
+
+	t.Setenv("STATIC_INDEX", "/srv/www/app.html")
+	t.Setenv("STATIC_ENABLED", "false")
+	cfg = Load()
+	assert.False(t, cfg.Static.Enabled)
+	assert.Equal(t, "/srv/www", cfg.Static.Dir)
+	assert.Equal(t, "/srv/www/app.html", cfg.Static.Index)
+}
+
+func TestLoadTracingSettings(t *testing.T) {
+	cfg := Load()
+	assert.Empty(t, cfg.Tracing.Endpoint)
+	assert.Equal(t, "face-recognition", cfg.Tracing.ServiceName)
+	assert.Equal(t, 1.0, cfg.Tracing.SampleRatio)
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://jaeger:4318")
+	t.Setenv("OTEL_SERVICE_NAME", "face-api")
+	t.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+
+	cfg = Load()
+	assert.Equal(t, "http://jaeger:4318", cfg.Tracing.Endpoint)
+	assert.Equal(t, "face-api", cfg.Tracing.ServiceName)
+	assert.Equal(t, 0.25, cfg.Tracing.SampleRatio)
+}
+
+func TestLoadWebSocketSettings(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, DefaultFaceEventsBatch, cfg.WebSocket.FaceEventsBatch)
+	assert.Equal(t, DefaultFaceEventsInterval, cfg.WebSocket.FaceEventsInterval)
+	assert.Equal(t, DefaultStatusInterval, cfg.WebSocket.StatusInterval)
+	assert.Equal(t, DefaultStatsDebounce, cfg.WebSocket.StatsDebounce)
+	assert.Equal(t, DefaultProgressInterval, cfg.WebSocket.ProgressInterval)
+	assert.Equal(t, DefaultWSReadBufferSize, cfg.WebSocket.ReadBufferSize)
+	assert.Equal(t, DefaultWSWriteBufferSize, cfg.WebSocket.WriteBufferSize)
+	assert.Equal(t, int64(DefaultWSMaxMessageSize), cfg.WebSocket.MaxMessageSize)
+	assert.Equal(t, DefaultWSMaxConnectionsPerIP, cfg.WebSocket.MaxConnectionsPerIP)
+
+	t.Setenv("WS_FACE_EVENTS_BATCH", "10")
+	t.Setenv("WS_FACE_EVENTS_INTERVAL_MS", "250")
+	t.Setenv("WS_STATUS_INTERVAL", "30")
+	t.Setenv("WS_STATS_DEBOUNCE_MS", "200")
+	t.Setenv("WS_PROGRESS_INTERVAL_MS", "0")
+	t.Setenv("WS_READ_BUFFER_SIZE", "2048")
+	t.Setenv("WS_WRITE_BUFFER_SIZE", "8192")
+	t.Setenv("WS_MAX_MESSAGE_SIZE", "512")
+	t.Setenv("WS_MAX_CONNECTIONS_PER_IP", "0")
+
+	cfg = Load()
+	assert.Equal(t, 10, cfg.WebSocket.FaceEventsBatch)
+	assert.Equal(t, 250*time.Millisecond, cfg.WebSocket.FaceEventsInterval)
+	assert.Equal(t, 30*time.Second, cfg.WebSocket.StatusInterval)
+	assert.Equal(t, 200*time.Millisecond, cfg.WebSocket.StatsDebounce)
+	assert.Equal(t, time.Duration(0), cfg.WebSocket.ProgressInterval)
+	assert.Equal(t, 2048, cfg.WebSocket.ReadBufferSize)
+	assert.Equal(t, 8192, cfg.WebSocket.WriteBufferSize)
+	assert.Equal(t, int64(512), cfg.WebSocket.MaxMessageSize)
+	assert.Equal(t, 0, cfg.WebSocket.MaxConnectionsPerIP)
+}
+
+func TestLoadQueueSettings(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, DefaultQueueWorkers, cfg.Queue.Workers)
+	assert.Equal(t, DefaultQueueSize, cfg.Queue.Size)
+	assert.Equal(t, DefaultProcessingTimeout, cfg.Queue.Timeout)
+
+	t.Setenv("PROCESSING_WORKERS", "4")
+	t.Setenv("PROCESSING_QUEUE_SIZE", "50")
+	t.Setenv("PROCESSING_TIMEOUT", "1h")
+
+	cfg = Load()
+	assert.Equal(t, 4, cfg.Queue.Workers)
+	assert.Equal(t, 50, cfg.Queue.Size)
+	assert.Equal(t, time.Hour, cfg.Queue.Timeout)
+}
+
+func TestLoadStatsRefreshInterval(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, DefaultStatsRefreshInterval, cfg.Cache.StatsRefreshInterval)
+
+	t.Setenv("STATS_REFRESH_INTERVAL", "0")
+	assert.Equal(t, time.Duration(0), Load().Cache.StatsRefreshInterval)
+}
+
+func TestLoadStatsReconcileInterval(t *testing.T) {
+	assert.Equal(t, DefaultStatsReconcileInterval, Load().Cache.StatsReconcileInterval)
+
+	t.Setenv("STATS_RECONCILE_INTERVAL", "15m")
+	assert.Equal(t, 15*time.Minute, Load().Cache.StatsReconcileInterval)
+}
+
+func TestLoadCacheWarmLimits(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, DefaultCacheWarmPersons, cfg.Cache.WarmPersons)
+	assert.Equal(t, DefaultCacheWarmTasks, cfg.Cache.WarmTasks)
+
+	t.Setenv("CACHE_WARM_PERSONS", "50")
+	t.Setenv("CACHE_WARM_TASKS", "0")
+
+	cfg = Load()
+	assert.Equal(t, 50, cfg.Cache.WarmPersons)
+	assert.Equal(t, 0, cfg.Cache.WarmTasks)
+}
+
+func TestLoadVideoSettings(t *testing.T) {
+	cfg := Load()
+	assert.True(t, cfg.Video.Enabled)
+	assert.Equal(t, "ffmpeg", cfg.Video.FFmpegPath)
+	assert.Equal(t, DefaultVideoFPS, cfg.Video.FPS)
+	assert.Equal(t, DefaultVideoMaxDuration, cfg.Video.MaxDuration)
+	assert.Equal(t, int64(DefaultVideoMaxSizeMB)<<20, cfg.Video.MaxSize)
+
+	t.Setenv("VIDEO_ENABLED", "false")
+	t.Setenv("FFPROBE_PATH", "/opt/ffmpeg/ffprobe")
+	t.Setenv("VIDEO_FPS", "0.5")
+	t.Setenv("VIDEO_MAX_DURATION", "30s")
+	t.Setenv("VIDEO_MAX_SIZE_MB", "10")
+
+	cfg = Load()
+	assert.False(t, cfg.Video.Enabled)
+	assert.Equal(t, "/opt/ffmpeg/ffprobe", cfg.Video.FFprobePath)
+	assert.Equal(t, 0.5, cfg.Video.FPS)
+	assert.Equal(t, 30*time.Second, cfg.Video.MaxDuration)
+	assert.Equal(t, int64(10)<<20, cfg.Video.MaxSize)
+}
+
+func TestLoadUploadConcurrency(t *testing.T) {
+	cfg := Load()
+	assert.Equal(t, DefaultUploadConcurrency, cfg.Storage.UploadConcurrency)
+
+	t.Setenv("UPLOAD_CONCURRENCY", "16")
+
+	cfg = Load()
+	assert.Equal(t, 16, cfg.Storage.UploadConcurrency)
+}
+
+func TestGetReadDSNs(t *testing.T) {
+	cfg := DatabaseConfig{
+		Host: "primary", Port: "5432", User: "u", Password: "p", DBName: "db", SSLMode: "disable",
+		ReadHosts: []string{"replica1", "replica2:6432"},
+	}
+
+	assert.Equal(t, []string{
+		"host=replica1 port=5432 user=u password=p dbname=db sslmode=disable",
+		"host=replica2 port=6432 user=u password=p dbname=db sslmode=disable",
+	}, cfg.GetReadDSNs())
+	assert.Empty(t, (&DatabaseConfig{}).GetReadDSNs())
+}
+
+func TestLoadResultsURL(t *testing.T) {
+	assert.Equal(t, DefaultResultsURL, Load().Storage.ResultsURL)
+
+	t.Setenv("RESULTS_URL", "/annotated")
+	assert.Equal(t, "/annotated", Load().Storage.ResultsURL)
+}
+
+func TestLoadMaxImagePixels(t *testing.T) {
+	assert.Equal(t, int64(DefaultMaxImageMegapixels*1e6), Load().Storage.MaxImagePixels)
+
+	t.Setenv("MAX_IMAGE_MEGAPIXELS", "0.5")
+	assert.Equal(t, int64(500000), Load().Storage.MaxImagePixels)
+}
+
+func TestLoadEmbeddingDim(t *testing.T) {
+	assert.Equal(t, embedding.DefaultDimension, Load().Compare.EmbeddingDim)
+
+	t.Setenv("EMBEDDING_DIM", "128")
+	assert.Equal(t, 128, Load().Compare.EmbeddingDim)
+}
@@ -2,58 +2,437 @@ package models
 
 import (
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"strings"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 // Person представляет человека в системе
 type Person struct {
-	ID        int       `db:"id" json:"id"`
-	Name      string    `db:"name" json:"name"`
-	CreatedAt time.Time `db:"created_at" json:"created_at"`
-	UpdatedAt time.Time `db:"updated_at" json:"updated_at"`
+	ID          int           `db:"id" json:"id"`
+	Name        string        `db:"name" json:"name"`
+	CreatedAt   time.Time     `db:"created_at" json:"created_at"`
+	UpdatedAt   time.Time     `db:"updated_at" json:"updated_at"`
+	DeletedAt   sql.NullTime  `db:"deleted_at" json:"-"`    // Soft-delete: NULL - человек не удален
+	CoverFaceID sql.NullInt64 `db:"cover_face_id" json:"-"` // Обложка (NULL - лицо с наибольшей уверенностью)
+	Centroid    []byte        `db:"centroid" json:"-"`      // Центроид embedding лиц (JSON; NULL - нет лиц с embedding)
+	IsLabeled   bool          `db:"is_labeled" json:"-"`    // Человека переименовали (false - имя кластера person_N)
+}
+
+// CoverFace - лицо-обложка человека для списка и карточки
+type CoverFace struct {
+	FaceID         int    `json:"face_id"`
+	AnnotatedImage string `json:"annotated_image"`
+	URL            string `json:"url"` // Адрес annotated_image в хранилище
 }
 
 // Face представляет отдельное лицо (фотографию)
 type Face struct {
-	ID             int       `db:"id" json:"id"`
-	PersonID       int       `db:"person_id" json:"person_id"`
-	OriginalImage  string    `db:"original_image" json:"original_image"`   // Оригинальное фото
-	AnnotatedImage string    `db:"annotated_image" json:"annotated_image"` // Фото с рамкой
-	FaceX          int       `db:"face_x" json:"face_x"`                   // Координаты лица
-	FaceY          int       `db:"face_y" json:"face_y"`
-	FaceWidth      int       `db:"face_width" json:"face_width"`
-	FaceHeight     int       `db:"face_height" json:"face_height"`
-	Embedding      []byte    `db:"embedding" json:"-"`           // Embedding вектор
-	Confidence     float64   `db:"confidence" json:"confidence"` // Уверенность детекции
-	DetectedAt     time.Time `db:"detected_at" json:"detected_at"`
-	ImagePath      string    `db:"image_path" json:"image_path"`
+	ID               int        `db:"id" json:"id"`
+	PersonID         int        `db:"person_id" json:"person_id"`
+	TaskID           string     `db:"task_id" json:"task_id"`                               // Задача, в которой найдено лицо
+	OriginalImage    string     `db:"original_image" json:"original_image"`                 // Оригинальное фото
+	OriginalFilename string     `db:"original_filename" json:"original_filename,omitempty"` // Имя от клиента ("" - как в хранилище, STORAGE_FILE_NAMING)
+	AnnotatedImage   string     `db:"annotated_image" json:"annotated_image"`               // Фото с рамкой
+	ThumbnailImage   string     `db:"thumbnail_image" json:"thumbnail_image"`               // Миниатюра фото с рамкой
+	FaceX            int        `db:"face_x" json:"face_x"`                                 // Координаты лица
+	FaceY            int        `db:"face_y" json:"face_y"`
+	FaceWidth        int        `db:"face_width" json:"face_width"`
+	FaceHeight       int        `db:"face_height" json:"face_height"`
+	ImageWidth       *int       `db:"image_width" json:"image_width"` // Размер оригинала (nil - неизвестен, у старых лиц)
+	ImageHeight      *int       `db:"image_height" json:"image_height"`
+	Embedding        []byte     `db:"embedding" json:"-"`                     // Embedding вектор
+	EmbeddingModel   string     `db:"embedding_model" json:"embedding_model"` // Модель, посчитавшая embedding ("" - неизвестна)
+	Confidence       float64    `db:"confidence" json:"confidence"`           // Уверенность детекции
+	Quality          *float64   `db:"quality" json:"quality"`                 // Резкость лица в [0, 1) (nil - не посчитана)
+	CapturedAt       *time.Time `db:"captured_at" json:"captured_at"`         // Время съемки из EXIF (nil - неизвестно)
+	FrameTime        *float64   `db:"frame_time" json:"frame_time,omitempty"` // Время кадра от начала видео, с (nil - лицо не из видео)
+	DetectedAt       time.Time  `db:"detected_at" json:"detected_at"`
+	ImagePath        string     `db:"image_path" json:"image_path"`
+}
+
+// FaceDetails - лицо с адресами его изображений (GET /api/faces/:id)
+type FaceDetails struct {
+	Face
+	OriginalURL  string    `json:"original_url"`
+	AnnotatedURL string    `json:"annotated_url,omitempty"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	Vector       []float64 `json:"embedding,omitempty"` // Только с include_embedding=true
+}
+
+// FaceEmbeddingResponse - embedding лица (GET /api/faces/:id/embedding)
+type FaceEmbeddingResponse struct {
+	FaceID    int       `json:"face_id"`
+	PersonID  int       `json:"person_id"`
+	Model     string    `json:"embedding_model"` // Модель, посчитавшая embedding ("" - неизвестна)
+	Dimension int       `json:"dimension"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// PersonEmbeddingsResponse - embedding всех лиц человека (GET /api/persons/:id/embeddings)
+type PersonEmbeddingsResponse struct {
+	PersonID int                     `json:"person_id"`
+	Name     string                  `json:"name"`
+	Faces    []FaceEmbeddingResponse `json:"faces"` // Лица без корректного embedding пропущены
+}
+
+// PersonFacesCountResponse - число лиц человека (GET /api/persons/:id/faces/count)
+type PersonFacesCountResponse struct {
+	Count int `json:"count"`
+}
+
+// LabeledEmbedding - embedding лица вместе с человеком, которому оно принадлежит
+// (строка выгрузки GET /api/export/embeddings)
+type LabeledEmbedding struct {
+	FaceID     int    `db:"id"`
+	PersonID   int    `db:"person_id"`
+	PersonName string `db:"person_name"`
+	Model      string `db:"embedding_model"`
+	Embedding  []byte `db:"embedding"`
+}
+
+// EmbeddingExportRecord - строка NDJSON выгрузки embedding: одно лицо с меткой
+type EmbeddingExportRecord struct {
+	FaceID     int       `json:"face_id"`
+	PersonID   int       `json:"person_id"`
+	PersonName string    `json:"person_name"`
+	Model      string    `json:"embedding_model"` // Модель, посчитавшая embedding ("" - неизвестна)
+	Dimension  int       `json:"dimension"`
+	Embedding  []float64 `json:"embedding"`
+}
+
+// FaceWithPerson - лицо с именем человека (GET /api/faces)
+type FaceWithPerson struct {
+	Face
+	PersonName string `db:"person_name" json:"person_name"`
+}
+
+// FaceListResponse - страница лиц, отфильтрованных по уверенности детекции
+type FaceListResponse struct {
+	Faces         []FaceWithPerson `json:"faces"`
+	Total         int              `json:"total"` // Всего лиц в диапазоне без учета limit/offset
+	MinConfidence float64          `json:"min_confidence"`
+	MaxConfidence float64          `json:"max_confidence"`
+	MinQuality    float64          `json:"min_quality"` // 0 - без фильтра (включая лица без оценки)
+	Limit         int              `json:"limit"`
+	Offset        int              `json:"offset"`
 }
 
 // Task представляет задачу обработки изображений
 type Task struct {
-	ID           string         `db:"id" json:"id"`
-	Status       string         `db:"status" json:"status"` // processing, completed, failed
-	TotalImages  int            `db:"total_images" json:"total_images"`
-	TotalFaces   int            `db:"total_faces" json:"total_faces"`
-	UniquPersons int            `db:"unique_persons" json:"unique_persons"`
-	ErrorMessage sql.NullString `db:"error_message" json:"error_message,omitempty"`
-	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
-	CompletedAt  sql.NullTime   `db:"completed_at" json:"completed_at,omitempty"`
+	ID            string         `db:"id" json:"id"`
+	Status        string         `db:"status" json:"status"` // processing, completed, failed
+	TotalImages   int            `db:"total_images" json:"total_images"`
+	TotalFaces    int            `db:"total_faces" json:"total_faces"`
+	UniquPersons  int            `db:"unique_persons" json:"unique_persons"`
+	MinSize       int            `db:"min_size" json:"min_size"`             // Минимальный размер лица (px)
+	DetThresh     float64        `db:"det_thresh" json:"det_thresh"`         // Порог уверенности детекции
+	MinConfidence float64        `db:"min_confidence" json:"min_confidence"` // Лица с меньшей уверенностью не сохраняются
+	RejectedFaces int            `db:"rejected_faces" json:"rejected_faces"` // Отброшено по min_confidence
+	OverflowFaces int            `db:"overflow_faces" json:"overflow_faces"` // Не сохранено: человек достиг PERSON_MAX_FACES
+	MergedFaces   int            `db:"merged_faces" json:"merged_faces"`     // Не сохранено: повторная детекция того же лица
+	Warnings      pq.StringArray `db:"warnings" json:"warnings,omitempty"`   // Несогласованные данные в ответе Python
+	CallbackURL   string         `db:"callback_url" json:"callback_url,omitempty"`
+	ContentHash   string         `db:"content_hash" json:"-"`    // SHA-256 файлов и параметров (идемпотентность)
+	Progress      int            `db:"progress" json:"progress"` // Прогресс обработки (0-100)
+	Stage         string         `db:"stage" json:"stage"`       // Текущий этап обработки
+	ErrorMessage  sql.NullString `db:"error_message" json:"error_message,omitempty"`
+	CreatedAt     time.Time      `db:"created_at" json:"created_at"`
+	CompletedAt   sql.NullTime   `db:"completed_at" json:"completed_at,omitempty"`
+	StartedAt     sql.NullTime   `db:"processing_started_at" json:"processing_started_at,omitempty"` // Начало обработки (без ожидания в очереди)
+	Duration      *float64       `db:"-" json:"duration_seconds"`                                    // Секунды обработки, nil пока задача обрабатывается (см. SetDuration)
+}
+
+// InProgress сообщает, что задача ждет в очереди или обрабатывается
+func (t *Task) InProgress() bool {
+	return t.Status == TaskStatusQueued || t.Status == TaskStatusProcessing
+}
+
+// SetDuration вычисляет Duration от начала обработки до завершения
+// У задач, созданных до processing_started_at, отсчет идет от created_at
+func (t *Task) SetDuration() {
+	t.Duration = nil
+	if t.InProgress() || !t.CompletedAt.Valid {
+		return
+	}
+
+	started := t.CreatedAt
+	if t.StartedAt.Valid {
+		started = t.StartedAt.Time
+	}
+
+	duration := math.Max(t.CompletedAt.Time.Sub(started).Seconds(), 0)
+	t.Duration = &duration
+}
+
+// TaskFile - результат обработки одного загруженного файла задачи
+type TaskFile struct {
+	TaskID           string `db:"task_id" json:"-"`
+	FileName         string `db:"file_name" json:"file_name"`
+	FacesCount       int    `db:"faces_count" json:"faces_count"` // Лиц найдено Python (0 - лиц нет)
+	Error            string `db:"error" json:"error,omitempty"`
+	OriginalFilename string `db:"original_filename" json:"original_filename,omitempty"` // Имя от клиента ("" - как file_name, STORAGE_FILE_NAMING)
+}
+
+// TaskFilesResponse - ответ GET /api/task/:id/files
+type TaskFilesResponse struct {
+	TaskID string     `json:"task_id"`
+	Status string     `json:"status"`
+	Files  []TaskFile `json:"files"` // Пусто, пока задача обрабатывается
+}
+
+// TaskImage - загруженное изображение задачи
+type TaskImage struct {
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"` // Размер в байтах
+	URL      string `json:"url"`
+}
+
+// TaskImagesResponse - ответ GET /api/task/:id/images
+type TaskImagesResponse struct {
+	TaskID string      `json:"task_id"`
+	Status string      `json:"status"`
+	Images []TaskImage `json:"images"`
+}
+
+// ClusterMetrics - качество одного кластера (человека) задачи
+// Расстояния косинусные: 1 - косинусное сходство
+type ClusterMetrics struct {
+	PersonID        int      `json:"person_id"`
+	Size            int      `json:"size"`              // Лиц задачи в кластере
+	IntraDistance   float64  `json:"intra_distance"`    // Среднее расстояние лиц до центроида кластера
+	NearestPersonID *int     `json:"nearest_person_id"` // Ближайший кластер (nil - кластер один)
+	NearestDistance *float64 `json:"nearest_distance"`  // Расстояние между центроидами до ближайшего кластера
+}
+
+// TaskMetricsResponse - качество кластеризации задачи (GET /api/task/:id/metrics)
+// Метрики, которым нужно больше одного кластера, при одном кластере равны null
+type TaskMetricsResponse struct {
+	TaskID           string           `json:"task_id"`
+	Status           string           `json:"status"`
+	Faces            int              `json:"faces"`         // Лиц с embedding, участвующих в расчете
+	SkippedFaces     int              `json:"skipped_faces"` // Лица без embedding или с другой размерностью
+	ClusterCount     int              `json:"cluster_count"`
+	SingletonCount   int              `json:"singleton_count"` // Кластеры из одного лица
+	AvgClusterSize   float64          `json:"avg_cluster_size"`
+	MaxClusterSize   int              `json:"max_cluster_size"`
+	IntraDistance    float64          `json:"intra_distance"`     // Среднее расстояние лица до центроида своего кластера
+	InterDistance    *float64         `json:"inter_distance"`     // Среднее расстояние между центроидами кластеров
+	MinInterDistance *float64         `json:"min_inter_distance"` // Расстояние между двумя самыми близкими кластерами
+	Silhouette       *float64         `json:"silhouette"`         // Упрощенный silhouette по центроидам (-1..1, выше - лучше)
+	Clusters         []ClusterMetrics `json:"clusters"`
+}
+
+// DeleteTaskResponse - итог удаления задачи
+type DeleteTaskResponse struct {
+	TaskID         string `json:"task_id"`
+	DeletedFaces   int    `json:"deleted_faces"`
+	DeletedPersons int    `json:"deleted_persons"` // Люди, у которых не осталось лиц из других задач
+	FilesDeleted   bool   `json:"files_deleted"`   // false - файлы не удалось удалить (см. логи сервера)
+}
+
+// RecentTasksResponse - последние задачи для главной страницы (новые первыми)
+type RecentTasksResponse struct {
+	Tasks []Task `json:"tasks"`
+}
+
+// PersonSpriteResponse - вырезки лиц человека одной сеткой (GET /api/persons/:id/sprite)
+type PersonSpriteResponse struct {
+	PersonID  int                    `json:"person_id"`
+	Cols      int                    `json:"cols"`
+	Size      int                    `json:"size"` // Сторона квадратной ячейки, px
+	Width     int                    `json:"width"`
+	Height    int                    `json:"height"`
+	Total     int                    `json:"total"`     // Лиц у человека (в спрайт попадают первые MaxSpriteFaces)
+	Image     string                 `json:"image"`     // data URL изображения (data:image/jpeg;base64,...)
+	Positions map[int]SpritePosition `json:"positions"` // ID лица → левый верхний угол его ячейки
+}
+
+// SpritePosition - положение ячейки лица в спрайте
+type SpritePosition struct {
+	X int `json:"x"`
+	Y int `json:"y"`
 }
 
+// MaxSpriteFaces - сколько лиц (новые первыми) помещается в спрайт человека
+const MaxSpriteFaces = 100
+
 // PersonWithFaces - человек со всеми его фотографиями
 // Используется для API ответов
 type PersonWithFaces struct {
 	Person
-	Faces []Face `json:"faces"`
+	Faces []Face     `json:"faces"`
+	Count int        `json:"faces_count"`
+	Score float64    `json:"score,omitempty"` // Релевантность (только в результатах поиска)
+	Tags  []string   `json:"tags,omitempty"`  // Метки (только в карточке человека)
+	Cover *CoverFace `json:"cover,omitempty"` // Обложка (в списке и карточке человека)
+}
+
+// TaskPerson - человек, в которого задача сохранила лица (GET /api/task/:id/persons)
+type TaskPerson struct {
+	Person
+	Created   bool `db:"created" json:"created"`       // false - человек создан раньше другой задачей и только дополнен
+	TaskFaces int  `db:"task_faces" json:"task_faces"` // Лиц этой задачи у человека
+}
+
+// TaskPersonsResponse - ответ GET /api/task/:id/persons
+type TaskPersonsResponse struct {
+	TaskID  string       `json:"task_id"`
+	Status  string       `json:"status"`
+	Persons []TaskPerson `json:"persons"`
+}
+
+// PersonListItem - человек в компактном списке (GET /api/persons?fields=compact)
+type PersonListItem struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
 	Count int    `json:"faces_count"`
 }
 
+// Форматы списка людей (параметр fields)
+const (
+	PersonFieldsFull    = "full"    // По умолчанию: человек с датами и обложкой
+	PersonFieldsCompact = "compact" // Только id, name и faces_count
+)
+
+// События журнала изменений людей (person_audit)
+const (
+	AuditFacesAdded   = "faces_added"   // details: face_ids, task_id
+	AuditFacesRemoved = "faces_removed" // details: face_ids, task_id (если лица удалены вместе с задачей)
+	AuditRenamed      = "renamed"       // details: old_name, new_name
+)
+
+// PersonAuditEntry - запись журнала изменений человека (GET /api/persons/:id/audit)
+type PersonAuditEntry struct {
+	ID        int64           `db:"id" json:"id"`
+	PersonID  int             `db:"person_id" json:"person_id"`
+	Event     string          `db:"event" json:"event"`
+	Actor     string          `db:"actor" json:"actor"`
+	Details   json.RawMessage `db:"details" json:"details"`
+	CreatedAt time.Time       `db:"created_at" json:"created_at"`
+}
+
+// PersonAuditResponse - журнал изменений человека
+type PersonAuditResponse struct {
+	PersonID int                `json:"person_id"`
+	Entries  []PersonAuditEntry `json:"entries"`
+}
+
 // Stats - общая статистика системы
 type Stats struct {
-	TotalPersons int `json:"total_persons"`
-	TotalFaces   int `json:"total_faces"`
-	TotalTasks   int `json:"total_tasks"`
+	TotalPersons      int           `json:"total_persons"`
+	TotalFaces        int           `json:"total_faces"`
+	TotalTasks        int           `json:"total_tasks"`
+	AvgFacesPerPerson float64       `json:"avg_faces_per_person"`
+	FacesPerDay       []DailyCount  `json:"faces_per_day"` // За последние StatsDays дней
+	TopPersons        []PersonCount `json:"top_persons"`   // TopPersonsLimit людей с наибольшим числом фото
+	Queue             *QueueStats   `json:"queue,omitempty"`
+}
+
+// StatsSummary - итоговые счетчики из stats_summary
+type StatsSummary struct {
+	TotalPersons int `db:"total_persons" json:"total_persons"`
+	TotalFaces   int `db:"total_faces" json:"total_faces"`
+	TotalTasks   int `db:"total_tasks" json:"total_tasks"`
+}
+
+// QueueStats - состояние очереди обработки задач
+type QueueStats struct {
+	Depth   int `json:"depth"`   // Задач ждут свободного воркера
+	Active  int `json:"active"`  // Задач обрабатывается сейчас
+	Workers int `json:"workers"` // Всего воркеров
+}
+
+// DailyCount - количество лиц, обнаруженных за день
+type DailyCount struct {
+	Day   time.Time `db:"day" json:"day"`
+	Count int       `db:"count" json:"count"`
+}
+
+// PersonCount - человек с количеством фото
+type PersonCount struct {
+	ID    int    `db:"id" json:"id"`
+	Name  string `db:"name" json:"name"`
+	Count int    `db:"faces_count" json:"faces_count"`
+}
+
+// Параметры расширенной статистики
+const (
+	StatsDays       = 30 // Глубина ряда faces_per_day
+	TopPersonsLimit = 5
+)
+
+// SearchOptions - параметры поиска людей
+type SearchOptions struct {
+	Fuzzy         bool    // Искать также по похожести (pg_trgm), а не только по подстроке
+	MinSimilarity float64 // Минимальная похожесть имени для нечеткого поиска (0-1]
+	MinFaces      int     // Только люди хотя бы с MinFaces фото (0 - без фильтра)
+	Limit         int     // Размер страницы (0 - без ограничения)
+	Offset        int     // Сколько результатов пропустить
+}
+
+// DefaultMinSimilarity - порог похожести по умолчанию (как pg_trgm.similarity_threshold)
+const DefaultMinSimilarity = 0.3
+
+// Размер страницы поиска
+const (
+	DefaultSearchLimit = 50
+	MaxSearchLimit     = 200
+)
+
+// SearchResponse - страница результатов поиска
+type SearchResponse struct {
+	Persons []PersonWithFaces `json:"persons"`
+	Total   int               `json:"total"` // Всего совпадений без учета limit/offset
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+}
+
+// PersonCursor - позиция в списке людей (новые первыми): последний показанный человек
+// Следующая страница начинается после него, поэтому люди, созданные во время обхода,
+// не сдвигают страницы (в отличие от offset)
+type PersonCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	ID        int       `json:"id"`
+}
+
+// ErrInvalidCursor - курсор не получен из next_cursor
+var ErrInvalidCursor = errors.New("неверный cursor")
+
+// Encode кодирует курсор в непрозрачную строку для next_cursor
+func (c PersonCursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParsePersonCursor разбирает строку из next_cursor
+func ParsePersonCursor(token string) (*PersonCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor PersonCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.ID <= 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// PersonPage - страница списка людей (GET /api/persons с limit, cursor или offset)
+type PersonPage struct {
+	Persons    interface{} `json:"persons"`               // []PersonWithFaces или []PersonListItem (fields=compact)
+	NextCursor string      `json:"next_cursor,omitempty"` // Пусто - страница последняя
+	Limit      int         `json:"limit"`
+}
+
+// UpdateCoverRequest - запрос на выбор обложки человека
+type UpdateCoverRequest struct {
+	FaceID int `json:"face_id" binding:"required"`
 }
 
 // UpdatePersonRequest - запрос на обновление имени
@@ -61,24 +440,330 @@ type UpdatePersonRequest struct {
 	Name string `json:"name" binding:"required"`
 }
 
+// TaskLabelRequest - имена для людей задачи: ключ - автоматическое имя кластера
+// (текущее имя человека) или ID человека, значение - новое имя
+type TaskLabelRequest struct {
+	Labels map[string]string `json:"labels"`
+}
+
+// LabeledPerson - человек задачи, получивший имя
+type LabeledPerson struct {
+	ID      int    `json:"id"`
+	OldName string `json:"old_name"`
+	Name    string `json:"name"`
+}
+
+// TaskLabelResponse - итог разметки людей задачи
+type TaskLabelResponse struct {
+	TaskID  string          `json:"task_id"`
+	Renamed []LabeledPerson `json:"renamed"`
+	Unknown []string        `json:"unknown"` // Ключи, которым не нашлось человека задачи
+}
+
+// Ограничения длины имени человека в символах
+const (
+	DefaultMaxPersonNameLength = 100
+	MaxPersonNameColumnLength  = 255 // persons.name VARCHAR(255)
+)
+
+// AddTagsRequest - запрос на добавление меток человеку
+type AddTagsRequest struct {
+	Tags []string `json:"tags"`
+}
+
+// PersonTagsResponse - метки человека после изменения
+type PersonTagsResponse struct {
+	PersonID int      `json:"person_id"`
+	Tags     []string `json:"tags"`
+}
+
+// MaxTagLength - максимальная длина метки в символах
+const MaxTagLength = 50
+
+// NormalizeTag приводит метку к каноническому виду: без пробелов по краям,
+// в нижнем регистре (метки не зависят от регистра)
+func NormalizeTag(tag string) string {
+	return strings.ToLower(strings.TrimSpace(tag))
+}
+
+// BulkDeleteRequest - запрос на удаление нескольких людей
+type BulkDeleteRequest struct {
+	IDs []int `json:"ids"`
+}
+
+// BulkDeleteResponse - итог массового удаления
+type BulkDeleteResponse struct {
+	Deleted  int   `json:"deleted"`
+	NotFound []int `json:"not_found"`
+}
+
+// CacheClearResponse - какие записи кэша очищены админским запросом
+type CacheClearResponse struct {
+	Cleared []string `json:"cleared"` // Ключи или "all" при полной очистке
+}
+
+// Статусы перестройки кэша
+const (
+	CacheRebuildRunning   = "running"
+	CacheRebuildCompleted = "completed"
+	CacheRebuildFailed    = "failed"
+)
+
+// Этапы перестройки кэша
+const (
+	CacheRebuildStageFlush   = "flush"
+	CacheRebuildStageStats   = "stats"
+	CacheRebuildStagePersons = "persons"
+	CacheRebuildStageTasks   = "tasks"
+)
+
+// CacheRebuildJob - фоновая перестройка кэша (POST /api/admin/cache/rebuild)
+// Она же - payload WebSocket сообщения cache_rebuild
+type CacheRebuildJob struct {
+	ID           string     `json:"job_id"`
+	Status       string     `json:"status"`
+	Stage        string     `json:"stage"`
+	Persons      int        `json:"persons"`       // Людей уже в кэше
+	PersonsLimit int        `json:"persons_limit"` // Сколько людей прогревается (новые первыми)
+	Tasks        int        `json:"tasks"`         // Задач уже в кэше
+	TasksLimit   int        `json:"tasks_limit"`   // 0 - задачи не прогреваются
+	Error        string     `json:"error,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+}
+
+// PersonSummary - человек с количеством фото, без самих фото (для экспорта)
+type PersonSummary struct {
+	Person
+	Count int `json:"faces_count"`
+}
+
+// ExportMetadata - содержимое metadata.json в ZIP экспорте человека
+type ExportMetadata struct {
+	PersonID int                `json:"person_id"`
+	Name     string             `json:"name"`
+	Faces    []ExportFaceRecord `json:"faces"`
+}
+
+// ExportFaceRecord - описание одного лица в экспорте
+type ExportFaceRecord struct {
+	FaceID     int     `json:"face_id"`
+	Original   string  `json:"original"`            // Путь внутри архива
+	Annotated  string  `json:"annotated,omitempty"` // Путь внутри архива (если запрошен)
+	Bbox       [4]int  `json:"bbox"`                // [x, y, width, height]
+	Confidence float64 `json:"confidence"`
+}
+
+// CompareFacesRequest - запрос на сравнение двух лиц
+// Каждое лицо задается либо ID из базы, либо готовым embedding
+type CompareFacesRequest struct {
+	FaceIDA    *int      `json:"face_id_a"`
+	FaceIDB    *int      `json:"face_id_b"`
+	EmbeddingA []float64 `json:"embedding_a"`
+	EmbeddingB []float64 `json:"embedding_b"`
+}
+
+// CompareFacesResponse - результат сравнения двух лиц
+type CompareFacesResponse struct {
+	Similarity float64 `json:"similarity"` // Косинусное сходство
+	Match      bool    `json:"match"`      // Один и тот же человек
+}
+
+// DefaultDedupeThreshold - порог косинусного сходства, выше которого лица одного
+// человека считаются почти одинаковыми (серия снимков одного момента)
+const DefaultDedupeThreshold = 0.95
+
+// DedupeGroup - группа почти одинаковых лиц: остается Keep, Duplicates удаляются
+type DedupeGroup struct {
+	Keep       int   `json:"keep"`
+	Duplicates []int `json:"duplicates"`
+}
+
+// DedupeResponse - результат POST /api/persons/:id/dedupe
+type DedupeResponse struct {
+	PersonID  int           `json:"person_id"`
+	Threshold float64       `json:"threshold"`
+	Applied   bool          `json:"applied"` // false - dry-run, ничего не удалено
+	Removed   int           `json:"removed"` // Удалено (или будет удалено при apply=true) лиц
+	Groups    []DedupeGroup `json:"groups"`
+}
+
+// ReembedFailure - лицо, для которого не удалось получить новый embedding
+type ReembedFailure struct {
+	FaceID int    `json:"face_id"`
+	Error  string `json:"error"`
+}
+
+// ReembedResponse - результат POST /api/persons/:id/reembed
+type ReembedResponse struct {
+	PersonID int              `json:"person_id"`
+	Updated  int              `json:"updated"` // Лиц с обновленным embedding
+	Skipped  int              `json:"skipped"` // Лиц, уже посчитанных моделью из ?model=
+	Failed   []ReembedFailure `json:"failed"`  // Лица, оставшиеся со старым embedding
+}
+
+// FaceEmbedding - embedding лица вместе с владельцем (для пересчета центроидов людей)
+type FaceEmbedding struct {
+	PersonID  int    `db:"person_id"`
+	Embedding []byte `db:"embedding"`
+}
+
+// DuplicateCandidate - человек в паре вероятных дубликатов
+type DuplicateCandidate struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	FacesCount int        `json:"faces_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Cover      *CoverFace `json:"cover,omitempty"`
+}
+
+// FaceSearchMatch - человек, похожий на лицо с изображения (GET /api/search/face)
+type FaceSearchMatch struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	FacesCount int        `json:"faces_count"`
+	Cover      *CoverFace `json:"cover,omitempty"`
+	Similarity float64    `json:"similarity"` // Косинусное сходство лица и центроида человека
+}
+
+// FaceSearchResponse - результат поиска людей по изображению
+type FaceSearchResponse struct {
+	ImageURL   string            `json:"image_url"`
+	FacesFound int               `json:"faces_found"`    // Лиц на изображении; ищется самое крупное
+	Bbox       []int             `json:"bbox,omitempty"` // Рамка лица, по которому шел поиск [x1, y1, x2, y2]
+	Threshold  float64           `json:"threshold"`
+	Matches    []FaceSearchMatch `json:"matches"` // Самые похожие первыми
+}
+
+// DuplicatePair - два разных человека, похожих на одного (кандидаты на слияние)
+// PersonA - более новый из двух
+type DuplicatePair struct {
+	PersonA    DuplicateCandidate `json:"person_a"`
+	PersonB    DuplicateCandidate `json:"person_b"`
+	Similarity float64            `json:"similarity"` // Косинусное сходство центроидов
+}
+
+// DuplicatePersonsResponse - результат GET /api/persons/duplicates
+type DuplicatePersonsResponse struct {
+	Threshold float64         `json:"threshold"`
+	Total     int             `json:"total"` // Всего пар выше порога (до ограничения limit)
+	Pairs     []DuplicatePair `json:"pairs"`
+}
+
+// TaskWebhookPayload - тело POST запроса на callback_url задачи
+type TaskWebhookPayload struct {
+	TaskID        string   `json:"task_id"`
+	Status        string   `json:"status"`
+	TotalImages   int      `json:"total_images"`
+	TotalFaces    int      `json:"total_faces"`
+	UniquePersons int      `json:"unique_persons"`
+	RejectedFaces int      `json:"rejected_faces"`
+	OverflowFaces int      `json:"overflow_faces"`
+	MergedFaces   int      `json:"merged_faces"`
+	Warnings      []string `json:"warnings,omitempty"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// UploadURLsRequest - загрузка изображений по URL вместо multipart
+type UploadURLsRequest struct {
+	URLs        []string `json:"urls"`
+	CallbackURL string   `json:"callback_url"`
+}
+
 // UploadResponse - ответ на загрузку файлов
 type UploadResponse struct {
 	TaskID  string `json:"task_id"`
 	Message string `json:"message"`
 }
 
+// ImportSkippedFile - файл архива импорта, который не будет обработан
+type ImportSkippedFile struct {
+	Path   string `json:"path"` // Путь внутри архива
+	Reason string `json:"reason"`
+}
+
+// ImportFolder - итог разбора одной папки (одного человека) архива импорта
+type ImportFolder struct {
+	Name    string              `json:"name"`            // Имя человека (имя папки)
+	Images  int                 `json:"images"`          // Изображений отправлено на обработку
+	Skipped []ImportSkippedFile `json:"skipped"`         // Файлы папки, которые не будут обработаны
+	Error   string              `json:"error,omitempty"` // Папка пропущена целиком (например, недопустимое имя)
+}
+
+// ImportResponse - ответ POST /api/import
+// Лица найдутся после обработки задачи; результат по файлам - GET /api/task/:id/files
+type ImportResponse struct {
+	TaskID  string              `json:"task_id"`
+	Message string              `json:"message"`
+	Folders []ImportFolder      `json:"folders"`
+	Skipped []ImportSkippedFile `json:"skipped"` // Файлы вне папок людей
+}
+
+// UploadValidationResponse - отчет POST /api/upload?validate_only=true:
+// что произойдет с загрузкой (файлы не сохраняются, задача не создается)
+type UploadValidationResponse struct {
+	Valid          bool                   `json:"valid"` // Хотя бы один файл будет обработан
+	Accepted       int                    `json:"accepted"`
+	Rejected       int                    `json:"rejected"`
+	Files          []UploadFileValidation `json:"files"`                      // В порядке загрузки
+	ExistingTaskID string                 `json:"existing_task_id,omitempty"` // Эти файлы уже обработаны - загрузка вернет эту задачу
+}
+
+// UploadFileValidation - результат проверки одного файла загрузки
+type UploadFileValidation struct {
+	FileName    string `json:"file_name"`   // Имя от клиента
+	StoredName  string `json:"stored_name"` // Имя, под которым файл будет сохранен
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type,omitempty"` // Определен по содержимому
+	Accepted    bool   `json:"accepted"`
+	Reason      string `json:"reason,omitempty"` // Причина отказа
+}
+
 // ErrorResponse - стандартный ответ с ошибкой
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error string `json:"error"` // Сообщение для человека
+	Code  string `json:"code"`  // Машиночитаемый код (apierror.Code*)
 }
 
 // Константы статусов задач
 const (
+	TaskStatusQueued     = "queued" // Ждет свободного воркера обработки
 	TaskStatusProcessing = "processing"
 	TaskStatusCompleted  = "completed"
 	TaskStatusFailed     = "failed"
 )
 
+// Параметры детекции по умолчанию
+const (
+	DefaultMinSize       = 30
+	DefaultDetThresh     = 0.5
+	DefaultMinConfidence = 0.0 // Без фильтрации
+
+	// DefaultMaxInconsistentRatio - доля лиц без метаданных или embedding в ответе Python,
+	// при превышении которой задача считается упавшей
+	DefaultMaxInconsistentRatio = 0.2
+
+	// DefaultMaxFaceOverlap - IoU рамок двух лиц одного фото, выше которого детектор
+	// считается нашедшим одно лицо дважды (остается более уверенная детекция)
+	DefaultMaxFaceOverlap = 0.7
+)
+
+// DetectionParams - параметры детекции лиц для задачи
+type DetectionParams struct {
+	MinSize       int     `json:"min_size"`       // Минимальный размер лица в пикселях
+	DetThresh     float64 `json:"det_thresh"`     // Порог уверенности детекции (0-1)
+	MinConfidence float64 `json:"min_confidence"` // Порог уверенности для сохранения лица (0-1)
+}
+
+// DefaultDetectionParams возвращает параметры детекции по умолчанию
+func DefaultDetectionParams() DetectionParams {
+	return DetectionParams{
+		MinSize:       DefaultMinSize,
+		DetThresh:     DefaultDetThresh,
+		MinConfidence: DefaultMinConfidence,
+	}
+}
+
 // PythonResponse - ответ от Python сервера
 type PythonResponse struct {
 	Success       bool                    `json:"success"`
@@ -89,12 +774,16 @@ type PythonResponse struct {
 	TotalFaces    int                     `json:"total_faces"`
 	UniquePersons int                     `json:"unique_persons"`
 	Error         string                  `json:"error,omitempty"`
+	Model         string                  `json:"embedding_model"` // Модель embedding (например insightface/buffalo_l)
 }
 
 // FaceMetadata метаданные о лице от Python
 type FaceMetadata struct {
-	OriginalImage string  `json:"original_image"` // Путь к оригинальному фото
-	BoxedImage    string  `json:"boxed_image"`    // Путь к фото с bbox
-	Bbox          []int   `json:"bbox"`           // [x1, y1, x2, y2]
-	Confidence    float64 `json:"confidence"`     // Уверенность детекции
+	OriginalImage string      `json:"original_image"`      // Путь к оригинальному фото
+	BoxedImage    string      `json:"boxed_image"`         // Путь к фото с bbox
+	Bbox          []int       `json:"bbox"`                // [x1, y1, x2, y2]
+	Confidence    float64     `json:"confidence"`          // Уверенность детекции
+	Landmarks     [][]float64 `json:"landmarks,omitempty"` // 5 точек [x, y]: глаза, нос, углы рта
+	ImageWidth    int         `json:"image_width"`         // Размер оригинального фото (0 - неизвестен)
+	ImageHeight   int         `json:"image_height"`
 }
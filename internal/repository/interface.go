@@ -1,29 +1,85 @@
 package repository
 
-import "face-recognition/internal/models"
+import (
+	"context"
+
+	"face-recognition/internal/models"
+)
 
 // RepositoryInterface определяет контракт для работы с данными
 // Это позволяет легко мокать репозиторий в тестах
 type RepositoryInterface interface {
+	// WithContext возвращает репозиторий, запросы которого попадают в trace из ctx
+	WithContext(ctx context.Context) RepositoryInterface
+
 	// Tasks
-	CreateTask(taskID string, totalImages int) error
+	CreateTask(task *models.Task) error
 	GetTask(taskID string) (*models.Task, error)
+	GetCompletedTaskByHash(contentHash string) (*models.Task, error)
 	UpdateTaskStatus(taskID, status string, errorMsg *string) error
-	UpdateTaskStats(taskID string, totalFaces, uniquePersons int) error
+	MarkTaskStarted(taskID string) error
+	UpdateTaskStats(taskID string, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces int) error
+	UpdateTaskWarnings(taskID string, warnings []string) error
+	UpdateTaskProgress(taskID string, percent int, stage string) error
+	SaveTaskFiles(taskID string, files []models.TaskFile) error
+	GetTaskFiles(taskID string) ([]models.TaskFile, error)
+	SaveOriginalFilenames(taskID string, names map[string]string) error
+	GetOriginalFilenames(taskID string) (map[string]string, error)
+	GetTaskFaces(taskID string) ([]models.Face, error)
+	ResetTaskForReprocess(taskID string, params models.DetectionParams) ([]models.Person, []models.Face, error)
+	DeleteTaskFaces(taskID string) ([]models.Person, []models.Face, error)
+	DeleteTask(taskID string) (*models.Task, []models.Person, []models.Face, error)
+	GetInterruptedTasks() ([]models.Task, error)
+	RequeueInterruptedTask(taskID string) ([]models.Person, []models.Face, error)
+	GetTaskIDs() ([]string, error)
+	GetRecentTasks(limit int) ([]models.Task, error)
+	LinkTaskPerson(taskID string, personID int, created bool) error
+	GetPersonsByTask(taskID string) ([]models.TaskPerson, error)
 
 	// Persons
-	GetOrCreatePerson(name string) (int, error)
+	GetOrCreatePerson(name string) (int, bool, error)
+	MarkPersonLabeled(id int) error
 	GetAllPersons() ([]models.PersonWithFaces, error)
+	GetPersonsByIDs(ids []int) ([]models.PersonWithFaces, error)
+	GetPersonsByTag(tag string) ([]models.PersonWithFaces, error)
+	GetUnlabeledPersons() ([]models.PersonWithFaces, error)
+	GetPersonsPage(afterID, limit int) ([]models.PersonWithFaces, error)
+	GetPersonsAfter(cursor *models.PersonCursor, limit int) ([]models.PersonWithFaces, error)
+	GetPersonsOffset(offset, limit int) ([]models.PersonWithFaces, error)
 	GetPersonByID(id int) (*models.PersonWithFaces, error)
 	UpdatePersonName(id int, name string) error
-	DeletePerson(id int) ([]models.Face, error)
-	SearchPersons(query string) ([]models.PersonWithFaces, error)
+	LabelTaskPersons(taskID string, labels map[string]string) ([]models.LabeledPerson, []string, error)
+	UpdatePersonCover(personID, faceID int) (*models.Person, error)
+	DeletePerson(id int) (*models.Person, error)
+	RestorePerson(id int) (*models.Person, error)
+	HardDeletePerson(id int) (*models.Person, []string, error)
+	DeletePersons(ids []int) ([]models.Person, error)
+	HardDeletePersons(ids []int) ([]models.Person, []string, error)
+	SearchPersons(query string, opts models.SearchOptions) ([]models.PersonWithFaces, int, error)
+	AddPersonTags(personID int, tags []string) ([]string, error)
+	RemovePersonTag(personID int, tag string) error
 
 	// Faces
 	CreateFace(face *models.Face) error
+	GetFaceByID(id int) (*models.Face, error)
+	CountPersonFaces(personID int) (int, error)
+	GetPersonFaceCount(personID int) (int, error)
+	ListFaces(minConf, maxConf, minQuality float64, limit, offset int) ([]models.FaceWithPerson, int, error)
+	UpdateFaceEmbedding(faceID int, embedding []byte, model string) error
+	DeleteFaces(personID int, faceIDs []int) ([]models.Face, []string, error)
+	GetFaceFileKeys() ([]string, error)
+	GetEmbeddingsPage(afterFaceID, limit int) ([]models.LabeledEmbedding, error)
+
+	// Audit
+	GetPersonAudit(personID int) ([]models.PersonAuditEntry, error)
+
+	// Centroids
+	GetPersonCentroids() (map[int][]float64, error)
+	UpdatePersonCentroid(personID int) error
 
 	// Stats
 	GetStats() (*models.Stats, error)
+	ReconcileStats() (before, after models.StatsSummary, err error)
 }
 
 // Проверяем что Repository реализует RepositoryInterface
@@ -1,31 +1,90 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"face-recognition/internal/audit"
 	"face-recognition/internal/models"
+	"face-recognition/pkg/embedding"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync/atomic"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// ErrTaskProcessing - задача еще в очереди или обрабатывается и не может быть перезапущена
+var ErrTaskProcessing = errors.New("задача уже обрабатывается")
+
 // Repository инкапсулирует всю работу с базой данных
+// Записи идут в primary, часть тяжелых чтений - в реплики (если заданы)
 type Repository struct {
-	db *sqlx.DB
+	db       tracedDB
+	replicas []*sqlx.DB
+	next     *uint64 // Счетчик round-robin по репликам (общий для копий из WithContext)
 }
 
 // NewRepository создает новый репозиторий
-func NewRepository(db *sqlx.DB) *Repository {
-	return &Repository{db: db}
+// replicas - необязательные read-only реплики для чтения
+func NewRepository(db *sqlx.DB, replicas ...*sqlx.DB) *Repository {
+	return &Repository{
+		db:       tracedDB{db: db, ctx: context.Background()},
+		replicas: replicas,
+		next:     new(uint64),
+	}
+}
+
+// WithContext возвращает репозиторий, запросы которого выполняются с ctx:
+// их спаны становятся дочерними для span из ctx
+func (r *Repository) WithContext(ctx context.Context) RepositoryInterface {
+	clone := *r
+	clone.db.ctx = ctx
+	return &clone
+}
+
+// reader возвращает соединение для чтения: следующую реплику по кругу,
+// а если реплик нет - primary
+// Данные на реплике могут отставать от только что записанных в primary
+func (r *Repository) reader() tracedDB {
+	if len(r.replicas) == 0 {
+		return r.db
+	}
+	n := atomic.AddUint64(r.next, 1)
+	return tracedDB{db: r.replicas[(n-1)%uint64(len(r.replicas))], ctx: r.db.ctx}
 }
 
 // ============ TASKS ============
 
-// CreateTask создает новую задачу обработки
-func (r *Repository) CreateTask(taskID string, totalImages int) error {
-	_, err := r.db.Exec(`
-		INSERT INTO tasks (id, status, total_images, created_at) 
-		VALUES ($1, $2, $3, NOW())
-	`, taskID, models.TaskStatusProcessing, totalImages)
-	return err
+// CreateTask создает новую задачу в статусе queued (processing она получит в MarkTaskStarted)
+// Используются поля ID, TotalImages, MinSize, DetThresh, MinConfidence, CallbackURL и ContentHash;
+// Status и CreatedAt заполняются из БД
+func (r *Repository) CreateTask(task *models.Task) error {
+	return r.db.QueryRow(`
+		INSERT INTO tasks (id, status, total_images, min_size, det_thresh, min_confidence, callback_url, content_hash, created_at) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+		RETURNING status, created_at
+	`, task.ID, models.TaskStatusQueued, task.TotalImages, task.MinSize, task.DetThresh,
+		task.MinConfidence, task.CallbackURL, task.ContentHash).Scan(&task.Status, &task.CreatedAt)
+}
+
+// GetCompletedTaskByHash находит последнюю завершенную задачу с тем же хэшем загрузки
+// Возвращает sql.ErrNoRows если такой задачи нет
+func (r *Repository) GetCompletedTaskByHash(contentHash string) (*models.Task, error) {
+	var task models.Task
+	err := r.db.Get(&task, `
+		SELECT * FROM tasks
+		WHERE content_hash = $1 AND status = $2
+		ORDER BY created_at DESC
+		LIMIT 1
+	`, contentHash, models.TaskStatusCompleted)
+	if err != nil {
+		return nil, err
+	}
+	return &task, nil
 }
 
 // GetTask получает задачу по ID
@@ -58,147 +117,443 @@ func (r *Repository) UpdateTaskStatus(taskID, status string, errorMsg *string) e
 }
 
 // UpdateTaskStats обновляет статистику задачи
-func (r *Repository) UpdateTaskStats(taskID string, totalFaces, uniquePersons int) error {
+// rejectedFaces - лица, отброшенные по min_confidence; overflowFaces - не сохраненные
+// из-за лимита лиц человека; mergedFaces - повторные детекции одного лица
+func (r *Repository) UpdateTaskStats(taskID string, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces int) error {
 	_, err := r.db.Exec(`
 		UPDATE tasks 
-		SET total_faces = $1, unique_persons = $2 
-		WHERE id = $3
-	`, totalFaces, uniquePersons, taskID)
+		SET total_faces = $1, unique_persons = $2, rejected_faces = $3, overflow_faces = $4, merged_faces = $5 
+		WHERE id = $6
+	`, totalFaces, uniquePersons, rejectedFaces, overflowFaces, mergedFaces, taskID)
 	return err
 }
 
-// ============ PERSONS ============
+// UpdateTaskWarnings сохраняет предупреждения о несогласованном ответе Python
+func (r *Repository) UpdateTaskWarnings(taskID string, warnings []string) error {
+	_, err := r.db.Exec(`
+		UPDATE tasks 
+		SET warnings = $1 
+		WHERE id = $2
+	`, pq.Array(warnings), taskID)
+	return err
+}
 
-// GetOrCreatePerson получает или создает персону по имени
-func (r *Repository) GetOrCreatePerson(name string) (int, error) {
-	var personID int
+// MarkTaskStarted переводит задачу из очереди в processing и запоминает момент начала обработки
+func (r *Repository) MarkTaskStarted(taskID string) error {
+	_, err := r.db.Exec(`
+		UPDATE tasks 
+		SET status = $1, processing_started_at = NOW() 
+		WHERE id = $2
+	`, models.TaskStatusProcessing, taskID)
+	return err
+}
 
-	// Пробуем найти существующую
-	err := r.db.QueryRow(`
-		SELECT id FROM persons WHERE name = $1
-	`, name).Scan(&personID)
+// UpdateTaskProgress сохраняет прогресс обработки задачи (0-100) и текущий этап
+// Позволяет узнать состояние задачи без WebSocket (например, после переподключения)
+func (r *Repository) UpdateTaskProgress(taskID string, percent int, stage string) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("прогресс должен быть в диапазоне 0-100, получено %d", percent)
+	}
 
-	// Если не найдена - создаем
-	if err == sql.ErrNoRows {
-		err = r.db.QueryRow(`
-			INSERT INTO persons (name) 
-			VALUES ($1) 
-			RETURNING id
-		`, name).Scan(&personID)
+	_, err := r.db.Exec(`
+		UPDATE tasks 
+		SET progress = $1, stage = $2 
+		WHERE id = $3
+	`, percent, stage, taskID)
+	return err
+}
+
+// SaveTaskFiles сохраняет результаты обработки файлов задачи,
+// заменяя результаты предыдущей обработки (reprocess)
+func (r *Repository) SaveTaskFiles(taskID string, files []models.TaskFile) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM task_files WHERE task_id = $1", taskID); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if _, err := tx.Exec(`
+			INSERT INTO task_files (task_id, file_name, faces_count, error, original_filename) 
+			VALUES ($1, $2, $3, $4, $5)
+		`, taskID, file.FileName, file.FacesCount, file.Error, file.OriginalFilename); err != nil {
+			return err
+		}
 	}
 
-	return personID, err
+	return tx.Commit()
 }
 
-// GetAllPersons возвращает всех людей с количеством фото
-func (r *Repository) GetAllPersons() ([]models.PersonWithFaces, error) {
-	rows, err := r.db.Query(`
-		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count
-		FROM persons p
-		LEFT JOIN faces f ON p.id = f.person_id
-		GROUP BY p.id
-		ORDER BY p.created_at DESC
-	`)
+// GetTaskFiles возвращает результаты обработки файлов задачи, по имени файла
+func (r *Repository) GetTaskFiles(taskID string) ([]models.TaskFile, error) {
+	files := []models.TaskFile{}
+	err := r.db.Select(&files, `
+		SELECT task_id, file_name, faces_count, error, original_filename 
+		FROM task_files 
+		WHERE task_id = $1 
+		ORDER BY file_name
+	`, taskID)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	return files, nil
+}
 
-	var persons []models.PersonWithFaces
-	for rows.Next() {
-		var p models.PersonWithFaces
-		err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count)
-		if err != nil {
-			continue
+// SaveOriginalFilenames запоминает исходные имена файлов задачи, сохраненных
+// под другим именем (имя в хранилище → имя от клиента)
+func (r *Repository) SaveOriginalFilenames(taskID string, names map[string]string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for fileName, original := range names {
+		if _, err := tx.Exec(`
+			INSERT INTO task_file_names (task_id, file_name, original_filename) 
+			VALUES ($1, $2, $3)
+			ON CONFLICT (task_id, file_name) DO UPDATE SET original_filename = EXCLUDED.original_filename
+		`, taskID, fileName, original); err != nil {
+			return err
 		}
-		persons = append(persons, p)
 	}
 
-	return persons, nil
+	return tx.Commit()
 }
 
-// GetPersonByID получает человека по ID со всеми фото
-func (r *Repository) GetPersonByID(id int) (*models.PersonWithFaces, error) {
-	var person models.PersonWithFaces
+// GetOriginalFilenames возвращает исходные имена файлов задачи (имя в хранилище → имя
+// от клиента); файлов, сохраненных под своим именем, в ней нет
+func (r *Repository) GetOriginalFilenames(taskID string) (map[string]string, error) {
+	var rows []struct {
+		FileName string `db:"file_name"`
+		Original string `db:"original_filename"`
+	}
+	if err := r.db.Select(&rows, "SELECT file_name, original_filename FROM task_file_names WHERE task_id = $1", taskID); err != nil {
+		return nil, err
+	}
 
-	// Получаем персону
-	err := r.db.Get(&person.Person, "SELECT * FROM persons WHERE id = $1", id)
+	names := make(map[string]string, len(rows))
+	for _, row := range rows {
+		names[row.FileName] = row.Original
+	}
+	return names, nil
+}
+
+// GetTaskFaces возвращает лица, найденные задачей, по возрастанию ID
+func (r *Repository) GetTaskFaces(taskID string) ([]models.Face, error) {
+	faces := []models.Face{}
+	err := r.reader().Select(&faces, "SELECT * FROM faces WHERE task_id = $1 ORDER BY id", taskID)
 	if err != nil {
 		return nil, err
 	}
+	return faces, nil
+}
 
-	// Получаем все фото
-	err = r.db.Select(&person.Faces, `
-		SELECT id, person_id, original_image, annotated_image, 
-		       face_x, face_y, face_width, face_height,
-		       embedding, confidence, detected_at 
-		FROM faces 
-		WHERE person_id = $1 
-		ORDER BY detected_at DESC
-	`, id)
-	if err != nil && err != sql.ErrNoRows {
+// GetInterruptedTasks возвращает задачи в статусе queued или processing
+// При запуске сервера это задачи, обработку которых прервала остановка:
+// очередь хранится в памяти, и их больше никто не обработает
+func (r *Repository) GetInterruptedTasks() ([]models.Task, error) {
+	var tasks []models.Task
+	err := r.reader().Select(&tasks, `
+		SELECT * FROM tasks 
+		WHERE status IN ($1, $2) 
+		ORDER BY created_at
+	`, models.TaskStatusQueued, models.TaskStatusProcessing)
+	if err != nil {
 		return nil, err
 	}
+	return tasks, nil
+}
 
-	if person.Faces == nil {
-		person.Faces = []models.Face{} // Пустой массив вместо nil
+// LinkTaskPerson отмечает, что задача сохранила лица в человека
+// created - человек создан этой задачей; повторная связь created не сбрасывает
+func (r *Repository) LinkTaskPerson(taskID string, personID int, created bool) error {
+	_, err := r.db.Exec(`
+		INSERT INTO task_persons (task_id, person_id, created)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (task_id, person_id) DO UPDATE SET created = task_persons.created OR EXCLUDED.created
+	`, taskID, personID, created)
+	return err
+}
+
+// GetPersonsByTask возвращает неудаленных людей задачи по возрастанию ID: созданных ею
+// и дополненных (созданных раньше другой задачей), с числом лиц этой задачи
+func (r *Repository) GetPersonsByTask(taskID string) ([]models.TaskPerson, error) {
+	persons := []models.TaskPerson{}
+	err := r.reader().Select(&persons, `
+		SELECT p.*, tp.created,
+		       (SELECT COUNT(*) FROM faces f WHERE f.person_id = p.id AND f.task_id = tp.task_id) AS task_faces
+		FROM task_persons tp
+		JOIN persons p ON p.id = tp.person_id
+		WHERE tp.task_id = $1 AND p.deleted_at IS NULL
+		ORDER BY p.id
+	`, taskID)
+	return persons, err
+}
+
+// GetTaskIDs возвращает ID всех задач (с primary, как и GetFaceFileKeys)
+func (r *Repository) GetTaskIDs() ([]string, error) {
+	var ids []string
+	err := r.db.Select(&ids, "SELECT id FROM tasks")
+	return ids, err
+}
+
+// GetRecentTasks возвращает limit последних созданных задач (новые первыми)
+func (r *Repository) GetRecentTasks(limit int) ([]models.Task, error) {
+	tasks := []models.Task{}
+	err := r.reader().Select(&tasks, "SELECT * FROM tasks ORDER BY created_at DESC, id LIMIT $1", limit)
+	return tasks, err
+}
+
+// RequeueInterruptedTask возвращает прерванную задачу в очередь (queued) с прежними параметрами
+// Лица, которые задача успела сохранить, удаляются вместе с людьми, у которых не осталось лиц,
+// чтобы повторная обработка не создала дубликаты
+// Возвращает удаленных людей и лица (для удаления файлов и инвалидации кэша)
+// sql.ErrNoRows - задачи нет или она уже не в queued/processing
+func (r *Repository) RequeueInterruptedTask(taskID string) ([]models.Person, []models.Face, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
 	}
+	defer tx.Rollback()
 
-	person.Count = len(person.Faces)
-	return &person, nil
+	result, err := tx.Exec(`
+		UPDATE tasks
+		SET status = $2, total_faces = 0, unique_persons = 0, rejected_faces = 0, overflow_faces = 0, merged_faces = 0, warnings = '{}',
+		    progress = 0, stage = '',
+		    error_message = NULL, processing_started_at = NULL, completed_at = NULL
+		WHERE id = $1 AND status IN ($3, $4)
+	`, taskID, models.TaskStatusQueued, models.TaskStatusQueued, models.TaskStatusProcessing)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return nil, nil, sql.ErrNoRows
+	}
+
+	persons, faces, err := deleteTaskFaces(tx, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return persons, faces, nil
 }
 
-// UpdatePersonName обновляет имя человека
-func (r *Repository) UpdatePersonName(id int, name string) error {
-	result, err := r.db.Exec(`
-		UPDATE persons 
-		SET name = $1, updated_at = NOW() 
-		WHERE id = $2
-	`, name, id)
+// ResetTaskForReprocess ставит задачу обратно в очередь (queued) с новыми параметрами
+// и удаляет найденные в ней лица, а также людей, у которых не осталось лиц
+// Хэш загрузки сбрасывается: результат больше не соответствует исходным параметрам
+// Возвращает удаленных людей и лица (для удаления файлов и инвалидации кэша)
+// sql.ErrNoRows - задачи нет, ErrTaskProcessing - задача еще в очереди или обрабатывается
+func (r *Repository) ResetTaskForReprocess(taskID string, params models.DetectionParams) ([]models.Person, []models.Face, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
 
+	// Проверка статуса и смена в одном UPDATE - два параллельных запроса
+	// не смогут перезапустить задачу дважды
+	result, err := tx.Exec(`
+		UPDATE tasks
+		SET status = $2, min_size = $3, det_thresh = $4, min_confidence = $5,
+		    total_faces = 0, unique_persons = 0, rejected_faces = 0, overflow_faces = 0, merged_faces = 0, content_hash = '', warnings = '{}',
+		    progress = 0, stage = '',
+		    error_message = NULL, processing_started_at = NULL, completed_at = NULL
+		WHERE id = $1 AND status NOT IN ($6, $7)
+	`, taskID, models.TaskStatusQueued, params.MinSize, params.DetThresh, params.MinConfidence,
+		models.TaskStatusQueued, models.TaskStatusProcessing)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return sql.ErrNoRows
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		var status string
+		if err := tx.Get(&status, "SELECT status FROM tasks WHERE id = $1", taskID); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrTaskProcessing
 	}
 
-	return nil
+	persons, faces, err := deleteTaskFaces(tx, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return persons, faces, nil
 }
 
-// DeletePerson удаляет человека (faces удалятся автоматически через CASCADE)
-func (r *Repository) DeletePerson(id int) ([]models.Face, error) {
-	// Сначала получаем все фото для удаления файлов
+// DeleteTaskFaces удаляет лица задачи и людей, у которых после этого не осталось лиц,
+// не меняя саму задачу (откат результатов задачи, не уложившейся в PROCESSING_TIMEOUT)
+// Возвращает удаленных людей и лица (для удаления файлов и инвалидации кэша)
+func (r *Repository) DeleteTaskFaces(taskID string) ([]models.Person, []models.Face, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	persons, faces, err := deleteTaskFaces(tx, taskID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return persons, faces, nil
+}
+
+// deleteTaskFaces удаляет лица задачи и людей, у которых после этого не осталось лиц
+// Удаление лиц записывается в журнал каждого затронутого человека
+func deleteTaskFaces(tx *tracedTx, taskID string) ([]models.Person, []models.Face, error) {
 	var faces []models.Face
-	r.db.Select(&faces, "SELECT * FROM faces WHERE person_id = $1", id)
+	if err := tx.Select(&faces, "DELETE FROM faces WHERE task_id = $1 RETURNING *", taskID); err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeAudit(tx, facesRemovedAudit(faces, taskID)...); err != nil {
+		return nil, nil, err
+	}
+
+	// Лиц задачи у людей больше нет - и связи задачи с ними тоже
+	if _, err := tx.Exec("DELETE FROM task_persons WHERE task_id = $1", taskID); err != nil {
+		return nil, nil, err
+	}
+
+	personIDs := make([]int, 0, len(faces))
+	for _, face := range faces {
+		personIDs = append(personIDs, face.PersonID)
+	}
+
+	var persons []models.Person
+	if err := tx.Select(&persons, `
+		DELETE FROM persons p
+		WHERE p.id = ANY($1)
+		  AND NOT EXISTS (SELECT 1 FROM faces f WHERE f.person_id = p.id)
+		RETURNING p.*
+	`, pq.Array(personIDs)); err != nil {
+		return nil, nil, err
+	}
+
+	// У оставшихся людей лиц стало меньше - центроид пересчитывается
+	removed := make(map[int]bool, len(persons))
+	for _, person := range persons {
+		removed[person.ID] = true
+	}
+	remaining := make([]int, 0, len(personIDs))
+	for _, id := range personIDs {
+		if !removed[id] {
+			remaining = append(remaining, id)
+		}
+	}
+	if err := refreshCentroids(tx, remaining); err != nil {
+		return nil, nil, err
+	}
+
+	return persons, faces, nil
+}
 
-	// Удаляем из БД
-	result, err := r.db.Exec("DELETE FROM persons WHERE id = $1", id)
+// DeleteTask удаляет задачу, ее лица и людей, у которых не осталось других лиц
+// Возвращает удаленную задачу, людей и лица (для очистки файлов и кэша)
+// sql.ErrNoRows - задачи нет, ErrTaskProcessing - задача еще обрабатывается
+func (r *Repository) DeleteTask(taskID string) (*models.Task, []models.Person, []models.Face, error) {
+	tx, err := r.db.Beginx()
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
+	defer tx.Rollback()
 
-	rows, _ := result.RowsAffected()
-	if rows == 0 {
-		return nil, sql.ErrNoRows
+	// Задачу в очереди или в обработке не удаляем - processImages продолжил бы сохранять ее лица
+	var task models.Task
+	err = tx.Get(&task, `
+		DELETE FROM tasks WHERE id = $1 AND status NOT IN ($2, $3) RETURNING *
+	`, taskID, models.TaskStatusQueued, models.TaskStatusProcessing)
+	if err == sql.ErrNoRows {
+		var status string
+		if err := tx.Get(&status, "SELECT status FROM tasks WHERE id = $1", taskID); err != nil {
+			return nil, nil, nil, err
+		}
+		return nil, nil, nil, ErrTaskProcessing
+	}
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
-	return faces, nil
+	persons, faces, err := deleteTaskFaces(tx, taskID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, nil, err
+	}
+
+	return &task, persons, faces, nil
 }
 
-// SearchPersons ищет людей по имени или ID
-func (r *Repository) SearchPersons(query string) ([]models.PersonWithFaces, error) {
-	rows, err := r.db.Query(`
-		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count
+// ============ PERSONS ============
+
+// GetOrCreatePerson получает или создает персону по имени
+// created = true если персона была создана этим вызовом
+// Удаленные (soft-delete) люди не учитываются - для них создается новая персона
+func (r *Repository) GetOrCreatePerson(name string) (int, bool, error) {
+	var personID int
+
+	// Пробуем найти существующую
+	err := r.db.QueryRow(`
+		SELECT id FROM persons WHERE name = $1 AND deleted_at IS NULL
+	`, name).Scan(&personID)
+
+	if err != sql.ErrNoRows {
+		return personID, false, err
+	}
+
+	// Если не найдена - создаем
+	err = r.db.QueryRow(`
+		INSERT INTO persons (name) 
+		VALUES ($1) 
+		RETURNING id
+	`, name).Scan(&personID)
+
+	return personID, err == nil, err
+}
+
+// coverJoin присоединяет к persons p лицо-обложку cf: выбранное вручную или,
+// если обложка не задана, лицо с наибольшей уверенностью, умноженной на резкость
+// Лица без оценки резкости идут после оцененных, между собой - по уверенности
+const coverJoin = `
+		LEFT JOIN faces cf ON cf.id = COALESCE(p.cover_face_id, (
+			SELECT id FROM faces WHERE person_id = p.id
+			ORDER BY confidence * COALESCE(quality, 0) DESC, confidence DESC, id LIMIT 1
+		))
+`
+
+// GetAllPersons возвращает всех людей с количеством фото и обложкой
+func (r *Repository) GetAllPersons() ([]models.PersonWithFaces, error) {
+	rows, err := r.reader().Query(`
+		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count,
+		       cf.id, cf.annotated_image
 		FROM persons p
 		LEFT JOIN faces f ON p.id = f.person_id
-		WHERE p.name ILIKE $1 OR CAST(p.id AS TEXT) = $2
-		GROUP BY p.id
+	` + coverJoin + `
+		WHERE p.deleted_at IS NULL
+		GROUP BY p.id, cf.id
 		ORDER BY p.created_at DESC
-	`, "%"+query+"%", query)
-
+	`)
 	if err != nil {
 		return nil, err
 	}
@@ -207,53 +562,1130 @@ func (r *Repository) SearchPersons(query string) ([]models.PersonWithFaces, erro
 	var persons []models.PersonWithFaces
 	for rows.Next() {
 		var p models.PersonWithFaces
-		err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count)
+		var coverID sql.NullInt64
+		var coverImage sql.NullString
+		err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count, &coverID, &coverImage)
 		if err != nil {
 			continue
 		}
+		p.Cover = scanCover(coverID, coverImage)
 		persons = append(persons, p)
 	}
 
 	return persons, nil
 }
 
-// ============ FACES ============
-
-// CreateFace добавляет новое лицо в базу
-func (r *Repository) CreateFace(face *models.Face) error {
-	_, err := r.db.Exec(`
-		INSERT INTO faces (
-			person_id, original_image, annotated_image,
-			face_x, face_y, face_width, face_height,
-			embedding, confidence
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
-	`, face.PersonID, face.OriginalImage, face.AnnotatedImage,
-		face.FaceX, face.FaceY, face.FaceWidth, face.FaceHeight,
-		face.Embedding, face.Confidence)
+// GetPersonsByIDs возвращает людей с обложкой из ids (удаленные пропускаются)
+// Порядок - как у GetAllPersons
+func (r *Repository) GetPersonsByIDs(ids []int) ([]models.PersonWithFaces, error) {
+	return r.listPersons("AND p.id = ANY($1)", "", pq.Array(ids))
+}
 
-	return err
+// GetPersonsAfter возвращает до limit людей с обложкой, созданных раньше курсора
+// (новые первыми, при равном created_at - по убыванию id); nil - первая страница
+func (r *Repository) GetPersonsAfter(cursor *models.PersonCursor, limit int) ([]models.PersonWithFaces, error) {
+	if cursor == nil {
+		return r.listPersons("", "LIMIT $1", limit)
+	}
+	// TIMESTAMP без часового пояса: сравниваем с тем же локальным временем, что прочитали
+	return r.listPersons("AND (p.created_at, p.id) < ($1::timestamp, $2)", "LIMIT $3",
+		cursor.CreatedAt.Format(cursorTimeLayout), cursor.ID, limit)
 }
 
-// ============ STATS ============
+// GetPersonsOffset возвращает страницу людей по смещению (устаревший способ: люди,
+// созданные между запросами страниц, сдвигают их - см. GetPersonsAfter)
+func (r *Repository) GetPersonsOffset(offset, limit int) ([]models.PersonWithFaces, error) {
+	return r.listPersons("", "LIMIT $1 OFFSET $2", limit, offset)
+}
 
-// GetStats возвращает общую статистику
-func (r *Repository) GetStats() (*models.Stats, error) {
-	var stats models.Stats
+// cursorTimeLayout - created_at курсора в виде литерала TIMESTAMP (точность PostgreSQL - микросекунды)
+const cursorTimeLayout = "2006-01-02 15:04:05.999999"
 
-	err := r.db.Get(&stats.TotalPersons, "SELECT COUNT(*) FROM persons")
+// listPersons - список людей с обложкой в порядке GetAllPersons (с id для однозначности)
+// filter дополняет WHERE, page - LIMIT/OFFSET
+func (r *Repository) listPersons(filter, page string, args ...interface{}) ([]models.PersonWithFaces, error) {
+	rows, err := r.reader().Query(`
+		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count,
+		       cf.id, cf.annotated_image
+		FROM persons p
+		LEFT JOIN faces f ON p.id = f.person_id
+	`+coverJoin+`
+		WHERE p.deleted_at IS NULL `+filter+`
+		GROUP BY p.id, cf.id
+		ORDER BY p.created_at DESC, p.id DESC
+		`+page, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	persons := []models.PersonWithFaces{}
+	for rows.Next() {
+		var p models.PersonWithFaces
+		var coverID sql.NullInt64
+		var coverImage sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count, &coverID, &coverImage); err != nil {
+			return nil, err
+		}
+		p.Cover = scanCover(coverID, coverImage)
+		persons = append(persons, p)
+	}
+
+	return persons, rows.Err()
+}
+
+// scanCover собирает обложку из колонок coverJoin (nil - у человека нет лиц)
+func scanCover(id sql.NullInt64, annotatedImage sql.NullString) *models.CoverFace {
+	if !id.Valid {
+		return nil
+	}
+	return &models.CoverFace{FaceID: int(id.Int64), AnnotatedImage: annotatedImage.String}
+}
 
-	err = r.db.Get(&stats.TotalFaces, "SELECT COUNT(*) FROM faces")
+// GetPersonsByTag возвращает людей с указанной меткой (без учета регистра)
+func (r *Repository) GetPersonsByTag(tag string) ([]models.PersonWithFaces, error) {
+	rows, err := r.reader().Query(`
+		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count,
+		       cf.id, cf.annotated_image
+		FROM persons p
+		JOIN person_tags pt ON pt.person_id = p.id
+		JOIN tags t ON t.id = pt.tag_id AND t.name = $1
+		LEFT JOIN faces f ON p.id = f.person_id
+	`+coverJoin+`
+		WHERE p.deleted_at IS NULL
+		GROUP BY p.id, cf.id
+		ORDER BY p.created_at DESC
+	`, models.NormalizeTag(tag))
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
+	var persons []models.PersonWithFaces
+	for rows.Next() {
+		var p models.PersonWithFaces
+		var coverID sql.NullInt64
+		var coverImage sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count, &coverID, &coverImage); err != nil {
+			return nil, err
+		}
+		p.Cover = scanCover(coverID, coverImage)
+		persons = append(persons, p)
+	}
+
+	return persons, rows.Err()
+}
+
+// GetUnlabeledPersons возвращает людей, которых еще не переименовывали (is_labeled = FALSE):
+// у них автоматическое имя кластера. Порядок - как у GetAllPersons
+func (r *Repository) GetUnlabeledPersons() ([]models.PersonWithFaces, error) {
+	return r.listPersons("AND NOT p.is_labeled", "")
+}
 
-	err = r.db.Get(&stats.TotalTasks, "SELECT COUNT(*) FROM tasks")
+// MarkPersonLabeled отмечает человека подписанным без смены имени (люди импорта
+// создаются сразу с именем папки)
+func (r *Repository) MarkPersonLabeled(id int) error {
+	_, err := r.db.Exec(`UPDATE persons SET is_labeled = TRUE WHERE id = $1`, id)
+	return err
+}
+
+// GetPersonsPage возвращает до limit людей с id > afterID, упорядоченных по id
+// Используется для постраничного обхода всего каталога (экспорт)
+func (r *Repository) GetPersonsPage(afterID, limit int) ([]models.PersonWithFaces, error) {
+	rows, err := r.db.Query(`
+		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count
+		FROM persons p
+		LEFT JOIN faces f ON p.id = f.person_id
+		WHERE p.id > $1 AND p.deleted_at IS NULL
+		GROUP BY p.id
+		ORDER BY p.id
+		LIMIT $2
+	`, afterID, limit)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	return &stats, nil
+	persons := make([]models.PersonWithFaces, 0, limit)
+	for rows.Next() {
+		var p models.PersonWithFaces
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count); err != nil {
+			return nil, err
+		}
+		persons = append(persons, p)
+	}
+
+	return persons, rows.Err()
+}
+
+// GetPersonByID получает человека по ID со всеми фото
+func (r *Repository) GetPersonByID(id int) (*models.PersonWithFaces, error) {
+	var person models.PersonWithFaces
+	db := r.reader() // Оба запроса - к одной и той же реплике
+
+	// Получаем персону
+	err := db.Get(&person.Person, "SELECT * FROM persons WHERE id = $1 AND deleted_at IS NULL", id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Получаем все фото
+	err = db.Select(&person.Faces, `
+		SELECT id, person_id, original_image, original_filename, annotated_image, thumbnail_image,
+		       face_x, face_y, face_width, face_height, image_width, image_height,
+		       embedding, embedding_model, confidence, quality, captured_at, frame_time, detected_at 
+		FROM faces 
+		WHERE person_id = $1 
+		ORDER BY detected_at DESC
+	`, id)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if person.Faces == nil {
+		person.Faces = []models.Face{} // Пустой массив вместо nil
+	}
+
+	// Метки
+	person.Tags = []string{}
+	err = db.Select(&person.Tags, `
+		SELECT t.name
+		FROM tags t
+		JOIN person_tags pt ON pt.tag_id = t.id
+		WHERE pt.person_id = $1
+		ORDER BY t.name
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+
+	person.Count = len(person.Faces)
+	person.Cover = pickCover(person.CoverFaceID, person.Faces)
+	return &person, nil
+}
+
+// pickCover выбирает обложку среди лиц человека так же, как coverJoin:
+// заданную вручную, иначе лицо с наибольшей уверенностью, умноженной на резкость,
+// затем с наибольшей уверенностью (при равенстве - с меньшим ID)
+func pickCover(coverFaceID sql.NullInt64, faces []models.Face) *models.CoverFace {
+	var best *models.Face
+	for i := range faces {
+		face := &faces[i]
+		if coverFaceID.Valid && int64(face.ID) == coverFaceID.Int64 {
+			best = face
+			break
+		}
+		if best == nil || betterCover(face, best) {
+			best = face
+		}
+	}
+
+	if best == nil {
+		return nil
+	}
+	return &models.CoverFace{FaceID: best.ID, AnnotatedImage: best.AnnotatedImage}
+}
+
+// betterCover сообщает, подходит ли лицо a для обложки лучше, чем b (порядок coverJoin)
+func betterCover(a, b *models.Face) bool {
+	scoreA, scoreB := a.Confidence*qualityOrZero(a.Quality), b.Confidence*qualityOrZero(b.Quality)
+	if scoreA != scoreB {
+		return scoreA > scoreB
+	}
+	if a.Confidence != b.Confidence {
+		return a.Confidence > b.Confidence
+	}
+	return a.ID < b.ID
+}
+
+// qualityOrZero - резкость лица; не посчитанная считается нулевой
+func qualityOrZero(quality *float64) float64 {
+	if quality == nil {
+		return 0
+	}
+	return *quality
+}
+
+// UpdatePersonCover делает лицо faceID обложкой человека
+// sql.ErrNoRows если человека нет, он удален или лицо принадлежит не ему
+func (r *Repository) UpdatePersonCover(personID, faceID int) (*models.Person, error) {
+	var person models.Person
+	err := r.db.Get(&person, `
+		UPDATE persons
+		SET cover_face_id = $2, updated_at = NOW()
+		WHERE id = $1 AND deleted_at IS NULL
+		  AND EXISTS (SELECT 1 FROM faces WHERE id = $2 AND person_id = $1)
+		RETURNING *
+	`, personID, faceID)
+	if err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// UpdatePersonName обновляет имя человека (sql.ErrNoRows если его нет или он удален)
+// Смена имени записывается в журнал событием renamed
+func (r *Repository) UpdatePersonName(id int, name string) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldName string
+	if err := tx.Get(&oldName, "SELECT name FROM persons WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id); err != nil {
+		return err
+	}
+
+	entries, err := renamePerson(tx, id, oldName, name)
+	if err != nil {
+		return err
+	}
+	if err := writeAudit(tx, entries...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// renamePerson меняет имя человека в транзакции, отмечает его подписанным (is_labeled)
+// и возвращает запись журнала renamed (пустой список, если имя не изменилось)
+func renamePerson(tx *tracedTx, id int, oldName, name string) ([]auditEntry, error) {
+	if _, err := tx.Exec(`
+		UPDATE persons 
+		SET name = $1, is_labeled = TRUE, updated_at = NOW() 
+		WHERE id = $2
+	`, name, id); err != nil {
+		return nil, err
+	}
+
+	if oldName == name {
+		return nil, nil
+	}
+	return []auditEntry{{
+		personID: id,
+		event:    models.AuditRenamed,
+		details:  map[string]interface{}{"old_name": oldName, "new_name": name},
+	}}, nil
+}
+
+// LabelTaskPersons переименовывает людей задачи в одной транзакции
+// Ключ labels - текущее имя человека (автоматическое имя кластера) или его ID;
+// учитываются только неудаленные люди задачи (task_persons)
+// Возвращает переименованных людей (по возрастанию ID) и ключи, которым не нашлось
+// человека (по алфавиту)
+func (r *Repository) LabelTaskPersons(taskID string, labels map[string]string) ([]models.LabeledPerson, []string, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var persons []models.Person
+	if err := tx.Select(&persons, `
+		SELECT * FROM persons
+		WHERE deleted_at IS NULL
+		  AND id IN (SELECT person_id FROM task_persons WHERE task_id = $1)
+		ORDER BY id
+		FOR UPDATE
+	`, taskID); err != nil {
+		return nil, nil, err
+	}
+
+	byName := make(map[string]int, len(persons))
+	byID := make(map[int]int, len(persons))
+	for i, person := range persons {
+		byName[person.Name] = i
+		byID[person.ID] = i
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// Имя ищем среди исходных имен: переименование по одному ключу не меняет
+	// сопоставление других ключей
+	newNames := make(map[int]string)
+	unknown := []string{}
+	for _, key := range keys {
+		i, ok := byName[key]
+		if !ok {
+			if id, err := strconv.Atoi(key); err == nil {
+				i, ok = byID[id]
+			}
+		}
+		if !ok {
+			unknown = append(unknown, key)
+			continue
+		}
+		newNames[i] = labels[key]
+	}
+
+	renamed := []models.LabeledPerson{}
+	var entries []auditEntry
+	for i, person := range persons {
+		name, ok := newNames[i]
+		if !ok {
+			continue
+		}
+		renameEntries, err := renamePerson(tx, person.ID, person.Name, name)
+		if err != nil {
+			return nil, nil, err
+		}
+		entries = append(entries, renameEntries...)
+		renamed = append(renamed, models.LabeledPerson{ID: person.ID, OldName: person.Name, Name: name})
+	}
+	if err := writeAudit(tx, entries...); err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return renamed, unknown, nil
+}
+
+// AddPersonTags добавляет человеку метки (создавая новые метки при необходимости)
+// Метки приводятся к нижнему регистру; уже назначенные метки пропускаются
+// Возвращает все метки человека; sql.ErrNoRows если человека нет или он удален
+func (r *Repository) AddPersonTags(personID int, tags []string) ([]string, error) {
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		normalized = append(normalized, models.NormalizeTag(tag))
+	}
+
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокируем человека, чтобы параллельное удаление не оставило метки "висеть"
+	var exists int
+	if err := tx.Get(&exists, "SELECT 1 FROM persons WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", personID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO tags (name) 
+		SELECT unnest($1::text[]) 
+		ON CONFLICT (name) DO NOTHING
+	`, pq.Array(normalized)); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO person_tags (person_id, tag_id) 
+		SELECT $1, id FROM tags WHERE name = ANY($2) 
+		ON CONFLICT DO NOTHING
+	`, personID, pq.Array(normalized)); err != nil {
+		return nil, err
+	}
+
+	// Метки входят в карточку человека - сдвигаем ее Last-Modified
+	if _, err := tx.Exec("UPDATE persons SET updated_at = NOW() WHERE id = $1", personID); err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	if err := tx.Select(&result, `
+		SELECT t.name
+		FROM tags t
+		JOIN person_tags pt ON pt.tag_id = t.id
+		WHERE pt.person_id = $1
+		ORDER BY t.name
+	`, personID); err != nil {
+		return nil, err
+	}
+
+	return result, tx.Commit()
+}
+
+// RemovePersonTag снимает метку с человека
+// sql.ErrNoRows если у человека нет такой метки
+func (r *Repository) RemovePersonTag(personID int, tag string) error {
+	result, err := r.db.Exec(`
+		DELETE FROM person_tags pt 
+		USING tags t 
+		WHERE pt.tag_id = t.id AND pt.person_id = $1 AND t.name = $2
+	`, personID, models.NormalizeTag(tag))
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	// Метки входят в карточку человека - сдвигаем ее Last-Modified
+	_, err = r.db.Exec("UPDATE persons SET updated_at = NOW() WHERE id = $1", personID)
+	return err
+}
+
+// DeletePerson помечает человека удаленным (soft-delete): лица и файлы сохраняются,
+// а человек пропадает из списков, поиска и статистики до RestorePerson
+// sql.ErrNoRows если человека нет или он уже удален
+func (r *Repository) DeletePerson(id int) (*models.Person, error) {
+	var person models.Person
+	err := r.db.Get(&person, `
+		UPDATE persons 
+		SET deleted_at = NOW() 
+		WHERE id = $1 AND deleted_at IS NULL 
+		RETURNING *
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// RestorePerson снимает пометку удаления с человека
+// sql.ErrNoRows если человека нет или он не удален
+func (r *Repository) RestorePerson(id int) (*models.Person, error) {
+	var person models.Person
+	err := r.db.Get(&person, `
+		UPDATE persons 
+		SET deleted_at = NULL 
+		WHERE id = $1 AND deleted_at IS NOT NULL 
+		RETURNING *
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	return &person, nil
+}
+
+// HardDeletePerson удаляет человека навсегда, в том числе помеченного удаленным
+// (faces удалятся автоматически через CASCADE)
+// Возвращает удаленного человека и ключи файлов его лиц, на которые не ссылаются
+// оставшиеся лица (см. orphanedFaceFiles). Лица читаются в той же транзакции, что и
+// удаление: лицо, сохраненное между ними, не останется без очистки файлов
+func (r *Repository) HardDeletePerson(id int) (*models.Person, []string, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Блокируем человека: новые лица не добавятся до коммита (sql.ErrNoRows если человека нет)
+	var person models.Person
+	if err := tx.Get(&person, "SELECT * FROM persons WHERE id = $1 FOR UPDATE", id); err != nil {
+		return nil, nil, err
+	}
+
+	var faces []models.Face
+	if err := tx.Select(&faces, "SELECT * FROM faces WHERE person_id = $1", id); err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM persons WHERE id = $1", id); err != nil {
+		return nil, nil, err
+	}
+
+	orphaned, err := orphanedFaceFiles(tx, faces)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return &person, orphaned, nil
+}
+
+// DeletePersons помечает нескольких людей удаленными (soft-delete, см. DeletePerson)
+// Возвращает реально помеченных: уже удаленных и несуществующих нет в списке
+func (r *Repository) DeletePersons(ids []int) ([]models.Person, error) {
+	var deleted []models.Person
+	err := r.db.Select(&deleted, `
+		UPDATE persons
+		SET deleted_at = NOW()
+		WHERE id = ANY($1) AND deleted_at IS NULL
+		RETURNING *
+	`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// HardDeletePersons удаляет нескольких людей навсегда в одной транзакции
+// Возвращает реально удаленных людей и ключи файлов их лиц, на которые не ссылаются
+// оставшиеся лица (см. orphanedFaceFiles) - только их можно удалять из хранилища
+func (r *Repository) HardDeletePersons(ids []int) ([]models.Person, []string, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	// Сначала получаем все фото для удаления файлов
+	var faces []models.Face
+	if err := tx.Select(&faces, "SELECT * FROM faces WHERE person_id = ANY($1)", pq.Array(ids)); err != nil {
+		return nil, nil, err
+	}
+
+	// Удаляем из БД (faces удалятся через CASCADE)
+	var deleted []models.Person
+	if err := tx.Select(&deleted, "DELETE FROM persons WHERE id = ANY($1) RETURNING *", pq.Array(ids)); err != nil {
+		return nil, nil, err
+	}
+
+	orphaned, err := orphanedFaceFiles(tx, faces)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return deleted, orphaned, nil
+}
+
+// SearchPersons ищет людей по имени или ID
+// Совпадение по ID идет первым, остальные результаты упорядочены по похожести
+// имени на запрос (pg_trgm). При opts.Fuzzy находятся и имена с опечатками,
+// похожесть которых не ниже opts.MinSimilarity. Возвращает страницу
+// opts.Limit/opts.Offset и общее число совпадений
+func (r *Repository) SearchPersons(query string, opts models.SearchOptions) ([]models.PersonWithFaces, int, error) {
+	// Без fuzzy порог > 1 отключает совпадение по похожести
+	minSimilarity := 2.0
+	if opts.Fuzzy {
+		minSimilarity = opts.MinSimilarity
+	}
+
+	args := []interface{}{"%" + query + "%", query, minSimilarity}
+	matches := `
+		FROM persons p
+		LEFT JOIN faces f ON p.id = f.person_id
+		WHERE p.deleted_at IS NULL
+		  AND (p.name ILIKE $1 OR CAST(p.id AS TEXT) = $2 OR similarity(p.name, $2) >= $3)
+		GROUP BY p.id
+	`
+	if opts.MinFaces > 0 {
+		args = append(args, opts.MinFaces)
+		matches += fmt.Sprintf("HAVING COUNT(f.id) >= $%d\n", len(args))
+	}
+
+	db := r.reader() // Страница и total - с одной и той же реплики
+
+	var total int
+	if err := db.Get(&total, "SELECT COUNT(*) FROM (SELECT p.id "+matches+") matches", args...); err != nil {
+		return nil, 0, err
+	}
+
+	page := `
+		SELECT p.id, p.name, p.created_at, p.updated_at, COUNT(f.id) as faces_count,
+		       similarity(p.name, $2) AS score
+	` + matches + `
+		ORDER BY CAST(p.id AS TEXT) = $2 DESC, p.name <-> $2, p.created_at DESC
+	`
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		page += fmt.Sprintf("LIMIT $%d ", len(args))
+	}
+	if opts.Offset > 0 {
+		args = append(args, opts.Offset)
+		page += fmt.Sprintf("OFFSET $%d", len(args))
+	}
+
+	rows, err := db.Query(page, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var persons []models.PersonWithFaces
+	for rows.Next() {
+		var p models.PersonWithFaces
+		err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.UpdatedAt, &p.Count, &p.Score)
+		if err != nil {
+			continue
+		}
+		persons = append(persons, p)
+	}
+
+	return persons, total, nil
+}
+
+// ============ FACES ============
+
+// CreateFace добавляет новое лицо в базу и записывает его ID в face.ID
+// Вместе с лицом в журнал человека пишется событие faces_added
+func (r *Repository) CreateFace(face *models.Face) error {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&face.ID, `
+		INSERT INTO faces (
+			person_id, task_id, original_image, annotated_image, thumbnail_image,
+			face_x, face_y, face_width, face_height, image_width, image_height,
+			embedding, embedding_model, confidence, quality, captured_at, frame_time, original_filename
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		RETURNING id
+	`, face.PersonID, face.TaskID, face.OriginalImage, face.AnnotatedImage, face.ThumbnailImage,
+		face.FaceX, face.FaceY, face.FaceWidth, face.FaceHeight, face.ImageWidth, face.ImageHeight,
+		face.Embedding, face.EmbeddingModel, face.Confidence, face.Quality, face.CapturedAt, face.FrameTime,
+		face.OriginalFilename)
+	if err != nil {
+		return err
+	}
+
+	if err := writeAudit(tx, auditEntry{
+		personID: face.PersonID,
+		event:    models.AuditFacesAdded,
+		details:  map[string]interface{}{"face_ids": []int{face.ID}, "task_id": face.TaskID},
+	}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetFaceByID получает лицо по ID (sql.ErrNoRows если лица нет)
+func (r *Repository) GetFaceByID(id int) (*models.Face, error) {
+	var face models.Face
+	if err := r.reader().Get(&face, "SELECT * FROM faces WHERE id = $1", id); err != nil {
+		return nil, err
+	}
+	return &face, nil
+}
+
+// ListFaces возвращает страницу лиц с уверенностью детекции в [minConf, maxConf]
+// и резкостью не ниже minQuality вместе с именами их людей и общее число таких лиц.
+// Сначала наименее уверенные - это вероятные ложные срабатывания. Лица удаленных
+// людей не учитываются; лица без оценки резкости - только при minQuality = 0
+func (r *Repository) ListFaces(minConf, maxConf, minQuality float64, limit, offset int) ([]models.FaceWithPerson, int, error) {
+	matches := `
+		FROM faces f
+		JOIN persons p ON p.id = f.person_id
+		WHERE p.deleted_at IS NULL
+		  AND f.confidence BETWEEN $1 AND $2
+		  AND ($3 = 0 OR f.quality >= $3)
+	`
+
+	db := r.reader() // Страница и total - с одной и той же реплики
+
+	var total int
+	if err := db.Get(&total, "SELECT COUNT(*) "+matches, minConf, maxConf, minQuality); err != nil {
+		return nil, 0, err
+	}
+
+	faces := []models.FaceWithPerson{}
+	err := db.Select(&faces, `
+		SELECT f.id, f.person_id, f.task_id, f.original_image, f.original_filename, f.annotated_image, f.thumbnail_image,
+		       f.face_x, f.face_y, f.face_width, f.face_height, f.image_width, f.image_height,
+		       f.embedding_model, f.confidence, f.quality, f.captured_at, f.frame_time, f.detected_at,
+		       p.name AS person_name
+	`+matches+`
+		ORDER BY f.confidence, f.id
+		LIMIT $4 OFFSET $5
+	`, minConf, maxConf, minQuality, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return faces, total, nil
+}
+
+// CountPersonFaces возвращает число лиц человека (0 - человека нет или у него нет лиц)
+func (r *Repository) CountPersonFaces(personID int) (int, error) {
+	var count int
+	err := r.db.Get(&count, "SELECT COUNT(*) FROM faces WHERE person_id = $1", personID)
+	return count, err
+}
+
+// GetPersonFaceCount возвращает число лиц человека одним COUNT(*), не читая сами лица
+// sql.ErrNoRows - человека нет или он удален
+func (r *Repository) GetPersonFaceCount(personID int) (int, error) {
+	var count int
+	err := r.reader().Get(&count, `
+		SELECT (SELECT COUNT(*) FROM faces f WHERE f.person_id = p.id)
+		FROM persons p
+		WHERE p.id = $1 AND p.deleted_at IS NULL
+	`, personID)
+	return count, err
+}
+
+// GetFaceFileKeys возвращает ключи всех файлов, на которые ссылаются лица
+// (оригиналы, аннотированные фото и миниатюры, без повторов)
+// Читает primary: только что сохраненное лицо может еще не дойти до реплики
+func (r *Repository) GetFaceFileKeys() ([]string, error) {
+	var keys []string
+	err := r.db.Select(&keys, `
+		SELECT original_image FROM faces WHERE original_image <> ''
+		UNION
+		SELECT annotated_image FROM faces WHERE annotated_image <> ''
+		UNION
+		SELECT thumbnail_image FROM faces WHERE thumbnail_image <> ''
+	`)
+	return keys, err
+}
+
+// GetEmbeddingsPage возвращает страницу embedding лиц неудаленных людей с их именами
+// (лица с id больше afterFaceID, по возрастанию id) - для потоковой выгрузки
+func (r *Repository) GetEmbeddingsPage(afterFaceID, limit int) ([]models.LabeledEmbedding, error) {
+	embeddings := make([]models.LabeledEmbedding, 0, limit)
+	err := r.reader().Select(&embeddings, `
+		SELECT f.id, f.person_id, p.name AS person_name, f.embedding_model, f.embedding
+		FROM faces f
+		JOIN persons p ON p.id = f.person_id
+		WHERE f.id > $1 AND f.embedding IS NOT NULL AND p.deleted_at IS NULL
+		ORDER BY f.id
+		LIMIT $2
+	`, afterFaceID, limit)
+	return embeddings, err
+}
+
+// UpdateFaceEmbedding заменяет embedding лица и модель, которой он посчитан
+// (после смены модели распознавания). sql.ErrNoRows если лица уже нет
+func (r *Repository) UpdateFaceEmbedding(faceID int, embedding []byte, model string) error {
+	result, err := r.db.Exec(`UPDATE faces SET embedding = $1, embedding_model = $2 WHERE id = $3`, embedding, model, faceID)
+	if err != nil {
+		return err
+	}
+
+	rows, _ := result.RowsAffected()
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteFaces удаляет лица человека по ID в одной транзакции (с записью faces_removed в журнал)
+// Возвращает удаленные лица и ключи их файлов, на которые не ссылаются оставшиеся
+// лица (одно фото может содержать лица нескольких людей - его файл остается)
+func (r *Repository) DeleteFaces(personID int, faceIDs []int) ([]models.Face, []string, error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var deleted []models.Face
+	if err := tx.Select(&deleted, `
+		DELETE FROM faces
+		WHERE person_id = $1 AND id = ANY($2)
+		RETURNING *
+	`, personID, pq.Array(faceIDs)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := writeAudit(tx, facesRemovedAudit(deleted, "")...); err != nil {
+		return nil, nil, err
+	}
+
+	if err := refreshCentroids(tx, []int{personID}); err != nil {
+		return nil, nil, err
+	}
+
+	orphaned, err := orphanedFaceFiles(tx, deleted)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return deleted, orphaned, nil
+}
+
+// orphanedFaceFiles возвращает ключи файлов удаленных лиц (original, annotated и миниатюры),
+// на которые не ссылается ни одно оставшееся лицо. Вызывается в транзакции после DELETE:
+// одно фото может содержать лица нескольких людей, и его файл нужен остальным лицам
+// (в том числе лицам людей, помеченных удаленными) и повторной обработке задачи
+func orphanedFaceFiles(tx *tracedTx, deleted []models.Face) ([]string, error) {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, face := range deleted {
+		for _, key := range []string{face.OriginalImage, face.AnnotatedImage, face.ThumbnailImage} {
+			if key != "" && !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	var used []string
+	if err := tx.Select(&used, `
+		SELECT original_image FROM faces WHERE original_image = ANY($1)
+		UNION
+		SELECT annotated_image FROM faces WHERE annotated_image = ANY($1)
+		UNION
+		SELECT thumbnail_image FROM faces WHERE thumbnail_image = ANY($1)
+	`, pq.Array(keys)); err != nil {
+		return nil, err
+	}
+
+	inUse := make(map[string]bool, len(used))
+	for _, key := range used {
+		inUse[key] = true
+	}
+	var orphaned []string
+	for _, key := range keys {
+		if !inUse[key] {
+			orphaned = append(orphaned, key)
+		}
+	}
+	return orphaned, nil
+}
+
+// ============ AUDIT ============
+
+// GetPersonAudit возвращает журнал изменений человека в порядке записи
+// Журнал остается и после удаления человека; нет записей - пустой список
+func (r *Repository) GetPersonAudit(personID int) ([]models.PersonAuditEntry, error) {
+	entries := []models.PersonAuditEntry{}
+	err := r.reader().Select(&entries, `
+		SELECT id, person_id, event, actor, details, created_at
+		FROM person_audit
+		WHERE person_id = $1
+		ORDER BY id
+	`, personID)
+	return entries, err
+}
+
+// auditEntry - запись журнала person_audit, которая пишется вместе с изменением
+type auditEntry struct {
+	personID int
+	event    string
+	details  map[string]interface{}
+}
+
+// writeAudit добавляет записи в журнал person_audit в транзакции самого изменения
+// Исполнитель берется из контекста транзакции (audit.Actor)
+func writeAudit(tx *tracedTx, entries ...auditEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	personIDs := make([]int, len(entries))
+	events := make([]string, len(entries))
+	details := make([]string, len(entries))
+	for i, entry := range entries {
+		data, err := json.Marshal(entry.details)
+		if err != nil {
+			return err
+		}
+		personIDs[i], events[i], details[i] = entry.personID, entry.event, string(data)
+	}
+
+	_, err := tx.Exec(`
+		INSERT INTO person_audit (person_id, event, actor, details)
+		SELECT person_id, event, $4, details::jsonb
+		FROM unnest($1::int[], $2::text[], $3::text[]) AS e(person_id, event, details)
+	`, pq.Array(personIDs), pq.Array(events), pq.Array(details), audit.Actor(tx.ctx))
+	return err
+}
+
+// facesRemovedAudit группирует удаленные лица по людям: одна запись faces_removed
+// на человека. taskID != "" - лица удалены вместе с задачей
+func facesRemovedAudit(faces []models.Face, taskID string) []auditEntry {
+	byPerson := make(map[int]int) // person_id -> индекс записи
+	var entries []auditEntry
+	for _, face := range faces {
+		i, ok := byPerson[face.PersonID]
+		if !ok {
+			i = len(entries)
+			byPerson[face.PersonID] = i
+			details := map[string]interface{}{"face_ids": []int{}}
+			if taskID != "" {
+				details["task_id"] = taskID
+			}
+			entries = append(entries, auditEntry{personID: face.PersonID, event: models.AuditFacesRemoved, details: details})
+		}
+		entries[i].details["face_ids"] = append(entries[i].details["face_ids"].([]int), face.ID)
+	}
+	return entries
+}
+
+// ============ CENTROIDS ============
+
+// GetPersonCentroids возвращает центроиды embedding неудаленных людей
+// Люди без центроида (нет лиц с корректным embedding) в результат не попадают
+func (r *Repository) GetPersonCentroids() (map[int][]float64, error) {
+	var rows []struct {
+		ID       int    `db:"id"`
+		Centroid []byte `db:"centroid"`
+	}
+	if err := r.reader().Select(&rows, `
+		SELECT id, centroid FROM persons WHERE deleted_at IS NULL AND centroid IS NOT NULL
+	`); err != nil {
+		return nil, err
+	}
+
+	centroids := make(map[int][]float64, len(rows))
+	for _, row := range rows {
+		var centroid []float64
+		if err := json.Unmarshal(row.Centroid, &centroid); err != nil || len(centroid) == 0 {
+			continue
+		}
+		centroids[row.ID] = centroid
+	}
+	return centroids, nil
+}
+
+// UpdatePersonCentroid пересчитывает центроид человека по его текущим лицам
+// CreateFace и UpdateFaceEmbedding центроид не трогают: их вызывают для многих лиц
+// подряд, и пересчет после каждого был бы квадратичным - вызывающий код пересчитывает
+// центроид один раз после серии изменений. Удаление лиц пересчитывает его само
+func (r *Repository) UpdatePersonCentroid(personID int) error {
+	return refreshCentroids(r.db, []int{personID})
+}
+
+// centroidStore - соединение или транзакция, в которой пересчитываются центроиды
+type centroidStore interface {
+	Select(dest interface{}, query string, args ...interface{}) error
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// refreshCentroids заново усредняет embedding лиц людей personIDs (embedding.Centroid)
+// и сохраняет результат в persons.centroid в том же JSON формате, что и embedding лиц
+// Лица с битым embedding пропускаются; у человека без корректных embedding
+// (или с embedding разной размерности) центроид становится NULL
+func refreshCentroids(db centroidStore, personIDs []int) error {
+	if len(personIDs) == 0 {
+		return nil
+	}
+
+	var faces []models.FaceEmbedding
+	if err := db.Select(&faces, `
+		SELECT person_id, embedding FROM faces
+		WHERE person_id = ANY($1) AND embedding IS NOT NULL
+		ORDER BY person_id, id
+	`, pq.Array(personIDs)); err != nil {
+		return err
+	}
+
+	vectors := make(map[int][][]float64)
+	for _, face := range faces {
+		var vector []float64
+		if err := json.Unmarshal(face.Embedding, &vector); err != nil {
+			continue
+		}
+		vectors[face.PersonID] = append(vectors[face.PersonID], vector)
+	}
+
+	updated := make(map[int]bool, len(personIDs))
+	for _, personID := range personIDs {
+		if updated[personID] {
+			continue
+		}
+		updated[personID] = true
+
+		var data []byte // nil - NULL
+		if centroid, err := embedding.Centroid(vectors[personID]); err == nil {
+			data, _ = json.Marshal(centroid)
+		}
+		if _, err := db.Exec("UPDATE persons SET centroid = $1 WHERE id = $2", data, personID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ============ STATS ============
+
+// GetStats возвращает общую статистику
+// Удаленные (soft-delete) люди и их лица не учитываются
+func (r *Repository) GetStats() (*models.Stats, error) {
+	var stats models.Stats
+	db := r.reader() // Все агрегаты считаем на одной реплике
+
+	// Итоги - из stats_summary (ведут триггеры), без COUNT(*) по всем таблицам
+	var summary models.StatsSummary
+	if err := db.Get(&summary, "SELECT total_persons, total_faces, total_tasks FROM stats_summary"); err != nil {
+		return nil, err
+	}
+	stats.TotalPersons = summary.TotalPersons
+	stats.TotalFaces = summary.TotalFaces
+	stats.TotalTasks = summary.TotalTasks
+
+	if stats.TotalPersons > 0 {
+		stats.AvgFacesPerPerson = float64(stats.TotalFaces) / float64(stats.TotalPersons)
+	}
+
+	// Количество лиц по дням за последние StatsDays дней
+	stats.FacesPerDay = []models.DailyCount{}
+	err := db.Select(&stats.FacesPerDay, `
+		SELECT date_trunc('day', detected_at) AS day, COUNT(*) AS count
+		FROM faces f
+		JOIN persons p ON p.id = f.person_id
+		WHERE p.deleted_at IS NULL
+		  AND detected_at >= date_trunc('day', NOW()) - make_interval(days => $1)
+		GROUP BY day
+		ORDER BY day
+	`, models.StatsDays-1)
+	if err != nil {
+		return nil, err
+	}
+
+	// Люди с наибольшим числом фото
+	stats.TopPersons = []models.PersonCount{}
+	err = db.Select(&stats.TopPersons, `
+		SELECT p.id, p.name, COUNT(f.id) AS faces_count
+		FROM persons p
+		JOIN faces f ON p.id = f.person_id
+		WHERE p.deleted_at IS NULL
+		GROUP BY p.id
+		ORDER BY faces_count DESC, p.id
+		LIMIT $1
+	`, models.TopPersonsLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	return &stats, nil
+}
+
+// ReconcileStats пересчитывает stats_summary по таблицам (исправляет расхождение счетчиков)
+// Строка счетчиков блокируется до пересчета: изменения, идущие параллельно, дождутся
+// конца сверки и будут учтены поверх нее. Возвращает счетчики до и после сверки
+func (r *Repository) ReconcileStats() (before, after models.StatsSummary, err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return before, after, err
+	}
+	defer tx.Rollback()
+
+	// Строки может не быть, если ее удалили вручную
+	if _, err := tx.Exec("INSERT INTO stats_summary (id) VALUES (TRUE) ON CONFLICT (id) DO NOTHING"); err != nil {
+		return before, after, err
+	}
+	err = tx.Get(&before, "SELECT total_persons, total_faces, total_tasks FROM stats_summary FOR UPDATE")
+	if err != nil {
+		return before, after, err
+	}
+
+	err = tx.Get(&after, `
+		UPDATE stats_summary SET
+			total_persons = (SELECT COUNT(*) FROM persons WHERE deleted_at IS NULL),
+			total_faces = (
+				SELECT COUNT(*) FROM faces f
+				JOIN persons p ON p.id = f.person_id
+				WHERE p.deleted_at IS NULL
+			),
+			total_tasks = (SELECT COUNT(*) FROM tasks),
+			reconciled_at = NOW()
+		RETURNING total_persons, total_faces, total_tasks
+	`)
+	if err != nil {
+		return before, after, err
+	}
+
+	return before, after, tx.Commit()
 }
@@ -0,0 +1,1535 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"face-recognition/internal/audit"
+	"face-recognition/internal/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newMockRepository создает репозиторий поверх sqlmock
+func newMockRepository(t *testing.T) (*Repository, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return NewRepository(sqlx.NewDb(db, "postgres")), mock
+}
+
+func TestGetStatsAggregates(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	day1 := time.Date(2024, 11, 20, 0, 0, 0, 0, time.UTC)
+	day2 := time.Date(2024, 11, 21, 0, 0, 0, 0, time.UTC)
+
+	// Итоги - одной строкой из stats_summary, без COUNT(*) по таблицам
+	mock.ExpectQuery(`SELECT total_persons, total_faces, total_tasks FROM stats_summary`).
+		WillReturnRows(sqlmock.NewRows([]string{"total_persons", "total_faces", "total_tasks"}).AddRow(4, 10, 2))
+	mock.ExpectQuery(`SELECT date_trunc\('day', detected_at\) AS day, COUNT\(\*\) AS count`).
+		WithArgs(models.StatsDays - 1).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}).
+			AddRow(day1, 3).
+			AddRow(day2, 7))
+	mock.ExpectQuery(`SELECT p.id, p.name, COUNT\(f.id\) AS faces_count`).
+		WithArgs(models.TopPersonsLimit).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "faces_count"}).
+			AddRow(2, "Alice", 6).
+			AddRow(1, "Bob", 4))
+
+	stats, err := repo.GetStats()
+	require.NoError(t, err)
+
+	assert.Equal(t, 4, stats.TotalPersons)
+	assert.Equal(t, 10, stats.TotalFaces)
+	assert.Equal(t, 2, stats.TotalTasks)
+	assert.Equal(t, 2.5, stats.AvgFacesPerPerson)
+
+	require.Len(t, stats.FacesPerDay, 2)
+	assert.Equal(t, day1, stats.FacesPerDay[0].Day)
+	assert.Equal(t, 3, stats.FacesPerDay[0].Count)
+	assert.Equal(t, 7, stats.FacesPerDay[1].Count)
+
+	require.Len(t, stats.TopPersons, 2)
+	assert.Equal(t, models.PersonCount{ID: 2, Name: "Alice", Count: 6}, stats.TopPersons[0])
+	assert.Equal(t, "Bob", stats.TopPersons[1].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetStatsEmptyDatabase(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`FROM stats_summary`).
+		WillReturnRows(sqlmock.NewRows([]string{"total_persons", "total_faces", "total_tasks"}).AddRow(0, 0, 0))
+	mock.ExpectQuery(`SELECT date_trunc`).
+		WillReturnRows(sqlmock.NewRows([]string{"day", "count"}))
+	mock.ExpectQuery(`SELECT p.id, p.name, COUNT\(f.id\) AS faces_count`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "faces_count"}))
+
+	stats, err := repo.GetStats()
+	require.NoError(t, err)
+
+	// Без людей среднее равно 0 (без деления на ноль), ряды - пустые массивы, а не null
+	assert.Equal(t, 0.0, stats.AvgFacesPerPerson)
+	assert.NotNil(t, stats.FacesPerDay)
+	assert.Empty(t, stats.FacesPerDay)
+	assert.NotNil(t, stats.TopPersons)
+	assert.Empty(t, stats.TopPersons)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconcileStats(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stats_summary \(id\) VALUES \(TRUE\) ON CONFLICT \(id\) DO NOTHING`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT total_persons, total_faces, total_tasks FROM stats_summary FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"total_persons", "total_faces", "total_tasks"}).AddRow(5, 12, 3))
+	mock.ExpectQuery(`UPDATE stats_summary SET(.|\n)*reconciled_at = NOW\(\)\s+RETURNING total_persons, total_faces, total_tasks`).
+		WillReturnRows(sqlmock.NewRows([]string{"total_persons", "total_faces", "total_tasks"}).AddRow(4, 10, 3))
+	mock.ExpectCommit()
+
+	before, after, err := repo.ReconcileStats()
+	require.NoError(t, err)
+	assert.Equal(t, models.StatsSummary{TotalPersons: 5, TotalFaces: 12, TotalTasks: 3}, before)
+	assert.Equal(t, models.StatsSummary{TotalPersons: 4, TotalFaces: 10, TotalTasks: 3}, after)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReconcileStatsRollsBackOnError(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO stats_summary`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"total_persons", "total_faces", "total_tasks"}).AddRow(0, 0, 0))
+	mock.ExpectQuery(`UPDATE stats_summary`).WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+
+	_, _, err := repo.ReconcileStats()
+	require.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonsPage(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`WHERE p.id > \$1 AND p.deleted_at IS NULL\s+GROUP BY p.id\s+ORDER BY p.id\s+LIMIT \$2`).
+		WithArgs(10, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count"}).
+			AddRow(11, "Alice", now, now, 3).
+			AddRow(12, "Bob", now, now, 0))
+
+	persons, err := repo.GetPersonsPage(10, 2)
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, 11, persons[0].ID)
+	assert.Equal(t, 3, persons[0].Count)
+	assert.Equal(t, "Bob", persons[1].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonsAfter(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	columns := []string{"id", "name", "created_at", "updated_at", "faces_count", "id", "annotated_image"}
+
+	// Первая страница - без условия на курсор
+	mock.ExpectQuery(`WHERE p.deleted_at IS NULL\s+GROUP BY p.id, cf.id\s+ORDER BY p.created_at DESC, p.id DESC\s+LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(5, "Eve", now, now, 1, 50, "task-1/face_50_boxed.jpg").
+			AddRow(4, "Dan", now, now, 0, nil, nil))
+
+	persons, err := repo.GetPersonsAfter(nil, 2)
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, 5, persons[0].ID)
+	assert.Equal(t, &models.CoverFace{FaceID: 50, AnnotatedImage: "task-1/face_50_boxed.jpg"}, persons[0].Cover)
+	assert.Nil(t, persons[1].Cover)
+
+	// Следующая - строго после (created_at, id) курсора, время с микросекундами
+	createdAt := time.Date(2026, 1, 2, 3, 4, 5, 123456000, time.UTC)
+	mock.ExpectQuery(`AND \(p.created_at, p.id\) < \(\$1::timestamp, \$2\)(.|\n)*ORDER BY p.created_at DESC, p.id DESC\s+LIMIT \$3`).
+		WithArgs("2026-01-02 03:04:05.123456", 4, 2).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	persons, err = repo.GetPersonsAfter(&models.PersonCursor{CreatedAt: createdAt, ID: 4}, 2)
+	require.NoError(t, err)
+	assert.NotNil(t, persons)
+	assert.Empty(t, persons)
+
+	mock.ExpectQuery(`ORDER BY p.created_at DESC, p.id DESC\s+LIMIT \$1 OFFSET \$2`).
+		WithArgs(2, 10).
+		WillReturnRows(sqlmock.NewRows(columns).AddRow(3, "Carol", now, now, 2, nil, nil))
+
+	persons, err = repo.GetPersonsOffset(10, 2)
+	require.NoError(t, err)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "Carol", persons[0].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetTaskForReprocess(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	params := models.DetectionParams{MinSize: 50, DetThresh: 0.7, MinConfidence: 0.4}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE tasks(.|\n)*rejected_faces = 0(.|\n)*warnings = '\{\}'(.|\n)*progress = 0, stage = ''(.|\n)*processing_started_at = NULL`).
+		WithArgs("task-1", models.TaskStatusQueued, 50, 0.7, 0.4, models.TaskStatusQueued, models.TaskStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`DELETE FROM faces WHERE task_id = \$1 RETURNING \*`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "task_id", "original_image"}).
+			AddRow(10, 1, "task-1", "task-1/a.jpg").
+			AddRow(11, 2, "task-1", "task-1/a.jpg"))
+	expectAudit(mock, audit.ActorSystem, []int{1, 2},
+		[]string{models.AuditFacesRemoved, models.AuditFacesRemoved},
+		[]string{`{"face_ids":[10],"task_id":"task-1"}`, `{"face_ids":[11],"task_id":"task-1"}`})
+	mock.ExpectExec(`DELETE FROM task_persons WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`DELETE FROM persons p`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "person_1", now, now))
+	expectCentroidRefresh(mock, 2, []byte("[0.6,0.8]"))
+	mock.ExpectCommit()
+
+	persons, faces, err := repo.ResetTaskForReprocess("task-1", params)
+	require.NoError(t, err)
+	assert.Len(t, faces, 2)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "person_1", persons[0].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTaskFaces(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Задача не меняется - удаляются только ее лица и опустевшие люди
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM faces WHERE task_id = \$1 RETURNING \*`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "task_id", "original_image"}).
+			AddRow(10, 1, "task-1", "task-1/a.jpg"))
+	expectAudit(mock, audit.ActorSystem, []int{1},
+		[]string{models.AuditFacesRemoved}, []string{`{"face_ids":[10],"task_id":"task-1"}`})
+	mock.ExpectExec(`DELETE FROM task_persons WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`DELETE FROM persons p`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "person_1"))
+	mock.ExpectCommit()
+
+	persons, faces, err := repo.DeleteTaskFaces("task-1")
+	require.NoError(t, err)
+	assert.Len(t, faces, 1)
+	require.Len(t, persons, 1)
+	assert.Equal(t, 1, persons[0].ID)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestResetTaskForReprocessGuards(t *testing.T) {
+	t.Run("processing", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE tasks`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT status FROM tasks`).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.TaskStatusProcessing))
+		mock.ExpectRollback()
+
+		_, _, err := repo.ResetTaskForReprocess("task-1", models.DefaultDetectionParams())
+		assert.Equal(t, ErrTaskProcessing, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE tasks`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`SELECT status FROM tasks`).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}))
+		mock.ExpectRollback()
+
+		_, _, err := repo.ResetTaskForReprocess("missing", models.DefaultDetectionParams())
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestGetInterruptedTasks(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM tasks\s+WHERE status IN \(\$1, \$2\)\s+ORDER BY created_at`).
+		WithArgs(models.TaskStatusQueued, models.TaskStatusProcessing).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).
+			AddRow("task-1", models.TaskStatusProcessing).
+			AddRow("task-2", models.TaskStatusQueued))
+
+	tasks, err := repo.GetInterruptedTasks()
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "task-1", tasks[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequeueInterruptedTask(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	// Параметры и хэш загрузки не меняются - задача обрабатывается так же, как была бы
+	mock.ExpectExec(`UPDATE tasks(.|\n)*SET status = \$2, total_faces = 0(.|\n)*processing_started_at = NULL(.|\n)*WHERE id = \$1 AND status IN \(\$3, \$4\)`).
+		WithArgs("task-1", models.TaskStatusQueued, models.TaskStatusQueued, models.TaskStatusProcessing).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`DELETE FROM faces WHERE task_id = \$1 RETURNING \*`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "task_id", "original_image"}).
+			AddRow(10, 1, "task-1", "task-1/a.jpg"))
+	expectAudit(mock, audit.ActorSystem, []int{1}, []string{models.AuditFacesRemoved}, []string{`{"face_ids":[10],"task_id":"task-1"}`})
+	mock.ExpectExec(`DELETE FROM task_persons WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`DELETE FROM persons p(.|\n)*NOT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "person_1", now, now))
+	mock.ExpectCommit()
+
+	persons, faces, err := repo.RequeueInterruptedTask("task-1")
+	require.NoError(t, err)
+	assert.Len(t, faces, 1)
+	assert.Len(t, persons, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRequeueInterruptedTaskNotInterrupted(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE tasks`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	_, _, err := repo.RequeueInterruptedTask("task-1")
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetRecentTasks(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM tasks ORDER BY created_at DESC, id LIMIT \$1`).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).
+			AddRow("task-2", models.TaskStatusCompleted).
+			AddRow("task-1", models.TaskStatusFailed))
+
+	tasks, err := repo.GetRecentTasks(2)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "task-2", tasks[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTask(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM tasks WHERE id = \$1 AND status NOT IN \(\$2, \$3\) RETURNING \*`).
+		WithArgs("task-1", models.TaskStatusQueued, models.TaskStatusProcessing).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status", "content_hash"}).
+			AddRow("task-1", models.TaskStatusCompleted, "hash-1"))
+	mock.ExpectQuery(`DELETE FROM faces WHERE task_id = \$1 RETURNING \*`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "task_id", "original_image"}).
+			AddRow(10, 1, "task-1", "task-1/a.jpg").
+			AddRow(11, 2, "task-1", "task-1/a.jpg"))
+	expectAudit(mock, audit.ActorSystem, []int{1, 2},
+		[]string{models.AuditFacesRemoved, models.AuditFacesRemoved},
+		[]string{`{"face_ids":[10],"task_id":"task-1"}`, `{"face_ids":[11],"task_id":"task-1"}`})
+	mock.ExpectExec(`DELETE FROM task_persons WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectQuery(`DELETE FROM persons p(.|\n)*NOT EXISTS`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "person_1", now, now))
+	// У человека 2 остались лица только без embedding
+	mock.ExpectQuery(`SELECT person_id, embedding FROM faces\s+WHERE person_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{2})).
+		WillReturnRows(sqlmock.NewRows([]string{"person_id", "embedding"}))
+	mock.ExpectExec(`UPDATE persons SET centroid = \$1 WHERE id = \$2`).
+		WithArgs([]byte(nil), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	task, persons, faces, err := repo.DeleteTask("task-1")
+	require.NoError(t, err)
+	assert.Equal(t, "hash-1", task.ContentHash)
+	assert.Len(t, faces, 2)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "person_1", persons[0].Name)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteTaskGuards(t *testing.T) {
+	t.Run("processing", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`DELETE FROM tasks`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT status FROM tasks`).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(models.TaskStatusProcessing))
+		mock.ExpectRollback()
+
+		_, _, _, err := repo.DeleteTask("task-1")
+		assert.Equal(t, ErrTaskProcessing, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+
+		mock.ExpectBegin()
+		mock.ExpectQuery(`DELETE FROM tasks`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		mock.ExpectQuery(`SELECT status FROM tasks`).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}))
+		mock.ExpectRollback()
+
+		_, _, _, err := repo.DeleteTask("missing")
+		assert.Equal(t, sql.ErrNoRows, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestReadsGoToReplicasRoundRobin(t *testing.T) {
+	newMock := func() (*sqlx.DB, sqlmock.Sqlmock) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		return sqlx.NewDb(db, "postgres"), mock
+	}
+
+	primary, primaryMock := newMock()
+	replica1, replica1Mock := newMock()
+	replica2, replica2Mock := newMock()
+	repo := NewRepository(primary, replica1, replica2)
+
+	personRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count"})
+	}
+
+	replica1Mock.ExpectQuery(`SELECT p.id, p.name`).WillReturnRows(personRows())
+	replica2Mock.ExpectQuery(`SELECT p.id, p.name`).WillReturnRows(personRows())
+	replica1Mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT p.id`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	replica1Mock.ExpectQuery(`p.name ILIKE`).WillReturnRows(personRows())
+	primaryMock.ExpectBegin()
+	primaryMock.ExpectQuery(`SELECT name FROM persons`).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Alice"))
+	primaryMock.ExpectExec(`UPDATE persons`).WillReturnResult(sqlmock.NewResult(0, 1))
+	primaryMock.ExpectCommit()
+
+	_, err := repo.GetAllPersons()
+	require.NoError(t, err)
+	_, err = repo.GetAllPersons()
+	require.NoError(t, err)
+	_, _, err = repo.SearchPersons("alice", models.SearchOptions{})
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdatePersonName(1, "Alice"))
+
+	assert.NoError(t, primaryMock.ExpectationsWereMet())
+	assert.NoError(t, replica1Mock.ExpectationsWereMet())
+	assert.NoError(t, replica2Mock.ExpectationsWereMet())
+}
+
+func TestReadsFallBackToPrimary(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "Alice", time.Now(), time.Now()))
+	mock.ExpectQuery(`FROM faces`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`FROM tags`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("staff"))
+
+	person, err := repo.GetPersonByID(1)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", person.Name)
+	assert.Equal(t, []string{"staff"}, person.Tags)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPersonsRanksBySimilarity(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// Без fuzzy похожесть не расширяет выборку (порог > 1), но задает порядок
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT p.id`).
+		WithArgs("%Ali%", "Ali", 2.0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	mock.ExpectQuery(`similarity\(p.name, \$2\) AS score.*ORDER BY CAST\(p.id AS TEXT\) = \$2 DESC, p.name <-> \$2`).
+		WithArgs("%Ali%", "Ali", 2.0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "score"}).
+			AddRow(1, "Ali", now, now, 2, 1.0).
+			AddRow(2, "Alice", now, now, 5, 0.5))
+
+	persons, total, err := repo.SearchPersons("Ali", models.SearchOptions{MinSimilarity: 0.3})
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, 2, total)
+	assert.Equal(t, "Ali", persons[0].Name)
+	assert.Equal(t, 0.5, persons[1].Score)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPersonsFuzzyMisspelled(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// "Alcie" не совпадает с "Alice" по подстроке - находится только через similarity >= порога
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).
+		WithArgs("%Alcie%", "Alcie", 0.2).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`OR similarity\(p.name, \$2\) >= \$3`).
+		WithArgs("%Alcie%", "Alcie", 0.2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "score"}).
+			AddRow(2, "Alice", now, now, 5, 0.25))
+
+	persons, _, err := repo.SearchPersons("Alcie", models.SearchOptions{Fuzzy: true, MinSimilarity: 0.2})
+	require.NoError(t, err)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "Alice", persons[0].Name)
+	assert.Equal(t, 0.25, persons[0].Score)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPersonsMinFacesAndPage(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// min_faces фильтрует сгруппированных людей - и в total, и на странице
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT p.id(.|\n)*GROUP BY p.id\s+HAVING COUNT\(f.id\) >= \$4\s+\) matches`).
+		WithArgs("%john%", "john", 2.0, 3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(12))
+	mock.ExpectQuery(`HAVING COUNT\(f.id\) >= \$4(.|\n)*ORDER BY(.|\n)*LIMIT \$5 OFFSET \$6`).
+		WithArgs("%john%", "john", 2.0, 3, 10, 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "score"}).
+			AddRow(7, "John", now, now, 4, 0.8).
+			AddRow(8, "Johnny", now, now, 3, 0.5))
+
+	persons, total, err := repo.SearchPersons("john", models.SearchOptions{MinFaces: 3, Limit: 10, Offset: 10})
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, 12, total)
+	assert.Equal(t, 4, persons[0].Count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSearchPersonsWithoutMinFaces(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Без min_faces HAVING не добавляется, а limit занимает следующий за порогом параметр
+	mock.ExpectQuery(`SELECT COUNT\(\*\)`).
+		WithArgs("%john%", "john", 2.0).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`GROUP BY p.id\s+ORDER BY(.|\n)*LIMIT \$4\s*$`).
+		WithArgs("%john%", "john", 2.0, 20).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "score"}))
+
+	persons, total, err := repo.SearchPersons("john", models.SearchOptions{Limit: 20})
+	require.NoError(t, err)
+	assert.Empty(t, persons)
+	assert.Equal(t, 0, total)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFaceByID(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE id = \$1`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "embedding"}).
+			AddRow(7, 3, []byte("[0.1,0.2]")))
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE id = \$1`).
+		WithArgs(8).
+		WillReturnError(sql.ErrNoRows)
+
+	face, err := repo.GetFaceByID(7)
+	require.NoError(t, err)
+	assert.Equal(t, 7, face.ID)
+	assert.Equal(t, 3, face.PersonID)
+	assert.Equal(t, []byte("[0.1,0.2]"), face.Embedding)
+
+	_, err = repo.GetFaceByID(8)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCountPersonFaces(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM faces WHERE person_id = \$1`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	count, err := repo.CountPersonFaces(3)
+	require.NoError(t, err)
+	assert.Equal(t, 42, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonFaceCount(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \(SELECT COUNT\(\*\) FROM faces f WHERE f.person_id = p.id\)\s+FROM persons p\s+WHERE p.id = \$1 AND p.deleted_at IS NULL`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	count, err := repo.GetPersonFaceCount(3)
+	require.NoError(t, err)
+	assert.Equal(t, 42, count)
+
+	mock.ExpectQuery(`FROM persons p`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}))
+
+	_, err = repo.GetPersonFaceCount(4)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTaskFaces(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE task_id = \$1 ORDER BY id`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "task_id"}).
+			AddRow(1, 3, "task-1").
+			AddRow(2, 4, "task-1"))
+
+	faces, err := repo.GetTaskFaces("task-1")
+	require.NoError(t, err)
+	require.Len(t, faces, 2)
+	assert.Equal(t, 4, faces[1].PersonID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetFaceFileKeys(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT original_image FROM faces(.|\n)*UNION(.|\n)*annotated_image(.|\n)*UNION(.|\n)*thumbnail_image`).
+		WillReturnRows(sqlmock.NewRows([]string{"original_image"}).
+			AddRow("task-1/a.jpg").
+			AddRow("task-1/1_boxed.jpg").
+			AddRow("thumbnails/task-1/1_boxed.jpg"))
+
+	keys, err := repo.GetFaceFileKeys()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"task-1/a.jpg", "task-1/1_boxed.jpg", "thumbnails/task-1/1_boxed.jpg"}, keys)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetEmbeddingsPage(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT f.id, f.person_id, p.name AS person_name, f.embedding_model, f.embedding(.|\n)*JOIN persons p(.|\n)*f.id > \$1 AND f.embedding IS NOT NULL AND p.deleted_at IS NULL(.|\n)*ORDER BY f.id(.|\n)*LIMIT \$2`).
+		WithArgs(10, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "person_name", "embedding_model", "embedding"}).
+			AddRow(11, 1, "Alice", "buffalo_l", []byte("[0.1,0.2]")).
+			AddRow(14, 2, "Bob", "", []byte("[0.3,0.4]")))
+
+	embeddings, err := repo.GetEmbeddingsPage(10, 2)
+	require.NoError(t, err)
+	require.Len(t, embeddings, 2)
+	assert.Equal(t, models.LabeledEmbedding{FaceID: 11, PersonID: 1, PersonName: "Alice", Model: "buffalo_l", Embedding: []byte("[0.1,0.2]")}, embeddings[0])
+	assert.Equal(t, "Bob", embeddings[1].PersonName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestListFacesFiltersByConfidence(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// Диапазон уверенности и резкость - и в total, и на странице; сначала наименее уверенные
+	mock.ExpectQuery(`SELECT COUNT\(\*\)\s+FROM faces f\s+JOIN persons p(.|\n)*p.deleted_at IS NULL(.|\n)*f.confidence BETWEEN \$1 AND \$2\s+AND \(\$3 = 0 OR f.quality >= \$3\)`).
+		WithArgs(0.3, 0.6, 0.5).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(5))
+	mock.ExpectQuery(`f.quality, f.captured_at(.|\n)*p.name AS person_name(.|\n)*f.confidence BETWEEN \$1 AND \$2(.|\n)*ORDER BY f.confidence, f.id\s+LIMIT \$4 OFFSET \$5`).
+		WithArgs(0.3, 0.6, 0.5, 2, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "confidence", "quality", "detected_at", "person_name"}).
+			AddRow(11, 1, 0.35, 0.7, now, "Anna").
+			AddRow(12, 4, 0.52, 0.9, now, "Boris"))
+
+	faces, total, err := repo.ListFaces(0.3, 0.6, 0.5, 2, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 5, total)
+	require.Len(t, faces, 2)
+	assert.Equal(t, 11, faces[0].ID)
+	assert.Equal(t, 0.35, faces[0].Confidence)
+	require.NotNil(t, faces[0].Quality)
+	assert.Equal(t, 0.7, *faces[0].Quality)
+	assert.Equal(t, "Anna", faces[0].PersonName)
+	assert.Equal(t, "Boris", faces[1].PersonName)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateFaceEmbedding(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectExec(`UPDATE faces SET embedding = \$1, embedding_model = \$2 WHERE id = \$3`).
+		WithArgs([]byte("[0.3,0.4]"), "insightface/buffalo_l", 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE faces SET embedding = \$1, embedding_model = \$2 WHERE id = \$3`).
+		WithArgs([]byte("[0.3,0.4]"), "insightface/buffalo_l", 8).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, repo.UpdateFaceEmbedding(7, []byte("[0.3,0.4]"), "insightface/buffalo_l"))
+	assert.Equal(t, sql.ErrNoRows, repo.UpdateFaceEmbedding(8, []byte("[0.3,0.4]"), "insightface/buffalo_l"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectCentroidRefresh ожидает пересчет центроида человека с одним оставшимся лицом
+// expectAudit ожидает запись в журнал person_audit: по событию на человека
+func expectAudit(mock sqlmock.Sqlmock, actor string, personIDs []int, events []string, details []string) {
+	mock.ExpectExec(`INSERT INTO person_audit \(person_id, event, actor, details\)`).
+		WithArgs(pq.Array(personIDs), pq.Array(events), pq.Array(details), actor).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(personIDs))))
+}
+
+func expectCentroidRefresh(mock sqlmock.Sqlmock, personID int, embedding []byte) {
+	mock.ExpectQuery(`SELECT person_id, embedding FROM faces\s+WHERE person_id = ANY\(\$1\) AND embedding IS NOT NULL`).
+		WithArgs(pq.Array([]int{personID})).
+		WillReturnRows(sqlmock.NewRows([]string{"person_id", "embedding"}).AddRow(personID, embedding))
+	mock.ExpectExec(`UPDATE persons SET centroid = \$1 WHERE id = \$2`).
+		WithArgs(sqlmock.AnyArg(), personID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestUpdatePersonCentroid(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Длина векторов не важна, битый embedding пропускается
+	mock.ExpectQuery(`SELECT person_id, embedding FROM faces\s+WHERE person_id = ANY\(\$1\) AND embedding IS NOT NULL`).
+		WithArgs(pq.Array([]int{7})).
+		WillReturnRows(sqlmock.NewRows([]string{"person_id", "embedding"}).
+			AddRow(7, []byte("[2,0]")).
+			AddRow(7, []byte("[0,5]")).
+			AddRow(7, []byte("broken")))
+	mock.ExpectExec(`UPDATE persons SET centroid = \$1 WHERE id = \$2`).
+		WithArgs([]byte("[0.7071067811865475,0.7071067811865475]"), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.UpdatePersonCentroid(7))
+
+	// Лиц с embedding не осталось - центроид сбрасывается в NULL
+	mock.ExpectQuery(`SELECT person_id, embedding FROM faces`).
+		WithArgs(pq.Array([]int{7})).
+		WillReturnRows(sqlmock.NewRows([]string{"person_id", "embedding"}).AddRow(7, []byte("broken")))
+	mock.ExpectExec(`UPDATE persons SET centroid = \$1 WHERE id = \$2`).
+		WithArgs([]byte(nil), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.UpdatePersonCentroid(7))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonCentroids(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT id, centroid FROM persons WHERE deleted_at IS NULL AND centroid IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "centroid"}).
+			AddRow(1, []byte("[0.6,0.8]")).
+			AddRow(2, []byte("broken")).
+			AddRow(3, []byte("[1,0]")))
+
+	centroids, err := repo.GetPersonCentroids()
+	require.NoError(t, err)
+	assert.Equal(t, map[int][]float64{1: {0.6, 0.8}, 3: {1, 0}}, centroids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskProgress(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectExec(`UPDATE tasks\s+SET progress = \$1, stage = \$2`).
+		WithArgs(70, "Сохранение в базу данных", "task-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.UpdateTaskProgress("task-1", 70, "Сохранение в базу данных"))
+
+	// Значения вне 0-100 не доходят до БД
+	assert.Error(t, repo.UpdateTaskProgress("task-1", 101, "x"))
+	assert.Error(t, repo.UpdateTaskProgress("task-1", -1, "x"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateTaskQueued(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Новая задача ждет воркера - processing она получит в MarkTaskStarted
+	createdAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	mock.ExpectQuery(`INSERT INTO tasks .* RETURNING status, created_at`).
+		WithArgs("task-1", models.TaskStatusQueued, 3, 30, 0.5, 0.0, "", "hash-1").
+		WillReturnRows(sqlmock.NewRows([]string{"status", "created_at"}).AddRow(models.TaskStatusQueued, createdAt))
+
+	task := &models.Task{ID: "task-1", TotalImages: 3, MinSize: 30, DetThresh: 0.5, ContentHash: "hash-1"}
+	require.NoError(t, repo.CreateTask(task))
+	assert.Equal(t, models.TaskStatusQueued, task.Status)
+	assert.Equal(t, createdAt, task.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkTaskStarted(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectExec(`UPDATE tasks\s+SET status = \$1, processing_started_at = NOW\(\)\s+WHERE id = \$2`).
+		WithArgs(models.TaskStatusProcessing, "task-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.MarkTaskStarted("task-1"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateTaskWarnings(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	warnings := []string{"лицо face_3 (кластер person_1): нет embedding"}
+
+	mock.ExpectExec(`UPDATE tasks\s+SET warnings = \$1`).
+		WithArgs(pq.Array(warnings), "task-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.UpdateTaskWarnings("task-1", warnings))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeletePersonIsSoft(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`UPDATE persons\s+SET deleted_at = NOW\(\)\s+WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"}).
+			AddRow(9, "Bob", now, now, now))
+	// Повторное удаление - человека уже нет
+	mock.ExpectQuery(`UPDATE persons\s+SET deleted_at = NOW\(\)`).
+		WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+
+	person, err := repo.DeletePerson(9)
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", person.Name)
+	assert.True(t, person.DeletedAt.Valid)
+
+	_, err = repo.DeletePerson(9)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRestorePerson(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`UPDATE persons\s+SET deleted_at = NULL\s+WHERE id = \$1 AND deleted_at IS NOT NULL`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"}).
+			AddRow(9, "Bob", now, now, nil))
+
+	person, err := repo.RestorePerson(9)
+	require.NoError(t, err)
+	assert.Equal(t, 9, person.ID)
+	assert.False(t, person.DeletedAt.Valid)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDeletePerson(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	// Без фильтра по deleted_at - удаляет и помеченных удаленными
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1 FOR UPDATE`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"}).
+			AddRow(9, "Bob", now, now, now))
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE person_id = \$1`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "original_image", "annotated_image"}).
+			AddRow(1, 9, "task-1/group.jpg", "task-1/face_1_boxed.jpg"))
+	mock.ExpectExec(`DELETE FROM persons WHERE id = \$1`).
+		WithArgs(9).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Групповое фото осталось у лица другого человека
+	mock.ExpectQuery(`SELECT original_image FROM faces WHERE original_image = ANY\(\$1\)`).
+		WithArgs(pq.Array([]string{"task-1/group.jpg", "task-1/face_1_boxed.jpg"})).
+		WillReturnRows(sqlmock.NewRows([]string{"original_image"}).AddRow("task-1/group.jpg"))
+	mock.ExpectCommit()
+
+	person, files, err := repo.HardDeletePerson(9)
+	require.NoError(t, err)
+	assert.Equal(t, "Bob", person.Name)
+	assert.Equal(t, []string{"task-1/face_1_boxed.jpg"}, files)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDeletePersonFacesError(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// Лица не прочитались - человек не удаляется, иначе его файлы остались бы без очистки
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1 FOR UPDATE`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).AddRow(9, "Bob", now, now))
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE person_id = \$1`).
+		WithArgs(9).
+		WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	_, _, err := repo.HardDeletePerson(9)
+	assert.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDeletePersonNotFound(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1 FOR UPDATE`).
+		WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, _, err := repo.HardDeletePerson(9)
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeletePersons(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`UPDATE persons\s+SET deleted_at = NOW\(\)\s+WHERE id = ANY\(\$1\) AND deleted_at IS NULL`).
+		WithArgs(pq.Array([]int{1, 2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "deleted_at"}).
+			AddRow(1, "noise_1", now, now, now))
+
+	deleted, err := repo.DeletePersons([]int{1, 2})
+	require.NoError(t, err)
+	require.Len(t, deleted, 1)
+	assert.Equal(t, 1, deleted[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDeleteFaces(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM faces\s+WHERE person_id = \$1 AND id = ANY\(\$2\)\s+RETURNING \*`).
+		WithArgs(5, pq.Array([]int{1, 3})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "original_image", "annotated_image", "thumbnail_image"}).
+			AddRow(1, 5, "task-1/a.jpg", "task-1/face_1_boxed.jpg", "").
+			AddRow(3, 5, "task-1/group.jpg", "task-1/face_3_boxed.jpg", "thumbnails/task-1/face_3_boxed.jpg"))
+	expectAudit(mock, audit.ActorSystem, []int{5}, []string{models.AuditFacesRemoved}, []string{`{"face_ids":[1,3]}`})
+	expectCentroidRefresh(mock, 5, []byte("[3,4]"))
+	// Групповое фото осталось у лица другого человека
+	mock.ExpectQuery(`SELECT original_image FROM faces WHERE original_image = ANY\(\$1\)`).
+		WithArgs(pq.Array([]string{
+			"task-1/a.jpg", "task-1/face_1_boxed.jpg",
+			"task-1/group.jpg", "task-1/face_3_boxed.jpg", "thumbnails/task-1/face_3_boxed.jpg",
+		})).
+		WillReturnRows(sqlmock.NewRows([]string{"original_image"}).AddRow("task-1/group.jpg"))
+	mock.ExpectCommit()
+
+	deleted, files, err := repo.DeleteFaces(5, []int{1, 3})
+	require.NoError(t, err)
+	require.Len(t, deleted, 2)
+	assert.Equal(t, []string{
+		"task-1/a.jpg", "task-1/face_1_boxed.jpg",
+		"task-1/face_3_boxed.jpg", "thumbnails/task-1/face_3_boxed.jpg",
+	}, files)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestHardDeletePersonsKeepsSharedFiles(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM faces WHERE person_id = ANY\(\$1\)`).
+		WithArgs(pq.Array([]int{1, 2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "original_image", "annotated_image", "thumbnail_image"}).
+			AddRow(10, 1, "task-1/group.jpg", "task-1/face_10_boxed.jpg", "").
+			AddRow(11, 2, "task-2/b.jpg", "", ""))
+	mock.ExpectQuery(`DELETE FROM persons WHERE id = ANY\(\$1\) RETURNING \*`).
+		WithArgs(pq.Array([]int{1, 2})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "noise_1", now, now).
+			AddRow(2, "noise_2", now, now))
+	// Групповое фото осталось у лица другого человека
+	mock.ExpectQuery(`SELECT original_image FROM faces WHERE original_image = ANY\(\$1\)`).
+		WithArgs(pq.Array([]string{"task-1/group.jpg", "task-1/face_10_boxed.jpg", "task-2/b.jpg"})).
+		WillReturnRows(sqlmock.NewRows([]string{"original_image"}).AddRow("task-1/group.jpg"))
+	mock.ExpectCommit()
+
+	deleted, files, err := repo.HardDeletePersons([]int{1, 2})
+	require.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.Equal(t, []string{"task-1/face_10_boxed.jpg", "task-2/b.jpg"}, files)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSoftDeletedPersonsAreHidden(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`FROM persons p(.|\n)*WHERE p.deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count"}))
+	mock.ExpectQuery(`SELECT COUNT\(\*\)(.|\n)*WHERE p.deleted_at IS NULL\s+AND \(p.name ILIKE \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`WHERE p.deleted_at IS NULL\s+AND \(p.name ILIKE \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "score"}))
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1 AND deleted_at IS NULL`).
+		WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`SELECT id FROM persons WHERE name = \$1 AND deleted_at IS NULL`).
+		WithArgs("person_1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(`INSERT INTO persons`).
+		WithArgs("person_1").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+
+	_, err := repo.GetAllPersons()
+	require.NoError(t, err)
+	_, _, err = repo.SearchPersons("Bob", models.SearchOptions{})
+	require.NoError(t, err)
+	_, err = repo.GetPersonByID(9)
+	assert.Equal(t, sql.ErrNoRows, err)
+
+	// Удаленный person_1 не переиспользуется новыми лицами
+	id, created, err := repo.GetOrCreatePerson("person_1")
+	require.NoError(t, err)
+	assert.Equal(t, 12, id)
+	assert.True(t, created)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveTaskFilesReplacesPrevious(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM task_files WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`INSERT INTO task_files`).
+		WithArgs("task-1", "a.jpg", 2, "", "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO task_files`).
+		WithArgs("task-1", "empty.jpg", 0, "", "Пустое фото.jpg").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := repo.SaveTaskFiles("task-1", []models.TaskFile{
+		{FileName: "a.jpg", FacesCount: 2},
+		{FileName: "empty.jpg", OriginalFilename: "Пустое фото.jpg"},
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSaveOriginalFilenames(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO task_file_names \(task_id, file_name, original_filename\)(.|\n)*ON CONFLICT`).
+		WithArgs("task-1", "3f2a.jpg", "Фото с отпуска.jpg").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.SaveOriginalFilenames("task-1", map[string]string{"3f2a.jpg": "Фото с отпуска.jpg"}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetOriginalFilenames(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT file_name, original_filename FROM task_file_names WHERE task_id = \$1`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"file_name", "original_filename"}).
+			AddRow("3f2a.jpg", "Фото с отпуска.jpg").
+			AddRow("9c1e.png", "scan.png"))
+
+	names, err := repo.GetOriginalFilenames("task-1")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"3f2a.jpg": "Фото с отпуска.jpg", "9c1e.png": "scan.png"}, names)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetTaskFilesEmpty(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`FROM task_files\s+WHERE task_id = \$1\s+ORDER BY file_name`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"task_id", "file_name", "faces_count", "error"}))
+
+	files, err := repo.GetTaskFiles("task-1")
+	require.NoError(t, err)
+	assert.NotNil(t, files)
+	assert.Empty(t, files)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetUnlabeledPersons(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+	columns := []string{"id", "name", "created_at", "updated_at", "faces_count", "id", "annotated_image"}
+
+	mock.ExpectQuery(`WHERE p.deleted_at IS NULL AND NOT p.is_labeled\s+GROUP BY p.id, cf.id\s+ORDER BY p.created_at DESC, p.id DESC`).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(7, "person_7", now, now, 2, 70, "task-1/face_70_boxed.jpg"))
+
+	persons, err := repo.GetUnlabeledPersons()
+	require.NoError(t, err)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "person_7", persons[0].Name)
+	assert.Equal(t, 2, persons[0].Count)
+
+	mock.ExpectExec(`UPDATE persons SET is_labeled = TRUE WHERE id = \$1`).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, repo.MarkPersonLabeled(7))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonsByIDs(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`WHERE p.deleted_at IS NULL AND p.id = ANY\(\$1\)\s+GROUP BY p.id, cf.id`).
+		WithArgs(pq.Array([]int{2, 7})).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "id", "annotated_image"}).
+			AddRow(7, "Alice", now, now, 3, 70, "task-1/face_70_boxed.jpg"))
+
+	persons, err := repo.GetPersonsByIDs([]int{2, 7})
+	require.NoError(t, err)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "Alice", persons[0].Name)
+	assert.Equal(t, &models.CoverFace{FaceID: 70, AnnotatedImage: "task-1/face_70_boxed.jpg"}, persons[0].Cover)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonsByTagIsCaseInsensitive(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`JOIN tags t ON t.id = pt.tag_id AND t.name = \$1`).
+		WithArgs("staff").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "id", "annotated_image"}).
+			AddRow(1, "Alice", time.Now(), time.Now(), 3, 4, "task-1/face_4_boxed.jpg"))
+
+	persons, err := repo.GetPersonsByTag(" Staff ")
+	require.NoError(t, err)
+	require.Len(t, persons, 1)
+	assert.Equal(t, "Alice", persons[0].Name)
+	assert.Equal(t, &models.CoverFace{FaceID: 4, AnnotatedImage: "task-1/face_4_boxed.jpg"}, persons[0].Cover)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddPersonTags(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT 1 FROM persons WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectExec(`INSERT INTO tags (.|\n)*ON CONFLICT \(name\) DO NOTHING`).
+		WithArgs(pq.Array([]string{"staff", "vip"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO person_tags (.|\n)*ON CONFLICT DO NOTHING`).
+		WithArgs(1, pq.Array([]string{"staff", "vip"})).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE persons SET updated_at = NOW\(\) WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT t.name`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("staff").AddRow("vip"))
+	mock.ExpectCommit()
+
+	tags, err := repo.AddPersonTags(1, []string{"Staff", "VIP"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"staff", "vip"}, tags)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAddPersonTagsDeletedPerson(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT 1 FROM persons`).
+		WithArgs(9).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	_, err := repo.AddPersonTags(9, []string{"staff"})
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRemovePersonTag(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectExec(`DELETE FROM person_tags pt`).
+		WithArgs(1, "vip").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE persons SET updated_at = NOW\(\) WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM person_tags pt`).
+		WithArgs(1, "missing").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	require.NoError(t, repo.RemovePersonTag(1, "VIP"))
+	assert.Equal(t, sql.ErrNoRows, repo.RemovePersonTag(1, "missing"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWithContextTracesQueries(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	repo, mock := newMockRepository(t)
+	mock.ExpectQuery(`SELECT \* FROM tasks WHERE id = \$1`).
+		WithArgs("missing").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec(`UPDATE tasks`).
+		WithArgs(50, "stage", "task-1").
+		WillReturnError(sql.ErrConnDone)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	traced := repo.WithContext(ctx)
+
+	_, err := traced.GetTask("missing")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	assert.Error(t, traced.UpdateTaskProgress("task-1", 50, "stage"))
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	// Запросы - дочерние спаны запроса; "не найдено" не считается ошибкой
+	assert.Equal(t, "postgres SELECT", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "postgres UPDATE", spans[1].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetAllPersonsIncludesCover(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	// Обложка - выбранная вручную, иначе лицо с наибольшей уверенностью, умноженной на резкость
+	mock.ExpectQuery(`LEFT JOIN faces cf ON cf.id = COALESCE\(p.cover_face_id, \(\s+SELECT id FROM faces WHERE person_id = p.id\s+ORDER BY confidence \* COALESCE\(quality, 0\) DESC, confidence DESC, id LIMIT 1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "faces_count", "id", "annotated_image"}).
+			AddRow(1, "Alice", now, now, 3, 12, "task-1/face_12_boxed.jpg").
+			AddRow(2, "Bob", now, now, 0, nil, nil))
+
+	persons, err := repo.GetAllPersons()
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, &models.CoverFace{FaceID: 12, AnnotatedImage: "task-1/face_12_boxed.jpg"}, persons[0].Cover)
+	assert.Nil(t, persons[1].Cover)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPickCover(t *testing.T) {
+	faces := []models.Face{
+		{ID: 3, Confidence: 0.8, AnnotatedImage: "c.jpg"},
+		{ID: 2, Confidence: 0.95, AnnotatedImage: "b.jpg"},
+		{ID: 1, Confidence: 0.95, AnnotatedImage: "a.jpg"},
+	}
+
+	// Без обложки - самое уверенное лицо, при равенстве - с меньшим ID
+	assert.Equal(t, &models.CoverFace{FaceID: 1, AnnotatedImage: "a.jpg"}, pickCover(sql.NullInt64{}, faces))
+
+	// Выбранная вручную обложка важнее уверенности
+	assert.Equal(t, &models.CoverFace{FaceID: 3, AnnotatedImage: "c.jpg"},
+		pickCover(sql.NullInt64{Int64: 3, Valid: true}, faces))
+
+	assert.Nil(t, pickCover(sql.NullInt64{}, nil))
+
+	// Резкое лицо важнее более уверенного размытого; лица без оценки - после оцененных
+	sharp, blurry := 0.9, 0.1
+	faces = []models.Face{
+		{ID: 1, Confidence: 0.99},
+		{ID: 2, Confidence: 0.95, Quality: &blurry},
+		{ID: 3, Confidence: 0.8, Quality: &sharp},
+	}
+	assert.Equal(t, 3, pickCover(sql.NullInt64{}, faces).FaceID)
+}
+
+func TestUpdatePersonCover(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`UPDATE persons\s+SET cover_face_id = \$2(.|\n)*AND EXISTS \(SELECT 1 FROM faces WHERE id = \$2 AND person_id = \$1\)`).
+		WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at", "cover_face_id"}).
+			AddRow(1, "Alice", now, now, 7))
+	// Лицо другого человека (или человек удален) - ничего не обновлено
+	mock.ExpectQuery(`UPDATE persons`).
+		WithArgs(1, 8).
+		WillReturnError(sql.ErrNoRows)
+
+	person, err := repo.UpdatePersonCover(1, 7)
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", person.Name)
+	assert.Equal(t, sql.NullInt64{Int64: 7, Valid: true}, person.CoverFaceID)
+
+	_, err = repo.UpdatePersonCover(1, 8)
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCreateFaceReturnsID(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Лицо из видео сохраняется со временем кадра
+	frameTime := 2.5
+	args := make([]driver.Value, 18)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[16] = frameTime
+	args[17] = "IMG_0001.jpg"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO faces(.|\n)*captured_at, frame_time(.|\n)*RETURNING id`).
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(42))
+	expectAudit(mock, audit.ActorSystem, []int{1}, []string{models.AuditFacesAdded}, []string{`{"face_ids":[42],"task_id":"task-1"}`})
+	mock.ExpectCommit()
+
+	face := &models.Face{PersonID: 1, TaskID: "task-1", OriginalImage: "task-1/a.jpg", OriginalFilename: "IMG_0001.jpg", FrameTime: &frameTime}
+	require.NoError(t, repo.CreateFace(face))
+	assert.Equal(t, 42, face.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdatePersonNameWritesAudit(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	ctx := audit.WithActor(context.Background(), audit.ActorAdmin)
+
+	// Исполнитель берется из контекста запроса; изменение и запись - в одной транзакции
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name FROM persons WHERE id = \$1 AND deleted_at IS NULL FOR UPDATE`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("person_3"))
+	mock.ExpectExec(`UPDATE persons\s+SET name = \$1, is_labeled = TRUE, updated_at = NOW\(\)\s+WHERE id = \$2`).
+		WithArgs("Alice", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, audit.ActorAdmin, []int{3}, []string{models.AuditRenamed}, []string{`{"new_name":"Alice","old_name":"person_3"}`})
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.WithContext(ctx).UpdatePersonName(3, "Alice"))
+
+	// То же имя - в журнале нечего записывать
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name FROM persons`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Alice"))
+	mock.ExpectExec(`UPDATE persons`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	require.NoError(t, repo.WithContext(ctx).UpdatePersonName(3, "Alice"))
+
+	// Удаленного человека не переименовать
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT name FROM persons`).
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectRollback()
+
+	assert.Equal(t, sql.ErrNoRows, repo.UpdatePersonName(4, "Bob"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLinkTaskPerson(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	// Повторная связь (например, второй кластер с тем же именем) не сбрасывает created
+	mock.ExpectExec(`INSERT INTO task_persons \(task_id, person_id, created\)(.|\n)*ON CONFLICT \(task_id, person_id\) DO UPDATE SET created = task_persons.created OR EXCLUDED.created`).
+		WithArgs("task-1", 3, true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	require.NoError(t, repo.LinkTaskPerson("task-1", 3, true))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonsByTask(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT p.\*, tp.created,(.|\n)*f.task_id = tp.task_id\) AS task_faces\s+FROM task_persons tp\s+JOIN persons p ON p.id = tp.person_id\s+WHERE tp.task_id = \$1 AND p.deleted_at IS NULL`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created", "task_faces"}).
+			AddRow(3, "cluster_0", true, 4).
+			AddRow(5, "Alice", false, 1))
+
+	persons, err := repo.GetPersonsByTask("task-1")
+	require.NoError(t, err)
+	require.Len(t, persons, 2)
+	assert.Equal(t, "cluster_0", persons[0].Name)
+	assert.True(t, persons[0].Created)
+	assert.Equal(t, 4, persons[0].TaskFaces)
+	assert.False(t, persons[1].Created)
+
+	// Задача без людей - пустой список, а не nil
+	mock.ExpectQuery(`FROM task_persons tp`).
+		WithArgs("task-2").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created", "task_faces"}))
+
+	persons, err = repo.GetPersonsByTask("task-2")
+	require.NoError(t, err)
+	assert.NotNil(t, persons)
+	assert.Empty(t, persons)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestLabelTaskPersons(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	ctx := audit.WithActor(context.Background(), audit.ActorAdmin)
+
+	// Люди задачи блокируются; ключи - имя кластера или ID, сопоставляются с исходными именами
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM persons\s+WHERE deleted_at IS NULL\s+AND id IN \(SELECT person_id FROM task_persons WHERE task_id = \$1\)\s+ORDER BY id\s+FOR UPDATE`).
+		WithArgs("task-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).
+			AddRow(3, "cluster_0").
+			AddRow(5, "cluster_1").
+			AddRow(8, "Carol"))
+	mock.ExpectExec(`UPDATE persons\s+SET name = \$1, is_labeled = TRUE, updated_at = NOW\(\)\s+WHERE id = \$2`).
+		WithArgs("Bob", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE persons`).
+		WithArgs("Alice", 5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE persons`).
+		WithArgs("Carol", 8).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// То же имя - без записи в журнале
+	expectAudit(mock, audit.ActorAdmin, []int{3, 5}, []string{models.AuditRenamed, models.AuditRenamed}, []string{
+		`{"new_name":"Bob","old_name":"cluster_0"}`,
+		`{"new_name":"Alice","old_name":"cluster_1"}`,
+	})
+	mock.ExpectCommit()
+
+	renamed, unknown, err := repo.WithContext(ctx).LabelTaskPersons("task-1", map[string]string{
+		"cluster_1": "Alice",
+		"3":         "Bob",
+		"Carol":     "Carol",
+		"cluster_7": "Dave",
+		"42":        "Eve",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []models.LabeledPerson{
+		{ID: 3, OldName: "cluster_0", Name: "Bob"},
+		{ID: 5, OldName: "cluster_1", Name: "Alice"},
+		{ID: 8, OldName: "Carol", Name: "Carol"},
+	}, renamed)
+	assert.Equal(t, []string{"42", "cluster_7"}, unknown)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonAudit(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT id, person_id, event, actor, details, created_at\s+FROM person_audit\s+WHERE person_id = \$1\s+ORDER BY id`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "event", "actor", "details", "created_at"}).
+			AddRow(1, 3, models.AuditFacesAdded, audit.ActorAnonymous, []byte(`{"face_ids":[42],"task_id":"task-1"}`), now).
+			AddRow(2, 3, models.AuditRenamed, audit.ActorAdmin, []byte(`{"old_name":"person_3","new_name":"Alice"}`), now))
+	mock.ExpectQuery(`FROM person_audit`).
+		WithArgs(9).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "event", "actor", "details", "created_at"}))
+
+	entries, err := repo.GetPersonAudit(3)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, models.AuditFacesAdded, entries[0].Event)
+	assert.Equal(t, audit.ActorAdmin, entries[1].Actor)
+	assert.JSONEq(t, `{"old_name":"person_3","new_name":"Alice"}`, string(entries[1].Details))
+
+	entries, err = repo.GetPersonAudit(9)
+	require.NoError(t, err)
+	assert.NotNil(t, entries)
+	assert.Empty(t, entries)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetPersonByIDImageSize(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT \* FROM persons WHERE id = \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "created_at", "updated_at"}).
+			AddRow(1, "Alice", now, now))
+	// У старого лица размер оригинала неизвестен (NULL)
+	mock.ExpectQuery(`face_width, face_height, image_width, image_height`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "person_id", "image_width", "image_height", "confidence"}).
+			AddRow(2, 1, 1920, 1080, 0.9).
+			AddRow(1, 1, nil, nil, 0.8))
+	mock.ExpectQuery(`FROM tags`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	person, err := repo.GetPersonByID(1)
+	require.NoError(t, err)
+	require.Len(t, person.Faces, 2)
+	require.NotNil(t, person.Faces[0].ImageWidth)
+	assert.Equal(t, 1920, *person.Faces[0].ImageWidth)
+	assert.Equal(t, 1080, *person.Faces[0].ImageHeight)
+	assert.Nil(t, person.Faces[1].ImageWidth)
+	assert.Nil(t, person.Faces[1].ImageHeight)
+
+	// В JSON неизвестный размер - null
+	data, err := json.Marshal(person.Faces[1])
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"image_width":null`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	"face-recognition/internal/tracing"
+
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracedDB выполняет запросы с контекстом репозитория и открывает span на каждый запрос
+// Методы повторяют sqlx.DB, поэтому код репозитория не зависит от трассировки
+type tracedDB struct {
+	db  *sqlx.DB
+	ctx context.Context
+}
+
+func (d tracedDB) Get(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuery(d.ctx, query)
+	err := d.db.GetContext(ctx, dest, query, args...)
+	endQuery(span, err)
+	return err
+}
+
+func (d tracedDB) Select(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuery(d.ctx, query)
+	err := d.db.SelectContext(ctx, dest, query, args...)
+	endQuery(span, err)
+	return err
+}
+
+func (d tracedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(d.ctx, query)
+	result, err := d.db.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+	return result, err
+}
+
+// Query - span покрывает выполнение запроса, но не чтение строк
+func (d tracedDB) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := startQuery(d.ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	endQuery(span, err)
+	return rows, err
+}
+
+func (d tracedDB) QueryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := startQuery(d.ctx, query)
+	row := d.db.QueryRowContext(ctx, query, args...)
+	endQuery(span, row.Err())
+	return row
+}
+
+func (d tracedDB) Beginx() (*tracedTx, error) {
+	tx, err := d.db.BeginTxx(d.ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{tx: tx, ctx: d.ctx}, nil
+}
+
+// tracedTx - транзакция, запросы которой тоже попадают в trace
+type tracedTx struct {
+	tx  *sqlx.Tx
+	ctx context.Context
+}
+
+func (t *tracedTx) Get(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuery(t.ctx, query)
+	err := t.tx.GetContext(ctx, dest, query, args...)
+	endQuery(span, err)
+	return err
+}
+
+func (t *tracedTx) Select(dest interface{}, query string, args ...interface{}) error {
+	ctx, span := startQuery(t.ctx, query)
+	err := t.tx.SelectContext(ctx, dest, query, args...)
+	endQuery(span, err)
+	return err
+}
+
+func (t *tracedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := startQuery(t.ctx, query)
+	result, err := t.tx.ExecContext(ctx, query, args...)
+	endQuery(span, err)
+	return result, err
+}
+
+func (t *tracedTx) Commit() error {
+	return t.tx.Commit()
+}
+
+func (t *tracedTx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// startQuery открывает span запроса с именем по первому слову SQL ("postgres SELECT")
+func startQuery(ctx context.Context, query string) (context.Context, trace.Span) {
+	statement := strings.Join(strings.Fields(query), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+
+	return tracing.Start(ctx, "postgres "+strings.ToUpper(operation),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.operation.name", strings.ToUpper(operation)),
+			attribute.String("db.query.text", statement),
+		),
+	)
+}
+
+// endQuery закрывает span запроса; sql.ErrNoRows - обычный результат, а не ошибка
+func endQuery(span trace.Span, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		err = nil
+	}
+	tracing.End(span, err)
+}
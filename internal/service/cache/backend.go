@@ -0,0 +1,22 @@
+package cache
+
+import (
+	"context"
+	"time"
+)
+
+// Backend - хранилище сырых значений кэша
+// Service сериализует модели в JSON и работает поверх любого бэкенда
+// ctx ограничивает операцию: бэкенд прерывает ее при отмене или дедлайне ctx
+type Backend interface {
+	// Get возвращает значение по ключу; found=false если ключа нет или он истек
+	Get(ctx context.Context, key string) (data []byte, found bool, err error)
+	// Set сохраняет значение на ttl
+	Set(ctx context.Context, key string, data []byte, ttl time.Duration) error
+	// Delete удаляет ключи (отсутствующие ключи не считаются ошибкой)
+	Delete(ctx context.Context, keys ...string) error
+	// Flush удаляет все ключи
+	Flush(ctx context.Context) error
+	// Close освобождает ресурсы бэкенда
+	Close() error
+}
@@ -4,170 +4,351 @@ import (
 	"context"
 	"encoding/json"
 	"face-recognition/internal/models"
+	"face-recognition/internal/tracing"
 	"fmt"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Service управляет кэшированием через Redis
+// Service управляет кэшированием моделей поверх Backend (Redis или in-memory LRU)
+// Каждая операция принимает ctx запроса: он задает родительский span и прерывает
+// операцию при отмене. Операция уже отмененного ctx не выполняется
 type Service struct {
-	client *redis.Client
-	ctx    context.Context
+	backend Backend
 }
 
+// Проверяем что бэкенды реализуют Backend
+var (
+	_ Backend = (*RedisBackend)(nil)
+	_ Backend = (*LRUBackend)(nil)
+)
+
 // NewService создает новый cache service
-func NewService(addr, password string, db int) (*Service, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+func NewService(backend Backend) *Service {
+	return &Service{backend: backend}
+}
+
+// Backend возвращает бэкенд кэша
+func (s *Service) Backend() Backend {
+	return s.backend
+}
 
-	ctx := context.Background()
+// Close закрывает бэкенд кэша
+func (s *Service) Close() error {
+	return s.backend.Close()
+}
 
-	// Проверяем подключение
-	if err := client.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("не удалось подключиться к Redis: %w", err)
+// startSpan открывает span операции кэша с ключом в атрибутах
+func (s *Service) startSpan(ctx context.Context, operation string, keys ...string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, "cache "+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.StringSlice("cache.keys", keys)),
+	)
+}
+
+// get читает ключ как есть
+// Возвращает false если ключа нет в кэше
+func (s *Service) get(ctx context.Context, key string) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
 	}
 
-	return &Service{
-		client: client,
-		ctx:    ctx,
-	}, nil
+	ctx, span := s.startSpan(ctx, "get", key)
+	data, found, err := s.backend.Get(ctx, key)
+	span.SetAttributes(attribute.Bool("cache.hit", found))
+	tracing.End(span, err)
+	return data, found, err
 }
 
-// Close закрывает соединение с Redis
-func (s *Service) Close() error {
-	return s.client.Close()
+// set сохраняет значение как есть на ttl
+func (s *Service) set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "set", key)
+	err := s.backend.Set(ctx, key, data, ttl)
+	tracing.End(span, err)
+	return err
 }
 
-// ============ PERSON CACHE ============
+// getJSON читает ключ и декодирует JSON в dst
+// Возвращает false если ключа нет в кэше
+func (s *Service) getJSON(ctx context.Context, key string, dst interface{}) (bool, error) {
+	data, found, err := s.get(ctx, key)
+	if err != nil || !found {
+		return false, err
+	}
 
-// GetPerson получает персону из кэша
-func (s *Service) GetPerson(id int) (*models.PersonWithFaces, error) {
-	key := fmt.Sprintf("person:%d", id)
+	if err := json.Unmarshal(data, dst); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
 
-	data, err := s.client.Get(s.ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, nil // Не найдено в кэше
+// setJSON кодирует значение в JSON и сохраняет на ttl
+func (s *Service) setJSON(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
 	}
+
+	return s.set(ctx, key, data, ttl)
+}
+
+// staleTTL - сколько хранится резервная копия записи (stale:<ключ>), которую отдают,
+// пока БД недоступна. Копия переживает TTL и инвалидацию основного ключа: во время
+// сбоя БД устаревшие данные лучше ошибки 500
+const staleTTL = 24 * time.Hour
+
+// staleKey - ключ резервной копии записи key
+func staleKey(key string) string {
+	return "stale:" + key
+}
+
+// setJSONWithStale сохраняет значение на ttl и его резервную копию на staleTTL
+// (одна операция set в trace)
+func (s *Service) setJSONWithStale(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	data, err := json.Marshal(value)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ctx, span := s.startSpan(ctx, "set", key, staleKey(key))
+	err = s.backend.Set(ctx, key, data, ttl)
+	if err == nil {
+		err = s.backend.Set(ctx, staleKey(key), data, staleTTL)
+	}
+	tracing.End(span, err)
+	return err
+}
+
+// delete удаляет ключи из кэша
+func (s *Service) delete(ctx context.Context, keys ...string) error {
+	if err := ctx.Err(); err != nil {
+		return err
 	}
 
+	ctx, span := s.startSpan(ctx, "delete", keys...)
+	err := s.backend.Delete(ctx, keys...)
+	tracing.End(span, err)
+	return err
+}
+
+// ============ PERSON CACHE ============
+
+// GetPerson получает персону из кэша
+func (s *Service) GetPerson(ctx context.Context, id int) (*models.PersonWithFaces, error) {
 	var person models.PersonWithFaces
-	if err := json.Unmarshal(data, &person); err != nil {
-		return nil, err
+	found, err := s.getJSON(ctx, fmt.Sprintf("person:%d", id), &person)
+	if err != nil || !found {
+		return nil, err // nil, nil - не найдено в кэше
 	}
 
 	return &person, nil
 }
 
-// SetPerson сохраняет персону в кэш на 1 час
-func (s *Service) SetPerson(person *models.PersonWithFaces) error {
-	key := fmt.Sprintf("person:%d", person.ID)
+// SetPerson сохраняет персону в кэш на 1 час (и резервную копию на staleTTL)
+func (s *Service) SetPerson(ctx context.Context, person *models.PersonWithFaces) error {
+	return s.setJSONWithStale(ctx, fmt.Sprintf("person:%d", person.ID), person, 1*time.Hour)
+}
 
-	data, err := json.Marshal(person)
-	if err != nil {
-		return err
+// GetStalePerson получает резервную копию персоны - для ответа, когда БД недоступна
+// (nil, nil - копии нет)
+func (s *Service) GetStalePerson(ctx context.Context, id int) (*models.PersonWithFaces, error) {
+	var person models.PersonWithFaces
+	found, err := s.getJSON(ctx, staleKey(fmt.Sprintf("person:%d", id)), &person)
+	if err != nil || !found {
+		return nil, err
 	}
 
-	return s.client.Set(s.ctx, key, data, 1*time.Hour).Err()
+	return &person, nil
 }
 
 // InvalidatePerson удаляет персону из кэша
-func (s *Service) InvalidatePerson(id int) error {
-	key := fmt.Sprintf("person:%d", id)
-	return s.client.Del(s.ctx, key).Err()
+func (s *Service) InvalidatePerson(ctx context.Context, id int) error {
+	return s.delete(ctx, fmt.Sprintf("person:%d", id))
 }
 
 // ============ TASK CACHE ============
 
 // GetTask получает задачу из кэша
-func (s *Service) GetTask(taskID string) (*models.Task, error) {
-	key := fmt.Sprintf("task:%s", taskID)
-
-	data, err := s.client.Get(s.ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
-	if err != nil {
+func (s *Service) GetTask(ctx context.Context, taskID string) (*models.Task, error) {
+	var task models.Task
+	found, err := s.getJSON(ctx, fmt.Sprintf("task:%s", taskID), &task)
+	if err != nil || !found {
 		return nil, err
 	}
 
+	return &task, nil
+}
+
+// SetTask сохраняет задачу в кэш
+func (s *Service) SetTask(ctx context.Context, task *models.Task) error {
+	// Задачи храним 24 часа
+	return s.setJSONWithStale(ctx, fmt.Sprintf("task:%s", task.ID), task, 24*time.Hour)
+}
+
+// GetStaleTask получает резервную копию задачи - для ответа, когда БД недоступна
+// (nil, nil - копии нет)
+func (s *Service) GetStaleTask(ctx context.Context, taskID string) (*models.Task, error) {
 	var task models.Task
-	if err := json.Unmarshal(data, &task); err != nil {
+	found, err := s.getJSON(ctx, staleKey(fmt.Sprintf("task:%s", taskID)), &task)
+	if err != nil || !found {
 		return nil, err
 	}
 
 	return &task, nil
 }
 
-// SetTask сохраняет задачу в кэш
-func (s *Service) SetTask(task *models.Task) error {
-	key := fmt.Sprintf("task:%s", task.ID)
+// InvalidateTask удаляет задачу из кэша
+func (s *Service) InvalidateTask(ctx context.Context, taskID string) error {
+	return s.delete(ctx, fmt.Sprintf("task:%s", taskID))
+}
 
-	data, err := json.Marshal(task)
-	if err != nil {
-		return err
+// GetTaskIDByHash возвращает ID завершенной задачи для хэша загрузки
+// Пустая строка - хэш не найден в кэше
+func (s *Service) GetTaskIDByHash(ctx context.Context, contentHash string) (string, error) {
+	var taskID string
+	found, err := s.getJSON(ctx, fmt.Sprintf("upload_hash:%s", contentHash), &taskID)
+	if err != nil || !found {
+		return "", err
 	}
 
-	// Задачи храним 24 часа
-	return s.client.Set(s.ctx, key, data, 24*time.Hour).Err()
+	return taskID, nil
+}
+
+// SetTaskIDByHash запоминает завершенную задачу для хэша загрузки на 24 часа
+func (s *Service) SetTaskIDByHash(ctx context.Context, contentHash, taskID string) error {
+	return s.setJSON(ctx, fmt.Sprintf("upload_hash:%s", contentHash), taskID, 24*time.Hour)
+}
+
+// InvalidateTaskIDByHash удаляет запись о хэше загрузки
+func (s *Service) InvalidateTaskIDByHash(ctx context.Context, contentHash string) error {
+	return s.delete(ctx, fmt.Sprintf("upload_hash:%s", contentHash))
+}
+
+// GetRecentTasks получает последние задачи из кэша (nil, nil - нет в кэше)
+func (s *Service) GetRecentTasks(ctx context.Context) ([]models.Task, error) {
+	var tasks []models.Task
+	found, err := s.getJSON(ctx, "recent_tasks", &tasks)
+	if err != nil || !found {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// SetRecentTasks сохраняет последние задачи в кэш на 10 секунд
+// Статус и прогресс задач меняются постоянно, поэтому TTL короткий:
+// кэш только гасит частые опросы главной страницы
+func (s *Service) SetRecentTasks(ctx context.Context, tasks []models.Task) error {
+	return s.setJSON(ctx, "recent_tasks", tasks, 10*time.Second)
+}
+
+// InvalidateRecentTasks очищает кэш последних задач
+func (s *Service) InvalidateRecentTasks(ctx context.Context) error {
+	return s.delete(ctx, "recent_tasks")
 }
 
 // ============ STATS CACHE ============
 
 // GetStats получает статистику из кэша
-func (s *Service) GetStats() (*models.Stats, error) {
-	data, err := s.client.Get(s.ctx, "stats").Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
-	if err != nil {
+func (s *Service) GetStats(ctx context.Context) (*models.Stats, error) {
+	var stats models.Stats
+	found, err := s.getJSON(ctx, "stats", &stats)
+	if err != nil || !found {
 		return nil, err
 	}
 
+	return &stats, nil
+}
+
+// SetStats сохраняет статистику в кэш на 1 минуту
+// TTL короче, чем у остальных ключей: статистика включает
+// временной ряд по дням, который должен обновляться часто
+func (s *Service) SetStats(ctx context.Context, stats *models.Stats) error {
+	return s.setJSONWithStale(ctx, "stats", stats, 1*time.Minute)
+}
+
+// GetStaleStats получает резервную копию статистики - для ответа, когда БД недоступна
+// (nil, nil - копии нет)
+func (s *Service) GetStaleStats(ctx context.Context) (*models.Stats, error) {
 	var stats models.Stats
-	if err := json.Unmarshal(data, &stats); err != nil {
+	found, err := s.getJSON(ctx, staleKey("stats"), &stats)
+	if err != nil || !found {
 		return nil, err
 	}
 
 	return &stats, nil
 }
 
-// SetStats сохраняет статистику в кэш на 5 минут
-func (s *Service) SetStats(stats *models.Stats) error {
-	data, err := json.Marshal(stats)
-	if err != nil {
-		return err
+// InvalidateStats очищает кэш статистики
+func (s *Service) InvalidateStats(ctx context.Context) error {
+	return s.delete(ctx, "stats")
+}
+
+// ============ FACE CROP CACHE ============
+
+// GetFaceCrop получает JPEG вырезанного лица (nil, nil - нет в кэше)
+func (s *Service) GetFaceCrop(ctx context.Context, faceID int, padding float64) ([]byte, error) {
+	data, found, err := s.get(ctx, faceCropKey(faceID, padding))
+	if err != nil || !found {
+		return nil, err
 	}
 
-	return s.client.Set(s.ctx, "stats", data, 5*time.Minute).Err()
+	return data, nil
 }
 
-// InvalidateStats очищает кэш статистики
-func (s *Service) InvalidateStats() error {
-	return s.client.Del(s.ctx, "stats").Err()
+// SetFaceCrop сохраняет JPEG вырезанного лица на 24 часа
+// Bbox и исходник лица не меняются, поэтому инвалидация не нужна
+func (s *Service) SetFaceCrop(ctx context.Context, faceID int, padding float64, data []byte) error {
+	return s.set(ctx, faceCropKey(faceID, padding), data, 24*time.Hour)
 }
 
-// ============ EMBEDDINGS CACHE ============
+// faceCropKey - ключ вырезки лица с заданным отступом
+func faceCropKey(faceID int, padding float64) string {
+	return fmt.Sprintf("face_crop:%d:%g", faceID, padding)
+}
 
-// GetEmbedding получает embedding для изображения
-func (s *Service) GetEmbedding(imagePath string) ([]float64, error) {
-	key := fmt.Sprintf("embedding:%s", imagePath)
+// ============ PERSON SPRITE CACHE ============
 
-	data, err := s.client.Get(s.ctx, key).Bytes()
-	if err == redis.Nil {
-		return nil, nil
-	}
-	if err != nil {
+// GetPersonSprite получает спрайт лиц человека (nil, nil - нет в кэше)
+// faces - отпечаток набора лиц: после добавления или удаления лиц ключ другой,
+// поэтому устаревший спрайт не отдается
+func (s *Service) GetPersonSprite(ctx context.Context, personID, cols, size int, faces string) (*models.PersonSpriteResponse, error) {
+	var sprite models.PersonSpriteResponse
+	found, err := s.getJSON(ctx, personSpriteKey(personID, cols, size, faces), &sprite)
+	if err != nil || !found {
 		return nil, err
 	}
 
+	return &sprite, nil
+}
+
+// SetPersonSprite сохраняет спрайт лиц человека на 24 часа
+func (s *Service) SetPersonSprite(ctx context.Context, cols, size int, faces string, sprite *models.PersonSpriteResponse) error {
+	return s.setJSON(ctx, personSpriteKey(sprite.PersonID, cols, size, faces), sprite, 24*time.Hour)
+}
+
+// personSpriteKey - ключ спрайта человека с заданной сеткой и набором лиц
+func personSpriteKey(personID, cols, size int, faces string) string {
+	return fmt.Sprintf("person_sprite:%d:%d:%d:%s", personID, cols, size, faces)
+}
+
+// ============ EMBEDDINGS CACHE ============
+
+// GetEmbedding получает embedding для изображения
+func (s *Service) GetEmbedding(ctx context.Context, imagePath string) ([]float64, error) {
 	var embedding []float64
-	if err := json.Unmarshal(data, &embedding); err != nil {
+	found, err := s.getJSON(ctx, fmt.Sprintf("embedding:%s", imagePath), &embedding)
+	if err != nil || !found {
 		return nil, err
 	}
 
@@ -175,20 +356,16 @@ func (s *Service) GetEmbedding(imagePath string) ([]float64, error) {
 }
 
 // SetEmbedding сохраняет embedding в кэш на 7 дней
-func (s *Service) SetEmbedding(imagePath string, embedding []float64) error {
-	key := fmt.Sprintf("embedding:%s", imagePath)
-
-	data, err := json.Marshal(embedding)
-	if err != nil {
-		return err
-	}
-
-	return s.client.Set(s.ctx, key, data, 7*24*time.Hour).Err()
+func (s *Service) SetEmbedding(ctx context.Context, imagePath string, embedding []float64) error {
+	return s.setJSON(ctx, fmt.Sprintf("embedding:%s", imagePath), embedding, 7*24*time.Hour)
 }
 
 // ============ UTILITY ============
 
-// FlushAll очищает весь кэш (только для разработки!)
-func (s *Service) FlushAll() error {
-	return s.client.FlushAll(s.ctx).Err()
+// FlushAll очищает весь кэш (для отладки, см. DELETE /api/cache/all)
+func (s *Service) FlushAll(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.backend.Flush(ctx)
 }
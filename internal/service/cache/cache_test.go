@@ -0,0 +1,376 @@
+package cache
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// fakeClock - управляемые часы для проверки TTL
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestLRU(maxEntries int) (*LRUBackend, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2024, 11, 21, 12, 0, 0, 0, time.UTC)}
+	b := NewLRUBackend(maxEntries)
+	b.now = clock.Now
+	return b, clock
+}
+
+func TestLRUExpiresEntries(t *testing.T) {
+	ctx := context.Background()
+	b, clock := newTestLRU(10)
+
+	require.NoError(t, b.Set(ctx, "a", []byte("1"), time.Minute))
+
+	data, found, err := b.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, []byte("1"), data)
+
+	clock.now = clock.now.Add(time.Minute + time.Second)
+
+	_, found, err = b.Get(ctx, "a")
+	require.NoError(t, err)
+	assert.False(t, found)
+	assert.Equal(t, 0, b.Len(), "истекший ключ удаляется при чтении")
+}
+
+func TestLRUSetRefreshesTTL(t *testing.T) {
+	ctx := context.Background()
+	b, clock := newTestLRU(10)
+
+	require.NoError(t, b.Set(ctx, "a", []byte("1"), time.Minute))
+	clock.now = clock.now.Add(50 * time.Second)
+	require.NoError(t, b.Set(ctx, "a", []byte("2"), time.Minute))
+	clock.now = clock.now.Add(50 * time.Second)
+
+	data, found, _ := b.Get(ctx, "a")
+	assert.True(t, found)
+	assert.Equal(t, []byte("2"), data)
+}
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestLRU(2)
+
+	require.NoError(t, b.Set(ctx, "a", []byte("1"), time.Hour))
+	require.NoError(t, b.Set(ctx, "b", []byte("2"), time.Hour))
+
+	// Читаем "a" - теперь самый старый "b"
+	_, found, _ := b.Get(ctx, "a")
+	require.True(t, found)
+
+	require.NoError(t, b.Set(ctx, "c", []byte("3"), time.Hour))
+	assert.Equal(t, 2, b.Len())
+
+	_, found, _ = b.Get(ctx, "b")
+	assert.False(t, found, "b должен быть вытеснен")
+	_, found, _ = b.Get(ctx, "a")
+	assert.True(t, found)
+	_, found, _ = b.Get(ctx, "c")
+	assert.True(t, found)
+}
+
+func TestLRUDeleteAndFlush(t *testing.T) {
+	ctx := context.Background()
+	b, _ := newTestLRU(10)
+
+	require.NoError(t, b.Set(ctx, "a", []byte("1"), time.Hour))
+	require.NoError(t, b.Set(ctx, "b", []byte("2"), time.Hour))
+
+	require.NoError(t, b.Delete(ctx, "a", "missing"))
+	_, found, _ := b.Get(ctx, "a")
+	assert.False(t, found)
+	assert.Equal(t, 1, b.Len())
+
+	require.NoError(t, b.Flush(ctx))
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestServiceWithLRUBackend(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(NewLRUBackend(10))
+
+	person, err := s.GetPerson(ctx, 1)
+	require.NoError(t, err)
+	assert.Nil(t, person, "промах кэша - nil без ошибки")
+
+	require.NoError(t, s.SetPerson(ctx, &models.PersonWithFaces{Person: models.Person{ID: 1, Name: "Alice"}, Count: 2}))
+	person, err = s.GetPerson(ctx, 1)
+	require.NoError(t, err)
+	require.NotNil(t, person)
+	assert.Equal(t, "Alice", person.Name)
+	assert.Equal(t, 2, person.Count)
+
+	require.NoError(t, s.InvalidatePerson(ctx, 1))
+	person, _ = s.GetPerson(ctx, 1)
+	assert.Nil(t, person)
+
+	require.NoError(t, s.SetStats(ctx, &models.Stats{TotalPersons: 3}))
+	stats, err := s.GetStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, stats.TotalPersons)
+
+	require.NoError(t, s.InvalidateStats(ctx))
+	stats, _ = s.GetStats(ctx)
+	assert.Nil(t, stats)
+}
+
+func TestServiceRecentTasks(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(NewLRUBackend(10))
+
+	tasks, err := s.GetRecentTasks(ctx)
+	require.NoError(t, err)
+	assert.Nil(t, tasks)
+
+	// Пустой список - тоже попадание, чтобы не ходить в БД при пустой базе
+	require.NoError(t, s.SetRecentTasks(ctx, []models.Task{}))
+	tasks, err = s.GetRecentTasks(ctx)
+	require.NoError(t, err)
+	assert.NotNil(t, tasks)
+	assert.Empty(t, tasks)
+
+	require.NoError(t, s.SetRecentTasks(ctx, []models.Task{{ID: "b"}, {ID: "a"}}))
+	tasks, err = s.GetRecentTasks(ctx)
+	require.NoError(t, err)
+	require.Len(t, tasks, 2)
+	assert.Equal(t, "b", tasks[0].ID)
+	assert.Equal(t, "a", tasks[1].ID)
+
+	require.NoError(t, s.InvalidateRecentTasks(ctx))
+	tasks, _ = s.GetRecentTasks(ctx)
+	assert.Nil(t, tasks)
+}
+
+func TestServiceStaleCopies(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(NewLRUBackend(10))
+
+	require.NoError(t, s.SetPerson(ctx, &models.PersonWithFaces{Person: models.Person{ID: 5, Name: "Alice"}}))
+	require.NoError(t, s.SetTask(ctx, &models.Task{ID: "task-1", Status: models.TaskStatusCompleted}))
+	require.NoError(t, s.SetStats(ctx, &models.Stats{TotalPersons: 3}))
+
+	// Инвалидация удаляет основной ключ, резервная копия остается
+	require.NoError(t, s.InvalidatePerson(ctx, 5))
+	require.NoError(t, s.InvalidateTask(ctx, "task-1"))
+	require.NoError(t, s.InvalidateStats(ctx))
+
+	person, _ := s.GetPerson(ctx, 5)
+	assert.Nil(t, person)
+	person, err := s.GetStalePerson(ctx, 5)
+	require.NoError(t, err)
+	require.NotNil(t, person)
+	assert.Equal(t, "Alice", person.Name)
+
+	task, err := s.GetStaleTask(ctx, "task-1")
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, models.TaskStatusCompleted, task.Status)
+
+	stats, err := s.GetStaleStats(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, stats)
+	assert.Equal(t, 3, stats.TotalPersons)
+
+	// Копии нет - nil, nil
+	person, err = s.GetStalePerson(ctx, 6)
+	require.NoError(t, err)
+	assert.Nil(t, person)
+
+	// Полная очистка удаляет и копии
+	require.NoError(t, s.FlushAll(ctx))
+	task, _ = s.GetStaleTask(ctx, "task-1")
+	assert.Nil(t, task)
+}
+
+func TestServiceFaceCrop(t *testing.T) {
+	ctx := context.Background()
+	s := NewService(NewLRUBackend(10))
+
+	data, err := s.GetFaceCrop(ctx, 7, 0.2)
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	// JPEG хранится как есть, отдельно для каждого отступа
+	require.NoError(t, s.SetFaceCrop(ctx, 7, 0.2, []byte{0xff, 0xd8, 0x01}))
+	data, err = s.GetFaceCrop(ctx, 7, 0.2)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{0xff, 0xd8, 0x01}, data)
+
+	data, _ = s.GetFaceCrop(ctx, 7, 0)
+	assert.Nil(t, data)
+}
+
+func TestServiceWithContextTracesOperations(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "request")
+	s := NewService(NewLRUBackend(10))
+
+	task, err := s.GetTask(ctx, "task-1")
+	require.NoError(t, err)
+	assert.Nil(t, task)
+	require.NoError(t, s.SetTask(ctx, &models.Task{ID: "task-1"}))
+	_, err = s.GetTask(ctx, "task-1")
+	require.NoError(t, err)
+	require.NoError(t, s.InvalidateTask(ctx, "task-1"))
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 5)
+
+	names := make([]string, 0, 4)
+	for _, span := range spans[:4] {
+		assert.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+		names = append(names, span.Name())
+	}
+	assert.Equal(t, []string{"cache get", "cache set", "cache get", "cache delete"}, names)
+	assert.Contains(t, spans[0].Attributes(), attribute.Bool("cache.hit", false))
+	assert.Contains(t, spans[2].Attributes(), attribute.Bool("cache.hit", true))
+	assert.Contains(t, spans[3].Attributes(), attribute.StringSlice("cache.keys", []string{"task:task-1"}))
+}
+
+// hungRedis - сервер, который принимает соединения, но ничего не отвечает
+func hungRedis(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	})
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestRedisOptions(t *testing.T) {
+	cfg := config.RedisConfig{
+		Addr:         "redis:6379",
+		DB:           2,
+		PoolSize:     20,
+		DialTimeout:  time.Second,
+		ReadTimeout:  200 * time.Millisecond,
+		WriteTimeout: 300 * time.Millisecond,
+	}
+
+	opts := redisOptions(cfg)
+	assert.Equal(t, "redis:6379", opts.Addr)
+	assert.Equal(t, 2, opts.DB)
+	assert.Equal(t, 20, opts.PoolSize)
+	assert.Equal(t, time.Second, opts.DialTimeout)
+	assert.Equal(t, 200*time.Millisecond, opts.ReadTimeout)
+	assert.Equal(t, 300*time.Millisecond, opts.WriteTimeout)
+	assert.True(t, opts.ContextTimeoutEnabled)
+
+	assert.Equal(t, 1500*time.Millisecond, operationTimeout(cfg))
+	assert.Equal(t, 3*time.Second, operationTimeout(config.RedisConfig{}))
+}
+
+func TestRedisBackendTimeout(t *testing.T) {
+	ctx := context.Background()
+	cfg := config.RedisConfig{
+		Addr:         hungRedis(t),
+		DialTimeout:  50 * time.Millisecond,
+		ReadTimeout:  50 * time.Millisecond,
+		WriteTimeout: 50 * time.Millisecond,
+	}
+
+	// Проверка соединения при старте тоже не зависает
+	start := time.Now()
+	_, err := NewRedisBackend(cfg)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 2*time.Second)
+
+	// Зависший Redis - ошибка кэша, а не зависший запрос; handlers читают из БД
+	b := &RedisBackend{client: redis.NewClient(redisOptions(cfg)), timeout: operationTimeout(cfg)}
+	defer b.Close()
+	s := NewService(b)
+
+	start = time.Now()
+	person, err := s.GetPerson(ctx, 1)
+	assert.Error(t, err)
+	assert.Nil(t, person)
+	assert.Error(t, s.SetStats(ctx, &models.Stats{}))
+	assert.Less(t, time.Since(start), 2*time.Second)
+}
+
+func TestServiceCancelledContext(t *testing.T) {
+	s := NewService(NewLRUBackend(10))
+	require.NoError(t, s.SetPerson(context.Background(), &models.PersonWithFaces{Person: models.Person{ID: 1}}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Операции отмененного запроса не выполняются
+	person, err := s.GetPerson(ctx, 1)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, person)
+	assert.ErrorIs(t, s.SetStats(ctx, &models.Stats{}), context.Canceled)
+	assert.ErrorIs(t, s.InvalidatePerson(ctx, 1), context.Canceled)
+	assert.ErrorIs(t, s.FlushAll(ctx), context.Canceled)
+
+	person, err = s.GetPerson(context.Background(), 1)
+	require.NoError(t, err)
+	assert.NotNil(t, person, "отмененная инвалидация ничего не удалила")
+	stats, _ := s.GetStats(context.Background())
+	assert.Nil(t, stats)
+}
+
+func TestRedisBackendRequestDeadline(t *testing.T) {
+	// Таймауты большие - операцию прерывает дедлайн ctx запроса
+	// (go-redis учитывает дедлайн ctx, но не его отмену во время чтения)
+	cfg := config.RedisConfig{
+		Addr:         hungRedis(t),
+		DialTimeout:  time.Minute,
+		ReadTimeout:  time.Minute,
+		WriteTimeout: time.Minute,
+	}
+	b := &RedisBackend{client: redis.NewClient(redisOptions(cfg)), timeout: operationTimeout(cfg)}
+	defer b.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := b.Get(ctx, "person:1")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
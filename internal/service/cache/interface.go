@@ -0,0 +1,45 @@
+package cache
+
+import (
+	"context"
+
+	"face-recognition/internal/models"
+)
+
+// ServiceInterface определяет контракт кэша, используемый handlers
+// Позволяет подменять кэш в тестах. Все операции принимают ctx запроса (trace и отмена)
+// GetStale* - резервные копии записей для ответа, пока БД недоступна
+type ServiceInterface interface {
+	GetPerson(ctx context.Context, id int) (*models.PersonWithFaces, error)
+	SetPerson(ctx context.Context, person *models.PersonWithFaces) error
+	InvalidatePerson(ctx context.Context, id int) error
+	GetStalePerson(ctx context.Context, id int) (*models.PersonWithFaces, error)
+
+	GetTask(ctx context.Context, taskID string) (*models.Task, error)
+	SetTask(ctx context.Context, task *models.Task) error
+	InvalidateTask(ctx context.Context, taskID string) error
+	GetStaleTask(ctx context.Context, taskID string) (*models.Task, error)
+	GetTaskIDByHash(ctx context.Context, contentHash string) (string, error)
+	SetTaskIDByHash(ctx context.Context, contentHash, taskID string) error
+	InvalidateTaskIDByHash(ctx context.Context, contentHash string) error
+	GetRecentTasks(ctx context.Context) ([]models.Task, error)
+	SetRecentTasks(ctx context.Context, tasks []models.Task) error
+	InvalidateRecentTasks(ctx context.Context) error
+
+	GetFaceCrop(ctx context.Context, faceID int, padding float64) ([]byte, error)
+	SetFaceCrop(ctx context.Context, faceID int, padding float64, data []byte) error
+
+	GetPersonSprite(ctx context.Context, personID, cols, size int, faces string) (*models.PersonSpriteResponse, error)
+	SetPersonSprite(ctx context.Context, cols, size int, faces string, sprite *models.PersonSpriteResponse) error
+
+	GetStats(ctx context.Context) (*models.Stats, error)
+	SetStats(ctx context.Context, stats *models.Stats) error
+	InvalidateStats(ctx context.Context) error
+	GetStaleStats(ctx context.Context) (*models.Stats, error)
+
+	// FlushAll очищает весь кэш
+	FlushAll(ctx context.Context) error
+}
+
+// Проверяем что Service реализует ServiceInterface
+var _ ServiceInterface = (*Service)(nil)
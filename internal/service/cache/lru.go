@@ -0,0 +1,129 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultLRUSize - размер in-memory кэша по умолчанию (количество ключей)
+const DefaultLRUSize = 1000
+
+// LRUBackend - in-memory кэш с ограничением размера и TTL
+// Используется как fallback, когда Redis недоступен
+// Операции в памяти не блокируются, поэтому ctx не используется
+type LRUBackend struct {
+	mu         sync.Mutex
+	maxEntries int
+	ll         *list.List // Front - самый свежий элемент
+	items      map[string]*list.Element
+	now        func() time.Time
+}
+
+// lruEntry - элемент списка LRU
+type lruEntry struct {
+	key       string
+	data      []byte
+	expiresAt time.Time
+}
+
+// NewLRUBackend создает in-memory кэш на maxEntries ключей
+func NewLRUBackend(maxEntries int) *LRUBackend {
+	if maxEntries <= 0 {
+		maxEntries = DefaultLRUSize
+	}
+
+	return &LRUBackend{
+		maxEntries: maxEntries,
+		ll:         list.New(),
+		items:      make(map[string]*list.Element),
+		now:        time.Now,
+	}
+}
+
+// Get возвращает значение и помечает ключ как недавно использованный
+// Истекшие ключи удаляются при обращении
+func (b *LRUBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	elem, ok := b.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	entry := elem.Value.(*lruEntry)
+	if b.now().After(entry.expiresAt) {
+		b.removeElement(elem)
+		return nil, false, nil
+	}
+
+	b.ll.MoveToFront(elem)
+	return entry.data, true, nil
+}
+
+// Set сохраняет значение, вытесняя самый старый ключ при переполнении
+func (b *LRUBackend) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	expiresAt := b.now().Add(ttl)
+
+	if elem, ok := b.items[key]; ok {
+		entry := elem.Value.(*lruEntry)
+		entry.data = data
+		entry.expiresAt = expiresAt
+		b.ll.MoveToFront(elem)
+		return nil
+	}
+
+	b.items[key] = b.ll.PushFront(&lruEntry{key: key, data: data, expiresAt: expiresAt})
+
+	for b.ll.Len() > b.maxEntries {
+		b.removeElement(b.ll.Back())
+	}
+
+	return nil
+}
+
+// Delete удаляет ключи
+func (b *LRUBackend) Delete(ctx context.Context, keys ...string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		if elem, ok := b.items[key]; ok {
+			b.removeElement(elem)
+		}
+	}
+	return nil
+}
+
+// Flush удаляет все ключи
+func (b *LRUBackend) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.ll.Init()
+	b.items = make(map[string]*list.Element)
+	return nil
+}
+
+// Close ничего не делает - ресурсов нет
+func (b *LRUBackend) Close() error {
+	return nil
+}
+
+// Len возвращает количество ключей (включая еще не удаленные истекшие)
+func (b *LRUBackend) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.ll.Len()
+}
+
+// removeElement удаляет элемент из списка и индекса (вызывать под mu)
+func (b *LRUBackend) removeElement(elem *list.Element) {
+	b.ll.Remove(elem)
+	delete(b.items, elem.Value.(*lruEntry).key)
+}
@@ -0,0 +1,107 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"face-recognition/internal/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBackend хранит кэш в Redis
+type RedisBackend struct {
+	client  *redis.Client
+	timeout time.Duration // Предел одной операции (см. operation)
+}
+
+// NewRedisBackend подключается к Redis и проверяет соединение
+func NewRedisBackend(cfg config.RedisConfig) (*RedisBackend, error) {
+	b := &RedisBackend{
+		client:  redis.NewClient(redisOptions(cfg)),
+		timeout: operationTimeout(cfg),
+	}
+
+	// Проверяем подключение
+	ctx, cancel := b.operation(context.Background())
+	defer cancel()
+	if err := b.client.Ping(ctx).Err(); err != nil {
+		b.client.Close()
+		return nil, fmt.Errorf("не удалось подключиться к Redis: %w", err)
+	}
+
+	return b, nil
+}
+
+// redisOptions - параметры клиента из конфигурации
+// ContextTimeoutEnabled: иначе go-redis не учитывает дедлайн контекста операции
+func redisOptions(cfg config.RedisConfig) *redis.Options {
+	return &redis.Options{
+		Addr:                  cfg.Addr,
+		Password:              cfg.Password,
+		DB:                    cfg.DB,
+		PoolSize:              cfg.PoolSize,
+		DialTimeout:           cfg.DialTimeout,
+		ReadTimeout:           cfg.ReadTimeout,
+		WriteTimeout:          cfg.WriteTimeout,
+		ContextTimeoutEnabled: true,
+	}
+}
+
+// operationTimeout - сколько может занять одна команда вместе с установкой соединения
+func operationTimeout(cfg config.RedisConfig) time.Duration {
+	timeout := cfg.DialTimeout + cfg.ReadTimeout + cfg.WriteTimeout
+	if timeout <= 0 {
+		timeout = config.DefaultRedisDialTimeout + config.DefaultRedisReadTimeout + config.DefaultRedisWriteTimeout
+	}
+	return timeout
+}
+
+// operation - контекст одной операции: наследует дедлайн и отмену ctx вызывающего, а зависший
+// Redis не держит запрос дольше timeout. Ошибка по таймауту для Service - обычная
+// ошибка кэша, данные читаются из БД
+func (b *RedisBackend) operation(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, b.timeout)
+}
+
+// Get получает значение из Redis
+func (b *RedisBackend) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
+
+	data, err := b.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// Set сохраняет значение в Redis
+func (b *RedisBackend) Set(ctx context.Context, key string, data []byte, ttl time.Duration) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
+	return b.client.Set(ctx, key, data, ttl).Err()
+}
+
+// Delete удаляет ключи из Redis
+func (b *RedisBackend) Delete(ctx context.Context, keys ...string) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
+	return b.client.Del(ctx, keys...).Err()
+}
+
+// Flush очищает всю базу Redis (только для разработки!)
+func (b *RedisBackend) Flush(ctx context.Context) error {
+	ctx, cancel := b.operation(ctx)
+	defer cancel()
+	return b.client.FlushAll(ctx).Err()
+}
+
+// Close закрывает соединение с Redis
+func (b *RedisBackend) Close() error {
+	return b.client.Close()
+}
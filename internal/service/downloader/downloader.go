@@ -0,0 +1,266 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+
+	"face-recognition/internal/config"
+	"face-recognition/internal/service/storage"
+)
+
+// maxRedirects - сколько редиректов допускается при скачивании
+const maxRedirects = 3
+
+// concurrency - сколько изображений скачивается одновременно
+const concurrency = 4
+
+// MaxURLLength - максимальная длина URL (как у callback_url)
+const MaxURLLength = 2048
+
+// Ошибки скачивания
+var (
+	ErrPrivateAddress = errors.New("адрес во внутренней сети запрещен")
+	ErrTooLarge       = errors.New("файл слишком большой")
+	ErrNotImage       = errors.New("файл не является изображением")
+)
+
+// allowedTypes - Content-Type изображений, которые может обработать пайплайн
+// (HEIC/HEIF конвертируются в JPEG перед отправкой в Python)
+var allowedTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+	"image/heic": ".heic",
+	"image/heif": ".heif",
+}
+
+// cgnat - 100.64.0.0/10, адреса провайдерского NAT (net.IP.IsPrivate их не считает)
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// File - скачанное изображение
+type File struct {
+	Name        string // Имя файла из URL (с расширением по Content-Type, если его не было)
+	ContentType string
+	Data        []byte
+}
+
+// Result - итог скачивания одного URL
+type Result struct {
+	URL  string
+	File *File
+	Err  error
+}
+
+// Downloader скачивает изображения по URL с защитой от SSRF
+// Адрес проверяется при установке соединения, поэтому DNS, указывающий
+// на внутреннюю сеть, и редиректы туда тоже блокируются
+type Downloader struct {
+	client       *http.Client
+	allowedHosts map[string]bool
+	allowPrivate bool
+	maxFileSize  int64
+	maxURLs      int
+}
+
+// New создает загрузчик по настройкам URL_UPLOAD_*
+func New(cfg config.URLUploadConfig) *Downloader {
+	allowed := make(map[string]bool, len(cfg.AllowedHosts))
+	for _, host := range cfg.AllowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	d := &Downloader{
+		allowedHosts: allowed,
+		allowPrivate: cfg.AllowPrivate,
+		maxFileSize:  cfg.MaxFileSize,
+		maxURLs:      cfg.MaxURLs,
+	}
+
+	dialer := &net.Dialer{
+		Timeout: cfg.Timeout,
+		Control: d.checkAddress,
+	}
+
+	d.client = &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// Прокси из окружения обошел бы проверку адреса при соединении
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   cfg.Timeout,
+			ResponseHeaderTimeout: cfg.Timeout,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("слишком много редиректов")
+			}
+			return d.ValidateURL(req.URL.String())
+		},
+	}
+
+	return d
+}
+
+// MaxURLs возвращает максимум URL в одном запросе
+func (d *Downloader) MaxURLs() int {
+	return d.maxURLs
+}
+
+// ValidateURL проверяет схему (http/https), хост и список разрешенных хостов
+// IP адреса во внутренней сети отклоняются сразу, доменные имена - при соединении
+func (d *Downloader) ValidateURL(rawURL string) error {
+	if len(rawURL) > MaxURLLength {
+		return fmt.Errorf("URL длиннее %d символов", MaxURLLength)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("неверный URL %q: %w", rawURL, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q должен использовать http или https", rawURL)
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("URL %q должен содержать хост", rawURL)
+	}
+
+	if len(d.allowedHosts) > 0 && !d.allowedHosts[host] {
+		return fmt.Errorf("хост %s не разрешен для загрузки", host)
+	}
+
+	if ip := net.ParseIP(host); ip != nil && !d.allowPrivate && isPrivate(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+
+	return nil
+}
+
+// DownloadAll скачивает все URL (не больше concurrency одновременно)
+// Результаты идут в порядке urls; ошибка одного URL не влияет на остальные
+func (d *Downloader) DownloadAll(urls []string) []Result {
+	results := make([]Result, len(urls))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, rawURL := range urls {
+		wg.Add(1)
+		go func(i int, rawURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			file, err := d.Download(rawURL)
+			results[i] = Result{URL: rawURL, File: file, Err: err}
+		}(i, rawURL)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// Download скачивает одно изображение
+// Проверяются статус ответа, размер и тип: и Content-Type, и сами байты
+// должны быть изображением
+func (d *Downloader) Download(rawURL string) (*File, error) {
+	if err := d.ValidateURL(rawURL); err != nil {
+		return nil, err
+	}
+
+	resp, err := d.client.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка скачивания: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("сервер вернул статус %d", resp.StatusCode)
+	}
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	ext, ok := allowedTypes[contentType]
+	if !ok {
+		return nil, fmt.Errorf("%w: Content-Type %q", ErrNotImage, resp.Header.Get("Content-Type"))
+	}
+
+	if resp.ContentLength > d.maxFileSize {
+		return nil, fmt.Errorf("%w: %d байт (максимум %d)", ErrTooLarge, resp.ContentLength, d.maxFileSize)
+	}
+
+	// Content-Length может отсутствовать или врать - читаем на байт больше лимита
+	data, err := io.ReadAll(io.LimitReader(resp.Body, d.maxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
+	}
+	if int64(len(data)) > d.maxFileSize {
+		return nil, fmt.Errorf("%w: больше %d байт", ErrTooLarge, d.maxFileSize)
+	}
+
+	if !looksLikeImage(data) {
+		return nil, fmt.Errorf("%w: содержимое не совпадает с Content-Type %s", ErrNotImage, contentType)
+	}
+
+	return &File{
+		Name:        fileName(resp.Request.URL, ext),
+		ContentType: contentType,
+		Data:        data,
+	}, nil
+}
+
+// checkAddress вызывается перед каждым соединением (в том числе после редиректа)
+func (d *Downloader) checkAddress(network, address string, _ syscall.RawConn) error {
+	if d.allowPrivate {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || isPrivate(ip) {
+		return fmt.Errorf("%w: %s", ErrPrivateAddress, host)
+	}
+	return nil
+}
+
+// isPrivate - адрес во внутренней, loopback, link-local или служебной сети
+func isPrivate(ip net.IP) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() || cgnat.Contains(ip)
+}
+
+// looksLikeImage проверяет первые байты файла: сервер может отдать HTML с image/jpeg
+func looksLikeImage(data []byte) bool {
+	if storage.IsHEIF(data) {
+		return true
+	}
+	return strings.HasPrefix(http.DetectContentType(data), "image/")
+}
+
+// fileName берет имя файла из пути URL (после редиректов)
+// Если расширения нет, добавляется расширение по Content-Type
+func fileName(u *url.URL, ext string) string {
+	name := path.Base(u.Path)
+	if name == "." || name == "/" || name == ".." {
+		name = "image"
+	}
+	if path.Ext(name) == "" {
+		name += ext
+	}
+	return name
+}
@@ -0,0 +1,202 @@
+package downloader
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"face-recognition/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig() config.URLUploadConfig {
+	return config.URLUploadConfig{
+		MaxURLs:      5,
+		MaxFileSize:  1 << 20,
+		Timeout:      5 * time.Second,
+		AllowPrivate: true, // httptest слушает на 127.0.0.1
+	}
+}
+
+func pngBytes(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4))))
+	return buf.Bytes()
+}
+
+func TestValidateURL(t *testing.T) {
+	d := New(config.URLUploadConfig{MaxURLs: 5, MaxFileSize: 1 << 20, Timeout: time.Second})
+
+	assert.NoError(t, d.ValidateURL("https://images.example.com/a.jpg"))
+	assert.NoError(t, d.ValidateURL("http://93.184.216.34/a.jpg"))
+
+	invalid := []string{
+		"ftp://example.com/a.jpg",
+		"file:///etc/passwd",
+		"https:///a.jpg",
+		"://bad",
+		"https://example.com/" + strings.Repeat("a", MaxURLLength),
+	}
+	for _, rawURL := range invalid {
+		assert.Error(t, d.ValidateURL(rawURL), rawURL)
+	}
+
+	// IP адреса внутренней сети отклоняются до запроса
+	private := []string{
+		"http://127.0.0.1/a.jpg",
+		"http://10.0.0.5/a.jpg",
+		"http://192.168.1.1/a.jpg",
+		"http://169.254.169.254/latest/meta-data",
+		"http://100.64.0.1/a.jpg",
+		"http://0.0.0.0/a.jpg",
+		"http://[::1]/a.jpg",
+		"http://[fd00::1]/a.jpg",
+	}
+	for _, rawURL := range private {
+		assert.ErrorIs(t, d.ValidateURL(rawURL), ErrPrivateAddress, rawURL)
+	}
+}
+
+func TestValidateURLAllowedHosts(t *testing.T) {
+	cfg := testConfig()
+	cfg.AllowedHosts = []string{"CDN.example.com"}
+	d := New(cfg)
+
+	assert.NoError(t, d.ValidateURL("https://cdn.example.com/a.jpg"))
+	assert.Error(t, d.ValidateURL("https://evil.example.com/a.jpg"))
+}
+
+func TestDownload(t *testing.T) {
+	data := pngBytes(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	d := New(testConfig())
+
+	file, err := d.Download(server.URL + "/photos/cat.png")
+	require.NoError(t, err)
+	assert.Equal(t, "cat.png", file.Name)
+	assert.Equal(t, "image/png", file.ContentType)
+	assert.Equal(t, data, file.Data)
+
+	// Без расширения в пути - расширение по Content-Type
+	file, err = d.Download(server.URL + "/photos/12345")
+	require.NoError(t, err)
+	assert.Equal(t, "12345.png", file.Name)
+}
+
+func TestDownloadRejected(t *testing.T) {
+	data := pngBytes(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing.jpg":
+			http.NotFound(w, r)
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/fake.jpg":
+			// HTML, выданный за картинку
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write([]byte("<html><body>not an image</body></html>"))
+		case "/big.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(append(data, make([]byte, 2<<20)...))
+		case "/stream.png":
+			// Без Content-Length - лимит проверяется при чтении
+			w.Header().Set("Content-Type", "image/png")
+			w.(http.Flusher).Flush()
+			w.Write(append(data, make([]byte, 2<<20)...))
+		}
+	}))
+	defer server.Close()
+
+	d := New(testConfig())
+
+	_, err := d.Download(server.URL + "/missing.jpg")
+	assert.Error(t, err)
+
+	_, err = d.Download(server.URL + "/page.html")
+	assert.ErrorIs(t, err, ErrNotImage)
+
+	_, err = d.Download(server.URL + "/fake.jpg")
+	assert.ErrorIs(t, err, ErrNotImage)
+
+	_, err = d.Download(server.URL + "/big.png")
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = d.Download(server.URL + "/stream.png")
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
+
+func TestDownloadBlocksPrivateAddressOnConnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("запрос не должен дойти до сервера во внутренней сети")
+	}))
+	defer server.Close()
+
+	cfg := testConfig()
+	cfg.AllowPrivate = false
+	d := New(cfg)
+
+	// Имя хоста проходит ValidateURL, но резолвится в 127.0.0.1
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	_, port, _ := net.SplitHostPort(u.Host)
+
+	_, err = d.Download("http://localhost:" + port + "/a.png")
+	assert.ErrorIs(t, err, ErrPrivateAddress)
+}
+
+func TestDownloadAll(t *testing.T) {
+	data := pngBytes(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	d := New(testConfig())
+	urls := []string{server.URL + "/a.png", server.URL + "/missing.png", server.URL + "/b.png"}
+
+	results := d.DownloadAll(urls)
+	require.Len(t, results, 3)
+
+	// Порядок результатов совпадает с порядком URL
+	for i, result := range results {
+		assert.Equal(t, urls[i], result.URL)
+	}
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, "a.png", results[0].File.Name)
+	assert.Error(t, results[1].Err)
+	assert.Nil(t, results[1].File)
+	assert.NoError(t, results[2].Err)
+}
+
+func TestFileName(t *testing.T) {
+	parse := func(raw string) *url.URL {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		return u
+	}
+
+	assert.Equal(t, "a.jpg", fileName(parse("https://example.com/x/a.jpg?size=large"), ".png"))
+	assert.Equal(t, "image.jpg", fileName(parse("https://example.com/"), ".jpg"))
+	assert.Equal(t, "image.jpg", fileName(parse("https://example.com"), ".jpg"))
+	assert.Equal(t, "image.jpg", fileName(parse("https://example.com/a/.."), ".jpg"))
+}
@@ -0,0 +1,129 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrQueueFull - в очереди нет места, задача не принята
+var ErrQueueFull = errors.New("очередь обработки переполнена")
+
+// Job - одна единица работы (обработка задачи)
+type Job func()
+
+// Queue - очередь задач с фиксированным числом воркеров
+// Воркеры ограничивают число одновременных тяжелых вызовов Python,
+// остальные задачи ждут в буфере размером size
+type Queue struct {
+	jobs    chan Job
+	slots   chan struct{} // Занятые места в очереди (в том числе зарезервированные)
+	workers int
+	active  atomic.Int32
+}
+
+// Slot - зарезервированное место в очереди (см. Reserve)
+type Slot struct {
+	q    *Queue
+	once sync.Once
+}
+
+// New создает очередь и запускает workers воркеров
+func New(workers, size int) *Queue {
+	if workers < 1 {
+		workers = 1
+	}
+	if size < 1 {
+		size = 1
+	}
+
+	q := &Queue{
+		jobs:    make(chan Job, size),
+		slots:   make(chan struct{}, size),
+		workers: workers,
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+// Submit ставит задачу в очередь и сразу возвращается
+// ErrQueueFull - буфер заполнен, задача не будет выполнена
+func (q *Queue) Submit(job Job) error {
+	slot, err := q.Reserve()
+	if err != nil {
+		return err
+	}
+	slot.Submit(job)
+	return nil
+}
+
+// Reserve занимает место в очереди, не ставя задачу
+// Нужен, когда перед постановкой выполняются необратимые действия:
+// место проверяется до них, а Submit слота уже не может завершиться ошибкой
+// ErrQueueFull - свободных мест нет
+func (q *Queue) Reserve() (*Slot, error) {
+	select {
+	case q.slots <- struct{}{}:
+		return &Slot{q: q}, nil
+	default:
+		return nil, ErrQueueFull
+	}
+}
+
+// Submit ставит задачу на зарезервированное место
+// Повторные вызовы и вызовы после Release ничего не делают
+func (s *Slot) Submit(job Job) {
+	s.once.Do(func() {
+		s.q.jobs <- job
+	})
+}
+
+// Release освобождает место, если задача на него так и не была поставлена
+// Безопасен для nil и после Submit, поэтому подходит для defer
+func (s *Slot) Release() {
+	if s == nil {
+		return
+	}
+	s.once.Do(func() {
+		<-s.q.slots
+	})
+}
+
+// work выполняет задачи из очереди по одной
+func (q *Queue) work() {
+	for job := range q.jobs {
+		<-q.slots // Задача покинула очередь - место свободно
+		q.run(job)
+	}
+}
+
+// run выполняет задачу; паника в задаче не останавливает воркер
+func (q *Queue) run(job Job) {
+	q.active.Add(1)
+	defer q.active.Add(-1)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("❌ Паника в задаче очереди: %v", r)
+		}
+	}()
+
+	job()
+}
+
+// Depth возвращает число задач, ожидающих воркера
+func (q *Queue) Depth() int {
+	return len(q.jobs)
+}
+
+// Active возвращает число выполняемых сейчас задач
+func (q *Queue) Active() int {
+	return int(q.active.Load())
+}
+
+// Workers возвращает число воркеров
+func (q *Queue) Workers() int {
+	return q.workers
+}
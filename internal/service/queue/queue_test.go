@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueBoundsConcurrency(t *testing.T) {
+	q := New(3, 100)
+
+	var running, maxRunning, done atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		require.NoError(t, q.Submit(func() {
+			defer wg.Done()
+			current := running.Add(1)
+			for {
+				seen := maxRunning.Load()
+				if current <= seen || maxRunning.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			done.Add(1)
+		}))
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(20), done.Load())
+	assert.LessOrEqual(t, maxRunning.Load(), int32(3))
+	// Воркеры действительно работали параллельно
+	assert.Equal(t, int32(3), maxRunning.Load())
+}
+
+func TestQueueDepthAndActive(t *testing.T) {
+	q := New(1, 10)
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	require.NoError(t, q.Submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	// Единственный воркер занят - остальные ждут в очереди
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Submit(func() {}))
+	}
+	assert.Equal(t, 1, q.Active())
+	assert.Equal(t, 3, q.Depth())
+	assert.Equal(t, 1, q.Workers())
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		return q.Depth() == 0 && q.Active() == 0
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestQueueFull(t *testing.T) {
+	q := New(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	defer close(release)
+
+	require.NoError(t, q.Submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	require.NoError(t, q.Submit(func() {}))
+	assert.ErrorIs(t, q.Submit(func() {}), ErrQueueFull)
+}
+
+func TestQueueSurvivesPanic(t *testing.T) {
+	q := New(1, 10)
+
+	done := make(chan struct{})
+	require.NoError(t, q.Submit(func() { panic("boom") }))
+	require.NoError(t, q.Submit(func() { close(done) }))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("воркер остановился после паники")
+	}
+}
+
+func TestQueueReserve(t *testing.T) {
+	q := New(1, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	defer close(release)
+
+	require.NoError(t, q.Submit(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+
+	// Зарезервированное место занимает очередь, хотя задачи в ней еще нет
+	slot, err := q.Reserve()
+	require.NoError(t, err)
+	assert.Equal(t, 0, q.Depth())
+	assert.ErrorIs(t, q.Submit(func() {}), ErrQueueFull)
+
+	// Освобожденное место снова доступно, а Release после Release ничего не делает
+	slot.Release()
+	slot.Release()
+	slot, err = q.Reserve()
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	slot.Submit(func() { close(done) })
+	slot.Release() // После Submit место занято задачей
+	assert.Equal(t, 1, q.Depth())
+	assert.ErrorIs(t, q.Submit(func() {}), ErrQueueFull)
+
+	release <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("задача на зарезервированном месте не выполнена")
+	}
+}
@@ -0,0 +1,77 @@
+//go:build cgo && avif
+
+package storage
+
+/*
+#cgo LDFLAGS: -lavif
+#include <avif/avif.h>
+
+// avifEncodeRGBA кодирует пиксели RGBA в AVIF (YUV 4:2:0, libavif >= 1.0)
+// Результат в out освобождается avifRWDataFree
+static avifResult avifEncodeRGBA(uint8_t *pixels, uint32_t width, uint32_t height,
+                                 uint32_t stride, int quality, avifRWData *out) {
+	avifImage *image = avifImageCreate(width, height, 8, AVIF_PIXEL_FORMAT_YUV420);
+	if (image == NULL) {
+		return AVIF_RESULT_OUT_OF_MEMORY;
+	}
+
+	avifRGBImage rgb;
+	avifRGBImageSetDefaults(&rgb, image);
+	rgb.format = AVIF_RGB_FORMAT_RGBA;
+	rgb.depth = 8;
+	rgb.pixels = pixels;
+	rgb.rowBytes = stride;
+
+	avifResult result = avifImageRGBToYUV(image, &rgb);
+	if (result == AVIF_RESULT_OK) {
+		avifEncoder *encoder = avifEncoderCreate();
+		if (encoder == NULL) {
+			result = AVIF_RESULT_OUT_OF_MEMORY;
+		} else {
+			encoder->quality = quality;
+			result = avifEncoderWrite(encoder, image, out);
+			avifEncoderDestroy(encoder);
+		}
+	}
+
+	avifImageDestroy(image);
+	return result;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"unsafe"
+)
+
+// Кодировщик AVIF на libavif: сборка с -tags avif (нужен libavif-dev >= 1.0)
+func init() {
+	imageEncoders[FormatAVIF] = encodeAVIF
+}
+
+// encodeAVIF кодирует изображение в AVIF
+func encodeAVIF(w io.Writer, img image.Image, quality int) error {
+	pixels := toNRGBA(img)
+	bounds := pixels.Bounds()
+	if bounds.Empty() {
+		return errors.New("пустое изображение")
+	}
+
+	var output C.avifRWData
+	result := C.avifEncodeRGBA(
+		(*C.uint8_t)(unsafe.Pointer(&pixels.Pix[0])),
+		C.uint32_t(bounds.Dx()), C.uint32_t(bounds.Dy()), C.uint32_t(pixels.Stride),
+		C.int(quality), &output,
+	)
+	if result != C.AVIF_RESULT_OK {
+		return fmt.Errorf("libavif: %s", C.GoString(C.avifResultToString(result)))
+	}
+	defer C.avifRWDataFree(&output)
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output.data), C.int(output.size)))
+	return err
+}
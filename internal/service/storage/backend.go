@@ -0,0 +1,34 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound возвращается бэкендом, если объект не существует
+var ErrNotFound = errors.New("файл не найден")
+
+// StorageBackend определяет контракт для хранилища файлов
+// Ключи - относительные пути вида "task_id/filename.jpg" с прямыми слэшами,
+// одинаковые для локального диска и S3
+type StorageBackend interface {
+	// Save сохраняет содержимое r под ключом key (size - размер в байтах, -1 если неизвестен)
+	Save(key string, r io.Reader, size int64) error
+	// Open открывает файл для чтения
+	Open(key string) (io.ReadCloser, error)
+	// Delete удаляет файл (отсутствие файла не считается ошибкой)
+	Delete(key string) error
+	// List возвращает отсортированные ключи всех файлов с указанным префиксом
+	List(prefix string) ([]string, error)
+	// DeletePrefix удаляет все файлы с указанным префиксом
+	DeletePrefix(prefix string) error
+	// Exists проверяет существование файла
+	Exists(key string) (bool, error)
+	// Size возвращает размер файла в байтах
+	Size(key string) (int64, error)
+	// ModTime возвращает время последней записи файла
+	ModTime(key string) (time.Time, error)
+	// URL возвращает адрес, по которому файл доступен клиентам
+	URL(key string) string
+}
@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"path"
+	"strings"
+)
+
+// ErrDecode - файл не удалось декодировать (битый или неподдерживаемый HEIC)
+var ErrDecode = errors.New("не удалось декодировать изображение")
+
+// heifBrands - major brand контейнера ISOBMFF (ftyp), которые означают HEIC/HEIF
+var heifBrands = map[string]bool{
+	"heic": true, "heix": true, "heim": true, "heis": true,
+	"hevc": true, "hevx": true, "hevm": true, "hevs": true,
+	"mif1": true, "msf1": true,
+}
+
+// IsHEIF проверяет по заголовку файла, что это HEIC/HEIF
+// Расширению не доверяем: iPhone может отдать HEIC с именем .jpg и наоборот
+func IsHEIF(header []byte) bool {
+	if len(header) < 12 || string(header[4:8]) != "ftyp" {
+		return false
+	}
+	return heifBrands[string(header[8:12])]
+}
+
+// ConvertHEIF заменяет HEIC/HEIF файл key на JPEG, который может прочитать InsightFace
+// Возвращает ключ JPEG и converted = true; остальные файлы не меняются (key, false)
+// Ошибка декодирования оборачивает ErrDecode - исходный файл при этом не удаляется
+func (s *Service) ConvertHEIF(key string) (string, bool, error) {
+	src, err := s.backend.Open(key)
+	if err != nil {
+		return "", false, fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	reader := bufio.NewReader(src)
+	header, _ := reader.Peek(12)
+	if !IsHEIF(header) {
+		return key, false, nil
+	}
+
+	img, err := decodeHEIF(reader)
+	if err != nil {
+		return "", false, fmt.Errorf("%w: %s: %v", ErrDecode, path.Base(key), err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 95}); err != nil {
+		return "", false, fmt.Errorf("ошибка кодирования JPEG: %w", err)
+	}
+
+	dstKey := s.convertedKey(key)
+	if err := s.backend.Save(dstKey, &buf, int64(buf.Len())); err != nil {
+		return "", false, err
+	}
+
+	if err := s.backend.Delete(key); err != nil {
+		return "", false, fmt.Errorf("не удалось удалить %s: %w", key, err)
+	}
+
+	return dstKey, true, nil
+}
+
+// convertedKey возвращает ключ JPEG для HEIC файла ("task_id/IMG_1.HEIC" → "task_id/IMG_1.jpg")
+// Если такой файл уже загружен в задачу, к имени добавляется "_heic"
+func (s *Service) convertedKey(key string) string {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	dstKey := base + ".jpg"
+	if dstKey == key || s.FileExists(dstKey) {
+		dstKey = base + "_heic.jpg"
+	}
+	return dstKey
+}
@@ -0,0 +1,51 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// ErrEmptyCrop - область вырезки не пересекается с изображением
+var ErrEmptyCrop = errors.New("область вырезки вне изображения")
+
+// CropImage вырезает область rect из изображения key и кодирует ее в формат
+// OutputFormat (Content-Type результата - ContentType). Область обрезается по границам изображения
+func (s *Service) CropImage(key string, rect image.Rectangle) ([]byte, error) {
+	src, err := s.Open(key)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось декодировать %s: %w", key, err)
+	}
+
+	rect = rect.Intersect(img.Bounds())
+	if rect.Empty() {
+		return nil, ErrEmptyCrop
+	}
+
+	data, _, err := s.encodeImage(subImage(img, rect), 90)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования вырезки: %w", err)
+	}
+	return data, nil
+}
+
+// subImage возвращает область изображения без копирования, если декодер это позволяет
+// (RGBA, YCbCr, Gray и т.д.), иначе копирует ее в RGBA
+func subImage(img image.Image, rect image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(rect)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, rect.Min, draw.Src)
+	return dst
+}
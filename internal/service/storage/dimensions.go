@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path"
+
+	// Декодеры форматов, которые читает Python: размеры читаются по заголовку
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/webp"
+)
+
+// ErrImageTooLarge - в изображении больше пикселей, чем допустимо (MAX_IMAGE_MEGAPIXELS)
+// Защита от "декомпрессионных бомб": маленький файл, который раскодируется
+// в гигапиксельное изображение и съедает память Go или Python воркера
+var ErrImageTooLarge = errors.New("изображение слишком большое")
+
+// SetMaxPixels задает, сколько пикселей может быть в обрабатываемом изображении
+// (0 - без ограничения)
+func (s *Service) SetMaxPixels(n int64) {
+	s.maxPixels = n
+}
+
+// CheckDimensions проверяет размеры изображения key до его декодирования
+// (см. CheckImageSize). Выполняется перед конвертацией HEIC и отправкой в Python
+func (s *Service) CheckDimensions(key string) error {
+	if s.maxPixels <= 0 {
+		return nil
+	}
+
+	src, err := s.Open(key)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	return CheckImageSize(src, path.Base(key), s.maxPixels)
+}
+
+// CheckImageSize читает размеры изображения из заголовка (image.DecodeConfig, без
+// декодирования пикселей) и возвращает ErrImageTooLarge, если пикселей больше maxPixels
+// Файлы, размеры которых Go прочитать не может (видео, неизвестный формат, битый
+// заголовок), не отклоняются - их проверяют ffmpeg и Python
+func CheckImageSize(r io.Reader, name string, maxPixels int64) error {
+	if maxPixels <= 0 {
+		return nil
+	}
+
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil
+	}
+
+	if pixels := int64(cfg.Width) * int64(cfg.Height); pixels > maxPixels {
+		return fmt.Errorf("%w: %s (%dx%d, %.1f Мп, допустимо %.1f Мп)",
+			ErrImageTooLarge, name, cfg.Width, cfg.Height, float64(pixels)/1e6, float64(maxPixels)/1e6)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+//go:build cgo && (webp || avif)
+
+package storage
+
+import (
+	"image"
+	"image/draw"
+)
+
+// toNRGBA возвращает пиксели изображения в RGBA без премультипликации альфы,
+// начиная с (0, 0) - в таком виде их принимают libwebp и libavif
+func toNRGBA(img image.Image) *image.NRGBA {
+	if nrgba, ok := img.(*image.NRGBA); ok && nrgba.Rect.Min == (image.Point{}) {
+		return nrgba
+	}
+
+	bounds := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, bounds.Min, draw.Src)
+	return dst
+}
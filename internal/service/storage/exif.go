@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// Теги EXIF, нужные для времени съемки
+const (
+	exifTagIFDPointer         = 0x8769 // Смещение Exif IFD внутри IFD0
+	exifTagDateTimeOriginal   = 0x9003 // "2006:01:02 15:04:05" - момент съемки
+	exifTagOffsetTimeOriginal = 0x9011 // "+03:00" - часовой пояс DateTimeOriginal (EXIF 2.31)
+)
+
+// exifDateLayout - формат даты в EXIF
+const exifDateLayout = "2006:01:02 15:04:05"
+
+// errNoExif - в файле нет EXIF или нужного тега
+var errNoExif = errors.New("EXIF не найден")
+
+// CaptureTime возвращает время съемки из EXIF DateTimeOriginal
+// nil - файл не JPEG, EXIF нет или дата не разбирается
+// HEIC после конвертации хранится как JPEG без EXIF, поэтому для него тоже nil
+func (s *Service) CaptureTime(key string) *time.Time {
+	file, err := s.Open(key)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	capturedAt, err := ReadCaptureTime(file)
+	if err != nil {
+		return nil
+	}
+	return &capturedAt
+}
+
+// ReadCaptureTime читает DateTimeOriginal из EXIF (сегмент APP1) JPEG файла
+// Без OffsetTimeOriginal время считается UTC - часовой пояс камеры неизвестен
+func ReadCaptureTime(r io.Reader) (time.Time, error) {
+	tiff, err := readJPEGExif(bufio.NewReader(r))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseExifCaptureTime(tiff)
+}
+
+// readJPEGExif возвращает TIFF данные EXIF из сегмента APP1 JPEG файла
+// Чтение останавливается на начале данных изображения (SOS)
+func readJPEGExif(r *bufio.Reader) ([]byte, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xFF, 0xD8} {
+		return nil, errNoExif
+	}
+
+	for {
+		marker, err := r.ReadByte()
+		if err != nil {
+			return nil, errNoExif
+		}
+		if marker != 0xFF {
+			return nil, errNoExif
+		}
+
+		kind, err := r.ReadByte()
+		for err == nil && kind == 0xFF { // Заполняющие байты перед маркером
+			kind, err = r.ReadByte()
+		}
+		if err != nil || kind == 0xDA || kind == 0xD9 { // SOS или EOI - EXIF уже не будет
+			return nil, errNoExif
+		}
+
+		var size [2]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return nil, errNoExif
+		}
+		length := int(binary.BigEndian.Uint16(size[:])) - 2
+		if length < 0 {
+			return nil, errNoExif
+		}
+
+		if kind != 0xE1 {
+			if _, err := r.Discard(length); err != nil {
+				return nil, errNoExif
+			}
+			continue
+		}
+
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, errNoExif
+		}
+		// APP1 бывает и XMP - нужен именно Exif
+		if bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+// parseExifCaptureTime находит DateTimeOriginal в TIFF структуре EXIF
+func parseExifCaptureTime(tiff []byte) (time.Time, error) {
+	if len(tiff) < 8 {
+		return time.Time{}, errNoExif
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return time.Time{}, errNoExif
+	}
+	if order.Uint16(tiff[2:4]) != 42 {
+		return time.Time{}, errNoExif
+	}
+
+	ifd0 := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	pointer, ok := ifd0[exifTagIFDPointer]
+	if !ok {
+		return time.Time{}, errNoExif
+	}
+
+	exif := readIFD(tiff, order, order.Uint32(pointer.value))
+	original, ok := exif[exifTagDateTimeOriginal]
+	if !ok {
+		return time.Time{}, errNoExif
+	}
+
+	value := original.ascii(tiff, order)
+	if offset, ok := exif[exifTagOffsetTimeOriginal]; ok {
+		if zone := offset.ascii(tiff, order); zone != "" {
+			if t, err := time.Parse(exifDateLayout+"-07:00", value+zone); err == nil {
+				return t, nil
+			}
+		}
+	}
+
+	return time.Parse(exifDateLayout, value)
+}
+
+// ifdEntry - запись IFD: тип, число значений и 4 байта значения (или смещения)
+type ifdEntry struct {
+	kind  uint16
+	count uint32
+	value []byte
+}
+
+// ascii возвращает строковое значение записи (тип ASCII) без завершающих нулей
+func (e ifdEntry) ascii(tiff []byte, order binary.ByteOrder) string {
+	if e.kind != 2 {
+		return ""
+	}
+
+	// Строка до 4 байт хранится в самой записи, длиннее - по смещению
+	data := e.value[:min(e.count, 4)]
+	if e.count > 4 {
+		offset := order.Uint32(e.value)
+		if uint64(offset)+uint64(e.count) > uint64(len(tiff)) {
+			return ""
+		}
+		data = tiff[offset : offset+e.count]
+	}
+	return strings.TrimSpace(strings.TrimRight(string(data), "\x00"))
+}
+
+// readIFD читает записи IFD по смещению offset (битые записи пропускаются)
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) map[uint16]ifdEntry {
+	entries := make(map[uint16]ifdEntry)
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return entries
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	for i := 0; i < count; i++ {
+		pos := start + i*12
+		if pos+12 > len(tiff) {
+			break
+		}
+		entries[order.Uint16(tiff[pos:])] = ifdEntry{
+			kind:  order.Uint16(tiff[pos+2:]),
+			count: order.Uint32(tiff[pos+4:]),
+			value: tiff[pos+8 : pos+12],
+		}
+	}
+	return entries
+}
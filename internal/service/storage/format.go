@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"log"
+)
+
+// Форматы изображений, которые создает Go: миниатюры и вырезки лиц (IMAGE_OUTPUT_FORMAT)
+// Аннотированные фото рисует Python - они всегда JPEG
+const (
+	FormatJPEG = "jpeg"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// ErrUnknownFormat - формат не из FormatJPEG, FormatWebP, FormatAVIF
+var ErrUnknownFormat = errors.New("неизвестный формат изображений")
+
+// ErrEncoderUnavailable - сервер собран без кодировщика формата (см. imageEncoders)
+var ErrEncoderUnavailable = errors.New("сервер собран без кодировщика формата")
+
+// ImageFormat - формат кодирования изображений
+type ImageFormat struct {
+	Name        string
+	ContentType string
+	Ext         string // Расширение ключа файла (с точкой)
+}
+
+// imageFormats - все поддерживаемые форматы (кодировщик есть не для всех)
+var imageFormats = map[string]ImageFormat{
+	FormatJPEG: {Name: FormatJPEG, ContentType: "image/jpeg", Ext: ".jpg"},
+	FormatWebP: {Name: FormatWebP, ContentType: "image/webp", Ext: ".webp"},
+	FormatAVIF: {Name: FormatAVIF, ContentType: "image/avif", Ext: ".avif"},
+}
+
+// imageEncoder кодирует изображение; quality - от 1 до 100
+type imageEncoder func(w io.Writer, img image.Image, quality int) error
+
+// imageEncoders - кодировщики, собранные в сервер. JPEG есть всегда, WebP и AVIF
+// добавляются сборкой с тегами webp и avif (нужны cgo и libwebp/libavif, см. webp.go, avif.go)
+var imageEncoders = map[string]imageEncoder{
+	FormatJPEG: func(w io.Writer, img image.Image, quality int) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	},
+}
+
+// SetOutputFormat задает формат миниатюр и вырезок лиц (по умолчанию JPEG)
+// Если кодировщик формата не собран, остается JPEG и возвращается ErrEncoderUnavailable
+func (s *Service) SetOutputFormat(name string) error {
+	format, ok := imageFormats[name]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownFormat, name)
+	}
+	if _, ok := imageEncoders[name]; !ok {
+		s.format = imageFormats[FormatJPEG]
+		return fmt.Errorf("%w %s", ErrEncoderUnavailable, name)
+	}
+	s.format = format
+	return nil
+}
+
+// OutputFormat возвращает формат миниатюр и вырезок лиц
+func (s *Service) OutputFormat() ImageFormat {
+	return s.format
+}
+
+// encodeImage кодирует изображение в формат сервиса и возвращает формат результата
+// Если кодировщик WebP/AVIF вернул ошибку, изображение кодируется в JPEG
+func (s *Service) encodeImage(img image.Image, quality int) ([]byte, ImageFormat, error) {
+	var buf bytes.Buffer
+	err := imageEncoders[s.format.Name](&buf, img, quality)
+	if err == nil {
+		return buf.Bytes(), s.format, nil
+	}
+	if s.format.Name == FormatJPEG {
+		return nil, s.format, err
+	}
+
+	log.Printf("⚠️  Не удалось закодировать изображение в %s, используем JPEG: %v", s.format.Name, err)
+	fallback := imageFormats[FormatJPEG]
+	buf.Reset()
+	if err := imageEncoders[FormatJPEG](&buf, img, quality); err != nil {
+		return nil, fallback, err
+	}
+	return buf.Bytes(), fallback, nil
+}
+
+// ContentType определяет Content-Type закодированного изображения по сигнатуре
+// (вырезки в кэше могли быть закодированы до смены IMAGE_OUTPUT_FORMAT)
+func ContentType(data []byte) string {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return imageFormats[FormatWebP].ContentType
+	case len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis"):
+		return imageFormats[FormatAVIF].ContentType
+	default:
+		return imageFormats[FormatJPEG].ContentType
+	}
+}
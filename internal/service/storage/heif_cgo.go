@@ -0,0 +1,31 @@
+//go:build cgo
+
+package storage
+
+import (
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/jdeng/goheif"
+)
+
+// HEIFSupported - сборка умеет декодировать HEIC (декодер libde265 требует cgo)
+const HEIFSupported = true
+
+// Без SafeEncoding декодер отдает изображение поверх памяти libde265,
+// которая освобождается вместе с декодером - кодирование в JPEG падает с SIGSEGV
+func init() {
+	goheif.SafeEncoding = true
+}
+
+// decodeHEIF декодирует HEIC; паника парсера на битом файле превращается в ошибку,
+// чтобы один файл не ронял сервер
+func decodeHEIF(r io.Reader) (img image.Image, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("паника декодера: %v", p)
+		}
+	}()
+	return goheif.Decode(r)
+}
@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package storage
+
+import (
+	"errors"
+	"image"
+	"io"
+)
+
+// HEIFSupported - сборка без cgo не умеет декодировать HEIC
+const HEIFSupported = false
+
+// decodeHEIF без cgo недоступен - файл помечается ошибкой декодирования
+func decodeHEIF(io.Reader) (image.Image, error) {
+	return nil, errors.New("сервер собран без cgo, HEIC не поддерживается")
+}
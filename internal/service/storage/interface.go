@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"context"
+	"image"
+	"io"
+	"mime/multipart"
+	"time"
+)
+
+// ServiceInterface определяет операции с файлами задач, которые нужны обработчикам
+// Обработчики зависят от интерфейса, а не от конкретного бэкенда (диск или S3)
+type ServiceInterface interface {
+	SaveUploadedFiles(files []*multipart.FileHeader) (string, []string, error)
+	SaveFile(taskID, filename string, r io.Reader, size int64) (string, error)
+	Open(key string) (io.ReadCloser, error)
+	DeleteFiles(keys []string) error
+	DeleteTaskDirectory(taskID string) error
+	ListTaskFiles(taskID string) ([]FileInfo, error)
+	GetUploadPath(taskID, filename string) string
+	GetResultPath(taskID, filename string) string
+	URL(key string) string
+	FileExists(key string) bool
+	GetFileSize(key string) (int64, error)
+	ListAllFiles() ([]string, error)
+	FileModTime(key string) (time.Time, error)
+	GenerateThumbnail(srcKey string, size int) (string, error)
+	CropImage(key string, rect image.Rectangle) ([]byte, error)
+	ComposeSprite(faces []SpriteFace, cols, size int) (*Sprite, error)
+	FaceQualities(key string, rects []image.Rectangle) ([]float64, error)
+	ValidateImage(key string) error
+	CheckDimensions(key string) error
+	ConvertHEIF(key string) (string, bool, error)
+	ExtractFrames(ctx context.Context, key string) ([]VideoFrame, bool, error)
+	CaptureTime(key string) *time.Time
+}
+
+// Проверяем что Service реализует ServiceInterface
+var _ ServiceInterface = (*Service)(nil)
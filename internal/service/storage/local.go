@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LocalBackend хранит файлы на локальном диске
+type LocalBackend struct {
+	root    string
+	baseURL string
+}
+
+// NewLocalBackend создает файловый бэкенд с корнем в rootDir
+// baseURL - префикс, по которому Go раздает файлы (например "/uploads")
+func NewLocalBackend(rootDir, baseURL string) (*LocalBackend, error) {
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, fmt.Errorf("не удалось создать %s: %w", rootDir, err)
+	}
+
+	return &LocalBackend{
+		root:    rootDir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// Root возвращает корневую папку хранилища
+func (b *LocalBackend) Root() string {
+	return b.root
+}
+
+// Save сохраняет файл на диск
+func (b *LocalBackend) Save(key string, r io.Reader, size int64) error {
+	destPath := b.path(key)
+
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("не удалось создать папку для %s: %w", key, err)
+	}
+
+	destFile, err := os.Create(destPath)
+	if err != nil {
+		return fmt.Errorf("не удалось создать файл %s: %w", destPath, err)
+	}
+	defer destFile.Close()
+
+	if _, err := io.Copy(destFile, r); err != nil {
+		return fmt.Errorf("ошибка записи файла %s: %w", destPath, err)
+	}
+
+	return nil
+}
+
+// Open открывает файл с диска
+func (b *LocalBackend) Open(key string) (io.ReadCloser, error) {
+	file, err := os.Open(b.path(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete удаляет файл с диска
+func (b *LocalBackend) Delete(key string) error {
+	if err := os.Remove(b.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List возвращает ключи всех файлов внутри папки prefix
+func (b *LocalBackend) List(prefix string) ([]string, error) {
+	var keys []string
+
+	err := filepath.WalkDir(b.path(prefix), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// DeletePrefix удаляет папку (или файл) с указанным префиксом
+func (b *LocalBackend) DeletePrefix(prefix string) error {
+	return os.RemoveAll(b.path(prefix))
+}
+
+// Exists проверяет существование файла
+func (b *LocalBackend) Exists(key string) (bool, error) {
+	_, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Size возвращает размер файла в байтах
+func (b *LocalBackend) Size(key string) (int64, error) {
+	info, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// ModTime возвращает время последнего изменения файла
+func (b *LocalBackend) ModTime(key string) (time.Time, error) {
+	info, err := os.Stat(b.path(key))
+	if os.IsNotExist(err) {
+		return time.Time{}, ErrNotFound
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// URL возвращает адрес файла на статическом роуте Go сервера
+func (b *LocalBackend) URL(key string) string {
+	return b.baseURL + "/" + cleanKey(key)
+}
+
+// path переводит ключ в путь на диске, не выпуская его за пределы root
+func (b *LocalBackend) path(key string) string {
+	return filepath.Join(b.root, filepath.FromSlash(cleanKey(key)))
+}
+
+// cleanKey нормализует ключ и отрезает попытки выйти выше корня ("../")
+func cleanKey(key string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(key)), "/")
+}
@@ -0,0 +1,89 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Стратегии имен сохраняемых загрузок (STORAGE_FILE_NAMING)
+// uuid и hash дают короткие ASCII имена без коллизий - удобно для S3 и файловых
+// систем с ограничениями на кодировку; исходное имя хранится в БД для показа
+const (
+	NamingOriginal = "original" // Имя файла от клиента
+	NamingUUID     = "uuid"     // UUID из ID задачи и имени файла: то же имя в той же задаче - тот же ключ
+	NamingHash     = "hash"     // SHA-256 содержимого: одинаковые файлы задачи - один ключ
+)
+
+// ErrUnknownNaming - стратегия имен не из NamingOriginal, NamingUUID, NamingHash
+var ErrUnknownNaming = errors.New("неизвестная стратегия имен файлов")
+
+// maxExtLength - расширения длиннее (вместе с точкой) в uuid/hash имена не переносятся
+const maxExtLength = 10
+
+// SetFileNaming задает, под какими именами сохраняются загрузки (по умолчанию original)
+func (s *Service) SetFileNaming(naming string) error {
+	switch naming {
+	case NamingOriginal, NamingUUID, NamingHash:
+		s.naming = naming
+		return nil
+	default:
+		return fmt.Errorf("%w: %q", ErrUnknownNaming, naming)
+	}
+}
+
+// FileNaming возвращает стратегию имен загрузок
+func (s *Service) FileNaming() string {
+	if s.naming == "" {
+		return NamingOriginal
+	}
+	return s.naming
+}
+
+// uploadKey возвращает ключ, под которым файл filename сохраняется в папку задачи
+// Содержимое (open) читается только для стратегии hash
+func (s *Service) uploadKey(taskID, filename string, open func() (io.ReadCloser, error)) (string, error) {
+	name := filepath.Base(filename)
+
+	switch s.FileNaming() {
+	case NamingUUID:
+		id := uuid.NewSHA1(uuid.NameSpaceURL, []byte(path.Join(taskID, name)))
+		return path.Join(taskID, id.String()+safeExt(name)), nil
+	case NamingHash:
+		src, err := open()
+		if err != nil {
+			return "", fmt.Errorf("не удалось открыть файл %s: %w", name, err)
+		}
+		defer src.Close()
+
+		hash := sha256.New()
+		if _, err := io.Copy(hash, src); err != nil {
+			return "", fmt.Errorf("не удалось прочитать файл %s: %w", name, err)
+		}
+		return path.Join(taskID, hex.EncodeToString(hash.Sum(nil))+safeExt(name)), nil
+	default:
+		return s.GetUploadPath(taskID, name), nil
+	}
+}
+
+// safeExt возвращает расширение имени в нижнем регистре, если оно из латиницы и цифр,
+// иначе "" (формат файла все равно определяется по содержимому)
+func safeExt(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if len(ext) < 2 || len(ext) > maxExtLength {
+		return ""
+	}
+	for _, r := range ext[1:] {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return ""
+		}
+	}
+	return ext
+}
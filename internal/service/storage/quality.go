@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"fmt"
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// qualitySize - сторона квадрата, к которому приводится лицо перед оценкой резкости:
+// так оценки лиц разного размера сравнимы, а мелкое лицо при увеличении размывается
+// и получает низкую оценку
+const qualitySize = 112
+
+// qualityScale - дисперсия лапласиана, которой соответствует оценка 0.5
+// (обычный порог "размытое/резкое" для фото)
+const qualityScale = 100
+
+// FaceQualities оценивает резкость областей rects изображения key (см. Sharpness)
+// Изображение декодируется один раз для всех лиц
+func (s *Service) FaceQualities(key string, rects []image.Rectangle) ([]float64, error) {
+	src, err := s.Open(key)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось декодировать %s: %w", key, err)
+	}
+
+	qualities := make([]float64, len(rects))
+	for i, rect := range rects {
+		qualities[i] = Sharpness(img, rect)
+	}
+	return qualities, nil
+}
+
+// Sharpness оценивает резкость области rect в [0, 1): дисперсия лапласиана яркости
+// области, приведенной к qualitySize x qualitySize, нормированная как v / (v + qualityScale)
+// Область вне изображения - 0
+func Sharpness(img image.Image, rect image.Rectangle) float64 {
+	rect = rect.Intersect(img.Bounds())
+	if rect.Empty() {
+		return 0
+	}
+
+	gray := image.NewGray(image.Rect(0, 0, qualitySize, qualitySize))
+	draw.ApproxBiLinear.Scale(gray, gray.Bounds(), img, rect, draw.Src, nil)
+
+	// Лапласиан по 4 соседям во внутренних точках
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < qualitySize-1; y++ {
+		for x := 1; x < qualitySize-1; x++ {
+			center := float64(gray.GrayAt(x, y).Y)
+			laplacian := float64(gray.GrayAt(x-1, y).Y) + float64(gray.GrayAt(x+1, y).Y) +
+				float64(gray.GrayAt(x, y-1).Y) + float64(gray.GrayAt(x, y+1).Y) - 4*center
+			sum += laplacian
+			sumSq += laplacian * laplacian
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	variance := sumSq/float64(n) - mean*mean
+	return variance / (variance + qualityScale)
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Options - параметры подключения к S3-совместимому хранилищу (AWS S3, MinIO)
+type S3Options struct {
+	Endpoint  string // host:port без схемы
+	AccessKey string
+	SecretKey string
+	Bucket    string
+	Region    string
+	UseSSL    bool
+	PublicURL string // Базовый адрес для ссылок на файлы (если пусто - endpoint/bucket)
+}
+
+// S3Backend хранит файлы в S3-совместимом бакете
+type S3Backend struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+// NewS3Backend создает S3 бэкенд и проверяет существование бакета
+func NewS3Backend(opts S3Options) (*S3Backend, error) {
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(opts.AccessKey, opts.SecretKey, ""),
+		Secure:       opts.UseSSL,
+		Region:       opts.Region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("не удалось создать S3 клиент: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), opts.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось проверить бакет %s: %w", opts.Bucket, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("бакет %s не существует", opts.Bucket)
+	}
+
+	publicURL := strings.TrimRight(opts.PublicURL, "/")
+	if publicURL == "" {
+		publicURL = strings.TrimRight(client.EndpointURL().String(), "/") + "/" + opts.Bucket
+	}
+
+	return &S3Backend{
+		client:    client,
+		bucket:    opts.Bucket,
+		publicURL: publicURL,
+	}, nil
+}
+
+// Save загружает объект в бакет
+// Content-Type задается по расширению ключа, чтобы WebP и AVIF миниатюры отдавались
+// браузеру с верным типом (неизвестное расширение - тип по умолчанию minio)
+func (b *S3Backend) Save(key string, r io.Reader, size int64) error {
+	opts := minio.PutObjectOptions{ContentType: mime.TypeByExtension(path.Ext(key))}
+	_, err := b.client.PutObject(context.Background(), b.bucket, cleanKey(key), r, size, opts)
+	if err != nil {
+		return fmt.Errorf("ошибка загрузки %s в S3: %w", key, err)
+	}
+	return nil
+}
+
+// Open открывает объект для чтения
+func (b *S3Backend) Open(key string) (io.ReadCloser, error) {
+	// GetObject ленивый - проверяем существование заранее, чтобы вернуть ErrNotFound
+	if _, err := b.stat(key); err != nil {
+		return nil, err
+	}
+	return b.client.GetObject(context.Background(), b.bucket, cleanKey(key), minio.GetObjectOptions{})
+}
+
+// Delete удаляет объект (S3 не возвращает ошибку для отсутствующих ключей)
+func (b *S3Backend) Delete(key string) error {
+	return b.client.RemoveObject(context.Background(), b.bucket, cleanKey(key), minio.RemoveObjectOptions{})
+}
+
+// List возвращает ключи всех объектов с указанным префиксом
+// S3 отдает ключи в лексикографическом порядке
+func (b *S3Backend) List(prefix string) ([]string, error) {
+	var keys []string
+
+	objects := b.client.ListObjects(context.Background(), b.bucket, minio.ListObjectsOptions{
+		Prefix:    cleanKey(prefix),
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return nil, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+
+	return keys, nil
+}
+
+// DeletePrefix удаляет все объекты с указанным префиксом
+func (b *S3Backend) DeletePrefix(prefix string) error {
+	ctx := context.Background()
+
+	objects := b.client.ListObjects(ctx, b.bucket, minio.ListObjectsOptions{
+		Prefix:    cleanKey(prefix),
+		Recursive: true,
+	})
+
+	for object := range objects {
+		if object.Err != nil {
+			return object.Err
+		}
+		if err := b.client.RemoveObject(ctx, b.bucket, object.Key, minio.RemoveObjectOptions{}); err != nil {
+			return fmt.Errorf("не удалось удалить %s: %w", object.Key, err)
+		}
+	}
+
+	return nil
+}
+
+// Exists проверяет существование объекта
+func (b *S3Backend) Exists(key string) (bool, error) {
+	_, err := b.stat(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Size возвращает размер объекта в байтах
+func (b *S3Backend) Size(key string) (int64, error) {
+	info, err := b.stat(key)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size, nil
+}
+
+// ModTime возвращает время загрузки объекта
+func (b *S3Backend) ModTime(key string) (time.Time, error) {
+	info, err := b.stat(key)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.LastModified, nil
+}
+
+// URL возвращает публичный адрес объекта
+func (b *S3Backend) URL(key string) string {
+	return b.publicURL + "/" + cleanKey(key)
+}
+
+// stat получает метаданные объекта, переводя NoSuchKey в ErrNotFound
+func (b *S3Backend) stat(key string) (minio.ObjectInfo, error) {
+	info, err := b.client.StatObject(context.Background(), b.bucket, cleanKey(key), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return info, ErrNotFound
+		}
+		return info, err
+	}
+	return info, nil
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+
+	"golang.org/x/image/draw"
+)
+
+// ErrEmptySprite - ни одно лицо не удалось поместить в спрайт
+var ErrEmptySprite = errors.New("нет лиц для спрайта")
+
+// SpriteFace - лицо для спрайта: исходное изображение и область лица на нем
+type SpriteFace struct {
+	Key  string
+	Rect image.Rectangle
+}
+
+// Sprite - собранный спрайт: изображение в формате OutputFormat и номера лиц
+// из входного списка по порядку ячеек (слева направо, сверху вниз)
+type Sprite struct {
+	Data   []byte
+	Format ImageFormat
+	Width  int
+	Height int
+	Placed []int // Placed[i] - номер лица в ячейке i; лица, которые не удалось вырезать, пропущены
+}
+
+// spriteBackground - цвет пустых полей ячеек (лицо у края фото не квадратное)
+var spriteBackground = color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+// ComposeSprite собирает вырезки лиц в одну сетку cols колонок с ячейками size x size
+// Область лица расширяется до квадрата и вписывается в ячейку по центру. Каждое исходное
+// изображение декодируется один раз и сразу освобождается, поэтому в памяти только
+// одно фото и готовые ячейки. Лица без файла или вне изображения пропускаются
+func (s *Service) ComposeSprite(faces []SpriteFace, cols, size int) (*Sprite, error) {
+	if cols <= 0 || size <= 0 {
+		return nil, fmt.Errorf("неверная сетка спрайта: %d колонок по %d px", cols, size)
+	}
+
+	// Лица одного фото вырезаются за одно декодирование
+	byKey := make(map[string][]int)
+	var keys []string
+	for i, face := range faces {
+		if _, ok := byKey[face.Key]; !ok {
+			keys = append(keys, face.Key)
+		}
+		byKey[face.Key] = append(byKey[face.Key], i)
+	}
+
+	tiles := make([]image.Image, len(faces))
+	for _, key := range keys {
+		img, err := s.decode(key)
+		if err != nil {
+			log.Printf("⚠️  Спрайт: пропускаем лица из %s: %v", key, err)
+			continue
+		}
+		for _, i := range byKey[key] {
+			rect := squareRect(faces[i].Rect).Intersect(img.Bounds())
+			if rect.Empty() {
+				continue
+			}
+			tiles[i] = spriteTile(subImage(img, rect), size)
+		}
+	}
+
+	sprite := &Sprite{}
+	for i, tile := range tiles {
+		if tile != nil {
+			sprite.Placed = append(sprite.Placed, i)
+		}
+	}
+	if len(sprite.Placed) == 0 {
+		return nil, ErrEmptySprite
+	}
+
+	sprite.Width = min(len(sprite.Placed), cols) * size
+	sprite.Height = (len(sprite.Placed) + cols - 1) / cols * size
+	canvas := image.NewRGBA(image.Rect(0, 0, sprite.Width, sprite.Height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(spriteBackground), image.Point{}, draw.Src)
+
+	for cell, i := range sprite.Placed {
+		at := SpriteCell(cell, cols, size)
+		draw.Draw(canvas, image.Rect(at.X, at.Y, at.X+size, at.Y+size), tiles[i], image.Point{}, draw.Src)
+	}
+
+	data, format, err := s.encodeImage(canvas, 85)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка кодирования спрайта: %w", err)
+	}
+	sprite.Data, sprite.Format = data, format
+	return sprite, nil
+}
+
+// SpriteCell возвращает левый верхний угол ячейки cell в сетке cols колонок
+func SpriteCell(cell, cols, size int) image.Point {
+	return image.Pt(cell%cols*size, cell/cols*size)
+}
+
+// decode открывает и декодирует изображение из хранилища
+func (s *Service) decode(key string) (image.Image, error) {
+	src, err := s.Open(key)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return nil, fmt.Errorf("не удалось декодировать %s: %w", key, err)
+	}
+	return img, nil
+}
+
+// squareRect расширяет область до квадрата по большей стороне с тем же центром
+func squareRect(rect image.Rectangle) image.Rectangle {
+	side := max(rect.Dx(), rect.Dy())
+	x := rect.Min.X - (side-rect.Dx())/2
+	y := rect.Min.Y - (side-rect.Dy())/2
+	return image.Rect(x, y, x+side, y+side)
+}
+
+// spriteTile масштабирует вырезку так, чтобы она вписалась в ячейку size x size
+// (маленькие лица увеличиваются), и размещает ее по центру ячейки
+func spriteTile(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := size, size
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, bounds.Dy()*size/bounds.Dx())
+	} else {
+		width = max(1, bounds.Dx()*size/bounds.Dy())
+	}
+
+	tile := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(tile, tile.Bounds(), image.NewUniform(spriteBackground), image.Point{}, draw.Src)
+	x, y := (size-width)/2, (size-height)/2
+	draw.CatmullRom.Scale(tile, image.Rect(x, y, x+width, y+height), img, bounds, draw.Src, nil)
+	return tile
+}
@@ -1,122 +1,352 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"mime/multipart"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
-// Service управляет файловым хранилищем
+// annotatedSuffix - окончание имени аннотированных фото, которые пишет Python
+const annotatedSuffix = "_boxed.jpg"
+
+// resultsPrefix - префикс ключей результатов обработки (аннотированных фото)
+// Python пишет их на диск в RESULTS_DIR, отдельно от загрузок, при любом бэкенде
+// загрузок; ключ "results/task_id/file" - файл RESULTS_DIR/task_id/file
+const resultsPrefix = "results/"
+
+// defaultResultsURL - префикс, по которому Go раздает RESULTS_DIR (см. SetResultsURL)
+const defaultResultsURL = "/results"
+
+// ErrCleanupUnsupported - очистка старых задач не поддерживается бэкендом
+// (для S3 используй lifecycle-правила бакета)
+var ErrCleanupUnsupported = errors.New("очистка старых задач поддерживается только для локального хранилища")
+
+// Service управляет файлами задач поверх StorageBackend
+// (локальный диск или S3 - выбирается конфигурацией)
 type Service struct {
-	uploadsDir string
-	resultsDir string
+	backend           StorageBackend
+	results           *LocalBackend // Результаты обработки в RESULTS_DIR (ключи с resultsPrefix)
+	uploadConcurrency int           // Сколько файлов одной загрузки сохраняется параллельно
+	format            ImageFormat   // Формат миниатюр и вырезок лиц
+	maxPixels         int64         // Изображения больше отклоняются до декодирования (0 - без ограничения)
+	naming            string        // Имена сохраняемых загрузок (NamingOriginal, NamingUUID, NamingHash)
+
+	frames FrameExtractor // Извлечение кадров видео (nil - видео не обрабатываются)
+	video  VideoOptions
 }
 
 // NewService создает новый файловый сервис
-func NewService(uploadsDir, resultsDir string) (*Service, error) {
-	// Создаем директории если их нет
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		return nil, fmt.Errorf("не удалось создать uploads: %w", err)
-	}
-
-	if err := os.MkdirAll(resultsDir, 0755); err != nil {
+// Результаты обработки лежат в resultsDir и раздаются по /results (см. SetResultsURL)
+func NewService(backend StorageBackend, resultsDir string) (*Service, error) {
+	// Создаем директорию результатов если ее нет
+	results, err := NewLocalBackend(resultsDir, defaultResultsURL)
+	if err != nil {
 		return nil, fmt.Errorf("не удалось создать results: %w", err)
 	}
 
 	return &Service{
-		uploadsDir: uploadsDir,
-		resultsDir: resultsDir,
+		backend:           backend,
+		results:           results,
+		uploadConcurrency: 1,
+		format:            imageFormats[FormatJPEG],
 	}, nil
 }
 
+// SetUploadConcurrency задает, сколько файлов одной загрузки сохраняется параллельно
+// Значения меньше 1 означают последовательное сохранение
+func (s *Service) SetUploadConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	s.uploadConcurrency = n
+}
+
+// SetResultsURL задает префикс, по которому Go раздает RESULTS_DIR (RESULTS_URL)
+func (s *Service) SetResultsURL(baseURL string) {
+	s.results.baseURL = strings.TrimRight(baseURL, "/")
+}
+
+// backendFor возвращает бэкенд, в котором лежит файл key, и ключ файла в нем:
+// результаты обработки - в RESULTS_DIR, остальное - в бэкенде загрузок
+func (s *Service) backendFor(key string) (StorageBackend, string) {
+	if rest, ok := strings.CutPrefix(key, resultsPrefix); ok && s.results != nil {
+		return s.results, rest
+	}
+	return s.backend, key
+}
+
+// Backend возвращает используемый бэкенд хранилища
+func (s *Service) Backend() StorageBackend {
+	return s.backend
+}
+
 // SaveUploadedFiles сохраняет загруженные файлы
-// Возвращает taskID и список путей к сохраненным файлам
+// Возвращает taskID и список ключей сохраненных файлов ("task_id/filename",
+// имя - по стратегии FileNaming) в порядке files. Файлы сохраняются параллельно, не больше uploadConcurrency
+// одновременно. При ошибке новые файлы не сохраняются, а папка задачи с уже
+// записанными файлами удаляется
 func (s *Service) SaveUploadedFiles(files []*multipart.FileHeader) (string, []string, error) {
 	// Генерируем уникальный ID задачи
-	taskID := uuid.New().String()
-	taskDir := filepath.Join(s.uploadsDir, taskID)
+	taskID := NewTaskID()
 
-	// Создаем папку для задачи
-	if err := os.MkdirAll(taskDir, 0755); err != nil {
-		return "", nil, fmt.Errorf("не удалось создать папку задачи: %w", err)
+	// Файлы с одинаковым именем попадают в один ключ: сохраняем только последний,
+	// как при последовательной записи, чтобы параллельные записи не смешались
+	savedFiles := make([]string, len(files))
+	last := make(map[string]int, len(files))
+	for i, fileHeader := range files {
+		key, err := s.uploadKey(taskID, fileHeader.Filename, func() (io.ReadCloser, error) { return fileHeader.Open() })
+		if err != nil {
+			return "", nil, err
+		}
+		savedFiles[i] = key
+		last[key] = i
 	}
 
-	var savedFiles []string
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, s.uploadConcurrency)
+
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
 
 	// Сохраняем каждый файл
-	for _, fileHeader := range files {
-		// Открываем загруженный файл
-		file, err := fileHeader.Open()
-		if err != nil {
-			return "", nil, fmt.Errorf("не удалось открыть файл %s: %w", fileHeader.Filename, err)
+	for i, fileHeader := range files {
+		if last[savedFiles[i]] != i {
+			continue
+		}
+
+		sem <- struct{}{}
+		if failed() {
+			<-sem
+			break
 		}
-		defer file.Close()
 
-		// Путь для сохранения
-		destPath := filepath.Join(taskDir, fileHeader.Filename)
+		wg.Add(1)
+		go func(i int, fileHeader *multipart.FileHeader) {
+			defer wg.Done()
+			defer func() { <-sem }()
 
-		// Создаем файл на диске
-		destFile, err := os.Create(destPath)
-		if err != nil {
-			return "", nil, fmt.Errorf("не удалось создать файл %s: %w", destPath, err)
+			if err := s.saveFile(savedFiles[i], fileHeader); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(i, fileHeader)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		if err := s.DeleteTaskDirectory(taskID); err != nil {
+			return "", nil, fmt.Errorf("%w (не удалось удалить уже сохраненные файлы: %v)", firstErr, err)
 		}
-		defer destFile.Close()
+		return "", nil, firstErr
+	}
 
-		// Копируем содержимое
-		if _, err := destFile.ReadFrom(file); err != nil {
-			return "", nil, fmt.Errorf("ошибка записи файла %s: %w", destPath, err)
+	return taskID, savedFiles, nil
+}
+
+// NewTaskID генерирует уникальный ID задачи (он же папка файлов задачи)
+func NewTaskID() string {
+	return uuid.New().String()
+}
+
+// SaveFile сохраняет файл в папку задачи (например, скачанный по URL)
+// Возвращает ключ сохраненного файла ("task_id/filename", имя - по стратегии FileNaming)
+func (s *Service) SaveFile(taskID, filename string, r io.Reader, size int64) (string, error) {
+	var data []byte
+	if s.FileNaming() == NamingHash {
+		// Имя зависит от содержимого - читаем его до сохранения
+		var err error
+		if data, err = io.ReadAll(r); err != nil {
+			return "", err
 		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
 
-		savedFiles = append(savedFiles, destPath)
+	key, err := s.uploadKey(taskID, filename, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := s.backend.Save(key, r, size); err != nil {
+		return "", err
 	}
+	return key, nil
+}
 
-	return taskID, savedFiles, nil
+// saveFile сохраняет один загруженный файл в бэкенд
+func (s *Service) saveFile(key string, fileHeader *multipart.FileHeader) error {
+	file, err := fileHeader.Open()
+	if err != nil {
+		return fmt.Errorf("не удалось открыть файл %s: %w", fileHeader.Filename, err)
+	}
+	defer file.Close()
+
+	return s.backend.Save(key, file, fileHeader.Size)
+}
+
+// Open открывает сохраненный файл для чтения
+func (s *Service) Open(key string) (io.ReadCloser, error) {
+	backend, key := s.backendFor(key)
+	return backend.Open(key)
 }
 
-// DeleteFiles удаляет файлы по путям
-func (s *Service) DeleteFiles(paths []string) error {
-	for _, path := range paths {
-		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("не удалось удалить %s: %w", path, err)
+// DeleteFiles удаляет файлы по ключам
+func (s *Service) DeleteFiles(keys []string) error {
+	for _, key := range keys {
+		backend, backendKey := s.backendFor(key)
+		if err := backend.Delete(backendKey); err != nil {
+			return fmt.Errorf("не удалось удалить %s: %w", key, err)
 		}
 	}
 	return nil
 }
 
-// DeleteTaskDirectory удаляет всю папку задачи
+// DeleteTaskDirectory удаляет все файлы задачи, включая ее результаты обработки
 func (s *Service) DeleteTaskDirectory(taskID string) error {
-	taskDir := filepath.Join(s.uploadsDir, taskID)
-	return os.RemoveAll(taskDir)
+	if err := s.backend.DeletePrefix(taskID + "/"); err != nil {
+		return err
+	}
+	if s.results == nil {
+		return nil
+	}
+	return s.results.DeletePrefix(taskID + "/")
+}
+
+// FileInfo - загруженный файл задачи
+type FileInfo struct {
+	Key  string // Ключ в хранилище ("task_id/filename")
+	Name string // Имя файла
+	Size int64  // Размер в байтах
+	URL  string // Адрес, по которому файл доступен клиентам
+}
+
+// ListTaskFiles возвращает загруженные оригиналы задачи с размерами и адресами
+// Аннотированные фото (Python пишет их в ту же папку как <face_id>_boxed.jpg)
+// и кадры видео в список не попадают. Файл, удаленный между List и GetFileSize (HEIC после
+// конвертации в JPEG), пропускается
+func (s *Service) ListTaskFiles(taskID string) ([]FileInfo, error) {
+	keys, err := s.backend.List(taskID + "/")
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, annotatedSuffix) || IsVideoFrame(key) {
+			continue
+		}
+
+		size, err := s.GetFileSize(key)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("не удалось получить размер %s: %w", key, err)
+		}
+
+		files = append(files, FileInfo{
+			Key:  key,
+			Name: path.Base(key),
+			Size: size,
+			URL:  s.URL(key),
+		})
+	}
+
+	return files, nil
 }
 
-// GetUploadPath возвращает путь к папке uploads
+// GetUploadPath возвращает ключ файла задачи
 func (s *Service) GetUploadPath(taskID, filename string) string {
-	return filepath.Join(s.uploadsDir, taskID, filename)
+	// filepath.Base отрезает директории из имени файла от клиента
+	return path.Join(taskID, filepath.Base(filename))
+}
+
+// GetResultPath возвращает ключ результата обработки задачи (аннотированного фото),
+// который Python пишет в RESULTS_DIR/task_id ("results/task_id/filename")
+func (s *Service) GetResultPath(taskID, filename string) string {
+	return resultsPrefix + path.Join(taskID, filepath.Base(filename))
+}
+
+// URL возвращает адрес, по которому файл доступен клиентам
+func (s *Service) URL(key string) string {
+	backend, key := s.backendFor(key)
+	return backend.URL(key)
 }
 
 // FileExists проверяет существование файла
-func (s *Service) FileExists(path string) bool {
-	_, err := os.Stat(path)
-	return err == nil
+func (s *Service) FileExists(key string) bool {
+	backend, key := s.backendFor(key)
+	exists, err := backend.Exists(key)
+	return err == nil && exists
 }
 
 // GetFileSize возвращает размер файла в байтах
-func (s *Service) GetFileSize(path string) (int64, error) {
-	info, err := os.Stat(path)
+func (s *Service) GetFileSize(key string) (int64, error) {
+	backend, key := s.backendFor(key)
+	return backend.Size(key)
+}
+
+// ListAllFiles возвращает ключи всех файлов хранилища: загрузки задач,
+// аннотированные фото (в том числе в RESULTS_DIR) и миниатюры
+func (s *Service) ListAllFiles() ([]string, error) {
+	keys, err := s.backend.List("")
+	if err != nil || s.results == nil {
+		return keys, err
+	}
+
+	results, err := s.results.List("")
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	return info.Size(), nil
+	for _, key := range results {
+		keys = append(keys, resultsPrefix+key)
+	}
+	return keys, nil
+}
+
+// FileModTime возвращает время последней записи файла
+func (s *Service) FileModTime(key string) (time.Time, error) {
+	backend, key := s.backendFor(key)
+	return backend.ModTime(key)
+}
+
+// IsFaceFile сообщает, создан ли файл для конкретного лица (миниатюра или
+// аннотированное фото) - такой файл нужен, только пока на него ссылается лицо
+func IsFaceFile(key string) bool {
+	return strings.HasPrefix(key, thumbnailsPrefix+"/") || strings.HasSuffix(key, annotatedSuffix)
 }
 
 // CleanupOldTasks удаляет старые задачи (можно вызывать по cron)
 // age - возраст в часах
 func (s *Service) CleanupOldTasks(ageHours int) error {
+	// Очистка по возрасту папок реализована только для локального диска
+	local, ok := s.backend.(*LocalBackend)
+	if !ok {
+		return ErrCleanupUnsupported
+	}
+
 	// Получаем все папки в uploads
-	entries, err := os.ReadDir(s.uploadsDir)
+	entries, err := os.ReadDir(local.Root())
 	if err != nil {
 		return err
 	}
@@ -127,7 +357,7 @@ func (s *Service) CleanupOldTasks(ageHours int) error {
 			continue
 		}
 
-		dirPath := filepath.Join(s.uploadsDir, entry.Name())
+		dirPath := filepath.Join(local.Root(), entry.Name())
 		info, err := entry.Info()
 		if err != nil {
 			continue
@@ -0,0 +1,1401 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockS3 - минимальный S3 сервер в памяти (path-style, один бакет)
+type mockS3 struct {
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newMockS3(bucket string) (*mockS3, *httptest.Server) {
+	m := &mockS3{bucket: bucket, objects: make(map[string][]byte)}
+	return m, httptest.NewServer(m)
+}
+
+func (m *mockS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	if parts[0] != m.bucket {
+		m.writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case key == "" && r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+
+	case key == "" && r.Method == http.MethodGet:
+		m.listObjects(w, r.URL.Query().Get("prefix"))
+
+	case r.Method == http.MethodPut:
+		data, err := readS3Body(r)
+		if err != nil {
+			m.writeError(w, http.StatusBadRequest, "BadRequest")
+			return
+		}
+		m.objects[key] = data
+		w.Header().Set("ETag", `"etag"`)
+		w.WriteHeader(http.StatusOK)
+
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		data, ok := m.objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			m.writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", "Mon, 01 Jan 2024 00:00:00 GMT")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+
+	case r.Method == http.MethodDelete:
+		delete(m.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		m.writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (m *mockS3) listObjects(w http.ResponseWriter, prefix string) {
+	var keys []string
+	for key := range m.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var contents strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&contents,
+			"<Contents><Key>%s</Key><Size>%d</Size><LastModified>2024-01-01T00:00:00.000Z</LastModified><ETag>\"etag\"</ETag></Contents>",
+			key, len(m.objects[key]))
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w,
+		`<?xml version="1.0" encoding="UTF-8"?><ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><MaxKeys>1000</MaxKeys><IsTruncated>false</IsTruncated>%s</ListBucketResult>`,
+		m.bucket, prefix, len(keys), contents.String())
+}
+
+func (m *mockS3) writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+	}{Code: code})
+}
+
+// readS3Body читает тело PUT запроса, раскодируя aws-chunked (streaming signature)
+func readS3Body(r *http.Request) ([]byte, error) {
+	if r.Header.Get("X-Amz-Content-Sha256") != "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+		return io.ReadAll(r.Body)
+	}
+
+	var data bytes.Buffer
+	reader := bufio.NewReader(r.Body)
+	for {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex := strings.SplitN(strings.TrimSpace(header), ";", 2)[0]
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return data.Bytes(), nil
+		}
+		if _, err := io.CopyN(&data, reader, size); err != nil {
+			return nil, err
+		}
+		// Пропускаем \r\n после данных чанка
+		if _, err := reader.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func newTestS3Backend(t *testing.T) (*S3Backend, *mockS3) {
+	mock, server := newMockS3("faces")
+	t.Cleanup(server.Close)
+
+	backend, err := NewS3Backend(S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "test",
+		SecretKey: "testsecret",
+		Bucket:    "faces",
+		Region:    "us-east-1",
+	})
+	require.NoError(t, err)
+	return backend, mock
+}
+
+func TestS3BackendSaveOpenDelete(t *testing.T) {
+	backend, mock := newTestS3Backend(t)
+
+	content := []byte("image bytes")
+	err := backend.Save("task-1/photo.jpg", bytes.NewReader(content), int64(len(content)))
+	require.NoError(t, err)
+	assert.Equal(t, content, mock.objects["task-1/photo.jpg"])
+
+	exists, err := backend.Exists("task-1/photo.jpg")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	size, err := backend.Size("task-1/photo.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(content)), size)
+
+	reader, err := backend.Open("task-1/photo.jpg")
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, content, data)
+
+	require.NoError(t, backend.Delete("task-1/photo.jpg"))
+	exists, err = backend.Exists("task-1/photo.jpg")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestS3BackendMissingObject(t *testing.T) {
+	backend, _ := newTestS3Backend(t)
+
+	_, err := backend.Open("task-1/missing.jpg")
+	assert.Equal(t, ErrNotFound, err)
+
+	_, err = backend.Size("task-1/missing.jpg")
+	assert.Equal(t, ErrNotFound, err)
+
+	exists, err := backend.Exists("task-1/missing.jpg")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestS3BackendDeletePrefix(t *testing.T) {
+	backend, mock := newTestS3Backend(t)
+
+	mock.objects["task-1/a.jpg"] = []byte("a")
+	mock.objects["task-1/b.jpg"] = []byte("b")
+	mock.objects["task-2/c.jpg"] = []byte("c")
+
+	require.NoError(t, backend.DeletePrefix("task-1/"))
+
+	assert.NotContains(t, mock.objects, "task-1/a.jpg")
+	assert.NotContains(t, mock.objects, "task-1/b.jpg")
+	assert.Contains(t, mock.objects, "task-2/c.jpg")
+}
+
+func TestS3BackendList(t *testing.T) {
+	backend, mock := newTestS3Backend(t)
+
+	mock.objects["task-1/b.jpg"] = []byte("b")
+	mock.objects["task-1/a.jpg"] = []byte("a")
+	mock.objects["task-2/c.jpg"] = []byte("c")
+
+	keys, err := backend.List("task-1/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"task-1/a.jpg", "task-1/b.jpg"}, keys)
+}
+
+func TestS3BackendURL(t *testing.T) {
+	backend, _ := newTestS3Backend(t)
+	assert.True(t, strings.HasSuffix(backend.URL("task-1/photo.jpg"), "/faces/task-1/photo.jpg"))
+
+	backend.publicURL = "https://cdn.example.com/faces"
+	assert.Equal(t, "https://cdn.example.com/faces/task-1/photo.jpg", backend.URL("/task-1/photo.jpg"))
+}
+
+func TestNewS3BackendMissingBucket(t *testing.T) {
+	_, server := newMockS3("other")
+	defer server.Close()
+
+	_, err := NewS3Backend(S3Options{
+		Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		AccessKey: "test",
+		SecretKey: "testsecret",
+		Bucket:    "faces",
+		Region:    "us-east-1",
+	})
+	assert.Error(t, err)
+}
+
+func TestCleanKey(t *testing.T) {
+	cases := map[string]string{
+		"task/photo.jpg":          "task/photo.jpg",
+		"/task/photo.jpg":         "task/photo.jpg",
+		"../../etc/passwd":        "etc/passwd",
+		"task/../../secret.txt":   "secret.txt",
+		"task/./nested/photo.jpg": "task/nested/photo.jpg",
+	}
+
+	for key, expected := range cases {
+		assert.Equal(t, expected, cleanKey(key), key)
+	}
+}
+
+func TestLocalBackendStaysInsideRoot(t *testing.T) {
+	root := t.TempDir()
+	backend, err := NewLocalBackend(root, "/uploads")
+	require.NoError(t, err)
+
+	require.NoError(t, backend.Save("../escape.txt", strings.NewReader("data"), 4))
+
+	// Файл оказался внутри root, а не уровнем выше
+	_, err = os.Stat(filepath.Join(root, "escape.txt"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(filepath.Dir(root), "escape.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Equal(t, "/uploads/escape.txt", backend.URL("../escape.txt"))
+}
+
+func TestLocalBackendSaveOpenDelete(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+
+	require.NoError(t, backend.Save("task-1/photo.jpg", strings.NewReader("image"), 5))
+
+	size, err := backend.Size("task-1/photo.jpg")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	require.NoError(t, backend.DeletePrefix("task-1/"))
+
+	_, err = backend.Open("task-1/photo.jpg")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, backend.Delete("task-1/photo.jpg"))
+}
+
+func TestLocalBackendList(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+
+	for _, key := range []string{"task-1/b.jpg", "task-1/a.jpg", "task-1/sub/c.jpg", "task-2/d.jpg"} {
+		require.NoError(t, backend.Save(key, strings.NewReader("x"), 1))
+	}
+
+	keys, err := backend.List("task-1/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"task-1/a.jpg", "task-1/b.jpg", "task-1/sub/c.jpg"}, keys)
+
+	keys, err = backend.List("missing/")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestServiceListTaskFilesSkipsAnnotated(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	// Кадры видео извлекаются заново при каждой обработке - это не загруженные файлы
+	for _, key := range []string{"task-1/photo.jpg", "task-1/face_0_boxed.jpg", "task-1/other.png", "task-1/clip_frame_000001.jpg"} {
+		require.NoError(t, backend.Save(key, strings.NewReader("x"), 1))
+	}
+
+	files, err := service.ListTaskFiles("task-1")
+	require.NoError(t, err)
+	assert.Equal(t, []FileInfo{
+		{Key: "task-1/other.png", Name: "other.png", Size: 1, URL: "/uploads/task-1/other.png"},
+		{Key: "task-1/photo.jpg", Name: "photo.jpg", Size: 1, URL: "/uploads/task-1/photo.jpg"},
+	}, files)
+}
+
+func TestServiceListTaskFilesMissingTask(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	files, err := service.ListTaskFiles("missing")
+	require.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestServiceGetUploadPathStripsDirectories(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	assert.Equal(t, "task-1/photo.jpg", service.GetUploadPath("task-1", "../../photo.jpg"))
+}
+
+func TestServiceGetResultPath(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	assert.Equal(t, "results/task-1/f1_boxed.jpg", service.GetResultPath("task-1", "task-1/f1_boxed.jpg"))
+	assert.Equal(t, "results/task-1/f1_boxed.jpg", service.GetResultPath("task-1", "../../f1_boxed.jpg"))
+}
+
+func TestServiceResolvesResultPaths(t *testing.T) {
+	uploadsDir, resultsDir := t.TempDir(), t.TempDir()
+	backend, err := NewLocalBackend(uploadsDir, "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, resultsDir)
+	require.NoError(t, err)
+	service.SetResultsURL("/annotated/")
+
+	require.NoError(t, backend.Save("task-1/photo.jpg", strings.NewReader("photo"), 5))
+	require.NoError(t, os.MkdirAll(filepath.Join(resultsDir, "task-1"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(resultsDir, "task-1", "f1_boxed.jpg"), []byte("boxed"), 0o644))
+
+	key := service.GetResultPath("task-1", "f1_boxed.jpg")
+	assert.True(t, service.FileExists(key))
+	assert.Equal(t, "/annotated/task-1/f1_boxed.jpg", service.URL(key))
+	assert.Equal(t, "/uploads/task-1/photo.jpg", service.URL("task-1/photo.jpg"))
+
+	size, err := service.GetFileSize(key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	reader, err := service.Open(key)
+	require.NoError(t, err)
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "boxed", string(data))
+
+	// Результат в загрузках не ищется, и наоборот
+	assert.False(t, service.FileExists("task-1/f1_boxed.jpg"))
+	assert.False(t, service.FileExists("results/task-1/photo.jpg"))
+
+	keys, err := service.ListAllFiles()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"task-1/photo.jpg", "results/task-1/f1_boxed.jpg"}, keys)
+	assert.True(t, IsFaceFile(key))
+
+	// Удаление задачи удаляет и ее результаты
+	require.NoError(t, service.DeleteTaskDirectory("task-1"))
+	assert.False(t, service.FileExists(key))
+	assert.False(t, service.FileExists("task-1/photo.jpg"))
+}
+
+func TestServiceSaveFile(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	key, err := service.SaveFile("task-1", "../photo.jpg", strings.NewReader("img"), 3)
+	require.NoError(t, err)
+	assert.Equal(t, "task-1/photo.jpg", key)
+	assert.True(t, service.FileExists(key))
+}
+
+// multipartFiles собирает multipart форму с файлами names и возвращает их заголовки
+func multipartFiles(tb testing.TB, names []string) []*multipart.FileHeader {
+	tb.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, name := range names {
+		part, err := writer.CreateFormFile("files", name)
+		require.NoError(tb, err)
+		_, err = part.Write([]byte("content of " + name))
+		require.NoError(tb, err)
+	}
+	require.NoError(tb, writer.Close())
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(32 << 20)
+	require.NoError(tb, err)
+	tb.Cleanup(func() { form.RemoveAll() })
+	return form.File["files"]
+}
+
+// slowBackend - локальный бэкенд с задержкой записи, чтобы файлы завершались вразнобой
+type slowBackend struct {
+	*LocalBackend
+	delay  func(key string) time.Duration
+	failOn string // Имя файла, запись которого завершается ошибкой
+}
+
+func (b *slowBackend) Save(key string, r io.Reader, size int64) error {
+	time.Sleep(b.delay(key))
+	if path.Base(key) == b.failOn {
+		return fmt.Errorf("запись %s отклонена", key)
+	}
+	return b.LocalBackend.Save(key, r, size)
+}
+
+func TestServiceSaveUploadedFilesPreservesOrder(t *testing.T) {
+	local, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	// Первые файлы пишутся дольше всех, поэтому завершаются последними
+	backend := &slowBackend{LocalBackend: local, delay: func(key string) time.Duration {
+		n, _ := strconv.Atoi(strings.TrimSuffix(path.Base(key), ".jpg"))
+		return time.Duration(10-n) * time.Millisecond
+	}}
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	service.SetUploadConcurrency(4)
+
+	names := make([]string, 10)
+	for i := range names {
+		names[i] = fmt.Sprintf("%d.jpg", i)
+	}
+
+	taskID, saved, err := service.SaveUploadedFiles(multipartFiles(t, names))
+	require.NoError(t, err)
+	require.Len(t, saved, len(names))
+	for i, name := range names {
+		assert.Equal(t, taskID+"/"+name, saved[i])
+
+		r, err := service.Open(saved[i])
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "content of "+name, string(data))
+	}
+}
+
+func TestServiceSaveUploadedFilesDuplicateNames(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	service.SetUploadConcurrency(4)
+
+	files := multipartFiles(t, []string{"a.jpg", "dir/a.jpg", "b.jpg"})
+	taskID, saved, err := service.SaveUploadedFiles(files)
+	require.NoError(t, err)
+	assert.Equal(t, []string{taskID + "/a.jpg", taskID + "/a.jpg", taskID + "/b.jpg"}, saved)
+
+	keys, err := backend.List(taskID + "/")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{taskID + "/a.jpg", taskID + "/b.jpg"}, keys)
+}
+
+func TestServiceFileNaming(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	assert.Equal(t, NamingOriginal, service.FileNaming())
+	assert.ErrorIs(t, service.SetFileNaming("random"), ErrUnknownNaming)
+	assert.Equal(t, NamingOriginal, service.FileNaming())
+
+	names := []string{"Фото с отпуска.JPG", "scan.png", "Фото с отпуска.JPG"}
+
+	t.Run("original", func(t *testing.T) {
+		require.NoError(t, service.SetFileNaming(NamingOriginal))
+		taskID, saved, err := service.SaveUploadedFiles(multipartFiles(t, names))
+		require.NoError(t, err)
+		assert.Equal(t, taskID+"/Фото с отпуска.JPG", saved[0])
+		assert.Equal(t, taskID+"/scan.png", saved[1])
+	})
+
+	t.Run("uuid", func(t *testing.T) {
+		require.NoError(t, service.SetFileNaming(NamingUUID))
+		taskID, saved, err := service.SaveUploadedFiles(multipartFiles(t, names))
+		require.NoError(t, err)
+		assert.Regexp(t, `^`+taskID+`/[0-9a-f-]{36}\.jpg$`, saved[0])
+		assert.Regexp(t, `^`+taskID+`/[0-9a-f-]{36}\.png$`, saved[1])
+		assert.Equal(t, saved[0], saved[2], "то же имя в той же задаче - тот же ключ")
+
+		// Имя выводится из задачи и имени файла, а не случайно
+		key, err := service.SaveFile(taskID, "scan.png", strings.NewReader("other"), 5)
+		require.NoError(t, err)
+		assert.Equal(t, saved[1], key)
+		key, err = service.SaveFile("task-2", "scan.png", strings.NewReader("other"), 5)
+		require.NoError(t, err)
+		assert.NotEqual(t, path.Base(saved[1]), path.Base(key), "в другой задаче - другое имя")
+	})
+
+	t.Run("hash", func(t *testing.T) {
+		require.NoError(t, service.SetFileNaming(NamingHash))
+		taskID, saved, err := service.SaveUploadedFiles(multipartFiles(t, names))
+		require.NoError(t, err)
+		sum := sha256.Sum256([]byte("content of scan.png"))
+		assert.Equal(t, taskID+"/"+hex.EncodeToString(sum[:])+".png", saved[1])
+		assert.Regexp(t, `^`+taskID+`/[0-9a-f]{64}\.jpg$`, saved[0])
+
+		// Имя зависит только от содержимого, а сохраняется содержимое целиком
+		key, err := service.SaveFile("task-2", "копия.png", strings.NewReader("content of scan.png"), 19)
+		require.NoError(t, err)
+		assert.Equal(t, path.Base(saved[1]), path.Base(key))
+		r, err := service.Open(key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		r.Close()
+		require.NoError(t, err)
+		assert.Equal(t, "content of scan.png", string(data))
+	})
+}
+
+func TestSafeExt(t *testing.T) {
+	assert.Equal(t, ".jpg", safeExt("photo.JPG"))
+	assert.Equal(t, ".heic", safeExt("IMG_0001.heic"))
+	assert.Equal(t, "", safeExt("README"))
+	assert.Equal(t, "", safeExt("фото.жпг"), "не латиница")
+	assert.Equal(t, "", safeExt("archive.verylongextension"))
+	assert.Equal(t, "", safeExt("name."))
+}
+
+func TestServiceSaveUploadedFilesCleansUpOnError(t *testing.T) {
+	root := t.TempDir()
+	local, err := NewLocalBackend(root, "/uploads")
+	require.NoError(t, err)
+	backend := &slowBackend{LocalBackend: local, delay: func(string) time.Duration { return 0 }, failOn: "2.jpg"}
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	service.SetUploadConcurrency(2)
+
+	names := []string{"0.jpg", "1.jpg", "2.jpg", "3.jpg", "4.jpg"}
+
+	taskID, saved, err := service.SaveUploadedFiles(multipartFiles(t, names))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "2.jpg")
+	assert.Empty(t, taskID)
+	assert.Nil(t, saved)
+
+	// Папка задачи с уже записанными файлами удалена
+	entries, err := os.ReadDir(root)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		keys, err := local.List(entry.Name() + "/")
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	}
+}
+
+func BenchmarkServiceSaveUploadedFiles(b *testing.B) {
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			backend, err := NewLocalBackend(b.TempDir(), "/uploads")
+			require.NoError(b, err)
+			service, err := NewService(backend, b.TempDir())
+			require.NoError(b, err)
+			service.SetUploadConcurrency(concurrency)
+
+			names := make([]string, 100)
+			for i := range names {
+				names[i] = fmt.Sprintf("%d.jpg", i)
+			}
+			files := multipartFiles(b, names)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				taskID, _, err := service.SaveUploadedFiles(files)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				service.DeleteTaskDirectory(taskID)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+// exifJPEG собирает JPEG с сегментом APP1 Exif, в котором DateTimeOriginal = dateTime
+// и (если задан) OffsetTimeOriginal = offset
+func exifJPEG(t *testing.T, order binary.ByteOrder, dateTime, offset string) []byte {
+	t.Helper()
+
+	type entry struct {
+		tag  uint16
+		text string
+	}
+	entries := []entry{{0x9003, dateTime}}
+	if offset != "" {
+		entries = append(entries, entry{0x9011, offset})
+	}
+
+	var tiff bytes.Buffer
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	write := func(v interface{}) { binary.Write(&tiff, order, v) }
+	write(uint16(42))
+	write(uint32(8))
+
+	// IFD0: только указатель на Exif IFD (сразу за IFD0)
+	exifIFD := uint32(8 + 2 + 12 + 4)
+	write(uint16(1))
+	write([]uint16{0x8769, 4})
+	write([]uint32{1, exifIFD})
+	write(uint32(0))
+
+	// Exif IFD: строки лежат за записями
+	data := exifIFD + 2 + uint32(len(entries))*12 + 4
+	write(uint16(len(entries)))
+	for _, e := range entries {
+		write([]uint16{e.tag, 2})
+		write([]uint32{uint32(len(e.text) + 1), data})
+		data += uint32(len(e.text) + 1)
+	}
+	write(uint32(0))
+	for _, e := range entries {
+		tiff.WriteString(e.text + "\x00")
+	}
+
+	var img bytes.Buffer
+	require.NoError(t, jpeg.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil))
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(img.Bytes()[2:]) // Без SOI - он уже записан
+	return out.Bytes()
+}
+
+func TestReadCaptureTime(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		capturedAt, err := ReadCaptureTime(bytes.NewReader(exifJPEG(t, order, "2023:07:14 18:30:05", "")))
+		require.NoError(t, err)
+		assert.Equal(t, time.Date(2023, 7, 14, 18, 30, 5, 0, time.UTC), capturedAt)
+	}
+}
+
+func TestReadCaptureTimeWithOffset(t *testing.T) {
+	capturedAt, err := ReadCaptureTime(bytes.NewReader(exifJPEG(t, binary.LittleEndian, "2023:07:14 18:30:05", "+03:00")))
+	require.NoError(t, err)
+	assert.True(t, capturedAt.Equal(time.Date(2023, 7, 14, 15, 30, 5, 0, time.UTC)))
+}
+
+func TestReadCaptureTimeMissing(t *testing.T) {
+	var plain bytes.Buffer
+	require.NoError(t, jpeg.Encode(&plain, image.NewRGBA(image.Rect(0, 0, 4, 4)), nil))
+
+	cases := map[string][]byte{
+		"jpeg без EXIF":   plain.Bytes(),
+		"битая дата":      exifJPEG(t, binary.BigEndian, "0000:00:00 00:00:00", ""),
+		"пустая дата":     exifJPEG(t, binary.BigEndian, "", ""),
+		"не jpeg":         []byte("not an image"),
+		"обрезанный exif": exifJPEG(t, binary.BigEndian, "2023:07:14 18:30:05", "")[:30],
+	}
+	for name, data := range cases {
+		_, err := ReadCaptureTime(bytes.NewReader(data))
+		assert.Error(t, err, name)
+	}
+}
+
+func TestServiceCaptureTime(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	withExif := exifJPEG(t, binary.BigEndian, "2021:12:31 23:59:59", "")
+	require.NoError(t, backend.Save("task-1/exif.jpg", bytes.NewReader(withExif), int64(len(withExif))))
+	saveQuadrants(t, backend, "task-1/plain.png")
+
+	capturedAt := service.CaptureTime("task-1/exif.jpg")
+	require.NotNil(t, capturedAt)
+	assert.Equal(t, time.Date(2021, 12, 31, 23, 59, 59, 0, time.UTC), *capturedAt)
+
+	assert.Nil(t, service.CaptureTime("task-1/plain.png"))
+	assert.Nil(t, service.CaptureTime("task-1/missing.jpg"))
+}
+
+func TestServiceCleanupOldTasksUnsupportedOnS3(t *testing.T) {
+	backend, _ := newTestS3Backend(t)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	assert.Equal(t, ErrCleanupUnsupported, service.CleanupOldTasks(24))
+}
+
+func TestGenerateThumbnail(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	// Исходник 400x200 в PNG
+	src := image.NewRGBA(image.Rect(0, 0, 400, 200))
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	require.NoError(t, backend.Save("task-1/face_boxed.png", &buf, int64(buf.Len())))
+
+	key, err := service.GenerateThumbnail("task-1/face_boxed.png", 128)
+	require.NoError(t, err)
+	assert.Equal(t, "thumbnails/task-1/face_boxed.jpg", key)
+
+	reader, err := backend.Open(key)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	cfg, format, err := image.DecodeConfig(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 128, cfg.Width)
+	assert.Equal(t, 64, cfg.Height)
+}
+
+func TestGenerateThumbnailMissingSource(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	_, err = service.GenerateThumbnail("task-1/missing.jpg", 128)
+	assert.Error(t, err)
+}
+
+// saveQuadrants сохраняет PNG 200x100: левая половина красная, правая синяя
+func saveQuadrants(t *testing.T, backend StorageBackend, key string) {
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for x := 0; x < 200; x++ {
+		for y := 0; y < 100; y++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 100 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, src))
+	require.NoError(t, backend.Save(key, &buf, int64(buf.Len())))
+}
+
+func TestCropImage(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	saveQuadrants(t, backend, "task-1/photo.png")
+
+	data, err := service.CropImage("task-1/photo.png", image.Rect(120, 20, 180, 60))
+	require.NoError(t, err)
+
+	img, format, err := image.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 60, img.Bounds().Dx())
+	assert.Equal(t, 40, img.Bounds().Dy())
+
+	// Вырезка целиком из синей половины
+	r, g, b, _ := img.At(img.Bounds().Min.X+30, img.Bounds().Min.Y+20).RGBA()
+	assert.Less(t, r>>8, uint32(30))
+	assert.Less(t, g>>8, uint32(30))
+	assert.Greater(t, b>>8, uint32(220))
+}
+
+func TestCropImageClampsToBounds(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	saveQuadrants(t, backend, "task-1/photo.png")
+
+	data, err := service.CropImage("task-1/photo.png", image.Rect(-20, -20, 50, 40))
+	require.NoError(t, err)
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, 50, cfg.Width)
+	assert.Equal(t, 40, cfg.Height)
+
+	_, err = service.CropImage("task-1/photo.png", image.Rect(300, 0, 400, 50))
+	assert.ErrorIs(t, err, ErrEmptyCrop)
+
+	_, err = service.CropImage("task-1/missing.png", image.Rect(0, 0, 10, 10))
+	assert.Error(t, err)
+}
+
+func TestComposeSprite(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	saveQuadrants(t, backend, "task-1/photo.png")
+
+	sprite, err := service.ComposeSprite([]SpriteFace{
+		{Key: "task-1/photo.png", Rect: image.Rect(120, 20, 180, 80)},   // синяя половина
+		{Key: "task-1/missing.png", Rect: image.Rect(0, 0, 10, 10)},     // файла нет - пропускается
+		{Key: "task-1/photo.png", Rect: image.Rect(10, 10, 50, 50)},     // красная половина
+		{Key: "task-1/photo.png", Rect: image.Rect(300, 300, 320, 320)}, // вне изображения
+		{Key: "task-1/photo.png", Rect: image.Rect(140, 40, 160, 60)},
+	}, 2, 32)
+	require.NoError(t, err)
+
+	// Три лица в две колонки: 2x2 ячейки по 32 px, ячейки по порядку входного списка
+	assert.Equal(t, []int{0, 2, 4}, sprite.Placed)
+	assert.Equal(t, 64, sprite.Width)
+	assert.Equal(t, 64, sprite.Height)
+	assert.Equal(t, FormatJPEG, sprite.Format.Name)
+
+	img, format, err := image.Decode(bytes.NewReader(sprite.Data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, image.Rect(0, 0, 64, 64), img.Bounds())
+
+	// Ячейка 0 - синее лицо, ячейка 1 - красное
+	r, _, b, _ := img.At(16, 16).RGBA()
+	assert.Greater(t, b>>8, uint32(200))
+	assert.Less(t, r>>8, uint32(60))
+	r, _, b, _ = img.At(48, 16).RGBA()
+	assert.Greater(t, r>>8, uint32(200))
+	assert.Less(t, b>>8, uint32(60))
+
+	assert.Equal(t, image.Pt(0, 32), SpriteCell(2, 2, 32))
+}
+
+func TestComposeSpriteSingleRow(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	saveQuadrants(t, backend, "task-1/photo.png")
+
+	// Лиц меньше колонок - ширина по числу лиц
+	sprite, err := service.ComposeSprite([]SpriteFace{{Key: "task-1/photo.png", Rect: image.Rect(0, 0, 100, 50)}}, 5, 40)
+	require.NoError(t, err)
+	assert.Equal(t, 40, sprite.Width)
+	assert.Equal(t, 40, sprite.Height)
+
+	_, err = service.ComposeSprite([]SpriteFace{{Key: "task-1/missing.png", Rect: image.Rect(0, 0, 10, 10)}}, 5, 40)
+	assert.ErrorIs(t, err, ErrEmptySprite)
+
+	_, err = service.ComposeSprite(nil, 0, 40)
+	assert.Error(t, err)
+}
+
+func TestSquareRect(t *testing.T) {
+	assert.Equal(t, image.Rect(10, -5, 50, 35), squareRect(image.Rect(10, 10, 50, 20)))
+	assert.Equal(t, image.Rect(0, 0, 8, 8), squareRect(image.Rect(0, 0, 8, 8)))
+}
+
+// texturedImage - слева резкая текстура (шум), справа та же текстура, размытая box-фильтром
+func texturedImage() *image.Gray {
+	const width, height, radius = 200, 100, 4
+	rng := rand.New(rand.NewSource(1))
+	noise := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range noise.Pix {
+		noise.Pix[i] = uint8(rng.Intn(256))
+	}
+
+	img := image.NewGray(noise.Bounds())
+	copy(img.Pix, noise.Pix)
+	for y := 0; y < height; y++ {
+		for x := width / 2; x < width; x++ {
+			sum, n := 0, 0
+			for dy := -radius; dy <= radius; dy++ {
+				for dx := -radius; dx <= radius; dx++ {
+					if p := image.Pt(x+dx, y+dy); p.In(noise.Bounds()) {
+						sum += int(noise.GrayAt(p.X, p.Y).Y)
+						n++
+					}
+				}
+			}
+			img.SetGray(x, y, color.Gray{Y: uint8(sum / n)})
+		}
+	}
+	return img
+}
+
+func TestSharpness(t *testing.T) {
+	img := texturedImage()
+
+	sharp := Sharpness(img, image.Rect(10, 10, 90, 90))
+	blurry := Sharpness(img, image.Rect(110, 10, 190, 90))
+	flat := Sharpness(image.NewGray(image.Rect(0, 0, 50, 50)), image.Rect(0, 0, 50, 50))
+
+	assert.Greater(t, sharp, 0.9)
+	assert.Less(t, blurry, 0.5)
+	assert.Greater(t, sharp, blurry)
+	assert.InDelta(t, 0, flat, 1e-9)
+	assert.Less(t, sharp, 1.0)
+
+	// Область вне изображения
+	assert.Equal(t, 0.0, Sharpness(img, image.Rect(300, 0, 400, 50)))
+}
+
+func TestFaceQualities(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, texturedImage()))
+	require.NoError(t, backend.Save("task-1/photo.png", &buf, int64(buf.Len())))
+
+	qualities, err := service.FaceQualities("task-1/photo.png", []image.Rectangle{
+		image.Rect(110, 10, 190, 90),
+		image.Rect(10, 10, 90, 90),
+	})
+	require.NoError(t, err)
+	require.Len(t, qualities, 2)
+	assert.Greater(t, qualities[1], qualities[0])
+
+	_, err = service.FaceQualities("task-1/missing.png", []image.Rectangle{image.Rect(0, 0, 10, 10)})
+	assert.Error(t, err)
+}
+
+func TestOutputFormats(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+	saveQuadrants(t, backend, "task-1/photo.png")
+
+	assert.Equal(t, FormatJPEG, service.OutputFormat().Name)
+	assert.ErrorIs(t, service.SetOutputFormat("gif"), ErrUnknownFormat)
+
+	// Каждый формат: вырезка и миниатюра кодируются в него, а без кодировщика
+	// в сборке (теги webp, avif) - в JPEG
+	for _, name := range []string{FormatJPEG, FormatWebP, FormatAVIF} {
+		want := imageFormats[name]
+		if err := service.SetOutputFormat(name); err != nil {
+			require.ErrorIs(t, err, ErrEncoderUnavailable, name)
+			want = imageFormats[FormatJPEG]
+		}
+		assert.Equal(t, want, service.OutputFormat(), name)
+
+		data, err := service.CropImage("task-1/photo.png", image.Rect(120, 20, 180, 60))
+		require.NoError(t, err, name)
+		assert.Equal(t, want.ContentType, ContentType(data), name)
+
+		key, err := service.GenerateThumbnail("task-1/photo.png", 64)
+		require.NoError(t, err, name)
+		assert.Equal(t, "thumbnails/task-1/photo"+want.Ext, key, name)
+	}
+}
+
+func TestEncodeImageFallsBackToJPEG(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	original, registered := imageEncoders[FormatWebP]
+	imageEncoders[FormatWebP] = func(io.Writer, image.Image, int) error {
+		return errors.New("encoder failed")
+	}
+	t.Cleanup(func() {
+		if registered {
+			imageEncoders[FormatWebP] = original
+		} else {
+			delete(imageEncoders, FormatWebP)
+		}
+	})
+	require.NoError(t, service.SetOutputFormat(FormatWebP))
+
+	data, format, err := service.encodeImage(image.NewRGBA(image.Rect(0, 0, 8, 8)), 85)
+	require.NoError(t, err)
+	assert.Equal(t, FormatJPEG, format.Name)
+	_, decoded, err := image.DecodeConfig(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", decoded)
+}
+
+func TestContentType(t *testing.T) {
+	assert.Equal(t, "image/jpeg", ContentType([]byte{0xFF, 0xD8, 0xFF, 0xE0}))
+	assert.Equal(t, "image/webp", ContentType([]byte("RIFF\x24\x00\x00\x00WEBPVP8 ")))
+	assert.Equal(t, "image/avif", ContentType([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00")))
+	assert.Equal(t, "image/jpeg", ContentType(nil))
+}
+
+// pngHeader возвращает начало PNG (сигнатура и IHDR) с размерами width x height:
+// файл в сотню байт, который заявляет гигапиксельное изображение
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 0, 17)
+	ihdr = append(ihdr, "IHDR"...)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0) // 8 бит, RGB
+
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)-4))
+	data = append(data, ihdr...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestCheckImageSize(t *testing.T) {
+	bomb := pngHeader(50000, 50000)
+	require.Less(t, len(bomb), 100)
+
+	err := CheckImageSize(bytes.NewReader(bomb), "bomb.png", 100e6)
+	assert.ErrorIs(t, err, ErrImageTooLarge)
+	assert.Contains(t, err.Error(), "bomb.png (50000x50000, 2500.0 Мп, допустимо 100.0 Мп)")
+
+	// Без ограничения и в пределах ограничения
+	assert.NoError(t, CheckImageSize(bytes.NewReader(bomb), "bomb.png", 0))
+	assert.NoError(t, CheckImageSize(bytes.NewReader(pngHeader(4000, 3000)), "photo.png", 12e6))
+
+	// Размеры, которые Go не читает, проверяют ffmpeg и Python
+	assert.NoError(t, CheckImageSize(strings.NewReader("not an image"), "notes.txt", 1))
+}
+
+func TestServiceCheckDimensions(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	bomb := pngHeader(50000, 50000)
+	require.NoError(t, backend.Save("task-1/bomb.png", bytes.NewReader(bomb), int64(len(bomb))))
+	var photo bytes.Buffer
+	require.NoError(t, jpeg.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 40, 30)), nil))
+	require.NoError(t, backend.Save("task-1/photo.jpg", &photo, int64(photo.Len())))
+
+	// Без SetMaxPixels размеры не проверяются
+	assert.NoError(t, service.CheckDimensions("task-1/bomb.png"))
+
+	service.SetMaxPixels(1200)
+	assert.ErrorIs(t, service.CheckDimensions("task-1/bomb.png"), ErrImageTooLarge)
+	assert.NoError(t, service.CheckDimensions("task-1/photo.jpg"))
+
+	service.SetMaxPixels(1199)
+	assert.ErrorIs(t, service.CheckDimensions("task-1/photo.jpg"), ErrImageTooLarge)
+}
+
+func TestResizeToFitKeepsSmallImages(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 50, 80))
+	assert.Equal(t, src, resizeToFit(src, 128))
+
+	tall := resizeToFit(image.NewRGBA(image.Rect(0, 0, 100, 400)), 128)
+	assert.Equal(t, 32, tall.Bounds().Dx())
+	assert.Equal(t, 128, tall.Bounds().Dy())
+}
+
+func TestIsHEIF(t *testing.T) {
+	sample, err := os.ReadFile("testdata/sample.heic")
+	require.NoError(t, err)
+	assert.True(t, IsHEIF(sample[:12]))
+
+	assert.True(t, IsHEIF([]byte("\x00\x00\x00\x18ftypheix")))
+	// Другие ISOBMFF контейнеры (mp4, avif) и обычные изображения - не HEIF
+	assert.False(t, IsHEIF([]byte("\x00\x00\x00\x18ftypisom")))
+	assert.False(t, IsHEIF([]byte("\x00\x00\x00\x18ftypavif")))
+	assert.False(t, IsHEIF([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01")))
+	assert.False(t, IsHEIF([]byte("ftyp")))
+}
+
+func TestConvertHEIF(t *testing.T) {
+	if !HEIFSupported {
+		t.Skip("HEIC декодер требует cgo")
+	}
+
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	sample, err := os.ReadFile("testdata/sample.heic")
+	require.NoError(t, err)
+	require.NoError(t, backend.Save("task-1/IMG_0001.HEIC", bytes.NewReader(sample), int64(len(sample))))
+
+	key, converted, err := service.ConvertHEIF("task-1/IMG_0001.HEIC")
+	require.NoError(t, err)
+	assert.True(t, converted)
+	assert.Equal(t, "task-1/IMG_0001.jpg", key)
+
+	// Хранится только JPEG
+	assert.False(t, service.FileExists("task-1/IMG_0001.HEIC"))
+
+	reader, err := backend.Open(key)
+	require.NoError(t, err)
+	defer reader.Close()
+
+	cfg, format, err := image.DecodeConfig(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.Equal(t, 1596, cfg.Width)
+	assert.Equal(t, 1064, cfg.Height)
+}
+
+func TestConvertHEIFNameCollision(t *testing.T) {
+	if !HEIFSupported {
+		t.Skip("HEIC декодер требует cgo")
+	}
+
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	sample, err := os.ReadFile("testdata/sample.heic")
+	require.NoError(t, err)
+	require.NoError(t, backend.Save("task-1/photo.heic", bytes.NewReader(sample), int64(len(sample))))
+	require.NoError(t, backend.Save("task-1/photo.jpg", bytes.NewBufferString("jpeg"), 4))
+
+	key, converted, err := service.ConvertHEIF("task-1/photo.heic")
+	require.NoError(t, err)
+	assert.True(t, converted)
+	assert.Equal(t, "task-1/photo_heic.jpg", key)
+
+	// Загруженный пользователем photo.jpg не перезаписан
+	size, err := service.GetFileSize("task-1/photo.jpg")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), size)
+}
+
+func TestConvertHEIFSkipsOtherFormats(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+	require.NoError(t, backend.Save("task-1/a.heic", &buf, int64(buf.Len())))
+
+	// Расширение .heic, но внутри PNG - файл не трогаем
+	key, converted, err := service.ConvertHEIF("task-1/a.heic")
+	require.NoError(t, err)
+	assert.False(t, converted)
+	assert.Equal(t, "task-1/a.heic", key)
+	assert.True(t, service.FileExists("task-1/a.heic"))
+}
+
+func TestConvertHEIFDecodeError(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	// Заголовок HEIC, дальше мусор
+	broken := append([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), bytes.Repeat([]byte{0xAB}, 256)...)
+	require.NoError(t, backend.Save("task-1/broken.heic", bytes.NewReader(broken), int64(len(broken))))
+
+	_, converted, err := service.ConvertHEIF("task-1/broken.heic")
+	assert.ErrorIs(t, err, ErrDecode)
+	assert.False(t, converted)
+
+	// Исходник остается для диагностики
+	assert.True(t, service.FileExists("task-1/broken.heic"))
+}
+
+// fakeFrameExtractor пишет frames кадров-JPEG вместо ffmpeg
+type fakeFrameExtractor struct {
+	duration time.Duration
+	frames   int
+	fps      float64 // Частота последнего вызова Extract
+}
+
+func (e *fakeFrameExtractor) Duration(ctx context.Context, videoPath string) (time.Duration, error) {
+	return e.duration, nil
+}
+
+func (e *fakeFrameExtractor) Extract(ctx context.Context, videoPath, dir string, fps float64) error {
+	e.fps = fps
+	for i := 1; i <= e.frames; i++ {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("frame_%06d.jpg", i)), buf.Bytes(), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mp4Header - начало MP4 файла (ftyp isom)
+const mp4Header = "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"
+
+func TestVideoType(t *testing.T) {
+	assert.Equal(t, "video/mp4", VideoType([]byte(mp4Header)))
+	assert.Equal(t, "video/mp4", VideoType([]byte("\x00\x00\x00\x18ftypmp42\x00\x00\x00\x00mp42isom")))
+	assert.Equal(t, "video/quicktime", VideoType([]byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00qt  ")))
+	assert.Equal(t, "video/webm", VideoType([]byte("\x1A\x45\xDF\xA3\x9f\x42\x86\x81\x01\x42\xf7\x81\x01\x42\xf2\x81\x04\x42\xf3\x81\x08\x42\x82\x84webm")))
+
+	// HEIC и AVIF - тоже ISOBMFF, но это фото
+	assert.Empty(t, VideoType([]byte("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic")))
+	assert.Empty(t, VideoType([]byte("\x00\x00\x00\x18ftypavif\x00\x00\x00\x00avifmif1")))
+	assert.Empty(t, VideoType([]byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00\x01")))
+}
+
+func TestExtractFrames(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	extractor := &fakeFrameExtractor{duration: 3 * time.Second, frames: 3}
+	service.SetVideo(extractor, VideoOptions{FPS: 2, MaxDuration: time.Minute, MaxSize: 1 << 20})
+
+	video := mp4Header + strings.Repeat("\x00", 100)
+	require.NoError(t, backend.Save("task-1/clip.mp4", strings.NewReader(video), int64(len(video))))
+
+	frames, ok, err := service.ExtractFrames(context.Background(), "task-1/clip.mp4")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2.0, extractor.fps)
+	assert.Equal(t, []VideoFrame{
+		{Key: "task-1/clip_frame_000001.jpg", Offset: 0},
+		{Key: "task-1/clip_frame_000002.jpg", Offset: 500 * time.Millisecond},
+		{Key: "task-1/clip_frame_000003.jpg", Offset: time.Second},
+	}, frames)
+
+	// Кадры - JPEG в папке задачи; видео остается для повторной обработки
+	reader, err := backend.Open("task-1/clip_frame_000002.jpg")
+	require.NoError(t, err)
+	_, format, err := image.DecodeConfig(reader)
+	reader.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "jpeg", format)
+	assert.True(t, service.FileExists("task-1/clip.mp4"))
+
+	// Фото не трогаем
+	require.NoError(t, backend.Save("task-1/a.jpg", strings.NewReader("\xff\xd8\xff\xe0 jpeg"), 9))
+	frames, ok, err = service.ExtractFrames(context.Background(), "task-1/a.jpg")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, frames)
+}
+
+func TestExtractFramesLimits(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	video := mp4Header + strings.Repeat("\x00", 100)
+	require.NoError(t, backend.Save("task-1/clip.mp4", strings.NewReader(video), int64(len(video))))
+
+	extract := func() error {
+		frames, ok, err := service.ExtractFrames(context.Background(), "task-1/clip.mp4")
+		assert.True(t, ok)
+		assert.Nil(t, frames)
+		return err
+	}
+
+	// Без извлекателя (VIDEO_ENABLED=false или нет ffmpeg) видео не обрабатываются
+	assert.ErrorIs(t, extract(), ErrVideoUnsupported)
+
+	service.SetVideo(&fakeFrameExtractor{duration: 10 * time.Minute, frames: 1}, VideoOptions{FPS: 1, MaxDuration: time.Minute, MaxSize: 1 << 20})
+	assert.ErrorIs(t, extract(), ErrVideoTooLong)
+
+	service.SetVideo(&fakeFrameExtractor{duration: time.Second, frames: 1}, VideoOptions{FPS: 1, MaxDuration: time.Minute, MaxSize: 64})
+	assert.ErrorIs(t, extract(), ErrVideoTooLarge)
+
+	service.SetVideo(&fakeFrameExtractor{duration: time.Second}, VideoOptions{FPS: 1, MaxDuration: time.Minute, MaxSize: 1 << 20})
+	assert.ErrorIs(t, extract(), ErrVideoNoFrames)
+
+	// Ни одного кадра в хранилище не осталось
+	keys, err := backend.List("task-1/")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"task-1/clip.mp4"}, keys)
+}
+
+func TestFFmpegExtractor(t *testing.T) {
+	// Вместо ffmpeg и ffprobe - скрипты, отвечающие как они
+	dir := t.TempDir()
+	ffprobe := filepath.Join(dir, "ffprobe")
+	ffmpeg := filepath.Join(dir, "ffmpeg")
+	require.NoError(t, os.WriteFile(ffprobe, []byte("#!/bin/sh\necho 12.480000\n"), 0755))
+	require.NoError(t, os.WriteFile(ffmpeg, []byte(`#!/bin/sh
+for arg; do out="$arg"; done
+echo "$@" > "$(dirname "$out")/args"
+touch "$(printf "$out" 1)" "$(printf "$out" 2)"
+`), 0755))
+
+	extractor := &FFmpegExtractor{FFmpeg: ffmpeg, FFprobe: ffprobe}
+	require.NoError(t, extractor.Available())
+
+	duration, err := extractor.Duration(context.Background(), "clip.mp4")
+	require.NoError(t, err)
+	assert.Equal(t, 12480*time.Millisecond, duration)
+
+	framesDir := t.TempDir()
+	require.NoError(t, extractor.Extract(context.Background(), "clip.mp4", framesDir, 0.5))
+	args, err := os.ReadFile(filepath.Join(framesDir, "args"))
+	require.NoError(t, err)
+	assert.Contains(t, string(args), "-i clip.mp4 -vf fps=0.5")
+	assert.FileExists(t, filepath.Join(framesDir, "frame_000001.jpg"))
+	assert.FileExists(t, filepath.Join(framesDir, "frame_000002.jpg"))
+
+	// Ошибка утилиты - вместе с ее stderr
+	require.NoError(t, os.WriteFile(ffprobe, []byte("#!/bin/sh\necho 'moov atom not found' >&2\nexit 1\n"), 0755))
+	_, err = extractor.Duration(context.Background(), "broken.mp4")
+	assert.ErrorContains(t, err, "moov atom not found")
+
+	assert.Error(t, (&FFmpegExtractor{FFmpeg: filepath.Join(dir, "missing"), FFprobe: ffprobe}).Available())
+}
+
+func TestCheckImage(t *testing.T) {
+	var photo bytes.Buffer
+	require.NoError(t, png.Encode(&photo, image.NewRGBA(image.Rect(0, 0, 10, 10))))
+
+	assert.NoError(t, CheckImage(bytes.NewReader(photo.Bytes()), "photo.png"))
+	assert.ErrorIs(t, CheckImage(bytes.NewReader(nil), "empty.jpg"), ErrEmptyFile)
+	assert.ErrorIs(t, CheckImage(bytes.NewReader(photo.Bytes()[:20]), "cut.png"), ErrCorruptImage)
+	assert.ErrorIs(t, CheckImage(strings.NewReader("\xFF\xD8\xFF"), "cut.jpg"), ErrCorruptImage)
+	assert.ErrorIs(t, CheckImage(strings.NewReader("not an image"), "notes.jpg"), ErrCorruptImage)
+
+	// HEIC и видео проверяют конвертер и ffmpeg
+	assert.NoError(t, CheckImage(strings.NewReader("\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic"), "IMG_1.HEIC"))
+	assert.NoError(t, CheckImage(strings.NewReader("\x00\x00\x00\x20ftypisom\x00\x00\x02\x00isomiso2avc1mp41"), "clip.mp4"))
+}
+
+func TestServiceValidateImage(t *testing.T) {
+	backend, err := NewLocalBackend(t.TempDir(), "/uploads")
+	require.NoError(t, err)
+	service, err := NewService(backend, t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, backend.Save("task-1/empty.jpg", bytes.NewReader(nil), 0))
+
+	assert.ErrorIs(t, service.ValidateImage("task-1/empty.jpg"), ErrEmptyFile)
+	assert.Error(t, service.ValidateImage("task-1/missing.jpg"))
+}
@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/png" // Регистрируем PNG декодер для image.Decode
+	"path"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// thumbnailsPrefix - префикс ключей миниатюр в хранилище
+const thumbnailsPrefix = "thumbnails"
+
+// GenerateThumbnail создает миниатюру изображения srcKey в формате OutputFormat,
+// вписанную в квадрат size x size с сохранением пропорций
+// Возвращает ключ миниатюры ("thumbnails/task_id/filename.jpg", ".webp" или ".avif")
+func (s *Service) GenerateThumbnail(srcKey string, size int) (string, error) {
+	if size <= 0 {
+		return "", fmt.Errorf("неверный размер миниатюры: %d", size)
+	}
+
+	src, err := s.Open(srcKey)
+	if err != nil {
+		return "", fmt.Errorf("не удалось открыть %s: %w", srcKey, err)
+	}
+	defer src.Close()
+
+	img, _, err := image.Decode(src)
+	if err != nil {
+		return "", fmt.Errorf("не удалось декодировать %s: %w", srcKey, err)
+	}
+
+	data, format, err := s.encodeImage(resizeToFit(img, size), 85)
+	if err != nil {
+		return "", fmt.Errorf("ошибка кодирования миниатюры: %w", err)
+	}
+
+	dstKey := ThumbnailKey(srcKey, format)
+	if err := s.backend.Save(dstKey, bytes.NewReader(data), int64(len(data))); err != nil {
+		return "", err
+	}
+
+	return dstKey, nil
+}
+
+// ThumbnailKey возвращает ключ миниатюры в формате format для исходного ключа
+func ThumbnailKey(srcKey string, format ImageFormat) string {
+	key := cleanKey(srcKey)
+	name := strings.TrimSuffix(key, path.Ext(key)) + format.Ext
+	return path.Join(thumbnailsPrefix, name)
+}
+
+// resizeToFit уменьшает изображение так, чтобы большая сторона была равна size
+// Изображения меньше size не увеличиваются
+func resizeToFit(img image.Image, size int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	if width <= size && height <= size {
+		return img
+	}
+
+	newWidth, newHeight := size, size
+	if width > height {
+		newHeight = max(1, height*size/width)
+	} else {
+		newWidth = max(1, width*size/height)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, newWidth, newHeight))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Over, nil)
+	return dst
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"path"
+)
+
+// ErrEmptyFile - файл загрузки пустой (0 байт)
+var ErrEmptyFile = errors.New("пустой файл")
+
+// ErrCorruptImage - заголовок изображения не читается: файл обрезан, поврежден
+// или это вовсе не изображение. Такие файлы не отправляются в Python
+var ErrCorruptImage = errors.New("файл поврежден или не является изображением")
+
+// ValidateImage проверяет, что файл key не пустой и читается как изображение
+// (см. CheckImage). Выполняется сразу после сохранения, до конвертации и Python
+func (s *Service) ValidateImage(key string) error {
+	src, err := s.Open(key)
+	if err != nil {
+		return fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	return CheckImage(src, path.Base(key))
+}
+
+// CheckImage возвращает ErrEmptyFile для пустого файла и ErrCorruptImage, если
+// image.DecodeConfig не читает заголовок (пиксели не декодируются). HEIC/HEIF и видео
+// Go так не читает - их проверяют конвертер и ffmpeg, здесь они не отклоняются
+func CheckImage(r io.Reader, name string) error {
+	header := make([]byte, 512)
+	n, err := io.ReadFull(r, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return fmt.Errorf("не удалось прочитать %s: %w", name, err)
+	}
+	header = header[:n]
+
+	if n == 0 {
+		return fmt.Errorf("%w: %s", ErrEmptyFile, name)
+	}
+	if IsHEIF(header) || VideoType(header) != "" {
+		return nil
+	}
+
+	if _, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(header), r)); err != nil {
+		return fmt.Errorf("%w: %s (%v)", ErrCorruptImage, name, err)
+	}
+	return nil
+}
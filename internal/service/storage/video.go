@@ -0,0 +1,266 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ошибки обработки видео (оборачиваются вместе с именем файла)
+var (
+	ErrVideoUnsupported = errors.New("видео не поддерживается: обработка видео выключена (VIDEO_ENABLED) или не найден ffmpeg")
+	ErrVideoTooLarge    = errors.New("видео слишком большое")
+	ErrVideoTooLong     = errors.New("видео слишком длинное")
+	ErrVideoNoFrames    = errors.New("в видео нет кадров")
+)
+
+// videoTypes - форматы видео, распознаваемые http.DetectContentType
+var videoTypes = map[string]bool{
+	"video/mp4":  true,
+	"video/webm": true,
+	"video/avi":  true,
+}
+
+// videoBrands - major brand контейнера ISOBMFF (ftyp) у MP4 и QuickTime,
+// которые http.DetectContentType не распознает (без "mp4" в совместимых brand)
+var videoBrands = map[string]string{
+	"isom": "video/mp4", "iso2": "video/mp4", "iso4": "video/mp4", "iso5": "video/mp4",
+	"iso6": "video/mp4", "avc1": "video/mp4", "mp41": "video/mp4", "mp42": "video/mp4",
+	"M4V ": "video/mp4", "dash": "video/mp4",
+	"qt  ": "video/quicktime",
+}
+
+// VideoType определяет по заголовку файла, что это видео, и возвращает его MIME тип
+// ("" - не видео). HEIC/HEIF - тоже контейнер ISOBMFF, но это фото
+func VideoType(header []byte) string {
+	if IsHEIF(header) {
+		return ""
+	}
+	if len(header) >= 12 && string(header[4:8]) == "ftyp" {
+		if contentType, ok := videoBrands[string(header[8:12])]; ok {
+			return contentType
+		}
+	}
+	if contentType := http.DetectContentType(header); videoTypes[contentType] {
+		return contentType
+	}
+	return ""
+}
+
+// VideoOptions - ограничения и частота извлечения кадров видео
+type VideoOptions struct {
+	FPS         float64       // Кадров в секунду видео
+	MaxDuration time.Duration // Видео длиннее отклоняются
+	MaxSize     int64         // Видео больше (в байтах) отклоняются
+}
+
+// VideoFrame - кадр видео, сохраненный в хранилище как JPEG
+type VideoFrame struct {
+	Key    string        // Ключ кадра ("task_id/clip_frame_000001.jpg")
+	Offset time.Duration // Время кадра от начала видео
+}
+
+// FrameExtractor извлекает кадры из видеофайла на диске
+type FrameExtractor interface {
+	// Duration возвращает длительность видео
+	Duration(ctx context.Context, videoPath string) (time.Duration, error)
+	// Extract пишет в dir кадры с частотой fps как JPEG; имена по порядку кадров
+	// (например frame_000001.jpg), первый кадр - начало видео
+	Extract(ctx context.Context, videoPath, dir string, fps float64) error
+}
+
+// SetVideo включает обработку видео: кадры извлекает extractor (nil - видео отклоняются)
+func (s *Service) SetVideo(extractor FrameExtractor, opts VideoOptions) {
+	s.frames = extractor
+	s.video = opts
+}
+
+// frameKeyPattern - ключи кадров видео ("<имя видео>_frame_000001.jpg")
+var frameKeyPattern = regexp.MustCompile(`_frame_\d{6}\.jpg$`)
+
+// IsVideoFrame сообщает, что файл - кадр, извлеченный из видео задачи
+// Кадры не входят в список файлов задачи: повторная обработка извлекает их из видео заново
+func IsVideoFrame(key string) bool {
+	return frameKeyPattern.MatchString(key)
+}
+
+// frameKey возвращает ключ n-го кадра видео ("task_id/clip.mp4" → "task_id/clip_frame_000001.jpg")
+func frameKey(videoKey string, n int) string {
+	return fmt.Sprintf("%s_frame_%06d.jpg", strings.TrimSuffix(videoKey, path.Ext(videoKey)), n)
+}
+
+// ExtractFrames сохраняет кадры видео key (с частотой VideoOptions.FPS) рядом с ним
+// как JPEG, которые может прочитать InsightFace. Возвращает кадры и video = true;
+// остальные файлы не меняются (nil, false). Само видео остается в хранилище
+func (s *Service) ExtractFrames(ctx context.Context, key string) ([]VideoFrame, bool, error) {
+	src, err := s.backend.Open(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("не удалось открыть %s: %w", key, err)
+	}
+	defer src.Close()
+
+	reader := bufio.NewReader(src)
+	header, _ := reader.Peek(512)
+	if VideoType(header) == "" {
+		return nil, false, nil
+	}
+
+	name := path.Base(key)
+	if s.frames == nil {
+		return nil, true, fmt.Errorf("%w: %s", ErrVideoUnsupported, name)
+	}
+
+	size, err := s.backend.Size(key)
+	if err != nil {
+		return nil, true, fmt.Errorf("не удалось получить размер %s: %w", key, err)
+	}
+	if s.video.MaxSize > 0 && size > s.video.MaxSize {
+		return nil, true, fmt.Errorf("%w: %s (%d МБ, допустимо %d МБ)", ErrVideoTooLarge, name, size>>20, s.video.MaxSize>>20)
+	}
+
+	// ffmpeg читает файл с диска - хранилище может быть S3
+	dir, err := os.MkdirTemp("", "video-*")
+	if err != nil {
+		return nil, true, fmt.Errorf("не удалось создать временную папку: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	videoPath := filepath.Join(dir, "video"+path.Ext(key))
+	if err := writeTempFile(videoPath, reader); err != nil {
+		return nil, true, err
+	}
+
+	duration, err := s.frames.Duration(ctx, videoPath)
+	if err != nil {
+		return nil, true, fmt.Errorf("%w: %s: %v", ErrDecode, name, err)
+	}
+	if s.video.MaxDuration > 0 && duration > s.video.MaxDuration {
+		return nil, true, fmt.Errorf("%w: %s (%v, допустимо %v)", ErrVideoTooLong, name, duration.Round(time.Second), s.video.MaxDuration)
+	}
+
+	framesDir := filepath.Join(dir, "frames")
+	if err := os.Mkdir(framesDir, 0755); err != nil {
+		return nil, true, fmt.Errorf("не удалось создать временную папку: %w", err)
+	}
+	if err := s.frames.Extract(ctx, videoPath, framesDir, s.video.FPS); err != nil {
+		return nil, true, fmt.Errorf("%w: %s: %v", ErrDecode, name, err)
+	}
+
+	entries, err := os.ReadDir(framesDir)
+	if err != nil {
+		return nil, true, fmt.Errorf("не удалось прочитать кадры %s: %w", name, err)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	if len(entries) == 0 {
+		return nil, true, fmt.Errorf("%w: %s", ErrVideoNoFrames, name)
+	}
+
+	frames := make([]VideoFrame, len(entries))
+	for i, entry := range entries {
+		frames[i] = VideoFrame{
+			Key:    frameKey(key, i+1),
+			Offset: time.Duration(float64(i) / s.video.FPS * float64(time.Second)),
+		}
+		if err := s.saveLocalFile(frames[i].Key, filepath.Join(framesDir, entry.Name())); err != nil {
+			return nil, true, err
+		}
+	}
+
+	return frames, true, nil
+}
+
+// writeTempFile записывает r во временный файл filePath
+func writeTempFile(filePath string, r io.Reader) error {
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("не удалось создать временный файл: %w", err)
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		dst.Close()
+		return fmt.Errorf("не удалось записать временный файл: %w", err)
+	}
+	return dst.Close()
+}
+
+// saveLocalFile сохраняет файл с диска в хранилище под ключом key
+func (s *Service) saveLocalFile(key, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return s.backend.Save(key, file, info.Size())
+}
+
+// FFmpegExtractor извлекает кадры утилитами ffmpeg и ffprobe
+type FFmpegExtractor struct {
+	FFmpeg  string // Путь к ffmpeg (или имя в PATH)
+	FFprobe string // Путь к ffprobe (или имя в PATH)
+}
+
+// Available проверяет, что ffmpeg и ffprobe найдены
+func (e *FFmpegExtractor) Available() error {
+	for _, tool := range []string{e.FFmpeg, e.FFprobe} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Duration читает длительность видео из контейнера (ffprobe)
+func (e *FFmpegExtractor) Duration(ctx context.Context, videoPath string) (time.Duration, error) {
+	out, err := runTool(ctx, e.FFprobe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", videoPath)
+	if err != nil {
+		return 0, err
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("неизвестная длительность видео: %q", strings.TrimSpace(string(out)))
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Extract пишет кадры с частотой fps в dir как frame_000001.jpg, frame_000002.jpg, ...
+func (e *FFmpegExtractor) Extract(ctx context.Context, videoPath, dir string, fps float64) error {
+	_, err := runTool(ctx, e.FFmpeg, "-v", "error", "-nostdin", "-i", videoPath,
+		"-vf", "fps="+strconv.FormatFloat(fps, 'f', -1, 64), "-q:v", "2",
+		filepath.Join(dir, "frame_%06d.jpg"))
+	return err
+}
+
+// runTool запускает утилиту и возвращает ее stdout; в ошибке - stderr утилиты
+func runTool(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %w: %s", filepath.Base(name), err, msg)
+		}
+		return nil, fmt.Errorf("%s: %w", filepath.Base(name), err)
+	}
+	return out, nil
+}
@@ -0,0 +1,45 @@
+//go:build cgo && webp
+
+package storage
+
+/*
+#cgo LDFLAGS: -lwebp
+#include <stdlib.h>
+#include <webp/encode.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"image"
+	"io"
+	"unsafe"
+)
+
+// Кодировщик WebP на libwebp: сборка с -tags webp (нужен libwebp-dev)
+func init() {
+	imageEncoders[FormatWebP] = encodeWebP
+}
+
+// encodeWebP кодирует изображение в WebP с потерями
+func encodeWebP(w io.Writer, img image.Image, quality int) error {
+	pixels := toNRGBA(img)
+	bounds := pixels.Bounds()
+	if bounds.Empty() {
+		return errors.New("пустое изображение")
+	}
+
+	var output *C.uint8_t
+	size := C.WebPEncodeRGBA(
+		(*C.uint8_t)(unsafe.Pointer(&pixels.Pix[0])),
+		C.int(bounds.Dx()), C.int(bounds.Dy()), C.int(pixels.Stride),
+		C.float(quality), &output,
+	)
+	if size == 0 {
+		return errors.New("libwebp не смогла закодировать изображение")
+	}
+	defer C.WebPFree(unsafe.Pointer(output))
+
+	_, err := w.Write(C.GoBytes(unsafe.Pointer(output), C.int(size)))
+	return err
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+)
+
+// ErrCallbacksDisabled - список разрешенных хостов пуст
+var ErrCallbacksDisabled = errors.New("callback_url не поддерживается: не задан WEBHOOK_ALLOWED_HOSTS")
+
+// Notifier отправляет HTTP уведомления о завершении задач
+type Notifier struct {
+	client       *http.Client
+	allowedHosts map[string]bool
+	maxRetries   int
+	baseDelay    time.Duration // Задержка перед первым повтором, далее удваивается
+}
+
+// NewNotifier создает отправщик уведомлений
+func NewNotifier(cfg config.WebhooksConfig) *Notifier {
+	allowed := make(map[string]bool, len(cfg.AllowedHosts))
+	for _, host := range cfg.AllowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	return &Notifier{
+		client: &http.Client{
+			Timeout: cfg.Timeout,
+			// Не следуем редиректам: иначе разрешенный хост мог бы
+			// перенаправить запрос во внутреннюю сеть
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		allowedHosts: allowed,
+		maxRetries:   cfg.MaxRetries,
+		baseDelay:    time.Second,
+	}
+}
+
+// ValidateURL проверяет схему (http/https) и что хост есть в списке разрешенных
+func (n *Notifier) ValidateURL(rawURL string) error {
+	if len(n.allowedHosts) == 0 {
+		return ErrCallbacksDisabled
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("неверный callback_url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("callback_url должен использовать http или https")
+	}
+
+	if u.Hostname() == "" {
+		return fmt.Errorf("callback_url должен содержать хост")
+	}
+
+	if !n.allowedHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("хост %s не разрешен для callback_url", u.Hostname())
+	}
+
+	return nil
+}
+
+// Notify отправляет payload POST запросом на callbackURL
+// При ошибке сети или ответе не 2xx повторяет с экспоненциальной задержкой
+func (n *Notifier) Notify(callbackURL string, payload models.TaskWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	delay := n.baseDelay
+	for attempt := 0; ; attempt++ {
+		err = n.send(callbackURL, body)
+		if err == nil {
+			log.Printf("📨 Webhook задачи %s доставлен: %s", payload.TaskID, callbackURL)
+			return nil
+		}
+
+		if attempt >= n.maxRetries {
+			break
+		}
+
+		log.Printf("⚠️  Webhook задачи %s: попытка %d не удалась: %v (повтор через %v)",
+			payload.TaskID, attempt+1, err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	log.Printf("❌ Webhook задачи %s не доставлен: %v", payload.TaskID, err)
+	return err
+}
+
+// send выполняет одну попытку доставки
+func (n *Notifier) send(callbackURL string, body []byte) error {
+	resp, err := n.client.Post(callbackURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("ответ %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"face-recognition/internal/config"
+	"face-recognition/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestNotifier(maxRetries int, hosts ...string) *Notifier {
+	n := NewNotifier(config.WebhooksConfig{
+		AllowedHosts: hosts,
+		Timeout:      time.Second,
+		MaxRetries:   maxRetries,
+	})
+	n.baseDelay = time.Millisecond
+	return n
+}
+
+func TestValidateURL(t *testing.T) {
+	n := newTestNotifier(0, "hooks.example.com")
+
+	assert.NoError(t, n.ValidateURL("https://hooks.example.com/done"))
+	assert.NoError(t, n.ValidateURL("http://HOOKS.example.com:8080/done"))
+
+	for _, rawURL := range []string{
+		"ftp://hooks.example.com/done",
+		"file:///etc/passwd",
+		"https:///no-host",
+		"https://169.254.169.254/latest/meta-data",
+		"https://hooks.example.com.evil.com/done",
+		"::not a url",
+	} {
+		assert.Error(t, n.ValidateURL(rawURL), rawURL)
+	}
+}
+
+func TestValidateURLWithoutAllowlist(t *testing.T) {
+	n := newTestNotifier(0)
+	assert.ErrorIs(t, n.ValidateURL("https://hooks.example.com/done"), ErrCallbacksDisabled)
+}
+
+func TestNotifyRetriesUntilSuccess(t *testing.T) {
+	var calls int32
+	var received models.TaskWebhookPayload
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(3, "127.0.0.1")
+	err := n.Notify(server.URL, models.TaskWebhookPayload{
+		TaskID:     "task-1",
+		Status:     models.TaskStatusCompleted,
+		TotalFaces: 5,
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, "task-1", received.TaskID)
+	assert.Equal(t, 5, received.TotalFaces)
+}
+
+func TestNotifyGivesUp(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	n := newTestNotifier(2, "127.0.0.1")
+	err := n.Notify(server.URL, models.TaskWebhookPayload{TaskID: "task-1"})
+
+	assert.Error(t, err)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls)) // первая попытка + 2 повтора
+}
+
+func TestNotifyDoesNotFollowRedirects(t *testing.T) {
+	var internalCalls int32
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&internalCalls, 1)
+	}))
+	defer internal.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL, http.StatusTemporaryRedirect)
+	}))
+	defer redirector.Close()
+
+	n := newTestNotifier(0, "127.0.0.1")
+	assert.Error(t, n.Notify(redirector.URL, models.TaskWebhookPayload{TaskID: "task-1"}))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&internalCalls))
+}
@@ -0,0 +1,3 @@
+-- Параметры детекции, с которыми запускалась задача
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS min_size INTEGER DEFAULT 30;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS det_thresh FLOAT DEFAULT 0.5;
@@ -0,0 +1,2 @@
+-- Миниатюры аннотированных фото
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS thumbnail_image VARCHAR(500) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+-- URL для уведомления о завершении задачи
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS callback_url VARCHAR(2048) NOT NULL DEFAULT '';
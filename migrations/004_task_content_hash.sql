@@ -0,0 +1,3 @@
+-- Хэш загруженных файлов для идемпотентной повторной загрузки
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS content_hash VARCHAR(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_tasks_content_hash ON tasks(content_hash);
@@ -0,0 +1,8 @@
+-- Связь лица с задачей, в которой оно найдено (нужна для повторной обработки)
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS task_id VARCHAR(36) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_faces_task_id ON faces(task_id);
+
+-- Заполняем task_id для существующих лиц: ID задачи - UUID в пути оригинала
+UPDATE faces
+SET task_id = COALESCE(substring(original_image from '([0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12})'), '')
+WHERE task_id = '';
@@ -0,0 +1,4 @@
+-- Нечеткий поиск по имени (similarity, <->) и ускорение ILIKE
+-- pg_trgm - trusted расширение (PostgreSQL 13+), владелец базы может его включить
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_persons_name_trgm ON persons USING GIN (name gin_trgm_ops);
@@ -0,0 +1,6 @@
+-- Прогресс обработки задачи (0-100) и текущий этап
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS progress INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS stage VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Уже завершенные задачи считаем выполненными полностью
+UPDATE tasks SET progress = 100 WHERE status = 'completed' AND progress = 0;
@@ -0,0 +1,2 @@
+-- Soft-delete людей: удаленные скрываются, но их можно восстановить
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
@@ -0,0 +1,8 @@
+-- Результат обработки каждого загруженного файла задачи
+CREATE TABLE IF NOT EXISTS task_files (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    file_name VARCHAR(500) NOT NULL,
+    faces_count INTEGER NOT NULL DEFAULT 0,
+    error TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (task_id, file_name)
+);
@@ -0,0 +1,13 @@
+-- Метки людей (staff, vip, ...); имена хранятся в нижнем регистре
+CREATE TABLE IF NOT EXISTS tags (
+    id SERIAL PRIMARY KEY,
+    name VARCHAR(50) NOT NULL UNIQUE
+);
+
+-- Связь многие-ко-многим людей и меток
+CREATE TABLE IF NOT EXISTS person_tags (
+    person_id INTEGER NOT NULL REFERENCES persons(id) ON DELETE CASCADE,
+    tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+    PRIMARY KEY (person_id, tag_id)
+);
+CREATE INDEX IF NOT EXISTS idx_person_tags_tag_id ON person_tags(tag_id);
@@ -0,0 +1,3 @@
+-- Порог уверенности детекции и число отброшенных по нему лиц
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS min_confidence FLOAT NOT NULL DEFAULT 0;
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS rejected_faces INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+-- Для загрузки по URL в file_name пишется адрес, который не удалось скачать (до 2048 символов)
+ALTER TABLE task_files ALTER COLUMN file_name TYPE TEXT;
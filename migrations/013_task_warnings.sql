@@ -0,0 +1,2 @@
+-- Предупреждения о несогласованном ответе Python (лица без метаданных или embedding)
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS warnings TEXT[] NOT NULL DEFAULT '{}';
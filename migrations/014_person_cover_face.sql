@@ -0,0 +1,3 @@
+-- Лицо-обложка человека; NULL - берется лицо с наибольшей уверенностью
+-- При удалении лица обложка сбрасывается
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS cover_face_id INTEGER REFERENCES faces(id) ON DELETE SET NULL;
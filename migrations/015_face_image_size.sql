@@ -0,0 +1,4 @@
+-- Размер оригинального фото - чтобы UI пересчитывал bbox в относительные координаты
+-- У лиц, сохраненных раньше, размер неизвестен (NULL)
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS image_width INTEGER;
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS image_height INTEGER;
@@ -0,0 +1,3 @@
+-- Начало обработки задачи - длительность считается без ожидания в очереди
+-- У задач, обработанных раньше, время неизвестно (NULL): длительность от created_at
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS processing_started_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- Модель, которой посчитан embedding лица (например insightface/buffalo_l)
+-- Нужна, чтобы после смены модели пересчитывать только устаревшие embedding
+-- У лиц, сохраненных раньше, модель неизвестна (пустая строка)
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS embedding_model VARCHAR(100) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Время съемки фото из EXIF DateTimeOriginal (detected_at - время обработки)
+-- Общее для всех лиц одного фото; NULL - EXIF нет или дата не разбирается
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS captured_at TIMESTAMP;
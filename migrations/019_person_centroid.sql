@@ -0,0 +1,39 @@
+-- Центроид embedding лиц человека: среднее нормированных embedding, приведенное
+-- к единичной длине (JSON, как faces.embedding). NULL - нет лиц с embedding
+-- Пересчитывается сервером при добавлении, удалении и пересчете лиц
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS centroid BYTEA;
+
+-- Заполнение для уже сохраненных людей (то же вычисление, что embedding.Centroid)
+WITH vectors AS (
+    SELECT f.id, f.person_id, e.ord, e.x::float8 AS x
+    FROM faces f,
+         jsonb_array_elements_text(convert_from(f.embedding, 'UTF8')::jsonb) WITH ORDINALITY AS e(x, ord)
+    WHERE f.embedding IS NOT NULL
+), norms AS (
+    SELECT id, sqrt(sum(x * x)) AS norm FROM vectors GROUP BY id
+), uniform AS (
+    -- Embedding разной размерности не усредняются - центроид остается NULL
+    SELECT person_id
+    FROM (SELECT person_id, id, count(*) AS dim FROM vectors GROUP BY person_id, id) d
+    GROUP BY person_id
+    HAVING count(DISTINCT dim) = 1
+), sums AS (
+    SELECT v.person_id, v.ord, sum(v.x / n.norm) AS x
+    FROM vectors v
+    JOIN norms n ON n.id = v.id
+    JOIN uniform u ON u.person_id = v.person_id
+    WHERE n.norm > 0
+    GROUP BY v.person_id, v.ord
+), lengths AS (
+    SELECT person_id, sqrt(sum(x * x)) AS len FROM sums GROUP BY person_id
+)
+UPDATE persons p
+SET centroid = c.centroid
+FROM (
+    SELECT s.person_id, convert_to(jsonb_agg(s.x / l.len ORDER BY s.ord)::text, 'UTF8') AS centroid
+    FROM sums s
+    JOIN lengths l ON l.person_id = s.person_id
+    WHERE l.len > 0
+    GROUP BY s.person_id
+) c
+WHERE c.person_id = p.id AND p.centroid IS NULL;
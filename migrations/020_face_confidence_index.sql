@@ -0,0 +1,2 @@
+-- Индекс для GET /api/faces: выборка лиц по диапазону уверенности детекции
+CREATE INDEX IF NOT EXISTS idx_faces_confidence ON faces(confidence);
@@ -0,0 +1,16 @@
+-- Журнал изменений людей (append-only): добавление и удаление лиц, переименование
+-- Пишется в той же транзакции, что и само изменение. Внешнего ключа на persons нет:
+-- журнал остается и после удаления человека
+CREATE TABLE IF NOT EXISTS person_audit (
+    id BIGSERIAL PRIMARY KEY,
+    person_id INTEGER NOT NULL,
+    event VARCHAR(50) NOT NULL,    -- faces_added, faces_removed, renamed
+    actor VARCHAR(100) NOT NULL,   -- admin, anonymous, system
+    details JSONB NOT NULL DEFAULT '{}',
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_person_audit_person_id ON person_audit(person_id, id);
+
+-- Записи журнала не изменяются и не удаляются
+CREATE OR REPLACE RULE person_audit_no_update AS ON UPDATE TO person_audit DO INSTEAD NOTHING;
+CREATE OR REPLACE RULE person_audit_no_delete AS ON DELETE TO person_audit DO INSTEAD NOTHING;
@@ -0,0 +1,2 @@
+-- Лица, не сохраненные из-за лимита лиц человека (PERSON_MAX_FACES)
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS overflow_faces INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Оценка резкости лица в [0, 1) (дисперсия лапласиана вырезки, считается в Go)
+-- NULL - не посчитана (лица, сохраненные до появления колонки)
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS quality FLOAT;
+
+-- Индекс для фильтра min_quality в GET /api/faces
+CREATE INDEX IF NOT EXISTS idx_faces_quality ON faces(quality);
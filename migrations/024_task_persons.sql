@@ -0,0 +1,19 @@
+-- Люди, в которых задача сохранила лица: созданные ею (created) и дополненные
+-- (человек создан раньше другой задачей). Человек может относиться к нескольким задачам
+CREATE TABLE IF NOT EXISTS task_persons (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    person_id INTEGER NOT NULL REFERENCES persons(id) ON DELETE CASCADE,
+    created BOOLEAN NOT NULL DEFAULT FALSE,
+    PRIMARY KEY (task_id, person_id)
+);
+CREATE INDEX IF NOT EXISTS idx_task_persons_person_id ON task_persons(person_id);
+
+-- Связи существующих задач - по их лицам; создателем считается задача
+-- самого раннего лица человека
+INSERT INTO task_persons (task_id, person_id, created)
+SELECT f.task_id, f.person_id,
+       f.task_id = (SELECT first.task_id FROM faces first WHERE first.person_id = f.person_id ORDER BY first.id LIMIT 1)
+FROM faces f
+JOIN tasks t ON t.id = f.task_id
+GROUP BY f.task_id, f.person_id
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- Постраничный список людей по курсору (created_at, id), новые первыми
+CREATE INDEX IF NOT EXISTS idx_persons_created_at_id ON persons(created_at, id);
@@ -0,0 +1,3 @@
+-- Лица из видео: время кадра от начала видео в секундах
+-- NULL - лицо найдено на фото (или сохранено до появления колонки)
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS frame_time FLOAT;
@@ -0,0 +1,2 @@
+-- Лица, не сохраненные как повторная детекция того же лица (DETECTION_MAX_OVERLAP)
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS merged_faces INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+-- Исходные имена загруженных файлов: с STORAGE_FILE_NAMING=uuid/hash файлы
+-- сохраняются под другим именем, а показывается имя от клиента
+CREATE TABLE IF NOT EXISTS task_file_names (
+    task_id VARCHAR(36) NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+    file_name TEXT NOT NULL,
+    original_filename TEXT NOT NULL,
+    PRIMARY KEY (task_id, file_name)
+);
+
+-- '' - имя от клиента совпадает с именем в хранилище
+ALTER TABLE faces ADD COLUMN IF NOT EXISTS original_filename TEXT NOT NULL DEFAULT '';
+ALTER TABLE task_files ADD COLUMN IF NOT EXISTS original_filename TEXT NOT NULL DEFAULT '';
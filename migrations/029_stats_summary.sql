@@ -0,0 +1,97 @@
+-- Счетчики статистики одной строкой: GetStats читает их вместо COUNT(*) по большим таблицам
+-- Обновляются триггерами при вставке и удалении людей, лиц и задач; расхождения
+-- (TRUNCATE, правки в обход триггеров) исправляет ReconcileStats
+CREATE TABLE IF NOT EXISTS stats_summary (
+    id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id), -- Строка всегда одна
+    total_persons INTEGER NOT NULL DEFAULT 0, -- Люди без deleted_at
+    total_faces INTEGER NOT NULL DEFAULT 0,   -- Лица людей без deleted_at
+    total_tasks INTEGER NOT NULL DEFAULT 0,
+    reconciled_at TIMESTAMP                   -- Последняя сверка с таблицами (NULL - не было)
+);
+
+INSERT INTO stats_summary (id, total_persons, total_faces, total_tasks, reconciled_at)
+SELECT TRUE,
+       (SELECT COUNT(*) FROM persons WHERE deleted_at IS NULL),
+       (SELECT COUNT(*) FROM faces f JOIN persons p ON p.id = f.person_id WHERE p.deleted_at IS NULL),
+       (SELECT COUNT(*) FROM tasks),
+       NOW()
+ON CONFLICT (id) DO NOTHING;
+
+-- Люди: вставка, мягкое удаление/восстановление (вместе с их лицами) и удаление
+-- Удаление обрабатывается BEFORE DELETE: лица человека еще не удалены каскадом,
+-- а каскадное удаление лиц уже не найдет человека и счетчик не тронет
+CREATE OR REPLACE FUNCTION stats_summary_persons()
+RETURNS TRIGGER AS $$
+DECLARE
+    person_faces INTEGER;
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        IF NEW.deleted_at IS NULL THEN
+            UPDATE stats_summary SET total_persons = total_persons + 1;
+        END IF;
+        RETURN NEW;
+    END IF;
+
+    IF TG_OP = 'DELETE' THEN
+        IF OLD.deleted_at IS NULL THEN
+            SELECT COUNT(*) INTO person_faces FROM faces WHERE person_id = OLD.id;
+            UPDATE stats_summary SET total_persons = total_persons - 1, total_faces = total_faces - person_faces;
+        END IF;
+        RETURN OLD;
+    END IF;
+
+    IF (OLD.deleted_at IS NULL) <> (NEW.deleted_at IS NULL) THEN
+        SELECT COUNT(*) INTO person_faces FROM faces WHERE person_id = NEW.id;
+        IF NEW.deleted_at IS NULL THEN
+            UPDATE stats_summary SET total_persons = total_persons + 1, total_faces = total_faces + person_faces;
+        ELSE
+            UPDATE stats_summary SET total_persons = total_persons - 1, total_faces = total_faces - person_faces;
+        END IF;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+-- Лица: считаются только лица людей без deleted_at (перенос к другому человеку - тоже)
+CREATE OR REPLACE FUNCTION stats_summary_faces()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE')
+        AND EXISTS (SELECT 1 FROM persons WHERE id = OLD.person_id AND deleted_at IS NULL) THEN
+        UPDATE stats_summary SET total_faces = total_faces - 1;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE')
+        AND EXISTS (SELECT 1 FROM persons WHERE id = NEW.person_id AND deleted_at IS NULL) THEN
+        UPDATE stats_summary SET total_faces = total_faces + 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION stats_summary_tasks()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE stats_summary SET total_tasks = total_tasks + 1;
+    ELSE
+        UPDATE stats_summary SET total_tasks = total_tasks - 1;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS stats_summary_persons_change ON persons;
+CREATE TRIGGER stats_summary_persons_change AFTER INSERT OR UPDATE OF deleted_at ON persons
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_persons();
+
+DROP TRIGGER IF EXISTS stats_summary_persons_delete ON persons;
+CREATE TRIGGER stats_summary_persons_delete BEFORE DELETE ON persons
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_persons();
+
+DROP TRIGGER IF EXISTS stats_summary_faces_change ON faces;
+CREATE TRIGGER stats_summary_faces_change AFTER INSERT OR DELETE OR UPDATE OF person_id ON faces
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_faces();
+
+DROP TRIGGER IF EXISTS stats_summary_tasks_change ON tasks;
+CREATE TRIGGER stats_summary_tasks_change AFTER INSERT OR DELETE ON tasks
+    FOR EACH ROW EXECUTE FUNCTION stats_summary_tasks();
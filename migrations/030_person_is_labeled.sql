@@ -0,0 +1,10 @@
+-- is_labeled - человеку дали имя: FALSE у новых людей с именем кластера (person_N),
+-- TRUE после переименования. По нему GET /api/persons?unlabeled=true находит людей,
+-- которых еще надо подписать
+ALTER TABLE persons ADD COLUMN IF NOT EXISTS is_labeled BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Уже существующие люди: подписаны все, чье имя не похоже на имя кластера
+UPDATE persons SET is_labeled = TRUE WHERE NOT is_labeled AND name !~ '^person_[0-9]+$';
+
+CREATE INDEX IF NOT EXISTS idx_persons_unlabeled ON persons(created_at, id)
+    WHERE NOT is_labeled AND deleted_at IS NULL;
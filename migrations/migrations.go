@@ -0,0 +1,153 @@
+// Package migrations содержит SQL миграции схемы БД, встроенные в бинарник,
+// и применяет их при старте сервера (RUN_MIGRATIONS=true)
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// lockID - ключ pg_advisory_lock: несколько экземпляров сервера, запущенных
+// одновременно, применяют миграции по очереди
+const lockID = 7242341
+
+// Migration - одна миграция: файл NNN_name.sql
+type Migration struct {
+	Version int
+	Name    string // Имя файла без .sql
+	SQL     string
+}
+
+// List возвращает встроенные миграции по возрастанию номера
+func List() ([]Migration, error) {
+	entries, err := files.ReadDir(".")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(entries))
+	seen := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("имя миграции %s должно начинаться с номера (NNN_name.sql)", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("у миграций %s и %s одинаковый номер %d", other, name, version)
+		}
+		seen[version] = name
+
+		data, err := files.ReadFile(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Apply применяет еще не примененные миграции по возрастанию номера
+// Каждая миграция выполняется в своей транзакции вместе с записью в schema_migrations,
+// поэтому упавшая миграция не оставляет схему наполовину измененной
+// Возвращает имена примененных миграций (пусто - схема актуальна)
+func Apply(ctx context.Context, db *sql.DB) ([]string, error) {
+	migrations, err := List()
+	if err != nil {
+		return nil, err
+	}
+
+	// Блокировка сессионная - все запросы идут через одно соединение
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return nil, fmt.Errorf("не удалось заблокировать миграции: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	if _, err := conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`); err != nil {
+		return nil, fmt.Errorf("не удалось создать schema_migrations: %w", err)
+	}
+
+	done, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, migration := range migrations {
+		if done[migration.Version] {
+			continue
+		}
+		if err := apply(ctx, conn, migration); err != nil {
+			return applied, fmt.Errorf("миграция %s: %w", migration.Name, err)
+		}
+		applied = append(applied, migration.Name)
+	}
+
+	return applied, nil
+}
+
+// appliedVersions возвращает номера уже примененных миграций
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	done := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		done[version] = true
+	}
+	return done, rows.Err()
+}
+
+// apply выполняет одну миграцию и записывает ее номер в той же транзакции
+func apply(ctx context.Context, conn *sql.Conn, migration Migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Без аргументов lib/pq выполняет файл целиком, со всеми выражениями
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name) VALUES ($1, $2)",
+		migration.Version, migration.Name,
+	); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
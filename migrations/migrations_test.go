@@ -0,0 +1,233 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	_ "github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListOrdersByVersion(t *testing.T) {
+	migrations, err := List()
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+
+	assert.Equal(t, 0, migrations[0].Version)
+	assert.Equal(t, "000_base_schema", migrations[0].Name)
+	for i := 1; i < len(migrations); i++ {
+		assert.Less(t, migrations[i-1].Version, migrations[i].Version)
+		assert.NotEmpty(t, migrations[i].SQL)
+	}
+}
+
+// expectPrepare ожидает блокировку, создание schema_migrations и чтение примененных версий
+func expectPrepare(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WithArgs(lockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, version := range applied {
+		rows.AddRow(version)
+	}
+	mock.ExpectQuery(`SELECT version FROM schema_migrations`).WillReturnRows(rows)
+}
+
+// expectMigration ожидает применение одной миграции в транзакции
+func expectMigration(mock sqlmock.Sqlmock, migration Migration) {
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migration.SQL)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).
+		WithArgs(migration.Version, migration.Name).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(`SELECT pg_advisory_unlock`).WithArgs(lockID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestApplyFreshDatabase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := List()
+	require.NoError(t, err)
+
+	expectPrepare(mock)
+	names := make([]string, 0, len(migrations))
+	for _, migration := range migrations {
+		expectMigration(mock, migration)
+		names = append(names, migration.Name)
+	}
+	expectUnlock(mock)
+
+	applied, err := Apply(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, names, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplySkipsAppliedMigrations(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := List()
+	require.NoError(t, err)
+	last := migrations[len(migrations)-1]
+
+	done := make([]int, 0, len(migrations)-1)
+	for _, migration := range migrations[:len(migrations)-1] {
+		done = append(done, migration.Version)
+	}
+
+	expectPrepare(mock, done...)
+	expectMigration(mock, last)
+	expectUnlock(mock)
+
+	applied, err := Apply(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, []string{last.Name}, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Все применены - повторный запуск ничего не делает
+	expectPrepare(mock, append(done, last.Version)...)
+	expectUnlock(mock)
+
+	applied, err = Apply(context.Background(), db)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestApplyStopsOnFailedMigration(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := List()
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(migrations), 2)
+
+	expectPrepare(mock)
+	expectMigration(mock, migrations[0])
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migrations[1].SQL)).WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	applied, err := Apply(context.Background(), db)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), migrations[1].Name)
+	assert.Equal(t, []string{migrations[0].Name}, applied)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// TestApplyPostgres применяет миграции к настоящей пустой базе дважды
+// Запускается, если задан MIGRATIONS_TEST_DSN (база будет изменена)
+func TestApplyPostgres(t *testing.T) {
+	dsn := os.Getenv("MIGRATIONS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MIGRATIONS_TEST_DSN не задан")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	migrations, err := List()
+	require.NoError(t, err)
+
+	applied, err := Apply(context.Background(), db)
+	require.NoError(t, err)
+	assert.Len(t, applied, len(migrations))
+
+	applied, err = Apply(context.Background(), db)
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+}
+
+// TestStatsSummaryTriggersPostgres проверяет, что триггеры держат stats_summary равным
+// подсчету по таблицам при вставке, мягком и полном удалении людей, лиц и задач
+// Запускается, если задан MIGRATIONS_TEST_DSN (база будет изменена)
+func TestStatsSummaryTriggersPostgres(t *testing.T) {
+	dsn := os.Getenv("MIGRATIONS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("MIGRATIONS_TEST_DSN не задан")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = Apply(context.Background(), db)
+	require.NoError(t, err)
+
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		_, err := db.Exec(query, args...)
+		require.NoError(t, err)
+	}
+	insertID := func(query string, args ...interface{}) int {
+		t.Helper()
+		var id int
+		require.NoError(t, db.QueryRow(query, args...).Scan(&id))
+		return id
+	}
+	// assertInSync сравнивает счетчики с подсчетом по таблицам
+	assertInSync := func(step string) {
+		t.Helper()
+		var summary, actual [3]int
+		require.NoError(t, db.QueryRow(
+			"SELECT total_persons, total_faces, total_tasks FROM stats_summary",
+		).Scan(&summary[0], &summary[1], &summary[2]))
+		require.NoError(t, db.QueryRow(`
+			SELECT (SELECT COUNT(*) FROM persons WHERE deleted_at IS NULL),
+			       (SELECT COUNT(*) FROM faces f JOIN persons p ON p.id = f.person_id WHERE p.deleted_at IS NULL),
+			       (SELECT COUNT(*) FROM tasks)
+		`).Scan(&actual[0], &actual[1], &actual[2]))
+		assert.Equal(t, actual, summary, step)
+	}
+
+	assertInSync("после миграций")
+
+	exec("INSERT INTO tasks (id, status) VALUES ('stats-summary-test', 'completed')")
+	alice := insertID("INSERT INTO persons (name) VALUES ('stats_alice') RETURNING id")
+	bob := insertID("INSERT INTO persons (name) VALUES ('stats_bob') RETURNING id")
+	face := insertID("INSERT INTO faces (person_id, task_id, original_image) VALUES ($1, 'stats-summary-test', 'a.jpg') RETURNING id", alice)
+	exec("INSERT INTO faces (person_id, task_id, original_image) VALUES ($1, 'stats-summary-test', 'b.jpg')", alice)
+	exec("INSERT INTO faces (person_id, task_id, original_image) VALUES ($1, 'stats-summary-test', 'c.jpg')", bob)
+	assertInSync("вставка")
+
+	exec("UPDATE persons SET deleted_at = NOW() WHERE id = $1", alice)
+	assertInSync("мягкое удаление")
+	exec("UPDATE persons SET deleted_at = NULL WHERE id = $1", alice)
+	assertInSync("восстановление")
+
+	exec("UPDATE faces SET person_id = $1 WHERE id = $2", bob, face)
+	assertInSync("перенос лица")
+	exec("UPDATE persons SET deleted_at = NOW() WHERE id = $1", bob)
+	exec("UPDATE faces SET person_id = $1 WHERE id = $2", alice, face)
+	assertInSync("перенос лица от удаленного человека")
+
+	exec("DELETE FROM faces WHERE id = $1", face)
+	assertInSync("удаление лица")
+	exec("DELETE FROM persons WHERE id = $1", alice)
+	assertInSync("удаление человека с лицами")
+	exec("DELETE FROM persons WHERE id = $1", bob)
+	assertInSync("удаление мягко удаленного человека")
+
+	exec("DELETE FROM tasks WHERE id = 'stats-summary-test'")
+	assertInSync("удаление задачи")
+}
@@ -0,0 +1,169 @@
+package embedding
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// DefaultMatchThreshold - порог сходства, выше которого лица считаются одним человеком
+// Совпадает с порогом Python /compare
+const DefaultMatchThreshold = 0.6
+
+// DefaultDimension - размерность embedding моделей InsightFace buffalo_* (ArcFace)
+// Другие модели отдают другую размерность (например, 128) - см. EMBEDDING_DIM
+const DefaultDimension = 512
+
+// Ошибки сравнения embedding
+var (
+	ErrEmpty             = errors.New("embedding пустой")
+	ErrDimensionMismatch = errors.New("размерности embedding не совпадают")
+)
+
+// CheckDimension проверяет, что embedding v имеет ожидаемую размерность dim
+// (dim <= 0 - размерность не проверяется). Ошибка оборачивает ErrEmpty или ErrDimensionMismatch
+func CheckDimension(v []float64, dim int) error {
+	if dim <= 0 {
+		return nil
+	}
+	if len(v) == 0 {
+		return ErrEmpty
+	}
+	if len(v) != dim {
+		return fmt.Errorf("%w: ожидается %d, получено %d", ErrDimensionMismatch, dim, len(v))
+	}
+	return nil
+}
+
+// CosineSimilarity вычисляет косинусное сходство двух векторов (от -1 до 1)
+// Для нулевого вектора сходство не определено - возвращается 0, как в sklearn
+func CosineSimilarity(a, b []float64) (float64, error) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, ErrEmpty
+	}
+	if len(a) != len(b) {
+		return 0, fmt.Errorf("%w: %d и %d", ErrDimensionMismatch, len(a), len(b))
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0, nil
+	}
+
+	similarity := dot / (math.Sqrt(normA) * math.Sqrt(normB))
+
+	// Погрешность округления может вывести значение за [-1, 1]
+	return math.Max(-1, math.Min(1, similarity)), nil
+}
+
+// Centroid возвращает среднее направление векторов: среднее нормированных векторов,
+// приведенное к единичной длине. Косинусное сходство двух центроидов - их скалярное
+// произведение. Нулевые векторы пропускаются; если ненулевых нет - ErrEmpty
+func Centroid(vectors [][]float64) ([]float64, error) {
+	var sum []float64
+	for _, v := range vectors {
+		if len(v) == 0 {
+			continue
+		}
+		if sum == nil {
+			sum = make([]float64, len(v))
+		}
+		if len(v) != len(sum) {
+			return nil, fmt.Errorf("%w: %d и %d", ErrDimensionMismatch, len(sum), len(v))
+		}
+
+		norm := math.Sqrt(dot(v, v))
+		if norm == 0 {
+			continue
+		}
+		for i := range v {
+			sum[i] += v[i] / norm
+		}
+	}
+
+	norm := math.Sqrt(dot(sum, sum))
+	if norm == 0 {
+		return nil, ErrEmpty
+	}
+	for i := range sum {
+		sum[i] /= norm
+	}
+	return sum, nil
+}
+
+// Dot - скалярное произведение векторов одной размерности
+// Для единичных векторов (например, из Centroid) это их косинусное сходство
+func Dot(a, b []float64) float64 {
+	return dot(a, b)
+}
+
+func dot(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// Comparer сравнивает два embedding и решает, один ли это человек
+// threshold - порог совпадения для этого сравнения (nil - порог самого Comparer)
+type Comparer interface {
+	Compare(a, b []float64, threshold *float64) (similarity float64, match bool, err error)
+}
+
+// ComparerFunc позволяет использовать функцию как Comparer
+// Например, embedding.ComparerFunc(pythonClient.CompareEmbeddings)
+type ComparerFunc func(a, b []float64, threshold *float64) (float64, bool, error)
+
+// Compare вызывает f(a, b, threshold)
+func (f ComparerFunc) Compare(a, b []float64, threshold *float64) (float64, bool, error) {
+	return f(a, b, threshold)
+}
+
+// WithDimension проверяет размерность обоих embedding (см. CheckDimension) до
+// сравнения через c: embedding другой модели дал бы бессмысленное сходство
+func WithDimension(c Comparer, dim int) Comparer {
+	return ComparerFunc(func(a, b []float64, threshold *float64) (float64, bool, error) {
+		if err := CheckDimension(a, dim); err != nil {
+			return 0, false, err
+		}
+		if err := CheckDimension(b, dim); err != nil {
+			return 0, false, err
+		}
+		return c.Compare(a, b, threshold)
+	})
+}
+
+// Local сравнивает embedding в Go, без запроса к Python
+type Local struct {
+	threshold float64
+}
+
+// NewLocal создает локальный Comparer с порогом совпадения threshold
+func NewLocal(threshold float64) *Local {
+	return &Local{threshold: threshold}
+}
+
+// Threshold возвращает порог совпадения
+func (l *Local) Threshold() float64 {
+	return l.threshold
+}
+
+// Compare считает косинусное сходство; совпадение - строго выше порога (как в Python)
+// Без threshold используется порог из NewLocal
+func (l *Local) Compare(a, b []float64, threshold *float64) (float64, bool, error) {
+	similarity, err := CosineSimilarity(a, b)
+	if err != nil {
+		return 0, false, err
+	}
+	if threshold == nil {
+		threshold = &l.threshold
+	}
+	return similarity, similarity > *threshold, nil
+}
@@ -0,0 +1,198 @@
+package embedding
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"identical", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"scaled", []float64{1, 2, 3}, []float64{2, 4, 6}, 1},
+		{"opposite", []float64{1, 2, 3}, []float64{-1, -2, -3}, -1},
+		{"orthogonal", []float64{1, 0}, []float64{0, 1}, 0},
+		{"45 degrees", []float64{1, 0}, []float64{1, 1}, 1 / math.Sqrt2},
+		{"known vectors", []float64{1, 2, 3}, []float64{4, 5, 6}, 32 / (math.Sqrt(14) * math.Sqrt(77))},
+		{"zero vector", []float64{0, 0, 0}, []float64{1, 2, 3}, 0},
+		{"both zero", []float64{0, 0}, []float64{0, 0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := CosineSimilarity(tt.a, tt.b)
+			require.NoError(t, err)
+			assert.InDelta(t, tt.want, got, 1e-12)
+		})
+	}
+}
+
+func TestCosineSimilarityErrors(t *testing.T) {
+	_, err := CosineSimilarity([]float64{1, 2}, []float64{1, 2, 3})
+	assert.True(t, errors.Is(err, ErrDimensionMismatch))
+
+	_, err = CosineSimilarity(nil, []float64{1})
+	assert.Equal(t, ErrEmpty, err)
+
+	_, err = CosineSimilarity([]float64{}, []float64{})
+	assert.Equal(t, ErrEmpty, err)
+}
+
+func TestCosineSimilarityStaysInRange(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		a := randomVector(rng, 512)
+		got, err := CosineSimilarity(a, a)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, got, 1.0)
+		assert.InDelta(t, 1.0, got, 1e-9)
+	}
+}
+
+func TestCentroid(t *testing.T) {
+	// Длина векторов не влияет: усредняются направления
+	centroid, err := Centroid([][]float64{{2, 0}, {0, 5}})
+	require.NoError(t, err)
+	assert.InDelta(t, 1/math.Sqrt2, centroid[0], 1e-12)
+	assert.InDelta(t, 1/math.Sqrt2, centroid[1], 1e-12)
+
+	// Нулевые и пустые векторы пропускаются
+	centroid, err = Centroid([][]float64{{0, 0}, nil, {3, 4}})
+	require.NoError(t, err)
+	assert.InDeltaSlice(t, []float64{0.6, 0.8}, centroid, 1e-12)
+
+	// Скалярное произведение центроидов - их косинусное сходство
+	a, err := Centroid([][]float64{{1, 2, 3}})
+	require.NoError(t, err)
+	b, err := Centroid([][]float64{{4, 5, 6}})
+	require.NoError(t, err)
+	want, _ := CosineSimilarity([]float64{1, 2, 3}, []float64{4, 5, 6})
+	assert.InDelta(t, want, Dot(a, b), 1e-12)
+}
+
+func TestCentroidErrors(t *testing.T) {
+	_, err := Centroid(nil)
+	assert.Equal(t, ErrEmpty, err)
+
+	_, err = Centroid([][]float64{{0, 0}})
+	assert.Equal(t, ErrEmpty, err)
+
+	// Противоположные векторы взаимно гасятся
+	_, err = Centroid([][]float64{{1, 0}, {-1, 0}})
+	assert.Equal(t, ErrEmpty, err)
+
+	_, err = Centroid([][]float64{{1, 0}, {1, 0, 0}})
+	assert.True(t, errors.Is(err, ErrDimensionMismatch))
+}
+
+func TestLocalCompare(t *testing.T) {
+	local := NewLocal(DefaultMatchThreshold)
+	assert.Equal(t, DefaultMatchThreshold, local.Threshold())
+
+	similarity, match, err := local.Compare([]float64{1, 2, 3}, []float64{1, 2, 3}, nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 1.0, similarity, 1e-12)
+	assert.True(t, match)
+
+	_, match, err = local.Compare([]float64{1, 0}, []float64{0, 1}, nil)
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	// Совпадение строго выше порога
+	_, match, err = NewLocal(1).Compare([]float64{1, 0}, []float64{1, 0}, nil)
+	require.NoError(t, err)
+	assert.False(t, match)
+
+	_, _, err = local.Compare([]float64{1}, []float64{1, 2}, nil)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch))
+}
+
+func TestLocalCompareThreshold(t *testing.T) {
+	local := NewLocal(DefaultMatchThreshold)
+	a, b := []float64{1, 0}, []float64{0.8, 0.6} // Сходство 0.8
+
+	// Порог сравнения заменяет порог NewLocal с обеих сторон от сходства
+	for threshold, want := range map[float64]bool{0.79: true, 0.7999: true, 0.8: false, 0.81: false} {
+		similarity, match, err := local.Compare(a, b, &threshold)
+		require.NoError(t, err)
+		assert.InDelta(t, 0.8, similarity, 1e-12)
+		assert.Equal(t, want, match, threshold)
+	}
+
+	_, match, err := local.Compare(a, b, nil)
+	require.NoError(t, err)
+	assert.True(t, match)
+	assert.Equal(t, DefaultMatchThreshold, local.Threshold())
+}
+
+func TestComparerFunc(t *testing.T) {
+	var received *float64
+	var comparer Comparer = ComparerFunc(func(a, b []float64, threshold *float64) (float64, bool, error) {
+		received = threshold
+		return 0.9, true, nil
+	})
+
+	threshold := 0.7
+	similarity, match, err := comparer.Compare(nil, nil, &threshold)
+	assert.Equal(t, &threshold, received)
+	require.NoError(t, err)
+	assert.Equal(t, 0.9, similarity)
+	assert.True(t, match)
+}
+
+func TestCheckDimension(t *testing.T) {
+	assert.NoError(t, CheckDimension([]float64{1, 2, 3}, 3))
+	assert.NoError(t, CheckDimension([]float64{1, 2}, 0), "dim <= 0 - без проверки")
+	assert.True(t, errors.Is(CheckDimension(nil, 3), ErrEmpty))
+
+	err := CheckDimension(make([]float64, 128), 512)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch))
+	assert.Contains(t, err.Error(), "ожидается 512, получено 128")
+}
+
+func TestWithDimension(t *testing.T) {
+	calls := 0
+	comparer := WithDimension(ComparerFunc(func(a, b []float64, threshold *float64) (float64, bool, error) {
+		calls++
+		return 1, true, nil
+	}), 3)
+
+	_, _, err := comparer.Compare([]float64{1, 2, 3}, []float64{1, 2}, nil)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch))
+	_, _, err = comparer.Compare([]float64{1, 2}, []float64{1, 2}, nil)
+	assert.True(t, errors.Is(err, ErrDimensionMismatch), "одинаковые, но не EMBEDDING_DIM")
+	assert.Zero(t, calls, "несовпадающие embedding не сравниваются")
+
+	similarity, match, err := comparer.Compare([]float64{1, 2, 3}, []float64{1, 2, 3}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, similarity)
+	assert.True(t, match)
+	assert.Equal(t, 1, calls)
+}
+
+func randomVector(rng *rand.Rand, dim int) []float64 {
+	v := make([]float64, dim)
+	for i := range v {
+		v[i] = rng.NormFloat64()
+	}
+	return v
+}
+
+// InsightFace возвращает embedding размерности 512
+func BenchmarkCosineSimilarity512(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	x, y := randomVector(rng, 512), randomVector(rng, 512)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		CosineSimilarity(x, y)
+	}
+}
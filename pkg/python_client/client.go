@@ -2,43 +2,133 @@ package python_client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"face-recognition/internal/models"
+	"face-recognition/internal/tracing"
+	"face-recognition/pkg/embedding"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// Client для взаимодействия с Python сервером
+// FileOpener открывает изображение по пути (или ключу хранилища) для отправки в Python
+type FileOpener func(path string) (io.ReadCloser, error)
+
+// DefaultCompareBatchSize - сколько кандидатов отправляется в одном запросе /compare/batch
+// 512-мерный embedding в JSON занимает ~10 КБ, так что запрос не больше ~5 МБ
+const DefaultCompareBatchSize = 500
+
+// Match - кандидат, похожий на probe embedding
+type Match struct {
+	ID         int     `json:"id"`
+	Similarity float64 `json:"similarity"`
+}
+
+// Таймауты запросов по умолчанию. Быстрые запросы (/health, /compare) не должны
+// ждать столько же, сколько обработка задачи InsightFace
+const (
+	DefaultRequestTimeout = 10 * time.Second // /health, /compare, /compare/batch
+	DefaultProcessTimeout = 10 * time.Minute // /process
+)
+
+// Client для взаимодействия с Python серверами
+// Запросы распределяются между несколькими серверами (см. pick)
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
+	endpoints        []*endpoint
+	next             atomic.Uint64 // Начало кругового обхода для следующего запроса
+	httpClient       *http.Client
+	openFile         FileOpener
+	compareBatchSize int
+	requestTimeout   time.Duration // Таймаут /health и /compare
+	processTimeout   time.Duration // Таймаут /process
+	embeddingDim     int           // Ожидаемая размерность embedding из /process (0 - не проверяется)
 }
 
-// NewClient создает новый клиент
-func NewClient(baseURL string) *Client {
+// NewClient создает новый клиент для одного или нескольких Python серверов
+// По умолчанию файлы читаются с локального диска (os.Open)
+// У http.Client нет общего таймаута: каждый запрос ограничен контекстом со своим
+// таймаутом (см. SetTimeouts)
+func NewClient(baseURLs ...string) *Client {
 	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Minute, // Увеличен для InsightFace
+		endpoints:  newEndpoints(baseURLs),
+		httpClient: &http.Client{},
+		openFile: func(path string) (io.ReadCloser, error) {
+			return os.Open(path)
 		},
+		compareBatchSize: DefaultCompareBatchSize,
+		requestTimeout:   DefaultRequestTimeout,
+		processTimeout:   DefaultProcessTimeout,
+	}
+}
+
+// SetTimeouts задает таймаут быстрых запросов (/health, /compare) и обработки (/process)
+// Неположительное значение оставляет таймаут по умолчанию
+func (c *Client) SetTimeouts(request, process time.Duration) {
+	if request > 0 {
+		c.requestTimeout = request
+	}
+	if process > 0 {
+		c.processTimeout = process
 	}
 }
 
+// SetEmbeddingDim задает размерность embedding, которую должна возвращать модель Python
+// (EMBEDDING_DIM). По умолчанию и при неположительном значении размерность не проверяется
+func (c *Client) SetEmbeddingDim(dim int) {
+	c.embeddingDim = dim
+}
+
+// SetFileOpener задает способ чтения файлов перед отправкой в Python
+// Сервер передает сюда storage.Service.Open, чтобы ключи хранилища
+// (локальный диск или S3) читались через бэкенд, а не относительно рабочей папки
+func (c *Client) SetFileOpener(opener FileOpener) {
+	c.openFile = opener
+}
+
 // ProcessImages отправляет изображения на полную обработку
 // Python делает: детекцию → embeddings → кластеризацию
-func (c *Client) ProcessImages(imagePaths []string, taskID string, minSize int, detThresh float64) (*models.PythonResponse, error) {
+// Запрос идет с заголовком traceparent из ctx - Python может продолжить trace
+// Запрос ограничен таймаутом обработки и дедлайном ctx (что наступит раньше)
+func (c *Client) ProcessImages(ctx context.Context, imagePaths []string, taskID string, minSize int, detThresh float64) (result *models.PythonResponse, err error) {
+	ctx, cancel := context.WithTimeout(ctx, c.processTimeout)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "python /process",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("task.id", taskID),
+			attribute.Int("images.count", len(imagePaths)),
+		),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Запрос уйдет на наименее загруженный сервер
+	ep, release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	span.SetAttributes(attribute.String("python.endpoint", ep.baseURL))
+
 	// Создаем multipart форму
 	body := &bytes.Buffer{}
 	writer := multipart.NewWriter(body)
 
 	// Добавляем каждое изображение
 	for _, imagePath := range imagePaths {
-		file, err := os.Open(imagePath)
+		file, err := c.openFile(imagePath)
 		if err != nil {
 			return nil, fmt.Errorf("не удалось открыть файл %s: %w", imagePath, err)
 		}
@@ -57,20 +147,24 @@ func (c *Client) ProcessImages(imagePaths []string, taskID string, minSize int,
 	// Добавляем параметры
 	writer.WriteField("task_id", taskID)
 	writer.WriteField("min_size", fmt.Sprintf("%d", minSize))
-	writer.WriteField("det_thresh", fmt.Sprintf("%.2f", detThresh))
+	writer.WriteField("det_thresh", fmt.Sprintf("%g", detThresh))
 
 	if err := writer.Close(); err != nil {
 		return nil, fmt.Errorf("ошибка закрытия writer: %w", err)
 	}
 
 	// Отправляем POST запрос
-	resp, err := c.httpClient.Post(
-		c.baseURL+"/process",
-		writer.FormDataContentType(),
-		body,
-	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ep.baseURL+"/process", body)
 	if err != nil {
-		return nil, fmt.Errorf("ошибка HTTP запроса: %w", err)
+		return nil, fmt.Errorf("ошибка создания запроса: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.markDown(ep, err)
+		return nil, fmt.Errorf("ошибка HTTP запроса к %s: %w", ep.baseURL, err)
 	}
 	defer resp.Body.Close()
 
@@ -81,39 +175,69 @@ func (c *Client) ProcessImages(imagePaths []string, taskID string, minSize int,
 	}
 
 	// Парсим ответ
-	var result models.PythonResponse
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	var response models.PythonResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return nil, fmt.Errorf("ошибка парсинга ответа: %w", err)
 	}
 
 	// Проверяем успешность обработки
-	if !result.Success {
-		return nil, fmt.Errorf("Python обработка не удалась: %s", result.Error)
+	if !response.Success {
+		return nil, fmt.Errorf("Python обработка не удалась: %s", response.Error)
 	}
 
-	return &result, nil
+	// Embedding другой модели дали бы бессмысленное сходство с уже сохраненными лицами -
+	// такой ответ отклоняется целиком (пустой embedding - лицо без embedding, не ошибка)
+	for faceID, vector := range response.Embeddings {
+		if len(vector) == 0 {
+			continue
+		}
+		if err := embedding.CheckDimension(vector, c.embeddingDim); err != nil {
+			return nil, fmt.Errorf("Python вернул embedding лица %s неверной размерности (проверьте EMBEDDING_DIM): %w", faceID, err)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("faces.count", response.TotalFaces))
+	return &response, nil
 }
 
 // CompareEmbeddings сравнивает два embedding
-func (c *Client) CompareEmbeddings(emb1, emb2 []float64) (float64, bool, error) {
-	requestBody, err := json.Marshal(map[string]interface{}{
+// threshold - порог совпадения для match (nil - порог по умолчанию Python, 0.6)
+func (c *Client) CompareEmbeddings(emb1, emb2 []float64, threshold *float64) (float64, bool, error) {
+	request := map[string]interface{}{
 		"embedding1": emb1,
 		"embedding2": emb2,
-	})
+	}
+	if threshold != nil {
+		request["threshold"] = *threshold
+	}
+
+	requestBody, err := json.Marshal(request)
 	if err != nil {
 		return 0, false, err
 	}
 
-	resp, err := c.httpClient.Post(
-		c.baseURL+"/compare",
-		"application/json",
-		bytes.NewBuffer(requestBody),
-	)
+	ep, release, err := c.acquire()
+	if err != nil {
+		return 0, false, err
+	}
+	defer release()
+
+	// Сравнение быстрое - ограничено коротким таймаутом, а не таймаутом обработки
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	resp, err := c.postJSON(ctx, ep.baseURL+"/compare", requestBody)
 	if err != nil {
+		c.markDown(ep, err)
 		return 0, false, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return 0, false, fmt.Errorf("Python вернул ошибку %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
 	var result struct {
 		Similarity float64 `json:"similarity"`
 		Match      bool    `json:"match"`
@@ -126,17 +250,106 @@ func (c *Client) CompareEmbeddings(emb1, emb2 []float64) (float64, bool, error)
 	return result.Similarity, result.Match, nil
 }
 
-// HealthCheck проверяет доступность Python сервера
-func (c *Client) HealthCheck() error {
-	resp, err := c.httpClient.Get(c.baseURL + "/health")
+// CompareEmbeddingBatch сравнивает probe со всеми candidates (ID лица: embedding)
+// и возвращает кандидатов со сходством строго выше threshold, от самых похожих
+// Кандидаты отправляются в /compare/batch частями по compareBatchSize,
+// поэтому тысячи embedding не собираются в один огромный запрос
+func (c *Client) CompareEmbeddingBatch(probe []float64, candidates map[int][]float64, threshold float64) ([]Match, error) {
+	if len(probe) == 0 {
+		return nil, fmt.Errorf("probe embedding пустой")
+	}
+
+	// Порядок кандидатов фиксирован - одинаковый вход дает одинаковые запросы
+	ids := make([]int, 0, len(candidates))
+	for id := range candidates {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	matches := []Match{}
+	for start := 0; start < len(ids); start += c.compareBatchSize {
+		end := start + c.compareBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		chunk, err := c.compareChunk(probe, ids[start:end], candidates, threshold)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, chunk...)
+	}
+
+	// Python сортирует каждую часть отдельно - общий порядок восстанавливаем здесь
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Similarity != matches[j].Similarity {
+			return matches[i].Similarity > matches[j].Similarity
+		}
+		return matches[i].ID < matches[j].ID
+	})
+
+	return matches, nil
+}
+
+// compareChunk отправляет одну часть кандидатов в /compare/batch
+func (c *Client) compareChunk(probe []float64, ids []int, candidates map[int][]float64, threshold float64) ([]Match, error) {
+	type candidate struct {
+		ID        int       `json:"id"`
+		Embedding []float64 `json:"embedding"`
+	}
+
+	chunk := make([]candidate, len(ids))
+	for i, id := range ids {
+		chunk[i] = candidate{ID: id, Embedding: candidates[id]}
+	}
+
+	requestBody, err := json.Marshal(map[string]interface{}{
+		"probe":      probe,
+		"candidates": chunk,
+		"threshold":  threshold,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ep, release, err := c.acquire()
 	if err != nil {
-		return fmt.Errorf("Python сервер недоступен: %w", err)
+		return nil, err
+	}
+	defer release()
+
+	// Сравнение быстрое - ограничено коротким таймаутом, а не таймаутом обработки
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	resp, err := c.postJSON(ctx, ep.baseURL+"/compare/batch", requestBody)
+	if err != nil {
+		c.markDown(ep, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("Python сервер вернул статус %d", resp.StatusCode)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Python вернул ошибку %d: %s", resp.StatusCode, string(bodyBytes))
 	}
 
-	return nil
+	var result struct {
+		Matches []Match `json:"matches"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("ошибка парсинга ответа: %w", err)
+	}
+
+	return result.Matches, nil
+}
+
+// postJSON отправляет JSON запрос на Python сервер в пределах ctx
+func (c *Client) postJSON(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.httpClient.Do(req)
 }
@@ -0,0 +1,209 @@
+package python_client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"face-recognition/pkg/embedding"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type batchRequest struct {
+	Probe      []float64 `json:"probe"`
+	Candidates []struct {
+		ID        int       `json:"id"`
+		Embedding []float64 `json:"embedding"`
+	} `json:"candidates"`
+	Threshold float64 `json:"threshold"`
+}
+
+// newBatchServer имитирует Python /compare/batch: ранжирует кандидатов части
+// по косинусному сходству и запоминает размеры полученных частей
+func newBatchServer(t *testing.T, chunks *[]int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/compare/batch", r.URL.Path)
+
+		var req batchRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		*chunks = append(*chunks, len(req.Candidates))
+
+		matches := []Match{}
+		for _, candidate := range req.Candidates {
+			similarity, err := embedding.CosineSimilarity(req.Probe, candidate.Embedding)
+			require.NoError(t, err)
+			if similarity > req.Threshold {
+				matches = append(matches, Match{ID: candidate.ID, Similarity: similarity})
+			}
+		}
+		sort.Slice(matches, func(i, j int) bool { return matches[i].Similarity > matches[j].Similarity })
+
+		json.NewEncoder(w).Encode(map[string]interface{}{"matches": matches})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newCompareServer имитирует Python /compare: match - сходство выше присланного порога
+// (без порога - 0.6, как в Python); запоминает, был ли порог в запросе
+func newCompareServer(t *testing.T, sent *map[string]interface{}) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/compare", r.URL.Path)
+
+		var req struct {
+			Embedding1 []float64 `json:"embedding1"`
+			Embedding2 []float64 `json:"embedding2"`
+			Threshold  *float64  `json:"threshold"`
+		}
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal(data, &req))
+		require.NoError(t, json.Unmarshal(data, sent))
+
+		threshold := 0.6
+		if req.Threshold != nil {
+			threshold = *req.Threshold
+		}
+		similarity, err := embedding.CosineSimilarity(req.Embedding1, req.Embedding2)
+		require.NoError(t, err)
+		json.NewEncoder(w).Encode(map[string]interface{}{"similarity": similarity, "match": similarity > threshold})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCompareEmbeddingsThreshold(t *testing.T) {
+	var sent map[string]interface{}
+	client := NewClient(newCompareServer(t, &sent).URL)
+	a, b := []float64{1, 0}, []float64{0.8, 0.6} // Сходство 0.8
+
+	// Без порога он не отправляется - решает Python
+	similarity, match, err := client.CompareEmbeddings(a, b, nil)
+	require.NoError(t, err)
+	assert.InDelta(t, 0.8, similarity, 1e-9)
+	assert.True(t, match)
+	assert.NotContains(t, sent, "threshold")
+
+	// Пороги чуть ниже и чуть выше сходства
+	for threshold, want := range map[float64]bool{0.79: true, 0.81: false} {
+		_, match, err := client.CompareEmbeddings(a, b, &threshold)
+		require.NoError(t, err)
+		assert.Equal(t, want, match, threshold)
+		assert.Equal(t, threshold, sent["threshold"])
+	}
+}
+
+func TestCompareEmbeddingBatch(t *testing.T) {
+	var chunks []int
+	client := NewClient(newBatchServer(t, &chunks).URL)
+
+	candidates := map[int][]float64{
+		1: {1, 0},
+		2: {0, 1}, // Ортогонален - ниже порога
+		3: {0.9, 0.1},
+		4: {1, 0.5},
+		5: {-1, 0},
+	}
+
+	matches, err := client.CompareEmbeddingBatch([]float64{1, 0}, candidates, 0.6)
+	require.NoError(t, err)
+
+	// Все кандидаты ушли одним запросом, ответ - от самых похожих
+	assert.Equal(t, []int{5}, chunks)
+	require.Len(t, matches, 3)
+	assert.Equal(t, []int{1, 3, 4}, []int{matches[0].ID, matches[1].ID, matches[2].ID})
+	assert.InDelta(t, 1.0, matches[0].Similarity, 1e-9)
+	assert.Greater(t, matches[1].Similarity, matches[2].Similarity)
+}
+
+func TestCompareEmbeddingBatchChunks(t *testing.T) {
+	var chunks []int
+	client := NewClient(newBatchServer(t, &chunks).URL)
+	client.compareBatchSize = 2
+
+	// Лучшие совпадения разбросаны по разным частям - общий порядок восстанавливается
+	candidates := map[int][]float64{
+		1: {0.7, 0.3},
+		2: {1, 0},
+		3: {0.8, 0.2},
+		4: {0.95, 0.05},
+		5: {0.6, 0.4},
+	}
+
+	matches, err := client.CompareEmbeddingBatch([]float64{1, 0}, candidates, 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, []int{2, 2, 1}, chunks)
+	ids := make([]int, len(matches))
+	for i, match := range matches {
+		ids[i] = match.ID
+	}
+	assert.Equal(t, []int{2, 4, 3, 1, 5}, ids)
+}
+
+func TestCompareEmbeddingBatchEmpty(t *testing.T) {
+	var chunks []int
+	client := NewClient(newBatchServer(t, &chunks).URL)
+
+	// Без кандидатов запросов нет
+	matches, err := client.CompareEmbeddingBatch([]float64{1, 0}, nil, 0.6)
+	require.NoError(t, err)
+	assert.Empty(t, matches)
+	assert.Empty(t, chunks)
+
+	_, err = client.CompareEmbeddingBatch(nil, map[int][]float64{1: {1, 0}}, 0.6)
+	assert.Error(t, err)
+	assert.Empty(t, chunks)
+}
+
+func TestCompareEmbeddingBatchError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Размерности embedding не совпадают"})
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).CompareEmbeddingBatch([]float64{1, 0}, map[int][]float64{1: {1, 0, 0}}, 0.6)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "400")
+	assert.Contains(t, err.Error(), "Размерности embedding не совпадают")
+}
+
+func TestProcessImagesEmbeddingDimension(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"success":    true,
+			"embeddings": map[string][]float64{"face_1": make([]float64, 128), "face_2": {}},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.SetFileOpener(func(path string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("img")), nil
+	})
+
+	// Без EMBEDDING_DIM размерность не проверяется
+	_, err := client.ProcessImages(context.Background(), []string{"a.jpg"}, "task-1", 30, 0.5)
+	require.NoError(t, err)
+
+	client.SetEmbeddingDim(128)
+	result, err := client.ProcessImages(context.Background(), []string{"a.jpg"}, "task-1", 30, 0.5)
+	require.NoError(t, err, "пустой embedding - лицо без embedding, не ошибка")
+	assert.Len(t, result.Embeddings["face_1"], 128)
+
+	client.SetEmbeddingDim(512)
+	_, err = client.ProcessImages(context.Background(), []string{"a.jpg"}, "task-1", 30, 0.5)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, embedding.ErrDimensionMismatch))
+	assert.Contains(t, err.Error(), "face_1")
+	assert.Contains(t, err.Error(), "ожидается 512, получено 128")
+}
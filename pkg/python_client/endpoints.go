@@ -0,0 +1,161 @@
+package python_client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNoEndpoints - клиент создан без адресов Python серверов
+var ErrNoEndpoints = errors.New("не задан ни один адрес Python сервера")
+
+// endpoint - один Python сервер из списка
+type endpoint struct {
+	baseURL  string
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+}
+
+// newEndpoints создает список серверов; до первой проверки все считаются доступными
+func newEndpoints(baseURLs []string) []*endpoint {
+	endpoints := make([]*endpoint, 0, len(baseURLs))
+	for _, baseURL := range baseURLs {
+		ep := &endpoint{baseURL: strings.TrimRight(baseURL, "/")}
+		ep.healthy.Store(true)
+		endpoints = append(endpoints, ep)
+	}
+	return endpoints
+}
+
+// pick выбирает сервер для запроса: доступный с наименьшим числом запросов в работе,
+// при равенстве - по кругу. Если недоступны все, выбираем среди всех:
+// отметка могла устареть, а отказ без попытки хуже ошибки от сервера
+func (c *Client) pick() *endpoint {
+	start := int(c.next.Add(1)-1) % len(c.endpoints)
+
+	var best *endpoint
+	for _, onlyHealthy := range []bool{true, false} {
+		for i := range c.endpoints {
+			ep := c.endpoints[(start+i)%len(c.endpoints)]
+			if onlyHealthy && !ep.healthy.Load() {
+				continue
+			}
+			if best == nil || ep.inFlight.Load() < best.inFlight.Load() {
+				best = ep
+			}
+		}
+		if best != nil {
+			break
+		}
+	}
+	return best
+}
+
+// acquire выбирает сервер и учитывает запрос в работе; release вызывается по завершении
+func (c *Client) acquire() (ep *endpoint, release func(), err error) {
+	if len(c.endpoints) == 0 {
+		return nil, nil, ErrNoEndpoints
+	}
+	ep = c.pick()
+	ep.inFlight.Add(1)
+	return ep, func() { ep.inFlight.Add(-1) }, nil
+}
+
+// markDown помечает сервер недоступным после сетевой ошибки
+// Вернуть его может только проверка здоровья
+// Таймаут и отмена запроса не значат, что сервер недоступен: медленный
+// InsightFace на большой задаче остается в ротации
+func (c *Client) markDown(ep *endpoint, err error) {
+	if !isConnectionError(err) {
+		return
+	}
+	if ep.healthy.Swap(false) && len(c.endpoints) > 1 {
+		log.Printf("⚠️  Python %s недоступен: %v", ep.baseURL, err)
+	}
+}
+
+// isConnectionError отличает ошибку соединения от таймаута или отмены запроса
+func isConnectionError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return false
+	}
+	return true
+}
+
+// HealthCheck проверяет все Python серверы и обновляет их отметки
+// Ошибка возвращается, только если недоступны все серверы
+func (c *Client) HealthCheck() error {
+	if len(c.endpoints) == 0 {
+		return ErrNoEndpoints
+	}
+
+	var errs []string
+	for _, ep := range c.endpoints {
+		err := c.checkEndpoint(ep)
+		wasHealthy := ep.healthy.Swap(err == nil)
+
+		if err != nil {
+			errs = append(errs, err.Error())
+			if wasHealthy && len(c.endpoints) > 1 {
+				log.Printf("⚠️  Python %s недоступен: %v", ep.baseURL, err)
+			}
+		} else if !wasHealthy && len(c.endpoints) > 1 {
+			log.Printf("✅ Python %s снова доступен", ep.baseURL)
+		}
+	}
+
+	if len(errs) == len(c.endpoints) {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// checkEndpoint запрашивает /health одного сервера
+func (c *Client) checkEndpoint(ep *endpoint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.requestTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ep.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Python сервер %s недоступен: %w", ep.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Python сервер %s вернул статус %d", ep.baseURL, resp.StatusCode)
+	}
+	return nil
+}
+
+// RunHealthChecks периодически проверяет серверы до отмены ctx
+// Запускается в отдельной горутине
+func (c *Client) RunHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.HealthCheck(); err != nil {
+				log.Printf("⚠️  Все Python серверы недоступны: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package python_client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// workerServer имитирует Python воркер: считает запросы /process,
+// /health отвечает 200, пока healthy == true
+type workerServer struct {
+	*httptest.Server
+	processed atomic.Int32
+	healthy   atomic.Bool
+	block     chan struct{} // Если задан, /process ждет его закрытия
+	started   chan struct{}
+}
+
+func newWorkerServer(t *testing.T) *workerServer {
+	worker := &workerServer{}
+	worker.healthy.Store(true)
+	worker.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			if !worker.healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		case "/process":
+			worker.processed.Add(1)
+			if worker.block != nil {
+				worker.started <- struct{}{}
+				<-worker.block
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"success": true})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(worker.Close)
+	return worker
+}
+
+// newTestClient создает клиент для воркеров; файлы берутся из памяти
+func newTestClient(workers ...*workerServer) *Client {
+	urls := make([]string, len(workers))
+	for i, worker := range workers {
+		urls[i] = worker.URL
+	}
+	client := NewClient(urls...)
+	client.SetFileOpener(func(path string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("img")), nil
+	})
+	return client
+}
+
+func process(t *testing.T, client *Client) error {
+	t.Helper()
+	_, err := client.ProcessImages(context.Background(), []string{"task-1/a.jpg"}, "task-1", 30, 0.5)
+	return err
+}
+
+func TestProcessImagesRoundRobin(t *testing.T) {
+	first, second := newWorkerServer(t), newWorkerServer(t)
+	client := newTestClient(first, second)
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, process(t, client))
+	}
+
+	assert.Equal(t, int32(2), first.processed.Load())
+	assert.Equal(t, int32(2), second.processed.Load())
+}
+
+func TestProcessImagesPrefersLeastInFlight(t *testing.T) {
+	busy, free := newWorkerServer(t), newWorkerServer(t)
+	busy.block = make(chan struct{})
+	busy.started = make(chan struct{}, 1)
+	client := newTestClient(busy, free)
+
+	// Первый запрос занимает busy до конца теста
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.NoError(t, process(t, client))
+	}()
+	select {
+	case <-busy.started:
+	case <-time.After(time.Second):
+		t.Fatal("запрос к первому серверу не начался")
+	}
+
+	// Очередь по кругу снова дошла бы до busy, но у него запрос в работе
+	require.NoError(t, process(t, client))
+	require.NoError(t, process(t, client))
+	assert.Equal(t, int32(1), busy.processed.Load())
+	assert.Equal(t, int32(2), free.processed.Load())
+
+	close(busy.block)
+	wg.Wait()
+}
+
+func TestProcessImagesSkipsUnhealthyEndpoint(t *testing.T) {
+	up, down := newWorkerServer(t), newWorkerServer(t)
+	down.healthy.Store(false)
+	client := newTestClient(up, down)
+
+	// Один сервер доступен - это не ошибка
+	require.NoError(t, client.HealthCheck())
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, process(t, client))
+	}
+	assert.Equal(t, int32(3), up.processed.Load())
+	assert.Zero(t, down.processed.Load())
+
+	// Сервер поднялся - следующая проверка возвращает его в работу
+	down.healthy.Store(true)
+	require.NoError(t, client.HealthCheck())
+	require.NoError(t, process(t, client))
+	require.NoError(t, process(t, client))
+	assert.Equal(t, int32(1), down.processed.Load())
+}
+
+func TestProcessImagesMarksFailedEndpointDown(t *testing.T) {
+	alive, dead := newWorkerServer(t), newWorkerServer(t)
+	dead.Close()
+	client := newTestClient(dead, alive)
+
+	// Первый запрос уходит на остановленный сервер и помечает его недоступным
+	assert.Error(t, process(t, client))
+	for i := 0; i < 3; i++ {
+		require.NoError(t, process(t, client))
+	}
+	assert.Equal(t, int32(3), alive.processed.Load())
+}
+
+func TestProcessImagesTimeoutKeepsEndpoint(t *testing.T) {
+	slow := newWorkerServer(t)
+	slow.block = make(chan struct{})
+	slow.started = make(chan struct{}, 2)
+	defer close(slow.block)
+	client := newTestClient(slow)
+
+	// Отмена запроса к медленному, но живому серверу не выводит его из ротации
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-slow.started
+		cancel()
+	}()
+	_, err := client.ProcessImages(ctx, []string{"task-1/a.jpg"}, "task-1", 30, 0.5)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Таймаут обработки - тоже не сетевая ошибка
+	client.SetTimeouts(0, 50*time.Millisecond)
+	_, err = client.ProcessImages(context.Background(), []string{"task-1/a.jpg"}, "task-1", 30, 0.5)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.True(t, client.endpoints[0].healthy.Load())
+}
+
+func TestHealthCheckAllDown(t *testing.T) {
+	first, second := newWorkerServer(t), newWorkerServer(t)
+	first.healthy.Store(false)
+	second.healthy.Store(false)
+	client := newTestClient(first, second)
+
+	err := client.HealthCheck()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), first.URL)
+	assert.Contains(t, err.Error(), second.URL)
+
+	// Отметки могли устареть - запрос все равно отправляется
+	require.NoError(t, process(t, client))
+	assert.Equal(t, int32(1), first.processed.Load()+second.processed.Load())
+}
+
+func TestRunHealthChecksRestoresEndpoint(t *testing.T) {
+	worker := newWorkerServer(t)
+	worker.healthy.Store(false)
+	client := newTestClient(worker)
+	require.Error(t, client.HealthCheck())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.RunHealthChecks(ctx, 10*time.Millisecond)
+
+	worker.healthy.Store(true)
+	assert.Eventually(t, func() bool {
+		return client.endpoints[0].healthy.Load()
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNewClientWithoutEndpoints(t *testing.T) {
+	client := newTestClient()
+
+	assert.ErrorIs(t, client.HealthCheck(), ErrNoEndpoints)
+	assert.ErrorIs(t, process(t, client), ErrNoEndpoints)
+}
+
+// deadlineTransport запоминает, сколько оставалось до дедлайна запроса на каждый путь
+type deadlineTransport struct {
+	mu        sync.Mutex
+	remaining map[string]time.Duration
+}
+
+func (d *deadlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	deadline, ok := req.Context().Deadline()
+	d.mu.Lock()
+	if ok {
+		d.remaining[req.URL.Path] = time.Until(deadline)
+	} else {
+		d.remaining[req.URL.Path] = -1
+	}
+	d.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestRequestsUsePerOperationTimeouts(t *testing.T) {
+	client := newTestClient(newWorkerServer(t))
+	transport := &deadlineTransport{remaining: make(map[string]time.Duration)}
+	client.httpClient.Transport = transport
+	client.SetTimeouts(2*time.Second, time.Hour)
+
+	require.NoError(t, client.HealthCheck())
+	require.NoError(t, process(t, client))
+	client.CompareEmbeddings([]float64{1, 0}, []float64{0, 1}, nil)
+	client.CompareEmbeddingBatch([]float64{1, 0}, map[int][]float64{1: {0, 1}}, 0.5)
+
+	// Быстрые запросы - с коротким дедлайном, обработка - с длинным
+	for _, path := range []string{"/health", "/compare", "/compare/batch"} {
+		remaining := transport.remaining[path]
+		assert.True(t, remaining > 0 && remaining <= 2*time.Second, "%s: %v", path, remaining)
+	}
+	remaining := transport.remaining["/process"]
+	assert.True(t, remaining > 59*time.Minute && remaining <= time.Hour, "/process: %v", remaining)
+
+	// Дедлайн вызывающего короче таймаута обработки - действует он
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := client.ProcessImages(ctx, []string{"task-1/a.jpg"}, "task-1", 30, 0.5)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, transport.remaining["/process"], time.Second)
+}
+
+func TestSetTimeoutsKeepsDefaults(t *testing.T) {
+	client := NewClient("http://python:5000")
+	assert.Zero(t, client.httpClient.Timeout, "общего таймаута нет - только таймауты запросов")
+
+	client.SetTimeouts(0, -time.Second)
+	assert.Equal(t, DefaultRequestTimeout, client.requestTimeout)
+	assert.Equal(t, DefaultProcessTimeout, client.processTimeout)
+
+	client.SetTimeouts(3*time.Second, 0)
+	assert.Equal(t, 3*time.Second, client.requestTimeout)
+	assert.Equal(t, DefaultProcessTimeout, client.processTimeout)
+}
@@ -5,6 +5,7 @@ from insightface.app import FaceAnalysis
 
 class FaceExtractor:
     def __init__(self, model_name='buffalo_l', ctx_id=-1):
+        self.model_name = model_name
         self.app = FaceAnalysis(name=model_name, providers=['CUDAExecutionProvider', 'CPUExecutionProvider'])
         self.app.prepare(ctx_id=ctx_id, det_size=(640, 640))
         print(f"[FaceExtractor] Модель {model_name} загружена.")
@@ -37,7 +38,9 @@ class FaceExtractor:
                 'det_score': float(face.det_score),
                 'embedding': face.normed_embedding,
                 'boxed_image': boxed_img,
-                'original_image_path': image_path
+                'original_image_path': image_path,
+                'image_width': int(img.shape[1]),
+                'image_height': int(img.shape[0])
             }
 
     def extract_faces_from_folder(self, folder_path, min_size=40, det_thresh=0.5):
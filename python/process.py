@@ -18,6 +18,10 @@ print("✅ Модели загружены")
 UPLOAD_FOLDER = '../uploads'  # Относительно python/
 os.makedirs(UPLOAD_FOLDER, exist_ok=True)
 
+# Фото с рамками - в RESULTS_DIR Go сервера (он раздает их по RESULTS_URL, /results)
+RESULTS_FOLDER = os.environ.get('RESULTS_FOLDER', '../results')  # Относительно python/
+os.makedirs(RESULTS_FOLDER, exist_ok=True)
+
 @app.route('/process', methods=['POST'])
 def process_images():
     """
@@ -51,6 +55,8 @@ def process_images():
         # Создаем папку для этой задачи в uploads (где Go раздает статику)
         task_folder = os.path.join(UPLOAD_FOLDER, task_id)
         os.makedirs(task_folder, exist_ok=True)
+        results_folder = os.path.join(RESULTS_FOLDER, task_id)
+        os.makedirs(results_folder, exist_ok=True)
 
         # Сохраняем загруженные изображения
         saved_paths = []
@@ -78,13 +84,13 @@ def process_images():
                 # Генерируем уникальный ID для каждого лица
                 face_id = f"{task_id}_img{len(all_faces)}_face{face_counter}"
 
-                # Сохраняем изображение с bbox В ТУ ЖЕ ПАПКУ что и оригинал
+                # Сохраняем изображение с bbox в папку результатов задачи
                 boxed_image_filename = f"{face_id}_boxed.jpg"
-                boxed_image_path = os.path.join(task_folder, boxed_image_filename)
+                boxed_image_path = os.path.join(results_folder, boxed_image_filename)
                 cv2.imwrite(boxed_image_path, face_data['boxed_image'])
 
-                # Формируем пути относительно uploads/ для Go
-                # Go раздает через /uploads/task_id/file.jpg
+                # Формируем пути относительно uploads/ и results/ для Go
+                # Go раздает их через /uploads/task_id/file.jpg и /results/task_id/file.jpg
                 original_relative = os.path.join(task_id, os.path.basename(image_path))
                 boxed_relative = os.path.join(task_id, boxed_image_filename)
 
@@ -95,7 +101,10 @@ def process_images():
                     'original_image_name': image_name,
                     'boxed_image_path': boxed_relative,        # Относительный путь!
                     'bbox': face_data['bbox'].tolist(),
+                    'kps': face_data['kps'].tolist() if face_data['kps'] is not None else [],
                     'det_score': face_data['det_score'],
+                    'image_width': face_data['image_width'],
+                    'image_height': face_data['image_height'],
                     'embedding': face_data['embedding']
                 }
                 all_faces.append(face_info)
@@ -139,7 +148,10 @@ def process_images():
                 'original_image': face['original_image_path'],
                 'boxed_image': face['boxed_image_path'],
                 'bbox': face['bbox'],
-                'confidence': face['det_score']
+                'confidence': face['det_score'],
+                'landmarks': face['kps'],  # Go выбирает по ним самое фронтальное лицо для обложки
+                'image_width': face['image_width'],  # Размер оригинала - для относительных координат bbox
+                'image_height': face['image_height']
             }
 
         print(f"\n📦 Пример путей для проверки:")
@@ -167,7 +179,8 @@ def process_images():
             'embeddings': embeddings_dict,
             'faces_metadata': faces_metadata,
             'total_faces': total_faces,
-            'unique_persons': unique_persons
+            'unique_persons': unique_persons,
+            'embedding_model': f"insightface/{face_extractor.model_name}"  # Go сохраняет у каждого лица
         })
 
     except Exception as e:
@@ -189,6 +202,7 @@ def health_check():
         'message': 'Python face processor ready',
         'version': '3.0',
         'model': 'InsightFace (buffalo_l)',
+        'embedding_model': f"insightface/{face_extractor.model_name}",
         'clustering': 'DBSCAN',
         'features': ['detection', 'embedding', 'clustering', 'bbox_drawing']
     })
@@ -199,13 +213,14 @@ def compare_faces():
     """
     Сравнение двух embeddings
 
-    Input: {"embedding1": [...], "embedding2": [...]}
-    Output: {"similarity": 0.85, "match": true}
+    Input: {"embedding1": [...], "embedding2": [...], "threshold": 0.6}
+    Output: {"similarity": 0.85, "match": true} - match: сходство > threshold (по умолчанию 0.6)
     """
     try:
         data = request.json
         emb1 = np.array(data.get('embedding1'))
         emb2 = np.array(data.get('embedding2'))
+        threshold = float(data.get('threshold', 0.6))
 
         if emb1 is None or emb2 is None:
             return jsonify({'error': 'Требуются оба embedding'}), 400
@@ -216,13 +231,56 @@ def compare_faces():
 
         return jsonify({
             'similarity': similarity,
-            'match': similarity > 0.6  # Порог можно настроить
+            'match': similarity > threshold
         })
 
     except Exception as e:
         return jsonify({'error': str(e)}), 500
 
 
+@app.route('/compare/batch', methods=['POST'])
+def compare_batch():
+    """
+    Сравнение одного embedding с множеством кандидатов за один запрос
+
+    Input: {"probe": [...], "candidates": [{"id": 1, "embedding": [...]}, ...], "threshold": 0.6}
+    Output: {"matches": [{"id": 1, "similarity": 0.91}, ...]} - сходство > threshold, по убыванию
+    """
+    try:
+        data = request.json or {}
+        probe = np.array(data.get('probe') or [], dtype=np.float64)
+        candidates = data.get('candidates') or []
+        threshold = float(data.get('threshold', 0.6))
+
+        if probe.size == 0:
+            return jsonify({'error': 'Требуется probe embedding'}), 400
+        if not candidates:
+            return jsonify({'matches': []})
+
+        ids = [c['id'] for c in candidates]
+        matrix = np.array([c['embedding'] for c in candidates], dtype=np.float64)
+        if matrix.ndim != 2 or matrix.shape[1] != probe.size:
+            return jsonify({'error': 'Размерности embedding не совпадают'}), 400
+
+        # Косинусное сходство со всеми кандидатами одной операцией
+        # Для нулевого вектора сходство 0, как в sklearn
+        norms = np.linalg.norm(matrix, axis=1) * np.linalg.norm(probe)
+        similarities = np.divide(matrix @ probe, norms, out=np.zeros(len(ids)), where=norms > 0)
+        similarities = np.clip(similarities, -1, 1)
+
+        order = np.argsort(-similarities, kind='stable')
+        matches = [
+            {'id': ids[i], 'similarity': float(similarities[i])}
+            for i in order
+            if similarities[i] > threshold
+        ]
+
+        return jsonify({'matches': matches})
+
+    except Exception as e:
+        return jsonify({'error': str(e)}), 500
+
+
 if __name__ == '__main__':
     print("\n" + "="*70)
     print("🐍 Face Recognition Processor v3.0 (InsightFace)")
@@ -230,6 +288,7 @@ if __name__ == '__main__':
     print("Endpoints:")
     print("  POST /process  - Полная обработка (detection + embedding + clustering)")
     print("  POST /compare  - Сравнение двух embeddings")
+    print("  POST /compare/batch - Сравнение embedding с множеством кандидатов")
     print("  GET  /health   - Проверка статуса")
     print("="*70)
     print("Модель: InsightFace buffalo_l (512-dim embeddings)")